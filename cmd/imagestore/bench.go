@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gordyf/imageencoder/lib/bench"
+)
+
+// runBench implements `imagestore bench`, measuring ingest throughput,
+// retrieval latency and compression on a synthetic screenshot corpus
+func runBench(ctx context.Context, args []string) error {
+	opts := bench.DefaultOptions()
+
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	fs.IntVar(&opts.Corpus.Images, "images", opts.Corpus.Images, "Number of screenshots in the corpus")
+	fs.IntVar(&opts.Corpus.Width, "width", opts.Corpus.Width, "Screenshot width")
+	fs.IntVar(&opts.Corpus.Height, "height", opts.Corpus.Height, "Screenshot height")
+	fs.IntVar(&opts.Corpus.Apps, "apps", opts.Corpus.Apps, "Distinct app layouts in the corpus")
+	fs.Int64Var(&opts.Corpus.Seed, "seed", opts.Corpus.Seed, "Corpus generator seed")
+	fs.StringVar(&opts.Dir, "dir", "", "Directory for scenario databases (default: temporary)")
+	tileSizes := fs.String("tile-sizes", "128,256,512", "Comma-separated tile sizes")
+	levels := fs.String("levels", "default", "Comma-separated compression levels")
+	codecs := fs.String("codecs", "zstd", "Comma-separated codec sets; join codecs in one set with +")
+	format := fs.String("format", "markdown", "Report format: markdown or json")
+	outPath := fs.String("out", "", "Write the report to a file instead of stdout")
+	fs.Parse(args)
+
+	if *format != "markdown" && *format != "json" {
+		return fmt.Errorf("invalid report format: %s", *format)
+	}
+
+	opts.TileSizes = nil
+	for _, field := range splitList(*tileSizes) {
+		tileSize, err := strconv.Atoi(field)
+		if err != nil || tileSize <= 0 {
+			return fmt.Errorf("invalid tile size: %s", field)
+		}
+		opts.TileSizes = append(opts.TileSizes, tileSize)
+	}
+	opts.CompressionLevels = splitList(*levels)
+	opts.CodecSets = nil
+	for _, set := range splitList(*codecs) {
+		opts.CodecSets = append(opts.CodecSets, strings.Split(set, "+"))
+	}
+	opts.Progress = os.Stderr
+
+	report, err := bench.Run(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	var out io.Writer = os.Stdout
+	if *outPath != "" {
+		file, err := os.Create(*outPath)
+		if err != nil {
+			return fmt.Errorf("failed to create report: %w", err)
+		}
+		defer file.Close()
+		out = file
+	}
+
+	if *format == "json" {
+		return report.WriteJSON(out)
+	}
+	return report.WriteMarkdown(out)
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+// Pebble allows one process per database, so a running server advertises
+// where it listens in a file beside the database. CLI commands that find the
+// database locked read it and go through the server's API instead.
+
+// serverInfo is the content of the server file
+type serverInfo struct {
+	URL    string `json:"url,omitempty"`
+	Socket string `json:"socket,omitempty"`
+	PID    int    `json:"pid"`
+}
+
+// serverFile returns the path of the server file for a database
+func serverFile(dbPath string) string {
+	return dbPath + ".server"
+}
+
+// advertiseServer writes the server file for a server listening on a TCP
+// address, a unix socket or both, and returns a function that removes it
+func advertiseServer(dbPath, addr, socket string) (func(), error) {
+	info := serverInfo{PID: os.Getpid()}
+	if addr != "" {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		// Wildcard listeners are reachable on loopback
+		if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+			host = "127.0.0.1"
+		}
+		info.URL = "http://" + net.JoinHostPort(host, port)
+	}
+	if socket != "" {
+		absolute, err := filepath.Abs(socket)
+		if err != nil {
+			return nil, err
+		}
+		info.Socket = absolute
+	}
+
+	data, err := json.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+	path := serverFile(dbPath)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return nil, fmt.Errorf("failed to write server file: %w", err)
+	}
+	return func() { os.Remove(path) }, nil
+}
+
+// advertisedServer returns the URL a server holding a database advertised,
+// preferring its unix socket, or "" if there is no server file. The file
+// may have been left behind by a crashed server, so callers must expect the
+// URL not to answer.
+func advertisedServer(dbPath string) string {
+	data, err := os.ReadFile(serverFile(dbPath))
+	if err != nil {
+		return ""
+	}
+	var info serverInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return ""
+	}
+	if info.Socket != "" {
+		return "unix://" + info.Socket
+	}
+	return info.URL
+}
+
+// listenUnix listens on a unix socket, replacing a stale socket file left
+// by a server that exited without cleaning up. Only the owner and group
+// may connect.
+func listenUnix(path string) (net.Listener, error) {
+	if _, err := os.Stat(path); err == nil {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("another server is listening on %s", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0o660); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+
+	"github.com/gordyf/imageencoder/lib/config"
+	"github.com/gordyf/imageencoder/lib/imagestore"
+	"github.com/gordyf/imageencoder/lib/remotesync"
+)
+
+// command is a CLI subcommand
+type command struct {
+	summary string
+	run     func(ctx context.Context, args []string) error
+}
+
+var commands = map[string]command{
+	"bench":  {"Benchmark the store on a synthetic corpus", runBench},
+	"repair": {"Salvage a damaged database into a fresh one", runRepair},
+	"serve":  {"Run the HTTP server", runServe},
+	"sync":   {"Sync images with a remote instance", runSync},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err := cmd.run(ctx, os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+// usage prints the available commands
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: imagestore <command> [flags] [args]")
+	fmt.Fprintln(os.Stderr, "\nCommands:")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].summary)
+	}
+}
+
+// storeFlags are the flags every command that opens the local store accepts
+type storeFlags struct {
+	configPath string
+	dbPath     string
+}
+
+func (f *storeFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.configPath, "config", "config.json", "Path to configuration file")
+	fs.StringVar(&f.dbPath, "db", "", "Database path (overrides config)")
+}
+
+// openSync opens the local store for a sync. If a running server holds the
+// database, the sync goes through that server's API instead.
+func (f *storeFlags) openSync(ctx context.Context) (remotesync.Store, func() error, error) {
+	storeConfig, err := f.storeConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	store, err := imagestore.NewPebbleImageStore(storeConfig)
+	if err == nil {
+		return store, store.Close, nil
+	}
+	if !errors.Is(err, imagestore.ErrStoreLocked) {
+		return nil, nil, err
+	}
+
+	lockErr := err
+	serverURL := advertisedServer(storeConfig.DatabasePath)
+	if serverURL == "" {
+		return nil, nil, fmt.Errorf("stop the other instance or set open_timeout_seconds to wait for it: %w", lockErr)
+	}
+	remote, err := remotesync.DialRemoteStore(ctx, serverURL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w, and the server at %s did not answer: %v", lockErr, serverURL, err)
+	}
+	fmt.Fprintf(os.Stderr, "Database is in use by the server at %s; going through its API\n", serverURL)
+	return remote, func() error { return nil }, nil
+}
+
+// storeConfig loads the configuration with background jobs disabled, since
+// CLI commands are short-lived
+func (f *storeFlags) storeConfig() (*imagestore.Config, error) {
+	cfg, err := config.LoadConfig(f.configPath)
+	if err != nil {
+		return nil, err
+	}
+	if f.dbPath != "" {
+		cfg.ImageStore.DatabasePath = f.dbPath
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	storeConfig := cfg.ImageStore.StoreConfig()
+	storeConfig.NoBackgroundJobs = true
+	return storeConfig, nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/gordyf/imageencoder/lib/config"
+	"github.com/gordyf/imageencoder/lib/imagestore"
+)
+
+// runRepair implements `imagestore repair <output-path>`, salvaging a
+// damaged database into a fresh one
+func runRepair(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("repair", flag.ExitOnError)
+	configPath := fs.String("config", "config.json", "Path to configuration file")
+	dbPath := fs.String("db", "", "Damaged database path (overrides config)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: imagestore repair [flags] <output-path>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected an output path")
+	}
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	if *dbPath != "" {
+		cfg.ImageStore.DatabasePath = *dbPath
+	}
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	report, err := imagestore.Repair(cfg.ImageStore.StoreConfig(), fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Recovered %d images, %d trashed images, %d tiles and %d import records into %s\n",
+		report.ImagesRecovered, report.TrashRecovered, report.TilesRecovered, report.ImportsRecovered, fs.Arg(0))
+	for _, id := range report.DamagedImages {
+		fmt.Printf("Unrecoverable image: %s\n", id)
+	}
+	for _, tileID := range report.MissingTiles {
+		fmt.Printf("Missing tile: %s\n", tileID)
+	}
+	for _, tileID := range report.CorruptTiles {
+		fmt.Printf("Corrupt tile: %s\n", tileID)
+	}
+	for _, key := range report.UnreadableKeys {
+		fmt.Printf("Unreadable key: %s\n", key)
+	}
+	for _, scanErr := range report.ScanErrors {
+		fmt.Printf("Scan stopped early: %s\n", scanErr)
+	}
+	return nil
+}
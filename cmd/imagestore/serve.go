@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/gordyf/imageencoder/internal/handlers"
+	"github.com/gordyf/imageencoder/lib/config"
+	"github.com/gordyf/imageencoder/lib/imagestore"
+	"github.com/gordyf/imageencoder/lib/pdfimport"
+	"github.com/gordyf/imageencoder/lib/remotesync"
+	"github.com/gordyf/imageencoder/lib/s3backup"
+	"github.com/gordyf/imageencoder/lib/s3tier"
+	"github.com/gordyf/imageencoder/lib/watcher"
+)
+
+// runServe implements `imagestore serve`, running the HTTP API. With an
+// upstream URL the store acts as an edge cache in front of that instance.
+func runServe(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	configPath := fs.String("config", "config.json", "Path to configuration file")
+	dbPath := fs.String("db", "", "Database path (overrides config)")
+	host := fs.String("host", "", "Listen host (overrides config)")
+	port := fs.Int("port", 0, "Listen port (overrides config)")
+	upstreamURL := fs.String("upstream", "", "Instance to fetch missing images from (overrides config)")
+	readOnly := fs.Bool("read-only", false, "Open the database read-only")
+	unixSocket := fs.String("unix-socket", "", "Also listen on this unix socket (overrides config)")
+	fs.Parse(args)
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	if *dbPath != "" {
+		cfg.ImageStore.DatabasePath = *dbPath
+	}
+	if *host != "" {
+		cfg.Server.Host = *host
+	}
+	if *port != 0 {
+		cfg.Server.Port = *port
+	}
+	if *unixSocket != "" {
+		cfg.Server.UnixSocket = *unixSocket
+	}
+	if *upstreamURL != "" {
+		cfg.ImageStore.UpstreamURL = *upstreamURL
+	}
+	if *readOnly {
+		cfg.ImageStore.ReadOnly = true
+	}
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	storeConfig := cfg.ImageStore.StoreConfig()
+	if cfg.ImageStore.UpstreamURL != "" {
+		storeConfig.Upstream = remotesync.NewUpstream(cfg.ImageStore.UpstreamURL)
+		log.Printf("Fetching missing images from upstream %s", cfg.ImageStore.UpstreamURL)
+	}
+
+	coldTier := cfg.ImageStore.ColdTier
+	switch {
+	case coldTier.Dir != "":
+		storeConfig.ColdStore, err = imagestore.NewDirColdStore(coldTier.Dir)
+		if err != nil {
+			return err
+		}
+		log.Printf("Offloading tiles unused for %d days to %s", coldTier.AfterDays, coldTier.Dir)
+	case coldTier.S3Bucket != "":
+		storeConfig.ColdStore, err = s3tier.NewFromEnv(ctx, coldTier.S3Bucket, coldTier.S3Prefix, coldTier.S3Region, coldTier.S3Endpoint)
+		if err != nil {
+			return err
+		}
+		log.Printf("Offloading tiles unused for %d days to s3://%s", coldTier.AfterDays, coldTier.S3Bucket)
+	}
+
+	backup := cfg.ImageStore.Backup
+	switch {
+	case backup.Dir != "":
+		storeConfig.BackupTarget, err = imagestore.NewDirBackupTarget(backup.Dir)
+		if err != nil {
+			return err
+		}
+	case backup.S3Bucket != "":
+		storeConfig.BackupTarget, err = s3backup.NewFromEnv(ctx, backup.S3Bucket, backup.S3Prefix, backup.S3Region, backup.S3Endpoint)
+		if err != nil {
+			return err
+		}
+	}
+	if backup.Schedule != "" {
+		log.Printf("Backing up on schedule %q, keeping %d", backup.Schedule, backup.Keep)
+	}
+
+	store, err := imagestore.NewPebbleImageStore(storeConfig)
+	if errors.Is(err, imagestore.ErrStoreLocked) {
+		return fmt.Errorf("stop the other instance, use a different database_path, or set open_timeout_seconds to wait for it: %w", err)
+	}
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	if storeConfig.ReadOnly {
+		log.Printf("Database %s opened read-only", storeConfig.DatabasePath)
+	}
+
+	if cfg.Watch.Dir != "" {
+		w, err := watcher.New(store, watcher.Config{
+			Dir:         cfg.Watch.Dir,
+			AfterStore:  cfg.Watch.AfterStore,
+			ArchiveDir:  cfg.Watch.ArchiveDir,
+			SettleDelay: time.Duration(cfg.Watch.SettleMillis) * time.Millisecond,
+		})
+		if err != nil {
+			return err
+		}
+		w.Start()
+		defer w.Close()
+	}
+
+	mux := http.NewServeMux()
+	handler := handlers.NewImageHandler(store, cfg.Server)
+	if cfg.PDF.Rasterizer != "" {
+		rasterizer := pdfimport.NewPdftoppm(cfg.PDF.Rasterizer)
+		handler.SetPDFImporter(pdfimport.NewImporter(store, rasterizer, cfg.PDF.DPI, cfg.PDF.MaxPages))
+	}
+	handler.RegisterRoutes(mux)
+
+	authorizer := handlers.NewAuthorizer(cfg.Auth)
+	if len(cfg.Auth.Tokens) > 0 {
+		log.Printf("Requiring bearer tokens, %d configured", len(cfg.Auth.Tokens))
+	}
+	handler.SetAuthorizer(authorizer)
+	go reloadAuthOnHangup(ctx, *configPath, authorizer)
+
+	server := &http.Server{
+		Addr:         cfg.GetServerAddress(),
+		Handler:      handlers.RequestIDMiddleware(authorizer.Middleware(mux)),
+		ReadTimeout:  time.Duration(cfg.Server.ReadTimeout) * time.Second,
+		WriteTimeout: time.Duration(cfg.Server.WriteTimeout) * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	var listeners []net.Listener
+	defer func() {
+		for _, listener := range listeners {
+			listener.Close()
+		}
+	}()
+	var tcpAddr string
+	if !cfg.Server.DisableTCP {
+		listener, err := net.Listen("tcp", server.Addr)
+		if err != nil {
+			return err
+		}
+		listeners = append(listeners, listener)
+		tcpAddr = listener.Addr().String()
+	}
+	if cfg.Server.UnixSocket != "" {
+		listener, err := listenUnix(cfg.Server.UnixSocket)
+		if err != nil {
+			return err
+		}
+		listeners = append(listeners, listener)
+	}
+
+	// Let CLI commands that find the database locked reach us instead
+	if !storeConfig.ReadOnly {
+		unadvertise, err := advertiseServer(storeConfig.DatabasePath, tcpAddr, cfg.Server.UnixSocket)
+		if err != nil {
+			log.Printf("Warning: CLI commands won't be able to proxy through this server: %v", err)
+		} else {
+			defer unadvertise()
+		}
+	}
+
+	errs := make(chan error, len(listeners))
+	for _, listener := range listeners {
+		log.Printf("Listening on %s", listener.Addr())
+		go func(listener net.Listener) {
+			errs <- server.Serve(listener)
+		}(listener)
+	}
+	// Shutdown stops every listener, so each Serve returns
+	for range listeners {
+		if err := <-errs; !errors.Is(err, http.ErrServerClosed) {
+			server.Close()
+			return err
+		}
+	}
+	return nil
+}
+
+// reloadAuthOnHangup rereads the auth section of the config file whenever the
+// process receives SIGHUP, keeping the current tokens if the file is invalid
+func reloadAuthOnHangup(ctx context.Context, configPath string, authorizer *handlers.Authorizer) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	defer signal.Stop(hangups)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangups:
+		}
+
+		cfg, err := config.LoadConfig(configPath)
+		if err == nil {
+			err = cfg.Auth.Validate()
+		}
+		if err != nil {
+			log.Printf("Warning: keeping the current auth tokens, failed to reload %s: %v", configPath, err)
+			continue
+		}
+		authorizer.Update(cfg.Auth)
+		log.Printf("Reloaded auth from %s, %d tokens", configPath, len(cfg.Auth.Tokens))
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/gordyf/imageencoder/lib/remotesync"
+)
+
+// runSync implements `imagestore sync <remote-url>`
+func runSync(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("sync", flag.ExitOnError)
+	var sf storeFlags
+	sf.register(fs)
+	directionName := fs.String("direction", "both", "push, pull or both")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: imagestore sync [flags] <remote-url>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected a remote URL")
+	}
+
+	direction, err := remotesync.ParseDirection(*directionName)
+	if err != nil {
+		return err
+	}
+
+	store, closeStore, err := sf.openSync(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+
+	result, err := remotesync.New(store, fs.Arg(0)).Run(ctx, direction)
+	if result != nil {
+		fmt.Printf("Pushed %d images (%d tiles), pulled %d images (%d tiles)\n",
+			result.ImagesPushed, result.TilesPushed, result.ImagesPulled, result.TilesPulled)
+		fmt.Printf("Sent %d bytes, received %d bytes\n", result.BytesSent, result.BytesReceived)
+		for _, id := range result.Conflicts {
+			fmt.Printf("Conflict: %s differs on both sides; rerun with -direction push or pull to resolve\n", id)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("%w (rerun to resume)", err)
+	}
+	return nil
+}
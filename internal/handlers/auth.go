@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gordyf/imageencoder/lib/config"
+	"github.com/gordyf/imageencoder/lib/imagestore"
+)
+
+// role is what a token may do in a namespace. Each role includes the ones
+// below it.
+type role int
+
+const (
+	roleNone   role = iota // Any valid token
+	roleReader             // Read images
+	roleWriter             // Also store, change and delete images
+	roleAdmin              // Also store-wide operations, when held on "*"
+)
+
+var roleNames = map[string]role{"reader": roleReader, "writer": roleWriter, "admin": roleAdmin}
+
+// allNamespaces is the namespace key granting a role everywhere
+const allNamespaces = "*"
+
+// principal is the holder of a token
+type principal struct {
+	name  string
+	roles map[string]role // By namespace, including allNamespaces
+}
+
+// can reports whether the principal holds at least want in namespace. Only
+// a role on allNamespaces satisfies a check on allNamespaces.
+func (p *principal) can(namespace string, want role) bool {
+	held := p.roles[allNamespaces]
+	if namespace != allNamespaces {
+		held = max(held, p.roles[namespace])
+	}
+	return held >= want
+}
+
+type principalKey struct{}
+
+// Authorizer checks bearer tokens against the auth section of the config
+// and the roles they hold, and signs and verifies URLs for retrieval without
+// a token. Its tokens and signing key can be replaced while serving.
+type Authorizer struct {
+	state atomic.Pointer[authState]
+}
+
+type authState struct {
+	tokens     map[[32]byte]*principal // By token hash; empty allows everything
+	signingKey []byte                  // Empty disables signed URLs
+}
+
+// NewAuthorizer returns an authorizer for the tokens in cfg
+func NewAuthorizer(cfg config.AuthConfig) *Authorizer {
+	a := &Authorizer{}
+	a.Update(cfg)
+	return a
+}
+
+// Update replaces the accepted tokens and the signing key. Requests already
+// admitted keep the roles they were admitted with.
+func (a *Authorizer) Update(cfg config.AuthConfig) {
+	tokens := make(map[[32]byte]*principal, len(cfg.Tokens))
+	for _, token := range cfg.Tokens {
+		p := &principal{name: token.Name, roles: make(map[string]role, len(token.Roles))}
+		for namespace, name := range token.Roles {
+			p.roles[namespace] = roleNames[name]
+		}
+		// Looking up hashes keeps the comparison from leaking the token
+		tokens[sha256.Sum256([]byte(token.Token))] = p
+	}
+	a.state.Store(&authState{tokens: tokens, signingKey: []byte(cfg.SigningKey)})
+}
+
+// Middleware rejects requests without a valid token with 401 and requests
+// the token's roles don't cover with 403. Admitted requests carry the token
+// holder's name as the actor of the changes they make.
+func (a *Authorizer) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		state := a.state.Load()
+		if len(state.signingKey) > 0 && r.URL.Query().Has("signature") {
+			if !signedRequest(r) || !state.verify(r.URL.Path, r.URL.Query(), time.Now()) {
+				writeError(w, http.StatusForbidden, codeForbidden, "Invalid or expired signature")
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if len(state.tokens) == 0 || r.URL.Path == "/health" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		p := state.tokens[sha256.Sum256([]byte(token))]
+		if !ok || p == nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="imagestore"`)
+			writeError(w, http.StatusUnauthorized, codeUnauthorized, "Missing or unknown bearer token")
+			return
+		}
+
+		namespace, want := requiredRole(r)
+		if !p.can(namespace, want) {
+			writeForbidden(w, namespace)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), principalKey{}, p)
+		next.ServeHTTP(w, r.WithContext(imagestore.WithActor(ctx, p.name)))
+	})
+}
+
+// requireRole checks a role the path alone didn't reveal, such as the
+// destination of a clone, writing a 403 and returning false when the request's
+// token lacks it. Requests are allowed when auth is off.
+func requireRole(w http.ResponseWriter, r *http.Request, namespace string, want role) bool {
+	p, ok := r.Context().Value(principalKey{}).(*principal)
+	if !ok || p.can(namespace, want) {
+		return true
+	}
+	writeForbidden(w, namespace)
+	return false
+}
+
+// writeForbidden reports a role check that failed
+func writeForbidden(w http.ResponseWriter, namespace string) {
+	message := "Token lacks the role needed in namespace " + namespace
+	if namespace == allNamespaces {
+		message = "Token lacks the role needed across all namespaces"
+	}
+	writeError(w, http.StatusForbidden, codeForbidden, message)
+}
+
+// imageSubresources are the path suffixes handleImages routes to other
+// handlers; what comes before one is the image ID
+var imageSubresources = []string{
+	"/restore", "/tags", "/metadata", "/clone", "/animation", "/pdf", "/source", "/annotations",
+	"/redactions", "/compose", "/region", "/usage", "/manifest", "/history", "/signed-url",
+}
+
+// requiredRole returns the namespace and role a request needs. Requests on
+// one image need a role in its namespace; anything spanning namespaces needs
+// the role on "*", and store-wide maintenance needs admin there.
+func requiredRole(r *http.Request) (string, role) {
+	read := r.Method == http.MethodGet || r.Method == http.MethodHead
+
+	switch path := r.URL.Path; {
+	case path == "/images/estimate":
+		return allNamespaces, roleNone // Neither reads nor writes any image
+	case path == "/tiles/missing":
+		return allNamespaces, roleNone // The handler checks the namespace named in the body
+	case path == "/export/images":
+		return allNamespaces, roleNone // The handler checks each requested image's namespace
+	case strings.HasPrefix(path, "/images/"):
+		id := strings.TrimPrefix(path, "/images/")
+		for _, suffix := range imageSubresources {
+			if trimmed, ok := strings.CutSuffix(id, suffix); ok && trimmed != "" {
+				id = trimmed
+				break
+			}
+		}
+		if read {
+			return imagestore.Namespace(id), roleReader
+		}
+		return imagestore.Namespace(id), roleWriter
+	case strings.HasPrefix(path, "/debug/"):
+		return imagestore.Namespace(strings.TrimPrefix(path, "/debug/")), roleReader
+	case path == "/images" && r.Method == http.MethodPost:
+		return "", roleWriter // Server-assigned IDs have no namespace
+	case path == "/images", path == "/search", path == "/clusters", path == "/stats", path == "/stats/history", path == "/stats/dedup", path == "/metrics",
+		path == "/changes", path == "/trash", strings.HasPrefix(path, "/sync/"),
+		strings.HasPrefix(path, "/tiles/") && !strings.HasPrefix(path, "/tiles/orphans"):
+		if read {
+			return allNamespaces, roleReader
+		}
+		return allNamespaces, roleWriter
+	default:
+		return allNamespaces, roleAdmin
+	}
+}
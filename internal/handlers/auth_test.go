@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gordyf/imageencoder/lib/config"
+	"github.com/gordyf/imageencoder/lib/imagestore"
+)
+
+// testAuthConfig grants "reader" read access to the team namespace,
+// "writer" write access to it and "admin" everything
+var testAuthConfig = config.AuthConfig{
+	Tokens: []config.TokenConfig{
+		{Name: "reader", Token: "reader-token", Roles: map[string]string{"team": "reader"}},
+		{Name: "writer", Token: "writer-token", Roles: map[string]string{"team": "writer"}},
+		{Name: "admin", Token: "admin-token", Roles: map[string]string{allNamespaces: "admin"}},
+	},
+}
+
+func TestRequiredRole(t *testing.T) {
+	tests := []struct {
+		method    string
+		path      string
+		namespace string
+		role      role
+	}{
+		{http.MethodGet, "/images/team/shot", "team", roleReader},
+		{http.MethodHead, "/images/team/shot", "team", roleReader},
+		{http.MethodPut, "/images/team/shot", "team", roleWriter},
+		{http.MethodDelete, "/images/shot", "", roleWriter},
+		{http.MethodGet, "/images/team/shot/tags", "team", roleReader},
+		{http.MethodPost, "/images/team/shot/tags", "team", roleWriter},
+		{http.MethodGet, "/images/team/shot/signed-url", "team", roleReader},
+		{http.MethodPost, "/images/team/suite/shot/clone", "team", roleWriter},
+		{http.MethodPost, "/images", "", roleWriter},
+		{http.MethodGet, "/images", allNamespaces, roleReader},
+		{http.MethodPost, "/images/estimate", allNamespaces, roleNone},
+		{http.MethodPost, "/tiles/missing", allNamespaces, roleNone},
+		{http.MethodPost, "/export/images", allNamespaces, roleNone},
+		{http.MethodGet, "/debug/team/shot", "team", roleReader},
+		{http.MethodGet, "/stats", allNamespaces, roleReader},
+		{http.MethodGet, "/sync/manifests", allNamespaces, roleReader},
+		{http.MethodPut, "/sync/tiles/abc", allNamespaces, roleWriter},
+		{http.MethodGet, "/tiles/abc", allNamespaces, roleReader},
+		{http.MethodGet, "/tiles/orphans", allNamespaces, roleAdmin},
+		{http.MethodPost, "/trash/purge", allNamespaces, roleAdmin},
+		{http.MethodPost, "/retention", allNamespaces, roleAdmin},
+		{http.MethodGet, "/backups", allNamespaces, roleAdmin},
+	}
+	for _, tt := range tests {
+		namespace, want := requiredRole(httptest.NewRequest(tt.method, tt.path, nil))
+		if namespace != tt.namespace || want != tt.role {
+			t.Errorf("%s %s: got namespace %q role %d, expected %q role %d", tt.method, tt.path, namespace, want, tt.namespace, tt.role)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	var admitted string
+	handler := NewAuthorizer(testAuthConfig).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if p, ok := r.Context().Value(principalKey{}).(*principal); ok {
+			admitted = p.name
+		}
+	}))
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		header string
+		status int
+	}{
+		{"missing token", http.MethodGet, "/images/team/shot", "", http.StatusUnauthorized},
+		{"unknown token", http.MethodGet, "/images/team/shot", "Bearer guess", http.StatusUnauthorized},
+		{"not a bearer token", http.MethodGet, "/images/team/shot", "reader-token", http.StatusUnauthorized},
+		{"health without token", http.MethodGet, "/health", "", http.StatusOK},
+		{"reader reads", http.MethodGet, "/images/team/shot", "Bearer reader-token", http.StatusOK},
+		{"reader writes", http.MethodPut, "/images/team/shot", "Bearer reader-token", http.StatusForbidden},
+		{"reader in another namespace", http.MethodGet, "/images/other/shot", "Bearer reader-token", http.StatusForbidden},
+		{"reader across namespaces", http.MethodGet, "/images", "Bearer reader-token", http.StatusForbidden},
+		{"writer writes", http.MethodPut, "/images/team/shot", "Bearer writer-token", http.StatusOK},
+		{"writer maintains", http.MethodPost, "/trash/purge", "Bearer writer-token", http.StatusForbidden},
+		{"admin maintains", http.MethodPost, "/trash/purge", "Bearer admin-token", http.StatusOK},
+		{"admin anywhere", http.MethodDelete, "/images/other/shot", "Bearer admin-token", http.StatusOK},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		if tt.header != "" {
+			r.Header.Set("Authorization", tt.header)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if w.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d: %s", tt.name, tt.status, w.Code, w.Body)
+		}
+		if tt.status == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: expected a WWW-Authenticate challenge", tt.name)
+		}
+	}
+
+	// Admitted requests carry the token's holder
+	r := httptest.NewRequest(http.MethodPut, "/images/team/shot", nil)
+	r.Header.Set("Authorization", "Bearer writer-token")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	if admitted != "writer" {
+		t.Errorf("expected the writer admitted, got %q", admitted)
+	}
+}
+
+func TestMiddlewareWithoutTokens(t *testing.T) {
+	handler := NewAuthorizer(config.AuthConfig{}).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/trash/purge", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected every request allowed without tokens, got %d", w.Code)
+	}
+}
+
+func TestMissingTilesNamespace(t *testing.T) {
+	storeConfig := imagestore.DefaultConfig()
+	storeConfig.DatabasePath = filepath.Join(t.TempDir(), "test.db")
+	storeConfig.TileSize = 4
+	storeConfig.TilePools = imagestore.TilePoolsNamespace
+	store, err := imagestore.NewPebbleImageStore(storeConfig)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	img := image.NewRGBA(image.Rect(0, 0, 8, 4))
+	for x := 0; x < 8; x++ {
+		for y := 0; y < 4; y++ {
+			img.Set(x, y, color.RGBA{uint8(x * 30), uint8(y * 60), 90, 255})
+		}
+	}
+	tiles, _, err := imagestore.ExtractTiles(img, 4)
+	if err != nil {
+		t.Fatalf("failed to extract tiles: %v", err)
+	}
+	tileIDs := []imagestore.TileID{tiles[0].ID, tiles[1].ID}
+	if err := store.StoreManifest("team/shot", imagestore.ImageManifest{Width: 8, Height: 4, TileSize: 4, Tiles: tileIDs}, map[imagestore.TileID][]byte{
+		tiles[0].ID: tiles[0].Data,
+		tiles[1].ID: tiles[1].Data,
+	}, imagestore.StoreOptions{}); err != nil {
+		t.Fatalf("failed to store image: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	NewImageHandler(store, config.DefaultConfig().Server).RegisterRoutes(mux)
+	auth := testAuthConfig
+	auth.Tokens = append(auth.Tokens, config.TokenConfig{Name: "other", Token: "other-token", Roles: map[string]string{"other": "reader"}})
+	handler := NewAuthorizer(auth).Middleware(mux)
+
+	ask := func(token, namespace string, tileIDs []imagestore.TileID) (int, []imagestore.TileID) {
+		t.Helper()
+		body, _ := json.Marshal(map[string]interface{}{"tile_size": 4, "tiles": tileIDs, "namespace": namespace})
+		r := httptest.NewRequest(http.MethodPost, "/tiles/missing", bytes.NewReader(body))
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		var reply struct {
+			Missing []imagestore.TileID `json:"missing"`
+		}
+		json.NewDecoder(w.Body).Decode(&reply)
+		return w.Code, reply.Missing
+	}
+
+	if status, missing := ask("reader-token", "team", tileIDs); status != http.StatusOK || len(missing) != 0 {
+		t.Errorf("expected the team's reader to find both tiles, got %d missing %v", status, missing)
+	}
+	if status, _ := ask("reader-token", "other", tileIDs); status != http.StatusForbidden {
+		t.Errorf("expected 403 asking about a namespace without a role, got %d", status)
+	}
+	if status, _ := ask("reader-token", "", tileIDs); status != http.StatusForbidden {
+		t.Errorf("expected 403 asking about the root namespace without a role, got %d", status)
+	}
+
+	// Another namespace's reader only learns about its own pool
+	if status, missing := ask("other-token", "other", tileIDs); status != http.StatusOK || len(missing) != 2 {
+		t.Errorf("expected both tiles missing from the other pool, got %d missing %v", status, missing)
+	}
+
+	// Tile IDs naming a pool are for sync, which reads every namespace
+	pooled := []imagestore.TileID{imagestore.TileID("team/" + string(tiles[0].ID))}
+	if status, _ := ask("other-token", "other", pooled); status != http.StatusForbidden {
+		t.Errorf("expected 403 asking about another pool's tile, got %d", status)
+	}
+	if status, missing := ask("admin-token", "", pooled); status != http.StatusOK || len(missing) != 0 {
+		t.Errorf("expected an admin to find the pooled tile, got %d missing %v", status, missing)
+	}
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+)
+
+// Error codes name what went wrong for clients to match on. Messages are for
+// people and may change; codes don't.
+const (
+	codeInvalidRequest     = "invalid_request"      // Malformed parameters or body
+	codeMethodNotAllowed   = "method_not_allowed"   // See the Allow header
+	codeNotSupported       = "not_supported"        // The store lacks the feature
+	codeInternal           = "internal_error"       // Logged on the server under the request ID
+	codeUnauthorized       = "unauthorized"         // No bearer token, or one the server doesn't know
+	codeForbidden          = "forbidden"            // The token's roles don't allow the request
+	codeImageNotFound      = "image_not_found"      // No live (or, for restores, trashed) image with the ID
+	codeTileNotFound       = "tile_not_found"       // No stored tile with the ID
+	codeAnimationNotFound  = "animation_not_found"  // The image has no animation
+	codeMissingFile        = "missing_file"         // The multipart upload has no file
+	codeInvalidImageFormat = "invalid_image_format" // The upload isn't a supported image type
+	codeTooLarge           = "request_too_large"    // Over max_upload_bytes
+	codeQuotaExceeded      = "quota_exceeded"       // Over the namespace quota
+	codeReadOnly           = "read_only"            // The store was opened read-only
+	codeStorageFull        = "storage_full"         // The disk guard refuses writes; deletions still work
+	codeImageExists        = "image_exists"         // Rejected by on_conflict
+	codePreconditionFailed = "precondition_failed"  // If-Match didn't match
+	codeTileSizeMismatch   = "tile_size_mismatch"   // details.tile_size is the size to use
+	codeTilesMissing       = "tiles_missing"        // A manifest references tiles the store lacks
+	codeOverloaded         = "overloaded"           // The write queue is full; see Retry-After
+	codeTimeout            = "timeout"              // The route timeout passed; see Retry-After
+	codeVerificationFailed = "verification_failed"  // The upload didn't reconstruct exactly
+	codeChangesTruncated   = "changes_truncated"    // The change log no longer reaches back that far
+)
+
+// errorBody is the JSON envelope every error response is sent in
+type errorBody struct {
+	Error errorDetail `json:"error"`
+}
+
+type errorDetail struct {
+	Code      string      `json:"code"`
+	Message   string      `json:"message"`
+	RequestID string      `json:"request_id"`
+	Details   interface{} `json:"details,omitempty"`
+}
+
+// writeError sends an error response in the JSON envelope
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeErrorDetails(w, status, code, message, nil)
+}
+
+// writeErrorDetails sends an error response with machine-readable details,
+// such as the limits a quota error exceeded
+func writeErrorDetails(w http.ResponseWriter, status int, code, message string, details interface{}) {
+	w.Header().Del("ETag")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorBody{Error: errorDetail{
+		Code:      code,
+		Message:   message,
+		RequestID: responseRequestID(w),
+		Details:   details,
+	}})
+}
+
+// uploadErrorCode returns the code for a status from readUploadedFile
+func uploadErrorCode(status int) string {
+	switch status {
+	case http.StatusRequestEntityTooLarge:
+		return codeTooLarge
+	case http.StatusUnsupportedMediaType:
+		return codeInvalidImageFormat
+	case http.StatusInternalServerError:
+		return codeInternal
+	default:
+		return codeInvalidRequest
+	}
+}
+
+// RequestIDMiddleware gives every request an ID, sent back in the
+// X-Request-ID header and in error responses. A client's own X-Request-ID is
+// kept so it can correlate logs across services.
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// responseRequestID returns the response's request ID, assigning one when
+// RequestIDMiddleware isn't in use
+func responseRequestID(w http.ResponseWriter) string {
+	id := w.Header().Get("X-Request-ID")
+	if id == "" {
+		id = newRequestID()
+		w.Header().Set("X-Request-ID", id)
+	}
+	return id
+}
+
+// validRequestID accepts short printable IDs, so a client can't inject
+// headers or flood logs through its own
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID returns a random 16-character hex ID
+func newRequestID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
@@ -1,34 +1,89 @@
 package handlers
 
 import (
+	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"iter"
 	"log"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/gordyf/imageencoder/lib/config"
 	"github.com/gordyf/imageencoder/lib/imagestore"
+	"github.com/gordyf/imageencoder/lib/pdfimport"
 )
 
 // ImageHandler handles HTTP requests for the image store
 type ImageHandler struct {
-	store imagestore.ImageStore
+	store                imagestore.ImageStore
+	maxUploadBytes       int64
+	multipartMemoryBytes int64
+	storeTimeout         time.Duration // Per-route limits; zero is unbounded
+	retrieveTimeout      time.Duration
+	statsTimeout         time.Duration
+	pdfImporter          *pdfimport.Importer // nil when PDF ingestion is disabled
+	authorizer           *Authorizer         // Signs URLs; nil when signing is disabled
 }
 
 // NewImageHandler creates a new image handler
-func NewImageHandler(store imagestore.ImageStore) *ImageHandler {
+func NewImageHandler(store imagestore.ImageStore, serverConfig config.ServerConfig) *ImageHandler {
 	return &ImageHandler{
-		store: store,
+		store:                store,
+		maxUploadBytes:       serverConfig.MaxUploadBytes,
+		multipartMemoryBytes: serverConfig.MultipartMemoryBytes,
+		storeTimeout:         time.Duration(serverConfig.RouteTimeouts.StoreSeconds) * time.Second,
+		retrieveTimeout:      time.Duration(serverConfig.RouteTimeouts.RetrieveSeconds) * time.Second,
+		statsTimeout:         time.Duration(serverConfig.RouteTimeouts.StatsSeconds) * time.Second,
 	}
 }
 
+// SetAuthorizer enables minting signed URLs at GET /images/{id}/signed-url
+func (h *ImageHandler) SetAuthorizer(authorizer *Authorizer) {
+	h.authorizer = authorizer
+}
+
+// SetPDFImporter enables PDF uploads at POST /images/{id}/pdf
+func (h *ImageHandler) SetPDFImporter(importer *pdfimport.Importer) {
+	h.pdfImporter = importer
+}
+
 // RegisterRoutes registers all HTTP routes
 func (h *ImageHandler) RegisterRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("/images/", h.handleImages)
-	mux.HandleFunc("/images", h.handleImagesList)
+	mux.HandleFunc("/images", h.handleImagesCollection)
+	mux.HandleFunc("/images/estimate", h.handleEstimate)
 	mux.HandleFunc("/debug/", h.handleDebugImage)
+	mux.HandleFunc("/trash", h.handleTrash)
+	mux.HandleFunc("/trash/purge", h.handleTrashPurge)
+	mux.HandleFunc("/retention", h.handleRetention)
+	mux.HandleFunc("/preload", h.handlePreload)
 	mux.HandleFunc("/stats", h.handleStats)
+	mux.HandleFunc("/stats/history", h.handleStatsHistory)
+	mux.HandleFunc("/stats/dedup", h.handleStatsDedup)
+	mux.HandleFunc("/metrics", h.handleMetrics)
+	mux.HandleFunc("/backups", h.handleBackups)
+	mux.HandleFunc("/jobs", h.handleJobs)
+	mux.HandleFunc("/clusters", h.handleClusters)
+	mux.HandleFunc("/search", h.handleSearch)
+	mux.HandleFunc("/export", h.handleExport)
+	mux.HandleFunc("/export/images", h.handleExportImages)
+	mux.HandleFunc("/tiles/missing", h.handleMissingTiles)
+	mux.HandleFunc("/tiles/orphans", h.handleOrphanedTiles)
+	mux.HandleFunc("/tiles/orphans/purge", h.handleOrphanedTiles)
+	mux.HandleFunc("/tiles/", h.handleTile)
+	mux.HandleFunc("/sync/manifests", h.handleSyncManifests)
+	mux.HandleFunc("/sync/manifests/", h.handleSyncManifest)
+	mux.HandleFunc("/sync/tiles/", h.handleSyncTile)
+	mux.HandleFunc("/changes", h.handleChanges)
 	mux.HandleFunc("/health", h.handleHealth)
 }
 
@@ -37,37 +92,147 @@ func (h *ImageHandler) handleImages(w http.ResponseWriter, r *http.Request) {
 	// Extract image ID from path
 	path := strings.TrimPrefix(r.URL.Path, "/images/")
 	if path == "" {
-		http.Error(w, "Missing image ID", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Missing image ID")
 		return
 	}
 
 	imageID := path
 
+	if id, ok := strings.CutSuffix(path, "/restore"); ok && id != "" {
+		h.handleRestore(w, r, id)
+		return
+	}
+
+	if id, ok := strings.CutSuffix(path, "/tags"); ok && id != "" {
+		h.handleTags(w, r, id)
+		return
+	}
+
+	if id, ok := strings.CutSuffix(path, "/metadata"); ok && id != "" {
+		h.handleMetadata(w, r, id)
+		return
+	}
+
+	if id, ok := strings.CutSuffix(path, "/clone"); ok && id != "" {
+		h.handleClone(w, r, id)
+		return
+	}
+
+	if id, ok := strings.CutSuffix(path, "/animation"); ok && id != "" {
+		h.handleAnimation(w, r, id)
+		return
+	}
+
+	if id, ok := strings.CutSuffix(path, "/pdf"); ok && id != "" {
+		h.handlePDF(w, r, id)
+		return
+	}
+
+	if id, ok := strings.CutSuffix(path, "/source"); ok && id != "" {
+		h.handleSourceInfo(w, r, id)
+		return
+	}
+
+	if id, ok := strings.CutSuffix(path, "/annotations"); ok && id != "" {
+		h.handleAnnotations(w, r, id)
+		return
+	}
+
+	if id, ok := strings.CutSuffix(path, "/redactions"); ok && id != "" {
+		h.handleRedactions(w, r, id)
+		return
+	}
+
+	if id, ok := strings.CutSuffix(path, "/compose"); ok && id != "" {
+		h.handleCompose(w, r, id)
+		return
+	}
+
+	if id, ok := strings.CutSuffix(path, "/region"); ok && id != "" {
+		h.handlePatchRegion(w, r, id)
+		return
+	}
+
+	if id, ok := strings.CutSuffix(path, "/usage"); ok && id != "" {
+		h.handleImageUsage(w, r, id)
+		return
+	}
+
+	if id, ok := strings.CutSuffix(path, "/manifest"); ok && id != "" {
+		h.handleManifest(w, r, id)
+		return
+	}
+
+	if id, ok := strings.CutSuffix(path, "/history"); ok && id != "" {
+		h.handleHistory(w, r, id)
+		return
+	}
+
+	if id, ok := strings.CutSuffix(path, "/signed-url"); ok && id != "" {
+		h.handleSignedURL(w, r, id)
+		return
+	}
+
 	switch r.Method {
 	case http.MethodPost:
 		h.storeImage(w, r, imageID)
+	case http.MethodPut:
+		h.storeRawImage(w, r, imageID)
 	case http.MethodGet:
-		h.retrieveImage(w, imageID)
+		if r.URL.Query().Get("annotations") == "true" {
+			h.retrieveAnnotatedImage(w, imageID)
+			return
+		}
+		h.retrieveImage(w, r, imageID)
 	case http.MethodDelete:
 		h.deleteImage(w, imageID)
 	default:
-		w.Header().Set("Allow", "GET, POST, DELETE")
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		w.Header().Set("Allow", "GET, POST, PUT, DELETE")
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
 	}
 }
 
-// handleImagesList handles listing all images
-func (h *ImageHandler) handleImagesList(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		w.Header().Set("Allow", "GET")
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+// handleImagesCollection handles operations on the image collection
+func (h *ImageHandler) handleImagesCollection(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.listImages(w, r)
+	case http.MethodPost:
+		h.storeImages(w, r)
+	case http.MethodDelete:
+		h.deleteImagesByTag(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
 	}
+}
+
+// tagStore is implemented by stores that support tagging images
+type tagStore interface {
+	AddTags(id string, tags ...string) error
+	RemoveTags(id string, tags ...string) error
+	ListByTag(tag string) ([]string, error)
+	DeleteByTag(tag string) (int, error)
+}
 
-	imageIDs, err := h.store.ListImages()
+// listImages handles GET /images, optionally filtered with ?tag=
+func (h *ImageHandler) listImages(w http.ResponseWriter, r *http.Request) {
+	var imageIDs []string
+	var err error
+
+	if tag := r.URL.Query().Get("tag"); tag != "" {
+		store, ok := h.store.(tagStore)
+		if !ok {
+			writeError(w, http.StatusNotImplemented, codeNotSupported, "Tags not supported by this store")
+			return
+		}
+		imageIDs, err = store.ListByTag(tag)
+	} else {
+		imageIDs, err = h.store.ListImages()
+	}
 	if err != nil {
 		log.Printf("Error listing images: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
 		return
 	}
 
@@ -80,50 +245,72 @@ func (h *ImageHandler) handleImagesList(w http.ResponseWriter, r *http.Request)
 
 // storeImage handles POST /images/{id}
 func (h *ImageHandler) storeImage(w http.ResponseWriter, r *http.Request, imageID string) {
-	// Parse multipart form
-	err := r.ParseMultipartForm(32 << 20) // 32MB max
-	if err != nil {
-		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+	if !h.parseUploadForm(w, r) {
 		return
 	}
 
 	// Get file from form
-	file, fileHeader, err := r.FormFile("image")
+	files := r.MultipartForm.File["image"]
+	if len(files) == 0 {
+		writeError(w, http.StatusBadRequest, codeMissingFile, "Missing image file")
+		return
+	}
+
+	imageData, status, err := h.readUploadedImage(files[0])
 	if err != nil {
-		http.Error(w, "Missing image file", http.StatusBadRequest)
+		writeError(w, status, uploadErrorCode(status), err.Error())
 		return
 	}
-	defer file.Close()
 
-	// Validate file type
-	contentType := fileHeader.Header.Get("Content-Type")
-	if !isValidImageType(contentType) {
-		http.Error(w, "Invalid image type. Supported: PNG, JPEG", http.StatusBadRequest)
+	h.storeUpload(w, r, imageID, imageData)
+}
+
+// storeRawImage handles PUT /images/{id}, where the request body is the
+// image itself and Content-Type names its format. The body is read straight
+// into memory once, without multipart parsing.
+func (h *ImageHandler) storeRawImage(w http.ResponseWriter, r *http.Request, imageID string) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if !isValidImageType(mediaType) {
+		writeError(w, http.StatusUnsupportedMediaType, codeInvalidImageFormat, "Content-Type must be an image type. Supported: PNG, JPEG, HEIC, AVIF")
 		return
 	}
 
-	// Read file data
-	imageData, err := io.ReadAll(file)
+	imageData, err := io.ReadAll(http.MaxBytesReader(w, r.Body, h.maxUploadBytes))
 	if err != nil {
-		log.Printf("Error reading image data: %v", err)
-		http.Error(w, "Failed to read image", http.StatusInternalServerError)
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeError(w, http.StatusRequestEntityTooLarge, codeTooLarge, fmt.Sprintf("Request body too large (max %d bytes)", h.maxUploadBytes))
+			return
+		}
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Failed to read request body")
+		return
+	}
+	if len(imageData) == 0 {
+		writeError(w, http.StatusBadRequest, codeMissingFile, "Missing image data")
 		return
 	}
 
-	// Validate file size
-	if len(imageData) > 50<<20 { // 50MB max
-		http.Error(w, "Image too large (max 50MB)", http.StatusRequestEntityTooLarge)
+	h.storeUpload(w, r, imageID, imageData)
+}
+
+// storeUpload stores an uploaded image under imageID with the request's
+// store options and reports the result
+func (h *ImageHandler) storeUpload(w http.ResponseWriter, r *http.Request, imageID string, imageData []byte) {
+	opts, err := parseStoreOptions(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
 		return
 	}
 
-	// Store image
-	err = h.store.StoreImage(imageID, imageData)
+	ctx, cancel := withRouteTimeout(r, h.storeTimeout)
+	defer cancel()
+	err = h.storeWithOptions(ctx, imageID, imageData, opts)
 	if err != nil {
-		log.Printf("Error storing image %s: %v", imageID, err)
-		http.Error(w, "Failed to store image", http.StatusInternalServerError)
+		writeStoreError(w, imageID, err)
 		return
 	}
 
+	h.setETag(w, imageID)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(map[string]string{
@@ -133,116 +320,2633 @@ func (h *ImageHandler) storeImage(w http.ResponseWriter, r *http.Request, imageI
 	})
 }
 
-// retrieveImage handles GET /images/{id}
-func (h *ImageHandler) retrieveImage(w http.ResponseWriter, imageID string) {
-	imageData, err := h.store.RetrieveImage(imageID)
-	if err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			http.Error(w, "Image not found", http.StatusNotFound)
+// dryRunStore is implemented by stores that can estimate a store without
+// writing
+type dryRunStore interface {
+	StoreImageDryRun(imageData []byte) (*imagestore.StoreEstimate, error)
+}
+
+// handleEstimate handles POST /images/estimate, reporting what storing the
+// uploaded images would write. Other methods operate on an image with the
+// ID "estimate".
+func (h *ImageHandler) handleEstimate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.handleImages(w, r)
+		return
+	}
+
+	store, ok := h.store.(dryRunStore)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, codeNotSupported, "Estimates not supported by this store")
+		return
+	}
+
+	if !h.parseUploadForm(w, r) {
+		return
+	}
+
+	files := r.MultipartForm.File["image"]
+	if len(files) == 0 {
+		writeError(w, http.StatusBadRequest, codeMissingFile, "Missing image file")
+		return
+	}
+
+	var total imagestore.StoreEstimate
+	estimates := make([]map[string]interface{}, 0, len(files))
+	for _, fileHeader := range files {
+		imageData, status, err := h.readUploadedImage(fileHeader)
+		if err != nil {
+			writeError(w, status, uploadErrorCode(status), fmt.Sprintf("%s: %s", fileHeader.Filename, err.Error()))
 			return
 		}
-		log.Printf("Error retrieving image %s: %v", imageID, err)
-		http.Error(w, "Failed to retrieve image", http.StatusInternalServerError)
-		return
+
+		estimate, err := store.StoreImageDryRun(imageData)
+		if err != nil {
+			if errors.Is(err, imagestore.ErrUnsupportedFormat) {
+				writeError(w, http.StatusUnsupportedMediaType, codeInvalidImageFormat, fmt.Sprintf("%s: %s", fileHeader.Filename, err.Error()))
+				return
+			}
+			if strings.Contains(err.Error(), "failed to decode") {
+				writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("%s: %s", fileHeader.Filename, err.Error()))
+				return
+			}
+			log.Printf("Error estimating %s: %v", fileHeader.Filename, err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Failed to estimate image")
+			return
+		}
+
+		total.Add(*estimate)
+		estimates = append(estimates, map[string]interface{}{
+			"filename": fileHeader.Filename,
+			"estimate": estimate,
+		})
 	}
 
-	w.Header().Set("Content-Type", "image/png")
-	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"%s.png\"", imageID))
-	w.Write(imageData)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"images": estimates,
+		"total":  total,
+	})
 }
 
-// deleteImage handles DELETE /images/{id}
-func (h *ImageHandler) deleteImage(w http.ResponseWriter, imageID string) {
-	err := h.store.DeleteImage(imageID)
+// storeImages handles POST /images, storing every uploaded file under a
+// server-assigned ID derived from its content hash
+func (h *ImageHandler) storeImages(w http.ResponseWriter, r *http.Request) {
+	if !h.parseUploadForm(w, r) {
+		return
+	}
+
+	files := r.MultipartForm.File["image"]
+	if len(files) == 0 {
+		writeError(w, http.StatusBadRequest, codeMissingFile, "Missing image file")
+		return
+	}
+
+	opts, err := parseStoreOptions(r)
 	if err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			http.Error(w, "Image not found", http.StatusNotFound)
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+
+	// Validate and read every file before storing any of them
+	uploads := make([][]byte, len(files))
+	for i, fileHeader := range files {
+		imageData, status, err := h.readUploadedImage(fileHeader)
+		if err != nil {
+			writeError(w, status, uploadErrorCode(status), fmt.Sprintf("%s: %s", fileHeader.Filename, err.Error()))
 			return
 		}
-		log.Printf("Error deleting image %s: %v", imageID, err)
-		http.Error(w, "Failed to delete image", http.StatusInternalServerError)
-		return
+		uploads[i] = imageData
+	}
+
+	// The timeout covers the whole batch
+	ctx, cancel := withRouteTimeout(r, h.storeTimeout)
+	defer cancel()
+
+	stored := make([]map[string]string, 0, len(files))
+	for i, imageData := range uploads {
+		imageID := generateImageID(imageData)
+
+		err := h.storeWithOptions(ctx, imageID, imageData, opts)
+		if err != nil {
+			writeStoreError(w, imageID, err)
+			return
+		}
+
+		stored = append(stored, map[string]string{
+			"image_id": imageID,
+			"filename": files[i].Filename,
+		})
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"status":   "success",
-		"image_id": imageID,
-		"message":  "Image deleted successfully",
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  "success",
+		"images":  stored,
+		"count":   len(stored),
+		"message": "Images stored successfully",
 	})
 }
 
-// handleStats handles GET /stats
-func (h *ImageHandler) handleStats(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		w.Header().Set("Allow", "GET")
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+// writeStoreError responds to a failed store, reporting quota violations as
+// 413 when the image alone is over quota and 507 otherwise
+func writeStoreError(w http.ResponseWriter, imageID string, err error) {
+	if isTimeout(err) {
+		writeTimeoutError(w, "Storing image "+imageID)
 		return
 	}
 
-	stats := h.store.GetStorageStats()
+	var quotaErr *imagestore.QuotaExceededError
+	if errors.As(err, &quotaErr) {
+		status := http.StatusInsufficientStorage
+		if quotaErr.TooLarge {
+			status = http.StatusRequestEntityTooLarge
+		}
+		writeErrorDetails(w, status, codeQuotaExceeded, quotaErr.Error(), map[string]interface{}{
+			"namespace": quotaErr.Namespace,
+			"resource":  quotaErr.Resource,
+			"limit":     quotaErr.Limit,
+			"requested": quotaErr.Requested,
+		})
+		return
+	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(stats)
-}
+	if errors.Is(err, imagestore.ErrWriteQueueFull) {
+		w.Header().Set("Retry-After", "1")
+		writeError(w, http.StatusServiceUnavailable, codeOverloaded, "Too many uploads in progress, retry later")
+		return
+	}
 
-// handleHealth handles GET /health
-func (h *ImageHandler) handleHealth(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		w.Header().Set("Allow", "GET")
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	if errors.Is(err, imagestore.ErrReadOnly) {
+		writeError(w, http.StatusForbidden, codeReadOnly, "Store is read-only")
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"status":  "healthy",
-		"service": "imageencoder",
-	})
-}
+	if errors.Is(err, imagestore.ErrStorageFull) {
+		writeError(w, http.StatusInsufficientStorage, codeStorageFull, err.Error())
+		return
+	}
 
-// handleDebugImage handles GET /debug/{id}
-func (h *ImageHandler) handleDebugImage(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		w.Header().Set("Allow", "GET")
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	if errors.Is(err, imagestore.ErrUnsupportedFormat) {
+		writeError(w, http.StatusUnsupportedMediaType, codeInvalidImageFormat, err.Error())
 		return
 	}
 
-	// Extract image ID from path
-	path := strings.TrimPrefix(r.URL.Path, "/debug/")
-	if path == "" {
-		http.Error(w, "Missing image ID", http.StatusBadRequest)
+	if errors.Is(err, imagestore.ErrPreconditionFailed) {
+		writeError(w, http.StatusPreconditionFailed, codePreconditionFailed, err.Error())
 		return
 	}
 
-	imageID := path
+	if errors.Is(err, imagestore.ErrImageExists) {
+		writeError(w, http.StatusConflict, codeImageExists, "An image with this ID already exists")
+		return
+	}
 
-	// Check if the store supports debug images
-	type debugImageStore interface {
-		RetrieveDebugImage(id string) ([]byte, error)
+	if errors.Is(err, imagestore.ErrVerificationFailed) {
+		log.Printf("Image %s failed verification and was not stored: %v", imageID, err)
+		writeError(w, http.StatusInternalServerError, codeVerificationFailed, "Stored image failed verification; nothing was written")
+		return
 	}
 
-	debugStore, ok := h.store.(debugImageStore)
+	log.Printf("Error storing image %s: %v", imageID, err)
+	writeError(w, http.StatusInternalServerError, codeInternal, "Failed to store image")
+}
+
+// optionsStore is implemented by stores that accept per-image store options
+type optionsStore interface {
+	StoreImageWithOptions(id string, data []byte, opts imagestore.StoreOptions) error
+}
+
+// contextStore is implemented by stores whose uploads and reads stop when
+// their context is done
+type contextStore interface {
+	StoreImageContext(ctx context.Context, id string, data []byte, opts imagestore.StoreOptions) error
+	RetrieveImageContext(ctx context.Context, id string) ([]byte, error)
+}
+
+// storeWithOptions stores an image, passing options and the request's
+// context through when the store supports them
+func (h *ImageHandler) storeWithOptions(ctx context.Context, imageID string, imageData []byte, opts imagestore.StoreOptions) error {
+	if store, ok := h.store.(contextStore); ok {
+		return store.StoreImageContext(ctx, imageID, imageData, opts)
+	}
+	if store, ok := h.store.(optionsStore); ok {
+		return store.StoreImageWithOptions(imageID, imageData, opts)
+	}
+	if opts.ExpiresAt != nil {
+		return fmt.Errorf("expiration not supported by this store")
+	}
+	if opts.IfMatch != "" {
+		return fmt.Errorf("conditional writes not supported by this store")
+	}
+	return h.store.StoreImage(imageID, imageData)
+}
+
+// etagStore is implemented by stores that version images with ETags
+type etagStore interface {
+	ImageETag(id string) (string, error)
+}
+
+// setETag sets the ETag header to an image's current version, if the store
+// supports versions
+func (h *ImageHandler) setETag(w http.ResponseWriter, imageID string) {
+	store, ok := h.store.(etagStore)
 	if !ok {
-		http.Error(w, "Debug images not supported by this store", http.StatusNotImplemented)
 		return
 	}
+	if etag, err := store.ImageETag(imageID); err == nil {
+		w.Header().Set("ETag", etag)
+	}
+}
 
-	imageData, err := debugStore.RetrieveDebugImage(imageID)
+// etagMatches evaluates an If-None-Match list against the current ETag,
+// using the weak comparison the header calls for
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// parseStoreOptions reads upload options from the request headers.
+// X-Image-Expires-At takes an RFC 3339 timestamp; X-Image-TTL takes a Go
+// duration ("36h") or a number of seconds. If-Match makes the write
+// conditional on the image's current ETag.
+func parseStoreOptions(r *http.Request) (imagestore.StoreOptions, error) {
+	opts := imagestore.StoreOptions{IfMatch: r.Header.Get("If-Match")}
+
+	if value := r.Header.Get("X-Image-Expires-At"); value != "" {
+		expiresAt, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return opts, fmt.Errorf("invalid X-Image-Expires-At header: %v", err)
+		}
+		opts.ExpiresAt = &expiresAt
+	}
+
+	if value := r.Header.Get("X-Image-TTL"); value != "" {
+		if opts.ExpiresAt != nil {
+			return opts, fmt.Errorf("X-Image-TTL and X-Image-Expires-At are mutually exclusive")
+		}
+		ttl, err := time.ParseDuration(value)
+		if err != nil {
+			seconds, convErr := strconv.ParseInt(value, 10, 64)
+			if convErr != nil {
+				return opts, fmt.Errorf("invalid X-Image-TTL header: %s", value)
+			}
+			ttl = time.Duration(seconds) * time.Second
+		}
+		if ttl <= 0 {
+			return opts, fmt.Errorf("X-Image-TTL must be positive")
+		}
+		expiresAt := time.Now().Add(ttl)
+		opts.ExpiresAt = &expiresAt
+	}
+
+	return opts, nil
+}
+
+// parseUploadForm limits the request body to the configured upload size and
+// parses the multipart form, writing an error response if either fails
+func (h *ImageHandler) parseUploadForm(w http.ResponseWriter, r *http.Request) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxUploadBytes)
+
+	err := r.ParseMultipartForm(h.multipartMemoryBytes)
 	if err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			http.Error(w, "Image not found", http.StatusNotFound)
-			return
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeError(w, http.StatusRequestEntityTooLarge, codeTooLarge, fmt.Sprintf("Request body too large (max %d bytes)", h.maxUploadBytes))
+			return false
 		}
-		log.Printf("Error retrieving debug image %s: %v", imageID, err)
-		http.Error(w, "Failed to retrieve debug image", http.StatusInternalServerError)
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Failed to parse form")
+		return false
+	}
+
+	return true
+}
+
+// readUploadedImage validates and reads a single uploaded image file,
+// returning the HTTP status to use if it is rejected
+func (h *ImageHandler) readUploadedImage(fileHeader *multipart.FileHeader) ([]byte, int, error) {
+	// Validate file type
+	contentType := fileHeader.Header.Get("Content-Type")
+	if !isValidImageType(contentType) {
+		return nil, http.StatusBadRequest, fmt.Errorf("Invalid image type. Supported: PNG, JPEG, HEIC, AVIF")
+	}
+
+	return h.readUploadedFile(fileHeader)
+}
+
+// readUploadedFile reads an uploaded file, enforcing the upload size limit
+func (h *ImageHandler) readUploadedFile(fileHeader *multipart.FileHeader) ([]byte, int, error) {
+	file, err := fileHeader.Open()
+	if err != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("Missing image file")
+	}
+	defer file.Close()
+
+	// Read file data
+	imageData, err := io.ReadAll(file)
+	if err != nil {
+		log.Printf("Error reading image data: %v", err)
+		return nil, http.StatusInternalServerError, fmt.Errorf("Failed to read image")
+	}
+
+	// Validate file size
+	if int64(len(imageData)) > h.maxUploadBytes {
+		return nil, http.StatusRequestEntityTooLarge, fmt.Errorf("Image too large (%d bytes, max %d bytes)", len(imageData), h.maxUploadBytes)
+	}
+
+	return imageData, http.StatusOK, nil
+}
+
+// generateImageID derives an image ID from the SHA-256 of the uploaded bytes
+func generateImageID(imageData []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(imageData))
+}
+
+// retrieveImage handles GET /images/{id}
+func (h *ImageHandler) retrieveImage(w http.ResponseWriter, r *http.Request, imageID string) {
+	// Read the version first, so a concurrent replacement can only make
+	// the ETag older than the pixels, never newer
+	h.setETag(w, imageID)
+
+	// A client polling for changes needn't download the version it has
+	if etag := w.Header().Get("ETag"); etag != "" && etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
 
-	w.Header().Set("Content-Type", "image/png")
-	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"debug_%s.png\"", imageID))
-	w.Write(imageData)
+	ctx, cancel := withRouteTimeout(r, h.retrieveTimeout)
+	defer cancel()
+
+	if store, ok := h.store.(streamStore); ok {
+		h.streamImage(ctx, w, store, imageID)
+		return
+	}
+
+	var imageData []byte
+	var err error
+	if store, ok := h.store.(contextStore); ok {
+		imageData, err = store.RetrieveImageContext(ctx, imageID)
+	} else {
+		imageData, err = h.store.RetrieveImage(imageID)
+	}
+	if err != nil {
+		writeRetrieveError(w, imageID, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"%s.png\"", imageID))
+	w.Write(imageData)
+}
+
+// streamStore is implemented by stores that can write very large images
+// without holding them whole
+type streamStore interface {
+	StreamImage(ctx context.Context, w io.Writer, id string) error
+}
+
+// imageWriter sets the image response headers on the first write, so a
+// stream that fails before writing anything can still send an error
+type imageWriter struct {
+	w       http.ResponseWriter
+	imageID string
+	started bool
+}
+
+func (iw *imageWriter) Write(data []byte) (int, error) {
+	if !iw.started {
+		iw.started = true
+		iw.w.Header().Set("Content-Type", "image/png")
+		iw.w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"%s.png\"", iw.imageID))
+	}
+	return iw.w.Write(data)
+}
+
+// streamImage writes an image with StreamImage
+func (h *ImageHandler) streamImage(ctx context.Context, w http.ResponseWriter, store streamStore, imageID string) {
+	iw := &imageWriter{w: w, imageID: imageID}
+	err := store.StreamImage(ctx, iw, imageID)
+	if err == nil {
+		return
+	}
+	if iw.started {
+		// Headers are already sent, so the error can only be logged
+		log.Printf("Error streaming image %s: %v", imageID, err)
+		return
+	}
+	writeRetrieveError(w, imageID, err)
+}
+
+// writeRetrieveError writes the response for an image that couldn't be
+// retrieved
+func writeRetrieveError(w http.ResponseWriter, imageID string, err error) {
+	if isTimeout(err) {
+		writeTimeoutError(w, "Retrieving image "+imageID)
+		return
+	}
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	if strings.Contains(err.Error(), "not found") {
+		writeError(w, http.StatusNotFound, codeImageNotFound, "Image not found")
+		return
+	}
+	log.Printf("Error retrieving image %s: %v", imageID, err)
+	writeError(w, http.StatusInternalServerError, codeInternal, "Failed to retrieve image")
+}
+
+// deleteImage handles DELETE /images/{id}
+func (h *ImageHandler) deleteImage(w http.ResponseWriter, imageID string) {
+	err := h.store.DeleteImage(imageID)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			writeError(w, http.StatusNotFound, codeImageNotFound, "Image not found")
+			return
+		}
+		log.Printf("Error deleting image %s: %v", imageID, err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to delete image")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":   "success",
+		"image_id": imageID,
+		"message":  "Image deleted successfully",
+	})
+}
+
+// handleStats handles GET /stats
+func (h *ImageHandler) handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Gathering stats scans the store and can't be interrupted, so a slow
+	// scan finishes in the background while the client gets its answer
+	ctx, cancel := withRouteTimeout(r, h.statsTimeout)
+	defer cancel()
+	result := make(chan imagestore.StorageStats, 1)
+	go func() { result <- h.store.GetStorageStats() }()
+
+	var stats imagestore.StorageStats
+	select {
+	case stats = <-result:
+	case <-ctx.Done():
+		if isTimeout(ctx.Err()) {
+			writeTimeoutError(w, "Gathering stats")
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// statsHistoryStore is implemented by stores that keep daily stats snapshots
+type statsHistoryStore interface {
+	StatsHistory(from, to time.Time) ([]imagestore.StatsSnapshot, error)
+}
+
+// handleStatsHistory handles GET /stats/history?from=YYYY-MM-DD&to=YYYY-MM-DD,
+// returning the daily stats snapshots in that range and the trend over them.
+// Either end may be left out.
+func (h *ImageHandler) handleStatsHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	store, ok := h.store.(statsHistoryStore)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, codeNotSupported, "Stats history not supported by this store")
+		return
+	}
+
+	var bounds [2]time.Time
+	for i, name := range []string{"from", "to"} {
+		value := r.URL.Query().Get(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, name+" must be a date in YYYY-MM-DD form")
+			return
+		}
+		bounds[i] = parsed
+	}
+
+	snapshots, err := store.StatsHistory(bounds[0], bounds[1])
+	if err != nil {
+		log.Printf("Error reading stats history: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"snapshots": snapshots,
+		"trend":     imagestore.SummarizeStats(snapshots),
+	})
+}
+
+// dedupStore is implemented by stores that break deduplication down by group
+type dedupStore interface {
+	DedupBreakdown(grouping imagestore.DedupGrouping, depth int) (map[string]imagestore.DedupStats, error)
+}
+
+// handleStatsDedup handles GET /stats/dedup?group_by=prefix&depth=N or
+// ?group_by=tag, returning tile deduplication per ID prefix or per tag
+func (h *ImageHandler) handleStatsDedup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	store, ok := h.store.(dedupStore)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, codeNotSupported, "Dedup breakdown not supported by this store")
+		return
+	}
+
+	grouping := imagestore.DedupGrouping(r.URL.Query().Get("group_by"))
+	if grouping == "" {
+		grouping = imagestore.DedupByPrefix
+	}
+	if grouping != imagestore.DedupByPrefix && grouping != imagestore.DedupByTag {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "group_by must be prefix or tag")
+		return
+	}
+	depth := 1
+	if value := r.URL.Query().Get("depth"); value != "" && grouping == imagestore.DedupByPrefix {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "depth must be a positive integer")
+			return
+		}
+		depth = parsed
+	}
+
+	groups, err := store.DedupBreakdown(grouping, depth)
+	if err != nil {
+		log.Printf("Error breaking down dedup: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"group_by": grouping,
+		"groups":   groups,
+	})
+}
+
+// jobsStore is implemented by stores that report maintenance progress
+type jobsStore interface {
+	Jobs() []imagestore.JobProgress
+}
+
+// handleJobs handles GET /jobs, returning the progress of each maintenance
+// job's current or latest run
+func (h *ImageHandler) handleJobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	store, ok := h.store.(jobsStore)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, codeNotSupported, "Jobs not supported by this store")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"jobs": store.Jobs()})
+}
+
+// backupStore is implemented by stores that can back themselves up
+type backupStore interface {
+	Backup() (*imagestore.BackupInfo, error)
+	ListBackups(verify bool) ([]imagestore.BackupInfo, error)
+}
+
+// handleBackups handles GET /backups, listing the backups on the target
+// along with the state of scheduled backups, and POST /backups, taking a
+// backup now. GET /backups?verify=true reads each archive back to check
+// its checksum.
+func (h *ImageHandler) handleBackups(w http.ResponseWriter, r *http.Request) {
+	store, ok := h.store.(backupStore)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, codeNotSupported, "Backups not supported by this store")
+		return
+	}
+
+	var (
+		result interface{}
+		status = http.StatusOK
+		err    error
+	)
+	switch r.Method {
+	case http.MethodGet:
+		var backups []imagestore.BackupInfo
+		backups, err = store.ListBackups(r.URL.Query().Get("verify") == "true")
+		result = map[string]interface{}{
+			"status":  h.store.GetStorageStats().Backups,
+			"backups": backups,
+		}
+	case http.MethodPost:
+		result, err = store.Backup()
+		status = http.StatusCreated
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	if errors.Is(err, imagestore.ErrNoBackupTarget) {
+		writeError(w, http.StatusNotImplemented, codeNotSupported, "No backup target configured")
+		return
+	}
+	if err != nil {
+		log.Printf("Error handling backups: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(result)
+}
+
+// withRouteTimeout derives a request's context for a route with a timeout,
+// zero meaning none. The request's own context still cancels it.
+func withRouteTimeout(r *http.Request, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(r.Context())
+	}
+	return context.WithTimeout(r.Context(), timeout)
+}
+
+// isTimeout reports whether an operation failed because its route timed out
+func isTimeout(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded)
+}
+
+// writeTimeoutError answers a request whose route timeout passed before the
+// store finished
+func writeTimeoutError(w http.ResponseWriter, operation string) {
+	w.Header().Set("Retry-After", "1")
+	writeError(w, http.StatusServiceUnavailable, codeTimeout, operation+" took too long and was cancelled")
+}
+
+// handleMetrics handles GET /metrics, exposing storage statistics in the
+// Prometheus text format
+func (h *ImageHandler) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	stats := h.store.GetStorageStats()
+	var b strings.Builder
+
+	writeMetric(&b, "imagestore_images", "Live images.", float64(stats.TotalImages))
+	writeMetric(&b, "imagestore_tile_refs", "Tile references across live images.", float64(stats.TotalTiles))
+	writeMetric(&b, "imagestore_unique_tiles", "Distinct stored tiles.", float64(stats.UniqueTiles))
+	writeMetric(&b, "imagestore_original_bytes", "Size of the uploaded files of live images.", float64(stats.OriginalBytes))
+	writeMetric(&b, "imagestore_tile_bytes", "Compressed size of stored tiles.", float64(stats.StorageBytes))
+	writeMetric(&b, "imagestore_compression_ratio", "Original bytes divided by tile bytes.", stats.CompressionRatio)
+	writeMetric(&b, "imagestore_disk_bytes", "Database size on disk.", float64(stats.DiskBytes))
+	writeMetric(&b, "imagestore_expiring_images", "Live images with an expiration time.", float64(stats.ExpiringImages))
+
+	if queue := stats.WriteQueue; queue.MaxWriters > 0 {
+		writeMetric(&b, "imagestore_write_queue_active", "Image writes running.", float64(queue.Active))
+		writeMetric(&b, "imagestore_write_queue_depth", "Image writes waiting for a slot.", float64(queue.Queued))
+		writeCounter(&b, "imagestore_write_queue_admitted_total", "Image writes admitted from the queue.", float64(queue.Admitted))
+		writeCounter(&b, "imagestore_write_queue_rejected_total", "Image writes refused because the queue was full.", float64(queue.Rejected))
+		writeCounter(&b, "imagestore_write_queue_wait_seconds_total", "Time admitted image writes spent queued.", queue.TotalWait.Seconds())
+		writeMetric(&b, "imagestore_write_queue_max_wait_seconds", "Longest time an image write spent queued.", queue.MaxWait.Seconds())
+	}
+
+	if resources := stats.Resources; resources.MaxReconstructions > 0 {
+		writeMetric(&b, "imagestore_reconstructions_active", "Image reconstructions running.", float64(resources.ActiveReconstructions))
+		writeCounter(&b, "imagestore_reconstruction_wait_seconds_total", "Time reads spent waiting for a reconstruction slot.", resources.ReconstructionWait.Seconds())
+	}
+	if resources := stats.Resources; resources.MaxDecompressors > 0 {
+		writeMetric(&b, "imagestore_decompressors_active", "Tile decompressions running.", float64(resources.ActiveDecompressors))
+	}
+	writeCounter(&b, "imagestore_shared_retrievals_total", "Retrievals that shared a concurrent identical request's reconstruction.", float64(stats.Resources.SharedRetrievals))
+
+	if cache := stats.ImageCache; cache.MaxBytes > 0 {
+		writeMetric(&b, "imagestore_image_cache_bytes", "Encoded image bytes in the image cache.", float64(cache.Bytes))
+		writeMetric(&b, "imagestore_image_cache_entries", "Images in the image cache.", float64(cache.Entries))
+		writeCounter(&b, "imagestore_image_cache_hits_total", "Retrievals served from the image cache.", float64(cache.Hits))
+		writeCounter(&b, "imagestore_image_cache_misses_total", "Retrievals that reconstructed the image.", float64(cache.Misses))
+		writeCounter(&b, "imagestore_image_cache_evictions_total", "Images evicted from the image cache to make room.", float64(cache.Evictions))
+	}
+
+	if tier := stats.ColdTier; tier.Enabled {
+		writeMetric(&b, "imagestore_cold_tier_tiles", "Tiles held only in the cold tier.", float64(tier.ColdTiles))
+		writeCounter(&b, "imagestore_cold_tier_hot_reads_total", "Tile reads served from the local database.", float64(tier.HotReads))
+		writeCounter(&b, "imagestore_cold_tier_cold_reads_total", "Tile reads fetched from the cold tier.", float64(tier.ColdReads))
+		writeCounter(&b, "imagestore_cold_tier_fetch_errors_total", "Failed cold tier fetches.", float64(tier.FetchErrors))
+		writeCounter(&b, "imagestore_cold_tier_offloaded_total", "Tiles moved to the cold tier.", float64(tier.Offloaded))
+		writeMetric(&b, "imagestore_cold_tier_hit_ratio", "Share of tile reads served locally.", tier.HitRate)
+	}
+
+	if backups := stats.Backups; backups.Enabled {
+		writeCounter(&b, "imagestore_backups_succeeded_total", "Backups taken and verified.", float64(backups.Succeeded))
+		writeCounter(&b, "imagestore_backups_failed_total", "Backups that failed.", float64(backups.Failed))
+		writeMetric(&b, "imagestore_backups_kept", "Backups on the target after the latest rotation.", float64(backups.BackupsKept))
+		if last := backups.LastBackup; last != nil {
+			writeMetric(&b, "imagestore_backup_last_success_timestamp_seconds", "When the newest successful backup was taken.", float64(last.Time.Unix()))
+			writeMetric(&b, "imagestore_backup_last_bytes", "Archive size of the newest successful backup.", float64(last.Bytes))
+			writeMetric(&b, "imagestore_backup_last_duration_seconds", "Time the newest successful backup took.", last.Duration.Seconds())
+		}
+	}
+
+	if blobs := stats.Blobs; blobs.Files > 0 {
+		writeMetric(&b, "imagestore_blob_files", "Blob files holding large tile encodings.", float64(blobs.Files))
+		writeMetric(&b, "imagestore_blob_bytes", "Size of the blob files.", float64(blobs.Bytes))
+		writeMetric(&b, "imagestore_blob_tiles", "Tiles whose encoding is held in a blob file.", float64(blobs.Tiles))
+		writeMetric(&b, "imagestore_blob_garbage_bytes", "Blob file bytes no tile points to.", float64(blobs.GarbageBytes))
+		writeMetric(&b, "imagestore_blob_mapped_files", "Sealed blob files read through a memory map.", float64(blobs.MappedFiles))
+	}
+
+	buckets := make([]string, 0, len(stats.Buckets))
+	for name := range stats.Buckets {
+		buckets = append(buckets, name)
+	}
+	sort.Strings(buckets)
+	writeMetricFamily(&b, "imagestore_bucket_keys", "Keys per bucket.", "bucket", buckets, func(name string) float64 {
+		return float64(stats.Buckets[name].Keys)
+	})
+	writeMetricFamily(&b, "imagestore_bucket_value_bytes", "Total value size per bucket.", "bucket", buckets, func(name string) float64 {
+		return float64(stats.Buckets[name].ValueBytes)
+	})
+	writeMetricFamily(&b, "imagestore_bucket_avg_value_bytes", "Average value size per bucket.", "bucket", buckets, func(name string) float64 {
+		return stats.Buckets[name].AvgValueBytes
+	})
+
+	namespaces := make([]string, 0, len(stats.Namespaces))
+	for name := range stats.Namespaces {
+		namespaces = append(namespaces, name)
+	}
+	sort.Strings(namespaces)
+	writeMetricFamily(&b, "imagestore_namespace_images", "Live images per namespace.", "namespace", namespaces, func(name string) float64 {
+		return float64(stats.Namespaces[name].Images)
+	})
+	writeMetricFamily(&b, "imagestore_namespace_original_bytes", "Uploaded bytes per namespace.", "namespace", namespaces, func(name string) float64 {
+		return float64(stats.Namespaces[name].OriginalBytes)
+	})
+	writeMetricFamily(&b, "imagestore_namespace_exclusive_bytes", "Tile bytes referenced only by the namespace.", "namespace", namespaces, func(name string) float64 {
+		return float64(stats.Namespaces[name].ExclusiveBytes)
+	})
+
+	operations := make([]string, 0, len(stats.Operations))
+	for name := range stats.Operations {
+		operations = append(operations, name)
+	}
+	sort.Strings(operations)
+	writeMetricFamily(&b, "imagestore_operation_p50_seconds", "Median latency of recent operations.", "operation", operations, func(name string) float64 {
+		return stats.Operations[name].P50.Seconds()
+	})
+	writeMetricFamily(&b, "imagestore_operation_p99_seconds", "99th percentile latency of recent operations.", "operation", operations, func(name string) float64 {
+		return stats.Operations[name].P99.Seconds()
+	})
+	writeMetricFamily(&b, "imagestore_slow_operations", "Operations slower than the slow-operation threshold.", "operation", operations, func(name string) float64 {
+		return float64(stats.Operations[name].Slow)
+	})
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	io.WriteString(w, b.String())
+}
+
+// labelEscaper escapes Prometheus label values
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// writeMetric writes a single gauge sample with its help and type lines
+func writeMetric(b *strings.Builder, name, help string, value float64) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+	fmt.Fprintf(b, "%s %s\n", name, strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+// writeCounter writes a single counter sample with its help and type lines
+func writeCounter(b *strings.Builder, name, help string, value float64) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+	fmt.Fprintf(b, "%s %s\n", name, strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+// writeMetricFamily writes one gauge sample per label value
+func writeMetricFamily(b *strings.Builder, name, help, label string, values []string, value func(string) float64) {
+	if len(values) == 0 {
+		return
+	}
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+	for _, v := range values {
+		fmt.Fprintf(b, "%s{%s=\"%s\"} %s\n", name, label, labelEscaper.Replace(v), strconv.FormatFloat(value(v), 'g', -1, 64))
+	}
+}
+
+// handleClusters handles GET /clusters
+func (h *ImageHandler) handleClusters(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	type clusterStore interface {
+		Clusters() (*imagestore.ClusterResult, error)
+	}
+
+	store, ok := h.store.(clusterStore)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, codeNotSupported, "Clustering not supported by this store")
+		return
+	}
+
+	result, err := store.Clusters()
+	if err != nil {
+		log.Printf("Error clustering images: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to cluster images")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// handleHealth handles GET /health
+func (h *ImageHandler) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  "healthy",
+		"service": "imageencoder",
+	})
+}
+
+// handleDebugImage handles GET /debug/{id}
+func (h *ImageHandler) handleDebugImage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Extract image ID from path
+	path := strings.TrimPrefix(r.URL.Path, "/debug/")
+	if path == "" {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Missing image ID")
+		return
+	}
+
+	imageID := path
+
+	// Check if the store supports debug images
+	type debugImageStore interface {
+		RetrieveDebugImage(id string) ([]byte, error)
+	}
+
+	debugStore, ok := h.store.(debugImageStore)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, codeNotSupported, "Debug images not supported by this store")
+		return
+	}
+
+	imageData, err := debugStore.RetrieveDebugImage(imageID)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			writeError(w, http.StatusNotFound, codeImageNotFound, "Image not found")
+			return
+		}
+		log.Printf("Error retrieving debug image %s: %v", imageID, err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to retrieve debug image")
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"debug_%s.png\"", imageID))
+	w.Write(imageData)
+}
+
+// deleteImagesByTag handles DELETE /images?tag=
+func (h *ImageHandler) deleteImagesByTag(w http.ResponseWriter, r *http.Request) {
+	tag := r.URL.Query().Get("tag")
+	if tag == "" {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Missing tag")
+		return
+	}
+
+	store, ok := h.store.(tagStore)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, codeNotSupported, "Tags not supported by this store")
+		return
+	}
+
+	deleted, err := store.DeleteByTag(tag)
+	if err != nil {
+		log.Printf("Error deleting images tagged %s: %v", tag, err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to delete images")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  "success",
+		"tag":     tag,
+		"deleted": deleted,
+	})
+}
+
+// handleTags handles POST and DELETE /images/{id}/tags with a JSON body of
+// the form {"tags": ["nightly"]}
+func (h *ImageHandler) handleTags(w http.ResponseWriter, r *http.Request, imageID string) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		w.Header().Set("Allow", "POST, DELETE")
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	store, ok := h.store.(tagStore)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, codeNotSupported, "Tags not supported by this store")
+		return
+	}
+
+	var body struct {
+		Tags []string `json:"tags"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || len(body.Tags) == 0 {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Request body must be JSON with a non-empty tags list")
+		return
+	}
+
+	var err error
+	if r.Method == http.MethodPost {
+		err = store.AddTags(imageID, body.Tags...)
+	} else {
+		err = store.RemoveTags(imageID, body.Tags...)
+	}
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			writeError(w, http.StatusNotFound, codeImageNotFound, "Image not found")
+			return
+		}
+		if strings.Contains(err.Error(), "invalid tag") {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+			return
+		}
+		log.Printf("Error updating tags for image %s: %v", imageID, err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to update tags")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":   "success",
+		"image_id": imageID,
+		"message":  "Tags updated successfully",
+	})
+}
+
+// manifestStore is implemented by stores that accept uploads as tile
+// manifests, letting clients skip tiles the store already holds
+type manifestStore interface {
+	TileSize() int
+	MissingTiles(tileIDs []imagestore.TileID) ([]imagestore.TileID, error)
+	StoreManifest(id string, manifest imagestore.ImageManifest, tileData map[imagestore.TileID][]byte, opts imagestore.StoreOptions) error
+}
+
+// poolStore is implemented by stores that can keep tiles apart per
+// namespace, where a manifest upload negotiates against the tiles of its
+// namespace
+type poolStore interface {
+	MissingPoolTiles(namespace string, tileIDs []imagestore.TileID) ([]imagestore.TileID, error)
+}
+
+// handleMissingTiles handles POST /tiles/missing, the first step of a
+// manifest upload: the client sends its tile IDs and learns which ones it
+// has to upload. The caller names the namespace it uploads to, needs to be
+// able to read it, and only learns about that namespace's tiles.
+func (h *ImageHandler) handleMissingTiles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	store, ok := h.store.(manifestStore)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, codeNotSupported, "Manifest uploads not supported by this store")
+		return
+	}
+
+	var body struct {
+		TileSize  int                 `json:"tile_size"`
+		Tiles     []imagestore.TileID `json:"tiles"`
+		Namespace string              `json:"namespace"`
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxUploadBytes)
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Request body must be JSON with tile_size and tiles")
+		return
+	}
+
+	// Which tiles exist reveals content, so the caller must be able to read
+	// the namespace, and is only told about its own pool
+	if !requireRole(w, r, body.Namespace, roleReader) {
+		return
+	}
+	// Tile IDs naming a pool are only sent by sync, which reads across all
+	// namespaces
+	for _, tileID := range body.Tiles {
+		if strings.Contains(string(tileID), "/") {
+			if !requireRole(w, r, allNamespaces, roleReader) {
+				return
+			}
+			break
+		}
+	}
+
+	// A client tiling at a different size can't match anything; tell it the
+	// size to use instead
+	if body.TileSize != store.TileSize() {
+		writeErrorDetails(w, http.StatusConflict, codeTileSizeMismatch, "Tile size mismatch", map[string]int{
+			"tile_size": store.TileSize(),
+		})
+		return
+	}
+
+	var missing []imagestore.TileID
+	var err error
+	if pools, ok := h.store.(poolStore); ok {
+		missing, err = pools.MissingPoolTiles(body.Namespace, body.Tiles)
+	} else {
+		missing, err = store.MissingTiles(body.Tiles)
+	}
+	if err != nil {
+		log.Printf("Error checking for missing tiles: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"tile_size": store.TileSize(),
+		"missing":   missing,
+	})
+}
+
+// handleManifest handles POST /images/{id}/manifest, the second step of a
+// manifest upload. The multipart form carries the manifest JSON in a
+// "manifest" field and raw RGB data for each missing tile in a "tile" file
+// named by its tile ID.
+func (h *ImageHandler) handleManifest(w http.ResponseWriter, r *http.Request, imageID string) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	store, ok := h.store.(manifestStore)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, codeNotSupported, "Manifest uploads not supported by this store")
+		return
+	}
+
+	if !h.parseUploadForm(w, r) {
+		return
+	}
+
+	var manifest imagestore.ImageManifest
+	if err := json.Unmarshal([]byte(r.FormValue("manifest")), &manifest); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Missing or invalid manifest field")
+		return
+	}
+
+	opts, err := parseStoreOptions(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+
+	tileData := make(map[imagestore.TileID][]byte)
+	for _, fileHeader := range r.MultipartForm.File["tile"] {
+		file, err := fileHeader.Open()
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Failed to read tile")
+			return
+		}
+		data, err := io.ReadAll(file)
+		file.Close()
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Failed to read tile")
+			return
+		}
+		tileData[imagestore.TileID(fileHeader.Filename)] = data
+	}
+
+	err = store.StoreManifest(imageID, manifest, tileData, opts)
+	if err != nil {
+		switch {
+		case strings.Contains(err.Error(), "missing tile"):
+			// A tile was collected since negotiation; the client should retry
+			writeError(w, http.StatusConflict, codeTilesMissing, err.Error())
+		case strings.Contains(err.Error(), "invalid manifest"):
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		default:
+			writeStoreError(w, imageID, err)
+		}
+		return
+	}
+
+	h.setETag(w, imageID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":         "success",
+		"image_id":       imageID,
+		"tiles_uploaded": len(tileData),
+		"message":        "Image stored successfully",
+	})
+}
+
+// cloneStore is implemented by stores that can copy an image without
+// copying its tiles
+type cloneStore interface {
+	CloneImage(srcID, dstID string) error
+}
+
+// handleClone handles POST /images/{id}/clone with a JSON body of
+// {"id": "<new id>"}
+func (h *ImageHandler) handleClone(w http.ResponseWriter, r *http.Request, imageID string) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	store, ok := h.store.(cloneStore)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, codeNotSupported, "Cloning not supported by this store")
+		return
+	}
+
+	var request struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.ID == "" {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, `Request body must be {"id": "<new image id>"}`)
+		return
+	}
+	if !requireRole(w, r, imagestore.Namespace(request.ID), roleWriter) {
+		return
+	}
+
+	if err := store.CloneImage(imageID, request.ID); err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			writeError(w, http.StatusNotFound, codeImageNotFound, "Image not found")
+			return
+		}
+		if strings.Contains(err.Error(), "already exists") {
+			writeError(w, http.StatusConflict, codeImageExists, "An image with this ID already exists")
+			return
+		}
+		if strings.Contains(err.Error(), "onto itself") {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+			return
+		}
+		writeStoreError(w, request.ID, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":   "success",
+		"image_id": request.ID,
+		"source":   imageID,
+		"message":  "Image cloned successfully",
+	})
+}
+
+// animationStore is implemented by stores that keep animations as frame
+// sequences
+type animationStore interface {
+	StoreAnimation(id string, data []byte, opts imagestore.StoreOptions) (*imagestore.Animation, error)
+	GetAnimation(id string) (*imagestore.Animation, error)
+	RetrieveAnimation(id string) ([]byte, error)
+	DeleteAnimation(id string) error
+}
+
+// handleAnimation handles /images/{id}/animation. POST stores an animated
+// GIF or PNG uploaded as the "image" file, GET returns it re-encoded as an
+// animated PNG (or its frame list with ?format=json), and DELETE removes it
+// with its frames.
+func (h *ImageHandler) handleAnimation(w http.ResponseWriter, r *http.Request, imageID string) {
+	store, ok := h.store.(animationStore)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, codeNotSupported, "Animations not supported by this store")
+		return
+	}
+
+	var animation *imagestore.Animation
+	var err error
+	status := http.StatusOK
+	switch r.Method {
+	case http.MethodPost:
+		if !h.parseUploadForm(w, r) {
+			return
+		}
+		files := r.MultipartForm.File["image"]
+		if len(files) == 0 {
+			writeError(w, http.StatusBadRequest, codeMissingFile, "Missing image file")
+			return
+		}
+		switch files[0].Header.Get("Content-Type") {
+		case "image/gif", "image/png", "image/apng":
+		default:
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid animation type. Supported: GIF, animated PNG")
+			return
+		}
+		data, uploadStatus, uploadErr := h.readUploadedFile(files[0])
+		if uploadErr != nil {
+			writeError(w, uploadStatus, uploadErrorCode(uploadStatus), uploadErr.Error())
+			return
+		}
+		opts, optsErr := parseStoreOptions(r)
+		if optsErr != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, optsErr.Error())
+			return
+		}
+		animation, err = store.StoreAnimation(imageID, data, opts)
+		status = http.StatusCreated
+	case http.MethodGet:
+		if r.URL.Query().Get("format") != "json" {
+			data, err := store.RetrieveAnimation(imageID)
+			if err != nil {
+				if strings.Contains(err.Error(), "not found") {
+					writeError(w, http.StatusNotFound, codeAnimationNotFound, "Animation not found")
+					return
+				}
+				log.Printf("Error retrieving animation %s: %v", imageID, err)
+				writeError(w, http.StatusInternalServerError, codeInternal, "Failed to retrieve animation")
+				return
+			}
+			w.Header().Set("Content-Type", "image/apng")
+			w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"%s.png\"", imageID))
+			w.Write(data)
+			return
+		}
+		animation, err = store.GetAnimation(imageID)
+	case http.MethodDelete:
+		if err := store.DeleteAnimation(imageID); err != nil {
+			writeAnimationError(w, imageID, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"status":   "success",
+			"image_id": imageID,
+			"message":  "Animation deleted successfully",
+		})
+		return
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if err != nil {
+		writeAnimationError(w, imageID, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(animation)
+}
+
+// handlePDF handles POST /images/{id}/pdf, storing each page of an uploaded
+// PDF as the image {id}/pages/{n}
+func (h *ImageHandler) handlePDF(w http.ResponseWriter, r *http.Request, imageID string) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if h.pdfImporter == nil {
+		writeError(w, http.StatusNotImplemented, codeNotSupported, "PDF ingestion is not configured")
+		return
+	}
+
+	if !h.parseUploadForm(w, r) {
+		return
+	}
+	files := r.MultipartForm.File["file"]
+	if len(files) == 0 {
+		writeError(w, http.StatusBadRequest, codeMissingFile, "Missing PDF file")
+		return
+	}
+	data, status, err := h.readUploadedFile(files[0])
+	if err != nil {
+		writeError(w, status, uploadErrorCode(status), err.Error())
+		return
+	}
+
+	result, err := h.pdfImporter.Import(r.Context(), imageID, data)
+	if err != nil {
+		if strings.Contains(err.Error(), "invalid PDF") {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+			return
+		}
+		log.Printf("Error importing PDF %s: %v", imageID, err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to import PDF")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":   "success",
+		"image_id": imageID,
+		"pages":    result.Pages,
+	})
+}
+
+// writeAnimationError maps an animation error to a response
+func writeAnimationError(w http.ResponseWriter, imageID string, err error) {
+	switch {
+	case strings.Contains(err.Error(), "not found"):
+		writeError(w, http.StatusNotFound, codeAnimationNotFound, "Animation not found")
+	case strings.Contains(err.Error(), "invalid animation"):
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+	default:
+		writeStoreError(w, imageID, err)
+	}
+}
+
+// sourceInfoStore is implemented by stores that record upload EXIF and ICC
+// metadata
+type sourceInfoStore interface {
+	GetSourceInfo(id string) (*imagestore.SourceInfo, error)
+}
+
+// handleSourceInfo handles GET /images/{id}/source, reporting the EXIF
+// orientation, capture time and camera of the upload and the size of its
+// ICC profile
+func (h *ImageHandler) handleSourceInfo(w http.ResponseWriter, r *http.Request, imageID string) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	store, ok := h.store.(sourceInfoStore)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, codeNotSupported, "Source metadata not supported by this store")
+		return
+	}
+
+	info, err := store.GetSourceInfo(imageID)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			writeError(w, http.StatusNotFound, codeImageNotFound, "Image not found")
+			return
+		}
+		log.Printf("Error retrieving source metadata for image %s: %v", imageID, err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to retrieve source metadata")
+		return
+	}
+
+	response := map[string]interface{}{"image_id": imageID}
+	if info != nil {
+		if info.Orientation != 0 {
+			response["orientation"] = info.Orientation
+		}
+		if info.TakenAt != nil {
+			response["taken_at"] = info.TakenAt
+		}
+		if info.Make != "" {
+			response["make"] = info.Make
+		}
+		if info.Model != "" {
+			response["model"] = info.Model
+		}
+		if info.DPI != 0 {
+			response["dpi"] = info.DPI
+		}
+		response["icc_profile_bytes"] = len(info.ICCProfile)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// annotationStore is implemented by stores that keep vector annotations
+// alongside images
+type annotationStore interface {
+	SetAnnotations(id string, annotations []imagestore.Annotation) error
+	GetAnnotations(id string) ([]imagestore.Annotation, error)
+	RetrieveAnnotatedImage(id string) ([]byte, error)
+}
+
+// handleAnnotations handles GET, PUT and DELETE /images/{id}/annotations.
+// PUT replaces the annotations with a JSON array; DELETE removes them all.
+func (h *ImageHandler) handleAnnotations(w http.ResponseWriter, r *http.Request, imageID string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPut && r.Method != http.MethodDelete {
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	store, ok := h.store.(annotationStore)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, codeNotSupported, "Annotations not supported by this store")
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		var annotations []imagestore.Annotation
+		if r.Method == http.MethodPut {
+			if err := json.NewDecoder(r.Body).Decode(&annotations); err != nil {
+				writeError(w, http.StatusBadRequest, codeInvalidRequest, "Request body must be a JSON array of annotations")
+				return
+			}
+		}
+
+		if err := store.SetAnnotations(imageID, annotations); err != nil {
+			switch {
+			case strings.Contains(err.Error(), "not found"):
+				writeError(w, http.StatusNotFound, codeImageNotFound, "Image not found")
+			case strings.Contains(err.Error(), "invalid annotation"):
+				writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+			default:
+				writeStoreError(w, imageID, err)
+			}
+			return
+		}
+	}
+
+	annotations, err := store.GetAnnotations(imageID)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			writeError(w, http.StatusNotFound, codeImageNotFound, "Image not found")
+			return
+		}
+		log.Printf("Error retrieving annotations for image %s: %v", imageID, err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to retrieve annotations")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"image_id":    imageID,
+		"annotations": annotations,
+	})
+}
+
+// redactionStore is implemented by stores that can hide regions of images
+// at retrieval
+type redactionStore interface {
+	SetRedactions(id string, redactions []imagestore.Redaction) error
+	GetRedactions(id string) ([]imagestore.Redaction, error)
+}
+
+// handleRedactions handles GET, PUT and DELETE /images/{id}/redactions.
+// PUT replaces the redactions with a JSON array; DELETE removes them all.
+func (h *ImageHandler) handleRedactions(w http.ResponseWriter, r *http.Request, imageID string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPut && r.Method != http.MethodDelete {
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	store, ok := h.store.(redactionStore)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, codeNotSupported, "Redactions not supported by this store")
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		var redactions []imagestore.Redaction
+		if r.Method == http.MethodPut {
+			if err := json.NewDecoder(r.Body).Decode(&redactions); err != nil {
+				writeError(w, http.StatusBadRequest, codeInvalidRequest, "Request body must be a JSON array of redactions")
+				return
+			}
+		}
+
+		if err := store.SetRedactions(imageID, redactions); err != nil {
+			switch {
+			case strings.Contains(err.Error(), "not found"):
+				writeError(w, http.StatusNotFound, codeImageNotFound, "Image not found")
+			case strings.Contains(err.Error(), "invalid redaction"):
+				writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+			default:
+				writeStoreError(w, imageID, err)
+			}
+			return
+		}
+	}
+
+	redactions, err := store.GetRedactions(imageID)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			writeError(w, http.StatusNotFound, codeImageNotFound, "Image not found")
+			return
+		}
+		log.Printf("Error retrieving redactions for image %s: %v", imageID, err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to retrieve redactions")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"image_id":   imageID,
+		"redactions": redactions,
+	})
+}
+
+// retrieveAnnotatedImage handles GET /images/{id}?annotations=true,
+// returning the image with its annotations drawn over it
+func (h *ImageHandler) retrieveAnnotatedImage(w http.ResponseWriter, imageID string) {
+	store, ok := h.store.(annotationStore)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, codeNotSupported, "Annotations not supported by this store")
+		return
+	}
+
+	imageData, err := store.RetrieveAnnotatedImage(imageID)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			writeError(w, http.StatusNotFound, codeImageNotFound, "Image not found")
+			return
+		}
+		log.Printf("Error retrieving annotated image %s: %v", imageID, err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to retrieve image")
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"%s.png\"", imageID))
+	w.Write(imageData)
+}
+
+// composeStore is implemented by stores that can assemble an image from
+// regions of stored ones
+type composeStore interface {
+	ComposeImage(id string, width, height int, regions []imagestore.ComposeRegion, opts imagestore.StoreOptions) (*imagestore.ComposeResult, error)
+}
+
+// handleCompose handles POST /images/{id}/compose with a JSON body of
+// {"width": w, "height": h, "regions": [...]}. Expiry headers apply as for
+// uploads.
+func (h *ImageHandler) handleCompose(w http.ResponseWriter, r *http.Request, imageID string) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	store, ok := h.store.(composeStore)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, codeNotSupported, "Composition not supported by this store")
+		return
+	}
+
+	var request struct {
+		Width   int                        `json:"width"`
+		Height  int                        `json:"height"`
+		Regions []imagestore.ComposeRegion `json:"regions"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid JSON body")
+		return
+	}
+	for _, region := range request.Regions {
+		if !requireRole(w, r, imagestore.Namespace(region.Source), roleReader) {
+			return
+		}
+	}
+
+	opts, err := parseStoreOptions(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+
+	result, err := store.ComposeImage(imageID, request.Width, request.Height, request.Regions, opts)
+	if err != nil {
+		switch {
+		case strings.Contains(err.Error(), "not found"):
+			writeError(w, http.StatusNotFound, codeImageNotFound, err.Error())
+		case strings.Contains(err.Error(), "invalid composition"):
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		default:
+			writeStoreError(w, imageID, err)
+		}
+		return
+	}
+
+	h.setETag(w, imageID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":         "success",
+		"image_id":       imageID,
+		"tiles_reused":   result.TilesReused,
+		"tiles_rendered": result.TilesRendered,
+		"new_tiles":      result.NewTiles,
+	})
+}
+
+// patchStore is implemented by stores that can update part of an image in
+// place
+type patchStore interface {
+	PatchImage(id string, patchData []byte, x, y int) (*imagestore.PatchResult, error)
+}
+
+// handlePatchRegion handles PATCH /images/{id}/region. The multipart form
+// carries the patch in an "image" file and its offset in "x" and "y" fields.
+func (h *ImageHandler) handlePatchRegion(w http.ResponseWriter, r *http.Request, imageID string) {
+	if r.Method != http.MethodPatch {
+		w.Header().Set("Allow", "PATCH")
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	store, ok := h.store.(patchStore)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, codeNotSupported, "Region patches not supported by this store")
+		return
+	}
+
+	if !h.parseUploadForm(w, r) {
+		return
+	}
+
+	files := r.MultipartForm.File["image"]
+	if len(files) == 0 {
+		writeError(w, http.StatusBadRequest, codeMissingFile, "Missing image file")
+		return
+	}
+
+	x, errX := strconv.Atoi(r.FormValue("x"))
+	y, errY := strconv.Atoi(r.FormValue("y"))
+	if errX != nil || errY != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "x and y must be integer pixel offsets")
+		return
+	}
+
+	patchData, status, err := h.readUploadedImage(files[0])
+	if err != nil {
+		writeError(w, status, uploadErrorCode(status), err.Error())
+		return
+	}
+
+	result, err := store.PatchImage(imageID, patchData, x, y)
+	if err != nil {
+		switch {
+		case strings.Contains(err.Error(), "not found"):
+			writeError(w, http.StatusNotFound, codeImageNotFound, "Image not found")
+		case errors.Is(err, imagestore.ErrUnsupportedFormat):
+			writeError(w, http.StatusUnsupportedMediaType, codeInvalidImageFormat, err.Error())
+		case strings.Contains(err.Error(), "invalid patch"), strings.Contains(err.Error(), "failed to decode"):
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		default:
+			writeStoreError(w, imageID, err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":         "success",
+		"image_id":       imageID,
+		"tiles_affected": result.TilesAffected,
+		"tiles_changed":  result.TilesChanged,
+		"new_tiles":      result.NewTiles,
+	})
+}
+
+// usageStore is implemented by stores that can account storage per image
+type usageStore interface {
+	ImageUsage(id string) (*imagestore.ImageUsage, error)
+}
+
+// handleImageUsage handles GET /images/{id}/usage, reporting the bytes an
+// image stores and how many deleting it would free
+func (h *ImageHandler) handleImageUsage(w http.ResponseWriter, r *http.Request, imageID string) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	store, ok := h.store.(usageStore)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, codeNotSupported, "Usage not supported by this store")
+		return
+	}
+
+	usage, err := store.ImageUsage(imageID)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			writeError(w, http.StatusNotFound, codeImageNotFound, "Image not found")
+			return
+		}
+		log.Printf("Error computing usage of image %s: %v", imageID, err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(usage)
+}
+
+// historyStore is implemented by stores that keep an audit log per image
+type historyStore interface {
+	History(id string) ([]imagestore.AuditEntry, error)
+}
+
+// handleHistory handles GET /images/{id}/history, listing everything done to
+// the image, oldest first. Deleted images keep their history.
+func (h *ImageHandler) handleHistory(w http.ResponseWriter, r *http.Request, imageID string) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	store, ok := h.store.(historyStore)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, codeNotSupported, "History not supported by this store")
+		return
+	}
+
+	history, err := store.History(imageID)
+	if err != nil {
+		log.Printf("Error reading history of image %s: %v", imageID, err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+	if len(history) == 0 {
+		writeError(w, http.StatusNotFound, codeImageNotFound, "Image not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":      imageID,
+		"history": history,
+	})
+}
+
+// handleSignedURL handles GET /images/{id}/signed-url, minting a URL that
+// retrieves the image without a token for expires_in seconds (default an
+// hour). Retrieval parameters such as annotations=true are fixed in the URL.
+func (h *ImageHandler) handleSignedURL(w http.ResponseWriter, r *http.Request, imageID string) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	lifetime := time.Hour
+	if value := r.URL.Query().Get("expires_in"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds <= 0 || time.Duration(seconds)*time.Second > maxSignedURLLifetime {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest,
+				fmt.Sprintf("expires_in must be between 1 and %d seconds", int(maxSignedURLLifetime.Seconds())))
+			return
+		}
+		lifetime = time.Duration(seconds) * time.Second
+	}
+
+	if store, ok := h.store.(etagStore); ok {
+		if _, err := store.ImageETag(imageID); err != nil {
+			writeError(w, http.StatusNotFound, codeImageNotFound, "Image not found")
+			return
+		}
+	}
+
+	expires := time.Now().Add(lifetime)
+	var signed string
+	var err error
+	if h.authorizer != nil {
+		signed, err = h.authorizer.SignURL("/images/"+imageID, r.URL.Query(), expires)
+	}
+	if h.authorizer == nil || errors.Is(err, errSigningDisabled) {
+		writeError(w, http.StatusNotImplemented, codeNotSupported, "Signed URLs need auth.signing_key to be set")
+		return
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"url":        scheme + "://" + r.Host + signed,
+		"path":       signed,
+		"expires_at": expires.UTC().Format(time.RFC3339),
+	})
+}
+
+// tileInspector is implemented by stores that can describe individual tiles
+type tileInspector interface {
+	InspectTile(tileID imagestore.TileID) (*imagestore.TileInfo, error)
+	TilePNG(tileID imagestore.TileID) ([]byte, error)
+	TileReferences(tileID imagestore.TileID) ([]imagestore.TileReference, error)
+}
+
+// handleTile handles GET /tiles/{id}, returning the tile as a PNG with
+// headers describing how it is stored, and GET /tiles/{id}/refs, listing the
+// images that use it
+func (h *ImageHandler) handleTile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	store, ok := h.store.(tileInspector)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, codeNotSupported, "Tile inspection not supported by this store")
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/tiles/")
+	id, refs := strings.CutSuffix(path, "/refs")
+	tileID := imagestore.TileID(id)
+	if tileID == "" || strings.Contains(id, "/") {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid tile path")
+		return
+	}
+
+	if refs {
+		references, err := store.TileReferences(tileID)
+		if err != nil {
+			if strings.Contains(err.Error(), "not found") {
+				writeError(w, http.StatusNotFound, codeTileNotFound, "Tile not found")
+				return
+			}
+			log.Printf("Error listing references to tile %s: %v", tileID, err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"tile":       tileID,
+			"references": references,
+		})
+		return
+	}
+
+	info, err := store.InspectTile(tileID)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			writeError(w, http.StatusNotFound, codeTileNotFound, "Tile not found")
+			return
+		}
+		log.Printf("Error inspecting tile %s: %v", tileID, err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	pngData, err := store.TilePNG(tileID)
+	if err != nil {
+		log.Printf("Error rendering tile %s: %v", tileID, err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("X-Tile-Codec", info.Codec)
+	w.Header().Set("X-Tile-Stored-Bytes", strconv.Itoa(info.StoredBytes))
+	w.Header().Set("X-Tile-Raw-Bytes", strconv.Itoa(info.RawBytes))
+	w.Header().Set("X-Tile-References", strconv.Itoa(info.References))
+	w.Header().Set("X-Tile-Images", strconv.Itoa(info.Images))
+	w.Write(pngData)
+}
+
+// orphanStore is implemented by stores that can find and delete tiles no
+// image references
+type orphanStore interface {
+	FindOrphanedTiles() (*imagestore.OrphanReport, error)
+	PurgeOrphanedTiles() (*imagestore.OrphanReport, error)
+}
+
+// handleOrphanedTiles handles GET /tiles/orphans, reporting unreferenced tiles
+// and the space they hold, and POST /tiles/orphans/purge, deleting them
+func (h *ImageHandler) handleOrphanedTiles(w http.ResponseWriter, r *http.Request) {
+	purge := r.URL.Path == "/tiles/orphans/purge"
+	method := http.MethodGet
+	if purge {
+		method = http.MethodPost
+	}
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	store, ok := h.store.(orphanStore)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, codeNotSupported, "Orphan reports not supported by this store")
+		return
+	}
+
+	var report *imagestore.OrphanReport
+	var err error
+	if purge {
+		report, err = store.PurgeOrphanedTiles()
+	} else {
+		report, err = store.FindOrphanedTiles()
+	}
+	if err != nil {
+		if errors.Is(err, imagestore.ErrReadOnly) {
+			writeError(w, http.StatusForbidden, codeReadOnly, "Store is read-only")
+			return
+		}
+		log.Printf("Error scanning for orphaned tiles: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"total_tiles":  report.TotalTiles,
+		"orphaned":     report.Orphaned,
+		"orphan_bytes": report.OrphanBytes,
+		"purged":       report.Purged,
+	})
+}
+
+// syncStore is implemented by stores that can exchange manifests and tiles
+// with another instance
+type syncStore interface {
+	ManifestDigests() (map[string]string, error)
+	GetManifest(id string) (*imagestore.StoredImage, error)
+	ImportManifest(storedImage *imagestore.StoredImage) error
+	ExportTile(tileID imagestore.TileID) ([]byte, error)
+	ImportTile(tileID imagestore.TileID, payload []byte) error
+}
+
+// handleSyncManifests handles GET /sync/manifests, listing the manifest
+// digest of every image
+func (h *ImageHandler) handleSyncManifests(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	store, ok := h.store.(syncStore)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, codeNotSupported, "Sync not supported by this store")
+		return
+	}
+
+	digests, err := store.ManifestDigests()
+	if err != nil {
+		log.Printf("Error listing manifest digests: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"manifests": digests,
+	})
+}
+
+// handleSyncManifest handles GET and PUT /sync/manifests/{id}
+func (h *ImageHandler) handleSyncManifest(w http.ResponseWriter, r *http.Request) {
+	store, ok := h.store.(syncStore)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, codeNotSupported, "Sync not supported by this store")
+		return
+	}
+
+	imageID := strings.TrimPrefix(r.URL.Path, "/sync/manifests/")
+	if imageID == "" {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Missing image ID")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		storedImage, err := store.GetManifest(imageID)
+		if err != nil {
+			writeError(w, http.StatusNotFound, codeImageNotFound, "Image not found")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(storedImage)
+
+	case http.MethodPut:
+		var storedImage imagestore.StoredImage
+		r.Body = http.MaxBytesReader(w, r.Body, h.maxUploadBytes)
+		if err := json.NewDecoder(r.Body).Decode(&storedImage); err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Request body must be a JSON manifest")
+			return
+		}
+		storedImage.ID = imageID
+
+		if err := store.ImportManifest(&storedImage); err != nil {
+			if strings.Contains(err.Error(), "missing tile") {
+				writeError(w, http.StatusConflict, codeTilesMissing, err.Error())
+				return
+			}
+			if strings.Contains(err.Error(), "invalid manifest") {
+				writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+				return
+			}
+			writeStoreError(w, imageID, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// handleSyncTile handles GET and PUT /sync/tiles/{id}. Tiles travel as raw
+// RGB data compressed with plain zstd.
+func (h *ImageHandler) handleSyncTile(w http.ResponseWriter, r *http.Request) {
+	store, ok := h.store.(syncStore)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, codeNotSupported, "Sync not supported by this store")
+		return
+	}
+
+	tileID := imagestore.TileID(strings.TrimPrefix(r.URL.Path, "/sync/tiles/"))
+	if tileID == "" {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Missing tile ID")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		payload, err := store.ExportTile(tileID)
+		if err != nil {
+			if strings.Contains(err.Error(), "not found") {
+				writeError(w, http.StatusNotFound, codeTileNotFound, "Tile not found")
+				return
+			}
+			log.Printf("Error exporting tile %s: %v", tileID, err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+			return
+		}
+		w.Header().Set("Content-Type", "application/zstd")
+		w.Write(payload)
+
+	case http.MethodPut:
+		payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, h.maxUploadBytes))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Failed to read tile")
+			return
+		}
+		if err := store.ImportTile(tileID, payload); err != nil {
+			if strings.Contains(err.Error(), "invalid tile") {
+				writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+				return
+			}
+			if errors.Is(err, imagestore.ErrStorageFull) {
+				writeError(w, http.StatusInsufficientStorage, codeStorageFull, err.Error())
+				return
+			}
+			log.Printf("Error importing tile %s: %v", tileID, err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// metadataStore is implemented by stores that support image metadata
+type metadataStore interface {
+	SetMetadata(id string, metadata map[string]string) error
+	GetMetadata(id string) (map[string]string, error)
+}
+
+// handleMetadata handles GET and PATCH /images/{id}/metadata. PATCH merges a
+// JSON object of string values; empty values remove keys.
+func (h *ImageHandler) handleMetadata(w http.ResponseWriter, r *http.Request, imageID string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPatch {
+		w.Header().Set("Allow", "GET, PATCH")
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	store, ok := h.store.(metadataStore)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, codeNotSupported, "Metadata not supported by this store")
+		return
+	}
+
+	if r.Method == http.MethodPatch {
+		var metadata map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&metadata); err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Request body must be a JSON object of string values")
+			return
+		}
+
+		if err := store.SetMetadata(imageID, metadata); err != nil {
+			if strings.Contains(err.Error(), "not found") {
+				writeError(w, http.StatusNotFound, codeImageNotFound, "Image not found")
+				return
+			}
+			log.Printf("Error updating metadata for image %s: %v", imageID, err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Failed to update metadata")
+			return
+		}
+	}
+
+	metadata, err := store.GetMetadata(imageID)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			writeError(w, http.StatusNotFound, codeImageNotFound, "Image not found")
+			return
+		}
+		log.Printf("Error retrieving metadata for image %s: %v", imageID, err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to retrieve metadata")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"image_id": imageID,
+		"metadata": metadata,
+	})
+}
+
+// handleSearch handles GET /search?q=
+func (h *ImageHandler) handleSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	type searchStore interface {
+		Search(query string) ([]string, error)
+	}
+
+	store, ok := h.store.(searchStore)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, codeNotSupported, "Search not supported by this store")
+		return
+	}
+
+	query := r.URL.Query().Get("q")
+	imageIDs, err := store.Search(query)
+	if err != nil {
+		if strings.Contains(err.Error(), "invalid query") {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Query must contain at least one letter or digit")
+			return
+		}
+		log.Printf("Error searching for %q: %v", query, err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"query":  query,
+		"images": imageIDs,
+		"count":  len(imageIDs),
+	})
+}
+
+// handleExport handles GET /export?mode=images|raw&format=tar|oci, streaming
+// the store as a tar archive or an OCI image layout
+func (h *ImageHandler) handleExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	type exportStore interface {
+		ExportTar(w io.Writer, mode imagestore.ExportMode) error
+		ExportOCI(w io.Writer, mode imagestore.ExportMode) error
+	}
+
+	store, ok := h.store.(exportStore)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, codeNotSupported, "Export not supported by this store")
+		return
+	}
+
+	modeName := r.URL.Query().Get("mode")
+	if modeName == "" {
+		modeName = string(imagestore.ExportImages)
+	}
+	mode, err := imagestore.ParseExportMode(modeName)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+
+	export := store.ExportTar
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "tar":
+	case "oci":
+		export = store.ExportOCI
+	default:
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("invalid export format: %s", format))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-tar")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=imagestore-%s.tar", mode))
+
+	// Headers are already sent once streaming starts, so errors can only be logged
+	if err := export(w, mode); err != nil {
+		log.Printf("Error exporting store: %v", err)
+	}
+}
+
+// maxExportWorkers bounds the workers a batch export may ask for
+const maxExportWorkers = 64
+
+// handleExportImages handles POST /export/images with a JSON body of
+// {"ids": [...], "workers": N}, streaming the images as a tar archive
+// reconstructed N at a time
+func (h *ImageHandler) handleExportImages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	type batchExportStore interface {
+		ExportImagesTar(ctx context.Context, w io.Writer, ids []string, workers int) error
+	}
+
+	store, ok := h.store.(batchExportStore)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, codeNotSupported, "Batch export not supported by this store")
+		return
+	}
+
+	var request struct {
+		IDs     []string `json:"ids"`
+		Workers int      `json:"workers"`
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxUploadBytes)
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || len(request.IDs) == 0 {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, `Request body must be {"ids": ["<image id>", ...]}`)
+		return
+	}
+	if request.Workers < 0 || request.Workers > maxExportWorkers {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("workers must be between 0 and %d", maxExportWorkers))
+		return
+	}
+	for _, id := range request.IDs {
+		if !requireRole(w, r, imagestore.Namespace(id), roleReader) {
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/x-tar")
+	w.Header().Set("Content-Disposition", "attachment; filename=images.tar")
+
+	// Headers are already sent once streaming starts, so errors can only be logged
+	if err := store.ExportImagesTar(r.Context(), w, request.IDs, request.Workers); err != nil {
+		log.Printf("Error exporting images: %v", err)
+	}
+}
+
+// trashStore is implemented by stores that support soft deletion
+type trashStore interface {
+	UndeleteImage(id string) error
+	ListTrash() ([]string, error)
+	PurgeTrash() (int, error)
+}
+
+// handleRestore handles POST /images/{id}/restore
+func (h *ImageHandler) handleRestore(w http.ResponseWriter, r *http.Request, imageID string) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	store, ok := h.store.(trashStore)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, codeNotSupported, "Trash not supported by this store")
+		return
+	}
+
+	err := store.UndeleteImage(imageID)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			writeError(w, http.StatusNotFound, codeImageNotFound, "Trashed image not found")
+			return
+		}
+		if strings.Contains(err.Error(), "already exists") {
+			writeError(w, http.StatusConflict, codeImageExists, "An image with this ID already exists")
+			return
+		}
+		log.Printf("Error restoring image %s: %v", imageID, err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to restore image")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":   "success",
+		"image_id": imageID,
+		"message":  "Image restored successfully",
+	})
+}
+
+// handleTrash handles GET /trash
+func (h *ImageHandler) handleTrash(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	store, ok := h.store.(trashStore)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, codeNotSupported, "Trash not supported by this store")
+		return
+	}
+
+	imageIDs, err := store.ListTrash()
+	if err != nil {
+		log.Printf("Error listing trash: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"images": imageIDs,
+		"count":  len(imageIDs),
+	})
+}
+
+// handleTrashPurge handles POST /trash/purge
+func (h *ImageHandler) handleTrashPurge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	store, ok := h.store.(trashStore)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, codeNotSupported, "Trash not supported by this store")
+		return
+	}
+
+	purged, err := store.PurgeTrash()
+	if err != nil {
+		log.Printf("Error purging trash: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to purge trash")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "success",
+		"purged": purged,
+	})
+}
+
+// retentionStore is implemented by stores that enforce retention policies
+type retentionStore interface {
+	RunRetention(dryRun bool) (*imagestore.RetentionReport, error)
+}
+
+// handleRetention handles GET /retention, reporting what the configured
+// retention policies would delete, and POST /retention, enforcing them
+// unless dry_run=true is given
+func (h *ImageHandler) handleRetention(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	store, ok := h.store.(retentionStore)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, codeNotSupported, "Retention not supported by this store")
+		return
+	}
+
+	dryRun := r.Method == http.MethodGet || r.URL.Query().Get("dry_run") == "true"
+	report, err := store.RunRetention(dryRun)
+	if err != nil {
+		if errors.Is(err, imagestore.ErrReadOnly) {
+			writeError(w, http.StatusForbidden, codeReadOnly, "Store is read-only")
+			return
+		}
+		log.Printf("Error applying retention: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to apply retention")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// preloadStore is implemented by stores that can warm their image cache
+type preloadStore interface {
+	Preload(ctx context.Context, prefixes ...string) (*imagestore.PreloadReport, error)
+}
+
+// handlePreload handles POST /preload, reconstructing the images under each
+// prefix query parameter, or the configured preload prefixes, into the image
+// cache
+func (h *ImageHandler) handlePreload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	store, ok := h.store.(preloadStore)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, codeNotSupported, "Preloading not supported by this store")
+		return
+	}
+
+	report, err := store.Preload(r.Context(), r.URL.Query()["prefix"]...)
+	if err != nil {
+		if errors.Is(err, imagestore.ErrImageCacheDisabled) {
+			writeError(w, http.StatusConflict, codeNotSupported, "Image cache is disabled")
+			return
+		}
+		log.Printf("Error preloading images: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to preload images")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// changeStore is implemented by stores with a change feed
+type changeStore interface {
+	LastChange() uint64
+	Changes(since uint64, limit int) ([]imagestore.Change, error)
+	Watch(ctx context.Context, since uint64) iter.Seq2[imagestore.Change, error]
+}
+
+// maxChangesWait bounds how long a long-poll for changes may wait
+const maxChangesWait = 60 * time.Second
+
+// handleChanges handles GET /changes?since=N, returning the changes after
+// sequence number N. With wait=S it long-polls for up to S seconds when
+// there are none yet; with Accept: text/event-stream it streams changes as
+// server-sent events until the client disconnects.
+func (h *ImageHandler) handleChanges(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	store, ok := h.store.(changeStore)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, codeNotSupported, "Change feed not supported by this store")
+		return
+	}
+
+	query := r.URL.Query()
+	var since uint64
+	if value := query.Get("since"); value != "" {
+		parsed, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "since must be a sequence number")
+			return
+		}
+		since = parsed
+	} else if lastEventID := r.Header.Get("Last-Event-ID"); lastEventID != "" {
+		// A reconnecting EventSource resumes after the last event it saw
+		since, _ = strconv.ParseUint(lastEventID, 10, 64)
+	}
+	limit := 1000
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "limit must be a positive integer")
+			return
+		}
+		limit = min(parsed, limit)
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		h.streamChanges(w, r, store, since)
+		return
+	}
+
+	changes, err := store.Changes(since, limit)
+	if err == nil && len(changes) == 0 && query.Get("wait") != "" {
+		seconds, convErr := strconv.Atoi(query.Get("wait"))
+		if convErr != nil || seconds < 0 {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "wait must be a number of seconds")
+			return
+		}
+		wait := min(time.Duration(seconds)*time.Second, maxChangesWait)
+		http.NewResponseController(w).SetWriteDeadline(time.Now().Add(wait + 10*time.Second))
+
+		ctx, cancel := context.WithTimeout(r.Context(), wait)
+		for _, watchErr := range store.Watch(ctx, since) {
+			// Wake on the first change, then return everything available
+			if watchErr == nil {
+				changes, err = store.Changes(since, limit)
+			} else if !errors.Is(watchErr, context.DeadlineExceeded) && !errors.Is(watchErr, context.Canceled) {
+				err = watchErr
+			}
+			break
+		}
+		cancel()
+	}
+	if errors.Is(err, imagestore.ErrChangesTruncated) {
+		writeError(w, http.StatusGone, codeChangesTruncated, "Changes after this sequence number are no longer kept; rescan the store and resume from last_seq at GET /changes")
+		return
+	}
+	if err != nil {
+		log.Printf("Error reading changes: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	if changes == nil {
+		changes = []imagestore.Change{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"changes":  changes,
+		"last_seq": store.LastChange(),
+	})
+}
+
+// streamChanges sends changes as server-sent events, each with the change's
+// sequence number as its ID so a reconnecting client resumes where it left
+// off
+func (h *ImageHandler) streamChanges(w http.ResponseWriter, r *http.Request, store changeStore, since uint64) {
+	rc := http.NewResponseController(w)
+	// The stream outlives the server's write timeout
+	rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	for change, err := range store.Watch(r.Context(), since) {
+		if errors.Is(err, imagestore.ErrChangesTruncated) {
+			fmt.Fprintf(w, "event: truncated\ndata: {}\n\n")
+			rc.Flush()
+			return
+		}
+		if err != nil {
+			return
+		}
+		data, err := json.Marshal(change)
+		if err != nil {
+			return
+		}
+		fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", change.Seq, change.Type, data)
+		if rc.Flush() != nil {
+			return
+		}
+	}
 }
 
 // isValidImageType checks if the content type is a supported image format
@@ -250,6 +2954,8 @@ func isValidImageType(contentType string) bool {
 	switch contentType {
 	case "image/png", "image/jpeg", "image/jpg":
 		return true
+	case "image/heic", "image/heif", "image/avif":
+		return true // Decoded only in binaries built with the heif tag
 	default:
 		return false
 	}
@@ -259,7 +2965,7 @@ func isValidImageType(contentType string) bool {
 func CORSMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
 		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
 
 		if r.Method == "OPTIONS" {
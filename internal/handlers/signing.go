@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxSignedURLLifetime bounds how long a signed URL can stay valid, so a
+// leaked link can't be used forever
+const maxSignedURLLifetime = 7 * 24 * time.Hour
+
+// errSigningDisabled is returned by SignURL without a signing key
+var errSigningDisabled = errors.New("signed URLs are disabled")
+
+// signedQueryParams are the retrieval parameters a signed URL may fix; any
+// other parameter would be ignored by the handler, so it isn't signed
+var signedQueryParams = []string{"annotations"}
+
+// SignURL returns the escaped path and query of a URL retrieving path, given
+// unescaped, without a token until expires. The signature covers the path, the expiry and the
+// retrieval parameters in query, so none can be changed.
+func (a *Authorizer) SignURL(path string, query url.Values, expires time.Time) (string, error) {
+	state := a.state.Load()
+	if len(state.signingKey) == 0 {
+		return "", errSigningDisabled
+	}
+
+	signed := url.Values{"expires": {strconv.FormatInt(expires.Unix(), 10)}}
+	for _, param := range signedQueryParams {
+		if value := query.Get(param); value != "" {
+			signed.Set(param, value)
+		}
+	}
+	signed.Set("signature", state.sign(path, signed))
+	return (&url.URL{Path: path, RawQuery: signed.Encode()}).String(), nil
+}
+
+// sign returns the signature of a path and its query, less any signature
+func (s *authState) sign(path string, query url.Values) string {
+	mac := hmac.New(sha256.New, s.signingKey)
+	mac.Write([]byte(path))
+	mac.Write([]byte{'\n'})
+	for _, param := range slices.Sorted(func(yield func(string) bool) {
+		for param := range query {
+			if param != "signature" && !yield(param) {
+				return
+			}
+		}
+	}) {
+		mac.Write([]byte(url.QueryEscape(param) + "=" + url.QueryEscape(query.Get(param)) + "&"))
+	}
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verify reports whether a request's path and query carry a valid signature
+// that hasn't expired. Parameters that weren't signed, and repeated ones,
+// which only the first value of is signed, fail verification.
+func (s *authState) verify(path string, query url.Values, now time.Time) bool {
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil || now.Unix() > expires {
+		return false
+	}
+	for param, values := range query {
+		if param != "expires" && param != "signature" && !slices.Contains(signedQueryParams, param) {
+			return false
+		}
+		if len(values) != 1 {
+			return false
+		}
+	}
+	return hmac.Equal([]byte(query.Get("signature")), []byte(s.sign(path, query)))
+}
+
+// signedRequest reports whether a request is one signed URLs may make: a
+// plain retrieval of one image
+func signedRequest(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	id, ok := strings.CutPrefix(r.URL.Path, "/images/")
+	if !ok || id == "" || id == "estimate" {
+		return false
+	}
+	for _, suffix := range imageSubresources {
+		if strings.HasSuffix(id, suffix) {
+			return false
+		}
+	}
+	return true
+}
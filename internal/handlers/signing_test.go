@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gordyf/imageencoder/lib/config"
+)
+
+const testSigningKey = "0123456789abcdef0123456789abcdef"
+
+// newSigningTestHandler returns an authorizer that signs URLs and requires
+// tokens otherwise, and a handler behind its middleware that answers 200
+func newSigningTestHandler(t *testing.T) (*Authorizer, http.Handler) {
+	t.Helper()
+
+	auth := testAuthConfig
+	auth.SigningKey = testSigningKey
+	authorizer := NewAuthorizer(auth)
+	return authorizer, authorizer.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+}
+
+// getSigned requests a signed URL without a token and returns the status
+func getSigned(handler http.Handler, method, signed string) int {
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(method, signed, nil))
+	return w.Code
+}
+
+func TestSignedURLRoundTrip(t *testing.T) {
+	authorizer, handler := newSigningTestHandler(t)
+
+	signed, err := authorizer.SignURL("/images/team/login page", url.Values{"annotations": {"true"}}, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("failed to sign URL: %v", err)
+	}
+	if !strings.HasPrefix(signed, "/images/team/login%20page?") {
+		t.Errorf("expected an escaped path, got %s", signed)
+	}
+
+	for _, method := range []string{http.MethodGet, http.MethodHead} {
+		if status := getSigned(handler, method, signed); status != http.StatusOK {
+			t.Errorf("%s: expected a signed URL to be admitted without a token, got %d", method, status)
+		}
+	}
+
+	// Signed URLs only retrieve
+	if status := getSigned(handler, http.MethodDelete, signed); status != http.StatusForbidden {
+		t.Errorf("expected 403 deleting with a signed URL, got %d", status)
+	}
+}
+
+func TestSignedURLExpired(t *testing.T) {
+	authorizer, handler := newSigningTestHandler(t)
+
+	signed, err := authorizer.SignURL("/images/team/shot", nil, time.Now().Add(-time.Second))
+	if err != nil {
+		t.Fatalf("failed to sign URL: %v", err)
+	}
+	if status := getSigned(handler, http.MethodGet, signed); status != http.StatusForbidden {
+		t.Errorf("expected 403 for an expired signature, got %d", status)
+	}
+}
+
+func TestSignedURLTampered(t *testing.T) {
+	authorizer, handler := newSigningTestHandler(t)
+
+	signed, err := authorizer.SignURL("/images/team/shot", url.Values{"annotations": {"true"}}, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("failed to sign URL: %v", err)
+	}
+	u, _ := url.Parse(signed)
+
+	tests := []struct {
+		name   string
+		tamper func(path string, query url.Values) string
+	}{
+		{"other image", func(path string, query url.Values) string {
+			return "/images/team/other?" + query.Encode()
+		}},
+		{"subresource", func(path string, query url.Values) string {
+			return path + "/metadata?" + query.Encode()
+		}},
+		{"later expiry", func(path string, query url.Values) string {
+			query.Set("expires", "99999999999")
+			return path + "?" + query.Encode()
+		}},
+		{"changed signed parameter", func(path string, query url.Values) string {
+			query.Set("annotations", "false")
+			return path + "?" + query.Encode()
+		}},
+		{"dropped signed parameter", func(path string, query url.Values) string {
+			query.Del("annotations")
+			return path + "?" + query.Encode()
+		}},
+		{"unsigned parameter", func(path string, query url.Values) string {
+			query.Set("format", "webp")
+			return path + "?" + query.Encode()
+		}},
+		{"repeated parameter", func(path string, query url.Values) string {
+			query.Add("annotations", "false")
+			return path + "?" + query.Encode()
+		}},
+		{"bad signature", func(path string, query url.Values) string {
+			query.Set("signature", "AAAA")
+			return path + "?" + query.Encode()
+		}},
+	}
+	for _, tt := range tests {
+		tampered := tt.tamper(u.Path, u.Query())
+		if status := getSigned(handler, http.MethodGet, tampered); status != http.StatusForbidden {
+			t.Errorf("%s: expected 403 for %s, got %d", tt.name, tampered, status)
+		}
+	}
+}
+
+func TestSignURLDropsUnsignedParameters(t *testing.T) {
+	authorizer, handler := newSigningTestHandler(t)
+
+	signed, err := authorizer.SignURL("/images/team/shot", url.Values{"format": {"webp"}, "annotations": {"true"}}, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("failed to sign URL: %v", err)
+	}
+	u, _ := url.Parse(signed)
+	if u.Query().Has("format") || u.Query().Get("annotations") != "true" {
+		t.Errorf("expected only signed parameters in %s", signed)
+	}
+	if status := getSigned(handler, http.MethodGet, signed); status != http.StatusOK {
+		t.Errorf("expected the signed URL to be admitted, got %d", status)
+	}
+}
+
+func TestSignURLDisabled(t *testing.T) {
+	authorizer := NewAuthorizer(config.AuthConfig{})
+	if _, err := authorizer.SignURL("/images/shot", nil, time.Now().Add(time.Hour)); !errors.Is(err, errSigningDisabled) {
+		t.Errorf("expected errSigningDisabled, got %v", err)
+	}
+
+	// Without a signing key a signature is no way around the token check
+	handler := NewAuthorizer(testAuthConfig).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	if status := getSigned(handler, http.MethodGet, "/images/team/shot?expires=99999999999&signature=AAAA"); status != http.StatusUnauthorized {
+		t.Errorf("expected 401 with signing disabled, got %d", status)
+	}
+}
@@ -0,0 +1,236 @@
+package bench
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gordyf/imageencoder/lib/imagestore"
+)
+
+// Options selects the corpus and the store configurations to compare. Every
+// combination of tile size, compression level and codec set is run as its
+// own scenario against a fresh store.
+type Options struct {
+	Corpus            CorpusConfig
+	TileSizes         []int
+	CompressionLevels []string
+	CodecSets         [][]string
+	Dir               string // Where scenario databases are created; default: a temporary directory
+	Progress          io.Writer
+}
+
+// DefaultOptions compares the common tile sizes at the default level
+func DefaultOptions() Options {
+	return Options{
+		Corpus:            DefaultCorpusConfig(),
+		TileSizes:         []int{128, 256, 512},
+		CompressionLevels: []string{imagestore.CompressionDefault},
+		CodecSets:         [][]string{{imagestore.CodecZstd}},
+	}
+}
+
+// Scenario is one store configuration under test
+type Scenario struct {
+	TileSize         int      `json:"tile_size"`
+	CompressionLevel string   `json:"compression_level"`
+	Codecs           []string `json:"codecs"`
+}
+
+func (s Scenario) String() string {
+	return fmt.Sprintf("tile %d, %s, %s", s.TileSize, s.CompressionLevel, strings.Join(s.Codecs, "+"))
+}
+
+// ScenarioResult holds the measurements of one scenario
+type ScenarioResult struct {
+	Scenario
+	Images              int     `json:"images"`
+	OriginalBytes       int64   `json:"original_bytes"`
+	StorageBytes        int64   `json:"storage_bytes"`
+	CompressionRatio    float64 `json:"compression_ratio"`
+	DeduplicatedPercent float64 `json:"deduplicated_percent"`
+	IngestSeconds       float64 `json:"ingest_seconds"`
+	IngestImagesPerSec  float64 `json:"ingest_images_per_second"`
+	IngestMBPerSec      float64 `json:"ingest_mb_per_second"`
+	RetrieveP50Millis   float64 `json:"retrieve_p50_ms"`
+	RetrieveP95Millis   float64 `json:"retrieve_p95_ms"`
+	RetrieveMaxMillis   float64 `json:"retrieve_max_ms"`
+}
+
+// Report is the outcome of a benchmark run
+type Report struct {
+	Started   time.Time        `json:"started"`
+	GoVersion string           `json:"go_version"`
+	GOOS      string           `json:"goos"`
+	GOARCH    string           `json:"goarch"`
+	CPUs      int              `json:"cpus"`
+	Corpus    CorpusConfig     `json:"corpus"`
+	Results   []ScenarioResult `json:"results"`
+}
+
+// Scenarios expands the options into the scenarios to run
+func (o Options) Scenarios() []Scenario {
+	var scenarios []Scenario
+	for _, tileSize := range o.TileSizes {
+		for _, level := range o.CompressionLevels {
+			for _, codecs := range o.CodecSets {
+				scenarios = append(scenarios, Scenario{TileSize: tileSize, CompressionLevel: level, Codecs: codecs})
+			}
+		}
+	}
+	return scenarios
+}
+
+// Run generates the corpus and measures every scenario
+func Run(ctx context.Context, opts Options) (*Report, error) {
+	scenarios := opts.Scenarios()
+	if len(scenarios) == 0 {
+		return nil, fmt.Errorf("no scenarios to run")
+	}
+
+	corpus, err := GenerateCorpus(opts.Corpus)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := opts.Dir
+	if dir == "" {
+		dir, err = os.MkdirTemp("", "imagestore-bench-*")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create benchmark directory: %w", err)
+		}
+		defer os.RemoveAll(dir)
+	}
+
+	report := &Report{
+		Started:   time.Now().UTC(),
+		GoVersion: runtime.Version(),
+		GOOS:      runtime.GOOS,
+		GOARCH:    runtime.GOARCH,
+		CPUs:      runtime.NumCPU(),
+		Corpus:    opts.Corpus,
+	}
+
+	for i, scenario := range scenarios {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		if opts.Progress != nil {
+			fmt.Fprintf(opts.Progress, "[%d/%d] %s\n", i+1, len(scenarios), scenario)
+		}
+
+		result, err := runScenario(ctx, scenario, corpus, filepath.Join(dir, fmt.Sprintf("scenario-%d.db", i)))
+		if err != nil {
+			return report, fmt.Errorf("scenario %s: %w", scenario, err)
+		}
+		report.Results = append(report.Results, *result)
+	}
+
+	return report, nil
+}
+
+// runScenario ingests the corpus into a fresh store, then retrieves every
+// image once
+func runScenario(ctx context.Context, scenario Scenario, corpus [][]byte, dbPath string) (*ScenarioResult, error) {
+	config := imagestore.DefaultConfig()
+	config.DatabasePath = dbPath
+	config.TileSize = scenario.TileSize
+	config.CompressionLevel = scenario.CompressionLevel
+	config.TileCodecs = scenario.Codecs
+	config.TrashRetention = 0
+	config.ExpirySweepInterval = 0
+
+	store, err := imagestore.NewPebbleImageStore(config)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dbPath)
+	defer store.Close()
+
+	ids := make([]string, len(corpus))
+	start := time.Now()
+	for i, imageData := range corpus {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		ids[i] = fmt.Sprintf("bench/%05d", i)
+		if err := store.StoreImage(ids[i], imageData); err != nil {
+			return nil, fmt.Errorf("failed to store %s: %w", ids[i], err)
+		}
+	}
+	ingest := time.Since(start)
+
+	latencies := make([]time.Duration, len(ids))
+	for i, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		start := time.Now()
+		if _, err := store.RetrieveImage(id); err != nil {
+			return nil, fmt.Errorf("failed to retrieve %s: %w", id, err)
+		}
+		latencies[i] = time.Since(start)
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	stats := store.GetStorageStats()
+	return &ScenarioResult{
+		Scenario:            scenario,
+		Images:              len(corpus),
+		OriginalBytes:       stats.OriginalBytes,
+		StorageBytes:        stats.StorageBytes,
+		CompressionRatio:    stats.CompressionRatio,
+		DeduplicatedPercent: stats.DeduplicatedPercent,
+		IngestSeconds:       ingest.Seconds(),
+		IngestImagesPerSec:  float64(len(corpus)) / ingest.Seconds(),
+		IngestMBPerSec:      float64(stats.OriginalBytes) / (1 << 20) / ingest.Seconds(),
+		RetrieveP50Millis:   millis(percentile(latencies, 50)),
+		RetrieveP95Millis:   millis(percentile(latencies, 95)),
+		RetrieveMaxMillis:   millis(latencies[len(latencies)-1]),
+	}, nil
+}
+
+// percentile returns the p-th percentile of sorted durations
+func percentile(sorted []time.Duration, p int) time.Duration {
+	index := (len(sorted)*p+99)/100 - 1
+	return sorted[max(index, 0)]
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// WriteJSON writes the report as indented JSON
+func (r *Report) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// WriteMarkdown writes the report as a markdown table
+func (r *Report) WriteMarkdown(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Image store benchmark\n\n")
+	fmt.Fprintf(&b, "%s, %s/%s, %d CPUs, %s\n\n", r.Started.Format(time.RFC3339), r.GOOS, r.GOARCH, r.CPUs, r.GoVersion)
+	fmt.Fprintf(&b, "Corpus: %d screenshots of %dx%d from %d apps (seed %d)\n\n",
+		r.Corpus.Images, r.Corpus.Width, r.Corpus.Height, r.Corpus.Apps, r.Corpus.Seed)
+
+	b.WriteString("| Tile size | Level | Codecs | Ratio | Dedup % | Stored MB | Ingest img/s | Ingest MB/s | Retrieve p50 ms | Retrieve p95 ms |\n")
+	b.WriteString("|---:|---|---|---:|---:|---:|---:|---:|---:|---:|\n")
+	for _, result := range r.Results {
+		fmt.Fprintf(&b, "| %d | %s | %s | %.2f | %.1f | %.2f | %.1f | %.2f | %.2f | %.2f |\n",
+			result.TileSize, result.CompressionLevel, strings.Join(result.Codecs, "+"),
+			result.CompressionRatio, result.DeduplicatedPercent, float64(result.StorageBytes)/(1<<20),
+			result.IngestImagesPerSec, result.IngestMBPerSec, result.RetrieveP50Millis, result.RetrieveP95Millis)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,139 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/gordyf/imageencoder/lib/imagestore"
+)
+
+func smallCorpus() CorpusConfig {
+	return CorpusConfig{Images: 6, Width: 128, Height: 96, Apps: 2, Seed: 7}
+}
+
+func TestGenerateCorpusIsDeterministic(t *testing.T) {
+	first, err := GenerateCorpus(smallCorpus())
+	if err != nil {
+		t.Fatalf("failed to generate corpus: %v", err)
+	}
+	second, _ := GenerateCorpus(smallCorpus())
+
+	if len(first) != 6 {
+		t.Fatalf("expected 6 screenshots, got %d", len(first))
+	}
+	for i := range first {
+		if !bytes.Equal(first[i], second[i]) {
+			t.Fatalf("screenshot %d differs between runs", i)
+		}
+	}
+
+	if _, err := GenerateCorpus(CorpusConfig{Images: 1, Width: 0, Height: 10, Apps: 1}); err == nil {
+		t.Error("expected an error for an invalid corpus")
+	}
+}
+
+func TestRun(t *testing.T) {
+	var progress bytes.Buffer
+	report, err := Run(context.Background(), Options{
+		Corpus:            smallCorpus(),
+		TileSizes:         []int{16, 32},
+		CompressionLevels: []string{imagestore.CompressionFastest},
+		CodecSets:         [][]string{{imagestore.CodecZstd}},
+		Dir:               t.TempDir(),
+		Progress:          &progress,
+	})
+	if err != nil {
+		t.Fatalf("benchmark failed: %v", err)
+	}
+
+	if len(report.Results) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(report.Results))
+	}
+	for _, result := range report.Results {
+		if result.Images != 6 || result.StorageBytes == 0 || result.CompressionRatio <= 0 {
+			t.Errorf("%s: unexpected images %d, storage bytes %d, ratio %f",
+				result.Scenario, result.Images, result.StorageBytes, result.CompressionRatio)
+		}
+		if result.DeduplicatedPercent == 0 {
+			t.Errorf("%s: expected the corpus to deduplicate", result.Scenario)
+		}
+	}
+	if !strings.Contains(progress.String(), "[2/2]") {
+		t.Errorf("expected progress output, got %q", progress.String())
+	}
+
+	var markdown bytes.Buffer
+	if err := report.WriteMarkdown(&markdown); err != nil {
+		t.Fatalf("failed to write markdown: %v", err)
+	}
+	if strings.Count(markdown.String(), "| 16 |")+strings.Count(markdown.String(), "| 32 |") != 2 {
+		t.Errorf("expected a row per scenario:\n%s", markdown.String())
+	}
+
+	var encoded bytes.Buffer
+	if err := report.WriteJSON(&encoded); err != nil {
+		t.Fatalf("failed to write JSON: %v", err)
+	}
+	var decoded Report
+	if err := json.Unmarshal(encoded.Bytes(), &decoded); err != nil || len(decoded.Results) != 2 {
+		t.Errorf("failed to round-trip JSON report: %v", err)
+	}
+}
+
+func BenchmarkStoreImage(b *testing.B) {
+	corpus, err := GenerateCorpus(DefaultCorpusConfig())
+	if err != nil {
+		b.Fatalf("failed to generate corpus: %v", err)
+	}
+
+	config := imagestore.DefaultConfig()
+	config.DatabasePath = b.TempDir() + "/bench.db"
+	config.ExpirySweepInterval = 0
+	store, err := imagestore.NewPebbleImageStore(config)
+	if err != nil {
+		b.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		imageData := corpus[i%len(corpus)]
+		b.SetBytes(int64(len(imageData)))
+		if err := store.StoreImage(fmt.Sprintf("bench/%d", i), imageData); err != nil {
+			b.Fatalf("failed to store image: %v", err)
+		}
+	}
+}
+
+func BenchmarkRetrieveImage(b *testing.B) {
+	corpus, err := GenerateCorpus(DefaultCorpusConfig())
+	if err != nil {
+		b.Fatalf("failed to generate corpus: %v", err)
+	}
+
+	config := imagestore.DefaultConfig()
+	config.DatabasePath = b.TempDir() + "/bench.db"
+	config.ExpirySweepInterval = 0
+	store, err := imagestore.NewPebbleImageStore(config)
+	if err != nil {
+		b.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	for i, imageData := range corpus {
+		if err := store.StoreImage(fmt.Sprintf("bench/%d", i), imageData); err != nil {
+			b.Fatalf("failed to store image: %v", err)
+		}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := store.RetrieveImage(fmt.Sprintf("bench/%d", i%len(corpus))); err != nil {
+			b.Fatalf("failed to retrieve image: %v", err)
+		}
+	}
+}
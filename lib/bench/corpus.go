@@ -0,0 +1,157 @@
+package bench
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math/rand"
+)
+
+// CorpusConfig describes a synthetic screenshot corpus. The same config
+// always generates the same images.
+type CorpusConfig struct {
+	Images int   `json:"images"` // Number of screenshots
+	Width  int   `json:"width"`  // Screenshot width in pixels
+	Height int   `json:"height"` // Screenshot height in pixels
+	Apps   int   `json:"apps"`   // Distinct app layouts the screenshots are drawn from
+	Seed   int64 `json:"seed"`   // Seed for the generator
+}
+
+// DefaultCorpusConfig returns a small corpus of laptop-sized screenshots
+func DefaultCorpusConfig() CorpusConfig {
+	return CorpusConfig{
+		Images: 50,
+		Width:  1280,
+		Height: 800,
+		Apps:   4,
+		Seed:   1,
+	}
+}
+
+// Validate checks that the corpus can be generated
+func (c CorpusConfig) Validate() error {
+	if c.Images <= 0 {
+		return fmt.Errorf("invalid image count: %d", c.Images)
+	}
+	if c.Width <= 0 || c.Height <= 0 {
+		return fmt.Errorf("invalid screenshot size: %dx%d", c.Width, c.Height)
+	}
+	if c.Apps <= 0 {
+		return fmt.Errorf("invalid app count: %d", c.Apps)
+	}
+	return nil
+}
+
+// appLayout is the fixed chrome of one synthetic app
+type appLayout struct {
+	background, toolbar, sidebar, text, accent color.RGBA
+	toolbarHeight, sidebarWidth                int
+	menuItems                                  int
+	pages                                      []int64 // Seeds of the content pages the app shows
+}
+
+// GenerateCorpus returns the corpus as PNG-encoded screenshots
+func GenerateCorpus(config CorpusConfig) ([][]byte, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	rng := rand.New(rand.NewSource(config.Seed))
+	layouts := make([]appLayout, config.Apps)
+	for i := range layouts {
+		layouts[i] = randomLayout(rng, config.Width, config.Height)
+	}
+
+	corpus := make([][]byte, config.Images)
+	for i := range corpus {
+		img := generateScreenshot(rng, layouts[rng.Intn(len(layouts))], config.Width, config.Height)
+
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, fmt.Errorf("failed to encode screenshot %d: %w", i, err)
+		}
+		corpus[i] = buf.Bytes()
+	}
+
+	return corpus, nil
+}
+
+// randomLayout picks the colors and chrome dimensions of an app
+func randomLayout(rng *rand.Rand, width, height int) appLayout {
+	dark := rng.Intn(2) == 0
+	shade := func(base int) color.RGBA {
+		v := uint8(base + rng.Intn(24))
+		if dark {
+			v = 255 - v
+		}
+		return color.RGBA{v, v, v, 255}
+	}
+
+	return appLayout{
+		background:    shade(232),
+		toolbar:       shade(200),
+		sidebar:       shade(216),
+		text:          shade(16),
+		accent:        color.RGBA{uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256)), 255},
+		toolbarHeight: max(height/16, 8),
+		sidebarWidth:  max(width/6, 16),
+		menuItems:     4 + rng.Intn(8),
+		pages:         []int64{rng.Int63(), rng.Int63(), rng.Int63()},
+	}
+}
+
+// generateScreenshot draws a screenshot of an app: fixed toolbar and sidebar
+// chrome around one of the app's pages, with a small random change such as
+// a selected menu item or a notification, like a UI captured at different
+// moments
+func generateScreenshot(rng *rand.Rand, layout appLayout, width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	fill := func(r image.Rectangle, c color.RGBA) {
+		draw.Draw(img, r.Intersect(img.Bounds()), &image.Uniform{c}, image.Point{}, draw.Src)
+	}
+
+	fill(img.Bounds(), layout.background)
+	fill(image.Rect(0, 0, width, layout.toolbarHeight), layout.toolbar)
+	fill(image.Rect(0, layout.toolbarHeight, layout.sidebarWidth, height), layout.sidebar)
+
+	// Sidebar menu with one highlighted entry
+	itemHeight := max(layout.toolbarHeight, 8)
+	selected := rng.Intn(layout.menuItems)
+	for i := 0; i < layout.menuItems; i++ {
+		top := layout.toolbarHeight + i*itemHeight*2 + itemHeight/2
+		if i == selected {
+			fill(image.Rect(0, top-itemHeight/4, layout.sidebarWidth, top+itemHeight+itemHeight/4), layout.accent)
+		}
+		fill(image.Rect(itemHeight/2, top+itemHeight/3, layout.sidebarWidth*2/3, top+itemHeight*2/3), layout.text)
+	}
+
+	// Content: lines of "text" made of word-sized blocks, and the odd card
+	page := rand.New(rand.NewSource(layout.pages[rng.Intn(len(layout.pages))]))
+	lineHeight := max(itemHeight/2, 4)
+	left := layout.sidebarWidth + itemHeight
+	for y := layout.toolbarHeight + itemHeight; y+lineHeight < height; y += lineHeight * 2 {
+		if page.Intn(10) == 0 {
+			cardHeight := lineHeight * (4 + page.Intn(6))
+			fill(image.Rect(left, y, width-itemHeight, y+cardHeight), layout.accent)
+			y += cardHeight
+			continue
+		}
+		for x := left; x < width-itemHeight; {
+			word := lineHeight * (1 + page.Intn(6))
+			fill(image.Rect(x, y, min(x+word, width-itemHeight), y+lineHeight), layout.text)
+			x += word + lineHeight
+		}
+	}
+
+	// A notification somewhere in the content area
+	if rng.Intn(2) == 0 {
+		x := left + rng.Intn(max(width-left, 1))
+		y := layout.toolbarHeight + rng.Intn(max(height-layout.toolbarHeight, 1))
+		fill(image.Rect(x, y, x+itemHeight*8, y+itemHeight*2), layout.accent)
+	}
+
+	return img
+}
@@ -0,0 +1,269 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	_ "image/jpeg" // Register JPEG decoding
+	_ "image/png"  // Register PNG decoding
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gordyf/imageencoder/lib/imagestore"
+)
+
+// DefaultTileSize matches the server's default tile size
+const DefaultTileSize = 256
+
+// Client uploads images to an image store server, sending only the tiles
+// the server doesn't already have
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+	TileSize   int // Learned from the server if it differs
+}
+
+// UploadResult describes what an upload sent over the wire
+type UploadResult struct {
+	Tiles         int   // Tiles in the image
+	TilesUploaded int   // Tiles the server didn't have
+	BytesUploaded int64 // Raw tile bytes sent
+}
+
+// New creates a client for a server such as http://localhost:8080
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		HTTPClient: http.DefaultClient,
+		TileSize:   DefaultTileSize,
+	}
+}
+
+// TileImage is the reference tiling for manifest uploads. It splits an image
+// exactly as the server does, returning the manifest and the raw RGB data
+// of each distinct tile.
+func TileImage(img image.Image, tileSize int) (imagestore.ImageManifest, map[imagestore.TileID][]byte, error) {
+	tiles, _, err := imagestore.ExtractTiles(img, tileSize)
+	if err != nil {
+		return imagestore.ImageManifest{}, nil, fmt.Errorf("failed to extract tiles: %w", err)
+	}
+
+	bounds := img.Bounds()
+	manifest := imagestore.ImageManifest{
+		Width:    bounds.Dx(),
+		Height:   bounds.Dy(),
+		TileSize: tileSize,
+		Tiles:    make([]imagestore.TileID, len(tiles)),
+	}
+	tileData := make(map[imagestore.TileID][]byte, len(tiles))
+	for i, tile := range tiles {
+		manifest.Tiles[i] = tile.ID
+		tileData[tile.ID] = tile.Data
+	}
+
+	return manifest, tileData, nil
+}
+
+// Upload stores a PNG or JPEG image under id. The client tiles the image,
+// asks the server which tiles it lacks and uploads only those along with
+// the manifest. A tile collected between the two steps causes one retry.
+func (c *Client) Upload(ctx context.Context, id string, imageData []byte) (*UploadResult, error) {
+	img, err := imagestore.DecodeImage(imageData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	var lastErr error
+	for attempt := 0; attempt < 2; attempt++ {
+		manifest, tileData, err := TileImage(img, c.TileSize)
+		if err != nil {
+			return nil, err
+		}
+		manifest.OriginalBytes = int64(len(imageData))
+
+		missing, err := c.missingTiles(ctx, imagestore.Namespace(id), manifest.Tiles)
+		if err == errTileSizeChanged {
+			lastErr = err
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		result, err := c.uploadManifest(ctx, id, manifest, tileData, missing)
+		if err == errTilesMissing {
+			lastErr = err
+			continue
+		}
+		return result, err
+	}
+
+	return nil, lastErr
+}
+
+var (
+	errTileSizeChanged = fmt.Errorf("server uses a different tile size")
+	errTilesMissing    = fmt.Errorf("server is missing tiles the manifest references")
+)
+
+// missingTiles asks the server which tiles the namespace's tile pool lacks.
+// If the server uses a different tile size, the client adopts it and
+// errTileSizeChanged is returned so the caller re-tiles.
+func (c *Client) missingTiles(ctx context.Context, namespace string, tileIDs []imagestore.TileID) ([]imagestore.TileID, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"tile_size": c.TileSize,
+		"tiles":     tileIDs,
+		"namespace": namespace,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+"/tiles/missing", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to negotiate tiles: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var reply struct {
+		TileSize int                 `json:"tile_size"` // Sent by servers predating error envelopes
+		Missing  []imagestore.TileID `json:"missing"`
+		Error    struct {
+			Details struct {
+				TileSize int `json:"tile_size"`
+			} `json:"details"`
+		} `json:"error"`
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+			return nil, fmt.Errorf("invalid negotiation response: %w", err)
+		}
+		return reply.Missing, nil
+	case http.StatusConflict:
+		if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+			return nil, fmt.Errorf("invalid tile size response")
+		}
+		tileSize := max(reply.Error.Details.TileSize, reply.TileSize)
+		if tileSize <= 0 {
+			return nil, fmt.Errorf("invalid tile size response")
+		}
+		c.TileSize = tileSize
+		return nil, errTileSizeChanged
+	default:
+		return nil, responseError("negotiate tiles", resp)
+	}
+}
+
+// uploadManifest sends the manifest and the data of the missing tiles
+func (c *Client) uploadManifest(ctx context.Context, id string, manifest imagestore.ImageManifest, tileData map[imagestore.TileID][]byte, missing []imagestore.TileID) (*UploadResult, error) {
+	manifestJSON, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	if err := writer.WriteField("manifest", string(manifestJSON)); err != nil {
+		return nil, err
+	}
+
+	result := &UploadResult{Tiles: len(manifest.Tiles)}
+	for _, tileID := range missing {
+		data, ok := tileData[tileID]
+		if !ok {
+			return nil, fmt.Errorf("server requested unknown tile %s", tileID)
+		}
+		part, err := writer.CreateFormFile("tile", string(tileID))
+		if err != nil {
+			return nil, err
+		}
+		if _, err := part.Write(data); err != nil {
+			return nil, err
+		}
+		result.TilesUploaded++
+		result.BytesUploaded += int64(len(data))
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	endpoint := c.BaseURL + "/images/" + escapeID(id) + "/manifest"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload manifest: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusCreated:
+		return result, nil
+	case http.StatusConflict:
+		return nil, errTilesMissing
+	default:
+		return nil, responseError("upload manifest", resp)
+	}
+}
+
+// escapeID escapes each segment of an image ID, keeping namespace slashes
+func escapeID(id string) string {
+	segments := strings.Split(id, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// APIError is an error response from the server
+type APIError struct {
+	Action     string // What the client was doing, such as "upload manifest"
+	StatusCode int
+	Code       string // Machine-readable, such as image_not_found; empty from servers predating error codes
+	Message    string
+	RequestID  string // Quote this when reporting a problem; the server logs under it
+}
+
+func (e *APIError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("failed to %s: %d %s: %s", e.Action, e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+	}
+	return fmt.Sprintf("failed to %s: %s: %s (request %s)", e.Action, e.Code, e.Message, e.RequestID)
+}
+
+// responseError builds an *APIError from an unexpected response
+func responseError(action string, resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	apiErr := &APIError{Action: action, StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(body))}
+
+	var envelope struct {
+		Error struct {
+			Code      string `json:"code"`
+			Message   string `json:"message"`
+			RequestID string `json:"request_id"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(body, &envelope) == nil && envelope.Error.Code != "" {
+		apiErr.Code = envelope.Error.Code
+		apiErr.Message = envelope.Error.Message
+		apiErr.RequestID = envelope.Error.RequestID
+	}
+	return apiErr
+}
@@ -0,0 +1,123 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gordyf/imageencoder/internal/handlers"
+	"github.com/gordyf/imageencoder/lib/config"
+	"github.com/gordyf/imageencoder/lib/imagestore"
+)
+
+func newTestServer(t *testing.T, tileSize int) (*httptest.Server, *imagestore.PebbleImageStore) {
+	t.Helper()
+
+	storeConfig := imagestore.DefaultConfig()
+	storeConfig.DatabasePath = filepath.Join(t.TempDir(), "test.db")
+	storeConfig.TileSize = tileSize
+
+	store, err := imagestore.NewPebbleImageStore(storeConfig)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	mux := http.NewServeMux()
+	handlers.NewImageHandler(store, config.DefaultConfig().Server).RegisterRoutes(mux)
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	return server, store
+}
+
+// encodeScreenshot draws a 16x16 image whose bottom-right quadrant varies
+// with seed, so images share three of four 8x8 tiles
+func encodeScreenshot(t *testing.T, seed uint8) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			c := color.RGBA{uint8(x * 16), uint8(y * 16), 90, 255}
+			if x >= 8 && y >= 8 {
+				c.B = seed
+			}
+			img.Set(x, y, c)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestUploadSkipsKnownTiles(t *testing.T) {
+	server, store := newTestServer(t, 8)
+
+	c := New(server.URL)
+	c.TileSize = 8
+
+	first := encodeScreenshot(t, 1)
+	result, err := c.Upload(context.Background(), "team/first", first)
+	if err != nil {
+		t.Fatalf("failed to upload: %v", err)
+	}
+	if result.Tiles != 4 || result.TilesUploaded != 4 {
+		t.Errorf("expected all 4 tiles uploaded, got %+v", result)
+	}
+
+	result, err = c.Upload(context.Background(), "team/second", encodeScreenshot(t, 2))
+	if err != nil {
+		t.Fatalf("failed to upload: %v", err)
+	}
+	if result.TilesUploaded != 1 || result.BytesUploaded != 8*8*3 {
+		t.Errorf("expected only the changed tile uploaded, got %+v", result)
+	}
+
+	// Re-uploading identical content sends no tile data at all
+	result, err = c.Upload(context.Background(), "team/first-again", first)
+	if err != nil {
+		t.Fatalf("failed to upload: %v", err)
+	}
+	if result.TilesUploaded != 0 {
+		t.Errorf("expected no tiles uploaded, got %+v", result)
+	}
+
+	// The server reconstructs exactly what a direct store would
+	retrieved, err := store.RetrieveImage("team/first")
+	if err != nil {
+		t.Fatalf("failed to retrieve image: %v", err)
+	}
+	if err := store.StoreImage("direct", first); err != nil {
+		t.Fatalf("failed to store image: %v", err)
+	}
+	direct, _ := store.RetrieveImage("direct")
+	if !bytes.Equal(retrieved, direct) {
+		t.Error("manifest upload reconstructs differently from a direct store")
+	}
+}
+
+func TestUploadAdoptsServerTileSize(t *testing.T) {
+	server, store := newTestServer(t, 8)
+
+	c := New(server.URL) // Defaults to 256
+	if _, err := c.Upload(context.Background(), "shot", encodeScreenshot(t, 3)); err != nil {
+		t.Fatalf("failed to upload: %v", err)
+	}
+	if c.TileSize != 8 {
+		t.Errorf("expected client to adopt server tile size 8, got %d", c.TileSize)
+	}
+	if _, err := store.RetrieveImage("shot"); err != nil {
+		t.Errorf("expected image to be stored: %v", err)
+	}
+}
@@ -3,28 +3,232 @@ package config
 import (
 	"encoding/json"
 	"fmt"
+	"maps"
+	"net/url"
 	"os"
 	"path/filepath"
+	"time"
+
+	"github.com/gordyf/imageencoder/lib/imagestore"
 )
 
 // ServerConfig holds HTTP server configuration
 type ServerConfig struct {
-	Port         int    `json:"port"`
-	Host         string `json:"host"`
-	ReadTimeout  int    `json:"read_timeout_seconds"`
-	WriteTimeout int    `json:"write_timeout_seconds"`
+	Port                 int                 `json:"port"`
+	Host                 string              `json:"host"`
+	ReadTimeout          int                 `json:"read_timeout_seconds"`
+	WriteTimeout         int                 `json:"write_timeout_seconds"`
+	MaxUploadBytes       int64               `json:"max_upload_bytes"`
+	MultipartMemoryBytes int64               `json:"multipart_memory_bytes"`
+	UnixSocket           string              `json:"unix_socket"` // Also listen on this socket path
+	DisableTCP           bool                `json:"disable_tcp"` // Listen only on UnixSocket
+	RouteTimeouts        RouteTimeoutsConfig `json:"route_timeouts"`
+}
+
+// RouteTimeoutsConfig bounds how long individual routes may run. A request
+// past its limit has its store operation cancelled and is answered with
+// 503. Zero fields leave a route bounded only by the write timeout.
+type RouteTimeoutsConfig struct {
+	StoreSeconds    int `json:"store_seconds"`    // Uploads to POST /images and /images/{id}
+	RetrieveSeconds int `json:"retrieve_seconds"` // GET /images/{id}
+	StatsSeconds    int `json:"stats_seconds"`    // GET /stats
 }
 
 // ImageStoreConfig holds image store configuration
 type ImageStoreConfig struct {
-	TileSize     int    `json:"tile_size"`
-	DatabasePath string `json:"database_path"`
+	TileSize                  int                          `json:"tile_size"`
+	AutoTileSize              bool                         `json:"auto_tile_size"` // Pick 128, 256 or 512 pixel tiles per upload; tile_size still applies to manifest uploads
+	DatabasePath              string                       `json:"database_path"`
+	TrashRetentionHours       int                          `json:"trash_retention_hours"`
+	TrashPurgeSecs            int                          `json:"trash_purge_interval_seconds"`
+	CompressionLevel          string                       `json:"compression_level"`
+	CompactionLevel           string                       `json:"compaction_level"`
+	TileCodecs                []string                     `json:"tile_codecs"`
+	CanonicalizeTiles         bool                         `json:"canonicalize_tiles"`
+	ClusterIntervalSecs       int                          `json:"cluster_interval_seconds"`
+	ClusterThreshold          float64                      `json:"cluster_threshold"`
+	ExpirySweepSecs           int                          `json:"expiry_sweep_interval_seconds"`
+	Quotas                    map[string]QuotaConfig       `json:"quotas"`
+	DefaultQuota              QuotaConfig                  `json:"default_quota"`
+	UpstreamURL               string                       `json:"upstream_url"` // Serve as an edge cache in front of this instance
+	SyncPolicy                string                       `json:"sync_policy"`  // image, batch or none
+	SyncIntervalMillis        int                          `json:"sync_interval_milliseconds"`
+	BytesPerSync              int                          `json:"bytes_per_sync"`
+	WALBytesPerSync           int                          `json:"wal_bytes_per_sync"`
+	OpenTimeoutSecs           int                          `json:"open_timeout_seconds"` // Wait this long for another process to release the database
+	ReadOnly                  bool                         `json:"read_only"`
+	MaxWriters                int                          `json:"max_writers"`       // 0 is unlimited
+	MaxQueuedWrites           int                          `json:"max_queued_writes"` // 0 is unbounded
+	ColdTier                  ColdTierConfig               `json:"cold_tier"`
+	Retention                 RetentionConfig              `json:"retention"`
+	BackgroundColor           string                       `json:"background_color"` // #rrggbb padding edge tiles; empty is black
+	VerifyWrites              bool                         `json:"verify_writes"`    // Check each upload reconstructs exactly before committing it
+	OnConflict                string                       `json:"on_conflict"`      // overwrite, overwrite-gc, reject or skip-identical
+	ChangeLogSize             int                          `json:"change_log_size"`  // Changes kept for GET /changes; 0 keeps 100000
+	Resources                 ResourcesConfig              `json:"resources"`
+	SlowOperationMillis       int                          `json:"slow_operation_milliseconds"`     // Log stores and retrievals slower than this; 0 disables the log
+	AlignScroll               bool                         `json:"align_scroll"`                    // Line up tiles of screenshots scrolled from the previous upload in their namespace
+	Transforms                map[string][]TransformConfig `json:"transforms"`                      // Upload pipelines by namespace
+	DefaultTransforms         []TransformConfig            `json:"default_transforms"`              // Pipeline for namespaces without an entry in transforms
+	Strips                    map[string]int               `json:"strips"`                          // Strip height by namespace; these namespaces store full-width strips instead of square tiles
+	TilePools                 string                       `json:"tile_pools"`                      // shared or namespace; fixed once the database holds images
+	StatsSnapshotSecs         int                          `json:"stats_snapshot_interval_seconds"` // How often to check for today's stats snapshot; 0 disables the history
+	StatsHistoryDays          int                          `json:"stats_history_days"`              // Days of stats snapshots kept; 0 keeps them all
+	MaxDatabaseBytes          int64                        `json:"max_database_bytes"`              // Refuse writes once the database is this large on disk; 0 disables
+	MinFreeDiskPercent        float64                      `json:"min_free_disk_percent"`           // Refuse writes while less of the database volume is free; 0 disables
+	Backup                    BackupConfig                 `json:"backup"`
+	Pebble                    PebbleConfig                 `json:"pebble"`
+	Blobs                     BlobsConfig                  `json:"blobs"`
+	MaintenanceBytesPerSecond int64                        `json:"maintenance_bytes_per_second"`   // IO budget of garbage collection, recompression and cold tier offload; 0 is unlimited
+	MaintenanceYieldMillis    int                          `json:"maintenance_yield_milliseconds"` // Longest maintenance pauses between chunks for running uploads; 0 never pauses
+}
+
+// ResourcesConfig bounds the work the store takes on at once. Zero fields
+// are unlimited.
+type ResourcesConfig struct {
+	MaxIngestWorkers         int      `json:"max_ingest_workers"` // Same as max_writers
+	MaxReconstructionWorkers int      `json:"max_reconstruction_workers"`
+	MaxDecompressors         int      `json:"max_decompressors"`
+	ImageCacheBytes          int64    `json:"image_cache_bytes"` // Memory for recently retrieved images; 0 disables the cache
+	Preload                  []string `json:"preload"`           // Image ID prefixes cached at startup and after recompression
+}
+
+// ColdTierConfig selects where tiles of rarely retrieved images are moved.
+// The tier is disabled when neither Dir nor S3Bucket is set.
+type ColdTierConfig struct {
+	Dir          string `json:"dir"`
+	S3Bucket     string `json:"s3_bucket"`
+	S3Prefix     string `json:"s3_prefix"`
+	S3Region     string `json:"s3_region"`
+	S3Endpoint   string `json:"s3_endpoint"` // Optional S3-compatible service
+	AfterDays    int    `json:"after_days"`  // Offload tiles of images not retrieved for this long
+	IntervalSecs int    `json:"interval_seconds"`
+}
+
+// BlobsConfig moves large tile encodings out of the database into
+// append-only blob files. Blob separation is off while ThresholdBytes is 0.
+type BlobsConfig struct {
+	ThresholdBytes int     `json:"threshold_bytes"`     // Encodings at least this large go to blob files
+	FileBytes      int64   `json:"file_bytes"`          // Size at which a new blob file is started; 0 is 256MB
+	GarbageRatio   float64 `json:"garbage_ratio"`       // Share of a file no tile points to before it is rewritten; 0 is 0.5
+	GCIntervalSecs int     `json:"gc_interval_seconds"` // How often blob files are collected; 0 disables the job
+	DisableMmap    bool    `json:"disable_mmap"`        // Read blob files with ReadAt instead of memory maps
+}
+
+// BackupConfig selects where backups are uploaded and when they are taken.
+// Backups are disabled when neither Dir nor S3Bucket is set.
+type BackupConfig struct {
+	Dir        string `json:"dir"`
+	S3Bucket   string `json:"s3_bucket"`
+	S3Prefix   string `json:"s3_prefix"`
+	S3Region   string `json:"s3_region"`
+	S3Endpoint string `json:"s3_endpoint"` // Optional S3-compatible service
+	Schedule   string `json:"schedule"`    // Cron expression in UTC, e.g. "0 3 * * *"; empty only backs up on request
+	Keep       int    `json:"keep"`        // Newest backups kept; 0 keeps them all
+}
+
+// Enabled reports whether a backup target is configured
+func (c BackupConfig) Enabled() bool {
+	return c.Dir != "" || c.S3Bucket != ""
+}
+
+// RetentionConfig lists retention policies and how often they are enforced
+type RetentionConfig struct {
+	IntervalSecs int                     `json:"interval_seconds"` // 0 only enforces them on request
+	Policies     []RetentionPolicyConfig `json:"policies"`
+}
+
+// RetentionPolicyConfig limits the live images under an ID prefix; zero
+// fields are unlimited
+type RetentionPolicyConfig struct {
+	Prefix           string `json:"prefix"`
+	MaxAgeDays       int    `json:"max_age_days"`
+	MaxCount         int    `json:"max_count"`
+	MaxOriginalBytes int64  `json:"max_original_bytes"`
+	MaxStoredBytes   int64  `json:"max_stored_bytes"` // Counts the bytes each image would free if deleted alone
+}
+
+// Enabled reports whether a cold tier backend is configured
+func (c ColdTierConfig) Enabled() bool {
+	return c.Dir != "" || c.S3Bucket != ""
+}
+
+// QuotaConfig limits what a namespace may store; zero means unlimited
+type QuotaConfig struct {
+	MaxOriginalBytes int64 `json:"max_original_bytes"`
+	MaxStoredBytes   int64 `json:"max_stored_bytes"`
+}
+
+// TransformConfig is one step of an upload pipeline. Which fields apply
+// depends on the type: autocrop, crop, normalize_dpi or srgb.
+type TransformConfig struct {
+	Type      string `json:"type"`
+	Top       int    `json:"top"`
+	Bottom    int    `json:"bottom"`
+	Left      int    `json:"left"`
+	Right     int    `json:"right"`
+	Width     int    `json:"width"`  // Crop only images this wide; 0 matches any
+	Height    int    `json:"height"` // Crop only images this tall; 0 matches any
+	Tolerance int    `json:"tolerance"`
+	DPI       int    `json:"dpi"`
+}
+
+// PebbleConfig tunes the storage engine for large values; see
+// imagestore.PebbleTuning. Zero fields keep Pebble's defaults, except
+// SSTableCompression, which defaults to none since tiles are already
+// compressed.
+type PebbleConfig struct {
+	CacheBytes                  int64  `json:"cache_bytes"`
+	MemTableBytes               uint64 `json:"memtable_bytes"`
+	MemTableStopWritesThreshold int    `json:"memtable_stop_writes_threshold"`
+	L0CompactionThreshold       int    `json:"l0_compaction_threshold"`
+	L0StopWritesThreshold       int    `json:"l0_stop_writes_threshold"`
+	LBaseMaxBytes               int64  `json:"lbase_max_bytes"`
+	TargetFileBytes             int64  `json:"target_file_bytes"`
+	BlockBytes                  int    `json:"block_bytes"`
+	MaxConcurrentCompactions    int    `json:"max_concurrent_compactions"`
+	SSTableCompression          string `json:"sstable_compression"` // none, snappy or zstd
+}
+
+// WatchConfig holds directory watch configuration. Watching is disabled
+// when Dir is empty.
+type WatchConfig struct {
+	Dir          string `json:"dir"`
+	AfterStore   string `json:"after_store"` // keep, delete or archive
+	ArchiveDir   string `json:"archive_dir"`
+	SettleMillis int    `json:"settle_milliseconds"`
+}
+
+// PDFConfig holds PDF ingestion configuration. PDF uploads are rejected
+// when Rasterizer is empty.
+type PDFConfig struct {
+	Rasterizer string `json:"rasterizer"` // Path to pdftoppm
+	DPI        int    `json:"dpi"`
+	MaxPages   int    `json:"max_pages"`
+}
+
+// AuthConfig lists the bearer tokens the API accepts and the roles each
+// holds. Every request is allowed when Tokens is empty. The section is
+// reloaded when the server receives SIGHUP.
+type AuthConfig struct {
+	Tokens     []TokenConfig `json:"tokens"`
+	SigningKey string        `json:"signing_key"` // Secret for signed retrieval URLs; empty disables them
+}
+
+// TokenConfig grants a token roles in namespaces
+type TokenConfig struct {
+	Name  string            `json:"name"`  // Who holds the token, recorded in image history
+	Token string            `json:"token"` // Sent as "Authorization: Bearer <token>"
+	Roles map[string]string `json:"roles"` // Namespace to reader, writer or admin; "*" covers every namespace
 }
 
 // Config holds the complete application configuration
 type Config struct {
 	Server     ServerConfig     `json:"server"`
 	ImageStore ImageStoreConfig `json:"image_store"`
+	Watch      WatchConfig      `json:"watch"`
+	PDF        PDFConfig        `json:"pdf"`
+	Auth       AuthConfig       `json:"auth"`
 	LogLevel   string           `json:"log_level"`
 }
 
@@ -32,14 +236,46 @@ type Config struct {
 func DefaultConfig() *Config {
 	return &Config{
 		Server: ServerConfig{
-			Port:         8080,
-			Host:         "localhost",
-			ReadTimeout:  30,
-			WriteTimeout: 30,
+			Port:                 8080,
+			Host:                 "localhost",
+			ReadTimeout:          30,
+			WriteTimeout:         30,
+			MaxUploadBytes:       50 << 20, // 50MB
+			MultipartMemoryBytes: 32 << 20, // 32MB
+			RouteTimeouts: RouteTimeoutsConfig{
+				StoreSeconds:    20,
+				RetrieveSeconds: 10,
+				StatsSeconds:    20,
+			},
 		},
 		ImageStore: ImageStoreConfig{
-			TileSize:     256,
-			DatabasePath: "./imagestore.db",
+			TileSize:               256,
+			DatabasePath:           "./imagestore.db",
+			TrashRetentionHours:    168, // 7 days
+			TrashPurgeSecs:         3600,
+			CompressionLevel:       "default",
+			CompactionLevel:        "best",
+			TileCodecs:             []string{"zstd"},
+			ClusterThreshold:       0.5,
+			ExpirySweepSecs:        60,
+			StatsSnapshotSecs:      3600,
+			MaintenanceYieldMillis: 1000,
+			SyncPolicy:             "image",
+			ColdTier: ColdTierConfig{
+				AfterDays:    30,
+				IntervalSecs: 3600,
+			},
+			Blobs: BlobsConfig{
+				GCIntervalSecs: 3600,
+			},
+		},
+		Watch: WatchConfig{
+			AfterStore:   "keep",
+			SettleMillis: 1000,
+		},
+		PDF: PDFConfig{
+			DPI:      150,
+			MaxPages: 500,
 		},
 		LogLevel: "info",
 	}
@@ -94,7 +330,11 @@ func SaveConfig(config *Config, configPath string) error {
 // Validate validates the configuration
 func (c *Config) Validate() error {
 	// Validate server config
-	if c.Server.Port <= 0 || c.Server.Port > 65535 {
+	if c.Server.DisableTCP {
+		if c.Server.UnixSocket == "" {
+			return fmt.Errorf("disable_tcp requires unix_socket")
+		}
+	} else if c.Server.Port <= 0 || c.Server.Port > 65535 {
 		return fmt.Errorf("invalid server port: %d", c.Server.Port)
 	}
 
@@ -106,6 +346,18 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("invalid write timeout: %d", c.Server.WriteTimeout)
 	}
 
+	if c.Server.MaxUploadBytes <= 0 {
+		return fmt.Errorf("invalid max upload bytes: %d", c.Server.MaxUploadBytes)
+	}
+
+	if c.Server.MultipartMemoryBytes <= 0 {
+		return fmt.Errorf("invalid multipart memory bytes: %d", c.Server.MultipartMemoryBytes)
+	}
+
+	if timeouts := c.Server.RouteTimeouts; timeouts.StoreSeconds < 0 || timeouts.RetrieveSeconds < 0 || timeouts.StatsSeconds < 0 {
+		return fmt.Errorf("invalid route timeouts: store %d, retrieve %d, stats %d", timeouts.StoreSeconds, timeouts.RetrieveSeconds, timeouts.StatsSeconds)
+	}
+
 	// Validate image store config
 	if c.ImageStore.TileSize <= 0 {
 		return fmt.Errorf("invalid tile size: %d", c.ImageStore.TileSize)
@@ -115,6 +367,225 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("database path cannot be empty")
 	}
 
+	if c.ImageStore.TrashRetentionHours < 0 {
+		return fmt.Errorf("invalid trash retention hours: %d", c.ImageStore.TrashRetentionHours)
+	}
+
+	if c.ImageStore.ClusterIntervalSecs < 0 {
+		return fmt.Errorf("invalid cluster interval: %d", c.ImageStore.ClusterIntervalSecs)
+	}
+
+	if c.ImageStore.ExpirySweepSecs < 0 {
+		return fmt.Errorf("invalid expiry sweep interval: %d", c.ImageStore.ExpirySweepSecs)
+	}
+
+	if c.ImageStore.StatsSnapshotSecs < 0 || c.ImageStore.StatsHistoryDays < 0 {
+		return fmt.Errorf("invalid stats history: %d second interval, %d days", c.ImageStore.StatsSnapshotSecs, c.ImageStore.StatsHistoryDays)
+	}
+
+	if c.ImageStore.MaintenanceBytesPerSecond < 0 || c.ImageStore.MaintenanceYieldMillis < 0 {
+		return fmt.Errorf("invalid maintenance pacing: %d bytes per second, %dms yield", c.ImageStore.MaintenanceBytesPerSecond, c.ImageStore.MaintenanceYieldMillis)
+	}
+
+	if err := imagestore.ValidatePebbleTuning(imagestore.PebbleTuning(c.ImageStore.Pebble)); err != nil {
+		return err
+	}
+
+	if c.ImageStore.MaxDatabaseBytes < 0 {
+		return fmt.Errorf("invalid max database bytes: %d", c.ImageStore.MaxDatabaseBytes)
+	}
+
+	if c.ImageStore.MinFreeDiskPercent < 0 || c.ImageStore.MinFreeDiskPercent >= 100 {
+		return fmt.Errorf("invalid min free disk percent: %g (must be at least 0 and below 100)", c.ImageStore.MinFreeDiskPercent)
+	}
+
+	for namespace, quota := range c.ImageStore.Quotas {
+		if quota.MaxOriginalBytes < 0 || quota.MaxStoredBytes < 0 {
+			return fmt.Errorf("invalid quota for namespace %q", namespace)
+		}
+	}
+
+	if c.ImageStore.DefaultQuota.MaxOriginalBytes < 0 || c.ImageStore.DefaultQuota.MaxStoredBytes < 0 {
+		return fmt.Errorf("invalid default quota")
+	}
+
+	if c.ImageStore.UpstreamURL != "" {
+		upstream, err := url.Parse(c.ImageStore.UpstreamURL)
+		if err != nil || (upstream.Scheme != "http" && upstream.Scheme != "https") || upstream.Host == "" {
+			return fmt.Errorf("invalid upstream URL: %s", c.ImageStore.UpstreamURL)
+		}
+	}
+
+	switch c.ImageStore.SyncPolicy {
+	case "", "image", "batch", "none":
+	default:
+		return fmt.Errorf("invalid sync policy: %s", c.ImageStore.SyncPolicy)
+	}
+
+	if c.ImageStore.SyncIntervalMillis < 0 {
+		return fmt.Errorf("invalid sync interval: %d", c.ImageStore.SyncIntervalMillis)
+	}
+
+	if c.ImageStore.OpenTimeoutSecs < 0 {
+		return fmt.Errorf("invalid open timeout: %d", c.ImageStore.OpenTimeoutSecs)
+	}
+
+	if c.ImageStore.SlowOperationMillis < 0 {
+		return fmt.Errorf("invalid slow operation threshold: %d", c.ImageStore.SlowOperationMillis)
+	}
+
+	if c.ImageStore.MaxWriters < 0 || c.ImageStore.MaxQueuedWrites < 0 {
+		return fmt.Errorf("invalid write queue: %d writers, %d queued", c.ImageStore.MaxWriters, c.ImageStore.MaxQueuedWrites)
+	}
+
+	if c.ImageStore.BytesPerSync < 0 || c.ImageStore.WALBytesPerSync < 0 {
+		return fmt.Errorf("invalid bytes per sync: %d, WAL %d", c.ImageStore.BytesPerSync, c.ImageStore.WALBytesPerSync)
+	}
+
+	if coldTier := c.ImageStore.ColdTier; coldTier.Enabled() {
+		if coldTier.Dir != "" && coldTier.S3Bucket != "" {
+			return fmt.Errorf("cold tier cannot use both a directory and an S3 bucket")
+		}
+		if coldTier.AfterDays <= 0 || coldTier.IntervalSecs < 0 {
+			return fmt.Errorf("invalid cold tier: after %d days, every %d seconds", coldTier.AfterDays, coldTier.IntervalSecs)
+		}
+	}
+
+	if blobs := c.ImageStore.Blobs; blobs.ThresholdBytes < 0 || blobs.FileBytes < 0 || blobs.GCIntervalSecs < 0 {
+		return fmt.Errorf("invalid blobs: threshold %d bytes, files of %d bytes, collected every %d seconds", blobs.ThresholdBytes, blobs.FileBytes, blobs.GCIntervalSecs)
+	}
+	if ratio := c.ImageStore.Blobs.GarbageRatio; ratio < 0 || ratio > 1 {
+		return fmt.Errorf("invalid blob garbage ratio: %g, must be between 0 and 1", ratio)
+	}
+
+	if backup := c.ImageStore.Backup; backup.Enabled() || backup.Schedule != "" {
+		if backup.Dir != "" && backup.S3Bucket != "" {
+			return fmt.Errorf("backups cannot use both a directory and an S3 bucket")
+		}
+		if !backup.Enabled() {
+			return fmt.Errorf("backup schedule needs a dir or s3_bucket to back up to")
+		}
+		if backup.Keep < 0 {
+			return fmt.Errorf("invalid backup keep: %d", backup.Keep)
+		}
+		if backup.Schedule != "" {
+			if err := imagestore.ValidateSchedule(backup.Schedule); err != nil {
+				return fmt.Errorf("invalid backup: %w", err)
+			}
+		}
+	}
+
+	if c.ImageStore.Retention.IntervalSecs < 0 {
+		return fmt.Errorf("invalid retention interval: %d", c.ImageStore.Retention.IntervalSecs)
+	}
+
+	for _, policy := range c.ImageStore.Retention.Policies {
+		if policy.MaxAgeDays < 0 || policy.MaxCount < 0 || policy.MaxOriginalBytes < 0 || policy.MaxStoredBytes < 0 {
+			return fmt.Errorf("invalid retention policy for prefix %q", policy.Prefix)
+		}
+	}
+
+	if c.ImageStore.ClusterThreshold < 0 || c.ImageStore.ClusterThreshold > 1 {
+		return fmt.Errorf("invalid cluster threshold: %f", c.ImageStore.ClusterThreshold)
+	}
+
+	if c.ImageStore.TrashPurgeSecs < 0 {
+		return fmt.Errorf("invalid trash purge interval: %d", c.ImageStore.TrashPurgeSecs)
+	}
+
+	validCompressionLevels := map[string]bool{
+		"":        true,
+		"fastest": true,
+		"default": true,
+		"better":  true,
+		"best":    true,
+	}
+
+	if !validCompressionLevels[c.ImageStore.CompressionLevel] {
+		return fmt.Errorf("invalid compression level: %s", c.ImageStore.CompressionLevel)
+	}
+
+	if !validCompressionLevels[c.ImageStore.CompactionLevel] {
+		return fmt.Errorf("invalid compaction level: %s", c.ImageStore.CompactionLevel)
+	}
+
+	if _, err := imagestore.ParseBackground(c.ImageStore.BackgroundColor); err != nil {
+		return err
+	}
+
+	switch c.ImageStore.OnConflict {
+	case "", imagestore.ConflictOverwrite, imagestore.ConflictOverwriteGC, imagestore.ConflictReject, imagestore.ConflictSkip:
+	default:
+		return fmt.Errorf("invalid on_conflict policy: %s", c.ImageStore.OnConflict)
+	}
+
+	switch c.ImageStore.TilePools {
+	case "", imagestore.TilePoolsShared, imagestore.TilePoolsNamespace:
+	default:
+		return fmt.Errorf("invalid tile_pools mode: %s", c.ImageStore.TilePools)
+	}
+
+	for _, transform := range c.ImageStore.DefaultTransforms {
+		if err := imagestore.ValidateTransform(imagestore.Transform(transform)); err != nil {
+			return fmt.Errorf("default transforms: %w", err)
+		}
+	}
+	for namespace, transforms := range c.ImageStore.Transforms {
+		for _, transform := range transforms {
+			if err := imagestore.ValidateTransform(imagestore.Transform(transform)); err != nil {
+				return fmt.Errorf("transforms for namespace %q: %w", namespace, err)
+			}
+		}
+	}
+	for namespace, height := range c.ImageStore.Strips {
+		if err := imagestore.ValidateStripHeight(height); err != nil {
+			return fmt.Errorf("strips for namespace %q: %w", namespace, err)
+		}
+	}
+
+	resources := c.ImageStore.Resources
+	if resources.MaxIngestWorkers < 0 || resources.MaxReconstructionWorkers < 0 || resources.MaxDecompressors < 0 || resources.ImageCacheBytes < 0 {
+		return fmt.Errorf("invalid resource limits: %d ingest workers, %d reconstruction workers, %d decompressors, %d image cache bytes",
+			resources.MaxIngestWorkers, resources.MaxReconstructionWorkers, resources.MaxDecompressors, resources.ImageCacheBytes)
+	}
+	if len(resources.Preload) > 0 && resources.ImageCacheBytes == 0 {
+		return fmt.Errorf("preload needs image_cache_bytes to be set")
+	}
+	if resources.MaxIngestWorkers > 0 && c.ImageStore.MaxWriters > 0 && resources.MaxIngestWorkers != c.ImageStore.MaxWriters {
+		return fmt.Errorf("max_ingest_workers (%d) and max_writers (%d) disagree", resources.MaxIngestWorkers, c.ImageStore.MaxWriters)
+	}
+
+	if c.ImageStore.ChangeLogSize < 0 {
+		return fmt.Errorf("invalid change log size: %d", c.ImageStore.ChangeLogSize)
+	}
+
+	switch c.Watch.AfterStore {
+	case "", "keep", "delete":
+	case "archive":
+		if c.Watch.ArchiveDir == "" {
+			return fmt.Errorf("watch archive directory cannot be empty when archiving")
+		}
+	default:
+		return fmt.Errorf("invalid watch after_store action: %s", c.Watch.AfterStore)
+	}
+
+	if c.Watch.SettleMillis < 0 {
+		return fmt.Errorf("invalid watch settle delay: %d", c.Watch.SettleMillis)
+	}
+
+	if c.PDF.Rasterizer != "" {
+		if c.PDF.DPI < 1 || c.PDF.DPI > 1200 {
+			return fmt.Errorf("invalid PDF DPI: %d", c.PDF.DPI)
+		}
+		if c.PDF.MaxPages < 1 {
+			return fmt.Errorf("invalid PDF max pages: %d", c.PDF.MaxPages)
+		}
+	}
+
+	if err := c.Auth.Validate(); err != nil {
+		return err
+	}
+
 	// Validate log level
 	validLogLevels := map[string]bool{
 		"debug": true,
@@ -130,6 +601,145 @@ func (c *Config) Validate() error {
 	return nil
 }
 
+// Validate checks the auth section on its own, so a reload can be checked
+// without the rest of the file
+func (c *AuthConfig) Validate() error {
+	if c.SigningKey != "" && len(c.SigningKey) < 32 {
+		return fmt.Errorf("auth signing_key must be at least 32 characters")
+	}
+
+	names := make(map[string]bool)
+	tokens := make(map[string]bool)
+	for _, token := range c.Tokens {
+		if token.Name == "" || token.Token == "" {
+			return fmt.Errorf("auth tokens need a name and a token")
+		}
+		if names[token.Name] || tokens[token.Token] {
+			return fmt.Errorf("duplicate auth token %s", token.Name)
+		}
+		names[token.Name] = true
+		tokens[token.Token] = true
+
+		for namespace, role := range token.Roles {
+			switch role {
+			case "reader", "writer", "admin":
+			default:
+				return fmt.Errorf("invalid role %q for token %s in namespace %q", role, token.Name, namespace)
+			}
+		}
+	}
+	return nil
+}
+
+// StoreConfig converts the image store section into an imagestore.Config.
+// UpstreamURL and the cold tier backend are left for the caller to wire up as
+// Config.Upstream and Config.ColdStore.
+func (c *ImageStoreConfig) StoreConfig() *imagestore.Config {
+	storeConfig := imagestore.DefaultConfig()
+	storeConfig.TileSize = c.TileSize
+	storeConfig.AutoTileSize = c.AutoTileSize
+	storeConfig.DatabasePath = c.DatabasePath
+	storeConfig.TrashRetention = time.Duration(c.TrashRetentionHours) * time.Hour
+	storeConfig.TrashPurgeInterval = time.Duration(c.TrashPurgeSecs) * time.Second
+	storeConfig.CanonicalizeTiles = c.CanonicalizeTiles
+	storeConfig.ClusterInterval = time.Duration(c.ClusterIntervalSecs) * time.Second
+	storeConfig.ExpirySweepInterval = time.Duration(c.ExpirySweepSecs) * time.Second
+	storeConfig.DefaultQuota = imagestore.Quota(c.DefaultQuota)
+	storeConfig.SyncInterval = time.Duration(c.SyncIntervalMillis) * time.Millisecond
+	storeConfig.BytesPerSync = c.BytesPerSync
+	storeConfig.WALBytesPerSync = c.WALBytesPerSync
+	storeConfig.OpenTimeout = time.Duration(c.OpenTimeoutSecs) * time.Second
+	storeConfig.SlowOperationThreshold = time.Duration(c.SlowOperationMillis) * time.Millisecond
+	storeConfig.ReadOnly = c.ReadOnly
+	storeConfig.MaxWriters = c.MaxWriters
+	storeConfig.MaxQueuedWrites = c.MaxQueuedWrites
+	storeConfig.ColdAfter = time.Duration(c.ColdTier.AfterDays) * 24 * time.Hour
+	storeConfig.ColdTierInterval = time.Duration(c.ColdTier.IntervalSecs) * time.Second
+	storeConfig.RetentionInterval = time.Duration(c.Retention.IntervalSecs) * time.Second
+	storeConfig.Background = c.BackgroundColor
+	storeConfig.VerifyWrites = c.VerifyWrites
+	storeConfig.AlignScroll = c.AlignScroll
+	storeConfig.TilePools = c.TilePools
+	storeConfig.StatsSnapshotInterval = time.Duration(c.StatsSnapshotSecs) * time.Second
+	storeConfig.StatsHistoryDays = c.StatsHistoryDays
+	storeConfig.MaxDatabaseBytes = c.MaxDatabaseBytes
+	storeConfig.MinFreeDiskPercent = c.MinFreeDiskPercent
+	storeConfig.BackupSchedule = c.Backup.Schedule
+	storeConfig.BackupKeep = c.Backup.Keep
+	storeConfig.MaintenanceBytesPerSecond = c.MaintenanceBytesPerSecond
+	storeConfig.Pebble = imagestore.PebbleTuning(c.Pebble)
+	storeConfig.MaintenanceYield = time.Duration(c.MaintenanceYieldMillis) * time.Millisecond
+	storeConfig.BlobThreshold = c.Blobs.ThresholdBytes
+	storeConfig.BlobFileBytes = c.Blobs.FileBytes
+	storeConfig.BlobGarbageRatio = c.Blobs.GarbageRatio
+	storeConfig.BlobGCInterval = time.Duration(c.Blobs.GCIntervalSecs) * time.Second
+	storeConfig.DisableBlobMmap = c.Blobs.DisableMmap
+	storeConfig.OnConflict = c.OnConflict
+	storeConfig.ChangeLogSize = c.ChangeLogSize
+	if c.Resources.MaxIngestWorkers > 0 {
+		storeConfig.MaxWriters = c.Resources.MaxIngestWorkers
+	}
+	storeConfig.Resources = imagestore.Resources{
+		MaxReconstructions: c.Resources.MaxReconstructionWorkers,
+		MaxDecompressors:   c.Resources.MaxDecompressors,
+		ImageCacheBytes:    c.Resources.ImageCacheBytes,
+		Preload:            c.Resources.Preload,
+	}
+
+	for _, policy := range c.Retention.Policies {
+		storeConfig.RetentionPolicies = append(storeConfig.RetentionPolicies, imagestore.RetentionPolicy{
+			Prefix:           policy.Prefix,
+			MaxAge:           time.Duration(policy.MaxAgeDays) * 24 * time.Hour,
+			MaxCount:         policy.MaxCount,
+			MaxOriginalBytes: policy.MaxOriginalBytes,
+			MaxStoredBytes:   policy.MaxStoredBytes,
+		})
+	}
+
+	if c.CompressionLevel != "" {
+		storeConfig.CompressionLevel = c.CompressionLevel
+	}
+	if c.CompactionLevel != "" {
+		storeConfig.CompactionLevel = c.CompactionLevel
+	}
+	if len(c.TileCodecs) > 0 {
+		storeConfig.TileCodecs = c.TileCodecs
+	}
+	if c.ClusterThreshold > 0 {
+		storeConfig.ClusterThreshold = c.ClusterThreshold
+	}
+	if c.SyncPolicy != "" {
+		storeConfig.SyncPolicy = c.SyncPolicy
+	}
+
+	if len(c.Quotas) > 0 {
+		storeConfig.Quotas = make(map[string]imagestore.Quota, len(c.Quotas))
+		for namespace, quota := range c.Quotas {
+			storeConfig.Quotas[namespace] = imagestore.Quota(quota)
+		}
+	}
+
+	if len(c.Strips) > 0 {
+		storeConfig.Strips = maps.Clone(c.Strips)
+	}
+
+	for _, transform := range c.DefaultTransforms {
+		storeConfig.DefaultTransforms = append(storeConfig.DefaultTransforms, imagestore.Transform(transform))
+	}
+	if len(c.Transforms) > 0 {
+		storeConfig.Transforms = make(map[string][]imagestore.Transform, len(c.Transforms))
+		for namespace, transforms := range c.Transforms {
+			pipeline := make([]imagestore.Transform, len(transforms))
+			for i, transform := range transforms {
+				pipeline[i] = imagestore.Transform(transform)
+			}
+			storeConfig.Transforms[namespace] = pipeline
+		}
+	}
+
+	return storeConfig
+}
+
 // GetServerAddress returns the full server address
 func (c *Config) GetServerAddress() string {
 	return fmt.Sprintf("%s:%d", c.Server.Host, c.Server.Port)
@@ -156,8 +766,22 @@ func LoadConfigFromEnv() *Config {
 		fmt.Sscanf(writeTimeout, "%d", &config.Server.WriteTimeout)
 	}
 
+	if maxUpload := os.Getenv("SERVER_MAX_UPLOAD_BYTES"); maxUpload != "" {
+		fmt.Sscanf(maxUpload, "%d", &config.Server.MaxUploadBytes)
+	}
+
+	if multipartMemory := os.Getenv("SERVER_MULTIPART_MEMORY_BYTES"); multipartMemory != "" {
+		fmt.Sscanf(multipartMemory, "%d", &config.Server.MultipartMemoryBytes)
+	}
+
+	if unixSocket := os.Getenv("SERVER_UNIX_SOCKET"); unixSocket != "" {
+		config.Server.UnixSocket = unixSocket
+	}
+
 	// Image store config from env
-	if tileSize := os.Getenv("TILE_SIZE"); tileSize != "" {
+	if tileSize := os.Getenv("TILE_SIZE"); tileSize == "auto" {
+		config.ImageStore.AutoTileSize = true
+	} else if tileSize != "" {
 		fmt.Sscanf(tileSize, "%d", &config.ImageStore.TileSize)
 	}
 
@@ -165,6 +789,36 @@ func LoadConfigFromEnv() *Config {
 		config.ImageStore.DatabasePath = dbPath
 	}
 
+	if trashRetention := os.Getenv("TRASH_RETENTION_HOURS"); trashRetention != "" {
+		fmt.Sscanf(trashRetention, "%d", &config.ImageStore.TrashRetentionHours)
+	}
+
+	if compressionLevel := os.Getenv("COMPRESSION_LEVEL"); compressionLevel != "" {
+		config.ImageStore.CompressionLevel = compressionLevel
+	}
+
+	if compactionLevel := os.Getenv("COMPACTION_LEVEL"); compactionLevel != "" {
+		config.ImageStore.CompactionLevel = compactionLevel
+	}
+
+	if upstreamURL := os.Getenv("UPSTREAM_URL"); upstreamURL != "" {
+		config.ImageStore.UpstreamURL = upstreamURL
+	}
+
+	if syncPolicy := os.Getenv("SYNC_POLICY"); syncPolicy != "" {
+		config.ImageStore.SyncPolicy = syncPolicy
+	}
+
+	// Watch config from env
+	if watchDir := os.Getenv("WATCH_DIR"); watchDir != "" {
+		config.Watch.Dir = watchDir
+	}
+
+	// PDF config from env
+	if rasterizer := os.Getenv("PDF_RASTERIZER"); rasterizer != "" {
+		config.PDF.Rasterizer = rasterizer
+	}
+
 	// Log level from env
 	if logLevel := os.Getenv("LOG_LEVEL"); logLevel != "" {
 		config.LogLevel = logLevel
@@ -5,6 +5,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestDefaultConfig(t *testing.T) {
@@ -25,6 +26,14 @@ func TestDefaultConfig(t *testing.T) {
 	if config.LogLevel != "info" {
 		t.Errorf("expected default log level 'info', got %s", config.LogLevel)
 	}
+
+	if config.Server.MaxUploadBytes != 50<<20 {
+		t.Errorf("expected default max upload bytes %d, got %d", 50<<20, config.Server.MaxUploadBytes)
+	}
+
+	if config.Server.MultipartMemoryBytes != 32<<20 {
+		t.Errorf("expected default multipart memory bytes %d, got %d", 32<<20, config.Server.MultipartMemoryBytes)
+	}
 }
 
 func TestConfigValidation(t *testing.T) {
@@ -65,6 +74,24 @@ func TestConfigValidation(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "invalid max upload bytes",
+			config: &Config{
+				Server:     ServerConfig{Port: 8080, Host: "localhost", ReadTimeout: 30, WriteTimeout: 30, MaxUploadBytes: 0, MultipartMemoryBytes: 32 << 20},
+				ImageStore: ImageStoreConfig{TileSize: 256, DatabasePath: "./test.db"},
+				LogLevel:   "info",
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid multipart memory bytes",
+			config: &Config{
+				Server:     ServerConfig{Port: 8080, Host: "localhost", ReadTimeout: 30, WriteTimeout: 30, MaxUploadBytes: 50 << 20, MultipartMemoryBytes: -1},
+				ImageStore: ImageStoreConfig{TileSize: 256, DatabasePath: "./test.db"},
+				LogLevel:   "info",
+			},
+			wantErr: true,
+		},
 		{
 			name: "invalid tile size",
 			config: &Config{
@@ -83,6 +110,380 @@ func TestConfigValidation(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "negative trash retention",
+			config: &Config{
+				Server:     ServerConfig{Port: 8080, Host: "localhost", ReadTimeout: 30, WriteTimeout: 30, MaxUploadBytes: 50 << 20, MultipartMemoryBytes: 32 << 20},
+				ImageStore: ImageStoreConfig{TileSize: 256, DatabasePath: "./test.db", TrashRetentionHours: -1},
+				LogLevel:   "info",
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid compression level",
+			config: &Config{
+				Server:     ServerConfig{Port: 8080, Host: "localhost", ReadTimeout: 30, WriteTimeout: 30, MaxUploadBytes: 50 << 20, MultipartMemoryBytes: 32 << 20},
+				ImageStore: ImageStoreConfig{TileSize: 256, DatabasePath: "./test.db", CompressionLevel: "ludicrous"},
+				LogLevel:   "info",
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid cluster threshold",
+			config: &Config{
+				Server:     ServerConfig{Port: 8080, Host: "localhost", ReadTimeout: 30, WriteTimeout: 30, MaxUploadBytes: 50 << 20, MultipartMemoryBytes: 32 << 20},
+				ImageStore: ImageStoreConfig{TileSize: 256, DatabasePath: "./test.db", ClusterThreshold: 1.5},
+				LogLevel:   "info",
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid expiry sweep interval",
+			config: &Config{
+				Server:     ServerConfig{Port: 8080, Host: "localhost", ReadTimeout: 30, WriteTimeout: 30, MaxUploadBytes: 50 << 20, MultipartMemoryBytes: 32 << 20},
+				ImageStore: ImageStoreConfig{TileSize: 256, DatabasePath: "./test.db", ExpirySweepSecs: -1},
+				LogLevel:   "info",
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid namespace quota",
+			config: &Config{
+				Server:     ServerConfig{Port: 8080, Host: "localhost", ReadTimeout: 30, WriteTimeout: 30, MaxUploadBytes: 50 << 20, MultipartMemoryBytes: 32 << 20},
+				ImageStore: ImageStoreConfig{TileSize: 256, DatabasePath: "./test.db", Quotas: map[string]QuotaConfig{"team": {MaxStoredBytes: -1}}},
+				LogLevel:   "info",
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid upstream URL",
+			config: &Config{
+				Server:     ServerConfig{Port: 8080, Host: "localhost", ReadTimeout: 30, WriteTimeout: 30, MaxUploadBytes: 50 << 20, MultipartMemoryBytes: 32 << 20},
+				ImageStore: ImageStoreConfig{TileSize: 256, DatabasePath: "./test.db", UpstreamURL: "primary:8080"},
+				LogLevel:   "info",
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid sync policy",
+			config: &Config{
+				Server:     ServerConfig{Port: 8080, Host: "localhost", ReadTimeout: 30, WriteTimeout: 30, MaxUploadBytes: 50 << 20, MultipartMemoryBytes: 32 << 20},
+				ImageStore: ImageStoreConfig{TileSize: 256, DatabasePath: "./test.db", SyncPolicy: "sometimes"},
+				LogLevel:   "info",
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid open timeout",
+			config: &Config{
+				Server:     ServerConfig{Port: 8080, Host: "localhost", ReadTimeout: 30, WriteTimeout: 30, MaxUploadBytes: 50 << 20, MultipartMemoryBytes: 32 << 20},
+				ImageStore: ImageStoreConfig{TileSize: 256, DatabasePath: "./test.db", OpenTimeoutSecs: -1},
+				LogLevel:   "info",
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative route timeout",
+			config: &Config{
+				Server:     ServerConfig{Port: 8080, Host: "localhost", ReadTimeout: 30, WriteTimeout: 30, MaxUploadBytes: 50 << 20, MultipartMemoryBytes: 32 << 20, RouteTimeouts: RouteTimeoutsConfig{RetrieveSeconds: -1}},
+				ImageStore: ImageStoreConfig{TileSize: 256, DatabasePath: "./test.db"},
+				LogLevel:   "info",
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid write queue",
+			config: &Config{
+				Server:     ServerConfig{Port: 8080, Host: "localhost", ReadTimeout: 30, WriteTimeout: 30, MaxUploadBytes: 50 << 20, MultipartMemoryBytes: 32 << 20},
+				ImageStore: ImageStoreConfig{TileSize: 256, DatabasePath: "./test.db", MaxWriters: -1},
+				LogLevel:   "info",
+			},
+			wantErr: true,
+		},
+		{
+			name: "cold tier with two backends",
+			config: &Config{
+				Server:     ServerConfig{Port: 8080, Host: "localhost", ReadTimeout: 30, WriteTimeout: 30, MaxUploadBytes: 50 << 20, MultipartMemoryBytes: 32 << 20},
+				ImageStore: ImageStoreConfig{TileSize: 256, DatabasePath: "./test.db", ColdTier: ColdTierConfig{Dir: "./cold", S3Bucket: "cold", AfterDays: 30}},
+				LogLevel:   "info",
+			},
+			wantErr: true,
+		},
+		{
+			name: "cold tier without age",
+			config: &Config{
+				Server:     ServerConfig{Port: 8080, Host: "localhost", ReadTimeout: 30, WriteTimeout: 30, MaxUploadBytes: 50 << 20, MultipartMemoryBytes: 32 << 20},
+				ImageStore: ImageStoreConfig{TileSize: 256, DatabasePath: "./test.db", ColdTier: ColdTierConfig{Dir: "./cold"}},
+				LogLevel:   "info",
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid retention policy",
+			config: &Config{
+				Server:     ServerConfig{Port: 8080, Host: "localhost", ReadTimeout: 30, WriteTimeout: 30, MaxUploadBytes: 50 << 20, MultipartMemoryBytes: 32 << 20},
+				ImageStore: ImageStoreConfig{TileSize: 256, DatabasePath: "./test.db", Retention: RetentionConfig{Policies: []RetentionPolicyConfig{{Prefix: "ci/", MaxCount: -1}}}},
+				LogLevel:   "info",
+			},
+			wantErr: true,
+		},
+		{
+			name: "watch archive without directory",
+			config: &Config{
+				Server:     ServerConfig{Port: 8080, Host: "localhost", ReadTimeout: 30, WriteTimeout: 30, MaxUploadBytes: 50 << 20, MultipartMemoryBytes: 32 << 20},
+				ImageStore: ImageStoreConfig{TileSize: 256, DatabasePath: "./test.db"},
+				Watch:      WatchConfig{Dir: "./inbox", AfterStore: "archive"},
+				LogLevel:   "info",
+			},
+			wantErr: true,
+		},
+		{
+			name: "PDF rasterizer without DPI",
+			config: &Config{
+				Server:     ServerConfig{Port: 8080, Host: "localhost", ReadTimeout: 30, WriteTimeout: 30, MaxUploadBytes: 50 << 20, MultipartMemoryBytes: 32 << 20},
+				ImageStore: ImageStoreConfig{TileSize: 256, DatabasePath: "./test.db"},
+				PDF:        PDFConfig{Rasterizer: "pdftoppm", MaxPages: 10},
+				LogLevel:   "info",
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid background color",
+			config: &Config{
+				Server:     ServerConfig{Port: 8080, Host: "localhost", ReadTimeout: 30, WriteTimeout: 30, MaxUploadBytes: 50 << 20, MultipartMemoryBytes: 32 << 20},
+				ImageStore: ImageStoreConfig{TileSize: 256, DatabasePath: "./test.db", BackgroundColor: "white"},
+				LogLevel:   "info",
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid conflict policy",
+			config: &Config{
+				Server:     ServerConfig{Port: 8080, Host: "localhost", ReadTimeout: 30, WriteTimeout: 30, MaxUploadBytes: 50 << 20, MultipartMemoryBytes: 32 << 20},
+				ImageStore: ImageStoreConfig{TileSize: 256, DatabasePath: "./test.db", OnConflict: "merge"},
+				LogLevel:   "info",
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative resource limit",
+			config: &Config{
+				Server:     ServerConfig{Port: 8080, Host: "localhost", ReadTimeout: 30, WriteTimeout: 30, MaxUploadBytes: 50 << 20, MultipartMemoryBytes: 32 << 20},
+				ImageStore: ImageStoreConfig{TileSize: 256, DatabasePath: "./test.db", Resources: ResourcesConfig{MaxDecompressors: -1}},
+				LogLevel:   "info",
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative image cache size",
+			config: &Config{
+				Server:     ServerConfig{Port: 8080, Host: "localhost", ReadTimeout: 30, WriteTimeout: 30, MaxUploadBytes: 50 << 20, MultipartMemoryBytes: 32 << 20},
+				ImageStore: ImageStoreConfig{TileSize: 256, DatabasePath: "./test.db", Resources: ResourcesConfig{ImageCacheBytes: -1}},
+				LogLevel:   "info",
+			},
+			wantErr: true,
+		},
+		{
+			name: "preload without image cache",
+			config: &Config{
+				Server:     ServerConfig{Port: 8080, Host: "localhost", ReadTimeout: 30, WriteTimeout: 30, MaxUploadBytes: 50 << 20, MultipartMemoryBytes: 32 << 20},
+				ImageStore: ImageStoreConfig{TileSize: 256, DatabasePath: "./test.db", Resources: ResourcesConfig{Preload: []string{"dashboards/"}}},
+				LogLevel:   "info",
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative slow operation threshold",
+			config: &Config{
+				Server:     ServerConfig{Port: 8080, Host: "localhost", ReadTimeout: 30, WriteTimeout: 30, MaxUploadBytes: 50 << 20, MultipartMemoryBytes: 32 << 20},
+				ImageStore: ImageStoreConfig{TileSize: 256, DatabasePath: "./test.db", SlowOperationMillis: -1},
+				LogLevel:   "info",
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid auth role",
+			config: &Config{
+				Server:     ServerConfig{Port: 8080, Host: "localhost", ReadTimeout: 30, WriteTimeout: 30, MaxUploadBytes: 50 << 20, MultipartMemoryBytes: 32 << 20},
+				ImageStore: ImageStoreConfig{TileSize: 256, DatabasePath: "./test.db"},
+				Auth:       AuthConfig{Tokens: []TokenConfig{{Name: "ci", Token: "secret", Roles: map[string]string{"ci": "owner"}}}},
+				LogLevel:   "info",
+			},
+			wantErr: true,
+		},
+		{
+			name: "duplicate auth token",
+			config: &Config{
+				Server:     ServerConfig{Port: 8080, Host: "localhost", ReadTimeout: 30, WriteTimeout: 30, MaxUploadBytes: 50 << 20, MultipartMemoryBytes: 32 << 20},
+				ImageStore: ImageStoreConfig{TileSize: 256, DatabasePath: "./test.db"},
+				Auth:       AuthConfig{Tokens: []TokenConfig{{Name: "ci", Token: "secret"}, {Name: "dashboards", Token: "secret"}}},
+				LogLevel:   "info",
+			},
+			wantErr: true,
+		},
+		{
+			name: "short signing key",
+			config: &Config{
+				Server:     ServerConfig{Port: 8080, Host: "localhost", ReadTimeout: 30, WriteTimeout: 30, MaxUploadBytes: 50 << 20, MultipartMemoryBytes: 32 << 20},
+				ImageStore: ImageStoreConfig{TileSize: 256, DatabasePath: "./test.db"},
+				Auth:       AuthConfig{SigningKey: "hunter2"},
+				LogLevel:   "info",
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid transform",
+			config: &Config{
+				Server:     ServerConfig{Port: 8080, Host: "localhost", ReadTimeout: 30, WriteTimeout: 30, MaxUploadBytes: 50 << 20, MultipartMemoryBytes: 32 << 20},
+				ImageStore: ImageStoreConfig{TileSize: 256, DatabasePath: "./test.db", Transforms: map[string][]TransformConfig{"phones": {{Type: "crop", Height: 40, Top: 40}}}},
+				LogLevel:   "info",
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid strip height",
+			config: &Config{
+				Server:     ServerConfig{Port: 8080, Host: "localhost", ReadTimeout: 30, WriteTimeout: 30, MaxUploadBytes: 50 << 20, MultipartMemoryBytes: 32 << 20},
+				ImageStore: ImageStoreConfig{TileSize: 256, DatabasePath: "./test.db", Strips: map[string]int{"pages": 0}},
+				LogLevel:   "info",
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid tile pool mode",
+			config: &Config{
+				Server:     ServerConfig{Port: 8080, Host: "localhost", ReadTimeout: 30, WriteTimeout: 30, MaxUploadBytes: 50 << 20, MultipartMemoryBytes: 32 << 20},
+				ImageStore: ImageStoreConfig{TileSize: 256, DatabasePath: "./test.db", TilePools: "tenant"},
+				LogLevel:   "info",
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative stats history",
+			config: &Config{
+				Server:     ServerConfig{Port: 8080, Host: "localhost", ReadTimeout: 30, WriteTimeout: 30, MaxUploadBytes: 50 << 20, MultipartMemoryBytes: 32 << 20},
+				ImageStore: ImageStoreConfig{TileSize: 256, DatabasePath: "./test.db", StatsHistoryDays: -1},
+				LogLevel:   "info",
+			},
+			wantErr: true,
+		},
+		{
+			name: "free disk percent out of range",
+			config: &Config{
+				Server:     ServerConfig{Port: 8080, Host: "localhost", ReadTimeout: 30, WriteTimeout: 30, MaxUploadBytes: 50 << 20, MultipartMemoryBytes: 32 << 20},
+				ImageStore: ImageStoreConfig{TileSize: 256, DatabasePath: "./test.db", MinFreeDiskPercent: 100},
+				LogLevel:   "info",
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative max database bytes",
+			config: &Config{
+				Server:     ServerConfig{Port: 8080, Host: "localhost", ReadTimeout: 30, WriteTimeout: 30, MaxUploadBytes: 50 << 20, MultipartMemoryBytes: 32 << 20},
+				ImageStore: ImageStoreConfig{TileSize: 256, DatabasePath: "./test.db", MaxDatabaseBytes: -1},
+				LogLevel:   "info",
+			},
+			wantErr: true,
+		},
+		{
+			name: "backup schedule without a target",
+			config: &Config{
+				Server:     ServerConfig{Port: 8080, Host: "localhost", ReadTimeout: 30, WriteTimeout: 30, MaxUploadBytes: 50 << 20, MultipartMemoryBytes: 32 << 20},
+				ImageStore: ImageStoreConfig{TileSize: 256, DatabasePath: "./test.db", Backup: BackupConfig{Schedule: "0 3 * * *"}},
+				LogLevel:   "info",
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid backup schedule",
+			config: &Config{
+				Server:     ServerConfig{Port: 8080, Host: "localhost", ReadTimeout: 30, WriteTimeout: 30, MaxUploadBytes: 50 << 20, MultipartMemoryBytes: 32 << 20},
+				ImageStore: ImageStoreConfig{TileSize: 256, DatabasePath: "./test.db", Backup: BackupConfig{Dir: "./backups", Schedule: "0 25 * * *"}},
+				LogLevel:   "info",
+			},
+			wantErr: true,
+		},
+		{
+			name: "valid backup schedule",
+			config: &Config{
+				Server:     ServerConfig{Port: 8080, Host: "localhost", ReadTimeout: 30, WriteTimeout: 30, MaxUploadBytes: 50 << 20, MultipartMemoryBytes: 32 << 20},
+				ImageStore: ImageStoreConfig{TileSize: 256, DatabasePath: "./test.db", Backup: BackupConfig{S3Bucket: "backups", Schedule: "@daily", Keep: 7}},
+				LogLevel:   "info",
+			},
+			wantErr: false,
+		},
+		{
+			name: "negative maintenance rate",
+			config: &Config{
+				Server:     ServerConfig{Port: 8080, Host: "localhost", ReadTimeout: 30, WriteTimeout: 30, MaxUploadBytes: 50 << 20, MultipartMemoryBytes: 32 << 20},
+				ImageStore: ImageStoreConfig{TileSize: 256, DatabasePath: "./test.db", MaintenanceBytesPerSecond: -1},
+				LogLevel:   "info",
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown sstable compression",
+			config: &Config{
+				Server:     ServerConfig{Port: 8080, Host: "localhost", ReadTimeout: 30, WriteTimeout: 30, MaxUploadBytes: 50 << 20, MultipartMemoryBytes: 32 << 20},
+				ImageStore: ImageStoreConfig{TileSize: 256, DatabasePath: "./test.db", Pebble: PebbleConfig{SSTableCompression: "lz4"}},
+				LogLevel:   "info",
+			},
+			wantErr: true,
+		},
+		{
+			name: "blob garbage ratio above one",
+			config: &Config{
+				Server:     ServerConfig{Port: 8080, Host: "localhost", ReadTimeout: 30, WriteTimeout: 30, MaxUploadBytes: 50 << 20, MultipartMemoryBytes: 32 << 20},
+				ImageStore: ImageStoreConfig{TileSize: 256, DatabasePath: "./test.db", Blobs: BlobsConfig{ThresholdBytes: 65536, GarbageRatio: 1.5}},
+				LogLevel:   "info",
+			},
+			wantErr: true,
+		},
+		{
+			name: "conflicting ingest worker limits",
+			config: &Config{
+				Server:     ServerConfig{Port: 8080, Host: "localhost", ReadTimeout: 30, WriteTimeout: 30, MaxUploadBytes: 50 << 20, MultipartMemoryBytes: 32 << 20},
+				ImageStore: ImageStoreConfig{TileSize: 256, DatabasePath: "./test.db", MaxWriters: 2, Resources: ResourcesConfig{MaxIngestWorkers: 4}},
+				LogLevel:   "info",
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative change log size",
+			config: &Config{
+				Server:     ServerConfig{Port: 8080, Host: "localhost", ReadTimeout: 30, WriteTimeout: 30, MaxUploadBytes: 50 << 20, MultipartMemoryBytes: 32 << 20},
+				ImageStore: ImageStoreConfig{TileSize: 256, DatabasePath: "./test.db", ChangeLogSize: -1},
+				LogLevel:   "info",
+			},
+			wantErr: true,
+		},
+		{
+			name: "tcp disabled without a unix socket",
+			config: &Config{
+				Server:     ServerConfig{Port: 8080, Host: "localhost", ReadTimeout: 30, WriteTimeout: 30, MaxUploadBytes: 50 << 20, MultipartMemoryBytes: 32 << 20, DisableTCP: true},
+				ImageStore: ImageStoreConfig{TileSize: 256, DatabasePath: "./test.db"},
+				LogLevel:   "info",
+			},
+			wantErr: true,
+		},
+		{
+			name: "unix socket only needs no port",
+			config: &Config{
+				Server:     ServerConfig{Port: 0, ReadTimeout: 30, WriteTimeout: 30, MaxUploadBytes: 50 << 20, MultipartMemoryBytes: 32 << 20, UnixSocket: "/run/imagestore.sock", DisableTCP: true},
+				ImageStore: ImageStoreConfig{TileSize: 256, DatabasePath: "./test.db"},
+				LogLevel:   "info",
+			},
+			wantErr: false,
+		},
+		{
+			name: "negative trash purge interval",
+			config: &Config{
+				Server:     ServerConfig{Port: 8080, Host: "localhost", ReadTimeout: 30, WriteTimeout: 30, MaxUploadBytes: 50 << 20, MultipartMemoryBytes: 32 << 20},
+				ImageStore: ImageStoreConfig{TileSize: 256, DatabasePath: "./test.db", TrashPurgeSecs: -1},
+				LogLevel:   "info",
+			},
+			wantErr: true,
+		},
 		{
 			name: "invalid log level",
 			config: &Config{
@@ -208,12 +609,13 @@ func TestSaveConfigCreatesDirectory(t *testing.T) {
 func TestLoadConfigFromEnv(t *testing.T) {
 	originalValues := make(map[string]string)
 	envVars := map[string]string{
-		"SERVER_PORT":         "9999",
-		"SERVER_HOST":         "example.com",
-		"SERVER_READ_TIMEOUT": "45",
-		"TILE_SIZE":           "128",
-		"DATABASE_PATH":       "/custom/path.db",
-		"LOG_LEVEL":           "warn",
+		"SERVER_PORT":             "9999",
+		"SERVER_HOST":             "example.com",
+		"SERVER_READ_TIMEOUT":     "45",
+		"SERVER_MAX_UPLOAD_BYTES": "1048576",
+		"TILE_SIZE":               "128",
+		"DATABASE_PATH":           "/custom/path.db",
+		"LOG_LEVEL":               "warn",
 	}
 
 	for key, value := range envVars {
@@ -245,6 +647,10 @@ func TestLoadConfigFromEnv(t *testing.T) {
 		t.Errorf("expected read timeout 45, got %d", config.Server.ReadTimeout)
 	}
 
+	if config.Server.MaxUploadBytes != 1048576 {
+		t.Errorf("expected max upload bytes 1048576, got %d", config.Server.MaxUploadBytes)
+	}
+
 	if config.ImageStore.TileSize != 128 {
 		t.Errorf("expected tile size 128, got %d", config.ImageStore.TileSize)
 	}
@@ -254,6 +660,18 @@ func TestLoadConfigFromEnv(t *testing.T) {
 	}
 }
 
+func TestLoadConfigFromEnvAutoTileSize(t *testing.T) {
+	t.Setenv("TILE_SIZE", "auto")
+
+	config := LoadConfigFromEnv()
+	if !config.ImageStore.AutoTileSize || config.ImageStore.TileSize != 256 {
+		t.Errorf("expected auto tile size over the default 256, got %v and %d", config.ImageStore.AutoTileSize, config.ImageStore.TileSize)
+	}
+	if !config.ImageStore.StoreConfig().AutoTileSize {
+		t.Error("expected auto tile size passed to the store")
+	}
+}
+
 func TestJSONMarshaling(t *testing.T) {
 	config := DefaultConfig()
 
@@ -276,3 +694,62 @@ func TestJSONMarshaling(t *testing.T) {
 		t.Errorf("log level mismatch after JSON round-trip")
 	}
 }
+
+func TestStoreConfig(t *testing.T) {
+	config := DefaultConfig()
+	config.ImageStore.TrashRetentionHours = 2
+	config.ImageStore.Quotas = map[string]QuotaConfig{"team": {MaxStoredBytes: 1024}}
+	config.ImageStore.Transforms = map[string][]TransformConfig{"phones": {{Type: "crop", Top: 40}, {Type: "srgb"}}}
+	config.ImageStore.Strips = map[string]int{"pages": 32}
+	config.ImageStore.TilePools = "namespace"
+	config.ImageStore.MaxDatabaseBytes = 1 << 30
+	config.ImageStore.MinFreeDiskPercent = 5
+	config.ImageStore.Backup = BackupConfig{Dir: "./backups", Schedule: "@daily", Keep: 7}
+	config.ImageStore.Pebble = PebbleConfig{CacheBytes: 256 << 20, SSTableCompression: "zstd"}
+	config.ImageStore.Blobs.ThresholdBytes = 65536
+
+	storeConfig := config.ImageStore.StoreConfig()
+
+	if storeConfig.TileSize != 256 || storeConfig.DatabasePath != "./imagestore.db" {
+		t.Errorf("unexpected store config: %+v", storeConfig)
+	}
+	if storeConfig.TrashRetention != 2*time.Hour {
+		t.Errorf("expected trash retention 2h, got %v", storeConfig.TrashRetention)
+	}
+	if storeConfig.MaintenanceYield != time.Second {
+		t.Errorf("expected maintenance yield 1s, got %v", storeConfig.MaintenanceYield)
+	}
+	if storeConfig.StatsSnapshotInterval != time.Hour {
+		t.Errorf("expected stats snapshot interval 1h, got %v", storeConfig.StatsSnapshotInterval)
+	}
+	if storeConfig.TrashPurgeInterval != time.Hour {
+		t.Errorf("expected trash purge interval 1h, got %v", storeConfig.TrashPurgeInterval)
+	}
+	if storeConfig.ExpirySweepInterval != time.Minute {
+		t.Errorf("expected expiry sweep interval 1m, got %v", storeConfig.ExpirySweepInterval)
+	}
+	if storeConfig.Quotas["team"].MaxStoredBytes != 1024 {
+		t.Errorf("expected team quota to carry over, got %+v", storeConfig.Quotas)
+	}
+	if transforms := storeConfig.Transforms["phones"]; len(transforms) != 2 || transforms[0].Top != 40 || transforms[1].Type != "srgb" {
+		t.Errorf("expected phones transforms to carry over, got %+v", storeConfig.Transforms)
+	}
+	if storeConfig.Strips["pages"] != 32 {
+		t.Errorf("expected pages strips to carry over, got %+v", storeConfig.Strips)
+	}
+	if storeConfig.TilePools != "namespace" {
+		t.Errorf("expected namespace tile pools, got %q", storeConfig.TilePools)
+	}
+	if storeConfig.MaxDatabaseBytes != 1<<30 || storeConfig.MinFreeDiskPercent != 5 {
+		t.Errorf("expected disk limits to carry over, got %d bytes, %g%%", storeConfig.MaxDatabaseBytes, storeConfig.MinFreeDiskPercent)
+	}
+	if storeConfig.Pebble.CacheBytes != 256<<20 || storeConfig.Pebble.SSTableCompression != "zstd" {
+		t.Errorf("expected pebble tuning to carry over, got %+v", storeConfig.Pebble)
+	}
+	if storeConfig.BlobThreshold != 65536 || storeConfig.BlobGCInterval != time.Hour {
+		t.Errorf("expected blob separation with hourly collection, got threshold %d every %v", storeConfig.BlobThreshold, storeConfig.BlobGCInterval)
+	}
+	if storeConfig.BackupSchedule != "@daily" || storeConfig.BackupKeep != 7 {
+		t.Errorf("expected the backup schedule to carry over, got %q keeping %d", storeConfig.BackupSchedule, storeConfig.BackupKeep)
+	}
+}
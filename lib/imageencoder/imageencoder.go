@@ -0,0 +1,154 @@
+// Package imageencoder embeds a deduplicating image store in a Go program:
+//
+//	store, err := imageencoder.Open("./images.db")
+//	err = store.Put("cat", img)
+//	img, err = store.Get("cat")
+//
+// It wraps lib/imagestore, which remains available through Store.Backend
+// for everything the facade doesn't cover.
+package imageencoder
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"strings"
+
+	"github.com/gordyf/imageencoder/lib/imagestore"
+)
+
+// ErrNotFound is returned for an image ID the store doesn't hold
+var ErrNotFound = errors.New("image not found")
+
+// Option configures a store opened with Open
+type Option func(*imagestore.Config)
+
+// WithTileSize sets the edge length of the tiles images are split into.
+// It must match the size the database was created with. Default: 256.
+func WithTileSize(size int) Option {
+	return func(c *imagestore.Config) { c.TileSize = size }
+}
+
+// WithCompression sets the zstd level new tiles are written at: fastest,
+// default, better or best
+func WithCompression(level string) Option {
+	return func(c *imagestore.Config) { c.CompressionLevel = level }
+}
+
+// WithReadOnly opens the database without write access
+func WithReadOnly() Option {
+	return func(c *imagestore.Config) { c.ReadOnly = true }
+}
+
+// WithConfig applies arbitrary changes to the underlying store
+// configuration, for settings without an option of their own
+func WithConfig(fn func(*imagestore.Config)) Option {
+	return fn
+}
+
+// Stats summarizes what a store holds
+type Stats struct {
+	Images           int
+	UniqueTiles      int
+	StoredBytes      int64   // Compressed tile data
+	OriginalBytes    int64   // Raw RGB size of every stored image
+	DiskBytes        int64   // Database size on disk
+	CompressionRatio float64 // OriginalBytes over StoredBytes
+}
+
+// Store is an embedded image store. It is safe for concurrent use.
+type Store struct {
+	backend *imagestore.PebbleImageStore
+}
+
+// Open opens the store at path, creating it if it doesn't exist
+func Open(path string, opts ...Option) (*Store, error) {
+	config := imagestore.DefaultConfig()
+	config.DatabasePath = path
+	for _, opt := range opts {
+		opt(config)
+	}
+
+	backend, err := imagestore.NewPebbleImageStore(config)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{backend: backend}, nil
+}
+
+// Put stores an image under id, replacing any image already there
+func (s *Store) Put(id string, img image.Image) error {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return fmt.Errorf("failed to encode image: %w", err)
+	}
+	return s.backend.StoreImage(id, buf.Bytes())
+}
+
+// PutEncoded stores an image from an encoded PNG or JPEG file
+func (s *Store) PutEncoded(id string, data []byte) error {
+	return s.backend.StoreImage(id, data)
+}
+
+// Get returns the image stored under id
+func (s *Store) Get(id string) (image.Image, error) {
+	data, err := s.GetEncoded(id)
+	if err != nil {
+		return nil, err
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image %s: %w", id, err)
+	}
+	return img, nil
+}
+
+// GetEncoded returns the image stored under id as a PNG
+func (s *Store) GetEncoded(id string) ([]byte, error) {
+	data, err := s.backend.RetrieveImage(id)
+	return data, notFound(id, err)
+}
+
+// Delete removes the image stored under id. It stays in the trash for the
+// retention window and can be restored through Backend.
+func (s *Store) Delete(id string) error {
+	return notFound(id, s.backend.DeleteImage(id))
+}
+
+// List returns the IDs of every stored image
+func (s *Store) List() ([]string, error) {
+	return s.backend.ListImages()
+}
+
+// Stats reports what the store holds
+func (s *Store) Stats() Stats {
+	stats := s.backend.GetStorageStats()
+	return Stats{
+		Images:           stats.TotalImages,
+		UniqueTiles:      stats.UniqueTiles,
+		StoredBytes:      stats.StorageBytes,
+		OriginalBytes:    stats.OriginalBytes,
+		DiskBytes:        stats.DiskBytes,
+		CompressionRatio: stats.CompressionRatio,
+	}
+}
+
+// Backend returns the underlying store
+func (s *Store) Backend() *imagestore.PebbleImageStore {
+	return s.backend
+}
+
+// Close flushes and closes the store
+func (s *Store) Close() error {
+	return s.backend.Close()
+}
+
+// notFound wraps the store's not-found errors in ErrNotFound
+func notFound(id string, err error) error {
+	if err != nil && strings.Contains(err.Error(), "image not found") {
+		return fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	return err
+}
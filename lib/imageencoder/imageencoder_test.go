@@ -0,0 +1,107 @@
+package imageencoder
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"path/filepath"
+	"testing"
+
+	"github.com/gordyf/imageencoder/lib/imagestore"
+)
+
+func testImage(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 8), uint8(y * 8), 90, 255})
+		}
+	}
+	return img
+}
+
+func TestPutGetDelete(t *testing.T) {
+	store, err := Open(filepath.Join(t.TempDir(), "test.db"), WithTileSize(8))
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	defer store.Close()
+
+	img := testImage(20, 12)
+	if err := store.Put("a", img); err != nil {
+		t.Fatalf("failed to put image: %v", err)
+	}
+	if err := store.Put("b", img); err != nil {
+		t.Fatalf("failed to put image: %v", err)
+	}
+
+	got, err := store.Get("a")
+	if err != nil {
+		t.Fatalf("failed to get image: %v", err)
+	}
+	if got.Bounds() != img.Bounds() {
+		t.Fatalf("expected bounds %v, got %v", img.Bounds(), got.Bounds())
+	}
+	for y := 0; y < 12; y++ {
+		for x := 0; x < 20; x++ {
+			r1, g1, b1, _ := img.At(x, y).RGBA()
+			r2, g2, b2, _ := got.At(x, y).RGBA()
+			if r1 != r2 || g1 != g2 || b1 != b2 {
+				t.Fatalf("pixel (%d, %d) differs", x, y)
+			}
+		}
+	}
+
+	stats := store.Stats()
+	if stats.Images != 2 {
+		t.Errorf("expected 2 images, got %d", stats.Images)
+	}
+	if stats.UniqueTiles != 6 {
+		t.Errorf("expected the copies to share 6 tiles, got %d", stats.UniqueTiles)
+	}
+
+	if err := store.Delete("a"); err != nil {
+		t.Fatalf("failed to delete image: %v", err)
+	}
+	if _, err := store.Get("a"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound after delete, got %v", err)
+	}
+	if err := store.Delete("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound deleting a missing image, got %v", err)
+	}
+
+	ids, err := store.List()
+	if err != nil {
+		t.Fatalf("failed to list images: %v", err)
+	}
+	if len(ids) != 1 || ids[0] != "b" {
+		t.Errorf("expected [b], got %v", ids)
+	}
+}
+
+func TestOptions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	store, err := Open(path, WithTileSize(8), WithCompression(imagestore.CompressionBest))
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	if err := store.Put("a", testImage(8, 8)); err != nil {
+		t.Fatalf("failed to put image: %v", err)
+	}
+	if size := store.Backend().TileSize(); size != 8 {
+		t.Errorf("expected tile size 8, got %d", size)
+	}
+	store.Close()
+
+	store, err = Open(path, WithTileSize(8), WithReadOnly())
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	if err := store.Put("b", testImage(8, 8)); !errors.Is(err, imagestore.ErrReadOnly) {
+		t.Errorf("expected ErrReadOnly, got %v", err)
+	}
+	if _, err := store.Get("a"); err != nil {
+		t.Errorf("expected reads to work read-only, got %v", err)
+	}
+}
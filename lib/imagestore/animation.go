@@ -0,0 +1,459 @@
+package imagestore
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"strings"
+)
+
+// Animation is a stored frame sequence. Each frame is an ordinary image with
+// the ID "<id>/frames/<n>", composited to the full canvas so frames share
+// the tiles they have in common, and the animation's own ID holds the first
+// frame as a still.
+type Animation struct {
+	ID        string           `json:"id"`
+	Width     int              `json:"width"`
+	Height    int              `json:"height"`
+	LoopCount int              `json:"loop_count"` // 0 loops forever
+	Frames    []AnimationFrame `json:"frames"`
+}
+
+// AnimationFrame is one frame of an animation
+type AnimationFrame struct {
+	ID    string `json:"id"`
+	Delay int    `json:"delay_ms"` // How long the frame shows
+}
+
+// AnimationFrameID returns the image ID of an animation frame
+func AnimationFrameID(id string, frame int) string {
+	return fmt.Sprintf("%s/frames/%d", id, frame)
+}
+
+// animationKey returns the key holding an animation's frame list
+func animationKey(id string) []byte {
+	return makeKey(animationsBucket, id)
+}
+
+// StoreAnimation stores an animated GIF or PNG as a sequence of frame
+// images. Frames are decoded and stored one at a time, so memory use does
+// not grow with the frame count; tiles unchanged between frames are
+// deduplicated like any other tiles.
+func (s *PebbleImageStore) StoreAnimation(id string, data []byte, opts StoreOptions) (*Animation, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+
+	animation := &Animation{ID: id, Frames: []AnimationFrame{}}
+
+	// The animation's own ID follows the conflict policy and If-Match, and
+	// is stored first so a rejected upload writes nothing. Its frames belong
+	// to it and are replaced along with it.
+	frameOpts := opts
+	frameOpts.IfMatch = ""
+	store := func(frame image.Image, delay int) error {
+		if len(animation.Frames) == 0 {
+			if err := s.storeDecoded(id, frame, opts, s.commitUpload); err != nil {
+				return err
+			}
+		}
+		frameID := AnimationFrameID(id, len(animation.Frames))
+		if err := s.storeDecoded(frameID, frame, frameOpts, s.commitPlan); err != nil {
+			return fmt.Errorf("failed to store frame %d: %w", len(animation.Frames), err)
+		}
+		animation.Frames = append(animation.Frames, AnimationFrame{ID: frameID, Delay: delay})
+		return nil
+	}
+
+	var err error
+	switch {
+	case bytes.HasPrefix(data, []byte("GIF8")):
+		err = decodeGIFFrames(data, animation, store)
+	case bytes.HasPrefix(data, pngSignature):
+		err = decodeAPNGFrames(data, animation, store)
+	default:
+		err = fmt.Errorf("invalid animation: not a GIF or animated PNG")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := json.Marshal(animation)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal animation: %w", err)
+	}
+	if err := s.db.Set(animationKey(id), value, s.writeOpts); err != nil {
+		return nil, fmt.Errorf("failed to store animation: %w", err)
+	}
+	return animation, nil
+}
+
+// storeDecoded stores an already decoded image through commit, then
+// collects the tiles of the version it replaced
+func (s *PebbleImageStore) storeDecoded(id string, img image.Image, opts StoreOptions, commit func(*storePlan) error) error {
+	plan, err := s.commitDecoded(id, img, opts, commit)
+	if err != nil {
+		return err
+	}
+	return s.collectReplaced(plan)
+}
+
+// commitDecoded plans and commits an already decoded image, returning the
+// plan
+func (s *PebbleImageStore) commitDecoded(id string, img image.Image, opts StoreOptions, commit func(*storePlan) error) (*storePlan, error) {
+	if err := s.acquireWrite(); err != nil {
+		return nil, err
+	}
+	defer s.releaseWrite()
+
+	// Hold off garbage collection so tiles we dedupe against stay present
+	s.gcMu.RLock()
+	defer s.gcMu.RUnlock()
+
+	plan, err := s.planImage(context.Background(), id, img, opts)
+	if err != nil {
+		return nil, err
+	}
+	return plan, commit(plan)
+}
+
+// GetAnimation returns a stored animation's frame list
+func (s *PebbleImageStore) GetAnimation(id string) (*Animation, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+
+	value, closer, err := s.db.Get(animationKey(id))
+	if err != nil {
+		return nil, fmt.Errorf("animation not found: %s", id)
+	}
+	defer closer.Close()
+
+	var animation Animation
+	if err := json.Unmarshal(value, &animation); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal animation: %w", err)
+	}
+	return &animation, nil
+}
+
+// RetrieveAnimation re-encodes a stored animation as an animated PNG,
+// reconstructing one frame at a time
+func (s *PebbleImageStore) RetrieveAnimation(id string) ([]byte, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+
+	animation, err := s.GetAnimation(id)
+	if err != nil {
+		return nil, err
+	}
+
+	encoder := newAPNGEncoder(animation)
+	for i, frame := range animation.Frames {
+		img, _, err := s.reconstructImage(context.Background(), frame.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to reconstruct frame %d: %w", i, err)
+		}
+		if err := encoder.addFrame(img, frame.Delay); err != nil {
+			return nil, err
+		}
+	}
+	return encoder.finish(), nil
+}
+
+// DeleteAnimation deletes an animation's frames, its still and its frame
+// list. Frames go to the trash like any deleted image.
+func (s *PebbleImageStore) DeleteAnimation(id string) error {
+	if err := s.enter(); err != nil {
+		return err
+	}
+	defer s.leave()
+
+	animation, err := s.GetAnimation(id)
+	if err != nil {
+		return err
+	}
+
+	for _, frame := range append(animation.Frames, AnimationFrame{ID: id}) {
+		if err := s.DeleteImage(frame.ID); err != nil && !strings.Contains(err.Error(), "not found") {
+			return fmt.Errorf("failed to delete %s: %w", frame.ID, err)
+		}
+	}
+	return s.db.Delete(animationKey(id), s.writeOpts)
+}
+
+// decodeGIFFrames composites each GIF frame onto the canvas and passes the
+// result to fn
+func decodeGIFFrames(data []byte, animation *Animation, fn func(image.Image, int) error) error {
+	decoded, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("invalid animation: %w", err)
+	}
+
+	animation.Width, animation.Height = decoded.Config.Width, decoded.Config.Height
+	animation.LoopCount = max(decoded.LoopCount, 0)
+	canvas := image.NewRGBA(image.Rect(0, 0, animation.Width, animation.Height))
+
+	for i, frame := range decoded.Image {
+		var previous *image.RGBA
+		if decoded.Disposal[i] == gif.DisposalPrevious {
+			previous = cloneRGBA(canvas)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		if err := fn(canvas, decoded.Delay[i]*10); err != nil {
+			return err
+		}
+
+		switch decoded.Disposal[i] {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+	return nil
+}
+
+// APNG frame disposal and blend operations
+const (
+	apngDisposeBackground = 1
+	apngDisposePrevious   = 2
+	apngBlendOver         = 1
+)
+
+// apngFrame is a parsed fcTL chunk and the image data that follows it
+type apngFrame struct {
+	bounds  image.Rectangle
+	delay   int
+	dispose byte
+	blend   byte
+	data    []byte // Concatenated IDAT or fdAT payloads
+}
+
+// decodeAPNGFrames composites each animated PNG frame onto the canvas and
+// passes the result to fn. Frames are decoded by rebuilding each as a
+// standalone PNG.
+func decodeAPNGFrames(data []byte, animation *Animation, fn func(image.Image, int) error) error {
+	var ihdr []byte
+	var shared [][]byte // Chunks such as PLTE and tRNS that every frame needs
+	var frames []*apngFrame
+	var current *apngFrame
+	animated := false
+
+	for pos := len(pngSignature); pos+12 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		if length < 0 || pos+12+length > len(data) {
+			return fmt.Errorf("invalid animation: truncated PNG chunk")
+		}
+		kind := string(data[pos+4 : pos+8])
+		payload := data[pos+8 : pos+8+length]
+		chunk := data[pos : pos+12+length]
+		pos += 12 + length
+
+		switch kind {
+		case "IHDR":
+			if length != 13 {
+				return fmt.Errorf("invalid animation: bad IHDR")
+			}
+			ihdr = payload
+			animation.Width = int(binary.BigEndian.Uint32(payload[0:]))
+			animation.Height = int(binary.BigEndian.Uint32(payload[4:]))
+		case "acTL":
+			if length != 8 {
+				return fmt.Errorf("invalid animation: bad acTL")
+			}
+			animated = true
+			animation.LoopCount = int(binary.BigEndian.Uint32(payload[4:]))
+		case "fcTL":
+			if length != 26 {
+				return fmt.Errorf("invalid animation: bad fcTL")
+			}
+			width := int(binary.BigEndian.Uint32(payload[4:]))
+			height := int(binary.BigEndian.Uint32(payload[8:]))
+			x := int(binary.BigEndian.Uint32(payload[12:]))
+			y := int(binary.BigEndian.Uint32(payload[16:]))
+			delayNum := int(binary.BigEndian.Uint16(payload[20:]))
+			delayDen := int(binary.BigEndian.Uint16(payload[22:]))
+			if delayDen == 0 {
+				delayDen = 100
+			}
+			current = &apngFrame{
+				bounds:  image.Rect(x, y, x+width, y+height),
+				delay:   delayNum * 1000 / delayDen,
+				dispose: payload[24],
+				blend:   payload[25],
+			}
+			frames = append(frames, current)
+		case "IDAT":
+			// The default image is only a frame when an fcTL precedes it
+			if current != nil {
+				current.data = append(current.data, payload...)
+			}
+		case "fdAT":
+			if current == nil || length < 4 {
+				return fmt.Errorf("invalid animation: fdAT without fcTL")
+			}
+			current.data = append(current.data, payload[4:]...)
+		case "IEND":
+			pos = len(data)
+		default:
+			if current == nil && kind != "acTL" {
+				shared = append(shared, chunk)
+			}
+		}
+	}
+	if !animated || len(frames) == 0 || ihdr == nil {
+		return fmt.Errorf("invalid animation: not a GIF or animated PNG")
+	}
+
+	canvasBounds := image.Rect(0, 0, animation.Width, animation.Height)
+	canvas := image.NewRGBA(canvasBounds)
+	for i, frame := range frames {
+		if !frame.bounds.In(canvasBounds) {
+			return fmt.Errorf("invalid animation: frame %d lies outside the canvas", i)
+		}
+		img, err := decodeAPNGFrame(ihdr, shared, frame)
+		if err != nil {
+			return fmt.Errorf("invalid animation: frame %d: %w", i, err)
+		}
+
+		var previous *image.RGBA
+		if frame.dispose == apngDisposePrevious && i > 0 {
+			previous = cloneRGBA(canvas)
+		}
+
+		op := draw.Src
+		if frame.blend == apngBlendOver {
+			op = draw.Over
+		}
+		draw.Draw(canvas, frame.bounds, img, img.Bounds().Min, op)
+		if err := fn(canvas, frame.delay); err != nil {
+			return err
+		}
+
+		switch {
+		case frame.dispose == apngDisposeBackground, frame.dispose == apngDisposePrevious && previous == nil:
+			draw.Draw(canvas, frame.bounds, image.Transparent, image.Point{}, draw.Src)
+		case frame.dispose == apngDisposePrevious:
+			canvas = previous
+		}
+	}
+	return nil
+}
+
+// decodeAPNGFrame decodes one frame's data as a standalone PNG sized to the
+// frame
+func decodeAPNGFrame(ihdr []byte, shared [][]byte, frame *apngFrame) (image.Image, error) {
+	header := append([]byte(nil), ihdr...)
+	binary.BigEndian.PutUint32(header[0:], uint32(frame.bounds.Dx()))
+	binary.BigEndian.PutUint32(header[4:], uint32(frame.bounds.Dy()))
+
+	var buf bytes.Buffer
+	buf.Write(pngSignature)
+	writePNGChunk(&buf, "IHDR", header)
+	for _, chunk := range shared {
+		buf.Write(chunk)
+	}
+	writePNGChunk(&buf, "IDAT", frame.data)
+	writePNGChunk(&buf, "IEND", nil)
+	return png.Decode(&buf)
+}
+
+// writePNGChunk writes a chunk with its length and CRC
+func writePNGChunk(buf *bytes.Buffer, kind string, payload []byte) {
+	binary.Write(buf, binary.BigEndian, uint32(len(payload)))
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(kind))
+	crc.Write(payload)
+	buf.WriteString(kind)
+	buf.Write(payload)
+	binary.Write(buf, binary.BigEndian, crc.Sum32())
+}
+
+// cloneRGBA copies an image so later drawing leaves the copy untouched
+func cloneRGBA(img *image.RGBA) *image.RGBA {
+	clone := image.NewRGBA(img.Bounds())
+	copy(clone.Pix, img.Pix)
+	return clone
+}
+
+// apngEncoder writes full-canvas frames as an 8-bit RGBA animated PNG
+type apngEncoder struct {
+	buf      bytes.Buffer
+	sequence uint32
+	frames   int
+}
+
+// newAPNGEncoder starts an animated PNG with the animation's canvas size,
+// frame count and loop count
+func newAPNGEncoder(animation *Animation) *apngEncoder {
+	e := &apngEncoder{}
+	e.buf.Write(pngSignature)
+
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], uint32(animation.Width))
+	binary.BigEndian.PutUint32(ihdr[4:], uint32(animation.Height))
+	ihdr[8] = 8 // Bit depth
+	ihdr[9] = 6 // RGBA
+	writePNGChunk(&e.buf, "IHDR", ihdr)
+
+	actl := make([]byte, 8)
+	binary.BigEndian.PutUint32(actl[0:], uint32(len(animation.Frames)))
+	binary.BigEndian.PutUint32(actl[4:], uint32(animation.LoopCount))
+	writePNGChunk(&e.buf, "acTL", actl)
+	return e
+}
+
+// addFrame appends a frame shown for delay milliseconds
+func (e *apngEncoder) addFrame(img image.Image, delay int) error {
+	bounds := img.Bounds()
+	fctl := make([]byte, 26)
+	binary.BigEndian.PutUint32(fctl[0:], e.sequence)
+	binary.BigEndian.PutUint32(fctl[4:], uint32(bounds.Dx()))
+	binary.BigEndian.PutUint32(fctl[8:], uint32(bounds.Dy()))
+	binary.BigEndian.PutUint16(fctl[20:], uint16(min(delay, 65535)))
+	binary.BigEndian.PutUint16(fctl[22:], 1000)
+	writePNGChunk(&e.buf, "fcTL", fctl)
+	e.sequence++
+
+	// Unfiltered scanlines, each prefixed with filter type 0
+	rgba := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, bounds.Min, draw.Src)
+	var compressed bytes.Buffer
+	writer := zlib.NewWriter(&compressed)
+	for y := 0; y < bounds.Dy(); y++ {
+		writer.Write([]byte{0})
+		writer.Write(rgba.Pix[y*rgba.Stride : y*rgba.Stride+bounds.Dx()*4])
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to compress frame: %w", err)
+	}
+
+	if e.frames == 0 {
+		writePNGChunk(&e.buf, "IDAT", compressed.Bytes())
+	} else {
+		fdat := binary.BigEndian.AppendUint32(nil, e.sequence)
+		writePNGChunk(&e.buf, "fdAT", append(fdat, compressed.Bytes()...))
+		e.sequence++
+	}
+	e.frames++
+	return nil
+}
+
+// finish ends the animated PNG and returns it
+func (e *apngEncoder) finish() []byte {
+	writePNGChunk(&e.buf, "IEND", nil)
+	return e.buf.Bytes()
+}
@@ -0,0 +1,106 @@
+package imagestore
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"strings"
+	"testing"
+)
+
+func TestStoreAnimation(t *testing.T) {
+	store := newTagsTestStore(t)
+
+	// Three 8x8 frames: a red background, then a 4x4 blue square drawn over
+	// the top-left tile, then a green one over the bottom-right tile that is
+	// disposed of afterwards
+	palette := color.Palette{color.RGBA{255, 0, 0, 255}, color.RGBA{0, 0, 255, 255}, color.RGBA{0, 255, 0, 255}}
+	background := image.NewPaletted(image.Rect(0, 0, 8, 8), palette)
+	blue := image.NewPaletted(image.Rect(0, 0, 4, 4), palette)
+	green := image.NewPaletted(image.Rect(4, 4, 8, 8), palette)
+	for i := range blue.Pix {
+		blue.Pix[i] = 1
+		green.Pix[i] = 2
+	}
+	var encoded bytes.Buffer
+	err := gif.EncodeAll(&encoded, &gif.GIF{
+		Image:    []*image.Paletted{background, blue, green},
+		Delay:    []int{10, 20, 30},
+		Disposal: []byte{gif.DisposalNone, gif.DisposalNone, gif.DisposalBackground},
+	})
+	if err != nil {
+		t.Fatalf("failed to encode GIF: %v", err)
+	}
+
+	animation, err := store.StoreAnimation("loader", encoded.Bytes(), StoreOptions{})
+	if err != nil {
+		t.Fatalf("failed to store animation: %v", err)
+	}
+	if len(animation.Frames) != 3 || animation.Frames[1].Delay != 200 || animation.Width != 8 {
+		t.Fatalf("unexpected animation %+v", animation)
+	}
+
+	// Frames share every tile they have in common: red, blue and green
+	if stats := store.GetStorageStats(); stats.UniqueTiles != 3 {
+		t.Errorf("expected 3 unique tiles, got %d", stats.UniqueTiles)
+	}
+
+	// The third frame composites all three layers
+	frame, err := store.RetrieveImage(AnimationFrameID("loader", 2))
+	if err != nil {
+		t.Fatalf("failed to retrieve frame: %v", err)
+	}
+	img, _ := decodeImageFromBytes(frame)
+	for _, check := range []struct {
+		x, y int
+		want color.RGBA
+	}{{0, 0, color.RGBA{0, 0, 255, 255}}, {7, 7, color.RGBA{0, 255, 0, 255}}, {7, 0, color.RGBA{255, 0, 0, 255}}} {
+		if got := color.RGBAModel.Convert(img.At(check.x, check.y)); got != check.want {
+			t.Errorf("frame 2 pixel (%d, %d) = %v, want %v", check.x, check.y, got, check.want)
+		}
+	}
+
+	// The animated PNG round-trips through the store
+	apng, err := store.RetrieveAnimation("loader")
+	if err != nil {
+		t.Fatalf("failed to retrieve animation: %v", err)
+	}
+	copied, err := store.StoreAnimation("copy", apng, StoreOptions{})
+	if err != nil {
+		t.Fatalf("failed to store animated PNG: %v", err)
+	}
+	if len(copied.Frames) != 3 || copied.Frames[2].Delay != 300 {
+		t.Errorf("unexpected round-tripped animation %+v", copied)
+	}
+	for i := range copied.Frames {
+		original, _ := store.RetrieveImage(AnimationFrameID("loader", i))
+		again, _ := store.RetrieveImage(AnimationFrameID("copy", i))
+		if !bytes.Equal(original, again) {
+			t.Errorf("frame %d changed in the round trip", i)
+		}
+	}
+
+	if _, err := store.StoreAnimation("still", encodedTestPNG(t), StoreOptions{}); err == nil || !strings.Contains(err.Error(), "invalid animation") {
+		t.Errorf("expected a still PNG to be rejected, got %v", err)
+	}
+
+	if err := store.DeleteAnimation("loader"); err != nil {
+		t.Fatalf("failed to delete animation: %v", err)
+	}
+	if _, err := store.GetAnimation("loader"); err == nil {
+		t.Error("expected the animation to be gone")
+	}
+	if _, err := store.RetrieveImage(AnimationFrameID("loader", 0)); err == nil {
+		t.Error("expected the frames to be deleted")
+	}
+}
+
+func encodedTestPNG(t *testing.T) []byte {
+	t.Helper()
+	data, err := encodeImageToPNG(createTestImage(8, 8))
+	if err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	return data
+}
@@ -0,0 +1,300 @@
+package imagestore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// Annotation types
+const (
+	AnnotationBox       = "box"       // Rectangle outline
+	AnnotationHighlight = "highlight" // Translucent filled rectangle
+	AnnotationText      = "text"      // Text label with its top-left corner at X, Y
+)
+
+// maxAnnotations bounds the annotations on one image so the record stays small
+const maxAnnotations = 1000
+
+// Annotation is a vector mark drawn over an image. Annotations are kept apart
+// from the manifest, so editing them never touches the image's tiles.
+type Annotation struct {
+	Type   string `json:"type"`
+	X      int    `json:"x"`
+	Y      int    `json:"y"`
+	Width  int    `json:"width,omitempty"`  // Box and highlight size
+	Height int    `json:"height,omitempty"` // Box and highlight size; text line height
+	Color  string `json:"color,omitempty"`  // #rrggbb or #rrggbbaa; defaults by type
+	Text   string `json:"text,omitempty"`
+}
+
+// annotationKey returns the key holding an image's annotations
+func annotationKey(id string) []byte {
+	return makeKey(annotationsBucket, id)
+}
+
+// SetAnnotations replaces the annotations of a live image; an empty list
+// removes them. Annotations belong to the image ID: they survive overwrites
+// and patches, follow the image into the trash, and are removed when it is
+// permanently deleted.
+func (s *PebbleImageStore) SetAnnotations(id string, annotations []Annotation) error {
+	if err := s.enter(); err != nil {
+		return err
+	}
+	defer s.leave()
+
+	if len(annotations) > maxAnnotations {
+		return fmt.Errorf("invalid annotation: at most %d annotations are allowed", maxAnnotations)
+	}
+	for i, annotation := range annotations {
+		if err := validateAnnotation(annotation); err != nil {
+			return fmt.Errorf("invalid annotation %d: %w", i, err)
+		}
+	}
+
+	_, closer, err := s.db.Get(makeKey(imagesBucket, id))
+	if err != nil {
+		return fmt.Errorf("image not found: %s", id)
+	}
+	closer.Close()
+
+	if len(annotations) == 0 {
+		return s.db.Delete(annotationKey(id), s.writeOpts)
+	}
+	if err := s.checkDiskSpace(); err != nil {
+		return err
+	}
+
+	value, err := json.Marshal(annotations)
+	if err != nil {
+		return fmt.Errorf("failed to marshal annotations: %w", err)
+	}
+	return s.db.Set(annotationKey(id), value, s.writeOpts)
+}
+
+// GetAnnotations returns the annotations of a live image
+func (s *PebbleImageStore) GetAnnotations(id string) ([]Annotation, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+
+	_, closer, err := s.db.Get(makeKey(imagesBucket, id))
+	if err != nil {
+		return nil, fmt.Errorf("image not found: %s", id)
+	}
+	closer.Close()
+	return loadAnnotations(s.db, id)
+}
+
+// loadAnnotations reads an image's annotations, returning an empty list if
+// it has none
+func loadAnnotations(reader pebble.Reader, id string) ([]Annotation, error) {
+	annotations := []Annotation{}
+
+	value, closer, err := reader.Get(annotationKey(id))
+	if err == pebble.ErrNotFound {
+		return annotations, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+
+	if err := json.Unmarshal(value, &annotations); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal annotations: %w", err)
+	}
+	return annotations, nil
+}
+
+// RetrieveAnnotatedImage returns an image as a PNG with its annotations drawn
+// over it. The stored tiles are not changed.
+func (s *PebbleImageStore) RetrieveAnnotatedImage(id string) ([]byte, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+
+	storedImage, err := s.lookupImage(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.shareRetrieval(context.Background(), "annotated", storedImage, renderKey(storedImage), func(ctx context.Context) ([]byte, error) {
+		img, err := s.reconstructStoredImage(ctx, storedImage)
+		if err != nil {
+			return nil, err
+		}
+
+		annotations, err := loadAnnotations(s.db, id)
+		if err != nil {
+			return nil, err
+		}
+		if len(annotations) == 0 {
+			return encodeStoredImage(img, storedImage)
+		}
+
+		canvas, ok := img.(*image.RGBA)
+		if !ok {
+			canvas = image.NewRGBA(img.Bounds())
+			draw.Draw(canvas, canvas.Bounds(), img, img.Bounds().Min, draw.Src)
+		}
+		for _, annotation := range annotations {
+			drawAnnotation(canvas, annotation)
+		}
+		return encodeStoredImage(canvas, storedImage)
+	})
+}
+
+// validateAnnotation checks an annotation can be drawn
+func validateAnnotation(annotation Annotation) error {
+	switch annotation.Type {
+	case AnnotationBox, AnnotationHighlight:
+		if annotation.Width <= 0 || annotation.Height <= 0 {
+			return fmt.Errorf("%s needs a positive width and height", annotation.Type)
+		}
+	case AnnotationText:
+		if annotation.Text == "" {
+			return fmt.Errorf("text needs text")
+		}
+	default:
+		return fmt.Errorf("unknown type %q", annotation.Type)
+	}
+
+	if annotation.Color != "" {
+		if _, err := parseAnnotationColor(annotation.Color); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseAnnotationColor parses a #rrggbb or #rrggbbaa color
+func parseAnnotationColor(value string) (color.NRGBA, error) {
+	hex, ok := strings.CutPrefix(value, "#")
+	if !ok || (len(hex) != 6 && len(hex) != 8) {
+		return color.NRGBA{}, fmt.Errorf("color must be #rrggbb or #rrggbbaa, got %q", value)
+	}
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+	rgba, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.NRGBA{}, fmt.Errorf("color must be #rrggbb or #rrggbbaa, got %q", value)
+	}
+	return color.NRGBA{R: uint8(rgba >> 24), G: uint8(rgba >> 16), B: uint8(rgba >> 8), A: uint8(rgba)}, nil
+}
+
+// drawAnnotation draws one annotation onto an image, clipped to its bounds
+func drawAnnotation(img *image.RGBA, annotation Annotation) {
+	c := color.NRGBA{R: 0xff, G: 0x30, B: 0x30, A: 0xff}
+	if annotation.Type == AnnotationHighlight {
+		c = color.NRGBA{R: 0xff, G: 0xe0, B: 0x00, A: 0x66}
+	}
+	if annotation.Color != "" {
+		c, _ = parseAnnotationColor(annotation.Color)
+	}
+	fill := image.NewUniform(c)
+
+	rect := image.Rect(annotation.X, annotation.Y, annotation.X+annotation.Width, annotation.Y+annotation.Height)
+	switch annotation.Type {
+	case AnnotationHighlight:
+		draw.Draw(img, rect, fill, image.Point{}, draw.Over)
+	case AnnotationBox:
+		stroke := max(1, min(2, min(rect.Dx(), rect.Dy())/2))
+		for _, edge := range []image.Rectangle{
+			image.Rect(rect.Min.X, rect.Min.Y, rect.Max.X, rect.Min.Y+stroke),
+			image.Rect(rect.Min.X, rect.Max.Y-stroke, rect.Max.X, rect.Max.Y),
+			image.Rect(rect.Min.X, rect.Min.Y+stroke, rect.Min.X+stroke, rect.Max.Y-stroke),
+			image.Rect(rect.Max.X-stroke, rect.Min.Y+stroke, rect.Max.X, rect.Max.Y-stroke),
+		} {
+			draw.Draw(img, edge, fill, image.Point{}, draw.Over)
+		}
+	case AnnotationText:
+		// Glyphs are 3x5 cells scaled to the line height
+		scale := 2
+		if annotation.Height > 0 {
+			scale = max(1, annotation.Height/6)
+		}
+		x := annotation.X
+		for _, r := range strings.ToUpper(annotation.Text) {
+			glyph, ok := glyphs[r]
+			if !ok {
+				glyph = glyphs['?']
+				if unicode.IsSpace(r) {
+					glyph = glyphs[' ']
+				}
+			}
+			for row, bits := range glyph {
+				for col := 0; col < 3; col++ {
+					if bits&(0b100>>col) == 0 {
+						continue
+					}
+					cell := image.Rect(x+col*scale, annotation.Y+row*scale, x+(col+1)*scale, annotation.Y+(row+1)*scale)
+					draw.Draw(img, cell, fill, image.Point{}, draw.Over)
+				}
+			}
+			x += 4 * scale
+		}
+	}
+}
+
+// glyphs is a 3x5 bitmap font, one row per byte with the leftmost pixel in
+// the highest of three bits. Lowercase letters are drawn as uppercase.
+var glyphs = map[rune][5]uint8{
+	' ': {0b000, 0b000, 0b000, 0b000, 0b000},
+	'0': {0b111, 0b101, 0b101, 0b101, 0b111},
+	'1': {0b010, 0b110, 0b010, 0b010, 0b111},
+	'2': {0b111, 0b001, 0b111, 0b100, 0b111},
+	'3': {0b111, 0b001, 0b111, 0b001, 0b111},
+	'4': {0b101, 0b101, 0b111, 0b001, 0b001},
+	'5': {0b111, 0b100, 0b111, 0b001, 0b111},
+	'6': {0b111, 0b100, 0b111, 0b101, 0b111},
+	'7': {0b111, 0b001, 0b001, 0b001, 0b001},
+	'8': {0b111, 0b101, 0b111, 0b101, 0b111},
+	'9': {0b111, 0b101, 0b111, 0b001, 0b111},
+	'A': {0b010, 0b101, 0b111, 0b101, 0b101},
+	'B': {0b110, 0b101, 0b110, 0b101, 0b110},
+	'C': {0b011, 0b100, 0b100, 0b100, 0b011},
+	'D': {0b110, 0b101, 0b101, 0b101, 0b110},
+	'E': {0b111, 0b100, 0b110, 0b100, 0b111},
+	'F': {0b111, 0b100, 0b110, 0b100, 0b100},
+	'G': {0b011, 0b100, 0b101, 0b101, 0b011},
+	'H': {0b101, 0b101, 0b111, 0b101, 0b101},
+	'I': {0b111, 0b010, 0b010, 0b010, 0b111},
+	'J': {0b001, 0b001, 0b001, 0b101, 0b010},
+	'K': {0b101, 0b101, 0b110, 0b101, 0b101},
+	'L': {0b100, 0b100, 0b100, 0b100, 0b111},
+	'M': {0b101, 0b111, 0b111, 0b101, 0b101},
+	'N': {0b110, 0b101, 0b101, 0b101, 0b101},
+	'O': {0b010, 0b101, 0b101, 0b101, 0b010},
+	'P': {0b110, 0b101, 0b110, 0b100, 0b100},
+	'Q': {0b010, 0b101, 0b101, 0b110, 0b011},
+	'R': {0b110, 0b101, 0b110, 0b101, 0b101},
+	'S': {0b011, 0b100, 0b010, 0b001, 0b110},
+	'T': {0b111, 0b010, 0b010, 0b010, 0b010},
+	'U': {0b101, 0b101, 0b101, 0b101, 0b111},
+	'V': {0b101, 0b101, 0b101, 0b101, 0b010},
+	'W': {0b101, 0b101, 0b111, 0b111, 0b101},
+	'X': {0b101, 0b101, 0b010, 0b101, 0b101},
+	'Y': {0b101, 0b101, 0b010, 0b010, 0b010},
+	'Z': {0b111, 0b001, 0b010, 0b100, 0b111},
+	'.': {0b000, 0b000, 0b000, 0b000, 0b010},
+	',': {0b000, 0b000, 0b000, 0b010, 0b100},
+	':': {0b000, 0b010, 0b000, 0b010, 0b000},
+	'-': {0b000, 0b000, 0b111, 0b000, 0b000},
+	'!': {0b010, 0b010, 0b010, 0b000, 0b010},
+	'?': {0b111, 0b001, 0b010, 0b000, 0b010},
+	'/': {0b001, 0b001, 0b010, 0b100, 0b100},
+	'#': {0b101, 0b111, 0b101, 0b111, 0b101},
+	'(': {0b001, 0b010, 0b010, 0b010, 0b001},
+	')': {0b100, 0b010, 0b010, 0b010, 0b100},
+}
@@ -0,0 +1,73 @@
+package imagestore
+
+import (
+	"bytes"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+func TestAnnotations(t *testing.T) {
+	store := newTagsTestStore(t, "screen")
+	before := store.GetStorageStats()
+
+	if annotations, err := store.GetAnnotations("screen"); err != nil || len(annotations) != 0 {
+		t.Fatalf("expected no annotations, got %v (%v)", annotations, err)
+	}
+
+	annotations := []Annotation{
+		{Type: AnnotationText, X: 0, Y: 0, Text: "ok"},
+		{Type: AnnotationBox, X: 0, Y: 0, Width: 8, Height: 8, Color: "#00ff00"},
+		{Type: AnnotationHighlight, X: 3, Y: 3, Width: 2, Height: 2, Color: "#0000ffff"},
+	}
+	if err := store.SetAnnotations("screen", annotations); err != nil {
+		t.Fatalf("failed to set annotations: %v", err)
+	}
+	stored, err := store.GetAnnotations("screen")
+	if err != nil || len(stored) != 3 || stored[2] != annotations[2] {
+		t.Errorf("expected the annotations back, got %v (%v)", stored, err)
+	}
+
+	plain, _ := store.RetrieveImage("screen")
+	annotated, err := store.RetrieveAnnotatedImage("screen")
+	if err != nil {
+		t.Fatalf("failed to retrieve annotated image: %v", err)
+	}
+	img, _ := decodeImageFromBytes(annotated)
+	if got := color.RGBAModel.Convert(img.At(4, 4)); got != (color.RGBA{0, 0, 255, 255}) {
+		t.Errorf("expected the highlight at (4, 4), got %v", got)
+	}
+	if got := color.RGBAModel.Convert(img.At(7, 7)); got != (color.RGBA{0, 255, 0, 255}) {
+		t.Errorf("expected the box edge at (7, 7), got %v", got)
+	}
+
+	// The overlay never touches the stored tiles
+	if after := store.GetStorageStats(); after.UniqueTiles != before.UniqueTiles {
+		t.Errorf("expected no new tiles, got %d -> %d", before.UniqueTiles, after.UniqueTiles)
+	}
+	if again, _ := store.RetrieveImage("screen"); !bytes.Equal(plain, again) {
+		t.Error("expected the plain image to be unchanged")
+	}
+
+	invalid := []Annotation{
+		{Type: "circle", Width: 1, Height: 1},
+		{Type: AnnotationBox},
+		{Type: AnnotationText},
+		{Type: AnnotationHighlight, Width: 1, Height: 1, Color: "red"},
+	}
+	for _, annotation := range invalid {
+		if err := store.SetAnnotations("screen", []Annotation{annotation}); err == nil || !strings.Contains(err.Error(), "invalid annotation") {
+			t.Errorf("expected %+v to be rejected, got %v", annotation, err)
+		}
+	}
+	if err := store.SetAnnotations("missing", annotations); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("expected not found, got %v", err)
+	}
+
+	// Annotations go with the image when it is deleted for good
+	store.deleteImagePermanently("screen")
+	if _, closer, err := store.db.Get(annotationKey("screen")); err == nil {
+		closer.Close()
+		t.Error("expected annotations to be deleted with the image")
+	}
+}
@@ -0,0 +1,115 @@
+package imagestore
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// auditBucket holds each image's audit log, keyed by image ID and the
+// sequence number of the change it records
+var auditBucket = []byte("audit")
+
+// AuditAction is what an audit entry records happening to an image
+type AuditAction string
+
+const (
+	AuditStored            AuditAction = "stored"             // Stored under an ID that had no image
+	AuditOverwritten       AuditAction = "overwritten"        // Replaced, patched or rebuilt from a manifest
+	AuditDeleted           AuditAction = "deleted"            // Moved to the trash or deleted outright
+	AuditRestored          AuditAction = "restored"           // Restored from the trash
+	AuditMetadataChanged   AuditAction = "metadata_changed"   // Metadata replaced
+	AuditTagsChanged       AuditAction = "tags_changed"       // Tags added or removed
+	AuditExpiryChanged     AuditAction = "expiry_changed"     // Expiry set or cleared
+	AuditRedactionsChanged AuditAction = "redactions_changed" // Redactions replaced
+)
+
+// changeType returns the change feed event for an audited action
+func (a AuditAction) changeType() ChangeType {
+	switch a {
+	case AuditStored, AuditRestored:
+		return ChangeCreated
+	case AuditDeleted:
+		return ChangeDeleted
+	default:
+		return ChangeUpdated
+	}
+}
+
+// AuditEntry is one entry in an image's audit log. Entries are only ever
+// added, and outlive the image: deleting it doesn't remove its history.
+type AuditEntry struct {
+	Seq    uint64      `json:"seq"` // Sequence number of the change feed event
+	Time   time.Time   `json:"time"`
+	Action AuditAction `json:"action"`
+	Actor  string      `json:"actor,omitempty"` // Who made the change, from WithActor; empty when unknown
+}
+
+type actorKey struct{}
+
+// WithActor returns a context recording who is making the changes done with
+// it, for the audit log
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// actorFrom returns the actor recorded in ctx by WithActor
+func actorFrom(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// auditKey returns the key of an image's audit entry
+func auditKey(id string, seq uint64) []byte {
+	return binary.BigEndian.AppendUint64(auditPrefix(id), seq)
+}
+
+// auditPrefix returns the prefix of every audit entry of an image
+func auditPrefix(id string) []byte {
+	return makeKey(auditBucket, id+"\x00")
+}
+
+// recordAudit adds an audit entry to a batch
+func recordAudit(batch *pebble.Batch, id string, entry AuditEntry) error {
+	value, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := batch.Set(auditKey(id, entry.Seq), value, pebble.Sync); err != nil {
+		return fmt.Errorf("failed to record audit entry: %w", err)
+	}
+	return nil
+}
+
+// History returns the audit log of an image, oldest first. It includes
+// images since deleted; an ID that never held an image has an empty history.
+func (s *PebbleImageStore) History(id string) ([]AuditEntry, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+
+	prefix := auditPrefix(id)
+	it, err := s.db.NewIter(&pebble.IterOptions{
+		LowerBound: prefix,
+		UpperBound: prefixUpperBound(prefix),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+
+	var entries []AuditEntry
+	for it.First(); it.Valid(); it.Next() {
+		var entry AuditEntry
+		if err := json.Unmarshal(it.Value(), &entry); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal audit entry: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, it.Error()
+}
@@ -0,0 +1,80 @@
+package imagestore
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestHistoryRecordsEveryChange(t *testing.T) {
+	store := newTagsTestStore(t)
+
+	imageData, _ := encodeImageToPNG(createTestImage(8, 8))
+	if err := store.StoreImageContext(WithActor(context.Background(), "alice"), "a", imageData, StoreOptions{}); err != nil {
+		t.Fatalf("failed to store image: %v", err)
+	}
+	if err := store.StoreImage("ab", imageData); err != nil {
+		t.Fatalf("failed to store image: %v", err)
+	}
+	replacement, _ := encodeImageToPNG(createTestImage(12, 8))
+	if err := store.StoreImage("a", replacement); err != nil {
+		t.Fatalf("failed to overwrite image: %v", err)
+	}
+	if err := store.SetMetadata("a", map[string]string{"branch": "main"}); err != nil {
+		t.Fatalf("failed to set metadata: %v", err)
+	}
+	if err := store.AddTags("a", "nightly"); err != nil {
+		t.Fatalf("failed to tag image: %v", err)
+	}
+	expiresAt := time.Now().Add(time.Hour)
+	if err := store.SetExpiration("a", &expiresAt); err != nil {
+		t.Fatalf("failed to set expiry: %v", err)
+	}
+	if err := store.DeleteImage("a"); err != nil {
+		t.Fatalf("failed to delete image: %v", err)
+	}
+	if err := store.UndeleteImage("a"); err != nil {
+		t.Fatalf("failed to restore image: %v", err)
+	}
+
+	history, err := store.History("a")
+	if err != nil {
+		t.Fatalf("failed to read history: %v", err)
+	}
+	want := []AuditAction{AuditStored, AuditOverwritten, AuditMetadataChanged, AuditTagsChanged, AuditExpiryChanged, AuditDeleted, AuditRestored}
+	if len(history) != len(want) {
+		t.Fatalf("expected %d entries, got %+v", len(want), history)
+	}
+	for i, entry := range history {
+		if entry.Action != want[i] {
+			t.Errorf("entry %d: expected %s, got %s", i, want[i], entry.Action)
+		}
+		if i > 0 && entry.Seq <= history[i-1].Seq {
+			t.Errorf("entry %d is out of order: %+v", i, history)
+		}
+	}
+	if history[0].Actor != "alice" || history[1].Actor != "" {
+		t.Errorf("expected only the first store to have an actor, got %+v", history[:2])
+	}
+
+	// An image's history is kept after it is gone for good
+	store.config.TrashRetention = 0
+	if err := store.DeleteImage("a"); err != nil {
+		t.Fatalf("failed to delete image: %v", err)
+	}
+	history, err = store.History("a")
+	if err != nil {
+		t.Fatalf("failed to read history: %v", err)
+	}
+	if len(history) != len(want)+1 || history[len(history)-1].Action != AuditDeleted {
+		t.Errorf("expected the permanent delete to be recorded, got %+v", history)
+	}
+
+	// IDs sharing a prefix have separate histories
+	if history, _ := store.History("ab"); len(history) != 1 {
+		t.Errorf("expected one entry for ab, got %+v", history)
+	}
+	if history, _ := store.History("missing"); len(history) != 0 {
+		t.Errorf("expected no history for an unknown ID, got %+v", history)
+	}
+}
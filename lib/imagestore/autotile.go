@@ -0,0 +1,101 @@
+package imagestore
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// Tile sizes Config.AutoTileSize chooses between
+const (
+	autoTileSmall  = 128
+	autoTileMedium = 256
+	autoTileLarge  = 512
+)
+
+// autoTileSamples is how many pixels along each axis chooseTileSize looks
+// at, bounding the cost on large images
+const autoTileSamples = 256
+
+// Thresholds of the tile size heuristic. Screenshots of user interfaces use
+// few distinct shades and change sharply at text and widget borders, so
+// small tiles dedupe their repeated elements and limit what an edit touches.
+// Photographs use most shades and change gradually; they rarely share tiles,
+// so large tiles spend less on per-tile overhead and compress better.
+const (
+	autoFlatEntropy  = 5.0       // Bits of luminance entropy below which an image is drawn rather than photographed
+	autoPhotoEntropy = 6.5       // Bits of luminance entropy above which an image is photographic
+	autoSharpEdge    = 48        // Luminance step between neighbors counted as a sharp edge
+	autoDenseEdges   = 0.08      // Share of sharp neighbors above which an image is text-heavy
+	autoLargePixels  = 4_000_000 // Images at least this large can use large tiles
+	autoSmallMaxSide = 4096      // Longest side that still gets small tiles; beyond it the tile count explodes
+)
+
+// tileSample summarizes the pixels chooseTileSize samples
+type tileSample struct {
+	entropy float64 // Shannon entropy of the luminance histogram, in bits
+	edges   float64 // Share of sampled pixels differing sharply from the next pixel right or below
+}
+
+// sampleTileStats samples up to autoTileSamples by autoTileSamples pixels of
+// img, composited over background
+func sampleTileStats(img image.Image, background color.RGBA) tileSample {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	stepX := max(1, width/autoTileSamples)
+	stepY := max(1, height/autoTileSamples)
+
+	luma := func(x, y int) int {
+		r, g, b := compositeRGB(img.At(bounds.Min.X+x, bounds.Min.Y+y), background)
+		return (299*int(r) + 587*int(g) + 114*int(b)) / 1000
+	}
+
+	var histogram [256]int
+	samples, sharp := 0, 0
+	for y := 0; y < height; y += stepY {
+		for x := 0; x < width; x += stepX {
+			l := luma(x, y)
+			histogram[l]++
+			samples++
+			if x+1 < width && abs(luma(x+1, y)-l) >= autoSharpEdge || y+1 < height && abs(luma(x, y+1)-l) >= autoSharpEdge {
+				sharp++
+			}
+		}
+	}
+
+	var sample tileSample
+	if samples == 0 {
+		return sample
+	}
+	for _, count := range histogram {
+		if count > 0 {
+			p := float64(count) / float64(samples)
+			sample.entropy -= p * math.Log2(p)
+		}
+	}
+	sample.edges = float64(sharp) / float64(samples)
+	return sample
+}
+
+// chooseTileSize picks the tile size for an upload from its content and
+// dimensions: small tiles for drawn, text-heavy images such as UI
+// screenshots, large tiles for big photographs, and medium tiles otherwise
+func chooseTileSize(img image.Image, background color.RGBA) int {
+	bounds := img.Bounds()
+	pixels := bounds.Dx() * bounds.Dy()
+	sample := sampleTileStats(img, background)
+
+	switch {
+	case max(bounds.Dx(), bounds.Dy()) <= autoSmallMaxSide &&
+		(sample.entropy < autoFlatEntropy || sample.entropy < autoPhotoEntropy && sample.edges >= autoDenseEdges):
+		return autoTileSmall
+	case pixels >= autoLargePixels && sample.entropy >= autoPhotoEntropy && sample.edges < autoDenseEdges:
+		return autoTileLarge
+	}
+	return autoTileMedium
+}
+
+// isAutoTileSize reports whether size is one chooseTileSize can pick
+func isAutoTileSize(size int) bool {
+	return size == autoTileSmall || size == autoTileMedium || size == autoTileLarge
+}
@@ -0,0 +1,99 @@
+package imagestore
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"path/filepath"
+	"testing"
+)
+
+// createUIImage draws dark text-like bars on a light background
+func createUIImage(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.RGBA{245, 245, 245, 255}), image.Point{}, draw.Src)
+	for y := 8; y+6 < height; y += 14 {
+		for x := 8; x+3 < width; x += 5 {
+			draw.Draw(img, image.Rect(x, y, x+3, y+6), image.NewUniform(color.RGBA{30, 30, 30, 255}), image.Point{}, draw.Src)
+		}
+	}
+	return img
+}
+
+// createGradientImage shades smoothly through every luminance, like a photo
+func createGradientImage(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			v := uint8((x + y) * 255 / (width + height))
+			img.Set(x, y, color.RGBA{v, v, v, 255})
+		}
+	}
+	return img
+}
+
+func TestChooseTileSize(t *testing.T) {
+	black := color.RGBA{A: 255}
+	tests := []struct {
+		name     string
+		img      image.Image
+		expected int
+	}{
+		{"UI screenshot", createUIImage(800, 600), 128},
+		{"small photo", createGradientImage(800, 600), 256},
+		{"large photo", createGradientImage(2400, 1800), 512},
+		{"giant UI screenshot", createUIImage(5000, 200), 256},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if size := chooseTileSize(tt.img, black); size != tt.expected {
+				sample := sampleTileStats(tt.img, black)
+				t.Errorf("expected %d pixel tiles, got %d (entropy %.2f, edges %.3f)", tt.expected, size, sample.entropy, sample.edges)
+			}
+		})
+	}
+}
+
+func TestAutoTileSize(t *testing.T) {
+	config := DefaultConfig()
+	config.DatabasePath = filepath.Join(t.TempDir(), "test.db")
+	config.TrashRetention = 0
+	config.AutoTileSize = true
+	store, err := NewPebbleImageStore(config)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	original := createUIImage(300, 200)
+	data, _ := encodeImageToPNG(original)
+	if err := store.StoreImage("ui", data); err != nil {
+		t.Fatalf("failed to store image: %v", err)
+	}
+	storedImage, err := store.GetManifest("ui")
+	if err != nil || storedImage.TileSize != 128 || len(storedImage.TileRefs) != 6 {
+		t.Fatalf("expected 3x2 tiles of 128 pixels, got %+v (%v)", storedImage, err)
+	}
+	if got := decodeRGBA(t, mustRetrieve(t, store, "ui")); !bytes.Equal(got.Pix, original.Pix) {
+		t.Error("retrieved image differs from the original")
+	}
+
+	// Patches and clones keep the image's tile size
+	patch, _ := encodeImageToPNG(createGradientImage(10, 10))
+	if _, err := store.PatchImage("ui", patch, 130, 5); err != nil {
+		t.Fatalf("failed to patch image: %v", err)
+	}
+	draw.Draw(original, image.Rect(130, 5, 140, 15), createGradientImage(10, 10), image.Point{}, draw.Src)
+	if err := store.CloneImage("ui", "copy"); err != nil {
+		t.Fatalf("failed to clone image: %v", err)
+	}
+	for _, id := range []string{"ui", "copy"} {
+		if got := decodeRGBA(t, mustRetrieve(t, store, id)); !bytes.Equal(got.Pix, original.Pix) {
+			t.Errorf("%s differs from the patched original", id)
+		}
+	}
+	if tileData, err := store.TilePNG(storedImage.TileRefs[0].TileID); err != nil || decodeRGBA(t, tileData).Rect.Dx() != 128 {
+		t.Errorf("expected a 128 pixel tile preview (%v)", err)
+	}
+}
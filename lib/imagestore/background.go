@@ -0,0 +1,35 @@
+package imagestore
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// startBackgroundJob runs fn every interval until the store is closed
+func (s *PebbleImageStore) startBackgroundJob(name string, interval time.Duration, fn func() error) {
+	s.jobs.Add(1)
+	go func() {
+		defer s.jobs.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.stopJobs:
+				return
+			case <-ticker.C:
+				if err := fn(); err != nil && !errors.Is(err, ErrStoreClosed) {
+					fmt.Printf("Warning: background job %s failed: %v\n", name, err)
+				}
+			}
+		}
+	}()
+}
+
+// stopBackgroundJobs signals every background job to exit and waits for them
+func (s *PebbleImageStore) stopBackgroundJobs() {
+	close(s.stopJobs)
+	s.jobs.Wait()
+}
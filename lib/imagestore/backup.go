@@ -0,0 +1,480 @@
+package imagestore
+
+import (
+	"archive/tar"
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// ErrNoBackupTarget is returned by backup operations when Config.BackupTarget
+// is not set
+var ErrNoBackupTarget = errors.New("no backup target configured")
+
+// BackupTarget holds backup archives. NewDirBackupTarget provides one backed
+// by a directory and s3backup.New one backed by an S3 bucket.
+type BackupTarget interface {
+	// PutBackup stores an object, replacing any object with the same name
+	PutBackup(name string, data io.ReadSeeker) error
+	// GetBackup opens an object stored with PutBackup
+	GetBackup(name string) (io.ReadCloser, error)
+	// ListBackups returns the names of all stored objects
+	ListBackups() ([]string, error)
+	// DeleteBackup removes an object, succeeding if it is already gone
+	DeleteBackup(name string) error
+}
+
+// Backups are tar archives of a database checkpoint, each with a sidecar
+// object holding the hex SHA-256 of the archive
+const (
+	backupPrefix         = "imagestore-"
+	backupSuffix         = ".tar"
+	backupChecksumSuffix = ".sha256"
+	backupTimeLayout     = "20060102T150405Z"
+)
+
+// backupCheckInterval is how often the backup job compares the clock with
+// Config.BackupSchedule, the finest resolution a cron schedule has
+const backupCheckInterval = time.Minute
+
+// BackupInfo describes a backup archive
+type BackupInfo struct {
+	Name     string        `json:"name"`
+	Time     time.Time     `json:"time"`               // When the checkpoint was taken
+	Bytes    int64         `json:"bytes,omitempty"`    // Archive size; only known for backups taken since the store opened
+	Checksum string        `json:"checksum,omitempty"` // Hex SHA-256 of the archive
+	Duration time.Duration `json:"duration,omitempty"` // Time taken to create, upload and verify the backup
+	Verified bool          `json:"verified"`           // The archive read back from the target matched Checksum
+}
+
+// BackupStats describes scheduled and requested backups since the store was
+// opened
+type BackupStats struct {
+	Enabled      bool
+	Schedule     string
+	NextBackup   time.Time // Zero until the schedule is first checked
+	Succeeded    int64
+	Failed       int64
+	LastBackup   *BackupInfo // Newest successful backup
+	LastError    string      // Why the latest backup failed; cleared by a success
+	LastFailure  time.Time
+	BackupsKept  int // Archives on the target after the latest rotation
+	RunningSince time.Time
+}
+
+// backupState serializes backups and tracks their outcome
+type backupState struct {
+	run      sync.Mutex // Held while a backup runs
+	mu       sync.Mutex // Guards the fields below
+	schedule *cronSchedule
+	next     time.Time
+	stats    BackupStats
+}
+
+// backupName returns the archive name of a backup taken at t
+func backupName(t time.Time) string {
+	return backupPrefix + t.UTC().Format(backupTimeLayout) + backupSuffix
+}
+
+// parseBackupName returns when the backup with an archive name was taken
+func parseBackupName(name string) (time.Time, bool) {
+	stamp, ok := strings.CutPrefix(name, backupPrefix)
+	if !ok {
+		return time.Time{}, false
+	}
+	stamp, ok = strings.CutSuffix(stamp, backupSuffix)
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(backupTimeLayout, stamp)
+	return t, err == nil
+}
+
+// Backup takes a consistent checkpoint of the database while it keeps
+// serving, uploads it to Config.BackupTarget as a tar archive with its
+// checksum, reads it back to verify the checksum, and then deletes the
+// oldest archives beyond Config.BackupKeep. Backups run one at a time.
+func (s *PebbleImageStore) Backup() (*BackupInfo, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+
+	target := s.config.BackupTarget
+	if target == nil {
+		return nil, ErrNoBackupTarget
+	}
+
+	s.backups.run.Lock()
+	defer s.backups.run.Unlock()
+
+	start := time.Now().UTC()
+	s.backups.mu.Lock()
+	s.backups.stats.RunningSince = start
+	s.backups.mu.Unlock()
+
+	info, kept, err := s.runBackup(target, start)
+
+	s.backups.mu.Lock()
+	defer s.backups.mu.Unlock()
+	stats := &s.backups.stats
+	stats.RunningSince = time.Time{}
+	if err != nil {
+		stats.Failed++
+		stats.LastError = err.Error()
+		stats.LastFailure = time.Now()
+		return nil, err
+	}
+	stats.Succeeded++
+	stats.LastBackup = info
+	stats.LastError = ""
+	stats.BackupsKept = kept
+	return info, nil
+}
+
+// runBackup creates, uploads, verifies and rotates a backup, returning the
+// number of archives kept
+func (s *PebbleImageStore) runBackup(target BackupTarget, start time.Time) (*BackupInfo, int, error) {
+	// The checkpoint hard-links the database's files, so it is made next
+	// to the database rather than in the system temp directory
+	dir, err := os.MkdirTemp(filepath.Dir(filepath.Clean(s.config.DatabasePath)), ".backup-")
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create backup directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Blob collection must not remove a file the checkpoint points into
+	// before it is linked
+	checkpoint := filepath.Join(dir, "checkpoint")
+	s.blobs.collect.Lock()
+	err = s.db.Checkpoint(checkpoint, pebble.WithFlushedWAL())
+	if err == nil {
+		if err = s.blobs.link(filepath.Join(checkpoint, blobDir)); err != nil {
+			err = fmt.Errorf("failed to link blob files: %w", err)
+		}
+	} else {
+		err = fmt.Errorf("failed to checkpoint database: %w", err)
+	}
+	s.blobs.collect.Unlock()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	archive, err := os.Create(filepath.Join(dir, "backup"+backupSuffix))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer archive.Close()
+
+	hash := sha256.New()
+	buffered := bufio.NewWriter(io.MultiWriter(archive, hash))
+	if err := writeBackupArchive(buffered, checkpoint); err != nil {
+		return nil, 0, fmt.Errorf("failed to archive checkpoint: %w", err)
+	}
+	if err := buffered.Flush(); err != nil {
+		return nil, 0, err
+	}
+	size, err := archive.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, 0, err
+	}
+	if _, err := archive.Seek(0, io.SeekStart); err != nil {
+		return nil, 0, err
+	}
+
+	info := &BackupInfo{
+		Name:     backupName(start),
+		Time:     start,
+		Bytes:    size,
+		Checksum: hex.EncodeToString(hash.Sum(nil)),
+	}
+	if err := target.PutBackup(info.Name, archive); err != nil {
+		return nil, 0, fmt.Errorf("failed to upload backup %s: %w", info.Name, err)
+	}
+	if err := target.PutBackup(info.Name+backupChecksumSuffix, strings.NewReader(info.Checksum+"\n")); err != nil {
+		return nil, 0, fmt.Errorf("failed to upload checksum of %s: %w", info.Name, err)
+	}
+	if err := verifyBackup(target, info.Name, info.Checksum); err != nil {
+		return nil, 0, err
+	}
+	info.Verified = true
+	info.Duration = time.Since(start)
+
+	kept, err := s.rotateBackups(target)
+	if err != nil {
+		return nil, 0, err
+	}
+	return info, kept, nil
+}
+
+// writeBackupArchive writes the files under dir to a tar stream, named
+// relative to dir
+func writeBackupArchive(w io.Writer, dir string) error {
+	tw := tar.NewWriter(w)
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		name, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		fileInfo, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		header, err := tar.FileInfoHeader(fileInfo, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(name)
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+
+		// The active blob file may have grown since it was linked; what
+		// the checkpoint points to is within the size already recorded
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.CopyN(tw, f, header.Size)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// verifyBackup reads an archive back from the target and compares its hash
+// with checksum
+func verifyBackup(target BackupTarget, name, checksum string) error {
+	r, err := target.GetBackup(name)
+	if err != nil {
+		return fmt.Errorf("failed to read back backup %s: %w", name, err)
+	}
+	defer r.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, r); err != nil {
+		return fmt.Errorf("failed to read back backup %s: %w", name, err)
+	}
+	if actual := hex.EncodeToString(hash.Sum(nil)); actual != checksum {
+		return fmt.Errorf("backup %s failed verification: checksum %s, expected %s", name, actual, checksum)
+	}
+	return nil
+}
+
+// readBackupChecksum returns the checksum recorded for an archive
+func readBackupChecksum(target BackupTarget, name string) (string, error) {
+	r, err := target.GetBackup(name + backupChecksumSuffix)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	data, err := io.ReadAll(io.LimitReader(r, 1024))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// backupNames returns the archive names on the target, oldest first
+func backupNames(target BackupTarget) ([]string, error) {
+	objects, err := target.ListBackups()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups: %w", err)
+	}
+
+	var names []string
+	for _, name := range objects {
+		if _, ok := parseBackupName(name); ok {
+			names = append(names, name)
+		}
+	}
+	// The timestamp layout sorts in time order
+	sort.Strings(names)
+	return names, nil
+}
+
+// rotateBackups deletes the oldest archives beyond Config.BackupKeep and
+// returns how many remain
+func (s *PebbleImageStore) rotateBackups(target BackupTarget) (int, error) {
+	names, err := backupNames(target)
+	if err != nil {
+		return 0, err
+	}
+	keep := s.config.BackupKeep
+	if keep <= 0 || len(names) <= keep {
+		return len(names), nil
+	}
+
+	for _, name := range names[:len(names)-keep] {
+		if err := target.DeleteBackup(name); err != nil {
+			return 0, fmt.Errorf("failed to delete old backup %s: %w", name, err)
+		}
+		if err := target.DeleteBackup(name + backupChecksumSuffix); err != nil {
+			return 0, fmt.Errorf("failed to delete checksum of old backup %s: %w", name, err)
+		}
+	}
+	return keep, nil
+}
+
+// ListBackups returns the backups on Config.BackupTarget, oldest first.
+// With verify, each archive is read back and checked against its recorded
+// checksum; otherwise Verified is false.
+func (s *PebbleImageStore) ListBackups(verify bool) ([]BackupInfo, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+
+	target := s.config.BackupTarget
+	if target == nil {
+		return nil, ErrNoBackupTarget
+	}
+
+	names, err := backupNames(target)
+	if err != nil {
+		return nil, err
+	}
+
+	backups := make([]BackupInfo, 0, len(names))
+	for _, name := range names {
+		taken, _ := parseBackupName(name)
+		info := BackupInfo{Name: name, Time: taken}
+		if checksum, err := readBackupChecksum(target, name); err == nil {
+			info.Checksum = checksum
+		}
+		if verify && info.Checksum != "" {
+			info.Verified = verifyBackup(target, name, info.Checksum) == nil
+		}
+		backups = append(backups, info)
+	}
+	return backups, nil
+}
+
+// runScheduledBackup takes a backup once Config.BackupSchedule comes due.
+// The first check schedules from the newest backup on the target, so a
+// backup missed while the server was down is taken straight away.
+func (s *PebbleImageStore) runScheduledBackup() error {
+	now := time.Now().UTC()
+
+	s.backups.mu.Lock()
+	if s.backups.next.IsZero() {
+		last := now
+		if names, err := backupNames(s.config.BackupTarget); err == nil && len(names) > 0 {
+			last, _ = parseBackupName(names[len(names)-1])
+		}
+		s.backups.next = s.backups.schedule.next(last)
+	}
+	due := !s.backups.next.IsZero() && !now.Before(s.backups.next)
+	if due {
+		// A failed backup waits for the next scheduled time rather than
+		// retrying every check
+		s.backups.next = s.backups.schedule.next(now)
+	}
+	s.backups.mu.Unlock()
+
+	if !due {
+		return nil
+	}
+	_, err := s.Backup()
+	return err
+}
+
+// backupStats returns the backup section of the storage statistics
+func (s *PebbleImageStore) backupStats() BackupStats {
+	s.backups.mu.Lock()
+	defer s.backups.mu.Unlock()
+
+	stats := s.backups.stats
+	stats.Enabled = s.config.BackupTarget != nil
+	stats.Schedule = s.config.BackupSchedule
+	stats.NextBackup = s.backups.next
+	return stats
+}
+
+// DirBackupTarget keeps backups as files in a directory, which may be a
+// mount of other storage
+type DirBackupTarget struct {
+	dir string
+}
+
+var _ BackupTarget = (*DirBackupTarget)(nil)
+
+// NewDirBackupTarget creates a backup target in dir, creating it if needed
+func NewDirBackupTarget(dir string) (*DirBackupTarget, error) {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, fmt.Errorf("failed to create backup directory: %w", err)
+	}
+	return &DirBackupTarget{dir: dir}, nil
+}
+
+// PutBackup writes an object through a temporary file so a partial backup
+// never appears under its name
+func (d *DirBackupTarget) PutBackup(name string, data io.ReadSeeker) error {
+	tmp, err := os.CreateTemp(d.dir, ".backup-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(d.dir, name))
+}
+
+// GetBackup opens an object
+func (d *DirBackupTarget) GetBackup(name string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(d.dir, name))
+}
+
+// ListBackups returns the names of the files in the directory, skipping
+// uploads in progress
+func (d *DirBackupTarget) ListBackups() ([]string, error) {
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+// DeleteBackup removes an object
+func (d *DirBackupTarget) DeleteBackup(name string) error {
+	if err := os.Remove(filepath.Join(d.dir, name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package imagestore
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBackup(t *testing.T) {
+	store := newTagsTestStore(t, "a", "b")
+	if _, err := store.Backup(); !errors.Is(err, ErrNoBackupTarget) {
+		t.Fatalf("expected ErrNoBackupTarget without a target, got %v", err)
+	}
+
+	target, err := NewDirBackupTarget(filepath.Join(t.TempDir(), "backups"))
+	if err != nil {
+		t.Fatalf("failed to create target: %v", err)
+	}
+	store.config.BackupTarget = target
+	store.config.BackupKeep = 2
+
+	// Seed two older backups, the oldest of which rotation removes
+	for _, days := range []int{2, 1} {
+		name := backupName(time.Now().AddDate(0, 0, -days))
+		target.PutBackup(name, strings.NewReader("old"))
+		target.PutBackup(name+backupChecksumSuffix, strings.NewReader("bad\n"))
+	}
+
+	info, err := store.Backup()
+	if err != nil {
+		t.Fatalf("failed to back up: %v", err)
+	}
+	if !info.Verified || info.Bytes == 0 || len(info.Checksum) != 64 {
+		t.Errorf("unexpected backup %+v", info)
+	}
+
+	backups, err := store.ListBackups(true)
+	if err != nil {
+		t.Fatalf("failed to list backups: %v", err)
+	}
+	if len(backups) != 2 || backups[1].Name != info.Name {
+		t.Fatalf("expected the newest 2 backups kept, got %+v", backups)
+	}
+	if backups[0].Verified || !backups[1].Verified {
+		t.Errorf("expected only the real backup to verify, got %+v", backups)
+	}
+
+	stats := store.GetStorageStats().Backups
+	if !stats.Enabled || stats.Succeeded != 1 || stats.BackupsKept != 2 || stats.LastBackup.Name != info.Name {
+		t.Errorf("unexpected backup stats %+v", stats)
+	}
+
+	// The archive restores to a working store
+	restored := filepath.Join(t.TempDir(), "restored.db")
+	r, err := target.GetBackup(info.Name)
+	if err != nil {
+		t.Fatalf("failed to open backup: %v", err)
+	}
+	defer r.Close()
+	extractTar(t, r, restored)
+
+	config := DefaultConfig()
+	config.DatabasePath = restored
+	config.TileSize = 4
+	restoredStore, err := NewPebbleImageStore(config)
+	if err != nil {
+		t.Fatalf("failed to open restored database: %v", err)
+	}
+	defer restoredStore.Close()
+	if !bytes.Equal(mustRetrieve(t, restoredStore, "b"), mustRetrieve(t, store, "b")) {
+		t.Error("expected the restored store to return the same image")
+	}
+}
+
+func TestScheduledBackup(t *testing.T) {
+	store := newTagsTestStore(t, "a")
+	target, _ := NewDirBackupTarget(t.TempDir())
+	store.config.BackupTarget = target
+	store.config.BackupSchedule = "0 3 * * *"
+	store.backups.schedule, _ = parseCronSchedule(store.config.BackupSchedule)
+
+	// A backup missed since the newest one on the target is taken at once
+	old := backupName(time.Now().AddDate(0, 0, -3))
+	target.PutBackup(old, strings.NewReader("old"))
+	if err := store.runScheduledBackup(); err != nil {
+		t.Fatalf("scheduled backup failed: %v", err)
+	}
+	if stats := store.GetStorageStats().Backups; stats.Succeeded != 1 || !stats.NextBackup.After(time.Now()) {
+		t.Errorf("expected a catch-up backup and the next one scheduled, got %+v", stats)
+	}
+
+	// Until the next scheduled time nothing more runs
+	if err := store.runScheduledBackup(); err != nil {
+		t.Fatalf("scheduled check failed: %v", err)
+	}
+	if stats := store.GetStorageStats().Backups; stats.Succeeded != 1 {
+		t.Errorf("expected no second backup, got %+v", stats)
+	}
+}
+
+// extractTar unpacks an archive of regular files into dir
+func extractTar(t *testing.T, r io.Reader, dir string) {
+	t.Helper()
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			t.Fatalf("failed to read archive: %v", err)
+		}
+		path := filepath.Join(dir, filepath.FromSlash(header.Name))
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(tr)
+		if err := os.WriteFile(path, data, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package imagestore
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"io"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// exportErrorsFile lists the images ExportImagesTar couldn't retrieve
+const exportErrorsFile = "errors.json"
+
+// ImageResult is one image retrieved by RetrieveImages
+type ImageResult struct {
+	ID   string
+	Data []byte // As returned by RetrieveImage
+	Err  error
+}
+
+// RetrieveImages retrieves ids with up to workers images reconstructed at
+// once, or GOMAXPROCS when workers is 0, and calls fn with each result in
+// the order of ids. At most workers results are held in memory, so a slow
+// fn holds back the reconstructions instead of buffering them. A failed
+// image is passed to fn rather than stopping the batch; RetrieveImages
+// stops at the first error fn returns, or when ctx is done.
+func (s *PebbleImageStore) RetrieveImages(ctx context.Context, ids []string, workers int, fn func(ImageResult) error) error {
+	if err := s.enter(); err != nil {
+		return err
+	}
+	defer s.leave()
+
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Each image gets its own buffered channel, so results are delivered in
+	// order however the reconstructions finish. A slot is taken before an
+	// image starts and given back once fn has its result.
+	results := make([]chan ImageResult, len(ids))
+	for i := range results {
+		results[i] = make(chan ImageResult, 1)
+	}
+	slots := make(chan struct{}, workers)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i, id := range ids {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				data, err := s.RetrieveImageContext(ctx, id)
+				results[i] <- ImageResult{ID: id, Data: data, Err: err}
+			}()
+		}
+	}()
+
+	for i := range ids {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var result ImageResult
+		select {
+		case result = <-results[i]:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		<-slots
+		if err := fn(result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExportImagesTar retrieves ids with RetrieveImages and streams them as a
+// tar archive, each written as images/<id>.png as soon as it and the images
+// before it are ready. Images that can't be retrieved, such as unknown IDs,
+// are left out and listed with their errors in a trailing errors.json.
+func (s *PebbleImageStore) ExportImagesTar(ctx context.Context, w io.Writer, ids []string, workers int) error {
+	tw := tar.NewWriter(w)
+	modTime := time.Now().UTC()
+
+	failed := make(map[string]string)
+	err := s.RetrieveImages(ctx, ids, workers, func(result ImageResult) error {
+		if result.Err != nil {
+			failed[result.ID] = result.Err.Error()
+			return nil
+		}
+		return writeTarFile(tw, exportImagesDir+result.ID+".png", result.Data, modTime)
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(failed) > 0 {
+		data, err := json.MarshalIndent(failed, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := writeTarFile(tw, exportErrorsFile, data, modTime); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
@@ -0,0 +1,85 @@
+package imagestore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestRetrieveImages(t *testing.T) {
+	var ids []string
+	for i := 0; i < 20; i++ {
+		ids = append(ids, fmt.Sprintf("img%02d", i))
+	}
+	store := newTagsTestStore(t, ids...)
+
+	requested := append([]string{"missing"}, ids...)
+	var got []string
+	err := store.RetrieveImages(context.Background(), requested, 4, func(result ImageResult) error {
+		got = append(got, result.ID)
+		if result.ID == "missing" {
+			if result.Err == nil {
+				t.Error("expected an error for an unknown image")
+			}
+			return nil
+		}
+		expected := mustRetrieve(t, store, result.ID)
+		if result.Err != nil || !bytes.Equal(result.Data, expected) {
+			t.Errorf("expected %s to match RetrieveImage (%v)", result.ID, result.Err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to retrieve images: %v", err)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(requested) {
+		t.Errorf("expected results in request order, got %v", got)
+	}
+
+	// An error from fn stops the batch
+	stop := errors.New("stop")
+	calls := 0
+	err = store.RetrieveImages(context.Background(), ids, 2, func(ImageResult) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Errorf("expected the batch stopped after one call, got %d calls (%v)", calls, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := store.RetrieveImages(ctx, ids, 0, func(ImageResult) error { return nil }); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestExportImagesTar(t *testing.T) {
+	store := newTagsTestStore(t, "a", "team/b")
+
+	var buf bytes.Buffer
+	if err := store.ExportImagesTar(context.Background(), &buf, []string{"team/b", "missing", "a"}, 0); err != nil {
+		t.Fatalf("failed to export: %v", err)
+	}
+
+	files := readTar(t, buf.Bytes())
+	if len(files) != 3 {
+		t.Fatalf("expected 2 images and the error list, got %d files", len(files))
+	}
+	for _, id := range []string{"a", "team/b"} {
+		if !bytes.Equal(files["images/"+id+".png"], mustRetrieve(t, store, id)) {
+			t.Errorf("exported image %s differs from retrieved image", id)
+		}
+	}
+
+	var failed map[string]string
+	if err := json.Unmarshal(files[exportErrorsFile], &failed); err != nil {
+		t.Fatalf("failed to decode the error list: %v", err)
+	}
+	if len(failed) != 1 || failed["missing"] == "" {
+		t.Errorf("expected only the unknown image listed, got %v", failed)
+	}
+}
@@ -0,0 +1,801 @@
+package imagestore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// Defaults for the blob options of Config
+const (
+	DefaultBlobFileBytes    = 256 << 20
+	DefaultBlobGarbageRatio = 0.5
+)
+
+// blobPointerID marks a tile value whose encoding lives in a blob file. Like
+// the cold tier stub it is reserved from codec IDs.
+const blobPointerID = 0xB0
+
+// blobPointerSize is the length of an encoded blobPointer: the marker, the
+// head byte, the file number, offset, length and checksum
+const blobPointerSize = 1 + 1 + 4 + 8 + 4 + 4
+
+// blobDir is the directory under Config.DatabasePath holding blob files
+const blobDir = "blobs"
+
+const blobFileSuffix = ".blob"
+
+// errBlobMissing is returned when a pointer names a blob file that has been
+// removed, as happens to readers of a snapshot taken before a collection
+var errBlobMissing = errors.New("blob file missing")
+
+var blobChecksumTable = crc32.MakeTable(crc32.Castagnoli)
+
+// BlobStats describes the blob files holding large tile encodings
+type BlobStats struct {
+	Files        int   // Blob files on disk
+	Bytes        int64 // Size of the blob files
+	Tiles        int   // Tiles whose encoding is held in a blob file
+	LiveBytes    int64 // Bytes of blob files still pointed to by a tile
+	GarbageBytes int64 // Bytes of blob files no tile points to, reclaimed by CollectBlobs
+	MappedFiles  int   // Sealed files read through a memory map
+}
+
+// BlobReport describes a blob collection
+type BlobReport struct {
+	Files          int   // Blob files examined, excluding the one being appended to
+	Removed        int   // Files deleted, after moving their live tiles
+	TilesMoved     int   // Live tiles copied out of removed files
+	ReclaimedBytes int64 // Bytes of removed files that were garbage
+}
+
+// blobPointer locates a tile encoding in a blob file
+type blobPointer struct {
+	head     byte // First byte of the encoding, naming its codec
+	file     uint32
+	offset   int64
+	length   uint32
+	checksum uint32 // CRC-32C of the encoding
+}
+
+func (p blobPointer) encode() []byte {
+	value := make([]byte, blobPointerSize)
+	value[0] = blobPointerID
+	value[1] = p.head
+	binary.BigEndian.PutUint32(value[2:], p.file)
+	binary.BigEndian.PutUint64(value[6:], uint64(p.offset))
+	binary.BigEndian.PutUint32(value[14:], p.length)
+	binary.BigEndian.PutUint32(value[18:], p.checksum)
+	return value
+}
+
+// decodeBlobPointer parses a stored tile value, reporting whether it is a
+// blob pointer
+func decodeBlobPointer(value []byte) (blobPointer, bool) {
+	if !isBlobPointer(value) {
+		return blobPointer{}, false
+	}
+	return blobPointer{
+		head:     value[1],
+		file:     binary.BigEndian.Uint32(value[2:]),
+		offset:   int64(binary.BigEndian.Uint64(value[6:])),
+		length:   binary.BigEndian.Uint32(value[14:]),
+		checksum: binary.BigEndian.Uint32(value[18:]),
+	}, true
+}
+
+// isBlobPointer reports whether a stored tile value points into a blob file
+func isBlobPointer(value []byte) bool {
+	return len(value) == blobPointerSize && value[0] == blobPointerID
+}
+
+// storedTileSize returns the size of a tile's stored encoding, which for a
+// blob pointer is the length it points to
+func storedTileSize(value []byte) int64 {
+	if p, ok := decodeBlobPointer(value); ok {
+		return int64(p.length)
+	}
+	return int64(len(value))
+}
+
+// blobFiles manages the append-only files holding tile encodings of at
+// least Config.BlobThreshold bytes. Only the newest file is appended to;
+// older files are sealed and change only when CollectBlobs removes them.
+type blobFiles struct {
+	dir string
+
+	mu       sync.Mutex     // Guards appends and the fields below
+	loaded   bool           // Whether nextID has been read from the directory
+	active   *os.File       // File being appended to; nil until the first append
+	activeID uint32         // Number of the active file
+	size     int64          // Bytes in the active file
+	nextID   uint32         // Number of the next file to create
+	pending  map[uint32]int // Appends per file not yet committed or abandoned
+
+	appending atomic.Uint64 // Number of the active file plus one; 0 while there is none
+
+	readMu  sync.RWMutex           // Held for reading while files are read, exclusively while they are unmapped or removed
+	openMu  sync.Mutex             // Guards readers
+	readers map[uint32]*blobReader // Open files by number
+	noMmap  bool                   // Read every file with ReadAt, from Config.DisableBlobMmap
+
+	collect sync.Mutex // Serializes collections, and backups with them
+}
+
+func (b *blobFiles) path(id uint32) string {
+	return filepath.Join(b.dir, fmt.Sprintf("%08d%s", id, blobFileSuffix))
+}
+
+// list returns the size of every blob file by number
+func (b *blobFiles) list() (map[uint32]int64, error) {
+	entries, err := os.ReadDir(b.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[uint32]int64{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[uint32]int64)
+	for _, entry := range entries {
+		stem, ok := strings.CutSuffix(entry.Name(), blobFileSuffix)
+		if !ok || entry.IsDir() {
+			continue
+		}
+		id, err := strconv.ParseUint(stem, 10, 32)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		files[uint32(id)] = info.Size()
+	}
+	return files, nil
+}
+
+// append writes payloads to the active blob file, starting a new file once
+// it reaches limit, and returns where each landed. Files written before a
+// restart are never appended to, so a torn tail can't be built on. Release
+// must be called once the pointers are committed or abandoned.
+func (b *blobFiles) append(payloads [][]byte, limit int64, sync bool) ([]blobPointer, func(), error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.loaded {
+		files, err := b.list()
+		if err != nil {
+			return nil, nil, err
+		}
+		for id := range files {
+			b.nextID = max(b.nextID, id+1)
+		}
+		b.pending = make(map[uint32]int)
+		b.loaded = true
+	}
+
+	// abandon drops this append's pending counts; the caller holds mu
+	used := make(map[uint32]int)
+	abandon := func() {
+		for id, n := range used {
+			if b.pending[id] -= n; b.pending[id] <= 0 {
+				delete(b.pending, id)
+			}
+		}
+	}
+
+	pointers := make([]blobPointer, len(payloads))
+	for i, payload := range payloads {
+		if b.active == nil || (b.size > 0 && b.size+int64(len(payload)) > limit) {
+			if err := b.roll(); err != nil {
+				abandon()
+				return nil, nil, err
+			}
+		}
+		if _, err := b.active.Write(payload); err != nil {
+			// The file's tail is unknown now, so the next append starts
+			// a new one
+			b.active.Close()
+			b.active = nil
+			b.appending.Store(0)
+			abandon()
+			return nil, nil, fmt.Errorf("failed to write blob file: %w", err)
+		}
+		pointers[i] = blobPointer{
+			head:     payload[0],
+			file:     b.activeID,
+			offset:   b.size,
+			length:   uint32(len(payload)),
+			checksum: crc32.Checksum(payload, blobChecksumTable),
+		}
+		b.size += int64(len(payload))
+		b.pending[b.activeID]++
+		used[b.activeID]++
+	}
+
+	if sync && b.active != nil {
+		if err := b.active.Sync(); err != nil {
+			abandon()
+			return nil, nil, fmt.Errorf("failed to sync blob file: %w", err)
+		}
+	}
+	release := func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		abandon()
+	}
+	return pointers, release, nil
+}
+
+// roll seals the active file and starts the next one. The caller holds mu.
+func (b *blobFiles) roll() error {
+	if b.active != nil {
+		if err := b.active.Sync(); err != nil {
+			return fmt.Errorf("failed to sync blob file: %w", err)
+		}
+		if err := b.active.Close(); err != nil {
+			return err
+		}
+		b.active = nil
+	}
+
+	if err := os.MkdirAll(b.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create blob directory: %w", err)
+	}
+	f, err := os.OpenFile(b.path(b.nextID), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create blob file: %w", err)
+	}
+	b.active, b.activeID, b.size = f, b.nextID, 0
+	b.appending.Store(uint64(b.nextID) + 1)
+	b.nextID++
+	return nil
+}
+
+// sealed drops from files the active file and any file with uncommitted
+// appends. Every pointer into a file left has already been committed.
+func (b *blobFiles) sealed(files map[uint32]int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.active != nil {
+		delete(files, b.activeID)
+	}
+	for id := range b.pending {
+		delete(files, id)
+	}
+}
+
+// read returns a copy of the encoding a pointer locates, checking its
+// checksum
+func (b *blobFiles) read(p blobPointer) ([]byte, error) {
+	b.readMu.RLock()
+	defer b.readMu.RUnlock()
+
+	payload, mapped, err := b.locate(p)
+	if err != nil {
+		return nil, err
+	}
+	if mapped {
+		payload = append([]byte(nil), payload...)
+	}
+	return payload, nil
+}
+
+// view passes fn the encoding a pointer locates, checking its checksum.
+// From a mapped file the slice points into the mapping, saving a copy; it
+// is only valid until fn returns.
+func (b *blobFiles) view(p blobPointer, fn func(payload []byte) error) error {
+	b.readMu.RLock()
+	defer b.readMu.RUnlock()
+
+	payload, _, err := b.locate(p)
+	if err != nil {
+		return err
+	}
+	return fn(payload)
+}
+
+// locate returns the encoding a pointer locates, sliced from the file's
+// mapping if it has one and otherwise read into a new buffer. The caller
+// holds readMu for reading.
+func (b *blobFiles) locate(p blobPointer) ([]byte, bool, error) {
+	reader, err := b.reader(p.file)
+	if err != nil {
+		return nil, false, err
+	}
+
+	end := p.offset + int64(p.length)
+	mapped := end <= int64(len(reader.data))
+	var payload []byte
+	if mapped {
+		payload = reader.data[p.offset:end]
+	} else {
+		payload = make([]byte, p.length)
+		if _, err := reader.file.ReadAt(payload, p.offset); err != nil {
+			return nil, false, fmt.Errorf("failed to read blob file %d: %w", p.file, err)
+		}
+	}
+	if crc32.Checksum(payload, blobChecksumTable) != p.checksum {
+		return nil, false, fmt.Errorf("blob file %d is corrupt at offset %d", p.file, p.offset)
+	}
+	return payload, mapped, nil
+}
+
+// willNeed hints that the tiles behind the given stored values are about to
+// be read, so the kernel can read in their pages from mapped files
+// together rather than faulting them in one at a time
+func (b *blobFiles) willNeed(values [][]byte) {
+	b.readMu.RLock()
+	defer b.readMu.RUnlock()
+
+	for _, value := range values {
+		p, ok := decodeBlobPointer(value)
+		if !ok {
+			continue
+		}
+		reader, err := b.reader(p.file)
+		if err == nil && p.offset+int64(p.length) <= int64(len(reader.data)) {
+			adviseWillNeed(reader.data, p.offset, int64(p.length))
+		}
+	}
+}
+
+// blobReader is an open blob file
+type blobReader struct {
+	file     *os.File
+	data     []byte // Read-only mapping of the file; nil while it is unmapped
+	mapTried bool   // Whether mapping the sealed file was tried
+}
+
+// reader returns an open blob file, mapping it once it is sealed unless
+// Config.DisableBlobMmap is set. The caller holds readMu for reading.
+func (b *blobFiles) reader(id uint32) (*blobReader, error) {
+	b.openMu.Lock()
+	defer b.openMu.Unlock()
+
+	reader, ok := b.readers[id]
+	if !ok {
+		f, err := os.Open(b.path(id))
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %d", errBlobMissing, id)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if b.readers == nil {
+			b.readers = make(map[uint32]*blobReader)
+		}
+		reader = &blobReader{file: f}
+		b.readers[id] = reader
+	}
+
+	// The active file keeps growing, so it is read with ReadAt until a new
+	// file takes over
+	if !reader.mapTried && !b.noMmap && b.appending.Load() != uint64(id)+1 {
+		reader.mapTried = true
+		if info, err := reader.file.Stat(); err == nil && info.Size() > 0 {
+			reader.data, _ = mmapBlobFile(reader.file, info.Size())
+		}
+	}
+	return reader, nil
+}
+
+// release unmaps and closes an open blob file. The caller holds openMu.
+func (r *blobReader) release() {
+	if r.data != nil {
+		munmapBlobFile(r.data)
+		r.data = nil
+	}
+	r.file.Close()
+}
+
+// mapped returns the number of blob files read through a mapping
+func (b *blobFiles) mapped() int {
+	b.openMu.Lock()
+	defer b.openMu.Unlock()
+
+	n := 0
+	for _, reader := range b.readers {
+		if reader.data != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// remove deletes a sealed blob file once no read is using it
+func (b *blobFiles) remove(id uint32) error {
+	b.readMu.Lock()
+	defer b.readMu.Unlock()
+
+	b.openMu.Lock()
+	if reader, ok := b.readers[id]; ok {
+		reader.release()
+		delete(b.readers, id)
+	}
+	b.openMu.Unlock()
+
+	if err := os.Remove(b.path(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// close syncs and closes the active file and releases every open file. No
+// reads may be running.
+func (b *blobFiles) close() error {
+	b.mu.Lock()
+	var err error
+	if b.active != nil {
+		err = b.active.Sync()
+		if closeErr := b.active.Close(); err == nil {
+			err = closeErr
+		}
+		b.active = nil
+		b.appending.Store(0)
+	}
+	b.mu.Unlock()
+
+	b.readMu.Lock()
+	defer b.readMu.Unlock()
+	b.openMu.Lock()
+	defer b.openMu.Unlock()
+	for id, reader := range b.readers {
+		reader.release()
+		delete(b.readers, id)
+	}
+	return err
+}
+
+// link places a copy of every blob file in dir, hard-linking where the
+// filesystem allows. The caller holds collect, so no file is removed
+// meanwhile; the active file may still grow, but only past what is already
+// committed.
+func (b *blobFiles) link(dir string) error {
+	files, err := b.list()
+	if err != nil || len(files) == 0 {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for id := range files {
+		target := filepath.Join(dir, filepath.Base(b.path(id)))
+		if err := os.Link(b.path(id), target); err == nil {
+			continue
+		}
+		if err := copyFile(b.path(id), target); err != nil {
+			return fmt.Errorf("failed to copy blob file %d: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// copyFile copies src to a new file at dst
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// separateBlobs moves values of at least Config.BlobThreshold bytes to the
+// active blob file, replacing each with a pointer. The appended bytes are
+// synced when commits sync, so it belongs just before the commit writing
+// the values; release must be called once that commit is done or
+// abandoned.
+func (s *PebbleImageStore) separateBlobs(values [][]byte) (func(), error) {
+	threshold := s.config.BlobThreshold
+	if threshold <= 0 {
+		return func() {}, nil
+	}
+
+	var indexes []int
+	var payloads [][]byte
+	for i, value := range values {
+		if len(value) >= threshold {
+			indexes = append(indexes, i)
+			payloads = append(payloads, value)
+		}
+	}
+	if len(payloads) == 0 {
+		return func() {}, nil
+	}
+
+	limit := s.config.BlobFileBytes
+	if limit <= 0 {
+		limit = DefaultBlobFileBytes
+	}
+	pointers, release, err := s.blobs.append(payloads, limit, s.writeOpts.Sync)
+	if err != nil {
+		return nil, err
+	}
+	for i, index := range indexes {
+		values[index] = pointers[i].encode()
+	}
+	return release, nil
+}
+
+// readBlobTile returns the encoding a tile's blob pointer locates. A pointer
+// read from an older snapshot may name a file collected since, in which
+// case the tile's current value is resolved instead; tiles are named by
+// their content, so it holds the same data.
+func (s *PebbleImageStore) readBlobTile(tileID TileID, stored []byte) ([]byte, error) {
+	p, _ := decodeBlobPointer(stored)
+	payload, err := s.blobs.read(p)
+	if !errors.Is(err, errBlobMissing) {
+		return payload, err
+	}
+
+	current, err := s.movedTileValue(tileID, stored, err)
+	if err != nil {
+		return nil, err
+	}
+	return s.resolveTile(tileID, current)
+}
+
+// decodeBlobTile decompresses the tile behind a blob pointer. From a mapped
+// file the encoding goes to the decompressor without being copied.
+func (s *PebbleImageStore) decodeBlobTile(tileID TileID, stored []byte) ([]byte, error) {
+	p, _ := decodeBlobPointer(stored)
+	var data []byte
+	err := s.blobs.view(p, func(payload []byte) error {
+		var err error
+		if data, err = s.decompressTileData(payload); err != nil {
+			return fmt.Errorf("failed to decompress tile %s: %w", tileID, err)
+		}
+		return nil
+	})
+	if !errors.Is(err, errBlobMissing) {
+		return data, err
+	}
+
+	current, err := s.movedTileValue(tileID, stored, err)
+	if err != nil {
+		return nil, err
+	}
+	return s.decodeTile(tileID, current)
+}
+
+// movedTileValue returns a tile's current value after reading its stored
+// value failed with missing, as for a pointer into a collected blob file
+func (s *PebbleImageStore) movedTileValue(tileID TileID, stored []byte, missing error) ([]byte, error) {
+	value, closer, err := s.db.Get(tileKey(tileID))
+	if err != nil {
+		return nil, fmt.Errorf("tile %s: %w", tileID, missing)
+	}
+	current := append([]byte(nil), value...)
+	closer.Close()
+	if bytes.Equal(current, stored) {
+		return nil, fmt.Errorf("tile %s: %w", tileID, missing)
+	}
+	return current, nil
+}
+
+// CollectBlobs reclaims blob files whose share of bytes no tile points to
+// has reached Config.BlobGarbageRatio. Tiles still pointing into such a file
+// are copied out, to the active blob file or back into the database if they
+// are now under Config.BlobThreshold, and the file is deleted. Blob bytes
+// become garbage when PurgeOrphanedTiles deletes the last tile pointing to
+// them, so collection follows the same references as tile garbage
+// collection. Like it, copies are made in chunks under the garbage
+// collection lock, pacing between them, and Jobs reports the progress.
+func (s *PebbleImageStore) CollectBlobs() (*BlobReport, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+
+	if s.config.ReadOnly {
+		return nil, ErrReadOnly
+	}
+
+	s.blobs.collect.Lock()
+	defer s.blobs.collect.Unlock()
+
+	job := s.startJob(JobBlobCollection, 0)
+	report, err := s.collectBlobs(job)
+	job.finish(err)
+	return report, err
+}
+
+// blobMove is a tile to copy out of a blob file being removed
+type blobMove struct {
+	key     []byte
+	pointer []byte
+}
+
+func (s *PebbleImageStore) collectBlobs(job *maintenanceJob) (*BlobReport, error) {
+	files, err := s.blobs.list()
+	if err != nil {
+		return nil, err
+	}
+	// Sealed before the snapshot, so it holds every pointer into them
+	s.blobs.sealed(files)
+	report := &BlobReport{Files: len(files)}
+	if len(files) == 0 {
+		return report, nil
+	}
+
+	snapshot := s.db.NewSnapshot()
+	defer snapshot.Close()
+
+	live := make(map[uint32]int64)
+	err = scanBlobPointers(snapshot, func(key []byte, p blobPointer) {
+		if _, ok := files[p.file]; ok {
+			live[p.file] += int64(p.length)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ratio := s.config.BlobGarbageRatio
+	if ratio <= 0 {
+		ratio = DefaultBlobGarbageRatio
+	}
+	selected := make(map[uint32]bool)
+	var collect []uint32
+	for id, size := range files {
+		if size == 0 || float64(size-live[id]) >= ratio*float64(size) {
+			selected[id] = true
+			collect = append(collect, id)
+		}
+	}
+	if len(collect) == 0 {
+		return report, nil
+	}
+	sort.Slice(collect, func(i, j int) bool { return collect[i] < collect[j] })
+
+	moves := make(map[uint32][]blobMove)
+	var total int64
+	err = scanBlobPointers(snapshot, func(key []byte, p blobPointer) {
+		if selected[p.file] {
+			moves[p.file] = append(moves[p.file], blobMove{append([]byte(nil), key...), p.encode()})
+			total++
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	job.setTotal(total)
+
+	for _, id := range collect {
+		for start := 0; start < len(moves[id]); start += maintenanceChunk {
+			chunk := moves[id][start:min(start+maintenanceChunk, len(moves[id]))]
+			moved, bytes, err := s.moveBlobChunk(chunk)
+			if err != nil {
+				return report, err
+			}
+			report.TilesMoved += moved
+			job.advance(int64(len(chunk)), int64(moved), bytes)
+			job.pace(bytes)
+		}
+
+		if err := s.blobs.remove(id); err != nil {
+			return report, fmt.Errorf("failed to remove blob file %d: %w", id, err)
+		}
+		report.Removed++
+		report.ReclaimedBytes += files[id] - live[id]
+	}
+	return report, nil
+}
+
+// moveBlobChunk copies tiles out of a blob file, skipping any whose value
+// changed since the collection's snapshot, and returns how many were copied
+// and their size
+func (s *PebbleImageStore) moveBlobChunk(chunk []blobMove) (int, int64, error) {
+	payloads := make([][]byte, len(chunk))
+	for i, move := range chunk {
+		p, _ := decodeBlobPointer(move.pointer)
+		payload, err := s.blobs.read(p)
+		if err != nil {
+			return 0, 0, err
+		}
+		payloads[i] = payload
+	}
+
+	s.gcMu.Lock()
+	defer s.gcMu.Unlock()
+
+	var keys, values [][]byte
+	var bytes int64
+	for i, move := range chunk {
+		value, closer, err := s.db.Get(move.key)
+		if errors.Is(err, pebble.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+		unchanged := string(value) == string(move.pointer)
+		closer.Close()
+		if !unchanged {
+			continue
+		}
+		keys = append(keys, move.key)
+		values = append(values, payloads[i])
+		bytes += int64(len(payloads[i]))
+	}
+	if len(keys) == 0 {
+		return 0, 0, nil
+	}
+
+	release, err := s.separateBlobs(values)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer release()
+
+	batch := s.db.NewBatch()
+	defer batch.Close()
+	for i, key := range keys {
+		if err := batch.Set(key, values[i], nil); err != nil {
+			return 0, 0, err
+		}
+	}
+	if err := batch.Commit(s.writeOpts); err != nil {
+		return 0, 0, fmt.Errorf("failed to commit blob collection: %w", err)
+	}
+	return len(keys), bytes, nil
+}
+
+// scanBlobPointers passes every tile's blob pointer to fn
+func scanBlobPointers(reader pebble.Reader, fn func(key []byte, p blobPointer)) error {
+	prefix := makePrefixKey(tilesBucket)
+	iter, err := reader.NewIter(&pebble.IterOptions{
+		LowerBound: prefix,
+		UpperBound: prefixUpperBound(prefix),
+	})
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+
+	for iter.First(); iter.Valid(); iter.Next() {
+		if p, ok := decodeBlobPointer(iter.Value()); ok {
+			fn(iter.Key(), p)
+		}
+	}
+	return iter.Error()
+}
+
+// blobStats describes the blob files given the bytes and tiles pointing
+// into them, as counted by the tile scan of GetStorageStats
+func (s *PebbleImageStore) blobStats(tiles int, liveBytes int64) BlobStats {
+	stats := BlobStats{Tiles: tiles, LiveBytes: liveBytes, MappedFiles: s.blobs.mapped()}
+	files, err := s.blobs.list()
+	if err != nil {
+		return stats
+	}
+	stats.Files = len(files)
+	for _, size := range files {
+		stats.Bytes += size
+	}
+	stats.GarbageBytes = max(stats.Bytes-liveBytes, 0)
+	return stats
+}
@@ -0,0 +1,173 @@
+package imagestore
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"path/filepath"
+	"testing"
+)
+
+func newBlobTestStore(t *testing.T, path string) *PebbleImageStore {
+	t.Helper()
+
+	config := DefaultConfig()
+	config.DatabasePath = path
+	config.TileSize = 4
+	config.TrashRetention = 0
+	config.BlobThreshold = 1
+	config.BlobGCInterval = 0
+
+	store, err := NewPebbleImageStore(config)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	return store
+}
+
+func TestBlobSeparation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	store := newBlobTestStore(t, path)
+
+	kept, _ := encodeImageToPNG(createTestImage(8, 8))
+	other := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			other.Set(x, y, color.RGBA{uint8(x * 30), 7, uint8(y * 30), 255})
+		}
+	}
+	dropped, _ := encodeImageToPNG(other)
+	for id, data := range map[string][]byte{"kept": kept, "dropped": dropped} {
+		if err := store.StoreImage(id, data); err != nil {
+			t.Fatalf("failed to store %s: %v", id, err)
+		}
+	}
+	expected := mustRetrieve(t, store, "kept")
+
+	stats := store.GetStorageStats()
+	if stats.Blobs.Tiles != stats.UniqueTiles || stats.Blobs.Files != 1 || stats.Blobs.GarbageBytes != 0 {
+		t.Fatalf("expected every tile in one blob file, got %+v", stats.Blobs)
+	}
+	storedImage, _ := store.GetManifest("kept")
+	info, err := store.InspectTile(storedImage.TileRefs[0].TileID)
+	if err != nil || !info.Blob || info.Codec != CodecZstd || int64(info.StoredBytes) > stats.Blobs.LiveBytes {
+		t.Errorf("expected inspection to describe the blob tile, got %+v (%v)", info, err)
+	}
+
+	// The file being appended to is never collected
+	if report, err := store.CollectBlobs(); err != nil || report.Files != 0 {
+		t.Fatalf("expected the active file skipped, got %+v (%v)", report, err)
+	}
+
+	// Reopening seals the file; dropping an image leaves part of it garbage
+	store.Close()
+	store = newBlobTestStore(t, path)
+	defer store.Close()
+	store.config.BlobGarbageRatio = 0.1
+	if err := store.DeleteImage("dropped"); err != nil {
+		t.Fatalf("failed to delete image: %v", err)
+	}
+	if _, err := store.PurgeOrphanedTiles(); err != nil {
+		t.Fatalf("failed to purge orphaned tiles: %v", err)
+	}
+	before := store.GetStorageStats().Blobs
+	if before.GarbageBytes == 0 {
+		t.Fatalf("expected garbage after the purge, got %+v", before)
+	}
+
+	snapshot := store.db.NewSnapshot()
+	defer snapshot.Close()
+	tileID := storedImage.TileRefs[0].TileID
+	value, closer, err := snapshot.Get(tileKey(tileID))
+	if err != nil {
+		t.Fatalf("failed to read tile: %v", err)
+	}
+	stale := append([]byte(nil), value...)
+	closer.Close()
+
+	report, err := store.CollectBlobs()
+	if err != nil {
+		t.Fatalf("failed to collect blobs: %v", err)
+	}
+	if report.Removed != 1 || report.TilesMoved != before.Tiles || report.ReclaimedBytes != before.GarbageBytes {
+		t.Errorf("unexpected report %+v for %+v", report, before)
+	}
+	after := store.GetStorageStats().Blobs
+	if after.Files != 1 || after.GarbageBytes != 0 || after.Tiles != before.Tiles {
+		t.Errorf("expected only the live tiles kept, got %+v", after)
+	}
+	if !bytes.Equal(mustRetrieve(t, store, "kept"), expected) {
+		t.Error("image changed after its tiles were moved")
+	}
+
+	// A pointer read before the collection still resolves
+	if _, err := store.resolveTile(tileID, stale); err != nil {
+		t.Errorf("failed to resolve a stale pointer: %v", err)
+	}
+	if jobs := store.Jobs(); len(jobs) == 0 {
+		t.Error("expected the collection reported as a job")
+	}
+
+	// Raw exports carry the encodings, not the pointers
+	var archive bytes.Buffer
+	if err := store.ExportTar(&archive, ExportRaw); err != nil {
+		t.Fatalf("failed to export: %v", err)
+	}
+	imported := newBlobTestStore(t, filepath.Join(t.TempDir(), "imported.db"))
+	defer imported.Close()
+	imported.config.BlobThreshold = 0
+	if _, err := imported.ImportArchive(&archive); err != nil {
+		t.Fatalf("failed to import: %v", err)
+	}
+	if stats := imported.GetStorageStats(); stats.Blobs.Tiles != 0 {
+		t.Errorf("expected tiles imported inline, got %+v", stats.Blobs)
+	}
+	if !bytes.Equal(mustRetrieve(t, imported, "kept"), expected) {
+		t.Error("imported image differs from the original")
+	}
+}
+
+func TestBlobPointer(t *testing.T) {
+	p := blobPointer{head: 0x01, file: 7, offset: 1 << 33, length: 150000, checksum: 0xdeadbeef}
+	value := p.encode()
+	decoded, ok := decodeBlobPointer(value)
+	if !ok || decoded != p {
+		t.Errorf("expected %+v back, got %+v", p, decoded)
+	}
+	if storedTileSize(value) != 150000 || storedTileSize([]byte{1, 2, 3}) != 3 {
+		t.Error("expected the size of the stored encoding")
+	}
+	if _, ok := decodeBlobPointer(append([]byte{0x01}, value[1:]...)); ok {
+		t.Error("expected a codec-prefixed value not to decode as a pointer")
+	}
+}
+
+func TestBlobMmap(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	store := newBlobTestStore(t, path)
+	imageData, _ := encodeImageToPNG(createTestImage(8, 8))
+	if err := store.StoreImage("a", imageData); err != nil {
+		t.Fatalf("failed to store image: %v", err)
+	}
+	expected := mustRetrieve(t, store, "a")
+	if mapped := store.GetStorageStats().Blobs.MappedFiles; mapped != 0 {
+		t.Errorf("expected the active file read without a mapping, got %d mapped", mapped)
+	}
+	store.Close()
+
+	for _, disabled := range []bool{false, true} {
+		store := newBlobTestStore(t, path)
+		store.blobs.noMmap = disabled
+		if !bytes.Equal(mustRetrieve(t, store, "a"), expected) {
+			t.Errorf("image read with mmap disabled=%v differs from the original", disabled)
+		}
+		want := 0
+		if blobMmapSupported && !disabled {
+			want = 1
+		}
+		if mapped := store.GetStorageStats().Blobs.MappedFiles; mapped != want {
+			t.Errorf("expected %d mapped files with mmap disabled=%v, got %d", want, disabled, mapped)
+		}
+		store.Close()
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !linux && !darwin && !freebsd
+
+package imagestore
+
+import "os"
+
+// blobMmapSupported reports whether sealed blob files are memory-mapped
+const blobMmapSupported = false
+
+// mmapBlobFile can't map files on this platform, so blob files are always
+// read with ReadAt
+func mmapBlobFile(f *os.File, size int64) ([]byte, bool) {
+	return nil, false
+}
+
+func munmapBlobFile(data []byte) {}
+
+func adviseWillNeed(data []byte, offset, length int64) {}
@@ -0,0 +1,36 @@
+//go:build linux || darwin || freebsd
+
+package imagestore
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// blobMmapSupported reports whether sealed blob files are memory-mapped
+const blobMmapSupported = true
+
+// mmapBlobFile maps size bytes of a sealed blob file read-only. Tile reads
+// jump around the file, so readahead is turned off for the mapping.
+func mmapBlobFile(f *os.File, size int64) ([]byte, bool) {
+	data, err := unix.Mmap(int(f.Fd()), 0, int(size), unix.PROT_READ, unix.MAP_SHARED)
+	if err != nil {
+		return nil, false
+	}
+	unix.Madvise(data, unix.MADV_RANDOM)
+	return data, true
+}
+
+// munmapBlobFile releases a mapping made by mmapBlobFile
+func munmapBlobFile(data []byte) {
+	unix.Munmap(data)
+}
+
+// adviseWillNeed asks the kernel to start reading part of a mapping in
+// before it is touched
+func adviseWillNeed(data []byte, offset, length int64) {
+	page := int64(os.Getpagesize())
+	start := offset / page * page
+	unix.Madvise(data[start:offset+length], unix.MADV_WILLNEED)
+}
@@ -0,0 +1,219 @@
+package imagestore
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iter"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// changesBucket holds the change feed, keyed by big-endian sequence number
+var changesBucket = []byte("changes")
+
+// ChangeType is what happened to an image
+type ChangeType string
+
+const (
+	ChangeCreated ChangeType = "created" // Stored under a new ID, or restored from the trash
+	ChangeUpdated ChangeType = "updated" // Replaced, or its tags, metadata or expiry changed
+	ChangeDeleted ChangeType = "deleted" // Moved to the trash or deleted outright
+)
+
+// DefaultChangeLogSize is how many changes are kept when Config.ChangeLogSize
+// is zero
+const DefaultChangeLogSize = 100000
+
+// changeTrimInterval is how many changes are written between trims of the
+// log, so that trimming costs one range tombstone per interval
+const changeTrimInterval = 1024
+
+// ErrChangesTruncated is returned when a consumer asks for changes older
+// than the log still holds. It has missed events and should rescan the
+// store, for example with Images, before watching from LastChange.
+var ErrChangesTruncated = errors.New("change log truncated")
+
+// Change is one entry in the change feed
+type Change struct {
+	Seq  uint64     `json:"seq"`
+	Type ChangeType `json:"type"`
+	ID   string     `json:"id"`
+	Time time.Time  `json:"time"`
+}
+
+// changeKey returns the key of a change
+func changeKey(seq uint64) []byte {
+	return binary.BigEndian.AppendUint64(makePrefixKey(changesBucket), seq)
+}
+
+// loadChangeSeq reads the sequence number of the newest change
+func (s *PebbleImageStore) loadChangeSeq() error {
+	prefix := makePrefixKey(changesBucket)
+	it, err := s.db.NewIter(&pebble.IterOptions{
+		LowerBound: prefix,
+		UpperBound: prefixUpperBound(prefix),
+	})
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+
+	if it.Last() {
+		s.changeSeq = binary.BigEndian.Uint64(it.Key()[len(prefix):])
+	}
+	s.changeNotify = make(chan struct{})
+	return it.Error()
+}
+
+// commitChanges records changes in a batch, in the change feed and each
+// image's audit log, and commits it. Sequence numbers are assigned and
+// committed under one lock so that they become visible in order; a consumer
+// never sees a change appear behind its position.
+func (s *PebbleImageStore) commitChanges(batch *pebble.Batch, action AuditAction, ids ...string) error {
+	return s.commitChangesBy(batch, action, "", ids...)
+}
+
+// commitChangesBy is commitChanges, recording who made the changes
+func (s *PebbleImageStore) commitChangesBy(batch *pebble.Batch, action AuditAction, actor string, ids ...string) error {
+	// Deletions free space, so only they go through once the disk is full
+	if action != AuditDeleted {
+		if err := s.checkDiskSpace(); err != nil {
+			return err
+		}
+	}
+
+	s.changeMu.Lock()
+	defer s.changeMu.Unlock()
+
+	now := time.Now().UTC()
+	seq := s.changeSeq
+	for _, id := range ids {
+		seq++
+		value, err := json.Marshal(Change{Seq: seq, Type: action.changeType(), ID: id, Time: now})
+		if err != nil {
+			return err
+		}
+		if err := batch.Set(changeKey(seq), value, pebble.Sync); err != nil {
+			return fmt.Errorf("failed to record change: %w", err)
+		}
+		if err := recordAudit(batch, id, AuditEntry{Seq: seq, Time: now, Action: action, Actor: actor}); err != nil {
+			return err
+		}
+	}
+
+	// Drop changes beyond the log size every so often
+	size := uint64(s.config.ChangeLogSize)
+	if size == 0 {
+		size = DefaultChangeLogSize
+	}
+	if seq/changeTrimInterval != s.changeSeq/changeTrimInterval && seq > size {
+		if err := batch.DeleteRange(changeKey(0), changeKey(seq-size+1), pebble.Sync); err != nil {
+			return fmt.Errorf("failed to trim change log: %w", err)
+		}
+	}
+
+	if err := batch.Commit(s.writeOpts); err != nil {
+		return err
+	}
+	s.imageCache.invalidate(ids...)
+
+	if seq != s.changeSeq {
+		s.changeSeq = seq
+		close(s.changeNotify)
+		s.changeNotify = make(chan struct{})
+	}
+	return nil
+}
+
+// LastChange returns the sequence number of the newest change, 0 if there
+// are none. Watching from it yields only changes made afterwards.
+func (s *PebbleImageStore) LastChange() uint64 {
+	s.changeMu.Lock()
+	defer s.changeMu.Unlock()
+	return s.changeSeq
+}
+
+// Changes returns up to limit changes after sequence number since, oldest
+// first, without waiting for new ones. It fails with ErrChangesTruncated if
+// changes after since have already been dropped from the log.
+func (s *PebbleImageStore) Changes(since uint64, limit int) ([]Change, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+
+	prefix := makePrefixKey(changesBucket)
+	it, err := s.db.NewIter(&pebble.IterOptions{
+		LowerBound: prefix,
+		UpperBound: prefixUpperBound(prefix),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+
+	// The oldest retained change must directly follow since, unless there
+	// is nothing newer than since at all
+	if it.First() && binary.BigEndian.Uint64(it.Key()[len(prefix):]) > since+1 {
+		return nil, ErrChangesTruncated
+	}
+
+	var changes []Change
+	for it.SeekGE(changeKey(since + 1)); it.Valid() && len(changes) < limit; it.Next() {
+		var change Change
+		if err := json.Unmarshal(it.Value(), &change); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal change: %w", err)
+		}
+		changes = append(changes, change)
+	}
+	return changes, it.Error()
+}
+
+// Watch yields every change after sequence number since, then waits for
+// new ones until ctx is cancelled or the store is closed. Iteration stops
+// at the first error, which is yielded with a zero Change; ctx's error is
+// yielded on cancellation.
+func (s *PebbleImageStore) Watch(ctx context.Context, since uint64) iter.Seq2[Change, error] {
+	return func(yield func(Change, error) bool) {
+		for {
+			if err := ctx.Err(); err != nil {
+				yield(Change{}, err)
+				return
+			}
+
+			// Take the notification channel before reading so a change
+			// committed in between still wakes us
+			s.changeMu.Lock()
+			notify := s.changeNotify
+			s.changeMu.Unlock()
+
+			changes, err := s.Changes(since, 1000)
+			if err != nil {
+				yield(Change{}, err)
+				return
+			}
+			for _, change := range changes {
+				if !yield(change, nil) {
+					return
+				}
+				since = change.Seq
+			}
+			if len(changes) > 0 {
+				continue
+			}
+
+			select {
+			case <-notify:
+			case <-s.stopJobs:
+				return
+			case <-ctx.Done():
+				yield(Change{}, ctx.Err())
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package imagestore
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestChangeFeed(t *testing.T) {
+	store := newTagsTestStore(t, "a")
+	imageData, err := encodeImageToPNG(createTestImage(8, 8))
+	if err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+
+	if err := store.StoreImage("a", imageData); err != nil {
+		t.Fatalf("failed to replace image: %v", err)
+	}
+	if err := store.AddTags("a", "red"); err != nil {
+		t.Fatalf("failed to tag image: %v", err)
+	}
+	if err := store.DeleteImage("a"); err != nil {
+		t.Fatalf("failed to delete image: %v", err)
+	}
+	if err := store.UndeleteImage("a"); err != nil {
+		t.Fatalf("failed to restore image: %v", err)
+	}
+
+	changes, err := store.Changes(0, 100)
+	if err != nil {
+		t.Fatalf("failed to read changes: %v", err)
+	}
+	expected := []ChangeType{ChangeCreated, ChangeUpdated, ChangeUpdated, ChangeDeleted, ChangeCreated}
+	if len(changes) != len(expected) {
+		t.Fatalf("expected %d changes, got %+v", len(expected), changes)
+	}
+	for i, change := range changes {
+		if change.Seq != uint64(i+1) || change.Type != expected[i] || change.ID != "a" {
+			t.Errorf("change %d: expected %d %s a, got %+v", i, i+1, expected[i], change)
+		}
+	}
+	if store.LastChange() != 5 {
+		t.Errorf("expected last change 5, got %d", store.LastChange())
+	}
+
+	changes, err = store.Changes(3, 1)
+	if err != nil || len(changes) != 1 || changes[0].Seq != 4 {
+		t.Errorf("expected change 4 alone, got %+v, %v", changes, err)
+	}
+}
+
+func TestWatchWaitsForChanges(t *testing.T) {
+	store := newTagsTestStore(t, "a")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		store.SetMetadata("a", map[string]string{"k": "v"})
+		store.DeleteImage("a")
+	}()
+
+	var seen []Change
+	for change, err := range store.Watch(ctx, store.LastChange()) {
+		if err != nil {
+			t.Fatalf("watch failed: %v", err)
+		}
+		seen = append(seen, change)
+		if len(seen) == 2 {
+			break
+		}
+	}
+	if seen[0].Type != ChangeUpdated || seen[1].Type != ChangeDeleted {
+		t.Errorf("expected an update then a delete, got %+v", seen)
+	}
+
+	// Cancellation ends a watch with no pending changes
+	cancelled, cancelNow := context.WithCancel(context.Background())
+	cancelNow()
+	for _, err := range store.Watch(cancelled, store.LastChange()) {
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected cancellation, got %v", err)
+		}
+	}
+}
+
+func TestChangeLogTrimAndReopen(t *testing.T) {
+	config := DefaultConfig()
+	config.DatabasePath = filepath.Join(t.TempDir(), "test.db")
+	config.TileSize = 4
+	config.ChangeLogSize = 10
+
+	store, err := NewPebbleImageStore(config)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	imageData, err := encodeImageToPNG(createTestImage(8, 8))
+	if err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	if err := store.StoreImage("a", imageData); err != nil {
+		t.Fatalf("failed to store image: %v", err)
+	}
+	for i := 0; i < changeTrimInterval; i++ {
+		if err := store.SetMetadata("a", map[string]string{"n": "x"}); err != nil {
+			t.Fatalf("failed to set metadata: %v", err)
+		}
+	}
+	last := store.LastChange()
+	store.Close()
+
+	store, err = NewPebbleImageStore(config)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+
+	if store.LastChange() != last {
+		t.Errorf("expected last change %d after reopening, got %d", last, store.LastChange())
+	}
+	if _, err := store.Changes(0, 10); !errors.Is(err, ErrChangesTruncated) {
+		t.Errorf("expected ErrChangesTruncated for trimmed changes, got %v", err)
+	}
+	changes, err := store.Changes(last-10, 100)
+	if err != nil || len(changes) != 10 {
+		t.Errorf("expected the 10 retained changes, got %d, %v", len(changes), err)
+	}
+
+	if err := store.DeleteImage("a"); err != nil {
+		t.Fatalf("failed to delete image: %v", err)
+	}
+	changes, err = store.Changes(last, 10)
+	if err != nil || len(changes) != 1 || changes[0].Seq != last+1 {
+		t.Errorf("expected numbering to continue at %d, got %+v, %v", last+1, changes, err)
+	}
+}
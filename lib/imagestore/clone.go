@@ -0,0 +1,115 @@
+package imagestore
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// CloneImage stores a copy of a live image under a new ID. The copy shares
+// every tile with the source, so only a manifest is written, and it keeps the
+// source's metadata and tags but not its expiration: a clone can pin content
+// the source's expiry or retention would otherwise delete. A clone into
+// another tile pool copies the tiles instead. Cloning onto an existing image
+// fails with an "already exists" error.
+func (s *PebbleImageStore) CloneImage(srcID, dstID string) error {
+	if err := s.enter(); err != nil {
+		return err
+	}
+	defer s.leave()
+
+	if srcID == dstID {
+		return fmt.Errorf("cannot clone image %s onto itself", srcID)
+	}
+
+	if err := s.acquireWrite(); err != nil {
+		return err
+	}
+	defer s.releaseWrite()
+
+	// Hold off garbage collection so the shared tiles stay present
+	s.gcMu.RLock()
+	defer s.gcMu.RUnlock()
+
+	snapshot := s.db.NewSnapshot()
+	defer snapshot.Close()
+
+	value, closer, err := snapshot.Get(makeKey(imagesBucket, srcID))
+	if err != nil {
+		return fmt.Errorf("image not found: %s", srcID)
+	}
+	var source StoredImage
+	err = decodeManifest(value, &source)
+	closer.Close()
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal image: %w", err)
+	}
+
+	if _, closer, err := snapshot.Get(makeKey(imagesBucket, dstID)); err == nil {
+		closer.Close()
+		return fmt.Errorf("image already exists: %s", dstID)
+	}
+
+	plan := &storePlan{
+		image: &StoredImage{
+			ID:            dstID,
+			Width:         source.Width,
+			Height:        source.Height,
+			TileRefs:      make([]TileRef, len(source.TileRefs)),
+			Metadata:      maps.Clone(source.Metadata),
+			OriginalBytes: source.OriginalBytes,
+			Tags:          slices.Clone(source.Tags),
+			Source:        source.Source,
+			StoredAt:      storedNow(),
+			Background:    source.Background,
+			Redactions:    slices.Clone(source.Redactions),
+			GridOffsetY:   source.GridOffsetY,
+			StripHeight:   source.StripHeight,
+			TileSize:      source.TileSize,
+		},
+		dedupMatches: len(source.TileRefs),
+	}
+	if plan.image.Metadata == nil {
+		plan.image.Metadata = make(map[string]string)
+	}
+	if s.tilePool(srcID) != s.tilePool(dstID) {
+		if err := s.planPoolCopy(plan, snapshot, source.TileRefs); err != nil {
+			return err
+		}
+		return s.commitPlan(plan)
+	}
+	for i, tileRef := range source.TileRefs {
+		tileRef.StorageType = StorageDuplicate
+		plan.image.TileRefs[i] = tileRef
+	}
+
+	return s.commitPlan(plan)
+}
+
+// planPoolCopy plans tile references to copies of the given tiles in the
+// pool of the plan's image, planning each as if it were uploaded anew
+func (s *PebbleImageStore) planPoolCopy(plan *storePlan, snapshot *pebble.Snapshot, tileRefs []TileRef) error {
+	stored, err := s.getTilesFrom(context.Background(), snapshot, tileRefs)
+	if err != nil {
+		return err
+	}
+
+	copied := make([]TileRef, len(tileRefs))
+	tiles := make(map[TileID]Tile, len(stored))
+	for i, tileRef := range tileRefs {
+		data := stored[tileRef.TileID]
+		if !tileRef.Transform.IsIdentity() {
+			data = tileRef.Transform.Apply(data)
+		}
+		hash := ComputeTileHash(data)
+		tileID := GenerateTileID(hash)
+		tiles[tileID] = Tile{ID: tileID, Hash: hash, Data: data}
+		copied[i] = TileRef{X: tileRef.X, Y: tileRef.Y, TileID: tileID}
+	}
+
+	plan.dedupMatches = 0
+	return s.planTiles(context.Background(), plan, snapshot, copied, tiles)
+}
@@ -0,0 +1,54 @@
+package imagestore
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCloneImage(t *testing.T) {
+	store := newTagsTestStore(t, "source", "existing")
+	store.AddTags("source", "nightly")
+	store.SetMetadata("source", map[string]string{"commit": "3f9a2c1d"})
+	expiresAt := time.Now().Add(time.Hour)
+	store.SetExpiration("source", &expiresAt)
+
+	before := store.GetStorageStats()
+	if err := store.CloneImage("source", "copy"); err != nil {
+		t.Fatalf("failed to clone image: %v", err)
+	}
+
+	after := store.GetStorageStats()
+	if after.UniqueTiles != before.UniqueTiles || after.DirectTiles != before.DirectTiles {
+		t.Errorf("expected the clone to share every tile, got %+v", after)
+	}
+
+	original, _ := store.RetrieveImage("source")
+	cloned, err := store.RetrieveImage("copy")
+	if err != nil || !bytes.Equal(original, cloned) {
+		t.Errorf("expected the clone to match the source (%v)", err)
+	}
+
+	clone, _ := store.GetManifest("copy")
+	if clone.Metadata["commit"] != "3f9a2c1d" || len(clone.Tags) != 1 || clone.ExpiresAt != nil || clone.StoredAt == nil {
+		t.Errorf("expected metadata and tags without expiry, got %+v", clone)
+	}
+	if tagged, _ := store.ListByTag("nightly"); len(tagged) != 2 {
+		t.Errorf("expected the clone to be indexed by tag, got %v", tagged)
+	}
+
+	// Deleting the source leaves the clone intact
+	store.deleteImagePermanently("source")
+	store.CollectGarbage()
+	if _, err := store.RetrieveImage("copy"); err != nil {
+		t.Errorf("clone lost tiles with its source: %v", err)
+	}
+
+	if err := store.CloneImage("copy", "existing"); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("expected already exists, got %v", err)
+	}
+	if err := store.CloneImage("missing", "other"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("expected not found, got %v", err)
+	}
+}
@@ -0,0 +1,48 @@
+package imagestore
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrStoreClosed is returned by calls made once Close has begun
+var ErrStoreClosed = errors.New("image store is closed")
+
+// closeState counts the calls in flight so Close can wait for them before
+// closing the database underneath them
+type closeState struct {
+	mu       sync.Mutex
+	closed   bool
+	inflight sync.WaitGroup
+}
+
+// enter admits a call, failing with ErrStoreClosed once Close has begun.
+// Every call that touches the database enters first and leaves when done.
+// A call made from within another enters again, so it fails cleanly
+// instead of running against a closing database.
+func (s *PebbleImageStore) enter() error {
+	s.closing.mu.Lock()
+	defer s.closing.mu.Unlock()
+	if s.closing.closed {
+		return ErrStoreClosed
+	}
+	s.closing.inflight.Add(1)
+	return nil
+}
+
+// leave finishes a call admitted by enter
+func (s *PebbleImageStore) leave() {
+	s.closing.inflight.Done()
+}
+
+// beginClose refuses further calls, reporting false if Close had already
+// begun
+func (s *PebbleImageStore) beginClose() bool {
+	s.closing.mu.Lock()
+	defer s.closing.mu.Unlock()
+	if s.closing.closed {
+		return false
+	}
+	s.closing.closed = true
+	return true
+}
@@ -0,0 +1,130 @@
+package imagestore
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCloseRefusesLaterCalls(t *testing.T) {
+	store := newTagsTestStore(t, "a")
+	if err := store.Close(); err != nil {
+		t.Fatalf("failed to close store: %v", err)
+	}
+
+	if err := store.Close(); !errors.Is(err, ErrStoreClosed) {
+		t.Errorf("expected ErrStoreClosed closing twice, got %v", err)
+	}
+	if _, err := store.RetrieveImage("a"); !errors.Is(err, ErrStoreClosed) {
+		t.Errorf("expected ErrStoreClosed retrieving, got %v", err)
+	}
+	if err := store.StoreImage("b", nil); !errors.Is(err, ErrStoreClosed) {
+		t.Errorf("expected ErrStoreClosed storing, got %v", err)
+	}
+	if _, err := store.ListImages(); !errors.Is(err, ErrStoreClosed) {
+		t.Errorf("expected ErrStoreClosed listing, got %v", err)
+	}
+	for _, err := range store.Images(context.Background()) {
+		if !errors.Is(err, ErrStoreClosed) {
+			t.Errorf("expected ErrStoreClosed iterating, got %v", err)
+		}
+	}
+	if stats := store.GetStorageStats(); stats.TotalImages != 0 {
+		t.Errorf("expected no stats from a closed store, got %d images", stats.TotalImages)
+	}
+}
+
+func TestCloseWaitsForCallsInFlight(t *testing.T) {
+	store := newTagsTestStore(t, "a", "b")
+
+	// Hold a batch retrieval open in its callback
+	inCallback := make(chan struct{})
+	release := make(chan struct{})
+	batchErr := make(chan error, 1)
+	go func() {
+		batchErr <- store.RetrieveImages(context.Background(), []string{"a", "b"}, 1, func(result ImageResult) error {
+			if result.ID == "a" {
+				close(inCallback)
+				<-release
+			}
+			return result.Err
+		})
+	}()
+	<-inCallback
+
+	closed := make(chan error, 1)
+	go func() { closed <- store.Close() }()
+
+	// New calls are refused as soon as Close begins
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, err := store.RetrieveImage("a")
+		if errors.Is(err, ErrStoreClosed) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected ErrStoreClosed once Close began, got %v", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case err := <-closed:
+		t.Fatalf("Close returned with a call in flight: %v", err)
+	default:
+	}
+
+	close(release)
+	if err := <-closed; err != nil {
+		t.Errorf("failed to close store: %v", err)
+	}
+	// The batch started before Close, so it finishes, though its second
+	// image is refused once Close has begun
+	if err := <-batchErr; err != nil && !errors.Is(err, ErrStoreClosed) {
+		t.Errorf("expected the batch to finish or see ErrStoreClosed, got %v", err)
+	}
+}
+
+func TestCloseConcurrentWithCalls(t *testing.T) {
+	store := newTagsTestStore(t, "a")
+	imageData := mustRetrieve(t, store, "a")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				_, err := store.RetrieveImage("a")
+				if err == nil {
+					err = store.StoreImage("a", imageData)
+				}
+				if errors.Is(err, ErrStoreClosed) {
+					return
+				}
+				if err != nil {
+					t.Errorf("unexpected error before close: %v", err)
+					return
+				}
+			}
+		}()
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	var closers sync.WaitGroup
+	closeErrs := make([]error, 2)
+	for i := range closeErrs {
+		closers.Add(1)
+		go func() {
+			defer closers.Done()
+			closeErrs[i] = store.Close()
+		}()
+	}
+	closers.Wait()
+	wg.Wait()
+
+	if (closeErrs[0] == nil) == (closeErrs[1] == nil) {
+		t.Errorf("expected exactly one Close to succeed, got %v", closeErrs)
+	}
+}
@@ -0,0 +1,175 @@
+package imagestore
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// ImageCluster is a group of images that share a large fraction of tiles
+type ImageCluster struct {
+	ImageIDs []string
+}
+
+// clusterCommonTile is the number of images above which a tile is too
+// common to say anything about similarity. Blank or solid tiles are shared
+// by much of a library, and counting every pair of images sharing one
+// would grow with the square of the library.
+const clusterCommonTile = 128
+
+// ClusterResult is the outcome of one clustering run
+type ClusterResult struct {
+	Clusters   []ImageCluster
+	Threshold  float64
+	ComputedAt time.Time
+}
+
+// ClusterImages groups stored images whose tile sets overlap by at least
+// threshold (Jaccard similarity of distinct tile IDs). Tiles shared by more
+// than clusterCommonTile images are left out of both sides of the overlap.
+// Images with no similar peers are omitted.
+func (s *PebbleImageStore) ClusterImages(threshold float64) (*ClusterResult, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+
+	var imageIDs []string
+	var tileSets []map[TileID]bool
+
+	prefix := makePrefixKey(imagesBucket)
+	iter, err := s.db.NewIter(&pebble.IterOptions{
+		LowerBound: prefix,
+		UpperBound: prefixUpperBound(prefix),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	// Invert the manifests into tile -> images so only overlapping pairs are
+	// ever compared
+	imagesByTile := make(map[TileID][]int)
+	for iter.First(); iter.Valid(); iter.Next() {
+		var storedImage StoredImage
+		if err := decodeManifest(iter.Value(), &storedImage); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal image: %w", err)
+		}
+
+		index := len(imageIDs)
+		tiles := make(map[TileID]bool)
+		for _, tileRef := range storedImage.TileRefs {
+			if !tiles[tileRef.TileID] {
+				tiles[tileRef.TileID] = true
+				imagesByTile[tileRef.TileID] = append(imagesByTile[tileRef.TileID], index)
+			}
+		}
+
+		imageIDs = append(imageIDs, storedImage.ID)
+		tileSets = append(tileSets, tiles)
+	}
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+
+	for tileID, images := range imagesByTile {
+		if len(images) > clusterCommonTile {
+			delete(imagesByTile, tileID)
+			for _, index := range images {
+				delete(tileSets[index], tileID)
+			}
+		}
+	}
+
+	type pair struct{ a, b int }
+	shared := make(map[pair]int)
+	for _, images := range imagesByTile {
+		for i := 0; i < len(images); i++ {
+			for j := i + 1; j < len(images); j++ {
+				shared[pair{images[i], images[j]}]++
+			}
+		}
+	}
+
+	// Union-find over images joined by a similar pair
+	parent := make([]int, len(imageIDs))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	linked := make([]bool, len(imageIDs))
+	for p, count := range shared {
+		union := len(tileSets[p.a]) + len(tileSets[p.b]) - count
+		if union == 0 || float64(count)/float64(union) < threshold {
+			continue
+		}
+		parent[find(p.a)] = find(p.b)
+		linked[p.a], linked[p.b] = true, true
+	}
+
+	groups := make(map[int][]string)
+	for i, id := range imageIDs {
+		if linked[i] {
+			root := find(i)
+			groups[root] = append(groups[root], id)
+		}
+	}
+
+	result := &ClusterResult{Threshold: threshold, ComputedAt: time.Now().UTC()}
+	for _, ids := range groups {
+		sort.Strings(ids)
+		result.Clusters = append(result.Clusters, ImageCluster{ImageIDs: ids})
+	}
+	sort.Slice(result.Clusters, func(i, j int) bool {
+		return result.Clusters[i].ImageIDs[0] < result.Clusters[j].ImageIDs[0]
+	})
+
+	return result, nil
+}
+
+// refreshClusters recomputes clusters with the configured threshold and caches
+// the result for Clusters
+func (s *PebbleImageStore) refreshClusters() error {
+	result, err := s.ClusterImages(s.config.ClusterThreshold)
+	if err != nil {
+		return err
+	}
+
+	s.clusterMu.Lock()
+	s.clusters = result
+	s.clusterMu.Unlock()
+	return nil
+}
+
+// Clusters returns the most recent result of the background clustering job,
+// or computes clusters on demand when the job is disabled or has not run yet
+func (s *PebbleImageStore) Clusters() (*ClusterResult, error) {
+	if s.config.ClusterInterval <= 0 {
+		return s.ClusterImages(s.config.ClusterThreshold)
+	}
+
+	s.clusterMu.RLock()
+	result := s.clusters
+	s.clusterMu.RUnlock()
+
+	if result != nil {
+		return result, nil
+	}
+
+	if err := s.refreshClusters(); err != nil {
+		return nil, err
+	}
+
+	s.clusterMu.RLock()
+	defer s.clusterMu.RUnlock()
+	return s.clusters, nil
+}
@@ -0,0 +1,157 @@
+package imagestore
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestClusterImages(t *testing.T) {
+	config := DefaultConfig()
+	config.DatabasePath = filepath.Join(t.TempDir(), "test.db")
+	config.TileSize = 4
+
+	store, err := NewPebbleImageStore(config)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	base := createTestImage(8, 8)
+
+	// Same as base except for one tile
+	variant := image.NewRGBA(base.Bounds())
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			variant.Set(x, y, base.At(x, y))
+		}
+	}
+	variant.Set(0, 0, color.RGBA{1, 2, 3, 255})
+
+	// Shares nothing with base
+	other := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			other.Set(x, y, color.RGBA{200, uint8(x*30 + y), 7, 255})
+		}
+	}
+
+	for id, img := range map[string]image.Image{"base": base, "variant": variant, "other": other} {
+		imageData, err := encodeImageToPNG(img)
+		if err != nil {
+			t.Fatalf("failed to encode %s: %v", id, err)
+		}
+		if err := store.StoreImage(id, imageData); err != nil {
+			t.Fatalf("failed to store %s: %v", id, err)
+		}
+	}
+
+	result, err := store.ClusterImages(0.5)
+	if err != nil {
+		t.Fatalf("failed to cluster images: %v", err)
+	}
+
+	if len(result.Clusters) != 1 {
+		t.Fatalf("expected 1 cluster, got %d: %+v", len(result.Clusters), result.Clusters)
+	}
+
+	ids := result.Clusters[0].ImageIDs
+	if len(ids) != 2 || ids[0] != "base" || ids[1] != "variant" {
+		t.Errorf("expected cluster [base variant], got %v", ids)
+	}
+
+	// A threshold above the 3/5 overlap separates them
+	result, err = store.ClusterImages(0.9)
+	if err != nil {
+		t.Fatalf("failed to cluster images: %v", err)
+	}
+	if len(result.Clusters) != 0 {
+		t.Errorf("expected no clusters at threshold 0.9, got %+v", result.Clusters)
+	}
+}
+
+func TestClusterImagesCommonTile(t *testing.T) {
+	config := DefaultConfig()
+	config.DatabasePath = filepath.Join(t.TempDir(), "test.db")
+	config.TileSize = 4
+
+	store, err := NewPebbleImageStore(config)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	// Every image has three blank tiles and one of its own, except the
+	// twins, whose own tiles match
+	imageWithTile := func(n int) []byte {
+		img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+		for y := 0; y < 4; y++ {
+			for x := 0; x < 4; x++ {
+				img.Set(x, y, color.RGBA{uint8(n), uint8(n >> 8), uint8(x*4 + y), 255})
+			}
+		}
+		imageData, err := encodeImageToPNG(img)
+		if err != nil {
+			t.Fatalf("failed to encode image: %v", err)
+		}
+		return imageData
+	}
+	for i := 0; i < clusterCommonTile+20; i++ {
+		if err := store.StoreImage(fmt.Sprintf("img%03d", i), imageWithTile(i+1)); err != nil {
+			t.Fatalf("failed to store image: %v", err)
+		}
+	}
+	for _, id := range []string{"twin-a", "twin-b"} {
+		if err := store.StoreImage(id, imageWithTile(0)); err != nil {
+			t.Fatalf("failed to store image: %v", err)
+		}
+	}
+
+	// Counting the blank tiles, every pair would overlap by 3/5
+	result, err := store.ClusterImages(0.5)
+	if err != nil {
+		t.Fatalf("failed to cluster images: %v", err)
+	}
+	if len(result.Clusters) != 1 || fmt.Sprint(result.Clusters[0].ImageIDs) != "[twin-a twin-b]" {
+		t.Errorf("expected only the twins clustered, got %+v", result.Clusters)
+	}
+}
+
+func TestClusterBackgroundJob(t *testing.T) {
+	config := DefaultConfig()
+	config.DatabasePath = filepath.Join(t.TempDir(), "test.db")
+	config.TileSize = 4
+	config.ClusterInterval = 10 * time.Millisecond
+
+	store, err := NewPebbleImageStore(config)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	imageData, err := encodeImageToPNG(createTestImage(8, 8))
+	if err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	for _, id := range []string{"a", "b"} {
+		if err := store.StoreImage(id, imageData); err != nil {
+			t.Fatalf("failed to store %s: %v", id, err)
+		}
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		result, err := store.Clusters()
+		if err != nil {
+			t.Fatalf("failed to get clusters: %v", err)
+		}
+		if len(result.Clusters) == 1 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("background job did not pick up newly stored images")
+}
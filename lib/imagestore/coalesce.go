@@ -0,0 +1,34 @@
+package imagestore
+
+import (
+	"context"
+)
+
+// shareRetrieval runs render for an image, letting concurrent requests for
+// the same rendering of the same manifest wait for one run instead of each
+// reconstructing the image. Flights are keyed by render key as well as ID, so
+// a request arriving after an overwrite never gets the previous version.
+//
+// The shared render outlives any single caller's context, since others may
+// be waiting on it; a caller whose context ends stops waiting with ctx's
+// error.
+func (s *PebbleImageStore) shareRetrieval(ctx context.Context, format string, storedImage *StoredImage, key string, render func(context.Context) ([]byte, error)) ([]byte, error) {
+	results := s.retrievals.DoChan(format+"\x00"+storedImage.ID+"\x00"+key, func() (interface{}, error) {
+		return render(context.WithoutCancel(ctx))
+	})
+
+	select {
+	case result := <-results:
+		if result.Err != nil {
+			return nil, result.Err
+		}
+		data := result.Val.([]byte)
+		if result.Shared {
+			s.sharedRetrievals.Add(1)
+			data = append([]byte(nil), data...)
+		}
+		return data, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,80 @@
+package imagestore
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConcurrentRetrievalsShareReconstruction(t *testing.T) {
+	config := DefaultConfig()
+	config.DatabasePath = filepath.Join(t.TempDir(), "test.db")
+	config.TileSize = 4
+	config.Resources.MaxReconstructions = 1
+
+	store, err := NewPebbleImageStore(config)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	imageData, _ := encodeImageToPNG(createTestImage(8, 8))
+	if err := store.StoreImage("a", imageData); err != nil {
+		t.Fatalf("failed to store image: %v", err)
+	}
+	want, err := store.RetrieveImage("a")
+	if err != nil {
+		t.Fatalf("failed to retrieve image: %v", err)
+	}
+
+	// Hold the only reconstruction slot so every request joins the first
+	store.reconstructions.acquire()
+	const requests = 10
+	results := make([][]byte, requests)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], _ = store.RetrieveImage("a")
+		}()
+	}
+	time.Sleep(100 * time.Millisecond)
+	store.reconstructions.release()
+	wg.Wait()
+
+	for i, data := range results {
+		if !bytes.Equal(data, want) {
+			t.Errorf("request %d got a different image", i)
+		}
+	}
+	if shared := store.GetStorageStats().Resources.SharedRetrievals; shared != requests {
+		t.Errorf("expected %d shared retrievals, got %d", requests, shared)
+	}
+
+	// A waiter that gives up doesn't cancel the others
+	store.reconstructions.acquire()
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		_, err := store.RetrieveImageContext(ctx, "a")
+		errs <- err
+	}()
+	done := make(chan []byte, 1)
+	go func() {
+		data, _ := store.RetrieveImage("a")
+		done <- data
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	if err := <-errs; err != context.Canceled {
+		t.Errorf("expected the cancelled waiter to fail with context.Canceled, got %v", err)
+	}
+	store.reconstructions.release()
+	if data := <-done; !bytes.Equal(data, want) {
+		t.Error("expected the remaining waiter to get the image")
+	}
+}
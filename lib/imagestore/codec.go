@@ -0,0 +1,242 @@
+package imagestore
+
+import (
+	"bytes"
+	"fmt"
+	"image/color"
+	"image/png"
+	"io"
+	"sort"
+
+	"github.com/DataDog/zstd"
+)
+
+// TileCodec encodes raw RGB tile data into a stored payload and back
+type TileCodec interface {
+	ID() byte
+	Name() string
+	Encode(data []byte, level int) ([]byte, error)
+	Decode(payload []byte) ([]byte, error)
+}
+
+// TileCodecFactory builds a codec for a store's tile size and optional zstd
+// dictionary
+type TileCodecFactory func(tileSize int, dict []byte) TileCodec
+
+// Built-in codec names accepted in Config.TileCodecs
+const (
+	CodecZstd         = "zstd"
+	CodecFilteredZstd = "filtered-zstd"
+	CodecPNG          = "png"
+)
+
+// zstdFrameMagic is the first byte of a bare zstd frame; values starting with
+// it predate codec bytes and are decoded as plain zstd
+const zstdFrameMagic = 0x28
+
+var tileCodecFactories = map[string]TileCodecFactory{
+	CodecZstd: func(tileSize int, dict []byte) TileCodec {
+		return &zstdCodec{dict: dict}
+	},
+	CodecFilteredZstd: func(tileSize int, dict []byte) TileCodec {
+		return &filteredZstdCodec{zstd: zstdCodec{dict: dict}, tileSize: tileSize}
+	},
+	CodecPNG: func(tileSize int, dict []byte) TileCodec {
+		return &pngCodec{tileSize: tileSize}
+	},
+}
+
+// RegisterTileCodec makes a codec available to Config.TileCodecs by name.
+// Codec IDs must be unique and must not equal the zstd frame magic byte, the
+// cold tier stub marker or the blob pointer marker.
+func RegisterTileCodec(name string, factory TileCodecFactory) {
+	tileCodecFactories[name] = factory
+}
+
+// newTileCodecs builds the candidate codecs named in the config and a lookup
+// of every registered codec by ID for decoding
+func newTileCodecs(names []string, tileSize int, dict []byte) ([]TileCodec, map[byte]TileCodec, error) {
+	byID := make(map[byte]TileCodec)
+
+	registered := make([]string, 0, len(tileCodecFactories))
+	for name := range tileCodecFactories {
+		registered = append(registered, name)
+	}
+	sort.Strings(registered)
+
+	for _, name := range registered {
+		codec := tileCodecFactories[name](tileSize, dict)
+		if codec.ID() == zstdFrameMagic || codec.ID() == coldStubID || codec.ID() == blobPointerID {
+			return nil, nil, fmt.Errorf("tile codec %s uses reserved ID %#x", name, codec.ID())
+		}
+		if existing, ok := byID[codec.ID()]; ok {
+			return nil, nil, fmt.Errorf("tile codecs %s and %s share ID %#x", existing.Name(), name, codec.ID())
+		}
+		byID[codec.ID()] = codec
+	}
+
+	if len(names) == 0 {
+		names = []string{CodecZstd}
+	}
+
+	candidates := make([]TileCodec, 0, len(names))
+	for _, name := range names {
+		factory, ok := tileCodecFactories[name]
+		if !ok {
+			return nil, nil, fmt.Errorf("unknown tile codec: %s", name)
+		}
+		candidates = append(candidates, factory(tileSize, dict))
+	}
+
+	return candidates, byID, nil
+}
+
+// zstdCodec stores tile data as a single zstd frame
+type zstdCodec struct {
+	dict []byte
+}
+
+func (c *zstdCodec) ID() byte     { return 0x01 }
+func (c *zstdCodec) Name() string { return CodecZstd }
+
+func (c *zstdCodec) Encode(data []byte, level int) ([]byte, error) {
+	if c.dict == nil {
+		return zstd.CompressLevel(nil, data, level)
+	}
+
+	var buf bytes.Buffer
+	writer := zstd.NewWriterLevelDict(&buf, level, c.dict)
+
+	_, err := writer.Write(data)
+	if err != nil {
+		writer.Close()
+		return nil, fmt.Errorf("failed to write data to zstd writer: %w", err)
+	}
+
+	err = writer.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to close zstd writer: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (c *zstdCodec) Decode(payload []byte) ([]byte, error) {
+	if c.dict == nil {
+		data, err := zstd.Decompress(nil, payload)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress zstd tile: %w", err)
+		}
+		return data, nil
+	}
+
+	reader := zstd.NewReaderDict(bytes.NewReader(payload), c.dict)
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from zstd reader: %w", err)
+	}
+	return data, nil
+}
+
+// filteredZstdCodec applies the PNG Paeth filter to each row before zstd,
+// which helps on gradients and photographic content
+type filteredZstdCodec struct {
+	zstd     zstdCodec
+	tileSize int
+}
+
+func (c *filteredZstdCodec) ID() byte     { return 0x02 }
+func (c *filteredZstdCodec) Name() string { return CodecFilteredZstd }
+
+func (c *filteredZstdCodec) Encode(data []byte, level int) ([]byte, error) {
+	return c.zstd.Encode(paethFilter(data, c.tileSize*3), level)
+}
+
+func (c *filteredZstdCodec) Decode(payload []byte) ([]byte, error) {
+	filtered, err := c.zstd.Decode(payload)
+	if err != nil {
+		return nil, err
+	}
+	return paethUnfilter(filtered, c.tileSize*3), nil
+}
+
+// pngCodec stores tile data as a PNG image using the standard library encoder
+type pngCodec struct {
+	tileSize int
+}
+
+func (c *pngCodec) ID() byte     { return 0x03 }
+func (c *pngCodec) Name() string { return CodecPNG }
+
+func (c *pngCodec) Encode(data []byte, level int) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := png.Encoder{CompressionLevel: png.BestCompression}
+	if err := encoder.Encode(&buf, tileImage(data, c.tileSize, c.tileSize)); err != nil {
+		return nil, fmt.Errorf("failed to encode PNG tile: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *pngCodec) Decode(payload []byte) ([]byte, error) {
+	img, err := png.Decode(bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode PNG tile: %w", err)
+	}
+
+	bounds := img.Bounds()
+	return extractTileData(img, bounds.Min.X, bounds.Min.Y, bounds.Max.X, bounds.Max.Y, c.tileSize, color.RGBA{}), nil
+}
+
+// paethFilter applies the PNG Paeth filter to 3-byte-per-pixel rows
+func paethFilter(data []byte, stride int) []byte {
+	out := make([]byte, len(data))
+	for i := range data {
+		out[i] = data[i] - paethPredict(data, i, stride)
+	}
+	return out
+}
+
+// paethUnfilter reverses paethFilter
+func paethUnfilter(filtered []byte, stride int) []byte {
+	out := make([]byte, len(filtered))
+	for i := range filtered {
+		out[i] = filtered[i] + paethPredict(out, i, stride)
+	}
+	return out
+}
+
+// paethPredict returns the Paeth predictor for byte i from its left, up and
+// upper-left neighbours
+func paethPredict(data []byte, i, stride int) byte {
+	var a, b, c int
+	col := i % stride
+	if col >= 3 {
+		a = int(data[i-3])
+	}
+	if i >= stride {
+		b = int(data[i-stride])
+		if col >= 3 {
+			c = int(data[i-stride-3])
+		}
+	}
+
+	p := a + b - c
+	pa, pb, pc := abs(p-a), abs(p-b), abs(p-c)
+	switch {
+	case pa <= pb && pa <= pc:
+		return byte(a)
+	case pb <= pc:
+		return byte(b)
+	default:
+		return byte(c)
+	}
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
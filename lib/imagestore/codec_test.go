@@ -0,0 +1,171 @@
+package imagestore
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/DataDog/zstd"
+)
+
+func createTestTileData(tileSize int) []byte {
+	data := make([]byte, tileSize*tileSize*3)
+	for i := range data {
+		data[i] = uint8((i * 7) % 251)
+	}
+	return data
+}
+
+func TestTileCodecsRoundTrip(t *testing.T) {
+	tileSize := 8
+	data := createTestTileData(tileSize)
+
+	for _, name := range []string{CodecZstd, CodecFilteredZstd, CodecPNG} {
+		codec := tileCodecFactories[name](tileSize, nil)
+
+		payload, err := codec.Encode(data, zstd.DefaultCompression)
+		if err != nil {
+			t.Fatalf("%s: failed to encode: %v", name, err)
+		}
+
+		decoded, err := codec.Decode(payload)
+		if err != nil {
+			t.Fatalf("%s: failed to decode: %v", name, err)
+		}
+
+		if !bytes.Equal(decoded, data) {
+			t.Errorf("%s: round-trip mismatch", name)
+		}
+	}
+}
+
+func TestPaethFilterRoundTrip(t *testing.T) {
+	data := createTestTileData(4)
+
+	filtered := paethFilter(data, 4*3)
+	if bytes.Equal(filtered, data) {
+		t.Error("expected filtering to change the data")
+	}
+
+	if unfiltered := paethUnfilter(filtered, 4*3); !bytes.Equal(unfiltered, data) {
+		t.Error("paeth unfilter did not restore original data")
+	}
+}
+
+func TestNewTileCodecsUnknown(t *testing.T) {
+	_, _, err := newTileCodecs([]string{"webp"}, 4, nil)
+	if err == nil {
+		t.Error("expected error for unknown tile codec")
+	}
+}
+
+func TestCompressTileDataKeepsSmallestCodec(t *testing.T) {
+	config := DefaultConfig()
+	config.DatabasePath = filepath.Join(t.TempDir(), "test.db")
+	config.TileSize = 8
+	config.TileCodecs = []string{CodecZstd, CodecFilteredZstd, CodecPNG}
+
+	store, err := NewPebbleImageStore(config)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	data := createTestTileData(8)
+
+	stored, err := store.compressTileData(data)
+	if err != nil {
+		t.Fatalf("failed to compress tile: %v", err)
+	}
+
+	for _, codec := range store.codecs {
+		payload, err := codec.Encode(data, store.level)
+		if err != nil {
+			t.Fatalf("%s: failed to encode: %v", codec.Name(), err)
+		}
+		if len(payload)+1 < len(stored) {
+			t.Errorf("%s produced %d bytes but %d were stored", codec.Name(), len(payload)+1, len(stored))
+		}
+	}
+
+	decoded, err := store.decompressTileData(stored)
+	if err != nil {
+		t.Fatalf("failed to decompress tile: %v", err)
+	}
+	if !bytes.Equal(decoded, data) {
+		t.Error("decompressed tile does not match original")
+	}
+}
+
+func TestDecompressLegacyZstdTile(t *testing.T) {
+	config := DefaultConfig()
+	config.DatabasePath = filepath.Join(t.TempDir(), "test.db")
+	config.TileSize = 4
+
+	store, err := NewPebbleImageStore(config)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	data := createTestTileData(4)
+
+	// Tiles written before codec bytes existed are bare zstd frames
+	legacy, err := zstd.Compress(nil, data)
+	if err != nil {
+		t.Fatalf("failed to compress legacy tile: %v", err)
+	}
+
+	decoded, err := store.decompressTileData(legacy)
+	if err != nil {
+		t.Fatalf("failed to decompress legacy tile: %v", err)
+	}
+	if !bytes.Equal(decoded, data) {
+		t.Error("legacy tile does not match original")
+	}
+}
+
+// benchmarkCodecParallel runs a codec operation on every GOMAXPROCS thread
+// at once, the load concurrent uploads and reads put on zstd
+func benchmarkCodecParallel(b *testing.B, dict []byte, decode bool) {
+	tileSize := 256
+	data := createTestTileData(tileSize)
+	codec := tileCodecFactories[CodecZstd](tileSize, dict)
+	payload, err := codec.Encode(data, zstd.DefaultCompression)
+	if err != nil {
+		b.Fatalf("failed to encode: %v", err)
+	}
+
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			var err error
+			if decode {
+				_, err = codec.Decode(payload)
+			} else {
+				_, err = codec.Encode(data, zstd.DefaultCompression)
+			}
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkZstdEncodeParallel(b *testing.B) {
+	b.Run("plain", func(b *testing.B) { benchmarkCodecParallel(b, nil, false) })
+	b.Run("dict", func(b *testing.B) { benchmarkCodecParallel(b, benchmarkDict(b), false) })
+}
+
+func BenchmarkZstdDecodeParallel(b *testing.B) {
+	b.Run("plain", func(b *testing.B) { benchmarkCodecParallel(b, nil, true) })
+	b.Run("dict", func(b *testing.B) { benchmarkCodecParallel(b, benchmarkDict(b), true) })
+}
+
+// benchmarkDict returns a raw-content dictionary; any bytes will do for
+// measuring the per-call cost of loading one
+func benchmarkDict(b *testing.B) []byte {
+	b.Helper()
+	return createTestTileData(64)
+}
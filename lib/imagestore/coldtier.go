@@ -0,0 +1,409 @@
+package imagestore
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// ColdStore is a cheaper backend that holds the stored encoding of tiles no
+// image has used recently. Only a stub stays in the local database; the tile
+// is fetched back on first read. NewDirColdStore provides one backed by a
+// directory and s3tier.New one backed by an S3 bucket.
+type ColdStore interface {
+	PutTile(tileID TileID, payload []byte) error
+	// GetTile returns a payload stored with PutTile
+	GetTile(tileID TileID) ([]byte, error)
+	// DeleteTile removes a payload, succeeding if it is already gone
+	DeleteTile(tileID TileID) error
+}
+
+// ColdTierStats describes cold tier activity since the store was opened
+type ColdTierStats struct {
+	Enabled     bool
+	ColdTiles   int     // Tiles held only in the cold store
+	HotReads    int64   // Tile reads served from the local database
+	ColdReads   int64   // Tile reads fetched from the cold store
+	FetchErrors int64   // Cold store fetches that failed
+	Offloaded   int64   // Tiles moved to the cold store
+	HitRate     float64 // Share of tile reads served locally
+}
+
+// coldStubID marks a tile value whose payload lives in the cold store. Like
+// the zstd frame magic it is reserved from codec IDs.
+const coldStubID = 0xC0
+
+// accessResolution is how stale an image's recorded access time may get
+// before a read records it again, bounding writes on hot images
+const accessResolution = time.Hour
+
+// coldTierCounters counts tile reads by tier
+type coldTierCounters struct {
+	hotReads    atomic.Int64
+	coldReads   atomic.Int64
+	fetchErrors atomic.Int64
+	offloaded   atomic.Int64
+}
+
+// isColdStub reports whether a stored tile value is a cold tier stub
+func isColdStub(stored []byte) bool {
+	return len(stored) == 1 && stored[0] == coldStubID
+}
+
+// accessKey returns the key holding when an image was last retrieved
+func accessKey(id string) []byte {
+	return makeKey(accessBucket, id)
+}
+
+// recordAccess notes that an image was retrieved so its tiles stay local.
+// Writes skip the WAL sync; losing a recent access only risks an early
+// offload.
+func (s *PebbleImageStore) recordAccess(id string) {
+	if s.config.ColdStore == nil || s.config.ReadOnly {
+		return
+	}
+
+	now := time.Now()
+	key := accessKey(id)
+	if value, closer, err := s.db.Get(key); err == nil {
+		last := time.Unix(int64(binary.BigEndian.Uint64(value)), 0)
+		closer.Close()
+		if now.Sub(last) < accessResolution {
+			return
+		}
+	}
+
+	var value [8]byte
+	binary.BigEndian.PutUint64(value[:], uint64(now.Unix()))
+	if err := s.db.Set(key, value[:], pebble.NoSync); err != nil {
+		fmt.Printf("Warning: failed to record access to %s: %v\n", id, err)
+	}
+}
+
+// resolveTile returns the local encoding of a stored tile value, fetching a
+// stubbed tile from the cold store and keeping it locally again
+func (s *PebbleImageStore) resolveTile(tileID TileID, stored []byte) ([]byte, error) {
+	if !isColdStub(stored) {
+		if s.config.ColdStore != nil {
+			s.coldStats.hotReads.Add(1)
+		}
+		if isBlobPointer(stored) {
+			return s.readBlobTile(tileID, stored)
+		}
+		return stored, nil
+	}
+
+	payload, err := s.fetchColdTile(tileID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !s.config.ReadOnly {
+		if err := s.restoreColdTile(tileID, payload); err != nil {
+			fmt.Printf("Warning: failed to restore cold tile %s locally: %v\n", tileID, err)
+		}
+	}
+	return payload, nil
+}
+
+// restoreColdTile keeps a tile fetched from the cold store locally again
+func (s *PebbleImageStore) restoreColdTile(tileID TileID, payload []byte) error {
+	values := [][]byte{payload}
+	release, err := s.separateBlobs(values)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return s.db.Set(tileKey(tileID), values[0], s.writeOpts)
+}
+
+// fetchColdTile reads a tile's payload from the cold store
+func (s *PebbleImageStore) fetchColdTile(tileID TileID) ([]byte, error) {
+	if s.config.ColdStore == nil {
+		return nil, fmt.Errorf("tile %s is in the cold tier but no cold store is configured", tileID)
+	}
+
+	payload, err := s.config.ColdStore.GetTile(tileID)
+	if err != nil {
+		s.coldStats.fetchErrors.Add(1)
+		return nil, fmt.Errorf("failed to fetch tile %s from the cold tier: %w", tileID, err)
+	}
+	if len(payload) == 0 || isColdStub(payload) {
+		s.coldStats.fetchErrors.Add(1)
+		return nil, fmt.Errorf("cold tier returned an invalid payload for tile %s", tileID)
+	}
+	s.coldStats.coldReads.Add(1)
+	return payload, nil
+}
+
+// OffloadColdTiles moves tiles to the cold store when no live or trashed
+// image referencing them has been retrieved within Config.ColdAfter,
+// returning the number of tiles moved. Images without a recorded access are
+// stamped as accessed now, giving images stored before the cold tier was
+// enabled a full ColdAfter before their tiles move.
+func (s *PebbleImageStore) OffloadColdTiles() (int, error) {
+	if err := s.enter(); err != nil {
+		return 0, err
+	}
+	defer s.leave()
+
+	if s.config.ColdStore == nil {
+		return 0, fmt.Errorf("no cold store is configured")
+	}
+
+	job := s.startJob(JobColdTierOffload, 0)
+	offloaded, err := s.offloadColdTiles(job)
+	job.finish(err)
+	return offloaded, err
+}
+
+func (s *PebbleImageStore) offloadColdTiles(job *maintenanceJob) (int, error) {
+	// Collection must not delete a tile between its upload and its stub
+	s.gcMu.RLock()
+	defer s.gcMu.RUnlock()
+
+	snapshot := s.db.NewSnapshot()
+	defer snapshot.Close()
+
+	hot, cold, err := s.classifyTiles(snapshot)
+	if err != nil {
+		return 0, err
+	}
+
+	prefix := makePrefixKey(tilesBucket)
+	iter, err := snapshot.NewIter(&pebble.IterOptions{
+		LowerBound: prefix,
+		UpperBound: prefixUpperBound(prefix),
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer iter.Close()
+
+	batch := s.db.NewBatch()
+	defer func() { batch.Close() }()
+
+	offloaded := 0
+	var examined, chunkBytes int64
+	for iter.First(); iter.Valid(); iter.Next() {
+		examined++
+		tileID := tileIDFromKey(iter.Key())
+		if hot[tileID] || !cold[tileID] || isColdStub(iter.Value()) {
+			continue
+		}
+		payload := iter.Value()
+		if isBlobPointer(payload) {
+			if payload, err = s.readBlobTile(tileID, payload); err != nil {
+				return offloaded, err
+			}
+		}
+		chunkBytes += int64(len(payload))
+
+		if err := s.config.ColdStore.PutTile(tileID, payload); err != nil {
+			return offloaded, fmt.Errorf("failed to offload tile %s: %w", tileID, err)
+		}
+		if err := batch.Set(append([]byte(nil), iter.Key()...), []byte{coldStubID}, nil); err != nil {
+			return offloaded, err
+		}
+
+		// Commit in chunks so an interrupted offload keeps its progress
+		if batch.Count() >= 1000 {
+			if err := batch.Commit(s.writeOpts); err != nil {
+				return offloaded, fmt.Errorf("failed to commit cold tier stubs: %w", err)
+			}
+			offloaded += int(batch.Count())
+			job.advance(examined, int64(batch.Count()), chunkBytes)
+			job.throttle(chunkBytes)
+			examined, chunkBytes = 0, 0
+			batch.Close()
+			batch = s.db.NewBatch()
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return offloaded, err
+	}
+
+	if err := batch.Commit(s.writeOpts); err != nil {
+		return offloaded, fmt.Errorf("failed to commit cold tier stubs: %w", err)
+	}
+	offloaded += int(batch.Count())
+	job.advance(examined, int64(batch.Count()), chunkBytes)
+	s.coldStats.offloaded.Add(int64(offloaded))
+
+	if offloaded > 0 {
+		fmt.Printf("Offloaded %d tiles to the cold tier\n", offloaded)
+	}
+	return offloaded, nil
+}
+
+// classifyTiles splits referenced tiles into those used by a recently
+// retrieved image and those only used by images idle past Config.ColdAfter.
+// It stamps images with no recorded access and drops records of images that
+// no longer exist.
+func (s *PebbleImageStore) classifyTiles(snapshot *pebble.Snapshot) (map[TileID]bool, map[TileID]bool, error) {
+	now := time.Now()
+	cutoff := now.Add(-s.config.ColdAfter)
+
+	batch := s.db.NewBatch()
+	defer batch.Close()
+
+	var stamp [8]byte
+	binary.BigEndian.PutUint64(stamp[:], uint64(now.Unix()))
+
+	hot := make(map[TileID]bool)
+	cold := make(map[TileID]bool)
+	images := make(map[string]bool)
+
+	for _, bucket := range [][]byte{imagesBucket, trashBucket} {
+		prefix := makePrefixKey(bucket)
+		iter, err := snapshot.NewIter(&pebble.IterOptions{
+			LowerBound: prefix,
+			UpperBound: prefixUpperBound(prefix),
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for iter.First(); iter.Valid(); iter.Next() {
+			var storedImage StoredImage
+			if err := decodeManifest(iter.Value(), &storedImage); err != nil {
+				iter.Close()
+				return nil, nil, fmt.Errorf("failed to unmarshal image: %w", err)
+			}
+			images[storedImage.ID] = true
+
+			recent := true
+			if value, closer, err := snapshot.Get(accessKey(storedImage.ID)); err == nil {
+				recent = time.Unix(int64(binary.BigEndian.Uint64(value)), 0).After(cutoff)
+				closer.Close()
+			} else if err := batch.Set(accessKey(storedImage.ID), stamp[:], nil); err != nil {
+				iter.Close()
+				return nil, nil, err
+			}
+
+			for _, tileRef := range storedImage.TileRefs {
+				if recent {
+					hot[tileRef.TileID] = true
+				} else {
+					cold[tileRef.TileID] = true
+				}
+			}
+		}
+
+		if err := iter.Close(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	prefix := makePrefixKey(accessBucket)
+	iter, err := snapshot.NewIter(&pebble.IterOptions{
+		LowerBound: prefix,
+		UpperBound: prefixUpperBound(prefix),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	for iter.First(); iter.Valid(); iter.Next() {
+		if !images[string(iter.Key()[len(prefix):])] {
+			if err := batch.Delete(append([]byte(nil), iter.Key()...), nil); err != nil {
+				iter.Close()
+				return nil, nil, err
+			}
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return nil, nil, err
+	}
+
+	if err := batch.Commit(s.writeOpts); err != nil {
+		return nil, nil, fmt.Errorf("failed to record image access: %w", err)
+	}
+	return hot, cold, nil
+}
+
+// coldTierStats snapshots the cold tier counters
+func (s *PebbleImageStore) coldTierStats(coldTiles int) ColdTierStats {
+	stats := ColdTierStats{
+		Enabled:     s.config.ColdStore != nil,
+		ColdTiles:   coldTiles,
+		HotReads:    s.coldStats.hotReads.Load(),
+		ColdReads:   s.coldStats.coldReads.Load(),
+		FetchErrors: s.coldStats.fetchErrors.Load(),
+		Offloaded:   s.coldStats.offloaded.Load(),
+	}
+	if reads := stats.HotReads + stats.ColdReads; reads > 0 {
+		stats.HitRate = float64(stats.HotReads) / float64(reads)
+	}
+	return stats
+}
+
+// DirColdStore keeps cold tiles as files in a directory, such as a mount of
+// slower or cheaper storage
+type DirColdStore struct {
+	dir string
+}
+
+// NewDirColdStore creates a cold store in dir, creating it if needed
+func NewDirColdStore(dir string) (*DirColdStore, error) {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, fmt.Errorf("failed to create cold tier directory: %w", err)
+	}
+	return &DirColdStore{dir: dir}, nil
+}
+
+// path spreads tiles over subdirectories named by the first two hex digits
+// of their ID so no directory grows too large
+func (d *DirColdStore) path(tileID TileID) string {
+	id := string(tileID)
+	if len(id) < 2 {
+		return filepath.Join(d.dir, id)
+	}
+	return filepath.Join(d.dir, id[:2], id)
+}
+
+// PutTile writes a tile through a temporary file so readers never see a
+// partial payload
+func (d *DirColdStore) PutTile(tileID TileID, payload []byte) error {
+	path := d.path(tileID)
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tile-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(payload); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// GetTile reads a tile's payload
+func (d *DirColdStore) GetTile(tileID TileID) ([]byte, error) {
+	return os.ReadFile(d.path(tileID))
+}
+
+// DeleteTile removes a tile's payload
+func (d *DirColdStore) DeleteTile(tileID TileID) error {
+	if err := os.Remove(d.path(tileID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package imagestore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+)
+
+func TestOffloadColdTiles(t *testing.T) {
+	store := newTagsTestStore(t, "recent")
+	cold, err := NewDirColdStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create cold store: %v", err)
+	}
+	store.config.ColdStore = cold
+	store.config.ColdAfter = time.Hour
+
+	other := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			other.Set(x, y, color.RGBA{uint8(x * 20), 99, uint8(y * 20), 255})
+		}
+	}
+	imageData, _ := encodeImageToPNG(other)
+	if err := store.StoreImage("idle", imageData); err != nil {
+		t.Fatalf("failed to store image: %v", err)
+	}
+	expected, _ := store.RetrieveImage("idle")
+
+	// The first pass stamps both images as accessed now
+	if offloaded, err := store.OffloadColdTiles(); err != nil || offloaded != 0 {
+		t.Fatalf("expected nothing offloaded on the first pass, got %d (%v)", offloaded, err)
+	}
+
+	var stale [8]byte
+	binary.BigEndian.PutUint64(stale[:], uint64(time.Now().Add(-2*time.Hour).Unix()))
+	store.db.Set(accessKey("idle"), stale[:], pebble.Sync)
+	store.db.Set(accessKey("deleted"), stale[:], pebble.Sync)
+
+	offloaded, err := store.OffloadColdTiles()
+	if err != nil {
+		t.Fatalf("failed to offload cold tiles: %v", err)
+	}
+	if offloaded != 4 {
+		t.Errorf("expected the idle image's 4 tiles offloaded, got %d", offloaded)
+	}
+	if stats := store.GetStorageStats(); stats.ColdTier.ColdTiles != 4 || stats.ColdTier.Offloaded != 4 {
+		t.Errorf("unexpected cold tier stats: %+v", stats.ColdTier)
+	}
+	if _, closer, err := store.db.Get(accessKey("deleted")); err == nil {
+		closer.Close()
+		t.Error("access record of a missing image survived the offload")
+	}
+
+	storedImage, _ := store.GetManifest("idle")
+	info, err := store.InspectTile(storedImage.TileRefs[0].TileID)
+	if err != nil || !info.Cold || info.Codec != CodecZstd {
+		t.Errorf("expected inspection to describe the cold tile, got %+v (%v)", info, err)
+	}
+
+	retrieved, err := store.RetrieveImage("idle")
+	if err != nil {
+		t.Fatalf("failed to retrieve offloaded image: %v", err)
+	}
+	if !bytes.Equal(retrieved, expected) {
+		t.Error("offloaded image differs from the original")
+	}
+
+	stats := store.GetStorageStats().ColdTier
+	if stats.ColdTiles != 0 || stats.ColdReads < 4 || stats.HitRate <= 0 || stats.HitRate >= 1 {
+		t.Errorf("expected the read to bring the tiles back, got %+v", stats)
+	}
+
+	// A recently retrieved image keeps its tiles local
+	if offloaded, _ := store.OffloadColdTiles(); offloaded != 0 {
+		t.Errorf("expected no tiles offloaded after the read, got %d", offloaded)
+	}
+}
+
+func TestOffloadedTileWithoutColdStore(t *testing.T) {
+	store := newTagsTestStore(t, "image")
+	storedImage, _ := store.GetManifest("image")
+	store.db.Set(tileKey(storedImage.TileRefs[0].TileID), []byte{coldStubID}, pebble.Sync)
+
+	if _, err := store.RetrieveImage("image"); err == nil {
+		t.Error("expected an error reading a cold tile without a cold store")
+	}
+}
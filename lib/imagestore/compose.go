@@ -0,0 +1,277 @@
+package imagestore
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/draw"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// ComposeRegion copies a rectangle of a stored image into a composed image
+type ComposeRegion struct {
+	Source string `json:"source"` // ID of the live image to copy from
+	X      int    `json:"x"`      // Rectangle in the source image
+	Y      int    `json:"y"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	DstX   int    `json:"dst_x"` // Top-left corner in the composed image
+	DstY   int    `json:"dst_y"`
+}
+
+// ComposeResult describes a composed image
+type ComposeResult struct {
+	TilesReused   int // Tiles referenced from a source without decoding
+	TilesRendered int // Tiles rebuilt from source pixels
+	NewTiles      int // Rendered tiles no stored tile matched
+}
+
+// ComposeImage stores a width x height image assembled from regions of live
+// images, such as the segments of a scrolling screenshot. Later regions are
+// drawn over earlier ones and uncovered pixels take the store's background
+// color. Where the topmost
+// region covers a whole tile and its source and destination offsets are
+// tile-aligned, the source's tile is referenced as is; other tiles are
+// rendered from source pixels and deduplicated like an upload. Composing
+// onto an existing ID follows Config.OnConflict.
+func (s *PebbleImageStore) ComposeImage(id string, width, height int, regions []ComposeRegion, opts StoreOptions) (*ComposeResult, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+
+	result, plan, err := s.composeImage(id, width, height, regions, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.collectReplaced(plan); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// composeImage plans and commits a composition, returning the plan
+func (s *PebbleImageStore) composeImage(id string, width, height int, regions []ComposeRegion, opts StoreOptions) (*ComposeResult, *storePlan, error) {
+	if width <= 0 || height <= 0 {
+		return nil, nil, fmt.Errorf("invalid composition: size %dx%d", width, height)
+	}
+	if len(regions) == 0 {
+		return nil, nil, fmt.Errorf("invalid composition: no regions")
+	}
+
+	if err := s.acquireWrite(); err != nil {
+		return nil, nil, err
+	}
+	defer s.releaseWrite()
+
+	// Hold off garbage collection so the referenced tiles stay present
+	s.gcMu.RLock()
+	defer s.gcMu.RUnlock()
+
+	snapshot := s.db.NewSnapshot()
+	defer snapshot.Close()
+
+	bounds := image.Rect(0, 0, width, height)
+	sources := make(map[string]*StoredImage)
+	for i, region := range regions {
+		source, ok := sources[region.Source]
+		if !ok {
+			value, closer, err := snapshot.Get(makeKey(imagesBucket, region.Source))
+			if err != nil {
+				return nil, nil, fmt.Errorf("image not found: %s", region.Source)
+			}
+			source = &StoredImage{}
+			err = decodeManifest(value, source)
+			closer.Close()
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to unmarshal image: %w", err)
+			}
+			sources[region.Source] = source
+		}
+
+		src := image.Rect(region.X, region.Y, region.X+region.Width, region.Y+region.Height)
+		dst := src.Add(image.Pt(region.DstX-region.X, region.DstY-region.Y))
+		if region.X < 0 || region.Y < 0 || src.Empty() || !src.In(image.Rect(0, 0, source.Width, source.Height)) {
+			return nil, nil, fmt.Errorf("invalid composition: region %d lies outside the %dx%d image %s", i, source.Width, source.Height, region.Source)
+		}
+		if overlapsRedaction(src, source.Redactions) {
+			return nil, nil, fmt.Errorf("invalid composition: region %d overlaps a redacted area of %s", i, region.Source)
+		}
+		if region.DstX < 0 || region.DstY < 0 || !dst.In(bounds) {
+			return nil, nil, fmt.Errorf("invalid composition: region %d lies outside the %dx%d result", i, width, height)
+		}
+	}
+
+	plan := &storePlan{
+		image: &StoredImage{
+			ID:          id,
+			Width:       width,
+			Height:      height,
+			Metadata:    make(map[string]string),
+			ExpiresAt:   opts.ExpiresAt,
+			StoredAt:    storedNow(),
+			Background:  formatBackground(s.background),
+			StripHeight: s.stripHeightFor(Namespace(id)),
+		},
+		ifMatch: opts.IfMatch,
+	}
+	if err := planPrevious(plan, snapshot); err != nil {
+		return nil, nil, err
+	}
+
+	tileSize := s.config.TileSize
+	tileWidth, tileHeight := plan.image.tileDims(tileSize)
+	tilesX := (width + tileWidth - 1) / tileWidth
+	tilesY := (height + tileHeight - 1) / tileHeight
+
+	// Pick each tile's source: a reused tile reference or nil to render
+	tileRefs := make([]TileRef, tilesX*tilesY)
+	reused := make([]bool, len(tileRefs))
+	var rendered []image.Rectangle
+	for tileY := 0; tileY < tilesY; tileY++ {
+		for tileX := 0; tileX < tilesX; tileX++ {
+			i := tileY*tilesX + tileX
+			rect := image.Rect(tileX*tileWidth, tileY*tileHeight, (tileX+1)*tileWidth, (tileY+1)*tileHeight)
+			tileRefs[i] = TileRef{X: tileX, Y: tileY}
+
+			if tileRef, ok := alignedTile(rect, regions, sources, tileSize, s.tilePool(id)); ok && rect.In(bounds) {
+				tileRef.X, tileRef.Y = tileX, tileY
+				tileRefs[i] = tileRef
+				reused[i] = true
+				continue
+			}
+			rendered = append(rendered, rect.Intersect(bounds))
+		}
+	}
+
+	// Draw the regions any rendered tile needs, then cut those tiles out
+	tiles := make(map[TileID]Tile)
+	if len(rendered) > 0 {
+		canvas := image.NewRGBA(bounds)
+		for _, region := range regions {
+			dst := image.Rect(region.DstX, region.DstY, region.DstX+region.Width, region.DstY+region.Height)
+			if !overlapsAny(dst, rendered) {
+				continue
+			}
+			src, err := s.renderWindow(snapshot, sources[region.Source], image.Rect(region.X, region.Y, region.X+region.Width, region.Y+region.Height))
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to read image %s: %w", region.Source, err)
+			}
+			draw.Draw(canvas, dst, src, image.Pt(region.X, region.Y), draw.Src)
+		}
+
+		for i := range tileRefs {
+			if reused[i] {
+				continue
+			}
+			x0, y0 := tileRefs[i].X*tileWidth, tileRefs[i].Y*tileHeight
+			data := extractTileRect(canvas, x0, y0, min(x0+tileWidth, width), min(y0+tileHeight, height), tileWidth, tileHeight, s.background)
+			hash := ComputeTileHash(data)
+			tileRefs[i].TileID = GenerateTileID(hash)
+			tiles[tileRefs[i].TileID] = Tile{ID: tileRefs[i].TileID, Hash: hash, Data: data}
+		}
+	}
+
+	plan.image.TileRefs = make([]TileRef, len(tileRefs))
+	if err := s.planTiles(context.Background(), plan, snapshot, tileRefs, tiles); err != nil {
+		return nil, nil, err
+	}
+
+	if err := s.commitUpload(plan); err != nil {
+		return nil, nil, err
+	}
+	return &ComposeResult{
+		TilesReused:   len(tileRefs) - len(rendered),
+		TilesRendered: len(rendered),
+		NewTiles:      len(plan.newTiles),
+	}, plan, nil
+}
+
+// alignedTile finds the source tile for a destination tile whose topmost
+// region covers it whole at a tile-aligned offset in both images. The
+// source's tiles must have the shape of rect, so strips are only reused by
+// strips of the same size, and belong to pool.
+func alignedTile(rect image.Rectangle, regions []ComposeRegion, sources map[string]*StoredImage, tileSize int, pool string) (TileRef, bool) {
+	tileWidth, tileHeight := rect.Dx(), rect.Dy()
+	for i := len(regions) - 1; i >= 0; i-- {
+		region := regions[i]
+		dst := image.Rect(region.DstX, region.DstY, region.DstX+region.Width, region.DstY+region.Height)
+		if !dst.Overlaps(rect) {
+			continue
+		}
+		source := sources[region.Source]
+		if sourceWidth, sourceHeight := source.tileDims(tileSize); sourceWidth != tileWidth || sourceHeight != tileHeight {
+			return TileRef{}, false
+		}
+		if !rect.In(dst) || (region.X-region.DstX)%tileWidth != 0 || (region.Y-region.DstY+source.GridOffsetY)%tileHeight != 0 {
+			return TileRef{}, false
+		}
+
+		// The source tile must be whole, not padded at the image edge
+		srcX, srcY := rect.Min.X+region.X-region.DstX, rect.Min.Y+region.Y-region.DstY
+		if srcY < 0 || srcX+tileWidth > source.Width || srcY+tileHeight > source.Height {
+			return TileRef{}, false
+		}
+		tilesX, _ := imageGrid(source, tileSize)
+		row := (srcY + source.GridOffsetY) / tileHeight
+		index := row*tilesX + srcX/tileWidth
+		if index >= len(source.TileRefs) {
+			return TileRef{}, false
+		}
+		tileRef := source.TileRefs[index]
+		if tileRef.X != srcX/tileWidth || tileRef.Y != row || tileIDPool(tileRef.TileID) != pool {
+			return TileRef{}, false
+		}
+		return tileRef, true
+	}
+	return TileRef{}, false
+}
+
+// overlapsAny reports whether rect overlaps any of rects
+func overlapsAny(rect image.Rectangle, rects []image.Rectangle) bool {
+	for _, other := range rects {
+		if rect.Overlaps(other) {
+			return true
+		}
+	}
+	return false
+}
+
+// renderWindow decodes the tiles of a stored image covering window into an
+// image whose bounds are the tile-aligned window. With a grid offset, the
+// window can start above the image.
+func (s *PebbleImageStore) renderWindow(reader pebble.Reader, storedImage *StoredImage, window image.Rectangle) (*image.RGBA, error) {
+	tileSize := s.config.TileSize
+	tileWidth, tileHeight := storedImage.tileDims(tileSize)
+	offset := storedImage.GridOffsetY
+	aligned := image.Rect(
+		window.Min.X/tileWidth*tileWidth, (window.Min.Y+offset)/tileHeight*tileHeight-offset,
+		min((window.Max.X+tileWidth-1)/tileWidth*tileWidth, storedImage.Width),
+		min((window.Max.Y+offset+tileHeight-1)/tileHeight*tileHeight-offset, storedImage.Height),
+	)
+
+	var refs []TileRef
+	for _, tileRef := range storedImage.TileRefs {
+		if storedImage.tileOrigin(tileRef, tileSize).In(aligned) {
+			refs = append(refs, tileRef)
+		}
+	}
+	stored, err := s.getTilesFrom(context.Background(), reader, refs)
+	if err != nil {
+		return nil, err
+	}
+
+	img := image.NewRGBA(aligned)
+	for _, tileRef := range refs {
+		data := stored[tileRef.TileID]
+		if !tileRef.Transform.IsIdentity() {
+			data = tileRef.Transform.Apply(data)
+		}
+		origin := storedImage.tileOrigin(tileRef, tileSize)
+		if err := storedImage.placeTile(img, data, origin, tileSize); err != nil {
+			return nil, err
+		}
+	}
+	return img, nil
+}
@@ -0,0 +1,79 @@
+package imagestore
+
+import (
+	"image/color"
+	"strings"
+	"testing"
+)
+
+func TestComposeImage(t *testing.T) {
+	store := newTagsTestStore(t, "top", "bottom")
+	original := createTestImage(8, 8)
+
+	checkPixels := func(id string, width, height int, at func(x, y int) color.Color) {
+		t.Helper()
+		data, err := store.RetrieveImage(id)
+		if err != nil {
+			t.Fatalf("failed to retrieve %s: %v", id, err)
+		}
+		img, err := decodeImageFromBytes(data)
+		if err != nil {
+			t.Fatalf("failed to decode %s: %v", id, err)
+		}
+		if img.Bounds().Dx() != width || img.Bounds().Dy() != height {
+			t.Fatalf("%s is %v, want %dx%d", id, img.Bounds(), width, height)
+		}
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				want := color.RGBAModel.Convert(at(x, y))
+				if got := color.RGBAModel.Convert(img.At(x, y)); got != want {
+					t.Fatalf("%s pixel (%d, %d) = %v, want %v", id, x, y, got, want)
+				}
+			}
+		}
+	}
+
+	// Stacking two whole images on the tile grid reuses every tile
+	before := store.GetStorageStats()
+	result, err := store.ComposeImage("stitched", 8, 16, []ComposeRegion{
+		{Source: "top", Width: 8, Height: 8},
+		{Source: "bottom", Width: 8, Height: 8, DstY: 8},
+	}, StoreOptions{})
+	if err != nil {
+		t.Fatalf("failed to compose image: %v", err)
+	}
+	if result.TilesReused != 8 || result.TilesRendered != 0 || result.NewTiles != 0 {
+		t.Errorf("expected every tile reused, got %+v", result)
+	}
+	if after := store.GetStorageStats(); after.UniqueTiles != before.UniqueTiles {
+		t.Errorf("expected no new tiles, got %d -> %d", before.UniqueTiles, after.UniqueTiles)
+	}
+	checkPixels("stitched", 8, 16, func(x, y int) color.Color { return original.At(x, y%8) })
+
+	// An unaligned crop is rendered, leaving uncovered pixels black
+	result, err = store.ComposeImage("cropped", 8, 8, []ComposeRegion{
+		{Source: "top", X: 1, Y: 2, Width: 5, Height: 6},
+	}, StoreOptions{})
+	if err != nil {
+		t.Fatalf("failed to compose image: %v", err)
+	}
+	if result.TilesReused != 0 || result.TilesRendered != 4 {
+		t.Errorf("expected every tile rendered, got %+v", result)
+	}
+	checkPixels("cropped", 8, 8, func(x, y int) color.Color {
+		if x >= 5 || y >= 6 {
+			return color.RGBA{0, 0, 0, 255}
+		}
+		return original.At(x+1, y+2)
+	})
+
+	if _, err := store.ComposeImage("bad", 8, 8, []ComposeRegion{{Source: "top", X: 4, Width: 8, Height: 8}}, StoreOptions{}); err == nil || !strings.Contains(err.Error(), "invalid composition") {
+		t.Errorf("expected invalid composition, got %v", err)
+	}
+	if _, err := store.ComposeImage("bad", 4, 4, []ComposeRegion{{Source: "top", Width: 8, Height: 8}}, StoreOptions{}); err == nil || !strings.Contains(err.Error(), "invalid composition") {
+		t.Errorf("expected invalid composition, got %v", err)
+	}
+	if _, err := store.ComposeImage("bad", 8, 8, []ComposeRegion{{Source: "missing", Width: 4, Height: 4}}, StoreOptions{}); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("expected not found, got %v", err)
+	}
+}
@@ -0,0 +1,279 @@
+package imagestore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/DataDog/zstd"
+	"github.com/cockroachdb/pebble"
+)
+
+// Named zstd compression levels accepted in Config
+const (
+	CompressionFastest = "fastest"
+	CompressionDefault = "default"
+	CompressionBetter  = "better"
+	CompressionBest    = "best"
+)
+
+// compressionLevels maps level names to zstd levels
+var compressionLevels = map[string]int{
+	CompressionFastest: zstd.BestSpeed,
+	CompressionDefault: zstd.DefaultCompression,
+	CompressionBetter:  9,
+	CompressionBest:    zstd.BestCompression,
+}
+
+// ParseCompressionLevel converts a level name to a zstd level; an empty name
+// selects the default level
+func ParseCompressionLevel(name string) (int, error) {
+	if name == "" {
+		return zstd.DefaultCompression, nil
+	}
+	level, ok := compressionLevels[name]
+	if !ok {
+		return 0, fmt.Errorf("invalid compression level: %s", name)
+	}
+	return level, nil
+}
+
+// CompressionBenchmark reports how a sample of tiles compresses at one level
+type CompressionBenchmark struct {
+	Level            string
+	Tiles            int
+	UncompressedSize int64
+	CompressedSize   int64
+	Ratio            float64
+	Duration         time.Duration
+}
+
+// BenchmarkCompressionLevels recompresses a random sample of the store's own
+// tiles at every named level and reports the resulting sizes and timings
+func (s *PebbleImageStore) BenchmarkCompressionLevels(sampleSize int) ([]CompressionBenchmark, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+
+	var tileKeys [][]byte
+
+	prefix := makePrefixKey(tilesBucket)
+	iter, err := s.db.NewIter(&pebble.IterOptions{
+		LowerBound: prefix,
+		UpperBound: prefixUpperBound(prefix),
+	})
+	if err != nil {
+		return nil, err
+	}
+	for iter.First(); iter.Valid(); iter.Next() {
+		tileKeys = append(tileKeys, append([]byte(nil), iter.Key()...))
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+
+	if sampleSize > 0 && len(tileKeys) > sampleSize {
+		rand.Shuffle(len(tileKeys), func(i, j int) {
+			tileKeys[i], tileKeys[j] = tileKeys[j], tileKeys[i]
+		})
+		tileKeys = tileKeys[:sampleSize]
+	}
+
+	var sample [][]byte
+	for _, key := range tileKeys {
+		data, err := s.getTileData(tileIDFromKey(key))
+		if err != nil {
+			return nil, err
+		}
+		sample = append(sample, data)
+	}
+
+	var results []CompressionBenchmark
+	for _, name := range []string{CompressionFastest, CompressionDefault, CompressionBetter, CompressionBest} {
+		result := CompressionBenchmark{Level: name, Tiles: len(sample)}
+
+		start := time.Now()
+		for _, data := range sample {
+			compressed, err := s.compressTileDataLevel(data, compressionLevels[name])
+			if err != nil {
+				return nil, err
+			}
+			result.UncompressedSize += int64(len(data))
+			result.CompressedSize += int64(len(compressed))
+		}
+		result.Duration = time.Since(start)
+
+		if result.CompressedSize > 0 {
+			result.Ratio = float64(result.UncompressedSize) / float64(result.CompressedSize)
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// recompressCommitHook, when set by tests, runs between reading a chunk of
+// tiles and committing their recompressed values
+var recompressCommitHook func()
+
+// RecompressTiles rewrites every stored tile at the compaction compression
+// level, returning the number of tiles rewritten and the bytes saved. Tiles
+// are committed in chunks, pacing between them like PurgeOrphanedTiles.
+// Each chunk commits under gcMu and skips tiles collected or rewritten
+// since they were read.
+func (s *PebbleImageStore) RecompressTiles() (int, int64, error) {
+	if err := s.enter(); err != nil {
+		return 0, 0, err
+	}
+	defer s.leave()
+
+	job := s.startJob(JobRecompression, 0)
+	rewritten, saved, err := s.recompressTiles(job)
+	job.finish(err)
+	return rewritten, saved, err
+}
+
+func (s *PebbleImageStore) recompressTiles(job *maintenanceJob) (int, int64, error) {
+	prefix := makePrefixKey(tilesBucket)
+	iter, err := s.db.NewIter(&pebble.IterOptions{
+		LowerBound: prefix,
+		UpperBound: prefixUpperBound(prefix),
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	defer iter.Close()
+
+	rewritten := 0
+	var saved int64
+	var examined, chunkBytes int64
+	var keys, originals, values [][]byte
+	var savings []int64
+	commit := func(last bool) error {
+		if recompressCommitHook != nil {
+			recompressCommitHook()
+		}
+		written, chunkSaved, err := s.commitRecompressed(keys, originals, values, savings)
+		if err != nil {
+			return err
+		}
+		rewritten += written
+		saved += chunkSaved
+		keys, originals, values, savings = nil, nil, nil, nil
+
+		job.advance(examined, int64(written), chunkBytes)
+		if !last {
+			job.pace(chunkBytes)
+		}
+		examined, chunkBytes = 0, 0
+		return nil
+	}
+
+	for iter.First(); iter.Valid(); iter.Next() {
+		if examined >= maintenanceChunk {
+			if err := commit(false); err != nil {
+				return 0, 0, err
+			}
+		}
+		examined++
+
+		if isColdStub(iter.Value()) {
+			continue // Only the cold store holds the payload
+		}
+		original := iter.Value()
+		stored := original
+		if isBlobPointer(stored) {
+			if stored, err = s.readBlobTile(tileIDFromKey(iter.Key()), stored); err != nil {
+				return 0, 0, err
+			}
+		}
+		chunkBytes += int64(len(stored))
+
+		data, err := s.decompressTileData(stored)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to decompress tile %s: %w", tileIDFromKey(iter.Key()), err)
+		}
+
+		compressed, err := s.compressTileDataLevel(data, s.compactionLevel)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		if len(compressed) >= len(stored) {
+			continue
+		}
+
+		chunkBytes += int64(len(compressed))
+		keys = append(keys, append([]byte(nil), iter.Key()...))
+		originals = append(originals, append([]byte(nil), original...))
+		values = append(values, compressed)
+		savings = append(savings, int64(len(stored)-len(compressed)))
+	}
+	if err := iter.Error(); err != nil {
+		return 0, 0, err
+	}
+	if err := commit(true); err != nil {
+		return 0, 0, err
+	}
+
+	// Rewriting every tile leaves Pebble's caches cold
+	if len(s.config.Resources.Preload) > 0 {
+		s.startPreload()
+	}
+
+	return rewritten, saved, nil
+}
+
+// commitRecompressed writes the recompressed values of tiles whose stored
+// values still match originals, returning the number written and the sum of
+// their savings. Holding gcMu keeps collection from deleting a tile between the
+// check and the write, which would bring it back unreferenced.
+func (s *PebbleImageStore) commitRecompressed(keys, originals, values [][]byte, savings []int64) (int, int64, error) {
+	s.gcMu.RLock()
+	defer s.gcMu.RUnlock()
+
+	var current, replacements [][]byte
+	var saved int64
+	for i, key := range keys {
+		value, closer, err := s.db.Get(key)
+		if errors.Is(err, pebble.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+		unchanged := bytes.Equal(value, originals[i])
+		closer.Close()
+		if !unchanged {
+			continue
+		}
+
+		saved += savings[i]
+		current = append(current, key)
+		replacements = append(replacements, values[i])
+	}
+	if len(current) == 0 {
+		return 0, 0, nil
+	}
+
+	release, err := s.separateBlobs(replacements)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer release()
+
+	batch := s.db.NewBatch()
+	defer batch.Close()
+	for i, key := range current {
+		if err := batch.Set(key, replacements[i], pebble.Sync); err != nil {
+			return 0, 0, err
+		}
+	}
+	if err := batch.Commit(s.writeOpts); err != nil {
+		return 0, 0, fmt.Errorf("failed to commit recompressed tiles: %w", err)
+	}
+	return len(current), saved, nil
+}
@@ -0,0 +1,184 @@
+package imagestore
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/DataDog/zstd"
+)
+
+func TestParseCompressionLevel(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected int
+		wantErr  bool
+	}{
+		{"", zstd.DefaultCompression, false},
+		{CompressionFastest, zstd.BestSpeed, false},
+		{CompressionDefault, zstd.DefaultCompression, false},
+		{CompressionBetter, 9, false},
+		{CompressionBest, zstd.BestCompression, false},
+		{"ludicrous", 0, true},
+	}
+
+	for _, tt := range tests {
+		level, err := ParseCompressionLevel(tt.name)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseCompressionLevel(%q) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if level != tt.expected {
+			t.Errorf("ParseCompressionLevel(%q) = %d, expected %d", tt.name, level, tt.expected)
+		}
+	}
+}
+
+func TestNewPebbleImageStoreInvalidCompressionLevel(t *testing.T) {
+	config := DefaultConfig()
+	config.DatabasePath = filepath.Join(t.TempDir(), "test.db")
+	config.CompressionLevel = "ludicrous"
+
+	store, err := NewPebbleImageStore(config)
+	if err == nil {
+		store.Close()
+		t.Fatal("expected error for invalid compression level")
+	}
+}
+
+func TestRecompressTilesPreservesImages(t *testing.T) {
+	config := DefaultConfig()
+	config.DatabasePath = filepath.Join(t.TempDir(), "test.db")
+	config.TileSize = 16
+	config.CompressionLevel = CompressionFastest
+	config.CompactionLevel = CompressionBest
+
+	store, err := NewPebbleImageStore(config)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	img := createTestImage(32, 32)
+	imageData, err := encodeImageToPNG(img)
+	if err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+
+	if err := store.StoreImage("recompress", imageData); err != nil {
+		t.Fatalf("failed to store image: %v", err)
+	}
+
+	before := store.GetStorageStats()
+
+	if _, _, err := store.RecompressTiles(); err != nil {
+		t.Fatalf("failed to recompress tiles: %v", err)
+	}
+
+	after := store.GetStorageStats()
+	if after.StorageBytes > before.StorageBytes {
+		t.Errorf("recompression grew storage from %d to %d bytes", before.StorageBytes, after.StorageBytes)
+	}
+
+	retrievedData, err := store.RetrieveImage("recompress")
+	if err != nil {
+		t.Fatalf("failed to retrieve image: %v", err)
+	}
+
+	retrieved, err := decodeImageFromBytes(retrievedData)
+	if err != nil {
+		t.Fatalf("failed to decode retrieved image: %v", err)
+	}
+
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 32; x++ {
+			if img.At(x, y) != retrieved.At(x, y) {
+				t.Fatalf("pixel (%d,%d) changed after recompression", x, y)
+			}
+		}
+	}
+}
+
+func TestRecompressTilesSkipsCollectedTiles(t *testing.T) {
+	config := DefaultConfig()
+	config.DatabasePath = filepath.Join(t.TempDir(), "test.db")
+	config.TileSize = 16
+	config.CompressionLevel = CompressionFastest
+	config.CompactionLevel = CompressionBest
+	config.TrashRetention = 0
+
+	store, err := NewPebbleImageStore(config)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	imageData, err := encodeImageToPNG(createTestImage(32, 32))
+	if err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	if err := store.StoreImage("recompress", imageData); err != nil {
+		t.Fatalf("failed to store image: %v", err)
+	}
+
+	// Collect every tile after recompression has read them
+	recompressCommitHook = func() {
+		recompressCommitHook = nil
+		if err := store.DeleteImage("recompress"); err != nil {
+			t.Errorf("failed to delete image: %v", err)
+		}
+		if _, err := store.PurgeOrphanedTiles(); err != nil {
+			t.Errorf("failed to purge orphaned tiles: %v", err)
+		}
+	}
+	defer func() { recompressCommitHook = nil }()
+
+	rewritten, _, err := store.RecompressTiles()
+	if err != nil {
+		t.Fatalf("failed to recompress tiles: %v", err)
+	}
+	if rewritten != 0 {
+		t.Errorf("expected no collected tiles rewritten, got %d", rewritten)
+	}
+	if stats := store.GetStorageStats(); stats.UniqueTiles != 0 {
+		t.Errorf("expected no tiles left after collection, got %d", stats.UniqueTiles)
+	}
+}
+
+func TestBenchmarkCompressionLevels(t *testing.T) {
+	config := DefaultConfig()
+	config.DatabasePath = filepath.Join(t.TempDir(), "test.db")
+	config.TileSize = 4
+
+	store, err := NewPebbleImageStore(config)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	imageData, err := encodeImageToPNG(createTestImage(8, 8))
+	if err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+
+	if err := store.StoreImage("bench", imageData); err != nil {
+		t.Fatalf("failed to store image: %v", err)
+	}
+
+	results, err := store.BenchmarkCompressionLevels(2)
+	if err != nil {
+		t.Fatalf("failed to benchmark compression levels: %v", err)
+	}
+
+	if len(results) != 4 {
+		t.Fatalf("expected results for 4 levels, got %d", len(results))
+	}
+
+	for _, result := range results {
+		if result.Tiles != 2 {
+			t.Errorf("level %s: expected 2 sampled tiles, got %d", result.Level, result.Tiles)
+		}
+		if result.UncompressedSize != 2*4*4*3 {
+			t.Errorf("level %s: expected %d uncompressed bytes, got %d", result.Level, 2*4*4*3, result.UncompressedSize)
+		}
+	}
+}
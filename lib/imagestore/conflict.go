@@ -0,0 +1,130 @@
+package imagestore
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Conflict policies accepted in Config.OnConflict, deciding what an upload
+// to an ID that already holds a live image does
+const (
+	ConflictOverwrite   = "overwrite"      // Replace it, leaving unreferenced tiles to the next garbage collection
+	ConflictOverwriteGC = "overwrite-gc"   // Replace it and delete tiles only the replaced version used
+	ConflictReject      = "reject"         // Fail with ErrImageExists, unless the content is identical
+	ConflictSkip        = "skip-identical" // Keep it if the content is identical, otherwise replace it
+)
+
+// ErrImageExists is returned by uploads to an existing ID under the reject
+// conflict policy
+var ErrImageExists = errors.New("image already exists")
+
+// validateConflictPolicy checks Config.OnConflict
+func validateConflictPolicy(policy string) error {
+	switch policy {
+	case "", ConflictOverwrite, ConflictOverwriteGC, ConflictReject, ConflictSkip:
+		return nil
+	}
+	return fmt.Errorf("unknown conflict policy: %s", policy)
+}
+
+// uploadCommitHook, when set by tests, runs between planning an upload and
+// committing it
+var uploadCommitHook func(id string)
+
+// commitUpload commits a planned upload according to the conflict policy.
+// Identical re-uploads under the reject and skip policies leave the stored
+// image, including its metadata and expiry, untouched, so retries are safe.
+// A skipped upload still fails if its If-Match precondition does.
+func (s *PebbleImageStore) commitUpload(plan *storePlan) error {
+	if uploadCommitHook != nil {
+		uploadCommitHook(plan.image.ID)
+	}
+	plan.onConflict = s.config.OnConflict
+	return s.commitPlan(plan)
+}
+
+// resolveConflict applies a plan's conflict policy to the version it
+// replaces, reporting whether the commit is to be skipped. The caller must
+// hold the image's version lock and have refreshed plan.previous under it,
+// so two uploads racing to create an ID can't both pass.
+func resolveConflict(plan *storePlan) (bool, error) {
+	if plan.previous == nil {
+		return false, nil
+	}
+	identical := sameContent(plan.previous, plan.image)
+	switch plan.onConflict {
+	case ConflictReject:
+		if !identical {
+			return false, fmt.Errorf("%w: %s", ErrImageExists, plan.image.ID)
+		}
+		return true, nil
+	case ConflictSkip:
+		return identical, nil
+	}
+	return false, nil
+}
+
+// sameContent reports whether two manifests describe the same pixels with
+// the same tiles
+func sameContent(a, b *StoredImage) bool {
+	if a.Width != b.Width || a.Height != b.Height || a.Background != b.Background || len(a.TileRefs) != len(b.TileRefs) {
+		return false
+	}
+	for i := range a.TileRefs {
+		x, y := a.TileRefs[i], b.TileRefs[i]
+		if x.X != y.X || x.Y != y.Y || x.TileID != y.TileID || x.Transform != y.Transform {
+			return false
+		}
+	}
+	return true
+}
+
+// collectReplaced deletes, under the overwrite-gc policy, the tiles a
+// committed upload's replaced version used that no live or trashed image
+// references any more. It takes the garbage collection lock, so the caller
+// must not hold gcMu.
+func (s *PebbleImageStore) collectReplaced(plan *storePlan) error {
+	if s.config.OnConflict != ConflictOverwriteGC || plan.previous == nil {
+		return nil
+	}
+
+	candidates := make(map[TileID]bool)
+	for _, tileRef := range plan.previous.TileRefs {
+		candidates[tileRef.TileID] = true
+	}
+	for _, tileRef := range plan.image.TileRefs {
+		delete(candidates, tileRef.TileID)
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	s.gcMu.Lock()
+	defer s.gcMu.Unlock()
+
+	referenced, err := referencedTiles(s.db, imagesBucket, trashBucket)
+	if err != nil {
+		return err
+	}
+
+	batch := s.db.NewBatch()
+	defer batch.Close()
+
+	var purged []TileID
+	for tileID := range candidates {
+		if referenced[tileID] {
+			continue
+		}
+		if err := batch.Delete(tileKey(tileID), nil); err != nil {
+			return err
+		}
+		purged = append(purged, tileID)
+	}
+	if len(purged) == 0 {
+		return nil
+	}
+	if err := batch.Commit(s.writeOpts); err != nil {
+		return fmt.Errorf("failed to delete replaced tiles: %w", err)
+	}
+	return s.deleteColdCopies(purged)
+}
@@ -0,0 +1,278 @@
+package imagestore
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func newConflictTestStore(t *testing.T, policy string) *PebbleImageStore {
+	t.Helper()
+
+	config := DefaultConfig()
+	config.DatabasePath = filepath.Join(t.TempDir(), "test.db")
+	config.TileSize = 4
+	config.OnConflict = policy
+
+	store, err := NewPebbleImageStore(config)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+// conflictTestImages returns two encoded 8x8 images with no tiles in common
+func conflictTestImages(t *testing.T) ([]byte, []byte) {
+	t.Helper()
+
+	first, err := encodeImageToPNG(createTestImage(8, 8))
+	if err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+	solid := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for i := range solid.Pix {
+		solid.Pix[i] = 200
+	}
+	solid.Set(0, 0, color.RGBA{1, 2, 3, 255})
+	second, err := encodeImageToPNG(solid)
+	if err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+	return first, second
+}
+
+func TestConflictReject(t *testing.T) {
+	store := newConflictTestStore(t, ConflictReject)
+	first, second := conflictTestImages(t)
+
+	if err := store.StoreImage("shot", first); err != nil {
+		t.Fatalf("failed to store image: %v", err)
+	}
+	before, _ := store.getStoredImage("shot")
+
+	// Retrying the same upload succeeds without rewriting anything
+	if err := store.StoreImage("shot", first); err != nil {
+		t.Errorf("expected identical re-upload to succeed, got %v", err)
+	}
+	if err := store.StoreImage("shot", second); !errors.Is(err, ErrImageExists) {
+		t.Errorf("expected ErrImageExists, got %v", err)
+	}
+
+	after, _ := store.getStoredImage("shot")
+	if !sameContent(before, after) || !after.StoredAt.Equal(*before.StoredAt) {
+		t.Error("expected the stored image to be untouched")
+	}
+
+	// Manifest uploads follow the same policy
+	tiles, _, err := ExtractTiles(createTestImage(8, 8), 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := ImageManifest{Width: 8, Height: 8, TileSize: 4}
+	for _, tile := range tiles {
+		manifest.Tiles = append(manifest.Tiles, tile.ID)
+	}
+	manifest.Tiles[0], manifest.Tiles[1] = manifest.Tiles[1], manifest.Tiles[0]
+	if err := store.StoreManifest("shot", manifest, nil, StoreOptions{}); !errors.Is(err, ErrImageExists) {
+		t.Errorf("expected ErrImageExists from a manifest upload, got %v", err)
+	}
+}
+
+func TestConflictRejectConcurrentCreate(t *testing.T) {
+	store := newConflictTestStore(t, ConflictReject)
+
+	// Racing uploads of different images to a new ID, all planned before
+	// any commits: exactly one creates it and the others are rejected
+	// rather than overwriting it
+	uploads := make([][]byte, 8)
+	for i := range uploads {
+		img := createTestImage(8, 8).(*image.RGBA)
+		img.Set(0, 0, color.RGBA{uint8(i), 0, 0, 255})
+		data, err := encodeImageToPNG(img)
+		if err != nil {
+			t.Fatalf("failed to encode image: %v", err)
+		}
+		uploads[i] = data
+	}
+
+	var planned sync.WaitGroup
+	planned.Add(len(uploads))
+	uploadCommitHook = func(string) {
+		planned.Done()
+		planned.Wait()
+	}
+	defer func() { uploadCommitHook = nil }()
+
+	errs := make([]error, len(uploads))
+	var wg sync.WaitGroup
+	for i, data := range uploads {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = store.StoreImage("shot", data)
+		}()
+	}
+	wg.Wait()
+
+	winner := -1
+	for i, err := range errs {
+		switch {
+		case err == nil && winner >= 0:
+			t.Fatalf("uploads %d and %d both succeeded", winner, i)
+		case err == nil:
+			winner = i
+		case !errors.Is(err, ErrImageExists):
+			t.Errorf("expected ErrImageExists, got %v", err)
+		}
+	}
+	if winner < 0 {
+		t.Fatal("expected one upload to succeed")
+	}
+
+	img, _ := decodeImageFromBytes(mustRetrieve(t, store, "shot"))
+	if r, _, _, _ := img.At(0, 0).RGBA(); int(r>>8) != winner {
+		t.Errorf("expected the successful upload %d to be stored, got pixel %d", winner, r>>8)
+	}
+}
+
+// conflictTestAnimation returns an encoded two-frame 8x8 GIF filled with
+// the given palette colors
+func conflictTestAnimation(t *testing.T, colors ...color.Color) []byte {
+	t.Helper()
+
+	animation := &gif.GIF{}
+	for i := range colors {
+		frame := image.NewPaletted(image.Rect(0, 0, 8, 8), colors)
+		for j := range frame.Pix {
+			frame.Pix[j] = uint8(i)
+		}
+		animation.Image = append(animation.Image, frame)
+		animation.Delay = append(animation.Delay, 10)
+	}
+	var encoded bytes.Buffer
+	if err := gif.EncodeAll(&encoded, animation); err != nil {
+		t.Fatalf("failed to encode GIF: %v", err)
+	}
+	return encoded.Bytes()
+}
+
+func TestConflictRejectAnimation(t *testing.T) {
+	store := newConflictTestStore(t, ConflictReject)
+	first, _ := conflictTestImages(t)
+
+	if err := store.StoreImage("loader", first); err != nil {
+		t.Fatalf("failed to store image: %v", err)
+	}
+	animation := conflictTestAnimation(t, color.RGBA{255, 0, 0, 255}, color.RGBA{0, 0, 255, 255})
+	if _, err := store.StoreAnimation("loader", animation, StoreOptions{}); !errors.Is(err, ErrImageExists) {
+		t.Fatalf("expected ErrImageExists, got %v", err)
+	}
+	if _, err := store.getStoredImage(AnimationFrameID("loader", 0)); err == nil {
+		t.Error("expected a rejected animation to store no frames")
+	}
+
+	// Storing the same animation again is an identical re-upload
+	if _, err := store.StoreAnimation("clip", animation, StoreOptions{}); err != nil {
+		t.Fatalf("failed to store animation: %v", err)
+	}
+	if _, err := store.StoreAnimation("clip", animation, StoreOptions{}); err != nil {
+		t.Errorf("expected identical re-upload to succeed, got %v", err)
+	}
+}
+
+func TestConflictOverwriteAnimationCollectsTiles(t *testing.T) {
+	store := newConflictTestStore(t, ConflictOverwriteGC)
+
+	red, blue, green := color.RGBA{255, 0, 0, 255}, color.RGBA{0, 0, 255, 255}, color.RGBA{0, 255, 0, 255}
+	if _, err := store.StoreAnimation("loader", conflictTestAnimation(t, red, blue), StoreOptions{}); err != nil {
+		t.Fatalf("failed to store animation: %v", err)
+	}
+	if _, err := store.StoreAnimation("loader", conflictTestAnimation(t, green, green), StoreOptions{}); err != nil {
+		t.Fatalf("failed to replace animation: %v", err)
+	}
+
+	report, err := store.FindOrphanedTiles()
+	if err != nil {
+		t.Fatalf("failed to find orphans: %v", err)
+	}
+	if report.Orphaned != 0 || report.TotalTiles != 1 {
+		t.Errorf("expected only the green tile to remain, found %d tiles and %d orphans", report.TotalTiles, report.Orphaned)
+	}
+}
+
+func TestConflictSkipIdentical(t *testing.T) {
+	store := newConflictTestStore(t, ConflictSkip)
+	first, second := conflictTestImages(t)
+
+	if err := store.StoreImage("shot", first); err != nil {
+		t.Fatalf("failed to store image: %v", err)
+	}
+	before, _ := store.getStoredImage("shot")
+
+	if err := store.StoreImage("shot", first); err != nil {
+		t.Fatalf("expected identical re-upload to succeed, got %v", err)
+	}
+	after, _ := store.getStoredImage("shot")
+	if !after.StoredAt.Equal(*before.StoredAt) {
+		t.Error("expected identical re-upload to be a no-op")
+	}
+
+	if err := store.StoreImage("shot", second); err != nil {
+		t.Fatalf("expected changed upload to overwrite, got %v", err)
+	}
+	after, _ = store.getStoredImage("shot")
+	if sameContent(before, after) {
+		t.Error("expected changed upload to replace the image")
+	}
+}
+
+func TestConflictOverwriteCollectsReplacedTiles(t *testing.T) {
+	store := newConflictTestStore(t, ConflictOverwriteGC)
+	first, second := conflictTestImages(t)
+
+	for _, id := range []string{"a", "b"} {
+		if err := store.StoreImage(id, first); err != nil {
+			t.Fatalf("failed to store image: %v", err)
+		}
+	}
+
+	// "b" still uses the replaced tiles, so they stay
+	if err := store.StoreImage("a", second); err != nil {
+		t.Fatalf("failed to overwrite image: %v", err)
+	}
+	if _, err := store.RetrieveImage("b"); err != nil {
+		t.Fatalf("expected shared tiles to survive: %v", err)
+	}
+
+	// Once nothing uses them they are deleted with the overwrite
+	if err := store.StoreImage("b", second); err != nil {
+		t.Fatalf("failed to overwrite image: %v", err)
+	}
+	report, err := store.FindOrphanedTiles()
+	if err != nil {
+		t.Fatalf("failed to find orphans: %v", err)
+	}
+	if report.Orphaned != 0 {
+		t.Errorf("expected replaced tiles to be collected, found %d orphans", report.Orphaned)
+	}
+	if report.TotalTiles != 2 {
+		t.Errorf("expected only the second image's 2 distinct tiles to remain, found %d", report.TotalTiles)
+	}
+}
+
+func TestUnknownConflictPolicy(t *testing.T) {
+	config := DefaultConfig()
+	config.DatabasePath = filepath.Join(t.TempDir(), "test.db")
+	config.OnConflict = "merge"
+
+	if store, err := NewPebbleImageStore(config); err == nil {
+		store.Close()
+		t.Error("expected an unknown conflict policy to be rejected")
+	}
+}
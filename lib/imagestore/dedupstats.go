@@ -0,0 +1,128 @@
+package imagestore
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// DedupGrouping selects how DedupBreakdown groups images
+type DedupGrouping string
+
+const (
+	DedupByPrefix DedupGrouping = "prefix" // Leading "/"-separated segments of the ID
+	DedupByTag    DedupGrouping = "tag"    // Each tag of the image
+)
+
+// DedupStats reports how the tiles of a group of images were stored.
+// Percentages are of TotalTiles, like the store-wide ones in StorageStats.
+type DedupStats struct {
+	Images              int
+	TotalTiles          int
+	DirectTiles         int
+	DeduplicatedTiles   int
+	DirectPercent       float64
+	DeduplicatedPercent float64
+	OriginalBytes       int64
+}
+
+// add counts the tile references of an image in the group
+func (d *DedupStats) add(tileRefs []TileRef) {
+	for _, tileRef := range tileRefs {
+		d.TotalTiles++
+		switch tileRef.StorageType {
+		case StorageUnique:
+			d.DirectTiles++
+		case StorageDuplicate:
+			d.DeduplicatedTiles++
+		}
+	}
+}
+
+// DedupPrefix returns the first depth segments of an image ID, leaving out
+// its last segment: with depth 1 it is the ID's namespace, and "" for IDs
+// without a "/"
+func DedupPrefix(id string, depth int) string {
+	segments := strings.Split(id, "/")
+	return strings.Join(segments[:min(depth, len(segments)-1)], "/")
+}
+
+// DedupBreakdown reports the deduplication of live images grouped by ID
+// prefix of up to depth segments, or by tag, so the images that benefit
+// most from sharing tiles stand out. Grouped by tag, an image counts
+// towards each of its tags, and untagged images are grouped under "".
+func (s *PebbleImageStore) DedupBreakdown(grouping DedupGrouping, depth int) (map[string]DedupStats, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+
+	switch {
+	case grouping != DedupByPrefix && grouping != DedupByTag:
+		return nil, fmt.Errorf("unknown dedup grouping: %q", grouping)
+	case grouping == DedupByPrefix && depth <= 0:
+		return nil, fmt.Errorf("prefix depth must be positive: %d", depth)
+	}
+
+	snapshot := s.db.NewSnapshot()
+	defer snapshot.Close()
+
+	prefix := makePrefixKey(imagesBucket)
+	iter, err := snapshot.NewIter(&pebble.IterOptions{
+		LowerBound: prefix,
+		UpperBound: prefixUpperBound(prefix),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	groups := make(map[string]*DedupStats)
+	for iter.First(); iter.Valid(); iter.Next() {
+		var keys []string
+		err := forEachManifestSegment(iter.Value(), func(storedImage *StoredImage, tileRefs []TileRef) error {
+			if keys == nil {
+				keys = dedupGroups(storedImage, grouping, depth)
+				for _, key := range keys {
+					if groups[key] == nil {
+						groups[key] = &DedupStats{}
+					}
+					groups[key].Images++
+					groups[key].OriginalBytes += storedImage.OriginalBytes
+				}
+			}
+			for _, key := range keys {
+				groups[key].add(tileRefs)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal image: %w", err)
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]DedupStats, len(groups))
+	for key, group := range groups {
+		if group.TotalTiles > 0 {
+			group.DirectPercent = float64(group.DirectTiles) / float64(group.TotalTiles) * 100.0
+			group.DeduplicatedPercent = float64(group.DeduplicatedTiles) / float64(group.TotalTiles) * 100.0
+		}
+		result[key] = *group
+	}
+	return result, nil
+}
+
+// dedupGroups lists the groups an image belongs to
+func dedupGroups(storedImage *StoredImage, grouping DedupGrouping, depth int) []string {
+	if grouping == DedupByPrefix {
+		return []string{DedupPrefix(storedImage.ID, depth)}
+	}
+	if len(storedImage.Tags) == 0 {
+		return []string{""}
+	}
+	return storedImage.Tags
+}
@@ -0,0 +1,77 @@
+package imagestore
+
+import "testing"
+
+func TestDedupPrefix(t *testing.T) {
+	tests := []struct {
+		id       string
+		depth    int
+		expected string
+	}{
+		{"img", 1, ""},
+		{"team/img", 1, "team"},
+		{"team/img", 2, "team"},
+		{"team/suite/img", 1, "team"},
+		{"team/suite/img", 2, "team/suite"},
+		{"team/suite/page/img", 2, "team/suite"},
+	}
+	for _, tt := range tests {
+		if got := DedupPrefix(tt.id, tt.depth); got != tt.expected {
+			t.Errorf("DedupPrefix(%q, %d) = %q, expected %q", tt.id, tt.depth, got, tt.expected)
+		}
+	}
+}
+
+func TestDedupBreakdown(t *testing.T) {
+	// Every image has the same content, so only the first stores tiles
+	store := newTagsTestStore(t, "a", "team/suite/b", "team/suite/c", "team/other/d")
+	if err := store.AddTags("team/suite/b", "login", "smoke"); err != nil {
+		t.Fatalf("failed to tag image: %v", err)
+	}
+
+	groups, err := store.DedupBreakdown(DedupByPrefix, 1)
+	if err != nil {
+		t.Fatalf("failed to break down dedup: %v", err)
+	}
+	if len(groups) != 2 {
+		t.Fatalf("expected 2 namespaces, got %v", groups)
+	}
+	if root := groups[""]; root.Images != 1 || root.DirectTiles != root.TotalTiles || root.DirectPercent != 100 {
+		t.Errorf("expected the first image stored directly, got %+v", root)
+	}
+	team := groups["team"]
+	if team.Images != 3 || team.DeduplicatedTiles != team.TotalTiles || team.DeduplicatedPercent != 100 {
+		t.Errorf("expected the team images fully deduplicated, got %+v", team)
+	}
+
+	stats := store.GetStorageStats()
+	if total := groups[""].TotalTiles + team.TotalTiles; total != stats.TotalTiles {
+		t.Errorf("expected the groups to add up to %d tiles, got %d", stats.TotalTiles, total)
+	}
+	if bytes := groups[""].OriginalBytes + team.OriginalBytes; bytes != stats.OriginalBytes {
+		t.Errorf("expected the groups to add up to %d original bytes, got %d", stats.OriginalBytes, bytes)
+	}
+
+	groups, err = store.DedupBreakdown(DedupByPrefix, 2)
+	if err != nil {
+		t.Fatalf("failed to break down dedup: %v", err)
+	}
+	if groups["team/suite"].Images != 2 || groups["team/other"].Images != 1 {
+		t.Errorf("expected images grouped by suite, got %v", groups)
+	}
+
+	groups, err = store.DedupBreakdown(DedupByTag, 0)
+	if err != nil {
+		t.Fatalf("failed to break down dedup: %v", err)
+	}
+	if groups["login"].Images != 1 || groups["smoke"].Images != 1 || groups[""].Images != 3 {
+		t.Errorf("expected images grouped by tag, got %v", groups)
+	}
+
+	if _, err := store.DedupBreakdown(DedupByPrefix, 0); err == nil {
+		t.Error("expected an error for a zero prefix depth")
+	}
+	if _, err := store.DedupBreakdown("size", 1); err == nil {
+		t.Error("expected an error for an unknown grouping")
+	}
+}
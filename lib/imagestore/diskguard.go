@@ -0,0 +1,59 @@
+package imagestore
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ErrStorageFull is returned by writes while the database is over
+// Config.MaxDatabaseBytes or its volume is below Config.MinFreeDiskPercent.
+// Reads and deletions keep working, so space can be freed.
+var ErrStorageFull = errors.New("storage full")
+
+// diskCheckInterval bounds how often the disk guard measures the database
+// and its volume
+const diskCheckInterval = 5 * time.Second
+
+// diskGuard caches the outcome of the last disk space check
+type diskGuard struct {
+	mu      sync.Mutex
+	checked time.Time
+	err     error
+}
+
+// checkDiskSpace returns ErrStorageFull, saying which limit was reached,
+// while writes must be refused. Without limits configured it always
+// returns nil.
+func (s *PebbleImageStore) checkDiskSpace() error {
+	if s.config.MaxDatabaseBytes <= 0 && s.config.MinFreeDiskPercent <= 0 {
+		return nil
+	}
+
+	s.diskGuard.mu.Lock()
+	defer s.diskGuard.mu.Unlock()
+	if time.Since(s.diskGuard.checked) < diskCheckInterval {
+		return s.diskGuard.err
+	}
+	s.diskGuard.checked = time.Now()
+	s.diskGuard.err = nil
+
+	if limit := s.config.MaxDatabaseBytes; limit > 0 {
+		if size := s.diskBytes(); size >= limit {
+			s.diskGuard.err = fmt.Errorf("%w: database uses %d bytes of the %d allowed", ErrStorageFull, size, limit)
+			return s.diskGuard.err
+		}
+	}
+
+	if minimum := s.config.MinFreeDiskPercent; minimum > 0 {
+		free, total, ok := diskSpace(filepath.Clean(s.config.DatabasePath))
+		if ok && total > 0 {
+			if percent := 100 * float64(free) / float64(total); percent < minimum {
+				s.diskGuard.err = fmt.Errorf("%w: %.1f%% of the volume is free, below the %.1f%% minimum", ErrStorageFull, percent, minimum)
+			}
+		}
+	}
+	return s.diskGuard.err
+}
@@ -0,0 +1,64 @@
+package imagestore
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDiskGuard(t *testing.T) {
+	store := newTagsTestStore(t, "a", "b")
+	img, _ := encodeImageToPNG(createTestImage(8, 8))
+
+	// Under the limit writes go through
+	store.config.MaxDatabaseBytes = 1 << 40
+	if err := store.StoreImage("c", img); err != nil {
+		t.Fatalf("expected a store under the limit, got %v", err)
+	}
+
+	// Over it they're refused with the reason, and the stats say why
+	store.config.MaxDatabaseBytes = 1
+	store.diskGuard.checked = time.Time{}
+	if err := store.StoreImage("d", img); !errors.Is(err, ErrStorageFull) {
+		t.Errorf("expected ErrStorageFull, got %v", err)
+	}
+	if err := store.SetAnnotations("a", []Annotation{{Type: AnnotationHighlight, Width: 1, Height: 1}}); !errors.Is(err, ErrStorageFull) {
+		t.Errorf("expected annotations to be refused, got %v", err)
+	}
+	if stats := store.GetStorageStats(); stats.StorageFull == "" {
+		t.Error("expected the stats to report the full store")
+	}
+
+	// Deletions still free space
+	if err := store.DeleteImage("a"); err != nil {
+		t.Errorf("expected deletes to work while full, got %v", err)
+	}
+
+	// The check is cached between intervals, then writes resume
+	store.config.MaxDatabaseBytes = 0
+	store.config.MinFreeDiskPercent = 0.0001
+	if err := store.StoreImage("d", img); !errors.Is(err, ErrStorageFull) {
+		t.Errorf("expected the cached result within the interval, got %v", err)
+	}
+	store.diskGuard.checked = time.Time{}
+	if err := store.StoreImage("d", img); err != nil {
+		t.Errorf("expected writes to resume with free space, got %v", err)
+	}
+}
+
+func TestDiskGuardBeforeBlobs(t *testing.T) {
+	store := newBlobTestStore(t, filepath.Join(t.TempDir(), "test.db"))
+	defer store.Close()
+
+	img, _ := encodeImageToPNG(createTestImage(8, 8))
+	store.config.MaxDatabaseBytes = 1
+	if err := store.StoreImage("refused", img); !errors.Is(err, ErrStorageFull) {
+		t.Fatalf("expected ErrStorageFull, got %v", err)
+	}
+
+	// A refused upload leaves nothing behind in the blob files
+	if blobs := store.GetStorageStats().Blobs; blobs.Bytes != 0 {
+		t.Errorf("expected no blob bytes written for a refused upload, got %d", blobs.Bytes)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !freebsd
+
+package imagestore
+
+// diskSpace can't measure volumes on this platform, so only
+// Config.MaxDatabaseBytes guards the disk here
+func diskSpace(path string) (free, total uint64, ok bool) {
+	return 0, 0, false
+}
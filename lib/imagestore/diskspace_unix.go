@@ -0,0 +1,15 @@
+//go:build linux || darwin || freebsd
+
+package imagestore
+
+import "syscall"
+
+// diskSpace returns the bytes available to unprivileged users and the total
+// size of the volume holding path
+func diskSpace(path string) (free, total uint64, ok bool) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, 0, false
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), uint64(stat.Blocks) * uint64(stat.Bsize), true
+}
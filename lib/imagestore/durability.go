@@ -0,0 +1,50 @@
+package imagestore
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// Write sync policies accepted in Config.SyncPolicy
+const (
+	SyncImage = "image" // Sync the WAL before each write returns
+	SyncBatch = "batch" // Share one WAL sync among writes within SyncInterval
+	SyncNone  = "none"  // Leave WAL syncing to the OS; a crash may lose recent writes
+)
+
+// DefaultSyncInterval is how long the batch policy waits to group WAL syncs
+const DefaultSyncInterval = 10 * time.Millisecond
+
+// applySyncPolicy sets the WAL options for a config's sync policy and returns
+// the write options for every commit. Each store, metadata change or delete
+// is a single atomic batch whatever the policy, so a crash can lose recent
+// writes under SyncNone but never leaves a manifest without its tiles.
+func applySyncPolicy(config *Config, options *pebble.Options) (*pebble.WriteOptions, error) {
+	if config.BytesPerSync < 0 || config.WALBytesPerSync < 0 {
+		return nil, fmt.Errorf("invalid bytes per sync: %d, WAL %d", config.BytesPerSync, config.WALBytesPerSync)
+	}
+	if config.BytesPerSync > 0 {
+		options.BytesPerSync = config.BytesPerSync
+	}
+	if config.WALBytesPerSync > 0 {
+		options.WALBytesPerSync = config.WALBytesPerSync
+	}
+
+	switch config.SyncPolicy {
+	case "", SyncImage:
+		return pebble.Sync, nil
+	case SyncBatch:
+		interval := config.SyncInterval
+		if interval <= 0 {
+			interval = DefaultSyncInterval
+		}
+		options.WALMinSyncInterval = func() time.Duration { return interval }
+		return pebble.Sync, nil
+	case SyncNone:
+		return pebble.NoSync, nil
+	default:
+		return nil, fmt.Errorf("unknown sync policy: %s", config.SyncPolicy)
+	}
+}
@@ -0,0 +1,81 @@
+package imagestore
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+)
+
+func TestApplySyncPolicy(t *testing.T) {
+	tests := []struct {
+		policy   string
+		expected *pebble.WriteOptions
+		grouped  bool
+	}{
+		{"", pebble.Sync, false},
+		{SyncImage, pebble.Sync, false},
+		{SyncBatch, pebble.Sync, true},
+		{SyncNone, pebble.NoSync, false},
+	}
+	for _, test := range tests {
+		config := DefaultConfig()
+		config.SyncPolicy = test.policy
+		config.SyncInterval = 5 * time.Millisecond
+		config.WALBytesPerSync = 1 << 20
+
+		options := &pebble.Options{}
+		writeOpts, err := applySyncPolicy(config, options)
+		if err != nil {
+			t.Fatalf("policy %q: %v", test.policy, err)
+		}
+		if writeOpts != test.expected {
+			t.Errorf("policy %q: expected sync %v, got %v", test.policy, test.expected.Sync, writeOpts.Sync)
+		}
+		if grouped := options.WALMinSyncInterval != nil; grouped != test.grouped {
+			t.Errorf("policy %q: expected grouped syncs %v, got %v", test.policy, test.grouped, grouped)
+		} else if grouped && options.WALMinSyncInterval() != 5*time.Millisecond {
+			t.Errorf("policy %q: expected a 5ms sync interval, got %v", test.policy, options.WALMinSyncInterval())
+		}
+		if options.WALBytesPerSync != 1<<20 {
+			t.Errorf("policy %q: WAL bytes per sync not applied", test.policy)
+		}
+	}
+
+	config := DefaultConfig()
+	config.SyncPolicy = "sometimes"
+	if _, err := applySyncPolicy(config, &pebble.Options{}); err == nil {
+		t.Error("expected an error for an unknown sync policy")
+	}
+}
+
+func TestStoreWithSyncPolicies(t *testing.T) {
+	imageData, _ := encodeImageToPNG(createTestImage(8, 8))
+
+	for _, policy := range []string{SyncBatch, SyncNone} {
+		config := DefaultConfig()
+		config.DatabasePath = filepath.Join(t.TempDir(), "test.db")
+		config.TileSize = 4
+		config.SyncPolicy = policy
+
+		store, err := NewPebbleImageStore(config)
+		if err != nil {
+			t.Fatalf("policy %s: failed to create store: %v", policy, err)
+		}
+		if err := store.StoreImage("image", imageData); err != nil {
+			t.Fatalf("policy %s: failed to store image: %v", policy, err)
+		}
+		store.Close()
+
+		// Writes survive a clean close under every policy
+		store, err = NewPebbleImageStore(config)
+		if err != nil {
+			t.Fatalf("policy %s: failed to reopen store: %v", policy, err)
+		}
+		if _, err := store.RetrieveImage("image"); err != nil {
+			t.Errorf("policy %s: failed to retrieve image: %v", policy, err)
+		}
+		store.Close()
+	}
+}
@@ -0,0 +1,79 @@
+package imagestore
+
+import (
+	"context"
+	"fmt"
+)
+
+// StoreEstimate projects what storing an image would write
+type StoreEstimate struct {
+	TotalTiles          int
+	NewTiles            int // Unique tiles the store doesn't hold yet
+	DeduplicatedTiles   int // Tiles already stored or repeated within the image
+	DeduplicatedPercent float64
+	OriginalBytes       int64
+	TileBytes           int64 // Compressed size of the new tiles
+	ManifestBytes       int64
+	ProjectedBytes      int64 // TileBytes + ManifestBytes
+	CompressionRatio    float64
+}
+
+// Add accumulates another estimate, e.g. to total a batch of images. Tiles
+// shared between the images in the batch are counted once per image.
+func (e *StoreEstimate) Add(other StoreEstimate) {
+	e.TotalTiles += other.TotalTiles
+	e.NewTiles += other.NewTiles
+	e.DeduplicatedTiles += other.DeduplicatedTiles
+	e.OriginalBytes += other.OriginalBytes
+	e.TileBytes += other.TileBytes
+	e.ManifestBytes += other.ManifestBytes
+	e.ProjectedBytes += other.ProjectedBytes
+	e.computeRatios()
+}
+
+// computeRatios derives the percentage and ratio from the totals
+func (e *StoreEstimate) computeRatios() {
+	e.DeduplicatedPercent = 0
+	if e.TotalTiles > 0 {
+		e.DeduplicatedPercent = float64(e.DeduplicatedTiles) / float64(e.TotalTiles) * 100
+	}
+	e.CompressionRatio = 0
+	if e.ProjectedBytes > 0 {
+		e.CompressionRatio = float64(e.OriginalBytes) / float64(e.ProjectedBytes)
+	}
+}
+
+// StoreImageDryRun tiles, deduplicates and compresses an image exactly as
+// StoreImage would, without writing anything, and reports the projected
+// storage cost
+func (s *PebbleImageStore) StoreImageDryRun(imageData []byte) (*StoreEstimate, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+
+	plan, err := s.planStore(context.Background(), "", imageData, StoreOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, err := encodeManifest(plan.image)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal image metadata: %w", err)
+	}
+
+	estimate := &StoreEstimate{
+		TotalTiles:        len(plan.image.TileRefs),
+		NewTiles:          len(plan.newTiles),
+		DeduplicatedTiles: plan.dedupMatches,
+		OriginalBytes:     plan.image.OriginalBytes,
+		ManifestBytes:     int64(len(manifest)),
+	}
+	for _, planned := range plan.newTiles {
+		estimate.TileBytes += int64(len(planned.compressed))
+	}
+	estimate.ProjectedBytes = estimate.TileBytes + estimate.ManifestBytes
+	estimate.computeRatios()
+
+	return estimate, nil
+}
@@ -0,0 +1,63 @@
+package imagestore
+
+import "testing"
+
+func TestStoreImageDryRun(t *testing.T) {
+	store := newTagsTestStore(t)
+
+	imageData, err := encodeImageToPNG(createTestImage(8, 8))
+	if err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+
+	estimate, err := store.StoreImageDryRun(imageData)
+	if err != nil {
+		t.Fatalf("failed to estimate: %v", err)
+	}
+	if estimate.TotalTiles != 4 || estimate.NewTiles+estimate.DeduplicatedTiles != 4 {
+		t.Errorf("unexpected tile counts: %+v", estimate)
+	}
+	if estimate.NewTiles == 0 || estimate.TileBytes == 0 || estimate.ManifestBytes == 0 {
+		t.Errorf("expected new tiles to be written: %+v", estimate)
+	}
+	if estimate.ProjectedBytes != estimate.TileBytes+estimate.ManifestBytes {
+		t.Errorf("projected bytes %d don't add up", estimate.ProjectedBytes)
+	}
+	if estimate.OriginalBytes != int64(len(imageData)) {
+		t.Errorf("expected original bytes %d, got %d", len(imageData), estimate.OriginalBytes)
+	}
+
+	// Nothing was written
+	if stats := store.GetStorageStats(); stats.TotalImages != 0 || stats.UniqueTiles != 0 {
+		t.Fatalf("dry run wrote to the store: %+v", stats)
+	}
+
+	// Once stored, the same image is fully deduplicated
+	if err := store.StoreImage("stored", imageData); err != nil {
+		t.Fatalf("failed to store image: %v", err)
+	}
+	estimate, err = store.StoreImageDryRun(imageData)
+	if err != nil {
+		t.Fatalf("failed to estimate: %v", err)
+	}
+	if estimate.NewTiles != 0 || estimate.TileBytes != 0 || estimate.DeduplicatedPercent != 100 {
+		t.Errorf("expected a fully deduplicated estimate, got %+v", estimate)
+	}
+
+	if _, err := store.StoreImageDryRun([]byte("not an image")); err == nil {
+		t.Error("expected an error for invalid image data")
+	}
+}
+
+func TestStoreEstimateAdd(t *testing.T) {
+	var total StoreEstimate
+	total.Add(StoreEstimate{TotalTiles: 4, NewTiles: 4, OriginalBytes: 1000, TileBytes: 400, ManifestBytes: 100, ProjectedBytes: 500})
+	total.Add(StoreEstimate{TotalTiles: 4, DeduplicatedTiles: 4, OriginalBytes: 1000, ManifestBytes: 100, ProjectedBytes: 100})
+
+	if total.TotalTiles != 8 || total.DeduplicatedPercent != 50 {
+		t.Errorf("unexpected totals: %+v", total)
+	}
+	if total.ProjectedBytes != 600 || total.CompressionRatio != 2000.0/600.0 {
+		t.Errorf("unexpected projected bytes or ratio: %+v", total)
+	}
+}
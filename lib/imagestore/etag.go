@@ -0,0 +1,75 @@
+package imagestore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// ErrPreconditionFailed is returned by a write whose StoreOptions.IfMatch
+// does not match the image's current version
+var ErrPreconditionFailed = errors.New("precondition failed")
+
+// manifestETag returns the strong ETag of a stored manifest. Any change to
+// the manifest, including metadata, tags and expiry, changes it.
+func manifestETag(manifest []byte) string {
+	sum := sha256.Sum256(manifest)
+	return `"` + hex.EncodeToString(sum[:12]) + `"`
+}
+
+// ImageETag returns the ETag of a live image's current version
+func (s *PebbleImageStore) ImageETag(id string) (string, error) {
+	if err := s.enter(); err != nil {
+		return "", err
+	}
+	defer s.leave()
+
+	value, closer, err := s.db.Get(makeKey(imagesBucket, id))
+	if err != nil {
+		return "", fmt.Errorf("image not found: %s", id)
+	}
+	defer closer.Close()
+	return manifestETag(value), nil
+}
+
+// ifMatchSatisfied evaluates an If-Match list against the current ETag, or
+// "" when no image exists. "*" matches any existing image. Weak tags never
+// match, as If-Match uses strong comparison.
+func ifMatchSatisfied(ifMatch, current string) bool {
+	if current == "" {
+		return false
+	}
+	for _, tag := range strings.Split(ifMatch, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == current {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIfMatch reads the image's current version and fails unless it
+// satisfies ifMatch. The caller must hold the image's version lock.
+func (s *PebbleImageStore) checkIfMatch(id, ifMatch string) error {
+	current := ""
+	value, closer, err := s.db.Get(makeKey(imagesBucket, id))
+	switch {
+	case err == nil:
+		current = manifestETag(value)
+		closer.Close()
+	case err != pebble.ErrNotFound:
+		return err
+	}
+
+	if !ifMatchSatisfied(ifMatch, current) {
+		if current == "" {
+			return fmt.Errorf("%w: image %s does not exist", ErrPreconditionFailed, id)
+		}
+		return fmt.Errorf("%w: image %s is at version %s", ErrPreconditionFailed, id, current)
+	}
+	return nil
+}
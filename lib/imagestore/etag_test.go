@@ -0,0 +1,90 @@
+package imagestore
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"sync"
+	"testing"
+)
+
+func TestIfMatch(t *testing.T) {
+	store := newTagsTestStore(t, "shot")
+	first, second := conflictTestImages(t)
+
+	etag, err := store.ImageETag("shot")
+	if err != nil {
+		t.Fatalf("failed to get ETag: %v", err)
+	}
+
+	if err := store.StoreImageWithOptions("shot", first, StoreOptions{IfMatch: etag}); err != nil {
+		t.Fatalf("expected write with the current ETag to succeed: %v", err)
+	}
+	updated, err := store.ImageETag("shot")
+	if err != nil {
+		t.Fatalf("failed to get ETag: %v", err)
+	}
+	if updated == etag {
+		t.Fatal("expected the ETag to change with the image")
+	}
+
+	// The first ETag is stale now
+	err = store.StoreImageWithOptions("shot", second, StoreOptions{IfMatch: etag})
+	if !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("expected ErrPreconditionFailed for a stale ETag, got %v", err)
+	}
+	if current, _ := store.ImageETag("shot"); current != updated {
+		t.Error("expected a failed conditional write to leave the image untouched")
+	}
+
+	if err := store.StoreImageWithOptions("shot", second, StoreOptions{IfMatch: `"other", ` + updated}); err != nil {
+		t.Errorf("expected a list containing the current ETag to match: %v", err)
+	}
+	if err := store.StoreImageWithOptions("shot", first, StoreOptions{IfMatch: "*"}); err != nil {
+		t.Errorf("expected * to match an existing image: %v", err)
+	}
+	if err := store.StoreImageWithOptions("missing", first, StoreOptions{IfMatch: "*"}); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("expected * to fail for a missing image, got %v", err)
+	}
+}
+
+func TestIfMatchConcurrentWriters(t *testing.T) {
+	store := newTagsTestStore(t, "shot")
+	etag, err := store.ImageETag("shot")
+	if err != nil {
+		t.Fatalf("failed to get ETag: %v", err)
+	}
+
+	// Every writer read the same version; only one may replace it
+	const writers = 8
+	var wg sync.WaitGroup
+	errs := make([]error, writers)
+	for i := 0; i < writers; i++ {
+		img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+		img.Set(0, 0, color.RGBA{uint8(i), 0, 0, 255})
+		data, err := encodeImageToPNG(img)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = store.StoreImageWithOptions("shot", data, StoreOptions{IfMatch: etag})
+		}(i)
+	}
+	wg.Wait()
+
+	succeeded := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			succeeded++
+		case !errors.Is(err, ErrPreconditionFailed):
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("expected exactly one writer to succeed, got %d", succeeded)
+	}
+}
@@ -0,0 +1,348 @@
+package imagestore
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+// SourceInfo is what an upload said about itself in EXIF and ICC metadata.
+// The pixels are stored upright, so Orientation records what was applied
+// rather than what a viewer should do.
+type SourceInfo struct {
+	Orientation int        `json:"orientation,omitempty"` // EXIF orientation, 1-8, applied at decode
+	TakenAt     *time.Time `json:"taken_at,omitempty"`    // EXIF DateTimeOriginal, falling back to DateTime
+	Make        string     `json:"make,omitempty"`
+	Model       string     `json:"model,omitempty"`
+	ICCProfile  []byte     `json:"icc_profile,omitempty"` // Embedded into reconstructed PNGs
+	DPI         int        `json:"dpi,omitempty"`         // Horizontal pixel density from the PNG pHYs chunk or JFIF header
+}
+
+// EXIF tags read from the upload
+const (
+	exifTagMake             = 0x010F
+	exifTagModel            = 0x0110
+	exifTagOrientation      = 0x0112
+	exifTagDateTime         = 0x0132
+	exifTagExifIFD          = 0x8769
+	exifTagDateTimeOriginal = 0x9003
+	exifTagOffsetOriginal   = 0x9011
+)
+
+// maxICCProfile bounds the profile kept from an upload; real profiles are a
+// few kilobytes
+const maxICCProfile = 1 << 20
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// parseSourceInfo reads EXIF and ICC metadata from a JPEG or PNG upload,
+// returning nil when it carries none. Malformed metadata is ignored rather
+// than failing the upload.
+func parseSourceInfo(data []byte) *SourceInfo {
+	info := &SourceInfo{}
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8}):
+		parseJPEGMetadata(data, info)
+	case bytes.HasPrefix(data, pngSignature):
+		parsePNGMetadata(data, info)
+	}
+
+	if info.Orientation < 1 || info.Orientation > 8 {
+		info.Orientation = 0
+	}
+	if len(info.ICCProfile) > maxICCProfile {
+		info.ICCProfile = nil
+	}
+	if info.empty() {
+		return nil
+	}
+	return info
+}
+
+// empty reports whether the upload said nothing about itself
+func (info *SourceInfo) empty() bool {
+	return info.Orientation == 0 && info.TakenAt == nil && info.Make == "" && info.Model == "" && info.ICCProfile == nil && info.DPI == 0
+}
+
+// parseJPEGMetadata walks the marker segments before the image data,
+// reading the EXIF APP1 segment and reassembling the ICC profile from its
+// numbered APP2 chunks
+func parseJPEGMetadata(data []byte, info *SourceInfo) {
+	iccChunks := make(map[byte][]byte)
+	var iccCount byte
+
+	for pos := 2; pos+4 <= len(data); {
+		if data[pos] != 0xFF {
+			break
+		}
+		marker := data[pos+1]
+		if marker == 0xFF {
+			pos++ // Fill byte
+			continue
+		}
+		if marker == 0xDA || marker == 0xD9 {
+			break // Start of scan or end of image
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if length < 2 || pos+2+length > len(data) {
+			break
+		}
+		segment := data[pos+4 : pos+2+length]
+		pos += 2 + length
+
+		switch {
+		case marker == 0xE0 && bytes.HasPrefix(segment, []byte("JFIF\x00")) && len(segment) >= 10:
+			switch density := int(binary.BigEndian.Uint16(segment[8:])); segment[7] {
+			case 1: // Dots per inch
+				info.DPI = density
+			case 2: // Dots per centimetre
+				info.DPI = int(math.Round(float64(density) * 2.54))
+			}
+		case marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")):
+			parseExif(segment[6:], info)
+		case marker == 0xE2 && bytes.HasPrefix(segment, []byte("ICC_PROFILE\x00")) && len(segment) >= 14:
+			iccCount = segment[13]
+			iccChunks[segment[12]] = segment[14:]
+		}
+	}
+
+	if iccCount == 0 || len(iccChunks) != int(iccCount) {
+		return
+	}
+	var profile []byte
+	for i := byte(1); i <= iccCount; i++ {
+		chunk, ok := iccChunks[i]
+		if !ok {
+			return
+		}
+		profile = append(profile, chunk...)
+	}
+	info.ICCProfile = profile
+}
+
+// parsePNGMetadata reads the eXIf, pHYs and iCCP chunks of a PNG
+func parsePNGMetadata(data []byte, info *SourceInfo) {
+	for pos := len(pngSignature); pos+8 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		kind := string(data[pos+4 : pos+8])
+		if length < 0 || pos+12+length > len(data) {
+			return
+		}
+		chunk := data[pos+8 : pos+8+length]
+		pos += 12 + length
+
+		switch kind {
+		case "eXIf":
+			parseExif(chunk, info)
+		case "pHYs":
+			// Pixels per unit on each axis, then the unit; 1 is the metre
+			if len(chunk) == 9 && chunk[8] == 1 {
+				info.DPI = int(math.Round(float64(binary.BigEndian.Uint32(chunk)) * 0.0254))
+			}
+		case "iCCP":
+			// Profile name, a NUL, the compression method and zlib data
+			name := bytes.IndexByte(chunk, 0)
+			if name < 0 || name+2 > len(chunk) || chunk[name+1] != 0 {
+				continue
+			}
+			reader, err := zlib.NewReader(bytes.NewReader(chunk[name+2:]))
+			if err != nil {
+				continue
+			}
+			profile, err := io.ReadAll(io.LimitReader(reader, maxICCProfile+1))
+			reader.Close()
+			if err == nil {
+				info.ICCProfile = profile
+			}
+		case "IEND":
+			return
+		}
+	}
+}
+
+// parseExif reads the tags SourceInfo records from a TIFF-structured EXIF
+// block
+func parseExif(tiff []byte, info *SourceInfo) {
+	if len(tiff) < 8 {
+		return
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return
+	}
+	if order.Uint16(tiff[2:]) != 42 {
+		return
+	}
+
+	var dateTime, dateTimeOriginal, offsetOriginal string
+	readIFD := func(offset uint32, fn func(tag, kind uint16, count uint32, value []byte)) {
+		if int64(offset)+2 > int64(len(tiff)) {
+			return
+		}
+		entries := int(order.Uint16(tiff[offset:]))
+		for i := 0; i < entries; i++ {
+			entry := int(offset) + 2 + i*12
+			if entry+12 > len(tiff) {
+				return
+			}
+			tag := order.Uint16(tiff[entry:])
+			kind := order.Uint16(tiff[entry+2:])
+			count := order.Uint32(tiff[entry+4:])
+
+			// Values over four bytes are stored at an offset
+			value := tiff[entry+8 : entry+12]
+			if kind == 2 && count > 4 {
+				start := order.Uint32(value)
+				if int64(start)+int64(count) > int64(len(tiff)) {
+					continue
+				}
+				value = tiff[start : start+count]
+			}
+			fn(tag, kind, count, value)
+		}
+	}
+	ascii := func(value []byte, count uint32) string {
+		if int(count) < len(value) {
+			value = value[:count]
+		}
+		return strings.TrimSpace(strings.TrimRight(string(value), "\x00"))
+	}
+
+	var exifIFD uint32
+	readIFD(order.Uint32(tiff[4:]), func(tag, kind uint16, count uint32, value []byte) {
+		switch {
+		case tag == exifTagOrientation && kind == 3:
+			info.Orientation = int(order.Uint16(value))
+		case tag == exifTagMake && kind == 2:
+			info.Make = ascii(value, count)
+		case tag == exifTagModel && kind == 2:
+			info.Model = ascii(value, count)
+		case tag == exifTagDateTime && kind == 2:
+			dateTime = ascii(value, count)
+		case tag == exifTagExifIFD && kind == 4:
+			exifIFD = order.Uint32(value)
+		}
+	})
+	if exifIFD != 0 {
+		readIFD(exifIFD, func(tag, kind uint16, count uint32, value []byte) {
+			switch {
+			case tag == exifTagDateTimeOriginal && kind == 2:
+				dateTimeOriginal = ascii(value, count)
+			case tag == exifTagOffsetOriginal && kind == 2:
+				offsetOriginal = ascii(value, count)
+			}
+		})
+	}
+
+	// EXIF times are local to the camera; without an offset we assume UTC
+	for _, value := range []string{dateTimeOriginal, dateTime} {
+		if value == "" {
+			continue
+		}
+		layout := "2006:01:02 15:04:05"
+		if offsetOriginal != "" && value == dateTimeOriginal {
+			value += offsetOriginal
+			layout += "-07:00"
+		}
+		if takenAt, err := time.Parse(layout, value); err == nil {
+			takenAt = takenAt.UTC()
+			info.TakenAt = &takenAt
+			return
+		}
+	}
+}
+
+// applyOrientation returns an image turned upright according to an EXIF
+// orientation
+func applyOrientation(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	outWidth, outHeight := width, height
+	if orientation >= 5 {
+		outWidth, outHeight = height, width // 5-8 swap the axes
+	}
+
+	out := image.NewRGBA(image.Rect(0, 0, outWidth, outHeight))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // Mirrored horizontally
+				dx, dy = width-1-x, y
+			case 3: // Rotated 180°
+				dx, dy = width-1-x, height-1-y
+			case 4: // Mirrored vertically
+				dx, dy = x, height-1-y
+			case 5: // Transposed
+				dx, dy = y, x
+			case 6: // Needs a 90° clockwise turn
+				dx, dy = height-1-y, x
+			case 7: // Transversed
+				dx, dy = height-1-y, width-1-x
+			case 8: // Needs a 90° counter-clockwise turn
+				dx, dy = y, width-1-x
+			}
+			out.Set(dx, dy, img.At(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+	return out
+}
+
+// embedICCProfile inserts an iCCP chunk after the IHDR chunk of an encoded
+// PNG
+func embedICCProfile(pngData, profile []byte) ([]byte, error) {
+	// IHDR is always first: signature, then 4+4+13+4 bytes
+	ihdrEnd := len(pngSignature) + 25
+	if len(pngData) < ihdrEnd || !bytes.HasPrefix(pngData, pngSignature) {
+		return pngData, nil
+	}
+
+	var chunk bytes.Buffer
+	chunk.WriteString("iCCP")
+	chunk.WriteString("ICC Profile\x00\x00")
+	writer := zlib.NewWriter(&chunk)
+	if _, err := writer.Write(profile); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, len(pngData)+chunk.Len()+8)
+	out = append(out, pngData[:ihdrEnd]...)
+	out = binary.BigEndian.AppendUint32(out, uint32(chunk.Len()-4))
+	out = append(out, chunk.Bytes()...)
+	out = binary.BigEndian.AppendUint32(out, crc32.ChecksumIEEE(chunk.Bytes()))
+	out = append(out, pngData[ihdrEnd:]...)
+	return out, nil
+}
+
+// GetSourceInfo returns the EXIF and ICC metadata recorded from a live
+// image's upload, or nil if it carried none
+func (s *PebbleImageStore) GetSourceInfo(id string) (*SourceInfo, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+
+	storedImage, err := s.getStoredImage(id)
+	if err != nil {
+		return nil, err
+	}
+	return storedImage.Source, nil
+}
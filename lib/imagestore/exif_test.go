@@ -0,0 +1,151 @@
+package imagestore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+	"time"
+)
+
+// exifSegment builds a big-endian EXIF APP1 segment with an orientation,
+// a camera model and a DateTimeOriginal in the EXIF sub-IFD
+func exifSegment(orientation uint16) []byte {
+	var tiff bytes.Buffer
+	order := binary.BigEndian
+	tiff.WriteString("MM")
+	binary.Write(&tiff, order, uint16(42))
+	binary.Write(&tiff, order, uint32(8))
+
+	// IFD0 at 8: three entries, then the next-IFD offset
+	model := "Pixel 8\x00"
+	ifd0End := uint32(8 + 2 + 3*12 + 4)
+	exifIFD := ifd0End + uint32(len(model))
+	binary.Write(&tiff, order, uint16(3))
+	for _, entry := range [][3]uint32{
+		{exifTagModel<<16 | 2, uint32(len(model)), ifd0End},
+		{exifTagOrientation<<16 | 3, 1, uint32(orientation) << 16},
+		{exifTagExifIFD<<16 | 4, 1, exifIFD},
+	} {
+		binary.Write(&tiff, order, entry)
+	}
+	binary.Write(&tiff, order, uint32(0))
+	tiff.WriteString(model)
+
+	// EXIF IFD: DateTimeOriginal and its offset
+	taken := "2024:03:01 12:30:00\x00"
+	offset := "+02:00\x00"
+	takenAt := exifIFD + 2 + 2*12 + 4
+	binary.Write(&tiff, order, uint16(2))
+	binary.Write(&tiff, order, [3]uint32{exifTagDateTimeOriginal<<16 | 2, uint32(len(taken)), takenAt})
+	binary.Write(&tiff, order, [3]uint32{exifTagOffsetOriginal<<16 | 2, uint32(len(offset)), takenAt + uint32(len(taken))})
+	binary.Write(&tiff, order, uint32(0))
+	tiff.WriteString(taken)
+	tiff.WriteString(offset)
+
+	return jpegSegment(0xE1, append([]byte("Exif\x00\x00"), tiff.Bytes()...))
+}
+
+func jpegSegment(marker byte, payload []byte) []byte {
+	segment := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+func TestSourceInfo(t *testing.T) {
+	// A 4x2 image, left half white, right half black
+	img := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 4; x++ {
+			c := color.RGBA{0, 0, 0, 255}
+			if x < 2 {
+				c = color.RGBA{255, 255, 255, 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	var encoded bytes.Buffer
+	jpeg.Encode(&encoded, img, &jpeg.Options{Quality: 100})
+
+	profile := bytes.Repeat([]byte("icc"), 100)
+	data := append([]byte{0xFF, 0xD8}, exifSegment(6)...)
+	data = append(data, jpegSegment(0xE2, append([]byte("ICC_PROFILE\x00\x01\x02"), profile[:150]...))...)
+	data = append(data, jpegSegment(0xE2, append([]byte("ICC_PROFILE\x00\x02\x02"), profile[150:]...))...)
+	data = append(data, encoded.Bytes()[2:]...)
+
+	info := parseSourceInfo(data)
+	if info == nil || info.Orientation != 6 || info.Model != "Pixel 8" || !bytes.Equal(info.ICCProfile, profile) {
+		t.Fatalf("unexpected source info %+v", info)
+	}
+	if want := time.Date(2024, 3, 1, 10, 30, 0, 0, time.UTC); info.TakenAt == nil || !info.TakenAt.Equal(want) {
+		t.Errorf("expected taken at %v, got %v", want, info.TakenAt)
+	}
+
+	store := newTagsTestStore(t)
+	if err := store.StoreImage("photo", data); err != nil {
+		t.Fatalf("failed to store image: %v", err)
+	}
+
+	// Turned a quarter clockwise, the white half ends up on top
+	retrieved, err := store.RetrieveImage("photo")
+	if err != nil {
+		t.Fatalf("failed to retrieve image: %v", err)
+	}
+	upright, _ := decodeImageFromBytes(retrieved)
+	if upright.Bounds().Dx() != 2 || upright.Bounds().Dy() != 4 {
+		t.Fatalf("expected a 2x4 image, got %v", upright.Bounds())
+	}
+	if r, _, _, _ := upright.At(0, 0).RGBA(); r>>8 < 200 {
+		t.Error("expected the top to be white")
+	}
+	if r, _, _, _ := upright.At(0, 3).RGBA(); r>>8 > 50 {
+		t.Error("expected the bottom to be black")
+	}
+
+	// The profile comes back embedded in the PNG
+	if embedded := parseSourceInfo(retrieved); embedded == nil || !bytes.Equal(embedded.ICCProfile, profile) {
+		t.Errorf("expected the ICC profile in the PNG, got %+v", embedded)
+	}
+	if stored, err := store.GetSourceInfo("photo"); err != nil || stored.Model != "Pixel 8" {
+		t.Errorf("expected stored source info, got %+v (%v)", stored, err)
+	}
+
+	if parseSourceInfo(encoded.Bytes()) != nil {
+		t.Error("expected no source info for a plain JPEG")
+	}
+}
+
+func TestApplyOrientation(t *testing.T) {
+	// A 2x1 image with a red pixel at (0, 0) and blue at (1, 0)
+	img := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	red, blue := color.RGBA{255, 0, 0, 255}, color.RGBA{0, 0, 255, 255}
+	img.Set(0, 0, red)
+	img.Set(1, 0, blue)
+
+	tests := []struct {
+		orientation int
+		width       int
+		redAt       image.Point
+	}{
+		{1, 2, image.Pt(0, 0)},
+		{2, 2, image.Pt(1, 0)},
+		{3, 2, image.Pt(1, 0)},
+		{4, 2, image.Pt(0, 0)},
+		{5, 1, image.Pt(0, 0)},
+		{6, 1, image.Pt(0, 0)},
+		{7, 1, image.Pt(0, 1)},
+		{8, 1, image.Pt(0, 1)},
+	}
+	for _, tt := range tests {
+		out := applyOrientation(img, tt.orientation)
+		if out.Bounds().Dx() != tt.width {
+			t.Errorf("orientation %d: expected width %d, got %v", tt.orientation, tt.width, out.Bounds())
+			continue
+		}
+		if got := color.RGBAModel.Convert(out.At(tt.redAt.X, tt.redAt.Y)); got != red {
+			t.Errorf("orientation %d: expected red at %v, got %v", tt.orientation, tt.redAt, got)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package imagestore
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// expiryIndexKey builds expiry:<unix nanos, zero padded>\x00<imageID> so
+// iteration visits images in expiration order
+func expiryIndexKey(expiresAt time.Time, imageID string) []byte {
+	return makeKey(expiryBucket, fmt.Sprintf("%020d\x00%s", expiresAt.UnixNano(), imageID))
+}
+
+// indexExpiry adds an expiry index entry for an image to a batch
+func indexExpiry(batch *pebble.Batch, storedImage *StoredImage) error {
+	if storedImage.ExpiresAt == nil {
+		return nil
+	}
+	if err := batch.Set(expiryIndexKey(*storedImage.ExpiresAt, storedImage.ID), nil, pebble.Sync); err != nil {
+		return fmt.Errorf("failed to index expiration: %w", err)
+	}
+	return nil
+}
+
+// unindexExpiry removes an image's expiry index entry from a batch
+func unindexExpiry(batch *pebble.Batch, storedImage *StoredImage) error {
+	if storedImage.ExpiresAt == nil {
+		return nil
+	}
+	if err := batch.Delete(expiryIndexKey(*storedImage.ExpiresAt, storedImage.ID), pebble.Sync); err != nil {
+		return fmt.Errorf("failed to unindex expiration: %w", err)
+	}
+	return nil
+}
+
+// SetExpiration sets or, with a nil time, clears when an image expires
+func (s *PebbleImageStore) SetExpiration(id string, expiresAt *time.Time) error {
+	if err := s.enter(); err != nil {
+		return err
+	}
+	defer s.leave()
+
+	// Hold the image's version from the read through the commit, so a
+	// concurrent tag or metadata update isn't lost
+	unlock := s.lockVersion(id)
+	defer unlock()
+
+	imageKey := makeKey(imagesBucket, id)
+	imageData, closer, err := s.db.Get(imageKey)
+	if err != nil {
+		return fmt.Errorf("image not found: %s", id)
+	}
+
+	var storedImage StoredImage
+	err = decodeManifest(imageData, &storedImage)
+	closer.Close()
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal image: %w", err)
+	}
+
+	batch := s.db.NewBatch()
+	defer batch.Close()
+
+	if err := unindexExpiry(batch, &storedImage); err != nil {
+		return err
+	}
+	storedImage.ExpiresAt = expiresAt
+	if err := indexExpiry(batch, &storedImage); err != nil {
+		return err
+	}
+
+	imageBytes, err := encodeManifest(&storedImage)
+	if err != nil {
+		return fmt.Errorf("failed to marshal image metadata: %w", err)
+	}
+	if err := batch.Set(imageKey, imageBytes, pebble.Sync); err != nil {
+		return fmt.Errorf("failed to store image metadata: %w", err)
+	}
+
+	return s.commitChanges(batch, AuditExpiryChanged, id)
+}
+
+// SweepExpired permanently deletes every image whose expiration has passed,
+// then collects tiles no longer referenced by any image. It returns the
+// number of images deleted.
+func (s *PebbleImageStore) SweepExpired() (int, error) {
+	if err := s.enter(); err != nil {
+		return 0, err
+	}
+	defer s.leave()
+
+	var expired []string
+
+	prefix := makePrefixKey(expiryBucket)
+	iter, err := s.db.NewIter(&pebble.IterOptions{
+		LowerBound: prefix,
+		UpperBound: expiryIndexKey(time.Now(), ""),
+	})
+	if err != nil {
+		return 0, err
+	}
+	for iter.First(); iter.Valid(); iter.Next() {
+		if _, id, ok := strings.Cut(string(iter.Key()[len(prefix):]), "\x00"); ok {
+			expired = append(expired, id)
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, id := range expired {
+		ok, err := s.deleteIfExpired(id)
+		if err != nil {
+			return deleted, fmt.Errorf("failed to delete expired image %s: %w", id, err)
+		}
+		if ok {
+			deleted++
+		}
+	}
+
+	if deleted > 0 {
+		if _, _, err := s.CollectGarbage(); err != nil {
+			return deleted, err
+		}
+	}
+
+	return deleted, nil
+}
+
+// deleteIfExpired permanently deletes an image found in the expiry index,
+// unless it was re-uploaded or its expiration cleared or moved since
+func (s *PebbleImageStore) deleteIfExpired(id string) (bool, error) {
+	unlock := s.lockVersion(id)
+	defer unlock()
+
+	storedImage, err := s.getStoredImage(id)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			return false, nil // Deleted meanwhile
+		}
+		return false, err
+	}
+	if storedImage.ExpiresAt == nil || storedImage.ExpiresAt.After(time.Now()) {
+		return false, nil
+	}
+	if err := s.deleteImagePermanently(id); err != nil {
+		return false, err
+	}
+	return true, nil
+}
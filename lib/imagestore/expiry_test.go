@@ -0,0 +1,193 @@
+package imagestore
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStoreImageWithExpiration(t *testing.T) {
+	store := newTagsTestStore(t)
+
+	imageData, err := encodeImageToPNG(createTestImage(8, 8))
+	if err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+
+	past := time.Now().Add(-time.Minute)
+	soon := time.Now().Add(time.Hour)
+	later := time.Now().Add(72 * time.Hour)
+
+	for id, expiresAt := range map[string]*time.Time{"expired": &past, "soon": &soon, "later": &later, "forever": nil} {
+		if err := store.StoreImageWithOptions(id, imageData, StoreOptions{ExpiresAt: expiresAt}); err != nil {
+			t.Fatalf("failed to store image %s: %v", id, err)
+		}
+	}
+
+	stats := store.GetStorageStats()
+	if stats.ExpiringImages != 3 {
+		t.Errorf("expected 3 expiring images, got %d", stats.ExpiringImages)
+	}
+	if stats.ExpiringWithin24h != 2 {
+		t.Errorf("expected 2 images expiring within 24h, got %d", stats.ExpiringWithin24h)
+	}
+
+	swept, err := store.SweepExpired()
+	if err != nil {
+		t.Fatalf("failed to sweep expired images: %v", err)
+	}
+	if swept != 1 {
+		t.Errorf("expected 1 expired image swept, got %d", swept)
+	}
+
+	if _, err := store.RetrieveImage("expired"); err == nil {
+		t.Error("expected expired image to be deleted")
+	}
+	if _, err := store.RetrieveImage("soon"); err != nil {
+		t.Errorf("expected unexpired image to remain: %v", err)
+	}
+
+	trashed, err := store.ListTrash()
+	if err != nil {
+		t.Fatalf("failed to list trash: %v", err)
+	}
+	if len(trashed) != 0 {
+		t.Errorf("expected expired images to bypass the trash, got %v", trashed)
+	}
+}
+
+func TestSetExpiration(t *testing.T) {
+	store := newTagsTestStore(t, "a")
+
+	past := time.Now().Add(-time.Second)
+	if err := store.SetExpiration("a", &past); err != nil {
+		t.Fatalf("failed to set expiration: %v", err)
+	}
+
+	// Clearing the expiration removes the index entry as well
+	if err := store.SetExpiration("a", nil); err != nil {
+		t.Fatalf("failed to clear expiration: %v", err)
+	}
+
+	swept, err := store.SweepExpired()
+	if err != nil {
+		t.Fatalf("failed to sweep expired images: %v", err)
+	}
+	if swept != 0 {
+		t.Errorf("expected nothing swept after clearing expiration, got %d", swept)
+	}
+
+	if err := store.SetExpiration("missing", &past); err == nil {
+		t.Error("expected error setting expiration on nonexistent image")
+	}
+}
+
+func TestSweepExpiredCollectsTiles(t *testing.T) {
+	store := newTagsTestStore(t)
+
+	imageData, err := encodeImageToPNG(createTestImage(8, 8))
+	if err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+
+	past := time.Now().Add(-time.Minute)
+	if err := store.StoreImageWithOptions("expired", imageData, StoreOptions{ExpiresAt: &past}); err != nil {
+		t.Fatalf("failed to store image: %v", err)
+	}
+
+	if _, err := store.SweepExpired(); err != nil {
+		t.Fatalf("failed to sweep expired images: %v", err)
+	}
+
+	if stats := store.GetStorageStats(); stats.UniqueTiles != 0 {
+		t.Errorf("expected tiles of the expired image to be collected, got %d", stats.UniqueTiles)
+	}
+}
+
+func TestSetExpirationConcurrentWithMetadata(t *testing.T) {
+	store := newTagsTestStore(t, "shot")
+
+	const rounds = 50
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < rounds; i++ {
+			expiresAt := time.Now().Add(time.Duration(i+1) * time.Hour)
+			if err := store.SetExpiration("shot", &expiresAt); err != nil {
+				t.Errorf("failed to set expiration: %v", err)
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < rounds; i++ {
+			if err := store.SetMetadata("shot", map[string]string{fmt.Sprintf("k%d", i): "v"}); err != nil {
+				t.Errorf("failed to set metadata: %v", err)
+				return
+			}
+		}
+	}()
+	wg.Wait()
+
+	metadata, err := store.GetMetadata("shot")
+	if err != nil {
+		t.Fatalf("failed to get metadata: %v", err)
+	}
+	if len(metadata) != rounds {
+		t.Errorf("expected every metadata update kept, got %d of %d keys", len(metadata), rounds)
+	}
+	if stats := store.GetStorageStats(); stats.ExpiringImages != 1 {
+		t.Errorf("expected one expiry index entry, got %d", stats.ExpiringImages)
+	}
+}
+
+func TestSweepExpiredRechecksImage(t *testing.T) {
+	store := newTagsTestStore(t, "shot")
+
+	// An index entry of a version that expired, as a sweep sees it when the
+	// image is re-uploaded or its expiration cleared after it scanned
+	past := time.Now().Add(-time.Minute)
+	if err := store.db.Set(expiryIndexKey(past, "shot"), nil, nil); err != nil {
+		t.Fatalf("failed to write index entry: %v", err)
+	}
+
+	swept, err := store.SweepExpired()
+	if err != nil {
+		t.Fatalf("failed to sweep expired images: %v", err)
+	}
+	if swept != 0 {
+		t.Errorf("expected nothing swept, got %d", swept)
+	}
+	mustRetrieve(t, store, "shot")
+}
+
+func TestNoBackgroundJobs(t *testing.T) {
+	config := DefaultConfig()
+	config.DatabasePath = filepath.Join(t.TempDir(), "test.db")
+	config.TileSize = 4
+	config.ExpirySweepInterval = time.Millisecond
+	config.NoBackgroundJobs = true
+
+	store, err := NewPebbleImageStore(config)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	imageData, err := encodeImageToPNG(createTestImage(8, 8))
+	if err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	past := time.Now().Add(-time.Minute)
+	if err := store.StoreImageWithOptions("expired", imageData, StoreOptions{ExpiresAt: &past}); err != nil {
+		t.Fatalf("failed to store image: %v", err)
+	}
+
+	// No sweeper runs, however short its interval
+	time.Sleep(50 * time.Millisecond)
+	mustRetrieve(t, store, "expired")
+}
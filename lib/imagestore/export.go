@@ -0,0 +1,393 @@
+package imagestore
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// ExportMode selects what an export contains
+type ExportMode string
+
+const (
+	// ExportImages writes every live image reconstructed as a PNG
+	ExportImages ExportMode = "images"
+	// ExportRaw writes every key in the store, deduplicated and compressed,
+	// so it can be restored with ImportArchive
+	ExportRaw ExportMode = "raw"
+)
+
+// Paths used inside export archives
+const (
+	exportImagesDir = "images/"
+	exportRawDir    = "store/"
+)
+
+// OCI media types used by ExportOCI
+const (
+	ociArtifactType      = "application/vnd.gordyf.imageencoder.export.v1"
+	ociManifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+	ociEmptyMediaType    = "application/vnd.oci.empty.v1+json"
+	ociLayerMediaType    = "application/vnd.oci.image.layer.v1.tar"
+)
+
+// ParseExportMode validates an export mode name
+func ParseExportMode(mode string) (ExportMode, error) {
+	switch ExportMode(mode) {
+	case ExportImages, ExportRaw:
+		return ExportMode(mode), nil
+	}
+	return "", fmt.Errorf("invalid export mode: %s", mode)
+}
+
+// ExportTar writes a consistent snapshot of the store as a tar archive. In
+// images mode each image is written as images/<id>.png; in raw mode each key
+// is written as store/<escaped key>.
+func (s *PebbleImageStore) ExportTar(w io.Writer, mode ExportMode) error {
+	if err := s.enter(); err != nil {
+		return err
+	}
+	defer s.leave()
+
+	snapshot := s.db.NewSnapshot()
+	defer snapshot.Close()
+
+	tw := tar.NewWriter(w)
+	modTime := time.Now().UTC()
+
+	var err error
+	switch mode {
+	case ExportImages:
+		err = s.exportImages(tw, snapshot, modTime)
+	case ExportRaw:
+		err = s.exportRaw(tw, snapshot, modTime)
+	default:
+		err = fmt.Errorf("invalid export mode: %s", mode)
+	}
+	if err != nil {
+		return err
+	}
+
+	return tw.Close()
+}
+
+// exportImages reconstructs every live image in the snapshot into the archive
+func (s *PebbleImageStore) exportImages(tw *tar.Writer, snapshot *pebble.Snapshot, modTime time.Time) error {
+	prefix := makePrefixKey(imagesBucket)
+	iter, err := snapshot.NewIter(&pebble.IterOptions{
+		LowerBound: prefix,
+		UpperBound: prefixUpperBound(prefix),
+	})
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+
+	for iter.First(); iter.Valid(); iter.Next() {
+		var storedImage StoredImage
+		if err := decodeManifest(iter.Value(), &storedImage); err != nil {
+			return fmt.Errorf("failed to unmarshal image: %w", err)
+		}
+
+		tiles, err := s.getTilesFrom(context.Background(), snapshot, storedImage.TileRefs)
+		if err != nil {
+			return fmt.Errorf("failed to reconstruct image %s: %w", storedImage.ID, err)
+		}
+
+		img, err := ReconstructImage(&storedImage, s.config.TileSize, prefetchedTiles(tiles))
+		if err != nil {
+			return fmt.Errorf("failed to reconstruct image %s: %w", storedImage.ID, err)
+		}
+
+		pngData, err := encodeImageToPNG(img)
+		if err != nil {
+			return fmt.Errorf("failed to encode image %s: %w", storedImage.ID, err)
+		}
+
+		if err := writeTarFile(tw, exportImagesDir+storedImage.ID+".png", pngData, modTime); err != nil {
+			return err
+		}
+	}
+
+	return iter.Error()
+}
+
+// exportRaw copies every key in the snapshot into the archive. Tiles held
+// in blob files are written with their encoding in place of the pointer, so
+// the archive stands alone.
+func (s *PebbleImageStore) exportRaw(tw *tar.Writer, snapshot *pebble.Snapshot, modTime time.Time) error {
+	iter, err := snapshot.NewIter(nil)
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+
+	tilesPrefix := makePrefixKey(tilesBucket)
+	for iter.First(); iter.Valid(); iter.Next() {
+		value := iter.Value()
+		if bytes.HasPrefix(iter.Key(), tilesPrefix) && isBlobPointer(value) {
+			if value, err = s.readBlobTile(tileIDFromKey(iter.Key()), value); err != nil {
+				return err
+			}
+		}
+		name := exportRawDir + url.PathEscape(string(iter.Key()))
+		if err := writeTarFile(tw, name, value, modTime); err != nil {
+			return err
+		}
+	}
+
+	return iter.Error()
+}
+
+// writeTarFile writes a single regular file entry
+func writeTarFile(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: modTime,
+		Format:  tar.FormatPAX,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write tar header for %s: %w", name, err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
+
+// ImportArchive restores a raw-mode tar archive into an empty store,
+// returning the number of keys written
+func (s *PebbleImageStore) ImportArchive(r io.Reader) (int, error) {
+	if err := s.enter(); err != nil {
+		return 0, err
+	}
+	defer s.leave()
+
+	if err := s.checkDiskSpace(); err != nil {
+		return 0, err
+	}
+	images, err := s.ListImages()
+	if err != nil {
+		return 0, err
+	}
+	if len(images) > 0 {
+		return 0, fmt.Errorf("archives can only be imported into an empty store")
+	}
+
+	tr := tar.NewReader(r)
+	batch := s.db.NewBatch()
+	defer func() { batch.Close() }()
+
+	// Tiles are added to the batch at commit, once the large ones have
+	// been moved to blob files
+	tilesPrefix := makePrefixKey(tilesBucket)
+	var tileKeys, tileValues [][]byte
+	var tileBytes int
+	commit := func() error {
+		release, err := s.separateBlobs(tileValues)
+		if err != nil {
+			return err
+		}
+		defer release()
+		for i, key := range tileKeys {
+			if err := batch.Set(key, tileValues[i], pebble.Sync); err != nil {
+				return err
+			}
+		}
+		if err := batch.Commit(s.writeOpts); err != nil {
+			return fmt.Errorf("failed to commit import: %w", err)
+		}
+		tileKeys, tileValues, tileBytes = nil, nil, 0
+		return nil
+	}
+
+	imported := 0
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return imported, fmt.Errorf("failed to read archive: %w", err)
+		}
+
+		escaped, ok := strings.CutPrefix(header.Name, exportRawDir)
+		if !ok || header.Typeflag != tar.TypeReg {
+			continue
+		}
+		key, err := url.PathUnescape(escaped)
+		if err != nil {
+			return imported, fmt.Errorf("invalid key in archive: %s", header.Name)
+		}
+		// The change feed describes the exporting store's history, not ours
+		if strings.HasPrefix(key, string(makePrefixKey(changesBucket))) {
+			continue
+		}
+
+		value, err := io.ReadAll(tr)
+		if err != nil {
+			return imported, fmt.Errorf("failed to read %s: %w", header.Name, err)
+		}
+		if strings.HasPrefix(key, string(tilesPrefix)) {
+			tileKeys = append(tileKeys, []byte(key))
+			tileValues = append(tileValues, value)
+			tileBytes += len(value)
+		} else if err := batch.Set([]byte(key), value, pebble.Sync); err != nil {
+			return imported, err
+		}
+		imported++
+
+		// Commit periodically so large stores don't build one huge batch
+		if batch.Len()+tileBytes > 64<<20 {
+			if err := commit(); err != nil {
+				return imported, err
+			}
+			batch.Close()
+			batch = s.db.NewBatch()
+		}
+	}
+
+	if err := commit(); err != nil {
+		return imported, err
+	}
+	return imported, nil
+}
+
+// ociDescriptor describes a blob in an OCI image layout
+type ociDescriptor struct {
+	MediaType    string            `json:"mediaType"`
+	ArtifactType string            `json:"artifactType,omitempty"`
+	Digest       string            `json:"digest"`
+	Size         int64             `json:"size"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+}
+
+// ExportOCI writes the export as an OCI image layout in a tar archive. The
+// archive holds a single artifact manifest whose one layer is the ExportTar
+// output, so it can be pushed to a registry with tools such as oras or
+// skopeo.
+func (s *PebbleImageStore) ExportOCI(w io.Writer, mode ExportMode) error {
+	if err := s.enter(); err != nil {
+		return err
+	}
+	defer s.leave()
+
+	// The layer digest is needed before the manifest, so stage it on disk
+	layerFile, err := os.CreateTemp("", "imagestore-export-*.tar")
+	if err != nil {
+		return fmt.Errorf("failed to create staging file: %w", err)
+	}
+	defer os.Remove(layerFile.Name())
+	defer layerFile.Close()
+
+	hash := sha256.New()
+	if err := s.ExportTar(io.MultiWriter(layerFile, hash), mode); err != nil {
+		return err
+	}
+	layerSize, err := layerFile.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := layerFile.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	created := time.Now().UTC()
+	configData := []byte("{}")
+	layer := ociDescriptor{
+		MediaType: ociLayerMediaType,
+		Digest:    "sha256:" + hex.EncodeToString(hash.Sum(nil)),
+		Size:      layerSize,
+		Annotations: map[string]string{
+			"org.opencontainers.image.title": fmt.Sprintf("imagestore-%s.tar", mode),
+		},
+	}
+
+	manifestData, err := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     ociManifestMediaType,
+		"artifactType":  ociArtifactType,
+		"config":        blobDescriptor(ociEmptyMediaType, configData),
+		"layers":        []ociDescriptor{layer},
+		"annotations": map[string]string{
+			"org.opencontainers.image.created": created.Format(time.RFC3339),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+
+	manifest := blobDescriptor(ociManifestMediaType, manifestData)
+	manifest.ArtifactType = ociArtifactType
+	manifest.Annotations = map[string]string{"org.opencontainers.image.ref.name": string(mode)}
+
+	indexData, err := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     "application/vnd.oci.image.index.v1+json",
+		"manifests":     []ociDescriptor{manifest},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal index: %w", err)
+	}
+
+	tw := tar.NewWriter(w)
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{"oci-layout", []byte(`{"imageLayoutVersion":"1.0.0"}`)},
+		{"index.json", indexData},
+		{blobPath(manifest.Digest), manifestData},
+		{blobPath(blobDescriptor(ociEmptyMediaType, configData).Digest), configData},
+	}
+	for _, file := range files {
+		if err := writeTarFile(tw, file.name, file.data, created); err != nil {
+			return err
+		}
+	}
+
+	// Stream the staged layer rather than loading it into memory
+	err = tw.WriteHeader(&tar.Header{
+		Name:    blobPath(layer.Digest),
+		Mode:    0644,
+		Size:    layerSize,
+		ModTime: created,
+		Format:  tar.FormatPAX,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write layer header: %w", err)
+	}
+	if _, err := io.Copy(tw, layerFile); err != nil {
+		return fmt.Errorf("failed to write layer: %w", err)
+	}
+
+	return tw.Close()
+}
+
+// blobDescriptor describes an in-memory blob
+func blobDescriptor(mediaType string, data []byte) ociDescriptor {
+	digest := sha256.Sum256(data)
+	return ociDescriptor{
+		MediaType: mediaType,
+		Digest:    "sha256:" + hex.EncodeToString(digest[:]),
+		Size:      int64(len(data)),
+	}
+}
+
+// blobPath returns the layout path of a blob with the given digest
+func blobPath(digest string) string {
+	return "blobs/" + strings.Replace(digest, ":", "/", 1)
+}
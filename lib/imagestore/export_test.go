@@ -0,0 +1,157 @@
+package imagestore
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readTar returns the contents of every file in a tar archive
+func readTar(t *testing.T, data []byte) map[string][]byte {
+	t.Helper()
+
+	files := make(map[string][]byte)
+	tr := tar.NewReader(bytes.NewReader(data))
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("failed to read archive: %v", err)
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", header.Name, err)
+		}
+		files[header.Name] = content
+	}
+	return files
+}
+
+func TestExportImages(t *testing.T) {
+	store := newTagsTestStore(t, "a", "team/b")
+
+	var buf bytes.Buffer
+	if err := store.ExportTar(&buf, ExportImages); err != nil {
+		t.Fatalf("failed to export: %v", err)
+	}
+
+	files := readTar(t, buf.Bytes())
+	if len(files) != 2 {
+		t.Fatalf("expected 2 files, got %d", len(files))
+	}
+
+	for _, id := range []string{"a", "team/b"} {
+		data, ok := files["images/"+id+".png"]
+		if !ok {
+			t.Fatalf("expected %s in export", id)
+		}
+		expected, err := store.RetrieveImage(id)
+		if err != nil {
+			t.Fatalf("failed to retrieve image: %v", err)
+		}
+		if !bytes.Equal(data, expected) {
+			t.Errorf("exported image %s differs from retrieved image", id)
+		}
+	}
+}
+
+func TestExportRawRoundTrip(t *testing.T) {
+	store := newTagsTestStore(t, "a", "b")
+	if err := store.AddTags("a", "nightly"); err != nil {
+		t.Fatalf("failed to tag image: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := store.ExportTar(&buf, ExportRaw); err != nil {
+		t.Fatalf("failed to export: %v", err)
+	}
+
+	config := DefaultConfig()
+	config.DatabasePath = filepath.Join(t.TempDir(), "restored.db")
+	config.TileSize = 4
+	restored, err := NewPebbleImageStore(config)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer restored.Close()
+
+	imported, err := restored.ImportArchive(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("failed to import: %v", err)
+	}
+	if imported == 0 {
+		t.Fatal("expected keys to be imported")
+	}
+
+	original, _ := store.RetrieveImage("a")
+	copied, err := restored.RetrieveImage("a")
+	if err != nil {
+		t.Fatalf("failed to retrieve restored image: %v", err)
+	}
+	if !bytes.Equal(original, copied) {
+		t.Error("restored image differs from the original")
+	}
+
+	tagged, err := restored.ListByTag("nightly")
+	if err != nil || len(tagged) != 1 || tagged[0] != "a" {
+		t.Errorf("expected indexes to be restored, got %v (%v)", tagged, err)
+	}
+
+	// Importing into a non-empty store is refused
+	if _, err := restored.ImportArchive(bytes.NewReader(buf.Bytes())); err == nil {
+		t.Error("expected error importing into a non-empty store")
+	}
+}
+
+func TestExportOCI(t *testing.T) {
+	store := newTagsTestStore(t, "a")
+
+	var buf bytes.Buffer
+	if err := store.ExportOCI(&buf, ExportRaw); err != nil {
+		t.Fatalf("failed to export: %v", err)
+	}
+
+	files := readTar(t, buf.Bytes())
+	if _, ok := files["oci-layout"]; !ok {
+		t.Fatal("expected oci-layout file")
+	}
+
+	var index struct {
+		Manifests []ociDescriptor `json:"manifests"`
+	}
+	if err := json.Unmarshal(files["index.json"], &index); err != nil || len(index.Manifests) != 1 {
+		t.Fatalf("invalid index.json: %v", err)
+	}
+
+	var manifest struct {
+		ArtifactType string          `json:"artifactType"`
+		Layers       []ociDescriptor `json:"layers"`
+	}
+	if err := json.Unmarshal(files[blobPath(index.Manifests[0].Digest)], &manifest); err != nil {
+		t.Fatalf("invalid manifest: %v", err)
+	}
+	if manifest.ArtifactType != ociArtifactType || len(manifest.Layers) != 1 {
+		t.Fatalf("unexpected manifest: %+v", manifest)
+	}
+
+	// Every blob must match its digest
+	for name, data := range files {
+		if !strings.HasPrefix(name, "blobs/") {
+			continue
+		}
+		if got := blobPath(blobDescriptor("", data).Digest); got != name {
+			t.Errorf("blob %s has digest path %s", name, got)
+		}
+	}
+
+	layer := readTar(t, files[blobPath(manifest.Layers[0].Digest)])
+	if len(layer) == 0 {
+		t.Error("expected layer to contain the raw export")
+	}
+}
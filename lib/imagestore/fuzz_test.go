@@ -0,0 +1,178 @@
+package imagestore
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// maxFuzzPixels keeps fuzzed images small enough to decode quickly
+const maxFuzzPixels = 1 << 16
+
+func FuzzDecodeImageFromBytes(f *testing.F) {
+	pngData, _ := encodeImageToPNG(createTestImage(5, 3))
+	var jpegData bytes.Buffer
+	jpeg.Encode(&jpegData, createTestImage(9, 9), nil)
+
+	f.Add(pngData)
+	f.Add(jpegData.Bytes())
+	f.Add(pngData[:len(pngData)/2])
+	f.Add([]byte("not an image"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// Headers can claim huge dimensions; skip rather than allocate them
+		if config, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil && config.Width*config.Height > maxFuzzPixels {
+			t.Skip()
+		}
+
+		img, err := decodeImageFromBytes(data)
+		if err != nil {
+			return
+		}
+
+		tiles, tileRefs, err := ExtractTiles(img, 4)
+		if err != nil {
+			t.Fatalf("decoded image failed to tile: %v", err)
+		}
+		for _, tile := range tiles {
+			if err := ValidateTileData(tile.Data, 4); err != nil {
+				t.Fatalf("extracted invalid tile: %v", err)
+			}
+		}
+		tilesX, tilesY := tileGrid(img.Bounds().Dx(), img.Bounds().Dy(), 4)
+		if len(tileRefs) != tilesX*tilesY {
+			t.Fatalf("expected %d tile refs, got %d", tilesX*tilesY, len(tileRefs))
+		}
+	})
+}
+
+func FuzzImportManifest(f *testing.F) {
+	store := newTagsTestStore(f, "source")
+	valid, err := store.GetManifest("source")
+	if err != nil {
+		f.Fatalf("failed to load manifest: %v", err)
+	}
+	validJSON, _ := json.Marshal(valid)
+
+	f.Add(validJSON)
+	f.Add([]byte(`{"Width":8,"Height":8,"TileRefs":[]}`))
+	f.Add([]byte(`{"Width":-1,"Height":8}`))
+	f.Add([]byte(`{"Width":9223372036854775807,"Height":9223372036854775807,"TileRefs":[{"X":0,"Y":0}]}`))
+	f.Add(bytes.Replace(validJSON, []byte(`"X":1`), []byte(`"X":7`), 1))
+	f.Add(bytes.Replace(validJSON, []byte(`"TileID"`), []byte(`"Transform":7,"TileID"`), 1))
+
+	i := 0
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var storedImage StoredImage
+		if err := json.Unmarshal(data, &storedImage); err != nil {
+			return
+		}
+		i++
+		storedImage.ID = fmt.Sprintf("fuzz/%d", i)
+
+		err := store.ImportManifest(&storedImage)
+		if err != nil {
+			if !strings.Contains(err.Error(), "invalid manifest") && !strings.Contains(err.Error(), "missing tile") {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			return
+		}
+
+		// Anything accepted must be retrievable
+		if _, err := store.RetrieveImage(storedImage.ID); err != nil {
+			t.Fatalf("imported manifest is not retrievable: %v", err)
+		}
+	})
+}
+
+func FuzzStoreRetrieveRoundTrip(f *testing.F) {
+	store := newTagsTestStore(f)
+
+	f.Add(uint8(1), uint8(1), int64(1), false)
+	f.Add(uint8(4), uint8(4), int64(2), false)
+	f.Add(uint8(7), uint8(3), int64(3), true)
+	f.Add(uint8(13), uint8(9), int64(4), true)
+	f.Add(uint8(255), uint8(2), int64(5), false)
+
+	f.Fuzz(func(t *testing.T, width, height uint8, seed int64, alpha bool) {
+		if width == 0 || height == 0 {
+			t.Skip()
+		}
+		assertRoundTrip(t, store, randomImage(rand.New(rand.NewSource(seed)), int(width), int(height), alpha))
+	})
+}
+
+// TestStoreRetrieveRoundTripProperty checks that arbitrary images, including
+// odd dimensions and translucent pixels, survive a store and retrieve
+func TestStoreRetrieveRoundTripProperty(t *testing.T) {
+	store := newTagsTestStore(t)
+	rng := rand.New(rand.NewSource(42))
+
+	for i := 0; i < 50; i++ {
+		img := randomImage(rng, 1+rng.Intn(40), 1+rng.Intn(40), rng.Intn(2) == 0)
+		assertRoundTrip(t, store, img)
+	}
+}
+
+// randomImage generates noise mixed with flat runs, so tiles both repeat
+// and differ
+func randomImage(rng *rand.Rand, width, height int, alpha bool) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	var c color.NRGBA
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if rng.Intn(4) == 0 {
+				c = color.NRGBA{uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256)), 255}
+				if alpha {
+					c.A = uint8(rng.Intn(256))
+				}
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+// assertRoundTrip stores an image and checks every retrieved pixel. The store
+// keeps RGB only, so translucent pixels come back composited over black.
+func assertRoundTrip(t *testing.T, store *PebbleImageStore, img image.Image) {
+	t.Helper()
+
+	imageData, err := encodeImageToPNG(img)
+	if err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+	id := fmt.Sprintf("roundtrip/%x", ComputeTileHash(imageData))
+	if err := store.StoreImage(id, imageData); err != nil {
+		t.Fatalf("failed to store image: %v", err)
+	}
+
+	retrievedData, err := store.RetrieveImage(id)
+	if err != nil {
+		t.Fatalf("failed to retrieve image: %v", err)
+	}
+	retrieved, err := decodeImageFromBytes(retrievedData)
+	if err != nil {
+		t.Fatalf("failed to decode retrieved image: %v", err)
+	}
+
+	bounds := img.Bounds()
+	if retrieved.Bounds().Dx() != bounds.Dx() || retrieved.Bounds().Dy() != bounds.Dy() {
+		t.Fatalf("expected %v, got %v", bounds, retrieved.Bounds())
+	}
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			r, g, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			expected := color.RGBA{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), 255}
+			if got := color.RGBAModel.Convert(retrieved.At(x, y)); got != expected {
+				t.Fatalf("%dx%d image: pixel (%d, %d) is %v, expected %v", bounds.Dx(), bounds.Dy(), x, y, got, expected)
+			}
+		}
+	}
+}
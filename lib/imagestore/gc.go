@@ -0,0 +1,280 @@
+package imagestore
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// OrphanReport describes stored tiles that no live or trashed image
+// references
+type OrphanReport struct {
+	TotalTiles  int   // Tiles in the store
+	Orphaned    int   // Tiles no image references
+	OrphanBytes int64 // Stored bytes held by orphaned tiles
+	Purged      bool  // Whether the orphaned tiles were deleted
+}
+
+// referencedTiles marks every tile referenced by an image in the given
+// manifest buckets
+func referencedTiles(reader pebble.Reader, buckets ...[]byte) (map[TileID]bool, error) {
+	referenced := make(map[TileID]bool)
+
+	for _, bucket := range buckets {
+		prefix := makePrefixKey(bucket)
+		iter, err := reader.NewIter(&pebble.IterOptions{
+			LowerBound: prefix,
+			UpperBound: prefixUpperBound(prefix),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for iter.First(); iter.Valid(); iter.Next() {
+			var storedImage StoredImage
+			if err := decodeManifest(iter.Value(), &storedImage); err != nil {
+				iter.Close()
+				return nil, fmt.Errorf("failed to unmarshal image: %w", err)
+			}
+			for _, tileRef := range storedImage.TileRefs {
+				referenced[tileRef.TileID] = true
+			}
+		}
+
+		if err := iter.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	return referenced, nil
+}
+
+// scanOrphans marks referenced tiles, counting every tile left unmarked into
+// the report and passing its key and value to fn. Tiles and manifests are read from the same
+// reader, so a snapshot gives a consistent answer.
+func scanOrphans(reader pebble.Reader, report *OrphanReport, fn func(key, value []byte) error) error {
+	referenced, err := referencedTiles(reader, imagesBucket, trashBucket)
+	if err != nil {
+		return err
+	}
+
+	prefix := makePrefixKey(tilesBucket)
+	iter, err := reader.NewIter(&pebble.IterOptions{
+		LowerBound: prefix,
+		UpperBound: prefixUpperBound(prefix),
+	})
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+
+	for iter.First(); iter.Valid(); iter.Next() {
+		report.TotalTiles++
+		if referenced[tileIDFromKey(iter.Key())] {
+			continue
+		}
+		report.Orphaned++
+		report.OrphanBytes += storedTileSize(iter.Value())
+		if fn != nil {
+			if err := fn(iter.Key(), iter.Value()); err != nil {
+				return err
+			}
+		}
+	}
+	return iter.Error()
+}
+
+// FindOrphanedTiles reports tiles that no live or trashed image references
+// and the space deleting them would reclaim, without deleting anything. Tiles
+// uploaded ahead of their manifest, as during a sync, show up as orphans
+// until the manifest arrives.
+func (s *PebbleImageStore) FindOrphanedTiles() (*OrphanReport, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+
+	snapshot := s.db.NewSnapshot()
+	defer snapshot.Close()
+
+	report := &OrphanReport{}
+	if err := scanOrphans(snapshot, report, nil); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// PurgeOrphanedTiles deletes tiles that no live or trashed image references,
+// reporting what was removed. Copies in the cold store are deleted too.
+//
+// Orphans are found on a snapshot without blocking writes, then deleted in
+// chunks, each under the garbage collection lock. Before each chunk, images
+// changed since the snapshot, per the change feed, are checked for
+// references to the candidates. Between chunks the collection paces itself
+// and yields to foreground writes; Jobs reports its progress.
+func (s *PebbleImageStore) PurgeOrphanedTiles() (*OrphanReport, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+
+	job := s.startJob(JobGarbageCollection, 0)
+	report, err := s.purgeOrphanedTiles(job)
+	job.finish(err)
+	return report, err
+}
+
+// orphanCandidate is a tile unreferenced in the garbage collection snapshot
+type orphanCandidate struct {
+	tileID TileID
+	size   int64
+}
+
+func (s *PebbleImageStore) purgeOrphanedTiles(job *maintenanceJob) (*OrphanReport, error) {
+	// Changes are committed under changeMu, so the snapshot holds exactly
+	// the changes up to seq
+	s.changeMu.Lock()
+	snapshot := s.db.NewSnapshot()
+	seq := s.changeSeq
+	s.changeMu.Unlock()
+	defer snapshot.Close()
+
+	report := &OrphanReport{Purged: true}
+	var candidates []orphanCandidate
+	err := scanOrphans(snapshot, report, func(key, value []byte) error {
+		candidates = append(candidates, orphanCandidate{tileIDFromKey(key), storedTileSize(value)})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	job.setTotal(int64(len(candidates)))
+
+	// Count only what is actually deleted
+	report.Orphaned, report.OrphanBytes = 0, 0
+	for start := 0; start < len(candidates); start += maintenanceChunk {
+		chunk := candidates[start:min(start+maintenanceChunk, len(candidates))]
+		purged, bytes, next, err := s.purgeOrphanChunk(chunk, seq)
+		if err != nil {
+			return nil, err
+		}
+		seq = next
+		report.Orphaned += len(purged)
+		report.OrphanBytes += bytes
+
+		if err := s.deleteColdCopies(purged); err != nil {
+			return report, err
+		}
+		job.advance(int64(len(chunk)), int64(len(purged)), bytes)
+		job.pace(bytes)
+	}
+	return report, nil
+}
+
+// purgeOrphanChunk deletes the candidates no image changed since seq
+// references, returning them, their size and the sequence number of the
+// newest change checked
+func (s *PebbleImageStore) purgeOrphanChunk(chunk []orphanCandidate, seq uint64) ([]TileID, int64, uint64, error) {
+	s.gcMu.Lock()
+	defer s.gcMu.Unlock()
+
+	referenced, seq, err := s.referencedSince(seq)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	batch := s.db.NewBatch()
+	defer batch.Close()
+
+	var purged []TileID
+	var bytes int64
+	for _, candidate := range chunk {
+		if referenced[candidate.tileID] {
+			continue
+		}
+		if err := batch.Delete(tileKey(candidate.tileID), nil); err != nil {
+			return nil, 0, 0, err
+		}
+		purged = append(purged, candidate.tileID)
+		bytes += candidate.size
+	}
+	if err := batch.Commit(s.writeOpts); err != nil {
+		return nil, 0, 0, fmt.Errorf("failed to commit garbage collection: %w", err)
+	}
+	return purged, bytes, seq, nil
+}
+
+// referencedSince marks the tiles referenced by live or trashed images
+// changed after seq, returning the sequence number of the newest change.
+// The caller holds gcMu, so no write is adding references meanwhile. If
+// the change log no longer reaches back to seq, every image is scanned.
+func (s *PebbleImageStore) referencedSince(seq uint64) (map[TileID]bool, uint64, error) {
+	ids := make(map[string]bool)
+	for {
+		changes, err := s.Changes(seq, 1000)
+		if errors.Is(err, ErrChangesTruncated) {
+			last := s.LastChange()
+			referenced, err := referencedTiles(s.db, imagesBucket, trashBucket)
+			return referenced, last, err
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(changes) == 0 {
+			break
+		}
+		for _, change := range changes {
+			ids[change.ID] = true
+			seq = change.Seq
+		}
+	}
+
+	referenced := make(map[TileID]bool)
+	for id := range ids {
+		for _, bucket := range [][]byte{imagesBucket, trashBucket} {
+			value, closer, err := s.db.Get(makeKey(bucket, id))
+			if errors.Is(err, pebble.ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				return nil, 0, err
+			}
+			var storedImage StoredImage
+			err = decodeManifest(value, &storedImage)
+			closer.Close()
+			if err != nil {
+				return nil, 0, fmt.Errorf("failed to unmarshal image: %w", err)
+			}
+			for _, tileRef := range storedImage.TileRefs {
+				referenced[tileRef.TileID] = true
+			}
+		}
+	}
+	return referenced, seq, nil
+}
+
+// deleteColdCopies removes deleted tiles from the cold store. A tile read
+// back from the cold store keeps its copy there, so any deleted tile may
+// have one.
+func (s *PebbleImageStore) deleteColdCopies(tileIDs []TileID) error {
+	if s.config.ColdStore == nil {
+		return nil
+	}
+	for _, tileID := range tileIDs {
+		if err := s.config.ColdStore.DeleteTile(tileID); err != nil {
+			return fmt.Errorf("failed to delete tile %s from the cold tier: %w", tileID, err)
+		}
+	}
+	return nil
+}
+
+// CollectGarbage deletes tiles that no live or trashed image references,
+// returning the number of tiles deleted and the bytes they occupied
+func (s *PebbleImageStore) CollectGarbage() (int, int64, error) {
+	report, err := s.PurgeOrphanedTiles()
+	if err != nil {
+		return 0, 0, err
+	}
+	return report.Orphaned, report.OrphanBytes, nil
+}
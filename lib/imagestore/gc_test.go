@@ -0,0 +1,107 @@
+package imagestore
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestCollectGarbage(t *testing.T) {
+	store := newTagsTestStore(t, "kept", "trashed", "deleted")
+
+	// Give "deleted" its own tiles so they become unreferenced
+	other := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			other.Set(x, y, color.RGBA{uint8(x * 20), 99, uint8(y * 20), 255})
+		}
+	}
+	imageData, err := encodeImageToPNG(other)
+	if err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	if err := store.StoreImage("deleted", imageData); err != nil {
+		t.Fatalf("failed to store image: %v", err)
+	}
+
+	if err := store.DeleteImage("trashed"); err != nil {
+		t.Fatalf("failed to trash image: %v", err)
+	}
+	if err := store.deleteImagePermanently("deleted"); err != nil {
+		t.Fatalf("failed to delete image: %v", err)
+	}
+
+	before := store.GetStorageStats()
+
+	deleted, freed, err := store.CollectGarbage()
+	if err != nil {
+		t.Fatalf("failed to collect garbage: %v", err)
+	}
+	if deleted != 4 {
+		t.Errorf("expected 4 unreferenced tiles collected, got %d", deleted)
+	}
+	if freed <= 0 {
+		t.Errorf("expected freed bytes to be positive, got %d", freed)
+	}
+
+	after := store.GetStorageStats()
+	if after.UniqueTiles != before.UniqueTiles-4 {
+		t.Errorf("expected %d tiles to remain, got %d", before.UniqueTiles-4, after.UniqueTiles)
+	}
+
+	if _, err := store.RetrieveImage("kept"); err != nil {
+		t.Errorf("expected referenced image to survive collection: %v", err)
+	}
+	if err := store.UndeleteImage("trashed"); err != nil {
+		t.Fatalf("failed to restore trashed image: %v", err)
+	}
+	if _, err := store.RetrieveImage("trashed"); err != nil {
+		t.Errorf("expected trashed image tiles to survive collection: %v", err)
+	}
+}
+
+func TestFindOrphanedTiles(t *testing.T) {
+	store := newTagsTestStore(t, "kept", "deleted")
+	other := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			other.Set(x, y, color.RGBA{uint8(x * 20), 99, uint8(y * 20), 255})
+		}
+	}
+	imageData, _ := encodeImageToPNG(other)
+	if err := store.StoreImage("deleted", imageData); err != nil {
+		t.Fatalf("failed to store image: %v", err)
+	}
+	if err := store.deleteImagePermanently("deleted"); err != nil {
+		t.Fatalf("failed to delete image: %v", err)
+	}
+
+	report, err := store.FindOrphanedTiles()
+	if err != nil {
+		t.Fatalf("failed to find orphaned tiles: %v", err)
+	}
+	if report.Orphaned != 4 || report.OrphanBytes <= 0 || report.Purged {
+		t.Errorf("unexpected orphan report: %+v", report)
+	}
+
+	// Reporting leaves the tiles in place
+	if stats := store.GetStorageStats(); stats.UniqueTiles != report.TotalTiles {
+		t.Errorf("expected %d tiles after the report, got %d", report.TotalTiles, stats.UniqueTiles)
+	}
+
+	purged, err := store.PurgeOrphanedTiles()
+	if err != nil {
+		t.Fatalf("failed to purge orphaned tiles: %v", err)
+	}
+	if purged.Orphaned != report.Orphaned || purged.OrphanBytes != report.OrphanBytes || !purged.Purged {
+		t.Errorf("expected the purge to match the report %+v, got %+v", report, purged)
+	}
+
+	report, _ = store.FindOrphanedTiles()
+	if report.Orphaned != 0 || report.TotalTiles != purged.TotalTiles-purged.Orphaned {
+		t.Errorf("expected no orphans after the purge, got %+v", report)
+	}
+	if _, err := store.RetrieveImage("kept"); err != nil {
+		t.Errorf("expected referenced image to survive the purge: %v", err)
+	}
+}
@@ -0,0 +1,54 @@
+package imagestore
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+)
+
+// ErrUnsupportedFormat is returned for uploads in a format this binary was
+// built without a decoder for
+var ErrUnsupportedFormat = errors.New("unsupported image format")
+
+// heifDecoder decodes HEIC and AVIF images. It is nil unless the binary is
+// built with the heif tag; see heif_libheif.go.
+var heifDecoder func(data []byte) (image.Image, error)
+
+// heifFormat names the format of an ISO base media file holding a HEIC or
+// AVIF image, judged by the brands in its ftyp box, or returns "" for
+// anything else
+func heifFormat(data []byte) string {
+	if len(data) < 16 || string(data[4:8]) != "ftyp" {
+		return ""
+	}
+	size := int(binary.BigEndian.Uint32(data))
+	if size < 16 || size > len(data) {
+		size = len(data)
+	}
+
+	// The major brand, then the compatible brands after the minor version
+	brands := []string{string(data[8:12])}
+	for pos := 16; pos+4 <= size; pos += 4 {
+		brands = append(brands, string(data[pos:pos+4]))
+	}
+	format := ""
+	for _, brand := range brands {
+		switch brand {
+		case "avif", "avis":
+			return "AVIF"
+		case "heic", "heix", "heim", "heis", "hevc", "hevx":
+			format = "HEIC"
+		}
+	}
+	return format
+}
+
+// decodeHEIF decodes a HEIC or AVIF image, failing with ErrUnsupportedFormat
+// when no decoder is built in
+func decodeHEIF(data []byte, format string) (image.Image, error) {
+	if heifDecoder == nil {
+		return nil, fmt.Errorf("%w: %s support is not built in", ErrUnsupportedFormat, format)
+	}
+	return heifDecoder(data)
+}
@@ -0,0 +1,69 @@
+//go:build heif
+
+package imagestore
+
+/*
+#cgo pkg-config: libheif
+#include <libheif/heif.h>
+*/
+import "C"
+
+import (
+	"fmt"
+	"image"
+	"unsafe"
+)
+
+func init() {
+	heifDecoder = decodeLibheif
+}
+
+// decodeLibheif decodes the primary image of a HEIC or AVIF file with
+// libheif, which applies the container's rotation and mirroring. AVIF needs
+// libheif built with an AV1 decoder such as dav1d.
+func decodeLibheif(data []byte) (image.Image, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("libheif: empty input")
+	}
+
+	ctx := C.heif_context_alloc()
+	defer C.heif_context_free(ctx)
+
+	// libheif copies the input, so Go memory is only borrowed for the call
+	if err := C.heif_context_read_from_memory(ctx, unsafe.Pointer(&data[0]), C.size_t(len(data)), nil); err.code != C.heif_error_Ok {
+		return nil, libheifError(err)
+	}
+
+	var handle *C.struct_heif_image_handle
+	if err := C.heif_context_get_primary_image_handle(ctx, &handle); err.code != C.heif_error_Ok {
+		return nil, libheifError(err)
+	}
+	defer C.heif_image_handle_release(handle)
+
+	var img *C.struct_heif_image
+	if err := C.heif_decode_image(handle, &img, C.heif_colorspace_RGB, C.heif_chroma_interleaved_RGBA, nil); err.code != C.heif_error_Ok {
+		return nil, libheifError(err)
+	}
+	defer C.heif_image_release(img)
+
+	width := int(C.heif_image_get_width(img, C.heif_channel_interleaved))
+	height := int(C.heif_image_get_height(img, C.heif_channel_interleaved))
+	var stride C.int
+	plane := C.heif_image_get_plane_readonly(img, C.heif_channel_interleaved, &stride)
+	if plane == nil || width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("libheif: no pixel data")
+	}
+
+	// Rows may be padded past width*4 bytes
+	pixels := unsafe.Slice((*byte)(unsafe.Pointer(plane)), int(stride)*height)
+	out := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		copy(out.Pix[y*out.Stride:y*out.Stride+width*4], pixels[y*int(stride):])
+	}
+	return out, nil
+}
+
+// libheifError converts a libheif error
+func libheifError(err C.struct_heif_error) error {
+	return fmt.Errorf("libheif: %s", C.GoString(err.message))
+}
@@ -0,0 +1,51 @@
+package imagestore
+
+import (
+	"errors"
+	"testing"
+)
+
+// ftypBox builds the start of an ISO base media file with the given brands
+func ftypBox(major string, compatible ...string) []byte {
+	size := 16 + 4*len(compatible)
+	box := []byte{0, 0, 0, byte(size)}
+	box = append(box, "ftyp"+major+"\x00\x00\x00\x00"...)
+	for _, brand := range compatible {
+		box = append(box, brand...)
+	}
+	return append(box, "\x00\x00\x00\x08meta"...)
+}
+
+func TestHEIFFormat(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		expected string
+	}{
+		{"heic", ftypBox("heic", "mif1", "heic"), "HEIC"},
+		{"avif", ftypBox("avif", "mif1", "miaf"), "AVIF"},
+		{"generic brand with avif", ftypBox("mif1", "avif"), "AVIF"},
+		{"mp4", ftypBox("isom", "iso2", "mp41"), ""},
+		{"png", append([]byte(nil), pngSignature...), ""},
+	}
+	for _, tt := range tests {
+		if got := heifFormat(tt.data); got != tt.expected {
+			t.Errorf("%s: heifFormat = %q, expected %q", tt.name, got, tt.expected)
+		}
+	}
+}
+
+func TestStoreHEICWithoutDecoder(t *testing.T) {
+	if heifDecoder != nil {
+		t.Skip("built with a HEIF decoder")
+	}
+	store := newTagsTestStore(t)
+
+	err := store.StoreImage("phone", ftypBox("heic", "mif1", "heic"))
+	if !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("expected ErrUnsupportedFormat, got %v", err)
+	}
+	if _, err := store.StoreImageDryRun(ftypBox("avif", "mif1")); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("expected ErrUnsupportedFormat from a dry run, got %v", err)
+	}
+}
@@ -0,0 +1,184 @@
+package imagestore
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+// xyzToSRGB maps D50 XYZ, the connection space of ICC profiles, to linear
+// sRGB
+var xyzToSRGB = [3][3]float64{
+	{3.1338561, -1.6168667, -0.4906146},
+	{-0.9787684, 1.9161415, 0.0334540},
+	{0.0719453, -0.2289914, 1.4052427},
+}
+
+// iccProfile is the part of an RGB matrix/TRC profile needed to convert its
+// colors to sRGB
+type iccProfile struct {
+	toXYZ  [3][3]float64 // Linear RGB to D50 XYZ; columns are the colorants
+	curves [3]func(float64) float64
+}
+
+// parseICCProfile reads an RGB display profile described by colorants and
+// tone curves. Profiles built from lookup tables are not supported.
+func parseICCProfile(data []byte) (*iccProfile, error) {
+	if len(data) < 132 || string(data[36:40]) != "acsp" {
+		return nil, fmt.Errorf("not an ICC profile")
+	}
+	if string(data[16:20]) != "RGB " || string(data[20:24]) != "XYZ " {
+		return nil, fmt.Errorf("unsupported ICC profile: %q data in %q connection space", data[16:20], data[20:24])
+	}
+
+	tags := make(map[string][]byte)
+	count := int(binary.BigEndian.Uint32(data[128:]))
+	for i := 0; i < count && 132+12*(i+1) <= len(data); i++ {
+		entry := data[132+12*i:]
+		offset, size := int(binary.BigEndian.Uint32(entry[4:])), int(binary.BigEndian.Uint32(entry[8:]))
+		if offset < 0 || size < 0 || offset+size > len(data) {
+			return nil, fmt.Errorf("invalid ICC profile: tag %q lies outside the profile", entry[:4])
+		}
+		tags[string(entry[:4])] = data[offset : offset+size]
+	}
+
+	profile := &iccProfile{}
+	for c, name := range []string{"r", "g", "b"} {
+		colorant, ok := tags[name+"XYZ"]
+		if !ok || len(colorant) < 20 || string(colorant[:4]) != "XYZ " {
+			return nil, fmt.Errorf("unsupported ICC profile: no %sXYZ colorant", name)
+		}
+		for i := 0; i < 3; i++ {
+			profile.toXYZ[i][c] = s15Fixed16(colorant[8+4*i:])
+		}
+
+		curve, err := parseICCCurve(tags[name+"TRC"])
+		if err != nil {
+			return nil, fmt.Errorf("unsupported ICC profile: %sTRC: %w", name, err)
+		}
+		profile.curves[c] = curve
+	}
+	return profile, nil
+}
+
+// s15Fixed16 decodes an ICC signed 15.16 fixed-point number
+func s15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
+
+// parseICCCurve decodes a curv or para tone curve into a function from
+// encoded to linear values, both in [0, 1]
+func parseICCCurve(tag []byte) (func(float64) float64, error) {
+	if len(tag) < 12 {
+		return nil, fmt.Errorf("missing tone curve")
+	}
+
+	switch string(tag[:4]) {
+	case "curv":
+		n := int(binary.BigEndian.Uint32(tag[8:]))
+		switch {
+		case n == 0:
+			return func(x float64) float64 { return x }, nil
+		case n == 1 && len(tag) >= 14:
+			gamma := float64(binary.BigEndian.Uint16(tag[12:])) / 256
+			return func(x float64) float64 { return math.Pow(x, gamma) }, nil
+		case n > 1 && len(tag) >= 12+2*n:
+			table := make([]float64, n)
+			for i := range table {
+				table[i] = float64(binary.BigEndian.Uint16(tag[12+2*i:])) / 65535
+			}
+			return func(x float64) float64 {
+				pos := x * float64(n-1)
+				i := min(int(pos), n-2)
+				return table[i] + (table[i+1]-table[i])*(pos-float64(i))
+			}, nil
+		}
+	case "para":
+		// Parameter counts of function types 0-4
+		counts := []int{1, 3, 4, 5, 7}
+		kind := int(binary.BigEndian.Uint16(tag[8:]))
+		if kind >= len(counts) || len(tag) < 12+4*counts[kind] {
+			break
+		}
+		// Missing parameters take values that make each type a case of type 4
+		p := [7]float64{1, 1, 0, 1, 0, 0, 0}
+		for i := 0; i < counts[kind]; i++ {
+			p[i] = s15Fixed16(tag[12+4*i:])
+		}
+		g, a, b, c, d, e, f := p[0], p[1], p[2], p[3], p[4], p[5], p[6]
+		switch kind {
+		case 0:
+			return func(x float64) float64 { return math.Pow(x, g) }, nil
+		case 1, 2:
+			return func(x float64) float64 {
+				if a*x+b < 0 {
+					return c * float64(kind-1)
+				}
+				return math.Pow(a*x+b, g) + c*float64(kind-1)
+			}, nil
+		default:
+			if kind == 3 {
+				e, f = 0, 0
+			}
+			return func(x float64) float64 {
+				if x < d {
+					return c*x + f
+				}
+				return math.Pow(a*x+b, g) + e
+			}, nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported tone curve %q", tag[:4])
+}
+
+// toSRGB returns a copy of img with its colors converted from the profile
+// to sRGB. Alpha is left as it is.
+func (p *iccProfile) toSRGB(img image.Image) *image.NRGBA {
+	var linear [3][256]float64
+	for c := range linear {
+		for v := range linear[c] {
+			linear[c][v] = p.curves[c](float64(v) / 255)
+		}
+	}
+
+	var matrix [3][3]float64
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				matrix[i][j] += xyzToSRGB[i][k] * p.toXYZ[k][j]
+			}
+		}
+	}
+
+	// Encoding goes through a table fine enough that neighbouring 8-bit
+	// values stay apart
+	const steps = 65535
+	encode := make([]uint8, steps+1)
+	for i := range encode {
+		v := float64(i) / steps
+		if v <= 0.0031308 {
+			v *= 12.92
+		} else {
+			v = 1.055*math.Pow(v, 1/2.4) - 0.055
+		}
+		encode[i] = uint8(math.Round(v * 255))
+	}
+
+	bounds := img.Bounds()
+	out := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			src := color.NRGBAModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.NRGBA)
+			in := [3]float64{linear[0][src.R], linear[1][src.G], linear[2][src.B]}
+			var rgb [3]uint8
+			for i := range rgb {
+				v := matrix[i][0]*in[0] + matrix[i][1]*in[1] + matrix[i][2]*in[2]
+				rgb[i] = encode[int(math.Round(math.Max(0, math.Min(1, v))*steps))]
+			}
+			out.SetNRGBA(x, y, color.NRGBA{rgb[0], rgb[1], rgb[2], src.A})
+		}
+	}
+	return out
+}
@@ -0,0 +1,189 @@
+package imagestore
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+)
+
+// ImageCacheStats reports how well the reconstructed image cache is doing
+type ImageCacheStats struct {
+	MaxBytes  int64 // From Config.Resources; 0 when the cache is disabled
+	Bytes     int64 // Encoded image bytes held now
+	Entries   int   // Images held now
+	Hits      int64 // Retrievals served from the cache
+	Misses    int64 // Retrievals that reconstructed the image
+	Evictions int64 // Images dropped to make room
+}
+
+// imageCache keeps recently retrieved images as encoded PNGs, least
+// recently used first out. Entries are keyed by image ID and carry the
+// render key of the manifest they were built from, so an entry outlived by
+// its manifest is never served even if invalidation missed it.
+type imageCache struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // Front is most recently used
+	stats   ImageCacheStats
+}
+
+type imageCacheEntry struct {
+	id   string
+	key  string
+	data []byte
+}
+
+// newImageCache returns a cache holding up to maxBytes, or nil when
+// maxBytes is zero
+func newImageCache(maxBytes int64) *imageCache {
+	if maxBytes <= 0 {
+		return nil
+	}
+	return &imageCache{
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+		stats:   ImageCacheStats{MaxBytes: maxBytes},
+	}
+}
+
+// renderKey identifies what an image looks like when encoded: its size,
+// tiles, padding and embedded color profile. Tags, metadata and expiry
+// don't change the pixels, so they don't change the key.
+func renderKey(storedImage *StoredImage) string {
+	var iccProfile []byte
+	if storedImage.Source != nil {
+		iccProfile = storedImage.Source.ICCProfile
+	}
+	data, _ := json.Marshal(struct {
+		Width, Height int
+		TileRefs      []TileRef
+		Background    string
+		ICCProfile    []byte
+		Redactions    []Redaction
+		GridOffsetY   int
+		StripHeight   int
+		TileSize      int
+	}{storedImage.Width, storedImage.Height, storedImage.TileRefs, storedImage.Background, iccProfile, storedImage.Redactions, storedImage.GridOffsetY, storedImage.StripHeight, storedImage.TileSize})
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// get returns a copy of the cached image for id if it was built from a
+// manifest with the given render key
+func (c *imageCache) get(id, key string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[id]
+	if ok && element.Value.(*imageCacheEntry).key != key {
+		c.removeElement(element)
+		ok = false
+	}
+	if !ok {
+		c.stats.Misses++
+		return nil, false
+	}
+
+	c.stats.Hits++
+	c.order.MoveToFront(element)
+	return append([]byte(nil), element.Value.(*imageCacheEntry).data...), true
+}
+
+// put caches an encoded image, evicting the least recently used images to
+// make room. Images larger than the whole cache aren't kept.
+func (c *imageCache) put(id, key string, data []byte) {
+	if c == nil || int64(len(data)) > c.stats.MaxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[id]; ok {
+		c.removeElement(element)
+	}
+	for c.stats.Bytes+int64(len(data)) > c.stats.MaxBytes {
+		c.removeElement(c.order.Back())
+		c.stats.Evictions++
+	}
+
+	entry := &imageCacheEntry{id: id, key: key, data: append([]byte(nil), data...)}
+	c.entries[id] = c.order.PushFront(entry)
+	c.stats.Bytes += int64(len(data))
+	c.stats.Entries = len(c.entries)
+}
+
+// add caches an encoded image only if it fits without evicting anything,
+// reporting whether it did
+func (c *imageCache) add(id, key string, data []byte) bool {
+	if c == nil {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[id]; ok || c.stats.Bytes+int64(len(data)) > c.stats.MaxBytes {
+		return false
+	}
+	c.entries[id] = c.order.PushFront(&imageCacheEntry{id: id, key: key, data: append([]byte(nil), data...)})
+	c.stats.Bytes += int64(len(data))
+	c.stats.Entries = len(c.entries)
+	return true
+}
+
+// contains reports whether id is cached for the given render key, without
+// counting a hit or miss
+func (c *imageCache) contains(id, key string) bool {
+	if c == nil {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[id]
+	return ok && element.Value.(*imageCacheEntry).key == key
+}
+
+// invalidate drops the cached images for ids
+func (c *imageCache) invalidate(ids ...string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, id := range ids {
+		if element, ok := c.entries[id]; ok {
+			c.removeElement(element)
+		}
+	}
+}
+
+// removeElement drops an entry; the caller holds mu
+func (c *imageCache) removeElement(element *list.Element) {
+	entry := c.order.Remove(element).(*imageCacheEntry)
+	delete(c.entries, entry.id)
+	c.stats.Bytes -= int64(len(entry.data))
+	c.stats.Entries = len(c.entries)
+}
+
+// snapshot returns the current cache statistics
+func (c *imageCache) snapshot() ImageCacheStats {
+	if c == nil {
+		return ImageCacheStats{}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
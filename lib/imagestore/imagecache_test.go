@@ -0,0 +1,105 @@
+package imagestore
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+)
+
+func TestImageCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := newImageCache(10)
+
+	cache.put("a", "ka", []byte("aaaa"))
+	cache.put("b", "kb", []byte("bbbb"))
+	if _, ok := cache.get("a", "ka"); !ok {
+		t.Fatal("expected a to be cached")
+	}
+
+	// b is now the least recently used, so it makes room for c
+	cache.put("c", "kc", []byte("cccc"))
+	if _, ok := cache.get("b", "kb"); ok {
+		t.Error("expected b to be evicted")
+	}
+	if data, ok := cache.get("a", "ka"); !ok || string(data) != "aaaa" {
+		t.Errorf("expected a to survive, got %q, %v", data, ok)
+	}
+
+	// An entry built from an older manifest is dropped, not served
+	if _, ok := cache.get("a", "newer"); ok {
+		t.Error("expected a stale render key to miss")
+	}
+
+	// Images larger than the whole cache aren't kept
+	cache.put("huge", "kh", make([]byte, 11))
+	if _, ok := cache.get("huge", "kh"); ok {
+		t.Error("expected an oversized image not to be cached")
+	}
+
+	stats := cache.snapshot()
+	if stats.Entries != 1 || stats.Bytes != 4 || stats.Evictions != 1 || stats.Hits != 2 || stats.Misses != 3 {
+		t.Errorf("unexpected cache stats: %+v", stats)
+	}
+
+	// A nil cache is disabled
+	var disabled *imageCache
+	disabled.put("a", "ka", []byte("aaaa"))
+	if _, ok := disabled.get("a", "ka"); ok {
+		t.Error("expected a disabled cache to miss")
+	}
+}
+
+func TestRetrieveUsesImageCache(t *testing.T) {
+	config := DefaultConfig()
+	config.DatabasePath = filepath.Join(t.TempDir(), "test.db")
+	config.TileSize = 4
+	config.Resources.ImageCacheBytes = 1 << 20
+
+	store, err := NewPebbleImageStore(config)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	first, _ := encodeImageToPNG(createTestImage(8, 8))
+	if err := store.StoreImage("a", first); err != nil {
+		t.Fatalf("failed to store image: %v", err)
+	}
+
+	original, err := store.RetrieveImage("a")
+	if err != nil {
+		t.Fatalf("failed to retrieve image: %v", err)
+	}
+	cached, err := store.RetrieveImage("a")
+	if err != nil {
+		t.Fatalf("failed to retrieve image: %v", err)
+	}
+	if !bytes.Equal(original, cached) {
+		t.Error("expected the cached image to match the reconstruction")
+	}
+	if stats := store.GetStorageStats().ImageCache; stats.Hits != 1 || stats.Misses != 1 || stats.Entries != 1 {
+		t.Errorf("expected one miss then one hit, got %+v", stats)
+	}
+
+	// Overwriting the image invalidates its entry
+	second, _ := encodeImageToPNG(createTestImage(12, 8))
+	if err := store.StoreImage("a", second); err != nil {
+		t.Fatalf("failed to overwrite image: %v", err)
+	}
+	if stats := store.GetStorageStats().ImageCache; stats.Entries != 0 {
+		t.Errorf("expected the overwrite to invalidate the entry, got %+v", stats)
+	}
+	replaced, err := store.RetrieveImage("a")
+	if err != nil {
+		t.Fatalf("failed to retrieve image: %v", err)
+	}
+	if bytes.Equal(replaced, original) {
+		t.Error("expected the overwritten image, got the cached original")
+	}
+
+	if err := store.DeleteImage("a"); err != nil {
+		t.Fatalf("failed to delete image: %v", err)
+	}
+	if stats := store.GetStorageStats().ImageCache; stats.Entries != 0 || stats.Bytes != 0 {
+		t.Errorf("expected the delete to invalidate the entry, got %+v", stats)
+	}
+}
@@ -0,0 +1,64 @@
+package imagestore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// ImportRecord remembers an object that was imported from an external
+// source so later runs can skip it
+type ImportRecord struct {
+	ImageID    string
+	ETag       string // Version of the source object that was imported
+	ImportedAt time.Time
+}
+
+// importKey builds imports:<source>\x00<object key>
+func importKey(source, key string) []byte {
+	return makeKey(importBucket, source+"\x00"+key)
+}
+
+// LookupImport returns the import record for an object, or nil if the
+// object has not been imported from the source
+func (s *PebbleImageStore) LookupImport(source, key string) (*ImportRecord, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+
+	data, closer, err := s.db.Get(importKey(source, key))
+	if errors.Is(err, pebble.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read import record: %w", err)
+	}
+	defer closer.Close()
+
+	var record ImportRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal import record: %w", err)
+	}
+	return &record, nil
+}
+
+// RecordImport stores the import record for an object
+func (s *PebbleImageStore) RecordImport(source, key string, record ImportRecord) error {
+	if err := s.enter(); err != nil {
+		return err
+	}
+	defer s.leave()
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal import record: %w", err)
+	}
+	if err := s.db.Set(importKey(source, key), data, s.writeOpts); err != nil {
+		return fmt.Errorf("failed to store import record: %w", err)
+	}
+	return nil
+}
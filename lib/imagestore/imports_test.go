@@ -0,0 +1,41 @@
+package imagestore
+
+import (
+	"testing"
+	"time"
+)
+
+func TestImportRecords(t *testing.T) {
+	store := newTagsTestStore(t)
+
+	record, err := store.LookupImport("s3://shots", "2024/home.png")
+	if err != nil {
+		t.Fatalf("failed to look up import: %v", err)
+	}
+	if record != nil {
+		t.Fatalf("expected no record before import, got %+v", record)
+	}
+
+	importedAt := time.Now().UTC().Truncate(time.Second)
+	err = store.RecordImport("s3://shots", "2024/home.png", ImportRecord{ImageID: "2024/home", ETag: "abc", ImportedAt: importedAt})
+	if err != nil {
+		t.Fatalf("failed to record import: %v", err)
+	}
+
+	record, err = store.LookupImport("s3://shots", "2024/home.png")
+	if err != nil {
+		t.Fatalf("failed to look up import: %v", err)
+	}
+	if record == nil || record.ImageID != "2024/home" || record.ETag != "abc" || !record.ImportedAt.Equal(importedAt) {
+		t.Errorf("unexpected import record: %+v", record)
+	}
+
+	// Records are scoped to their source
+	record, err = store.LookupImport("s3://other", "2024/home.png")
+	if err != nil {
+		t.Fatalf("failed to look up import: %v", err)
+	}
+	if record != nil {
+		t.Errorf("expected no record for a different source, got %+v", record)
+	}
+}
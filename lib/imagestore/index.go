@@ -0,0 +1,25 @@
+package imagestore
+
+import "github.com/cockroachdb/pebble"
+
+// indexImage adds every secondary index entry for a live image to a batch
+func indexImage(batch *pebble.Batch, storedImage *StoredImage) error {
+	if err := indexTags(batch, storedImage.ID, storedImage.Tags); err != nil {
+		return err
+	}
+	if err := indexExpiry(batch, storedImage); err != nil {
+		return err
+	}
+	return indexSearchTokens(batch, storedImage)
+}
+
+// unindexImage removes every secondary index entry for an image from a batch
+func unindexImage(batch *pebble.Batch, storedImage *StoredImage) error {
+	if err := unindexTags(batch, storedImage.ID, storedImage.Tags); err != nil {
+		return err
+	}
+	if err := unindexExpiry(batch, storedImage); err != nil {
+		return err
+	}
+	return unindexSearchTokens(batch, storedImage)
+}
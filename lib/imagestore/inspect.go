@@ -0,0 +1,234 @@
+package imagestore
+
+import (
+	"fmt"
+	"image"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// TileInfo describes how a tile is stored. Tiles are always stored whole,
+// encoded with one of the store's codecs.
+type TileInfo struct {
+	ID          TileID
+	Codec       string // Codec of the stored encoding
+	StoredBytes int    // Size of the stored encoding
+	RawBytes    int    // Size of the decoded RGB data
+	References  int    // Tile references across live and trashed images
+	Images      int    // Distinct live and trashed images referencing the tile
+	Cold        bool   // Held in the cold store, with only a stub kept locally
+	Blob        bool   // Held in a blob file, with only a pointer kept in the database
+}
+
+// TileReference is one place an image uses a tile
+type TileReference struct {
+	ImageID   string        `json:"image_id"`
+	X         int           `json:"x"`
+	Y         int           `json:"y"`
+	Transform TileTransform `json:"transform,omitempty"`
+	Trashed   bool          `json:"trashed,omitempty"`
+}
+
+// InspectTile describes a stored tile, counting its references with a scan of
+// every manifest
+func (s *PebbleImageStore) InspectTile(tileID TileID) (*TileInfo, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+
+	value, closer, err := s.db.Get(tileKey(tileID))
+	if err != nil {
+		return nil, fmt.Errorf("tile not found: %s", tileID)
+	}
+	stored := append([]byte(nil), value...)
+	closer.Close()
+
+	// Inspecting a cold tile reads it without moving it back
+	cold, blob := isColdStub(stored), isBlobPointer(stored)
+	if cold {
+		if stored, err = s.fetchColdTile(tileID); err != nil {
+			return nil, err
+		}
+	}
+	if blob {
+		if stored, err = s.readBlobTile(tileID, stored); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := s.decompressTileData(stored)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress tile %s: %w", tileID, err)
+	}
+
+	refs, err := s.imageReferences(tileID)
+	if err != nil {
+		return nil, err
+	}
+	images := make(map[string]bool)
+	for _, ref := range refs {
+		images[ref.ImageID] = true
+	}
+
+	return &TileInfo{
+		ID:          tileID,
+		Codec:       s.codecName(stored),
+		StoredBytes: len(stored),
+		RawBytes:    len(data),
+		References:  len(refs),
+		Images:      len(images),
+		Cold:        cold,
+		Blob:        blob,
+	}, nil
+}
+
+// codecName names the codec of a stored tile encoding
+func (s *PebbleImageStore) codecName(stored []byte) string {
+	if p, ok := decodeBlobPointer(stored); ok {
+		stored = []byte{p.head}
+	}
+	if stored[0] == zstdFrameMagic {
+		return CodecZstd
+	}
+	if codec, ok := s.codecsByID[stored[0]]; ok {
+		return codec.Name()
+	}
+	return "unknown"
+}
+
+// TilePNG returns a stored tile as a PNG image
+func (s *PebbleImageStore) TilePNG(tileID TileID) ([]byte, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+
+	data, err := s.getTileData(tileID)
+	if err != nil {
+		return nil, err
+	}
+	if !isStripData(data, s.config.TileSize) {
+		return encodeImageToPNG(tileImage(data, s.config.TileSize, s.config.TileSize))
+	}
+
+	// A strip is as wide as the images using it
+	width, err := s.stripWidth(tileID, len(data))
+	if err != nil {
+		// Tiles of images stored with Config.AutoTileSize are squares of another size
+		for _, size := range []int{autoTileSmall, autoTileMedium, autoTileLarge} {
+			if len(data) == size*size*3 {
+				return encodeImageToPNG(tileImage(data, size, size))
+			}
+		}
+		return nil, err
+	}
+	return encodeImageToPNG(tileImage(data, width, len(data)/3/width))
+}
+
+// stripWidth finds the width of a stored strip of size bytes from the first
+// live or trashed image using it
+func (s *PebbleImageStore) stripWidth(tileID TileID, size int) (int, error) {
+	for _, bucket := range [][]byte{imagesBucket, trashBucket} {
+		prefix := makePrefixKey(bucket)
+		iter, err := s.db.NewIter(&pebble.IterOptions{
+			LowerBound: prefix,
+			UpperBound: prefixUpperBound(prefix),
+		})
+		if err != nil {
+			return 0, err
+		}
+
+		for iter.First(); iter.Valid(); iter.Next() {
+			var storedImage StoredImage
+			if err := decodeManifest(iter.Value(), &storedImage); err != nil {
+				iter.Close()
+				return 0, fmt.Errorf("failed to unmarshal image: %w", err)
+			}
+			if storedImage.StripHeight == 0 || storedImage.Width*storedImage.StripHeight*3 != size {
+				continue
+			}
+			for _, tileRef := range storedImage.TileRefs {
+				if tileRef.TileID == tileID {
+					iter.Close()
+					return storedImage.Width, nil
+				}
+			}
+		}
+
+		if err := iter.Close(); err != nil {
+			return 0, err
+		}
+	}
+	return 0, fmt.Errorf("tile not found: no image uses strip %s", tileID)
+}
+
+// TileReferences lists every live and trashed image position using a stored
+// tile
+func (s *PebbleImageStore) TileReferences(tileID TileID) ([]TileReference, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+
+	_, closer, err := s.db.Get(tileKey(tileID))
+	if err != nil {
+		return nil, fmt.Errorf("tile not found: %s", tileID)
+	}
+	closer.Close()
+
+	return s.imageReferences(tileID)
+}
+
+// imageReferences scans every manifest for references to a tile
+func (s *PebbleImageStore) imageReferences(tileID TileID) ([]TileReference, error) {
+	refs := []TileReference{}
+
+	for _, bucket := range [][]byte{imagesBucket, trashBucket} {
+		prefix := makePrefixKey(bucket)
+		iter, err := s.db.NewIter(&pebble.IterOptions{
+			LowerBound: prefix,
+			UpperBound: prefixUpperBound(prefix),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for iter.First(); iter.Valid(); iter.Next() {
+			var storedImage StoredImage
+			if err := decodeManifest(iter.Value(), &storedImage); err != nil {
+				iter.Close()
+				return nil, fmt.Errorf("failed to unmarshal image: %w", err)
+			}
+			for _, tileRef := range storedImage.TileRefs {
+				if tileRef.TileID == tileID {
+					refs = append(refs, TileReference{
+						ImageID:   storedImage.ID,
+						X:         tileRef.X,
+						Y:         tileRef.Y,
+						Transform: tileRef.Transform,
+						Trashed:   string(bucket) == string(trashBucket),
+					})
+				}
+			}
+		}
+
+		if err := iter.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	return refs, nil
+}
+
+// tileImage converts raw RGB tile data to an opaque image
+func tileImage(data []byte, width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i, j := 0, 0; i+2 < len(data); i, j = i+3, j+4 {
+		img.Pix[j] = data[i]
+		img.Pix[j+1] = data[i+1]
+		img.Pix[j+2] = data[i+2]
+		img.Pix[j+3] = 255
+	}
+	return img
+}
@@ -0,0 +1,62 @@
+package imagestore
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestInspectTile(t *testing.T) {
+	store := newTagsTestStore(t, "first", "second")
+	store.DeleteImage("second")
+
+	storedImage, _ := store.GetManifest("first")
+	tileID := storedImage.TileRefs[0].TileID
+
+	info, err := store.InspectTile(tileID)
+	if err != nil {
+		t.Fatalf("failed to inspect tile: %v", err)
+	}
+	if info.Codec != CodecZstd || info.RawBytes != 4*4*3 || info.StoredBytes == 0 {
+		t.Errorf("unexpected tile info: %+v", info)
+	}
+	if info.Images != 2 || info.References < 2 {
+		t.Errorf("expected references from the live and trashed image, got %+v", info)
+	}
+
+	refs, err := store.TileReferences(tileID)
+	if err != nil {
+		t.Fatalf("failed to list tile references: %v", err)
+	}
+	trashed := 0
+	for _, ref := range refs {
+		if ref.Trashed {
+			trashed++
+			if ref.ImageID != "second" {
+				t.Errorf("unexpected trashed reference %+v", ref)
+			}
+		}
+	}
+	if len(refs) != info.References || trashed == 0 {
+		t.Errorf("expected %d references including trashed ones, got %+v", info.References, refs)
+	}
+
+	pngData, err := store.TilePNG(tileID)
+	if err != nil {
+		t.Fatalf("failed to render tile: %v", err)
+	}
+	img, err := png.Decode(bytes.NewReader(pngData))
+	if err != nil || img.Bounds().Dx() != 4 || img.Bounds().Dy() != 4 {
+		t.Errorf("expected a 4x4 PNG, got %v (%v)", img, err)
+	}
+
+	for _, missing := range []TileID{"nope", GenerateTileID(ComputeTileHash([]byte("absent")))} {
+		if _, err := store.InspectTile(missing); err == nil || !strings.Contains(err.Error(), "not found") {
+			t.Errorf("expected not found for %s, got %v", missing, err)
+		}
+		if _, err := store.TileReferences(missing); err == nil || !strings.Contains(err.Error(), "not found") {
+			t.Errorf("expected not found references for %s, got %v", missing, err)
+		}
+	}
+}
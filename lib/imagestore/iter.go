@@ -0,0 +1,111 @@
+package imagestore
+
+import (
+	"context"
+	"fmt"
+	"iter"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// TileSummary is what Tiles reports about a tile without decoding it
+type TileSummary struct {
+	ID          TileID
+	Codec       string // Codec of the stored encoding; empty for cold tiles
+	StoredBytes int    // Size of the stored encoding, just the stub for cold tiles
+	Cold        bool   // Held in the cold store
+	Blob        bool   // Held in a blob file
+}
+
+// Images iterates over every live image's manifest in ID order, reading
+// one at a time so that large stores can be walked without holding every ID
+// in memory the way ListImages does. The walk sees the store as it was when
+// it started. It stops when the loop breaks, ctx is cancelled or a manifest
+// can't be read, yielding the error with a nil manifest.
+func (s *PebbleImageStore) Images(ctx context.Context) iter.Seq2[*StoredImage, error] {
+	return func(yield func(*StoredImage, error) bool) {
+		if err := s.enter(); err != nil {
+			yield(nil, err)
+			return
+		}
+		defer s.leave()
+
+		prefix := makePrefixKey(imagesBucket)
+		it, err := s.db.NewIter(&pebble.IterOptions{
+			LowerBound: prefix,
+			UpperBound: prefixUpperBound(prefix),
+		})
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		defer it.Close()
+
+		for it.First(); it.Valid(); it.Next() {
+			if err := ctx.Err(); err != nil {
+				yield(nil, err)
+				return
+			}
+			var storedImage StoredImage
+			if err := decodeManifest(it.Value(), &storedImage); err != nil {
+				yield(nil, fmt.Errorf("failed to unmarshal image %s: %w", it.Key()[len(prefix):], err))
+				return
+			}
+			if !yield(&storedImage, nil) {
+				return
+			}
+		}
+		if err := it.Error(); err != nil {
+			yield(nil, err)
+		}
+	}
+}
+
+// Tiles iterates over every stored tile in key order, with the same
+// semantics as Images
+func (s *PebbleImageStore) Tiles(ctx context.Context) iter.Seq2[TileSummary, error] {
+	return func(yield func(TileSummary, error) bool) {
+		if err := s.enter(); err != nil {
+			yield(TileSummary{}, err)
+			return
+		}
+		defer s.leave()
+
+		prefix := makePrefixKey(tilesBucket)
+		it, err := s.db.NewIter(&pebble.IterOptions{
+			LowerBound: prefix,
+			UpperBound: prefixUpperBound(prefix),
+		})
+		if err != nil {
+			yield(TileSummary{}, err)
+			return
+		}
+		defer it.Close()
+
+		for it.First(); it.Valid(); it.Next() {
+			if err := ctx.Err(); err != nil {
+				yield(TileSummary{}, err)
+				return
+			}
+			value := it.Value()
+			if len(value) == 0 {
+				continue
+			}
+			summary := TileSummary{
+				ID:          tileIDFromKey(it.Key()),
+				StoredBytes: int(storedTileSize(value)),
+				Cold:        isColdStub(value),
+				Blob:        isBlobPointer(value),
+			}
+			if !summary.Cold {
+				summary.Codec = s.codecName(value)
+			}
+			if !yield(summary, nil) {
+				return
+			}
+		}
+		if err := it.Error(); err != nil {
+			yield(TileSummary{}, err)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package imagestore
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestImagesIterator(t *testing.T) {
+	store := newTagsTestStore(t, "c", "a", "b")
+	if err := store.DeleteImage("b"); err != nil {
+		t.Fatalf("failed to delete image: %v", err)
+	}
+
+	var ids []string
+	for storedImage, err := range store.Images(context.Background()) {
+		if err != nil {
+			t.Fatalf("iteration failed: %v", err)
+		}
+		if len(storedImage.TileRefs) != 4 {
+			t.Errorf("expected 4 tile refs for %s, got %d", storedImage.ID, len(storedImage.TileRefs))
+		}
+		ids = append(ids, storedImage.ID)
+	}
+	if len(ids) != 2 || ids[0] != "a" || ids[1] != "c" {
+		t.Errorf("expected live images [a c] in order, got %v", ids)
+	}
+
+	// Breaking out early stops the walk
+	count := 0
+	for range store.Images(context.Background()) {
+		count++
+		break
+	}
+	if count != 1 {
+		t.Errorf("expected one image before break, got %d", count)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for storedImage, err := range store.Images(ctx) {
+		if storedImage != nil || !errors.Is(err, context.Canceled) {
+			t.Errorf("expected cancellation error, got %v, %v", storedImage, err)
+		}
+	}
+}
+
+func TestTilesIterator(t *testing.T) {
+	store := newTagsTestStore(t, "a", "b")
+	stats := store.GetStorageStats()
+
+	seen := make(map[TileID]bool)
+	var storedBytes int64
+	for tile, err := range store.Tiles(context.Background()) {
+		if err != nil {
+			t.Fatalf("iteration failed: %v", err)
+		}
+		if seen[tile.ID] {
+			t.Errorf("tile %s yielded twice", tile.ID)
+		}
+		seen[tile.ID] = true
+		storedBytes += int64(tile.StoredBytes)
+
+		if tile.Codec != CodecZstd || tile.Cold {
+			t.Errorf("expected a local zstd tile, got %+v", tile)
+		}
+		if _, err := store.InspectTile(tile.ID); err != nil {
+			t.Errorf("yielded ID %s doesn't resolve: %v", tile.ID, err)
+		}
+	}
+	if len(seen) != stats.UniqueTiles || storedBytes != stats.StorageBytes {
+		t.Errorf("expected %d tiles in %d bytes, got %d in %d", stats.UniqueTiles, stats.StorageBytes, len(seen), storedBytes)
+	}
+}
@@ -0,0 +1,100 @@
+package imagestore
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Operations timed by the store, the keys of StorageStats.Operations
+const (
+	OperationStore    = "store"
+	OperationRetrieve = "retrieve"
+)
+
+// latencySamples is how many recent calls of each operation percentiles are
+// computed over
+const latencySamples = 1024
+
+// OperationStats summarizes the latency of one kind of operation
+type OperationStats struct {
+	Count int64         // Successful calls since the store opened
+	Slow  int64         // Calls slower than Config.SlowOperationThreshold
+	P50   time.Duration // Percentiles over the most recent calls
+	P95   time.Duration
+	P99   time.Duration
+	Max   time.Duration
+}
+
+// latencyTracker keeps a window of recent durations per operation and logs
+// the calls slower than a threshold
+type latencyTracker struct {
+	threshold time.Duration // 0 disables the slow-operation log
+
+	mu         sync.Mutex
+	operations map[string]*latencyWindow
+}
+
+type latencyWindow struct {
+	samples []time.Duration // Ring buffer of the latest latencySamples calls
+	count   int64
+	slow    int64
+}
+
+func newLatencyTracker(threshold time.Duration) *latencyTracker {
+	return &latencyTracker{threshold: threshold, operations: make(map[string]*latencyWindow)}
+}
+
+// observe records a completed call. detail describes the image's shape for
+// the slow-operation log, so a pathological image can be recognized from it.
+func (t *latencyTracker) observe(operation, id string, elapsed time.Duration, detail string) {
+	t.mu.Lock()
+	window := t.operations[operation]
+	if window == nil {
+		window = &latencyWindow{samples: make([]time.Duration, 0, latencySamples)}
+		t.operations[operation] = window
+	}
+	if len(window.samples) < latencySamples {
+		window.samples = append(window.samples, elapsed)
+	} else {
+		window.samples[window.count%latencySamples] = elapsed
+	}
+	window.count++
+	slow := t.threshold > 0 && elapsed > t.threshold
+	if slow {
+		window.slow++
+	}
+	t.mu.Unlock()
+
+	if slow {
+		fmt.Printf("Warning: slow %s of %s took %s: %s\n", operation, id, elapsed.Round(time.Millisecond), detail)
+	}
+}
+
+// snapshot returns the statistics of every operation observed so far
+func (t *latencyTracker) snapshot() map[string]OperationStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	stats := make(map[string]OperationStats, len(t.operations))
+	for operation, window := range t.operations {
+		sorted := slices.Clone(window.samples)
+		slices.Sort(sorted)
+		stats[operation] = OperationStats{
+			Count: window.count,
+			Slow:  window.slow,
+			P50:   percentile(sorted, 0.50),
+			P95:   percentile(sorted, 0.95),
+			P99:   percentile(sorted, 0.99),
+			Max:   sorted[len(sorted)-1],
+		}
+	}
+	return stats
+}
+
+// percentile returns the nearest-rank percentile p of sorted durations
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(p*float64(len(sorted))+0.5) - 1
+	return sorted[max(0, min(rank, len(sorted)-1))]
+}
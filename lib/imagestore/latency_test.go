@@ -0,0 +1,46 @@
+package imagestore
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLatencyTrackerPercentiles(t *testing.T) {
+	tracker := newLatencyTracker(95 * time.Millisecond)
+	for i := 1; i <= 100; i++ {
+		tracker.observe(OperationRetrieve, "a", time.Duration(i)*time.Millisecond, "")
+	}
+
+	stats := tracker.snapshot()[OperationRetrieve]
+	if stats.Count != 100 || stats.Slow != 5 {
+		t.Errorf("expected 100 calls with 5 slow, got %+v", stats)
+	}
+	if stats.P50 != 50*time.Millisecond || stats.P95 != 95*time.Millisecond || stats.P99 != 99*time.Millisecond || stats.Max != 100*time.Millisecond {
+		t.Errorf("unexpected percentiles: %+v", stats)
+	}
+
+	// Percentiles cover only the most recent calls
+	for range latencySamples {
+		tracker.observe(OperationRetrieve, "a", time.Millisecond, "")
+	}
+	if stats := tracker.snapshot()[OperationRetrieve]; stats.Max != time.Millisecond || stats.Count != 100+latencySamples {
+		t.Errorf("expected older calls to age out, got %+v", stats)
+	}
+}
+
+func TestStorageStatsReportOperations(t *testing.T) {
+	store := newTagsTestStore(t)
+
+	imageData, _ := encodeImageToPNG(createTestImage(8, 8))
+	if err := store.StoreImage("a", imageData); err != nil {
+		t.Fatalf("failed to store image: %v", err)
+	}
+	if _, err := store.RetrieveImage("a"); err != nil {
+		t.Fatalf("failed to retrieve image: %v", err)
+	}
+
+	operations := store.GetStorageStats().Operations
+	if operations[OperationStore].Count != 1 || operations[OperationRetrieve].Count != 1 {
+		t.Errorf("expected one store and one retrieval, got %+v", operations)
+	}
+}
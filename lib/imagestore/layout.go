@@ -0,0 +1,111 @@
+package imagestore
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// keyLayoutVersion identifies the key layout written by this version of the
+// store. Version 1 keys tiles by their raw hash rather than its hex form.
+const keyLayoutVersion = 1
+
+// layoutKey records the key layout version of a database
+var layoutKey = makeKey(metaBucket, "layout")
+
+// migrationBatchSize bounds the number of tiles rewritten per batch
+const migrationBatchSize = 1000
+
+// keyLayout returns the key layout version recorded in a database; databases
+// predating the record are version 0
+func keyLayout(db *pebble.DB) (int, error) {
+	value, closer, err := db.Get(layoutKey)
+	if err == pebble.ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer closer.Close()
+
+	version, err := strconv.Atoi(string(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid key layout version: %w", err)
+	}
+	return version, nil
+}
+
+// migrateKeyLayout upgrades a database written with an older key layout.
+// Every step is idempotent, so a migration interrupted by a crash simply
+// continues the next time the store is opened.
+func migrateKeyLayout(db *pebble.DB) error {
+	version, err := keyLayout(db)
+	if err != nil {
+		return err
+	}
+
+	if version > keyLayoutVersion {
+		return fmt.Errorf("database key layout version %d is newer than supported version %d", version, keyLayoutVersion)
+	}
+	if version == keyLayoutVersion {
+		return nil
+	}
+
+	if version < 1 {
+		migrated, err := migrateTileKeys(db)
+		if err != nil {
+			return err
+		}
+		if migrated > 0 {
+			fmt.Printf("Migrated %d tile keys to binary tile IDs\n", migrated)
+		}
+	}
+
+	return db.Set(layoutKey, []byte(strconv.Itoa(keyLayoutVersion)), pebble.Sync)
+}
+
+// migrateTileKeys rewrites tiles keyed by their hex tile ID under binary keys
+func migrateTileKeys(db *pebble.DB) (int, error) {
+	prefix := makePrefixKey(tilesBucket)
+	iter, err := db.NewIter(&pebble.IterOptions{
+		LowerBound: prefix,
+		UpperBound: prefixUpperBound(prefix),
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer iter.Close()
+
+	batch := db.NewBatch()
+	defer func() { batch.Close() }()
+
+	migrated := 0
+	for iter.First(); iter.Valid(); iter.Next() {
+		key := tileKey(TileID(iter.Key()[len(prefix):]))
+		if string(key) == string(iter.Key()) {
+			continue
+		}
+
+		if err := batch.Set(key, iter.Value(), pebble.Sync); err != nil {
+			return migrated, err
+		}
+		if err := batch.Delete(iter.Key(), pebble.Sync); err != nil {
+			return migrated, err
+		}
+		migrated++
+
+		if batch.Count() >= 2*migrationBatchSize {
+			if err := batch.Commit(pebble.Sync); err != nil {
+				return migrated, err
+			}
+			batch.Close()
+			batch = db.NewBatch()
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return migrated, err
+	}
+
+	return migrated, batch.Commit(pebble.Sync)
+}
@@ -0,0 +1,121 @@
+package imagestore
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/cockroachdb/pebble"
+)
+
+func TestTileKey(t *testing.T) {
+	tileID := GenerateTileID(ComputeTileHash([]byte("tile")))
+
+	key := tileKey(tileID)
+	if len(key) != len(tilesBucket)+1+len(TileHash{}) {
+		t.Errorf("expected a binary tile key, got %d bytes", len(key))
+	}
+	if got := tileIDFromKey(key); got != tileID {
+		t.Errorf("expected %s back from key, got %s", tileID, got)
+	}
+
+	// IDs that aren't lowercase hex digests can't collide with stored tiles
+	for _, invalid := range []TileID{"short", TileID(bytes.ToUpper([]byte(tileID)))} {
+		if got := tileKey(invalid); !bytes.Equal(got, makeKey(tilesBucket, string(invalid))) {
+			t.Errorf("expected %s to be keyed as-is, got %q", invalid, got)
+		}
+	}
+}
+
+func TestPrefixUpperBound(t *testing.T) {
+	tests := []struct {
+		prefix   []byte
+		expected []byte
+	}{
+		{[]byte("tiles:"), []byte("tiles;")},
+		{[]byte{'a', 0xFF}, []byte{'b'}},
+		{[]byte{0xFF, 0xFF}, nil},
+	}
+	for _, test := range tests {
+		if got := prefixUpperBound(test.prefix); !bytes.Equal(got, test.expected) {
+			t.Errorf("prefixUpperBound(%q) = %q, expected %q", test.prefix, got, test.expected)
+		}
+	}
+
+	// Binary tile keys may contain 0xFF right after the bucket prefix
+	key := makeKey(tilesBucket, "\xff\xff")
+	if bytes.Compare(key, prefixUpperBound(makePrefixKey(tilesBucket))) >= 0 {
+		t.Error("tile key starting with 0xFF falls outside the bucket bounds")
+	}
+}
+
+func TestMigrateTileKeys(t *testing.T) {
+	config := DefaultConfig()
+	config.DatabasePath = filepath.Join(t.TempDir(), "legacy.db")
+	config.TileSize = 4
+
+	store, err := NewPebbleImageStore(config)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	imageData, _ := encodeImageToPNG(createTestImage(8, 8))
+	if err := store.StoreImage("legacy", imageData); err != nil {
+		t.Fatalf("failed to store image: %v", err)
+	}
+	expected, _ := store.RetrieveImage("legacy")
+
+	// Rewrite the database with hex tile keys and no layout version
+	storedImage, _ := store.GetManifest("legacy")
+	for _, tileRef := range storedImage.TileRefs {
+		value, closer, err := store.db.Get(tileKey(tileRef.TileID))
+		if err != nil {
+			continue // Repeated tile, already rewritten
+		}
+		value = append([]byte(nil), value...)
+		closer.Close()
+		store.db.Set(makeKey(tilesBucket, string(tileRef.TileID)), value, pebble.Sync)
+		store.db.Delete(tileKey(tileRef.TileID), pebble.Sync)
+	}
+	store.db.Delete(layoutKey, pebble.Sync)
+	store.Close()
+
+	store, err = NewPebbleImageStore(config)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+
+	retrieved, err := store.RetrieveImage("legacy")
+	if err != nil {
+		t.Fatalf("failed to retrieve migrated image: %v", err)
+	}
+	if !bytes.Equal(retrieved, expected) {
+		t.Error("migrated image differs from the original")
+	}
+
+	if _, closer, err := store.db.Get(makeKey(tilesBucket, string(storedImage.TileRefs[0].TileID))); err == nil {
+		closer.Close()
+		t.Error("hex tile key still present after migration")
+	}
+	if stats := store.GetStorageStats(); stats.UniqueTiles != 4 {
+		t.Errorf("expected 4 unique tiles after migration, got %d", stats.UniqueTiles)
+	}
+}
+
+func TestRebuildSearchIndexDropsStaleEntries(t *testing.T) {
+	store := newTagsTestStore(t, "kept")
+	store.db.Set(searchIndexKey("stale", "gone"), nil, pebble.Sync)
+
+	if err := store.RebuildSearchIndex(); err != nil {
+		t.Fatalf("failed to rebuild search index: %v", err)
+	}
+
+	if _, closer, err := store.db.Get(searchIndexKey("stale", "gone")); err == nil {
+		closer.Close()
+		t.Error("stale search entry survived the rebuild")
+	}
+	matches, err := store.searchToken("kept")
+	if err != nil || !matches["kept"] {
+		t.Errorf("expected the live image to stay indexed, got %v (%v)", matches, err)
+	}
+}
@@ -0,0 +1,209 @@
+package imagestore
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Names of the maintenance jobs reported by Jobs
+const (
+	JobGarbageCollection = "garbage_collection"
+	JobRecompression     = "recompression"
+	JobColdTierOffload   = "cold_tier_offload"
+	JobBlobCollection    = "blob_collection"
+)
+
+// maintenanceChunk is how many keys a maintenance job writes per batch.
+// Short commits let foreground writes in between and keep an interrupted
+// job's progress.
+const maintenanceChunk = 1000
+
+// maintenanceQuiet is how long after the last foreground write finished
+// maintenance still counts the store as busy
+const maintenanceQuiet = 50 * time.Millisecond
+
+// maintenancePoll is how often a yielding job checks whether foreground
+// writes are done
+const maintenancePoll = 10 * time.Millisecond
+
+// JobProgress describes the current or latest run of a maintenance job
+type JobProgress struct {
+	Name      string        `json:"name"`
+	Running   bool          `json:"running"`
+	Started   time.Time     `json:"started"`
+	Finished  time.Time     `json:"finished"`        // Zero while running
+	Processed int64         `json:"processed"`       // Keys examined
+	Total     int64         `json:"total,omitempty"` // Keys to examine, when known up front
+	Written   int64         `json:"written"`         // Keys rewritten or deleted
+	Bytes     int64         `json:"bytes"`           // Bytes read and written
+	Yielded   time.Duration `json:"yielded"`         // Time paused for foreground writes
+	Throttled time.Duration `json:"throttled"`       // Time paused by Config.MaintenanceBytesPerSecond
+	Error     string        `json:"error,omitempty"`
+}
+
+// maintenanceState tracks foreground writes, which maintenance yields to,
+// and the progress of maintenance jobs
+type maintenanceState struct {
+	foreground     atomic.Int64 // Image writes admitted and not yet finished
+	lastForeground atomic.Int64 // When the latest image write finished, in Unix nanoseconds
+
+	mu   sync.Mutex
+	jobs map[string]*JobProgress // Latest run by job name
+}
+
+// maintenanceJob is a running maintenance job
+type maintenanceJob struct {
+	store    *PebbleImageStore
+	progress *JobProgress // Guarded by store.maintenance.mu
+	paced    time.Time    // End of the previous pace
+}
+
+// acquireWrite admits an image write through the write queue and counts it
+// as foreground work that maintenance yields to
+func (s *PebbleImageStore) acquireWrite() error {
+	return s.acquireWriteContext(context.Background())
+}
+
+// acquireWriteContext is acquireWrite, giving up once ctx is done
+func (s *PebbleImageStore) acquireWriteContext(ctx context.Context) error {
+	if err := s.writes.acquireContext(ctx); err != nil {
+		return err
+	}
+	s.maintenance.foreground.Add(1)
+	return nil
+}
+
+// releaseWrite finishes a write admitted by acquireWrite
+func (s *PebbleImageStore) releaseWrite() {
+	s.maintenance.lastForeground.Store(time.Now().UnixNano())
+	s.maintenance.foreground.Add(-1)
+	s.writes.release()
+}
+
+// foregroundBusy reports whether image writes are running or finished too
+// recently for maintenance to resume
+func (s *PebbleImageStore) foregroundBusy() bool {
+	if s.maintenance.foreground.Load() > 0 {
+		return true
+	}
+	last := s.maintenance.lastForeground.Load()
+	return last != 0 && time.Since(time.Unix(0, last)) < maintenanceQuiet
+}
+
+// startJob records the start of a maintenance job, replacing the progress
+// of its previous run
+func (s *PebbleImageStore) startJob(name string, total int64) *maintenanceJob {
+	now := time.Now()
+	progress := &JobProgress{Name: name, Running: true, Started: now, Total: total}
+
+	s.maintenance.mu.Lock()
+	if s.maintenance.jobs == nil {
+		s.maintenance.jobs = make(map[string]*JobProgress)
+	}
+	s.maintenance.jobs[name] = progress
+	s.maintenance.mu.Unlock()
+
+	return &maintenanceJob{store: s, progress: progress, paced: now}
+}
+
+// setTotal records how many keys the job will examine once it is known
+func (j *maintenanceJob) setTotal(total int64) {
+	j.store.maintenance.mu.Lock()
+	j.progress.Total = total
+	j.store.maintenance.mu.Unlock()
+}
+
+// advance adds to the job's counts
+func (j *maintenanceJob) advance(processed, written, bytes int64) {
+	j.store.maintenance.mu.Lock()
+	j.progress.Processed += processed
+	j.progress.Written += written
+	j.progress.Bytes += bytes
+	j.store.maintenance.mu.Unlock()
+}
+
+// finish records the end of the job and its error, if any
+func (j *maintenanceJob) finish(err error) {
+	j.store.maintenance.mu.Lock()
+	defer j.store.maintenance.mu.Unlock()
+	j.progress.Running = false
+	j.progress.Finished = time.Now()
+	if err != nil {
+		j.progress.Error = err.Error()
+	}
+}
+
+// pace is called between a job's chunks, holding no locks, after bytes of
+// IO. It sleeps long enough to keep the job under
+// Config.MaintenanceBytesPerSecond, then waits while foreground writes are
+// running, for at most Config.MaintenanceYield so the job still advances
+// under constant load. It returns early once the store is closing.
+func (j *maintenanceJob) pace(bytes int64) {
+	j.throttle(bytes)
+
+	s := j.store
+	limit := s.config.MaintenanceYield
+	if limit <= 0 {
+		return
+	}
+	start := time.Now()
+	for time.Since(start) < limit && s.foregroundBusy() {
+		if !s.sleepMaintenance(maintenancePoll) {
+			break
+		}
+	}
+	yielded := time.Since(start)
+
+	j.paced = time.Now()
+	s.maintenance.mu.Lock()
+	j.progress.Yielded += yielded
+	s.maintenance.mu.Unlock()
+}
+
+// throttle is the first half of pace, keeping the job under
+// Config.MaintenanceBytesPerSecond without yielding. Jobs that hold gcMu
+// between chunks use it alone: a pending collection blocks new writes
+// behind them, so waiting for writes would only stall both.
+func (j *maintenanceJob) throttle(bytes int64) {
+	s := j.store
+	if rate := s.config.MaintenanceBytesPerSecond; rate > 0 && bytes > 0 {
+		budget := time.Duration(float64(bytes) / float64(rate) * float64(time.Second))
+		if wait := budget - time.Since(j.paced); wait > 0 {
+			s.sleepMaintenance(wait)
+			s.maintenance.mu.Lock()
+			j.progress.Throttled += wait
+			s.maintenance.mu.Unlock()
+		}
+	}
+	j.paced = time.Now()
+}
+
+// sleepMaintenance sleeps for d, returning false early if the store is
+// closing
+func (s *PebbleImageStore) sleepMaintenance(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-s.stopJobs:
+		return false
+	}
+}
+
+// Jobs returns the progress of each maintenance job's current or latest
+// run since the store was opened, by name
+func (s *PebbleImageStore) Jobs() []JobProgress {
+	s.maintenance.mu.Lock()
+	defer s.maintenance.mu.Unlock()
+
+	jobs := make([]JobProgress, 0, len(s.maintenance.jobs))
+	for _, progress := range s.maintenance.jobs {
+		jobs = append(jobs, *progress)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Name < jobs[j].Name })
+	return jobs
+}
@@ -0,0 +1,82 @@
+package imagestore
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestChunkedGarbageCollection(t *testing.T) {
+	store := newTagsTestStore(t, "a")
+
+	// More orphans than fit in one chunk
+	orphans := 2*maintenanceChunk + 500
+	for i := 0; i < orphans; i++ {
+		if err := store.db.Set(tileKey(TileID(fmt.Sprintf("%064x", i))), []byte{1, 2, 3}, nil); err != nil {
+			t.Fatalf("failed to seed orphan: %v", err)
+		}
+	}
+
+	report, err := store.PurgeOrphanedTiles()
+	if err != nil {
+		t.Fatalf("failed to collect: %v", err)
+	}
+	if report.Orphaned != orphans || report.OrphanBytes != int64(3*orphans) {
+		t.Errorf("expected %d orphans purged, got %+v", orphans, report)
+	}
+	mustRetrieve(t, store, "a")
+
+	jobs := store.Jobs()
+	if len(jobs) != 1 || jobs[0].Name != JobGarbageCollection || jobs[0].Running {
+		t.Fatalf("expected a finished collection job, got %+v", jobs)
+	}
+	if job := jobs[0]; job.Total != int64(orphans) || job.Processed != int64(orphans) || job.Written != int64(orphans) {
+		t.Errorf("unexpected progress %+v", job)
+	}
+}
+
+func TestGarbageCollectionSeesLaterWrites(t *testing.T) {
+	store := newTagsTestStore(t)
+	seq := store.LastChange()
+
+	// An upload after the snapshot references tiles the snapshot saw as
+	// orphans; the chunk must keep them
+	img, _ := encodeImageToPNG(createTestImage(8, 8))
+	if err := store.StoreImage("late", img); err != nil {
+		t.Fatalf("failed to store: %v", err)
+	}
+	manifest, _ := store.GetManifest("late")
+	var chunk []orphanCandidate
+	for _, tileRef := range manifest.TileRefs {
+		chunk = append(chunk, orphanCandidate{tileID: tileRef.TileID})
+	}
+
+	purged, _, next, err := store.purgeOrphanChunk(chunk, seq)
+	if err != nil {
+		t.Fatalf("failed to purge chunk: %v", err)
+	}
+	if len(purged) != 0 || next != store.LastChange() {
+		t.Errorf("expected no tiles purged and the feed read to the end, got %v up to %d", purged, next)
+	}
+	mustRetrieve(t, store, "late")
+}
+
+func TestMaintenancePacing(t *testing.T) {
+	store := newTagsTestStore(t)
+	store.config.MaintenanceBytesPerSecond = 1000
+	store.config.MaintenanceYield = 30 * time.Millisecond
+
+	job := store.startJob(JobRecompression, 0)
+	store.maintenance.foreground.Add(1)
+	job.pace(50)
+	store.maintenance.foreground.Add(-1)
+	job.finish(nil)
+
+	progress := store.Jobs()[0]
+	if progress.Throttled < 40*time.Millisecond {
+		t.Errorf("expected 50 bytes at 1000 B/s to throttle about 50ms, got %v", progress.Throttled)
+	}
+	if progress.Yielded < 30*time.Millisecond {
+		t.Errorf("expected the job to yield to the running write, got %v", progress.Yielded)
+	}
+}
@@ -0,0 +1,279 @@
+package imagestore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/DataDog/zstd"
+)
+
+// ImageManifest describes an image by the IDs of its tiles, letting clients
+// that tile images themselves upload only the tiles the store lacks
+type ImageManifest struct {
+	Width         int      `json:"width"`
+	Height        int      `json:"height"`
+	TileSize      int      `json:"tile_size"`
+	OriginalBytes int64    `json:"original_bytes"`
+	Tiles         []TileID `json:"tiles"`                // Row-major, as produced by ExtractTiles
+	Background    string   `json:"background,omitempty"` // #rrggbb the tiles were padded with, as passed to ExtractTilesOver; empty is black
+}
+
+// storedManifest is the form a StoredImage takes in the images and trash
+// buckets. Tiles are extracted in row-major order, so for such images only the
+// tile IDs are kept and each position is derived from its index; StorageTypes
+// and Transforms are omitted when every entry is zero. Images whose tile
+// references aren't in grid order, and manifests written before the compact
+// form, keep the full TileRefs list. Rows and SegmentRows are only set in the
+// header of a segmented manifest.
+type storedManifest struct {
+	StoredImage
+	TileRefs     []TileRef       `json:",omitempty"` // Shadows StoredImage.TileRefs
+	Columns      int             `json:",omitempty"` // Tiles per row
+	Tiles        []TileID        `json:",omitempty"`
+	StorageTypes []StorageType   `json:",omitempty"`
+	Transforms   []TileTransform `json:",omitempty"`
+	Rows         int             `json:",omitempty"` // Tile rows
+	SegmentRows  int             `json:",omitempty"` // Tile rows per segment
+}
+
+// encodeManifest serializes a stored image for the images and trash buckets.
+// Manifests of large images list thousands of tile references whose JSON is
+// highly repetitive, so they are stored as a zstd frame. Grids of more than
+// manifestSegmentTiles tiles are split into segments of whole tile rows.
+func encodeManifest(storedImage *StoredImage) ([]byte, error) {
+	manifest := storedManifest{StoredImage: *storedImage}
+	manifest.StoredImage.TileRefs = nil
+
+	if columns, ok := gridColumns(storedImage.TileRefs); ok {
+		if len(storedImage.TileRefs) > manifestSegmentTiles {
+			return encodeSegmentedManifest(storedImage, columns)
+		}
+		manifest.Columns = columns
+		manifest.Tiles = make([]TileID, len(storedImage.TileRefs))
+		storageTypes := make([]StorageType, len(storedImage.TileRefs))
+		transforms := make([]TileTransform, len(storedImage.TileRefs))
+		for i, tileRef := range storedImage.TileRefs {
+			manifest.Tiles[i] = tileRef.TileID
+			storageTypes[i] = tileRef.StorageType
+			transforms[i] = tileRef.Transform
+			if tileRef.StorageType != 0 {
+				manifest.StorageTypes = storageTypes
+			}
+			if tileRef.Transform != 0 {
+				manifest.Transforms = transforms
+			}
+		}
+	} else {
+		manifest.TileRefs = storedImage.TileRefs
+	}
+
+	data, err := json.Marshal(&manifest)
+	if err != nil {
+		return nil, err
+	}
+	return zstd.Compress(nil, data)
+}
+
+// decodeManifest parses a manifest written by encodeManifest. Manifests
+// stored before compression was added are plain JSON and are read as-is.
+func decodeManifest(data []byte, storedImage *StoredImage) error {
+	if isSegmentedManifest(data) {
+		return decodeSegmentedManifest(data, storedImage)
+	}
+	if len(data) > 0 && data[0] == zstdFrameMagic {
+		decompressed, err := zstd.Decompress(nil, data)
+		if err != nil {
+			return fmt.Errorf("failed to decompress manifest: %w", err)
+		}
+		data = decompressed
+	}
+
+	var manifest storedManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return err
+	}
+	*storedImage = manifest.StoredImage
+	storedImage.TileRefs = manifest.TileRefs
+
+	if len(manifest.Tiles) == 0 {
+		return nil
+	}
+	if manifest.Columns <= 0 {
+		return fmt.Errorf("invalid manifest: %d tile columns", manifest.Columns)
+	}
+	if manifest.StorageTypes != nil && len(manifest.StorageTypes) != len(manifest.Tiles) {
+		return fmt.Errorf("invalid manifest: %d storage types for %d tiles", len(manifest.StorageTypes), len(manifest.Tiles))
+	}
+	if manifest.Transforms != nil && len(manifest.Transforms) != len(manifest.Tiles) {
+		return fmt.Errorf("invalid manifest: %d transforms for %d tiles", len(manifest.Transforms), len(manifest.Tiles))
+	}
+
+	storedImage.TileRefs = make([]TileRef, len(manifest.Tiles))
+	for i, tileID := range manifest.Tiles {
+		tileRef := TileRef{X: i % manifest.Columns, Y: i / manifest.Columns, TileID: tileID}
+		if manifest.StorageTypes != nil {
+			tileRef.StorageType = manifest.StorageTypes[i]
+		}
+		if manifest.Transforms != nil {
+			tileRef.Transform = manifest.Transforms[i]
+		}
+		storedImage.TileRefs[i] = tileRef
+	}
+	return nil
+}
+
+// gridColumns returns the number of tiles per row when tile references are
+// in row-major order over a full grid, as ExtractTiles produces them
+func gridColumns(tileRefs []TileRef) (int, bool) {
+	columns := 0
+	for columns < len(tileRefs) && tileRefs[columns].Y == 0 {
+		columns++
+	}
+	if columns == 0 || len(tileRefs)%columns != 0 {
+		return 0, false
+	}
+
+	for i, tileRef := range tileRefs {
+		if tileRef.X != i%columns || tileRef.Y != i/columns {
+			return 0, false
+		}
+	}
+	return columns, true
+}
+
+// tileGrid returns the number of tile columns and rows for an image
+func tileGrid(width, height, tileSize int) (int, int) {
+	return (width + tileSize - 1) / tileSize, (height + tileSize - 1) / tileSize
+}
+
+// imageGrid returns the number of tile columns and rows of a stored image,
+// counting the extra row a grid offset can add
+func imageGrid(storedImage *StoredImage, tileSize int) (int, int) {
+	if storedImage.StripHeight > 0 {
+		return 1, (storedImage.Height + storedImage.GridOffsetY + storedImage.StripHeight - 1) / storedImage.StripHeight
+	}
+	tileSize, _ = storedImage.tileDims(tileSize)
+	return tileGrid(storedImage.Width, storedImage.Height+storedImage.GridOffsetY, tileSize)
+}
+
+// TileSize returns the tile size the store splits images into
+func (s *PebbleImageStore) TileSize() int {
+	return s.config.TileSize
+}
+
+// MissingTiles returns the IDs from the list that the store does not hold
+func (s *PebbleImageStore) MissingTiles(tileIDs []TileID) ([]TileID, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+
+	missing := []TileID{}
+	seen := make(map[TileID]bool, len(tileIDs))
+
+	for _, tileID := range tileIDs {
+		if seen[tileID] {
+			continue
+		}
+		seen[tileID] = true
+
+		if _, closer, err := s.db.Get(tileKey(tileID)); err == nil {
+			closer.Close()
+			continue
+		}
+		missing = append(missing, tileID)
+	}
+
+	return missing, nil
+}
+
+// StoreManifest stores an image from its manifest. tileData holds raw RGB
+// data for tiles the store lacks; every tile must either be stored already
+// or be present there, otherwise a "missing tile" error is returned and the
+// client should negotiate again. An upload to an existing ID follows
+// Config.OnConflict.
+func (s *PebbleImageStore) StoreManifest(id string, manifest ImageManifest, tileData map[TileID][]byte, opts StoreOptions) error {
+	if err := s.enter(); err != nil {
+		return err
+	}
+	defer s.leave()
+
+	plan, err := s.storeManifest(id, manifest, tileData, opts)
+	if err != nil {
+		return err
+	}
+	return s.collectReplaced(plan)
+}
+
+// storeManifest validates, plans and commits a manifest upload, returning
+// the plan
+func (s *PebbleImageStore) storeManifest(id string, manifest ImageManifest, tileData map[TileID][]byte, opts StoreOptions) (*storePlan, error) {
+	if manifest.TileSize != s.config.TileSize {
+		return nil, fmt.Errorf("invalid manifest: tile size %d does not match store tile size %d", manifest.TileSize, s.config.TileSize)
+	}
+	if manifest.Width <= 0 || manifest.Height <= 0 {
+		return nil, fmt.Errorf("invalid manifest: image dimensions %dx%d", manifest.Width, manifest.Height)
+	}
+	background, err := ParseBackground(manifest.Background)
+	if err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	tilesX, tilesY := tileGrid(manifest.Width, manifest.Height, manifest.TileSize)
+	if tilesX > len(manifest.Tiles) || tilesY > len(manifest.Tiles) || len(manifest.Tiles) != tilesX*tilesY {
+		return nil, fmt.Errorf("invalid manifest: expected %d tiles, got %d", tilesX*tilesY, len(manifest.Tiles))
+	}
+
+	// Tiles are content-addressed, so uploaded data must hash to its ID
+	tiles := make(map[TileID]Tile, len(tileData))
+	for tileID, data := range tileData {
+		if err := ValidateTileData(data, s.config.TileSize); err != nil {
+			return nil, fmt.Errorf("invalid manifest: tile %s: %w", tileID, err)
+		}
+		hash := ComputeTileHash(data)
+		if GenerateTileID(hash) != tileID {
+			return nil, fmt.Errorf("invalid manifest: tile hash mismatch for %s", tileID)
+		}
+		tiles[tileID] = Tile{ID: tileID, Hash: hash, Data: data}
+	}
+
+	tileRefs := make([]TileRef, len(manifest.Tiles))
+	for i, tileID := range manifest.Tiles {
+		tileRefs[i] = TileRef{X: i % tilesX, Y: i / tilesX, TileID: tileID}
+	}
+
+	if err := s.acquireWrite(); err != nil {
+		return nil, err
+	}
+	defer s.releaseWrite()
+
+	s.gcMu.RLock()
+	defer s.gcMu.RUnlock()
+
+	plan := &storePlan{
+		image: &StoredImage{
+			ID:            id,
+			Width:         manifest.Width,
+			Height:        manifest.Height,
+			TileRefs:      make([]TileRef, len(tileRefs)),
+			Metadata:      make(map[string]string),
+			OriginalBytes: manifest.OriginalBytes,
+			ExpiresAt:     opts.ExpiresAt,
+			StoredAt:      storedNow(),
+			Background:    formatBackground(background),
+		},
+		ifMatch: opts.IfMatch,
+	}
+
+	snapshot := s.db.NewSnapshot()
+	defer snapshot.Close()
+
+	if err := planPrevious(plan, snapshot); err != nil {
+		return nil, err
+	}
+	if err := s.planTiles(context.Background(), plan, snapshot, tileRefs, tiles); err != nil {
+		return nil, err
+	}
+
+	return plan, s.commitUpload(plan)
+}
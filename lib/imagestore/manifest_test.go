@@ -0,0 +1,162 @@
+package imagestore
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestStoreManifest(t *testing.T) {
+	store := newTagsTestStore(t, "existing")
+
+	tiles, _, err := ExtractTiles(createTestImage(8, 8), 4)
+	if err != nil {
+		t.Fatalf("failed to extract tiles: %v", err)
+	}
+
+	manifest := ImageManifest{Width: 8, Height: 8, TileSize: 4, OriginalBytes: 100}
+	for _, tile := range tiles {
+		manifest.Tiles = append(manifest.Tiles, tile.ID)
+	}
+
+	// "existing" holds the same image, so no tile data is needed
+	missing, err := store.MissingTiles(manifest.Tiles)
+	if err != nil {
+		t.Fatalf("failed to check missing tiles: %v", err)
+	}
+	if len(missing) != 0 {
+		t.Errorf("expected no missing tiles, got %v", missing)
+	}
+
+	if err := store.StoreManifest("copy", manifest, nil, StoreOptions{}); err != nil {
+		t.Fatalf("failed to store manifest: %v", err)
+	}
+
+	original, _ := store.RetrieveImage("existing")
+	copied, err := store.RetrieveImage("copy")
+	if err != nil {
+		t.Fatalf("failed to retrieve manifest image: %v", err)
+	}
+	if string(original) != string(copied) {
+		t.Error("manifest image differs from the original")
+	}
+}
+
+func TestStoreManifestValidation(t *testing.T) {
+	store := newTagsTestStore(t)
+
+	data := createTestTileData(4)
+	tileID := GenerateTileID(ComputeTileHash(data))
+	manifest := ImageManifest{Width: 4, Height: 4, TileSize: 4, Tiles: []TileID{tileID}}
+
+	missing, err := store.MissingTiles([]TileID{tileID, tileID})
+	if err != nil || len(missing) != 1 {
+		t.Fatalf("expected one missing tile, got %v (%v)", missing, err)
+	}
+
+	tests := []struct {
+		name     string
+		manifest ImageManifest
+		tileData map[TileID][]byte
+		errText  string
+	}{
+		{"missing tile data", manifest, nil, "missing tile"},
+		{"hash mismatch", manifest, map[TileID][]byte{tileID: make([]byte, len(data))}, "hash mismatch"},
+		{"wrong tile size", ImageManifest{Width: 4, Height: 4, TileSize: 8, Tiles: []TileID{tileID}}, nil, "tile size"},
+		{"wrong tile count", ImageManifest{Width: 8, Height: 4, TileSize: 4, Tiles: []TileID{tileID}}, nil, "expected 2 tiles"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := store.StoreManifest("bad", tt.manifest, tt.tileData, StoreOptions{})
+			if err == nil || !strings.Contains(err.Error(), tt.errText) {
+				t.Errorf("expected error containing %q, got %v", tt.errText, err)
+			}
+		})
+	}
+
+	if err := store.StoreManifest("good", manifest, map[TileID][]byte{tileID: data}, StoreOptions{}); err != nil {
+		t.Fatalf("failed to store manifest with tile data: %v", err)
+	}
+	if missing, _ := store.MissingTiles([]TileID{tileID}); len(missing) != 0 {
+		t.Errorf("expected uploaded tile to be stored, still missing %v", missing)
+	}
+}
+
+func TestManifestEncoding(t *testing.T) {
+	storedImage := &StoredImage{ID: "large", Width: 4096, Height: 4096, Metadata: map[string]string{}}
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			tileID := GenerateTileID(ComputeTileHash([]byte{byte(x % 4)}))
+			storedImage.TileRefs = append(storedImage.TileRefs, TileRef{X: x, Y: y, TileID: tileID, StorageType: StorageDuplicate})
+		}
+	}
+
+	plain, err := json.Marshal(storedImage)
+	if err != nil {
+		t.Fatalf("failed to marshal manifest: %v", err)
+	}
+	encoded, err := encodeManifest(storedImage)
+	if err != nil {
+		t.Fatalf("failed to encode manifest: %v", err)
+	}
+	if len(encoded) >= len(plain)/4 {
+		t.Errorf("expected encoded manifest well under %d bytes, got %d", len(plain), len(encoded))
+	}
+
+	// Manifests written before compression are plain JSON
+	for name, data := range map[string][]byte{"encoded": encoded, "plain": plain} {
+		var decoded StoredImage
+		if err := decodeManifest(data, &decoded); err != nil {
+			t.Fatalf("failed to decode %s manifest: %v", name, err)
+		}
+		if ManifestDigest(&decoded) != ManifestDigest(storedImage) {
+			t.Errorf("%s manifest changed after decoding", name)
+		}
+		if decoded.TileRefs[5].StorageType != StorageDuplicate {
+			t.Errorf("%s manifest lost tile storage types", name)
+		}
+	}
+}
+
+func TestManifestEncodingImplicitGrid(t *testing.T) {
+	rowMajor := &StoredImage{ID: "grid", Width: 12, Height: 8}
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 3; x++ {
+			tileID := GenerateTileID(ComputeTileHash([]byte{byte(y*3 + x)}))
+			rowMajor.TileRefs = append(rowMajor.TileRefs, TileRef{X: x, Y: y, TileID: tileID})
+		}
+	}
+	rowMajor.TileRefs[4].Transform = transformInvert
+
+	// Manifests received from other stores may list tiles in any order
+	shuffled := *rowMajor
+	shuffled.TileRefs = append([]TileRef(nil), rowMajor.TileRefs...)
+	shuffled.TileRefs[0], shuffled.TileRefs[5] = shuffled.TileRefs[5], shuffled.TileRefs[0]
+
+	for name, storedImage := range map[string]*StoredImage{"row-major": rowMajor, "shuffled": &shuffled} {
+		encoded, err := encodeManifest(storedImage)
+		if err != nil {
+			t.Fatalf("failed to encode %s manifest: %v", name, err)
+		}
+
+		var decoded StoredImage
+		if err := decodeManifest(encoded, &decoded); err != nil {
+			t.Fatalf("failed to decode %s manifest: %v", name, err)
+		}
+		if len(decoded.TileRefs) != len(storedImage.TileRefs) {
+			t.Fatalf("%s manifest: expected %d tile refs, got %d", name, len(storedImage.TileRefs), len(decoded.TileRefs))
+		}
+		for i, tileRef := range decoded.TileRefs {
+			if tileRef != storedImage.TileRefs[i] {
+				t.Errorf("%s manifest: tile ref %d is %+v, expected %+v", name, i, tileRef, storedImage.TileRefs[i])
+			}
+		}
+	}
+
+	if _, ok := gridColumns(shuffled.TileRefs); ok {
+		t.Error("shuffled tile refs should not use the implicit grid")
+	}
+	if columns, ok := gridColumns(rowMajor.TileRefs); !ok || columns != 3 {
+		t.Errorf("expected 3 grid columns, got %d (%v)", columns, ok)
+	}
+}
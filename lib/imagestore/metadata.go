@@ -0,0 +1,87 @@
+package imagestore
+
+import (
+	"fmt"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// SetMetadata merges metadata into an image's existing metadata. Keys with an
+// empty value are removed.
+func (s *PebbleImageStore) SetMetadata(id string, metadata map[string]string) error {
+	if err := s.enter(); err != nil {
+		return err
+	}
+	defer s.leave()
+
+	// Held from the read through the commit, so concurrent updates and
+	// stores to the image aren't lost
+	unlock := s.lockVersion(id)
+	defer unlock()
+
+	imageKey := makeKey(imagesBucket, id)
+	imageData, closer, err := s.db.Get(imageKey)
+	if err != nil {
+		return fmt.Errorf("image not found: %s", id)
+	}
+
+	var storedImage StoredImage
+	err = decodeManifest(imageData, &storedImage)
+	closer.Close()
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal image: %w", err)
+	}
+
+	batch := s.db.NewBatch()
+	defer batch.Close()
+
+	if err := unindexSearchTokens(batch, &storedImage); err != nil {
+		return err
+	}
+
+	if storedImage.Metadata == nil {
+		storedImage.Metadata = make(map[string]string)
+	}
+	for key, value := range metadata {
+		if value == "" {
+			delete(storedImage.Metadata, key)
+		} else {
+			storedImage.Metadata[key] = value
+		}
+	}
+
+	if err := indexSearchTokens(batch, &storedImage); err != nil {
+		return err
+	}
+
+	imageBytes, err := encodeManifest(&storedImage)
+	if err != nil {
+		return fmt.Errorf("failed to marshal image metadata: %w", err)
+	}
+	if err := batch.Set(imageKey, imageBytes, pebble.Sync); err != nil {
+		return fmt.Errorf("failed to store image metadata: %w", err)
+	}
+
+	return s.commitChanges(batch, AuditMetadataChanged, id)
+}
+
+// GetMetadata returns an image's metadata
+func (s *PebbleImageStore) GetMetadata(id string) (map[string]string, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+
+	imageData, closer, err := s.db.Get(makeKey(imagesBucket, id))
+	if err != nil {
+		return nil, fmt.Errorf("image not found: %s", id)
+	}
+	defer closer.Close()
+
+	var storedImage StoredImage
+	if err := decodeManifest(imageData, &storedImage); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal image: %w", err)
+	}
+
+	return storedImage.Metadata, nil
+}
@@ -0,0 +1,61 @@
+package imagestore
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestSetMetadataMerges(t *testing.T) {
+	store := newTagsTestStore(t, "shot")
+
+	if err := store.SetMetadata("shot", map[string]string{"url": "https://example.com", "browser": "chrome"}); err != nil {
+		t.Fatalf("failed to set metadata: %v", err)
+	}
+	if err := store.SetMetadata("shot", map[string]string{"browser": "", "commit": "abc123"}); err != nil {
+		t.Fatalf("failed to set metadata: %v", err)
+	}
+
+	metadata, err := store.GetMetadata("shot")
+	if err != nil {
+		t.Fatalf("failed to get metadata: %v", err)
+	}
+
+	expected := map[string]string{"url": "https://example.com", "commit": "abc123"}
+	if !reflect.DeepEqual(metadata, expected) {
+		t.Errorf("expected metadata %v, got %v", expected, metadata)
+	}
+
+	if err := store.SetMetadata("missing", map[string]string{"a": "b"}); err == nil {
+		t.Error("expected error setting metadata on nonexistent image")
+	}
+}
+
+func TestSetMetadataConcurrent(t *testing.T) {
+	store := newTagsTestStore(t, "shot")
+
+	const writers, rounds = 4, 25
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				if err := store.SetMetadata("shot", map[string]string{fmt.Sprintf("k%d-%d", w, i): "v"}); err != nil {
+					t.Errorf("failed to set metadata: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	metadata, err := store.GetMetadata("shot")
+	if err != nil {
+		t.Fatalf("failed to get metadata: %v", err)
+	}
+	if len(metadata) != writers*rounds {
+		t.Errorf("expected every update kept, got %d of %d keys", len(metadata), writers*rounds)
+	}
+}
@@ -0,0 +1,61 @@
+package imagestore
+
+import (
+	"bytes"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// BucketStats summarizes the keys stored under one bucket prefix
+type BucketStats struct {
+	Keys          int
+	KeyBytes      int64
+	ValueBytes    int64
+	AvgValueBytes float64
+}
+
+// bucketStats scans the whole keyspace, grouping keys by the bucket name
+// before the first ":"
+func (s *PebbleImageStore) bucketStats() (map[string]BucketStats, error) {
+	iter, err := s.db.NewIter(&pebble.IterOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	buckets := make(map[string]BucketStats)
+	for iter.First(); iter.Valid(); iter.Next() {
+		key := iter.Key()
+		name := string(key)
+		if i := bytes.IndexByte(key, ':'); i >= 0 {
+			name = string(key[:i])
+		}
+
+		bucket := buckets[name]
+		bucket.Keys++
+		bucket.KeyBytes += int64(len(key))
+		bucket.ValueBytes += int64(len(iter.Value()))
+		buckets[name] = bucket
+	}
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+
+	for name, bucket := range buckets {
+		bucket.AvgValueBytes = float64(bucket.ValueBytes) / float64(bucket.Keys)
+		buckets[name] = bucket
+	}
+	return buckets, nil
+}
+
+// diskBytes returns the space the database occupies on disk, including
+// the WAL, obsolete files awaiting deletion and blob files
+func (s *PebbleImageStore) diskBytes() int64 {
+	bytes := int64(s.db.Metrics().DiskSpaceUsage())
+	if files, err := s.blobs.list(); err == nil {
+		for _, size := range files {
+			bytes += size
+		}
+	}
+	return bytes
+}
@@ -0,0 +1,30 @@
+package imagestore
+
+import "testing"
+
+func TestStorageStatsBuckets(t *testing.T) {
+	store := newTagsTestStore(t, "a", "b")
+	store.AddTags("a", "release")
+
+	stats := store.GetStorageStats()
+
+	images := stats.Buckets["images"]
+	if images.Keys != 2 || images.ValueBytes == 0 {
+		t.Errorf("unexpected images bucket: %+v", images)
+	}
+	if images.AvgValueBytes != float64(images.ValueBytes)/2 {
+		t.Errorf("expected average %f, got %f", float64(images.ValueBytes)/2, images.AvgValueBytes)
+	}
+
+	tiles := stats.Buckets["tiles"]
+	if tiles.Keys != stats.UniqueTiles || tiles.ValueBytes != stats.StorageBytes {
+		t.Errorf("tiles bucket %+v disagrees with %d tiles of %d bytes", tiles, stats.UniqueTiles, stats.StorageBytes)
+	}
+	if stats.Buckets["tags"].Keys != 1 {
+		t.Errorf("expected one tag entry, got %+v", stats.Buckets["tags"])
+	}
+
+	if stats.DiskBytes <= 0 {
+		t.Errorf("expected a positive disk size, got %d", stats.DiskBytes)
+	}
+}
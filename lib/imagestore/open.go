@@ -0,0 +1,85 @@
+package imagestore
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/cockroachdb/pebble/vfs"
+)
+
+var (
+	// ErrStoreLocked is returned when another process holds the database
+	// lock for longer than Config.OpenTimeout
+	ErrStoreLocked = errors.New("database is locked by another process")
+
+	// ErrReadOnly is returned by writes to a store opened with Config.ReadOnly
+	ErrReadOnly = pebble.ErrReadOnly
+)
+
+// Backoff between attempts to take a database lock held by another process
+const (
+	lockRetryInitial = 50 * time.Millisecond
+	lockRetryMax     = time.Second
+)
+
+// lockDatabase takes the lock on a database directory, creating the
+// directory and any missing parents first unless the database is opened
+// read-only. Directories are created with mode 0777 so the umask decides
+// their permissions. While another process holds the lock, it retries with
+// backoff until timeout has passed.
+func lockDatabase(path string, readOnly bool, timeout time.Duration) (*pebble.Lock, error) {
+	if !readOnly {
+		if err := os.MkdirAll(path, 0777); err != nil {
+			return nil, openError(path, err)
+		}
+	}
+
+	deadline := time.Now().Add(timeout)
+	backoff := lockRetryInitial
+	for {
+		lock, err := pebble.LockDirectory(path, vfs.Default)
+		if err == nil {
+			return lock, nil
+		}
+
+		// Failing to create the lock file is a file system problem; failing
+		// to lock it means another process holds the database
+		var pathErr *fs.PathError
+		if errors.As(err, &pathErr) {
+			return nil, openError(path, err)
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil, fmt.Errorf("%w: %s (waited %s): %w", ErrStoreLocked, path, timeout, err)
+		}
+		if backoff > remaining {
+			backoff = remaining
+		}
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > lockRetryMax {
+			backoff = lockRetryMax
+		}
+	}
+}
+
+// openError describes why a database could not be opened and what to do
+// about it
+func openError(path string, err error) error {
+	switch {
+	case errors.Is(err, fs.ErrPermission):
+		return fmt.Errorf("permission denied opening database %s: the store needs read and write access to the directory and its parents: %w", path, err)
+	case errors.Is(err, fs.ErrNotExist), errors.Is(err, pebble.ErrDBDoesNotExist):
+		return fmt.Errorf("database %s does not exist: %w", path, err)
+	case errors.Is(err, syscall.ENOTDIR):
+		return fmt.Errorf("database path %s or one of its parents is a file, not a directory: %w", path, err)
+	case pebble.IsCorruptionError(err):
+		return fmt.Errorf("database %s is corrupt: salvage it with \"imagestore repair\": %w", path, err)
+	}
+	return fmt.Errorf("failed to open database %s: %w", path, err)
+}
@@ -0,0 +1,158 @@
+package imagestore
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+)
+
+func TestOpenCreatesParentDirectories(t *testing.T) {
+	umask := syscall.Umask(0o027)
+	defer syscall.Umask(umask)
+
+	config := DefaultConfig()
+	parent := filepath.Join(t.TempDir(), "missing")
+	config.DatabasePath = filepath.Join(parent, "nested", "test.db")
+
+	store, err := NewPebbleImageStore(config)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	store.Close()
+
+	info, err := os.Stat(parent)
+	if err != nil {
+		t.Fatalf("parent directory not created: %v", err)
+	}
+	if mode := info.Mode().Perm(); mode != 0o750 {
+		t.Errorf("expected the umask to leave mode 0750, got %#o", mode)
+	}
+}
+
+func TestOpenDiagnostics(t *testing.T) {
+	dir := t.TempDir()
+
+	config := DefaultConfig()
+	config.DatabasePath = filepath.Join(dir, "test.db")
+	store, err := NewPebbleImageStore(config)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	_, err = NewPebbleImageStore(config)
+	if err == nil || !strings.Contains(err.Error(), "locked by another process") {
+		t.Errorf("expected a lock error, got %v", err)
+	}
+
+	// Closing releases the lock
+	store.Close()
+	store, err = NewPebbleImageStore(config)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	store.Close()
+
+	manifests, _ := filepath.Glob(filepath.Join(config.DatabasePath, "MANIFEST-*"))
+	for _, manifest := range manifests {
+		os.WriteFile(manifest, []byte("not a manifest"), 0644)
+	}
+	_, err = NewPebbleImageStore(config)
+	if err == nil || !strings.Contains(err.Error(), "is corrupt") {
+		t.Errorf("expected a corruption error, got %v", err)
+	}
+
+	file := filepath.Join(dir, "file")
+	os.WriteFile(file, nil, 0644)
+	config.DatabasePath = filepath.Join(file, "test.db")
+	_, err = NewPebbleImageStore(config)
+	if err == nil || !strings.Contains(err.Error(), "not a directory") {
+		t.Errorf("expected a not-a-directory error, got %v", err)
+	}
+
+	config.DatabasePath = filepath.Join(dir, "absent.db")
+	_, err = openPebbleImageStore(config, &pebble.Options{ReadOnly: true})
+	if err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Errorf("expected a missing database error, got %v", err)
+	}
+}
+
+func TestOpenPermissionDenied(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("permission checks don't apply to root")
+	}
+
+	dir := t.TempDir()
+	if err := os.Chmod(dir, 0o500); err != nil {
+		t.Fatalf("failed to restrict directory: %v", err)
+	}
+	defer os.Chmod(dir, 0o700)
+
+	config := DefaultConfig()
+	config.DatabasePath = filepath.Join(dir, "test.db")
+	_, err := NewPebbleImageStore(config)
+	if err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("expected a permission error, got %v", err)
+	}
+}
+
+func TestOpenWaitsForLock(t *testing.T) {
+	config := DefaultConfig()
+	config.DatabasePath = filepath.Join(t.TempDir(), "test.db")
+
+	holder, err := NewPebbleImageStore(config)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	_, err = NewPebbleImageStore(config)
+	if !errors.Is(err, ErrStoreLocked) {
+		t.Errorf("expected ErrStoreLocked without a timeout, got %v", err)
+	}
+
+	time.AfterFunc(100*time.Millisecond, func() { holder.Close() })
+
+	waiting := *config
+	waiting.OpenTimeout = 5 * time.Second
+	store, err := NewPebbleImageStore(&waiting)
+	if err != nil {
+		t.Fatalf("expected the open to wait for the lock, got %v", err)
+	}
+	store.Close()
+}
+
+func TestOpenReadOnly(t *testing.T) {
+	config := DefaultConfig()
+	config.DatabasePath = filepath.Join(t.TempDir(), "test.db")
+	config.TileSize = 4
+
+	imageData, _ := encodeImageToPNG(createTestImage(8, 8))
+	store, err := NewPebbleImageStore(config)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	if err := store.StoreImage("existing", imageData); err != nil {
+		t.Fatalf("failed to store image: %v", err)
+	}
+	store.Close()
+
+	config.ReadOnly = true
+	readOnly, err := NewPebbleImageStore(config)
+	if err != nil {
+		t.Fatalf("failed to open store read-only: %v", err)
+	}
+	defer readOnly.Close()
+
+	if _, err := readOnly.RetrieveImage("existing"); err != nil {
+		t.Errorf("failed to read from read-only store: %v", err)
+	}
+
+	if err := readOnly.StoreImage("new", imageData); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("expected ErrReadOnly, got %v", err)
+	}
+}
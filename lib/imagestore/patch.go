@@ -0,0 +1,159 @@
+package imagestore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+)
+
+// PatchResult describes a region patch
+type PatchResult struct {
+	TilesAffected int // Tiles the patch overlaps
+	TilesChanged  int // Tiles whose content changed
+	NewTiles      int // Tiles written because no stored tile matched
+}
+
+// patchAttempts bounds how often PatchImage starts over when the image is
+// written between its read and its commit
+const patchAttempts = 3
+
+// patchCommitHook, when set by tests, runs between planning a patch and
+// committing it
+var patchCommitHook func(id string)
+
+// PatchImage draws a patch image over a live image with its top-left corner
+// at (x, y), replacing the manifest. Only the tiles the patch overlaps are
+// read, re-extracted and deduplicated; the rest of the manifest is kept as
+// is. Transparent patch pixels are blended over the existing content. The
+// patch only commits over the version it was drawn on; if the image is
+// written meanwhile, it is drawn again on the new version, and after
+// patchAttempts tries fails with ErrPreconditionFailed.
+func (s *PebbleImageStore) PatchImage(id string, patchData []byte, x, y int) (*PatchResult, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+
+	patch, err := decodeImageFromBytes(patchData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	for attempt := 1; ; attempt++ {
+		result, err := s.patchImage(id, patch, x, y)
+		if !errors.Is(err, ErrPreconditionFailed) || attempt == patchAttempts {
+			return result, err
+		}
+	}
+}
+
+// patchImage makes one attempt at PatchImage against a snapshot
+func (s *PebbleImageStore) patchImage(id string, patch image.Image, x, y int) (*PatchResult, error) {
+	if err := s.acquireWrite(); err != nil {
+		return nil, err
+	}
+	defer s.releaseWrite()
+
+	// Hold off garbage collection so tiles we dedupe against stay present
+	s.gcMu.RLock()
+	defer s.gcMu.RUnlock()
+
+	snapshot := s.db.NewSnapshot()
+	defer snapshot.Close()
+
+	// The commit checks the image is still at the version patched here
+	plan := &storePlan{image: &StoredImage{ID: id}}
+	if value, closer, err := snapshot.Get(makeKey(imagesBucket, id)); err == nil {
+		plan.ifMatch = manifestETag(value)
+		closer.Close()
+	}
+	if err := planPrevious(plan, snapshot); err != nil {
+		return nil, err
+	}
+	current := plan.previous
+	if current == nil {
+		return nil, fmt.Errorf("image not found: %s", id)
+	}
+
+	patchBounds := patch.Bounds()
+	target := image.Rect(x, y, x+patchBounds.Dx(), y+patchBounds.Dy())
+	if x < 0 || y < 0 || target.Empty() || !target.In(image.Rect(0, 0, current.Width, current.Height)) {
+		return nil, fmt.Errorf("invalid patch: %dx%d at (%d, %d) outside the %dx%d image", patchBounds.Dx(), patchBounds.Dy(), x, y, current.Width, current.Height)
+	}
+
+	// Edge tiles keep the padding the image was stored with
+	background, err := ParseBackground(current.Background)
+	if err != nil {
+		return nil, err
+	}
+
+	// Rebuild the pixels of the overlapped tiles only
+	region, err := s.renderWindow(snapshot, current, target)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image %s: %w", id, err)
+	}
+	tileSize := s.config.TileSize
+	tileWidth, tileHeight := current.tileDims(tileSize)
+	window := region.Bounds()
+
+	var affected []int
+	previous := make(map[int][]byte)
+	for i, tileRef := range current.TileRefs {
+		if origin := current.tileOrigin(tileRef, tileSize); origin.In(window) {
+			x0, y0 := origin.X, origin.Y
+			affected = append(affected, i)
+			previous[i] = extractTileRect(region, x0, y0, min(x0+tileWidth, current.Width), min(y0+tileHeight, current.Height), tileWidth, tileHeight, background)
+		}
+	}
+	draw.Draw(region, target, patch, patchBounds.Min, draw.Over)
+
+	// Re-extract the overlapped tiles and plan only those that changed
+	result := &PatchResult{TilesAffected: len(affected)}
+	var changedRefs []TileRef
+	var changedIndexes []int
+	tiles := make(map[TileID]Tile)
+	for _, index := range affected {
+		tileRef := current.TileRefs[index]
+		origin := current.tileOrigin(tileRef, tileSize)
+		x0, y0 := origin.X, origin.Y
+		data := extractTileRect(region, x0, y0, min(x0+tileWidth, current.Width), min(y0+tileHeight, current.Height), tileWidth, tileHeight, background)
+		if bytes.Equal(data, previous[index]) {
+			continue
+		}
+
+		hash := ComputeTileHash(data)
+		tileID := GenerateTileID(hash)
+
+		tiles[tileID] = Tile{ID: tileID, Hash: hash, Data: data}
+		changedRefs = append(changedRefs, TileRef{X: tileRef.X, Y: tileRef.Y, TileID: tileID})
+		changedIndexes = append(changedIndexes, index)
+	}
+	result.TilesChanged = len(changedRefs)
+	if len(changedRefs) == 0 {
+		return result, nil
+	}
+
+	patched := *current
+	patched.TileRefs = append([]TileRef(nil), current.TileRefs...)
+	plan.image = &StoredImage{ID: id, TileRefs: make([]TileRef, len(changedRefs))}
+	if err := s.planTiles(context.Background(), plan, snapshot, changedRefs, tiles); err != nil {
+		return nil, err
+	}
+	for i, index := range changedIndexes {
+		patched.TileRefs[index] = plan.image.TileRefs[i]
+	}
+	patched.StoredAt = storedNow()
+	plan.image = &patched
+	result.NewTiles = len(plan.newTiles)
+
+	if patchCommitHook != nil {
+		patchCommitHook(id)
+	}
+	if err := s.commitPlan(plan); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,152 @@
+package imagestore
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+func TestPatchImage(t *testing.T) {
+	store := newTagsTestStore(t, "screen")
+
+	patch := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 2; x++ {
+			patch.Set(x, y, color.RGBA{255, 0, 0, 255})
+		}
+	}
+	patchData, err := encodeImageToPNG(patch)
+	if err != nil {
+		t.Fatalf("failed to encode patch: %v", err)
+	}
+
+	result, err := store.PatchImage("screen", patchData, 5, 5)
+	if err != nil {
+		t.Fatalf("failed to patch image: %v", err)
+	}
+	if result.TilesAffected != 1 || result.TilesChanged != 1 || result.NewTiles != 1 {
+		t.Errorf("expected one changed tile, got %+v", result)
+	}
+
+	retrieved, err := store.RetrieveImage("screen")
+	if err != nil {
+		t.Fatalf("failed to retrieve image: %v", err)
+	}
+	img, err := decodeImageFromBytes(retrieved)
+	if err != nil {
+		t.Fatalf("failed to decode image: %v", err)
+	}
+	original := createTestImage(8, 8)
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			want := color.RGBAModel.Convert(original.At(x, y))
+			if x >= 5 && x < 7 && y >= 5 && y < 7 {
+				want = color.RGBA{255, 0, 0, 255}
+			}
+			if got := color.RGBAModel.Convert(img.At(x, y)); got != want {
+				t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, got, want)
+			}
+		}
+	}
+
+	// Patching the same pixels again changes nothing
+	result, err = store.PatchImage("screen", patchData, 5, 5)
+	if err != nil || result.TilesChanged != 0 {
+		t.Errorf("expected no changed tiles, got %+v (%v)", result, err)
+	}
+
+	// A patch spanning a tile corner touches all four tiles
+	result, err = store.PatchImage("screen", patchData, 3, 3)
+	if err != nil || result.TilesAffected != 4 || result.TilesChanged != 4 {
+		t.Errorf("expected four changed tiles, got %+v (%v)", result, err)
+	}
+
+	if _, err := store.PatchImage("screen", patchData, 7, 0); err == nil || !strings.Contains(err.Error(), "invalid patch") {
+		t.Errorf("expected invalid patch, got %v", err)
+	}
+	if _, err := store.PatchImage("missing", patchData, 0, 0); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("expected not found, got %v", err)
+	}
+}
+
+func TestPatchImageReplacedMeanwhile(t *testing.T) {
+	store := newTagsTestStore(t, "screen")
+	defer func() { patchCommitHook = nil }()
+
+	solid := func(c color.RGBA) []byte {
+		img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+		for y := 0; y < 8; y++ {
+			for x := 0; x < 8; x++ {
+				img.Set(x, y, c)
+			}
+		}
+		data, err := encodeImageToPNG(img)
+		if err != nil {
+			t.Fatalf("failed to encode image: %v", err)
+		}
+		return data
+	}
+	blue := color.RGBA{0, 0, 255, 255}
+	patchImg := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 2; x++ {
+			patchImg.Set(x, y, color.RGBA{255, 0, 0, 255})
+		}
+	}
+	patchData, err := encodeImageToPNG(patchImg)
+	if err != nil {
+		t.Fatalf("failed to encode patch: %v", err)
+	}
+
+	// Replace the image once after the patch has read it. The patch must
+	// not commit over the replacement; it is drawn again on top of it.
+	replacement := solid(blue)
+	calls := 0
+	patchCommitHook = func(id string) {
+		calls++
+		if calls == 1 {
+			if err := store.StoreImage(id, replacement); err != nil {
+				t.Fatalf("failed to replace image: %v", err)
+			}
+		}
+	}
+	if _, err := store.PatchImage("screen", patchData, 0, 0); err != nil {
+		t.Fatalf("failed to patch image: %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("expected the patch retried once, got %d attempts", calls)
+	}
+
+	data, err := store.RetrieveImage("screen")
+	if err != nil {
+		t.Fatalf("failed to retrieve image: %v", err)
+	}
+	img, err := decodeImageFromBytes(data)
+	if err != nil {
+		t.Fatalf("failed to decode image: %v", err)
+	}
+	if got := color.RGBAModel.Convert(img.At(0, 0)); got != (color.RGBA{255, 0, 0, 255}) {
+		t.Errorf("expected the patch at (0, 0), got %v", got)
+	}
+	if got := color.RGBAModel.Convert(img.At(7, 7)); got != blue {
+		t.Errorf("expected the replacement kept at (7, 7), got %v", got)
+	}
+
+	// An image rewritten before every commit fails the patch rather than
+	// being overwritten
+	calls = 0
+	patchCommitHook = func(id string) {
+		calls++
+		if err := store.StoreImage(id, solid(color.RGBA{0, uint8(calls), 0, 255})); err != nil {
+			t.Fatalf("failed to replace image: %v", err)
+		}
+	}
+	if _, err := store.PatchImage("screen", patchData, 4, 4); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("expected ErrPreconditionFailed, got %v", err)
+	}
+	if calls != patchAttempts {
+		t.Errorf("expected %d attempts, got %d", patchAttempts, calls)
+	}
+}
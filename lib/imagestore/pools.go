@@ -0,0 +1,161 @@
+package imagestore
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// Tile pool modes for Config.TilePools
+const (
+	TilePoolsShared    = "shared"    // One pool for every namespace, deduplicating across all of them
+	TilePoolsNamespace = "namespace" // A pool per namespace; images never share tiles with other namespaces
+)
+
+// tilePoolsKey records the tile pool mode a database was written with
+var tilePoolsKey = makeKey(metaBucket, "tile_pools")
+
+// validateTilePools checks a Config.TilePools mode
+func validateTilePools(mode string) error {
+	switch mode {
+	case "", TilePoolsShared, TilePoolsNamespace:
+		return nil
+	}
+	return fmt.Errorf("unknown tile pool mode: %s", mode)
+}
+
+// checkTilePools compares the configured tile pool mode with the one
+// recorded in the database, recording it while the database holds no
+// images. Tile IDs depend on the mode, so it can't change once images are
+// stored. Databases from before the record hold shared tiles.
+func checkTilePools(db *pebble.DB, mode string, readOnly bool) error {
+	if mode == "" {
+		mode = TilePoolsShared
+	}
+
+	recorded := TilePoolsShared
+	value, closer, err := db.Get(tilePoolsKey)
+	switch {
+	case err == nil:
+		recorded = string(value)
+		closer.Close()
+		if recorded == mode {
+			return nil
+		}
+	case err != pebble.ErrNotFound:
+		return err
+	}
+
+	empty, err := bucketEmpty(db, imagesBucket)
+	if err != nil {
+		return err
+	}
+	if empty && !readOnly {
+		return db.Set(tilePoolsKey, []byte(mode), pebble.Sync)
+	}
+	if recorded != mode {
+		return fmt.Errorf("database stores %s tile pools, but %s pools are configured: the mode can't change once images are stored", recorded, mode)
+	}
+	return nil
+}
+
+// bucketEmpty reports whether a bucket holds no keys
+func bucketEmpty(db *pebble.DB, bucket []byte) (bool, error) {
+	prefix := makePrefixKey(bucket)
+	iter, err := db.NewIter(&pebble.IterOptions{
+		LowerBound: prefix,
+		UpperBound: prefixUpperBound(prefix),
+	})
+	if err != nil {
+		return false, err
+	}
+	empty := !iter.First()
+	return empty, iter.Close()
+}
+
+// tilePool returns the pool the tiles of an image belong to: its namespace
+// when tiles are isolated per namespace, otherwise the shared pool, ""
+func (s *PebbleImageStore) tilePool(id string) string {
+	if s.config.TilePools == TilePoolsNamespace {
+		return Namespace(id)
+	}
+	return ""
+}
+
+// poolTileID names a tile of a pool. Tiles of the shared pool are named by
+// their content alone; the namespace a pool belongs to prefixes the others.
+func poolTileID(pool string, contentID TileID) TileID {
+	if pool == "" {
+		return contentID
+	}
+	return TileID(pool + "/" + string(contentID))
+}
+
+// tileIDPool returns the pool a tile ID belongs to
+func tileIDPool(tileID TileID) string {
+	pool, _, found := strings.Cut(string(tileID), "/")
+	if !found {
+		return ""
+	}
+	return pool
+}
+
+// contentTileID returns the ID a tile has in the shared pool, which is
+// derived from its data alone
+func contentTileID(tileID TileID) TileID {
+	if _, contentID, found := strings.Cut(string(tileID), "/"); found {
+		return TileID(contentID)
+	}
+	return tileID
+}
+
+// poolTiles renames tiles named by their content, and the references to
+// them, into a pool
+func poolTiles(pool string, tileRefs []TileRef, tiles map[TileID]Tile) ([]TileRef, map[TileID]Tile) {
+	if pool == "" {
+		return tileRefs, tiles
+	}
+
+	pooledRefs := make([]TileRef, len(tileRefs))
+	for i, tileRef := range tileRefs {
+		if tileIDPool(tileRef.TileID) == "" {
+			tileRef.TileID = poolTileID(pool, tileRef.TileID)
+		}
+		pooledRefs[i] = tileRef
+	}
+
+	pooled := make(map[TileID]Tile, len(tiles))
+	for tileID, tile := range tiles {
+		if tileIDPool(tileID) == "" {
+			tile.ID = poolTileID(pool, tileID)
+		}
+		pooled[tile.ID] = tile
+	}
+	return pooledRefs, pooled
+}
+
+// MissingPoolTiles is MissingTiles for a manifest upload to namespace. The
+// IDs are named by content, as clients compute them, and are checked in the
+// tile pool of the namespace.
+func (s *PebbleImageStore) MissingPoolTiles(namespace string, tileIDs []TileID) ([]TileID, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+
+	pool := s.tilePool(namespace + "/")
+	pooled := make([]TileID, len(tileIDs))
+	for i, tileID := range tileIDs {
+		pooled[i] = poolTileID(pool, tileID)
+	}
+
+	missing, err := s.MissingTiles(pooled)
+	if err != nil {
+		return nil, err
+	}
+	for i, tileID := range missing {
+		missing[i] = contentTileID(tileID)
+	}
+	return missing, nil
+}
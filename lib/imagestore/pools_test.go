@@ -0,0 +1,108 @@
+package imagestore
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTilePools(t *testing.T) {
+	config := DefaultConfig()
+	config.DatabasePath = filepath.Join(t.TempDir(), "test.db")
+	config.TileSize = 4
+	config.TilePools = TilePoolsNamespace
+
+	store, err := NewPebbleImageStore(config)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	img := createTestImage(8, 8)
+	imageData, _ := encodeImageToPNG(img)
+	for _, id := range []string{"a/1", "a/2", "b/1"} {
+		if err := store.StoreImage(id, imageData); err != nil {
+			t.Fatalf("failed to store %s: %v", id, err)
+		}
+	}
+
+	// Each namespace holds its own copy of the tiles
+	if unique := store.GetStorageStats().UniqueTiles; unique != 8 {
+		t.Errorf("expected 4 tiles per namespace, got %d", unique)
+	}
+	manifest, _ := store.GetManifest("b/1")
+	for _, tileRef := range manifest.TileRefs {
+		if !strings.HasPrefix(string(tileRef.TileID), "b/") {
+			t.Fatalf("expected tiles of b/1 in the b pool, got %s", tileRef.TileID)
+		}
+	}
+	if !bytes.Equal(mustRetrieve(t, store, "b/1"), mustRetrieve(t, store, "a/1")) {
+		t.Error("expected both namespaces to return the same image")
+	}
+
+	// Clones and compositions into another namespace copy tiles into its pool
+	if err := store.CloneImage("a/1", "c/clone"); err != nil {
+		t.Fatalf("failed to clone: %v", err)
+	}
+	if _, err := store.ComposeImage("d/crop", 8, 8, []ComposeRegion{{Source: "a/1", Width: 8, Height: 8}}, StoreOptions{}); err != nil {
+		t.Fatalf("failed to compose: %v", err)
+	}
+	for _, id := range []string{"c/clone", "d/crop"} {
+		manifest, _ := store.GetManifest(id)
+		if pool := tileIDPool(manifest.TileRefs[0].TileID); pool != Namespace(id) {
+			t.Errorf("expected %s to use its own pool, got %q", id, pool)
+		}
+		if !bytes.Equal(mustRetrieve(t, store, id), mustRetrieve(t, store, "a/1")) {
+			t.Errorf("expected %s to match its source", id)
+		}
+	}
+
+	// Manifests can't reference another namespace's tiles
+	foreign, _ := store.GetManifest("a/1")
+	foreign.ID = "b/foreign"
+	if err := store.ImportManifest(foreign); err == nil || !strings.Contains(err.Error(), "tile pool") {
+		t.Errorf("expected a cross-pool reference to be rejected, got %v", err)
+	}
+
+	// Manifest uploads negotiate with content IDs against their namespace
+	tiles, _, _ := ExtractTiles(img, 4)
+	contentIDs := []TileID{tiles[0].ID}
+	if missing, _ := store.MissingPoolTiles("a", contentIDs); len(missing) != 0 {
+		t.Errorf("expected namespace a to hold the tile, missing %v", missing)
+	}
+	if missing, _ := store.MissingPoolTiles("e", contentIDs); len(missing) != 1 || missing[0] != tiles[0].ID {
+		t.Errorf("expected namespace e to lack the tile, got %v", missing)
+	}
+
+	// The mode is fixed once images are stored
+	store.Close()
+	config.TilePools = TilePoolsShared
+	if store, err := NewPebbleImageStore(config); err == nil {
+		store.Close()
+		t.Fatal("expected switching to shared pools to be refused")
+	}
+}
+
+func TestTilePoolsEmptyStore(t *testing.T) {
+	config := DefaultConfig()
+	config.DatabasePath = filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewPebbleImageStore(config)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	store.Close()
+
+	// Without images the mode can still change
+	config.TilePools = TilePoolsNamespace
+	store, err = NewPebbleImageStore(config)
+	if err != nil {
+		t.Fatalf("expected an empty store to switch pools, got %v", err)
+	}
+	store.Close()
+
+	config.TilePools = "tenant"
+	if _, err := NewPebbleImageStore(config); err == nil {
+		t.Error("expected an unknown mode to be rejected")
+	}
+}
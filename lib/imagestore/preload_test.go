@@ -0,0 +1,70 @@
+package imagestore
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestPreloadFillsImageCache(t *testing.T) {
+	config := DefaultConfig()
+	config.DatabasePath = filepath.Join(t.TempDir(), "test.db")
+	config.TileSize = 4
+	config.Resources.ImageCacheBytes = 1 << 20
+
+	store, err := NewPebbleImageStore(config)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	imageData, _ := encodeImageToPNG(createTestImage(8, 8))
+	for _, id := range []string{"dash/a", "dash/b", "other/c"} {
+		if err := store.StoreImage(id, imageData); err != nil {
+			t.Fatalf("failed to store %s: %v", id, err)
+		}
+	}
+
+	report, err := store.Preload(context.Background(), "dash/")
+	if err != nil {
+		t.Fatalf("preload failed: %v", err)
+	}
+	if report.Loaded != 2 || report.Cached != 0 || report.Skipped != 0 || report.Bytes == 0 {
+		t.Errorf("expected two images loaded, got %+v", report)
+	}
+
+	if _, err := store.RetrieveImage("dash/a"); err != nil {
+		t.Fatalf("failed to retrieve image: %v", err)
+	}
+	if stats := store.GetStorageStats().ImageCache; stats.Hits != 1 || stats.Misses != 0 {
+		t.Errorf("expected the preloaded image to be a hit, got %+v", stats)
+	}
+
+	// A second run finds everything cached
+	report, err = store.Preload(context.Background(), "dash/")
+	if err != nil {
+		t.Fatalf("preload failed: %v", err)
+	}
+	if report.Loaded != 0 || report.Cached != 2 {
+		t.Errorf("expected both images already cached, got %+v", report)
+	}
+
+	// Preloading stops rather than evicting what's cached
+	store.imageCache.stats.MaxBytes = store.imageCache.snapshot().Bytes
+	report, err = store.Preload(context.Background(), "other/")
+	if err != nil {
+		t.Fatalf("preload failed: %v", err)
+	}
+	if report.Loaded != 0 || report.Skipped != 1 || store.GetStorageStats().ImageCache.Evictions != 0 {
+		t.Errorf("expected other/c to be skipped, got %+v", report)
+	}
+}
+
+func TestPreloadNeedsImageCache(t *testing.T) {
+	store := newTagsTestStore(t)
+
+	if _, err := store.Preload(context.Background(), ""); !errors.Is(err, ErrImageCacheDisabled) {
+		t.Errorf("expected ErrImageCacheDisabled, got %v", err)
+	}
+}
@@ -0,0 +1,220 @@
+package imagestore
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// Upload transform types
+const (
+	TransformAutoCrop     = "autocrop"      // Trim uniform letterbox bars from every edge
+	TransformCrop         = "crop"          // Remove fixed margins, such as a status bar
+	TransformNormalizeDPI = "normalize_dpi" // Scale down images denser than DPI
+	TransformSRGB         = "srgb"          // Convert colors from the embedded ICC profile to sRGB
+)
+
+// Transform is one step of the pipeline uploads go through before they are
+// tiled. Normalizing screenshots from different devices lets their common
+// content land on the same tiles.
+type Transform struct {
+	Type      string
+	Top       int // TransformCrop: rows removed from the top
+	Bottom    int // TransformCrop: rows removed from the bottom
+	Left      int // TransformCrop: columns removed from the left
+	Right     int // TransformCrop: columns removed from the right
+	Width     int // TransformCrop: only crop images this wide; 0 matches any width
+	Height    int // TransformCrop: only crop images this tall; 0 matches any height
+	Tolerance int // TransformAutoCrop: how far a bar's channels may stray from its color
+	DPI       int // TransformNormalizeDPI: density denser uploads are scaled down to
+}
+
+// ValidateTransform checks a transform's type and parameters
+func ValidateTransform(t Transform) error {
+	switch t.Type {
+	case TransformAutoCrop:
+		if t.Tolerance < 0 || t.Tolerance > 255 {
+			return fmt.Errorf("invalid transform: autocrop tolerance must be between 0 and 255, got %d", t.Tolerance)
+		}
+	case TransformCrop:
+		if t.Top < 0 || t.Bottom < 0 || t.Left < 0 || t.Right < 0 || t.Width < 0 || t.Height < 0 {
+			return fmt.Errorf("invalid transform: crop margins and sizes must not be negative")
+		}
+		if t.Top+t.Bottom+t.Left+t.Right == 0 {
+			return fmt.Errorf("invalid transform: crop removes nothing")
+		}
+		if (t.Width > 0 && t.Left+t.Right >= t.Width) || (t.Height > 0 && t.Top+t.Bottom >= t.Height) {
+			return fmt.Errorf("invalid transform: crop removes the whole %dx%d image", t.Width, t.Height)
+		}
+	case TransformNormalizeDPI:
+		if t.DPI <= 0 {
+			return fmt.Errorf("invalid transform: normalize_dpi needs a positive dpi, got %d", t.DPI)
+		}
+	case TransformSRGB:
+	default:
+		return fmt.Errorf("invalid transform: unknown type %q", t.Type)
+	}
+	return nil
+}
+
+// validateTransforms checks every pipeline in config
+func validateTransforms(config *Config) error {
+	pipelines := [][]Transform{config.DefaultTransforms}
+	for _, pipeline := range config.Transforms {
+		pipelines = append(pipelines, pipeline)
+	}
+	for _, pipeline := range pipelines {
+		for _, transform := range pipeline {
+			if err := ValidateTransform(transform); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// transformsFor returns the upload pipeline configured for a namespace,
+// falling back to the default pipeline
+func (s *PebbleImageStore) transformsFor(namespace string) []Transform {
+	if transforms, ok := s.config.Transforms[namespace]; ok {
+		return transforms
+	}
+	return s.config.DefaultTransforms
+}
+
+// applyTransforms runs an upload through a pipeline, in order. source is
+// what the upload said about itself and may be nil; the returned source has
+// the ICC profile removed once the pixels are converted to sRGB. A step that
+// doesn't apply to the image, such as a crop rule for another screen size,
+// leaves it unchanged.
+func applyTransforms(img image.Image, source *SourceInfo, transforms []Transform) (image.Image, *SourceInfo) {
+	for _, t := range transforms {
+		bounds := img.Bounds()
+		switch t.Type {
+		case TransformAutoCrop:
+			img = cropTo(img, letterboxContent(img, t.Tolerance))
+		case TransformCrop:
+			if (t.Width == 0 || t.Width == bounds.Dx()) && (t.Height == 0 || t.Height == bounds.Dy()) &&
+				t.Left+t.Right < bounds.Dx() && t.Top+t.Bottom < bounds.Dy() {
+				img = cropTo(img, image.Rect(bounds.Min.X+t.Left, bounds.Min.Y+t.Top, bounds.Max.X-t.Right, bounds.Max.Y-t.Bottom))
+			}
+		case TransformNormalizeDPI:
+			if source != nil && source.DPI > t.DPI {
+				scale := float64(t.DPI) / float64(source.DPI)
+				width := max(1, int(math.Round(float64(bounds.Dx())*scale)))
+				height := max(1, int(math.Round(float64(bounds.Dy())*scale)))
+				img = downscale(img, width, height)
+			}
+		case TransformSRGB:
+			if source == nil || source.ICCProfile == nil {
+				continue
+			}
+			profile, err := parseICCProfile(source.ICCProfile)
+			if err != nil {
+				fmt.Printf("Warning: keeping the upload's color profile: %v\n", err)
+				continue
+			}
+			img = profile.toSRGB(img)
+			converted := *source
+			converted.ICCProfile = nil
+			source = &converted
+			if source.empty() {
+				source = nil
+			}
+		}
+	}
+	return img, source
+}
+
+// letterboxContent returns the part of img inside uniform bars along its
+// edges. The top and left bars take their color from the top-left corner,
+// the bottom and right bars from the bottom-right one. At least one row and
+// column is always kept.
+func letterboxContent(img image.Image, tolerance int) image.Rectangle {
+	bounds := img.Bounds()
+	matches := func(c color.Color, bar color.RGBA) bool {
+		p := color.RGBAModel.Convert(c).(color.RGBA)
+		for _, d := range []int{int(p.R) - int(bar.R), int(p.G) - int(bar.G), int(p.B) - int(bar.B), int(p.A) - int(bar.A)} {
+			if d > tolerance || -d > tolerance {
+				return false
+			}
+		}
+		return true
+	}
+	uniformRow := func(y int, bar color.RGBA) bool {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if !matches(img.At(x, y), bar) {
+				return false
+			}
+		}
+		return true
+	}
+	uniformColumn := func(x, y0, y1 int, bar color.RGBA) bool {
+		for y := y0; y < y1; y++ {
+			if !matches(img.At(x, y), bar) {
+				return false
+			}
+		}
+		return true
+	}
+
+	first := color.RGBAModel.Convert(img.At(bounds.Min.X, bounds.Min.Y)).(color.RGBA)
+	last := color.RGBAModel.Convert(img.At(bounds.Max.X-1, bounds.Max.Y-1)).(color.RGBA)
+
+	content := bounds
+	for content.Dy() > 1 && uniformRow(content.Min.Y, first) {
+		content.Min.Y++
+	}
+	for content.Dy() > 1 && uniformRow(content.Max.Y-1, last) {
+		content.Max.Y--
+	}
+	for content.Dx() > 1 && uniformColumn(content.Min.X, content.Min.Y, content.Max.Y, first) {
+		content.Min.X++
+	}
+	for content.Dx() > 1 && uniformColumn(content.Max.X-1, content.Min.Y, content.Max.Y, last) {
+		content.Max.X--
+	}
+	return content
+}
+
+// cropTo returns the part of img inside rect as a new image with its origin
+// at (0, 0), or img itself when rect covers all of it
+func cropTo(img image.Image, rect image.Rectangle) image.Image {
+	if rect == img.Bounds() {
+		return img
+	}
+	out := image.NewRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	draw.Draw(out, out.Bounds(), img, rect.Min, draw.Src)
+	return out
+}
+
+// downscale resizes img to width x height by averaging the source pixels
+// each output pixel covers
+func downscale(img image.Image, width, height int) image.Image {
+	bounds := img.Bounds()
+	out := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := bounds.Min.Y + y*bounds.Dy()/height
+		y1 := max(y0+1, bounds.Min.Y+(y+1)*bounds.Dy()/height)
+		for x := 0; x < width; x++ {
+			x0 := bounds.Min.X + x*bounds.Dx()/width
+			x1 := max(x0+1, bounds.Min.X+(x+1)*bounds.Dx()/width)
+
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					p := color.NRGBAModel.Convert(img.At(sx, sy)).(color.NRGBA)
+					sum[0] += int(p.R)
+					sum[1] += int(p.G)
+					sum[2] += int(p.B)
+					sum[3] += int(p.A)
+				}
+			}
+			n := (y1 - y0) * (x1 - x0)
+			out.SetNRGBA(x, y, color.NRGBA{uint8(sum[0] / n), uint8(sum[1] / n), uint8(sum[2] / n), uint8(sum[3] / n)})
+		}
+	}
+	return out
+}
@@ -0,0 +1,238 @@
+package imagestore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"path/filepath"
+	"testing"
+)
+
+// letterbox draws img centered on a black canvas with bars of the given
+// thickness
+func letterbox(img image.Image, bar int) *image.RGBA {
+	bounds := img.Bounds()
+	out := image.NewRGBA(image.Rect(0, 0, bounds.Dx()+2*bar, bounds.Dy()+2*bar))
+	for y := range out.Bounds().Dy() {
+		for x := range out.Bounds().Dx() {
+			out.Set(x, y, color.RGBA{A: 255})
+		}
+	}
+	for y := range bounds.Dy() {
+		for x := range bounds.Dx() {
+			out.Set(bar+x, bar+y, img.At(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+	return out
+}
+
+// withPHYs inserts a pHYs chunk declaring dpi after the IHDR chunk of a PNG
+func withPHYs(pngData []byte, dpi int) []byte {
+	ihdrEnd := len(pngSignature) + 25
+	chunk := []byte("pHYs")
+	ppm := uint32(float64(dpi)/0.0254 + 0.5)
+	chunk = binary.BigEndian.AppendUint32(chunk, ppm)
+	chunk = binary.BigEndian.AppendUint32(chunk, ppm)
+	chunk = append(chunk, 1)
+
+	out := append([]byte(nil), pngData[:ihdrEnd]...)
+	out = binary.BigEndian.AppendUint32(out, uint32(len(chunk)-4))
+	out = append(out, chunk...)
+	out = binary.BigEndian.AppendUint32(out, crc32.ChecksumIEEE(chunk))
+	return append(out, pngData[ihdrEnd:]...)
+}
+
+// linearSRGBProfile builds an ICC profile with the sRGB primaries and linear
+// tone curves
+func linearSRGBProfile() []byte {
+	colorants := map[string][3]float64{
+		"rXYZ": {0.4360747, 0.2225045, 0.0139322},
+		"gXYZ": {0.3850649, 0.7168786, 0.0971045},
+		"bXYZ": {0.1430804, 0.0606169, 0.7141733},
+	}
+	names := []string{"rXYZ", "gXYZ", "bXYZ", "rTRC", "gTRC", "bTRC"}
+
+	profile := make([]byte, 128)
+	copy(profile[16:], "RGB XYZ ")
+	copy(profile[36:], "acsp")
+	profile = binary.BigEndian.AppendUint32(profile, uint32(len(names)))
+
+	var data []byte
+	offset := len(profile) + 12*len(names)
+	for _, name := range names {
+		var tag []byte
+		if xyz, ok := colorants[name]; ok {
+			tag = append([]byte("XYZ "), 0, 0, 0, 0)
+			for _, v := range xyz {
+				tag = binary.BigEndian.AppendUint32(tag, uint32(int32(v*65536)))
+			}
+		} else {
+			tag = append([]byte("curv"), 0, 0, 0, 0, 0, 0, 0, 0) // No entries: identity
+		}
+		profile = append(profile, name...)
+		profile = binary.BigEndian.AppendUint32(profile, uint32(offset+len(data)))
+		profile = binary.BigEndian.AppendUint32(profile, uint32(len(tag)))
+		data = append(data, tag...)
+	}
+	return append(profile, data...)
+}
+
+func TestUploadTransforms(t *testing.T) {
+	config := DefaultConfig()
+	config.DatabasePath = filepath.Join(t.TempDir(), "test.db")
+	config.TileSize = 4
+	config.DefaultTransforms = []Transform{{Type: TransformAutoCrop}}
+	config.Transforms = map[string][]Transform{
+		"phones": {{Type: TransformCrop, Top: 4, Height: 12}},
+		"raw":    nil,
+	}
+
+	store, err := NewPebbleImageStore(config)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	content := createTestImage(8, 8)
+	plain, _ := encodeImageToPNG(content)
+	boxed, _ := encodeImageToPNG(letterbox(content, 4))
+	if err := store.StoreImage("plain", plain); err != nil {
+		t.Fatalf("failed to store plain image: %v", err)
+	}
+	if err := store.StoreImage("boxed", boxed); err != nil {
+		t.Fatalf("failed to store letterboxed image: %v", err)
+	}
+
+	// The letterbox is trimmed, leaving the very same tiles
+	before := store.GetStorageStats().UniqueTiles
+	manifest, err := store.GetManifest("boxed")
+	if err != nil || manifest.Width != 8 || manifest.Height != 8 {
+		t.Fatalf("expected the letterbox trimmed to 8x8, got %+v (%v)", manifest, err)
+	}
+	if retrieved, _ := store.RetrieveImage("boxed"); !bytes.Equal(retrieved, mustRetrieve(t, store, "plain")) {
+		t.Error("expected the trimmed image to match the plain one")
+	}
+	if after := store.GetStorageStats().UniqueTiles; after != before {
+		t.Errorf("expected no new tiles, got %d -> %d", before, after)
+	}
+
+	// A namespace pipeline replaces the default, and crop rules only match
+	// their screen size
+	tall := image.NewRGBA(image.Rect(0, 0, 8, 12))
+	for y := range 12 {
+		for x := range 8 {
+			tall.Set(x, y, content.At(x, y-4))
+		}
+	}
+	tallData, _ := encodeImageToPNG(tall)
+	if err := store.StoreImage("phones/shot", tallData); err != nil {
+		t.Fatalf("failed to store phone screenshot: %v", err)
+	}
+	if manifest, _ := store.GetManifest("phones/shot"); manifest.Width != 8 || manifest.Height != 8 {
+		t.Errorf("expected the status bar cropped, got %dx%d", manifest.Width, manifest.Height)
+	}
+	if err := store.StoreImage("phones/other", boxed); err != nil {
+		t.Fatalf("failed to store: %v", err)
+	}
+	if manifest, _ := store.GetManifest("phones/other"); manifest.Width != 16 || manifest.Height != 16 {
+		t.Errorf("expected a 16x16 image to skip the crop rule, got %dx%d", manifest.Width, manifest.Height)
+	}
+	if err := store.StoreImage("raw/shot", boxed); err != nil {
+		t.Fatalf("failed to store: %v", err)
+	}
+	if manifest, _ := store.GetManifest("raw/shot"); manifest.Width != 16 {
+		t.Errorf("expected an empty pipeline to store the upload as is, got %dx%d", manifest.Width, manifest.Height)
+	}
+}
+
+// mustRetrieve retrieves an image or fails the test
+func mustRetrieve(t *testing.T, store *PebbleImageStore, id string) []byte {
+	t.Helper()
+	data, err := store.RetrieveImage(id)
+	if err != nil {
+		t.Fatalf("failed to retrieve %s: %v", id, err)
+	}
+	return data
+}
+
+func TestNormalizeDPI(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for x := range 4 {
+		img.Set(x, 0, color.RGBA{uint8(100 * (x % 2)), 0, 0, 255})
+		img.Set(x, 1, color.RGBA{uint8(100 * (x % 2)), 0, 0, 255})
+	}
+	data, _ := encodeImageToPNG(img)
+	data = withPHYs(data, 144)
+
+	source := parseSourceInfo(data)
+	if source == nil || source.DPI != 144 {
+		t.Fatalf("expected 144 dpi from pHYs, got %+v", source)
+	}
+
+	out, _ := applyTransforms(img, source, []Transform{{Type: TransformNormalizeDPI, DPI: 72}})
+	if out.Bounds().Dx() != 2 || out.Bounds().Dy() != 1 {
+		t.Fatalf("expected the image halved, got %v", out.Bounds())
+	}
+	if got := color.RGBAModel.Convert(out.At(0, 0)).(color.RGBA); got.R != 50 {
+		t.Errorf("expected neighbouring pixels averaged, got %v", got)
+	}
+
+	if same, _ := applyTransforms(img, source, []Transform{{Type: TransformNormalizeDPI, DPI: 300}}); same != image.Image(img) {
+		t.Error("expected images below the target density to be left alone")
+	}
+}
+
+func TestSRGBTransform(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	img.Set(0, 0, color.RGBA{128, 128, 128, 255})
+	source := &SourceInfo{ICCProfile: linearSRGBProfile()}
+
+	out, converted := applyTransforms(img, source, []Transform{{Type: TransformSRGB}})
+	if converted != nil {
+		t.Errorf("expected the profile dropped once applied, got %+v", converted)
+	}
+	// Linear 0.5 is about 188 in sRGB
+	got := color.NRGBAModel.Convert(out.At(0, 0)).(color.NRGBA)
+	for _, v := range []uint8{got.R, got.G, got.B} {
+		if v < 187 || v > 189 {
+			t.Errorf("expected about 188, got %v", got)
+			break
+		}
+	}
+
+	// Profiles that can't be read leave the upload untouched
+	unknown := &SourceInfo{ICCProfile: []byte("not a profile")}
+	if same, kept := applyTransforms(img, unknown, []Transform{{Type: TransformSRGB}}); same != image.Image(img) || kept != unknown {
+		t.Error("expected an unreadable profile to be kept")
+	}
+}
+
+func TestValidateTransform(t *testing.T) {
+	valid := []Transform{
+		{Type: TransformAutoCrop, Tolerance: 8},
+		{Type: TransformCrop, Top: 88, Width: 1170, Height: 2532},
+		{Type: TransformNormalizeDPI, DPI: 72},
+		{Type: TransformSRGB},
+	}
+	for _, transform := range valid {
+		if err := ValidateTransform(transform); err != nil {
+			t.Errorf("expected %+v to be valid, got %v", transform, err)
+		}
+	}
+
+	invalid := []Transform{
+		{Type: "sharpen"},
+		{Type: TransformAutoCrop, Tolerance: 300},
+		{Type: TransformCrop},
+		{Type: TransformCrop, Top: -1},
+		{Type: TransformCrop, Top: 10, Bottom: 10, Height: 20},
+		{Type: TransformNormalizeDPI},
+	}
+	for _, transform := range invalid {
+		if err := ValidateTransform(transform); err == nil {
+			t.Errorf("expected %+v to be rejected", transform)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package imagestore
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// quotaUsageTTL bounds how long cached namespace usage is trusted. Uploads
+// adjust it as they commit, but deletes and changes in other namespaces'
+// sharing only show once it is recomputed.
+const quotaUsageTTL = time.Minute
+
+// Quota limits how much a namespace may store. Zero fields are unlimited.
+type Quota struct {
+	MaxOriginalBytes int64 // Total size of the uploaded image files
+	MaxStoredBytes   int64 // Compressed tile bytes no other namespace references
+}
+
+// limited reports whether the quota sets any limit
+func (q Quota) limited() bool {
+	return q.MaxOriginalBytes > 0 || q.MaxStoredBytes > 0
+}
+
+// NamespaceUsage reports what a namespace currently stores
+type NamespaceUsage struct {
+	Images         int
+	OriginalBytes  int64  // Total size of the uploaded image files
+	ExclusiveBytes int64  // Compressed tile bytes no other namespace references
+	Quota          *Quota `json:",omitempty"`
+}
+
+// QuotaExceededError is returned when a store would take a namespace over
+// its quota
+type QuotaExceededError struct {
+	Namespace string
+	Resource  string // "original bytes" or "stored bytes"
+	Limit     int64
+	Requested int64 // Usage the store would have resulted in
+	TooLarge  bool  // The image alone exceeds the limit
+}
+
+func (e *QuotaExceededError) Error() string {
+	if e.TooLarge {
+		return fmt.Sprintf("image exceeds %s quota for namespace %q: %d > %d", e.Resource, e.Namespace, e.Requested, e.Limit)
+	}
+	return fmt.Sprintf("quota exceeded for namespace %q: %s would reach %d of %d", e.Namespace, e.Resource, e.Requested, e.Limit)
+}
+
+// Namespace returns the namespace of an image ID: everything before the
+// first "/", or "" for IDs without one
+func Namespace(id string) string {
+	namespace, _, found := strings.Cut(id, "/")
+	if !found {
+		return ""
+	}
+	return namespace
+}
+
+// quotaFor returns the quota configured for a namespace, falling back to
+// the default quota
+func (s *PebbleImageStore) quotaFor(namespace string) Quota {
+	if quota, ok := s.config.Quotas[namespace]; ok {
+		return quota
+	}
+	return s.config.DefaultQuota
+}
+
+// sharedOwner marks a tile referenced by more than one namespace
+const sharedOwner = "\x00shared"
+
+// namespaceUsage computes usage for every namespace, ignoring the live image
+// with the given ID (pass "" to include everything). Trashed images still
+// hold their tiles, so they count towards sharing but not towards usage.
+func (s *PebbleImageStore) namespaceUsage(excludeID string) (map[string]*NamespaceUsage, error) {
+	usage := make(map[string]*NamespaceUsage)
+	owners := make(map[TileID]string)
+
+	for _, bucket := range [][]byte{imagesBucket, trashBucket} {
+		prefix := makePrefixKey(bucket)
+		iter, err := s.db.NewIter(&pebble.IterOptions{
+			LowerBound: prefix,
+			UpperBound: prefixUpperBound(prefix),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for iter.First(); iter.Valid(); iter.Next() {
+			var storedImage StoredImage
+			if err := decodeManifest(iter.Value(), &storedImage); err != nil {
+				iter.Close()
+				return nil, fmt.Errorf("failed to unmarshal image: %w", err)
+			}
+
+			live := string(bucket) == string(imagesBucket)
+			if live && storedImage.ID == excludeID {
+				continue
+			}
+
+			namespace := Namespace(storedImage.ID)
+			if live {
+				if usage[namespace] == nil {
+					usage[namespace] = &NamespaceUsage{}
+				}
+				usage[namespace].Images++
+				usage[namespace].OriginalBytes += storedImage.OriginalBytes
+			}
+
+			for _, tileRef := range storedImage.TileRefs {
+				if owner, ok := owners[tileRef.TileID]; ok && owner != namespace {
+					owners[tileRef.TileID] = sharedOwner
+				} else if !ok {
+					owners[tileRef.TileID] = namespace
+				}
+			}
+		}
+
+		if err := iter.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	prefix := makePrefixKey(tilesBucket)
+	iter, err := s.db.NewIter(&pebble.IterOptions{
+		LowerBound: prefix,
+		UpperBound: prefixUpperBound(prefix),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	for iter.First(); iter.Valid(); iter.Next() {
+		owner, ok := owners[tileIDFromKey(iter.Key())]
+		if !ok || owner == sharedOwner || usage[owner] == nil {
+			continue
+		}
+		usage[owner].ExclusiveBytes += storedTileSize(iter.Value())
+	}
+
+	return usage, iter.Error()
+}
+
+// UsageByNamespace reports current usage for every namespace that holds
+// live images, along with its quota when one applies
+func (s *PebbleImageStore) UsageByNamespace() (map[string]NamespaceUsage, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+
+	usage, err := s.namespaceUsage("")
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]NamespaceUsage, len(usage))
+	for namespace, u := range usage {
+		if quota := s.quotaFor(namespace); quota.limited() {
+			u.Quota = &quota
+		}
+		result[namespace] = *u
+	}
+	return result, nil
+}
+
+// usageCache holds namespace usage for quota checks, so admitting an
+// upload doesn't scan the whole store. Each admitted upload adds to its
+// namespace; what deletes and overwrites free is left counted until the
+// usage is recomputed, so the cache never understates a namespace's own
+// uploads.
+type usageCache struct {
+	mu       sync.Mutex
+	usage    map[string]*NamespaceUsage // nil until computed
+	computed time.Time
+}
+
+// get returns a copy of a namespace's cached usage, recomputing every
+// namespace's usage when the cache is missing or stale
+func (c *usageCache) get(s *PebbleImageStore, namespace string) (NamespaceUsage, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.usage == nil || time.Since(c.computed) > quotaUsageTTL {
+		usage, err := s.namespaceUsage("")
+		if err != nil {
+			return NamespaceUsage{}, err
+		}
+		c.usage, c.computed = usage, time.Now()
+	}
+	if u := c.usage[namespace]; u != nil {
+		return *u, nil
+	}
+	return NamespaceUsage{}, nil
+}
+
+// add counts an applied plan towards its namespace's cached usage
+func (c *usageCache) add(plan *storePlan, newBytes int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.usage == nil {
+		return
+	}
+	namespace := Namespace(plan.image.ID)
+	u := c.usage[namespace]
+	if u == nil {
+		u = &NamespaceUsage{}
+		c.usage[namespace] = u
+	}
+	if plan.previous == nil {
+		u.Images++
+	} else {
+		u.OriginalBytes -= plan.previous.OriginalBytes
+	}
+	u.OriginalBytes += plan.image.OriginalBytes
+	u.ExclusiveBytes += newBytes
+}
+
+// invalidate drops the cached usage, so the next check recomputes it
+func (c *usageCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.usage = nil
+}
+
+// lockQuota serializes quota checks in one namespace with the stores they
+// admit, letting other namespaces check theirs meanwhile
+func (s *PebbleImageStore) lockQuota(namespace string) func() {
+	h := fnv.New32a()
+	h.Write([]byte(namespace))
+	mu := &s.quotaLocks[h.Sum32()%versionLockStripes]
+	mu.Lock()
+	return mu.Unlock
+}
+
+// checkQuota rejects a plan that would take its namespace over quota,
+// returning the compressed size of the tiles it adds. The plan's previous
+// version must be current, and the caller must hold the namespace's quota
+// lock until the plan is applied.
+func (s *PebbleImageStore) checkQuota(plan *storePlan, quota Quota) (int64, error) {
+	namespace := Namespace(plan.image.ID)
+
+	var newBytes int64
+	for _, planned := range plan.newTiles {
+		newBytes += int64(len(planned.compressed))
+	}
+
+	tooLarge := func(resource string, requested, limit int64) error {
+		return &QuotaExceededError{Namespace: namespace, Resource: resource, Limit: limit, Requested: requested, TooLarge: true}
+	}
+	if quota.MaxOriginalBytes > 0 && plan.image.OriginalBytes > quota.MaxOriginalBytes {
+		return 0, tooLarge("original bytes", plan.image.OriginalBytes, quota.MaxOriginalBytes)
+	}
+	if quota.MaxStoredBytes > 0 && newBytes > quota.MaxStoredBytes {
+		return 0, tooLarge("stored bytes", newBytes, quota.MaxStoredBytes)
+	}
+
+	// The cache may overstate usage, so only its verdict to admit is final
+	cached, err := s.quotaUsage.get(s, namespace)
+	if err != nil {
+		return 0, fmt.Errorf("failed to compute namespace usage: %w", err)
+	}
+	if plan.previous != nil {
+		cached.OriginalBytes -= plan.previous.OriginalBytes
+	}
+	if exceedsQuota(namespace, quota, cached, plan.image.OriginalBytes, newBytes) == nil {
+		return newBytes, nil
+	}
+
+	// The image being overwritten no longer counts against the namespace
+	usage, err := s.namespaceUsage(plan.image.ID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to compute namespace usage: %w", err)
+	}
+	s.quotaUsage.invalidate()
+	current := usage[namespace]
+	if current == nil {
+		current = &NamespaceUsage{}
+	}
+	return newBytes, exceedsQuota(namespace, quota, *current, plan.image.OriginalBytes, newBytes)
+}
+
+// exceedsQuota reports the limit an upload adding originalBytes and
+// newBytes to a namespace's usage would exceed
+func exceedsQuota(namespace string, quota Quota, usage NamespaceUsage, originalBytes, newBytes int64) error {
+	if requested := usage.OriginalBytes + originalBytes; quota.MaxOriginalBytes > 0 && requested > quota.MaxOriginalBytes {
+		return &QuotaExceededError{Namespace: namespace, Resource: "original bytes", Limit: quota.MaxOriginalBytes, Requested: requested}
+	}
+	if requested := usage.ExclusiveBytes + newBytes; quota.MaxStoredBytes > 0 && requested > quota.MaxStoredBytes {
+		return &QuotaExceededError{Namespace: namespace, Resource: "stored bytes", Limit: quota.MaxStoredBytes, Requested: requested}
+	}
+	return nil
+}
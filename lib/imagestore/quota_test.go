@@ -0,0 +1,171 @@
+package imagestore
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"path/filepath"
+	"testing"
+)
+
+func newQuotaTestStore(t *testing.T, quotas map[string]Quota) *PebbleImageStore {
+	t.Helper()
+
+	config := DefaultConfig()
+	config.DatabasePath = filepath.Join(t.TempDir(), "test.db")
+	config.TileSize = 4
+	config.Quotas = quotas
+
+	store, err := NewPebbleImageStore(config)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	return store
+}
+
+// encodeSolidImage encodes an 8x8 image of a single color
+func encodeSolidImage(t *testing.T, c color.RGBA) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	imageData, err := encodeImageToPNG(img)
+	if err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	return imageData
+}
+
+func TestNamespace(t *testing.T) {
+	tests := map[string]string{
+		"team-a/screenshot": "team-a",
+		"team-a/nested/id":  "team-a",
+		"plain-id":          "",
+	}
+	for id, expected := range tests {
+		if got := Namespace(id); got != expected {
+			t.Errorf("Namespace(%q) = %q, expected %q", id, got, expected)
+		}
+	}
+}
+
+func TestUsageByNamespace(t *testing.T) {
+	store := newQuotaTestStore(t, map[string]Quota{"a": {MaxOriginalBytes: 1 << 20}})
+
+	shared := encodeSolidImage(t, color.RGBA{1, 2, 3, 255})
+	for _, id := range []string{"a/one", "b/one"} {
+		if err := store.StoreImage(id, shared); err != nil {
+			t.Fatalf("failed to store image %s: %v", id, err)
+		}
+	}
+	if err := store.StoreImage("a/two", encodeSolidImage(t, color.RGBA{200, 100, 50, 255})); err != nil {
+		t.Fatalf("failed to store image: %v", err)
+	}
+
+	usage, err := store.UsageByNamespace()
+	if err != nil {
+		t.Fatalf("failed to compute usage: %v", err)
+	}
+
+	if usage["a"].Images != 2 || usage["b"].Images != 1 {
+		t.Errorf("unexpected image counts: a=%d b=%d", usage["a"].Images, usage["b"].Images)
+	}
+	if usage["b"].ExclusiveBytes != 0 {
+		t.Errorf("expected namespace b to share all its tiles, got %d exclusive bytes", usage["b"].ExclusiveBytes)
+	}
+	if usage["a"].ExclusiveBytes <= 0 {
+		t.Errorf("expected namespace a to own the tile of a/two, got %d exclusive bytes", usage["a"].ExclusiveBytes)
+	}
+	if usage["a"].Quota == nil || usage["b"].Quota != nil {
+		t.Errorf("expected only namespace a to report a quota")
+	}
+
+	stats := store.GetStorageStats()
+	if len(stats.Namespaces) != 2 {
+		t.Errorf("expected stats to report 2 namespaces, got %d", len(stats.Namespaces))
+	}
+}
+
+func TestQuotaEnforcement(t *testing.T) {
+	imageData := encodeSolidImage(t, color.RGBA{1, 2, 3, 255})
+	size := int64(len(imageData))
+
+	store := newQuotaTestStore(t, map[string]Quota{
+		"small": {MaxOriginalBytes: size - 1},
+		"pair":  {MaxOriginalBytes: 2 * size},
+	})
+
+	var quotaErr *QuotaExceededError
+
+	err := store.StoreImage("small/one", imageData)
+	if !errors.As(err, &quotaErr) || !quotaErr.TooLarge {
+		t.Fatalf("expected too-large quota error, got %v", err)
+	}
+
+	for _, id := range []string{"pair/one", "pair/two"} {
+		if err := store.StoreImage(id, imageData); err != nil {
+			t.Fatalf("failed to store image %s within quota: %v", id, err)
+		}
+	}
+
+	// Overwriting an image replaces its usage rather than adding to it
+	if err := store.StoreImage("pair/two", imageData); err != nil {
+		t.Errorf("expected overwrite within quota to succeed: %v", err)
+	}
+
+	err = store.StoreImage("pair/three", imageData)
+	if !errors.As(err, &quotaErr) || quotaErr.TooLarge {
+		t.Fatalf("expected cumulative quota error, got %v", err)
+	}
+	if quotaErr.Namespace != "pair" || quotaErr.Requested != 3*size {
+		t.Errorf("unexpected quota error details: %+v", quotaErr)
+	}
+
+	// Namespaces without a quota are unlimited
+	if err := store.StoreImage("other/one", imageData); err != nil {
+		t.Errorf("expected unlimited namespace to accept image: %v", err)
+	}
+}
+
+func TestQuotaUsageCache(t *testing.T) {
+	imageData := encodeSolidImage(t, color.RGBA{1, 2, 3, 255})
+	size := int64(len(imageData))
+	store := newQuotaTestStore(t, map[string]Quota{"pair": {MaxOriginalBytes: 2 * size, MaxStoredBytes: 1 << 20}})
+
+	// Uploads adjust the cached usage instead of recomputing it
+	for i, id := range []string{"pair/one", "pair/two"} {
+		if err := store.StoreImage(id, encodeSolidImage(t, color.RGBA{uint8(i), 2, 3, 255})); err != nil {
+			t.Fatalf("failed to store image %s: %v", id, err)
+		}
+	}
+	usage, err := store.namespaceUsage("")
+	if err != nil {
+		t.Fatalf("failed to compute usage: %v", err)
+	}
+	cached, err := store.quotaUsage.get(store, "pair")
+	if err != nil {
+		t.Fatalf("failed to get cached usage: %v", err)
+	}
+	if cached != *usage["pair"] {
+		t.Errorf("expected cached usage %+v to match %+v", cached, *usage["pair"])
+	}
+
+	// Deletes aren't reflected in the cache, but a store the cache would
+	// reject is checked against the exact usage
+	if err := store.DeleteImage("pair/one"); err != nil {
+		t.Fatalf("failed to delete image: %v", err)
+	}
+	if err := store.StoreImage("pair/three", imageData); err != nil {
+		t.Errorf("expected the deleted image's quota to be freed: %v", err)
+	}
+	var quotaErr *QuotaExceededError
+	if err := store.StoreImage("pair/four", imageData); !errors.As(err, &quotaErr) {
+		t.Errorf("expected cumulative quota error, got %v", err)
+	}
+}
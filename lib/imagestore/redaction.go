@@ -0,0 +1,181 @@
+package imagestore
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"slices"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// Redaction modes
+const (
+	RedactBlack = "black" // Filled with opaque black
+	RedactBlur  = "blur"  // Averaged over coarse blocks, hiding text but keeping the layout
+)
+
+// maxRedactions bounds the redactions on one image so the manifest stays small
+const maxRedactions = 100
+
+// redactionBlock is the side of the blocks a blurred region is averaged
+// over, large enough that text inside can't be read back
+const redactionBlock = 16
+
+// Redaction is a rectangle hidden whenever the image is rendered. The stored
+// tiles keep the original pixels, so a redaction can be changed or removed
+// later.
+type Redaction struct {
+	X      int    `json:"x"`
+	Y      int    `json:"y"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Mode   string `json:"mode,omitempty"` // RedactBlack or RedactBlur; empty is black
+}
+
+// rect returns the redacted rectangle
+func (r Redaction) rect() image.Rectangle {
+	return image.Rect(r.X, r.Y, r.X+r.Width, r.Y+r.Height)
+}
+
+// SetRedactions replaces the redactions of a live image; an empty list
+// removes them. Redactions are part of the manifest: they change its ETag,
+// follow the image through patches, clones and the trash, and are dropped
+// when the image is overwritten.
+func (s *PebbleImageStore) SetRedactions(id string, redactions []Redaction) error {
+	if err := s.enter(); err != nil {
+		return err
+	}
+	defer s.leave()
+
+	if len(redactions) > maxRedactions {
+		return fmt.Errorf("invalid redaction: at most %d redactions are allowed", maxRedactions)
+	}
+
+	// Redactions are checked against and written onto the manifest read
+	// here, so hold the version lock until the commit
+	unlock := s.lockVersion(id)
+	defer unlock()
+
+	imageKey := makeKey(imagesBucket, id)
+	imageData, closer, err := s.db.Get(imageKey)
+	if err != nil {
+		return fmt.Errorf("image not found: %s", id)
+	}
+
+	var storedImage StoredImage
+	err = decodeManifest(imageData, &storedImage)
+	closer.Close()
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal image: %w", err)
+	}
+
+	bounds := image.Rect(0, 0, storedImage.Width, storedImage.Height)
+	for i, redaction := range redactions {
+		switch redaction.Mode {
+		case "", RedactBlack, RedactBlur:
+		default:
+			return fmt.Errorf("invalid redaction %d: unknown mode %q", i, redaction.Mode)
+		}
+		if redaction.X < 0 || redaction.Y < 0 || redaction.rect().Empty() || !redaction.rect().In(bounds) {
+			return fmt.Errorf("invalid redaction %d: %dx%d at (%d, %d) outside the %dx%d image", i, redaction.Width, redaction.Height, redaction.X, redaction.Y, storedImage.Width, storedImage.Height)
+		}
+	}
+	storedImage.Redactions = slices.Clone(redactions)
+	if len(redactions) == 0 {
+		storedImage.Redactions = nil
+	}
+
+	imageBytes, err := encodeManifest(&storedImage)
+	if err != nil {
+		return fmt.Errorf("failed to marshal image: %w", err)
+	}
+
+	batch := s.db.NewBatch()
+	defer batch.Close()
+	if err := batch.Set(imageKey, imageBytes, pebble.Sync); err != nil {
+		return fmt.Errorf("failed to store redactions: %w", err)
+	}
+	return s.commitChanges(batch, AuditRedactionsChanged, id)
+}
+
+// GetRedactions returns the redactions of a live image
+func (s *PebbleImageStore) GetRedactions(id string) ([]Redaction, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+
+	imageData, closer, err := s.db.Get(makeKey(imagesBucket, id))
+	if err != nil {
+		return nil, fmt.Errorf("image not found: %s", id)
+	}
+	defer closer.Close()
+
+	var storedImage StoredImage
+	if err := decodeManifest(imageData, &storedImage); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal image: %w", err)
+	}
+	if storedImage.Redactions == nil {
+		return []Redaction{}, nil
+	}
+	return storedImage.Redactions, nil
+}
+
+// applyRedactions hides the redacted rectangles of a reconstructed image in
+// place
+func applyRedactions(img image.Image, redactions []Redaction) image.Image {
+	if len(redactions) == 0 {
+		return img
+	}
+	rgba, ok := img.(*image.RGBA)
+	if !ok {
+		rgba = image.NewRGBA(img.Bounds())
+		draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Src)
+	}
+
+	for _, redaction := range redactions {
+		area := redaction.rect().Intersect(rgba.Bounds())
+		if redaction.Mode == RedactBlur {
+			blurBlocks(rgba, area)
+		} else {
+			draw.Draw(rgba, area, image.NewUniform(color.RGBA{A: 255}), image.Point{}, draw.Src)
+		}
+	}
+	return rgba
+}
+
+// blurBlocks replaces each redactionBlock square of area with its average
+// color
+func blurBlocks(img *image.RGBA, area image.Rectangle) {
+	for y0 := area.Min.Y; y0 < area.Max.Y; y0 += redactionBlock {
+		for x0 := area.Min.X; x0 < area.Max.X; x0 += redactionBlock {
+			block := image.Rect(x0, y0, x0+redactionBlock, y0+redactionBlock).Intersect(area)
+
+			var sum [4]int
+			for y := block.Min.Y; y < block.Max.Y; y++ {
+				for x := block.Min.X; x < block.Max.X; x++ {
+					offset := img.PixOffset(x, y)
+					for c := range sum {
+						sum[c] += int(img.Pix[offset+c])
+					}
+				}
+			}
+
+			n := block.Dx() * block.Dy()
+			average := color.RGBA{uint8(sum[0] / n), uint8(sum[1] / n), uint8(sum[2] / n), uint8(sum[3] / n)}
+			draw.Draw(img, block, image.NewUniform(average), image.Point{}, draw.Src)
+		}
+	}
+}
+
+// overlapsRedaction reports whether rect covers any of the redacted area
+func overlapsRedaction(rect image.Rectangle, redactions []Redaction) bool {
+	for _, redaction := range redactions {
+		if rect.Overlaps(redaction.rect()) {
+			return true
+		}
+	}
+	return false
+}
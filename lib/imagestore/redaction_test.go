@@ -0,0 +1,140 @@
+package imagestore
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRedactions(t *testing.T) {
+	store := newTagsTestStore(t, "screen")
+	before := store.GetStorageStats()
+	plain, _ := store.RetrieveImage("screen")
+	etag, _ := store.ImageETag("screen")
+
+	redactions := []Redaction{
+		{X: 0, Y: 0, Width: 4, Height: 2},
+		{X: 4, Y: 4, Width: 4, Height: 4, Mode: RedactBlur},
+	}
+	if err := store.SetRedactions("screen", redactions); err != nil {
+		t.Fatalf("failed to set redactions: %v", err)
+	}
+	if stored, err := store.GetRedactions("screen"); err != nil || len(stored) != 2 || stored[1] != redactions[1] {
+		t.Errorf("expected the redactions back, got %v (%v)", stored, err)
+	}
+	if changed, _ := store.ImageETag("screen"); changed == etag {
+		t.Error("expected the ETag to change with the redactions")
+	}
+
+	data, err := store.RetrieveImage("screen")
+	if err != nil {
+		t.Fatalf("failed to retrieve image: %v", err)
+	}
+	img, _ := decodeImageFromBytes(data)
+	original := createTestImage(8, 8)
+	if got := color.RGBAModel.Convert(img.At(3, 1)); got != (color.RGBA{0, 0, 0, 255}) {
+		t.Errorf("expected black at (3, 1), got %v", got)
+	}
+	if got, want := color.RGBAModel.Convert(img.At(3, 3)), color.RGBAModel.Convert(original.At(3, 3)); got != want {
+		t.Errorf("expected (3, 3) untouched, got %v want %v", got, want)
+	}
+	blurred := color.RGBAModel.Convert(img.At(4, 4))
+	if blurred != color.RGBAModel.Convert(img.At(7, 7)) || blurred == color.RGBAModel.Convert(original.At(4, 4)) {
+		t.Errorf("expected the blurred block to be one averaged color, got %v and %v", img.At(4, 4), img.At(7, 7))
+	}
+
+	// Redactions never touch the stored tiles
+	if after := store.GetStorageStats(); after.UniqueTiles != before.UniqueTiles {
+		t.Errorf("expected no new tiles, got %d -> %d", before.UniqueTiles, after.UniqueTiles)
+	}
+
+	// Clones keep the redactions, and compose can't copy the hidden pixels out
+	if err := store.CloneImage("screen", "copy"); err != nil {
+		t.Fatalf("failed to clone: %v", err)
+	}
+	if clone, _ := store.RetrieveImage("copy"); !bytes.Equal(clone, data) {
+		t.Error("expected the clone to be redacted too")
+	}
+	_, err = store.ComposeImage("leak", 4, 4, []ComposeRegion{{Source: "screen", X: 2, Y: 0, Width: 4, Height: 4}}, StoreOptions{})
+	if err == nil || !strings.Contains(err.Error(), "overlaps a redacted area") {
+		t.Errorf("expected compose over a redaction to be rejected, got %v", err)
+	}
+
+	invalid := []Redaction{
+		{X: 0, Y: 0, Width: 0, Height: 4},
+		{X: 6, Y: 6, Width: 4, Height: 4},
+		{X: -1, Y: 0, Width: 2, Height: 2},
+		{X: 0, Y: 0, Width: 2, Height: 2, Mode: "pixelate"},
+	}
+	for _, redaction := range invalid {
+		if err := store.SetRedactions("screen", []Redaction{redaction}); err == nil || !strings.Contains(err.Error(), "invalid redaction") {
+			t.Errorf("expected %+v to be rejected, got %v", redaction, err)
+		}
+	}
+	if err := store.SetRedactions("missing", redactions); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("expected not found, got %v", err)
+	}
+
+	// Clearing them restores the original pixels
+	if err := store.SetRedactions("screen", nil); err != nil {
+		t.Fatalf("failed to clear redactions: %v", err)
+	}
+	if cleared, _ := store.RetrieveImage("screen"); !bytes.Equal(cleared, plain) {
+		t.Error("expected the original image once the redactions are cleared")
+	}
+}
+
+func TestRedactionsConcurrentWithTags(t *testing.T) {
+	store := newTagsTestStore(t, "screen")
+
+	const rounds = 25
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < rounds; i++ {
+			if err := store.SetRedactions("screen", []Redaction{{X: 0, Y: 0, Width: 1 + i%8, Height: 1}}); err != nil {
+				t.Errorf("failed to set redactions: %v", err)
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < rounds; i++ {
+			if err := store.AddTags("screen", fmt.Sprintf("t%d", i)); err != nil {
+				t.Errorf("failed to add tags: %v", err)
+				return
+			}
+		}
+	}()
+	wg.Wait()
+
+	manifest, err := store.GetManifest("screen")
+	if err != nil {
+		t.Fatalf("failed to get manifest: %v", err)
+	}
+	if len(manifest.Tags) != rounds {
+		t.Errorf("expected all %d tags kept, got %d", rounds, len(manifest.Tags))
+	}
+	if len(manifest.Redactions) != 1 || manifest.Redactions[0].Width != 1+(rounds-1)%8 {
+		t.Errorf("expected the last redaction kept, got %v", manifest.Redactions)
+	}
+}
+
+func TestBlurBlocks(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	img.Set(0, 0, color.RGBA{200, 0, 0, 255})
+	img.Set(1, 0, color.RGBA{0, 100, 0, 255})
+
+	blurBlocks(img, img.Bounds())
+	for x := 0; x < 2; x++ {
+		if got := img.RGBAAt(x, 0); got != (color.RGBA{100, 50, 0, 255}) {
+			t.Errorf("expected the average at (%d, 0), got %v", x, got)
+		}
+	}
+}
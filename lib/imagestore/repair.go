@@ -0,0 +1,269 @@
+package imagestore
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// RepairReport describes what Repair salvaged and what it had to drop
+type RepairReport struct {
+	ImagesRecovered  int
+	TrashRecovered   int
+	TilesRecovered   int
+	ImportsRecovered int
+	DamagedImages    []string // Manifests dropped because they were invalid or a tile was lost
+	MissingTiles     []TileID // Referenced tiles absent from the database
+	CorruptTiles     []TileID // Tiles that failed to decode or didn't match their hash
+	UnreadableKeys   []string // Keys whose values could not be parsed
+	ScanErrors       []string // Buckets whose scan stopped early
+}
+
+// Repair copies everything readable from the database at
+// config.DatabasePath into a fresh database at outPath. Every tile an image
+// references is decoded and verified against its hash; images that lose a
+// tile are dropped and reported. Secondary indexes are rebuilt from the
+// salvaged manifests and unreferenced tiles are left behind. The source is
+// opened read-only and never modified.
+func Repair(config *Config, outPath string) (*RepairReport, error) {
+	if entries, err := os.ReadDir(outPath); err == nil && len(entries) > 0 {
+		return nil, fmt.Errorf("repair output %s is not empty", outPath)
+	}
+
+	sourceConfig := *config
+	sourceConfig.TileDumpDir = ""
+	source, err := openPebbleImageStore(&sourceConfig, &pebble.Options{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer source.Close()
+
+	destConfig := *config
+	destConfig.DatabasePath = outPath
+	destConfig.TileDumpDir = ""
+	dest, err := openPebbleImageStore(&destConfig, &pebble.Options{})
+	if err != nil {
+		return nil, err
+	}
+	defer dest.Close()
+
+	r := &repairer{
+		source: source,
+		dest:   dest,
+		report: &RepairReport{},
+		tiles:  make(map[TileID]bool),
+	}
+
+	if err := r.copyManifests(imagesBucket, true); err != nil {
+		return nil, err
+	}
+	if err := r.copyManifests(trashBucket, false); err != nil {
+		return nil, err
+	}
+	if err := r.copyImports(); err != nil {
+		return nil, err
+	}
+
+	if err := dest.db.Flush(); err != nil {
+		return nil, fmt.Errorf("failed to flush repaired database: %w", err)
+	}
+
+	report := r.report
+	sort.Strings(report.DamagedImages)
+	sort.Slice(report.MissingTiles, func(i, j int) bool { return report.MissingTiles[i] < report.MissingTiles[j] })
+	sort.Slice(report.CorruptTiles, func(i, j int) bool { return report.CorruptTiles[i] < report.CorruptTiles[j] })
+	sort.Strings(report.UnreadableKeys)
+
+	return report, nil
+}
+
+// repairer carries the state of one Repair run
+type repairer struct {
+	source, dest *PebbleImageStore
+	report       *RepairReport
+	tiles        map[TileID]bool // Tiles already checked, and whether they were salvaged
+}
+
+// copyManifests salvages the manifests in a bucket along with their tiles,
+// indexing live images in the destination
+func (r *repairer) copyManifests(bucket []byte, live bool) error {
+	prefix := makePrefixKey(bucket)
+	iter, err := r.source.db.NewIter(&pebble.IterOptions{
+		LowerBound: prefix,
+		UpperBound: prefixUpperBound(prefix),
+	})
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+
+	for iter.First(); iter.Valid(); iter.Next() {
+		id := strings.TrimPrefix(string(iter.Key()), string(prefix))
+
+		var storedImage StoredImage
+		if err := decodeManifest(iter.Value(), &storedImage); err != nil {
+			r.report.UnreadableKeys = append(r.report.UnreadableKeys, string(iter.Key()))
+			continue
+		}
+		storedImage.ID = id
+
+		ok, err := r.salvageable(&storedImage)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			r.report.DamagedImages = append(r.report.DamagedImages, id)
+			continue
+		}
+
+		if err := r.writeManifest(bucket, &storedImage, live); err != nil {
+			return err
+		}
+		if live {
+			r.report.ImagesRecovered++
+		} else {
+			r.report.TrashRecovered++
+		}
+	}
+
+	if err := iter.Error(); err != nil {
+		r.report.ScanErrors = append(r.report.ScanErrors, fmt.Sprintf("%s: %v", bucket, err))
+	}
+	return nil
+}
+
+// salvageable reports whether a manifest is valid and all of its tiles
+// could be copied. Every tile is checked so the report lists them all.
+func (r *repairer) salvageable(storedImage *StoredImage) (bool, error) {
+	if err := validateTileRefs(storedImage, r.source.config.TileSize); err != nil {
+		return false, nil
+	}
+
+	ok := true
+	for _, tileRef := range storedImage.TileRefs {
+		copied, err := r.copyTile(tileRef.TileID)
+		if err != nil {
+			return false, err
+		}
+		ok = ok && copied
+	}
+	return ok, nil
+}
+
+// copyTile verifies a tile and copies its stored encoding to the
+// destination, remembering the outcome
+func (r *repairer) copyTile(tileID TileID) (bool, error) {
+	if salvaged, checked := r.tiles[tileID]; checked {
+		return salvaged, nil
+	}
+	r.tiles[tileID] = false
+
+	// The source is opened read-only and so may still use the hex tile keys
+	// of an older key layout
+	key := tileKey(tileID)
+	value, closer, err := r.source.db.Get(key)
+	if err != nil {
+		value, closer, err = r.source.db.Get(makeKey(tilesBucket, string(tileID)))
+	}
+	if err != nil {
+		r.report.MissingTiles = append(r.report.MissingTiles, tileID)
+		return false, nil
+	}
+	defer closer.Close()
+
+	// A cold tier stub can't be checked without the cold store; keep it so
+	// the tile is fetched from there as before
+	if isColdStub(value) {
+		if err := r.dest.db.Set(key, value, pebble.NoSync); err != nil {
+			return false, fmt.Errorf("failed to write tile %s: %w", tileID, err)
+		}
+		r.tiles[tileID] = true
+		r.report.TilesRecovered++
+		return true, nil
+	}
+
+	// Blob files are copied tile by tile, so a damaged one loses only the
+	// tiles it can't read back
+	if isBlobPointer(value) {
+		if value, err = r.source.readBlobTile(tileID, value); err != nil {
+			r.report.CorruptTiles = append(r.report.CorruptTiles, tileID)
+			return false, nil
+		}
+	}
+
+	data, err := r.source.decompressTileData(value)
+	if err != nil || GenerateTileID(ComputeTileHash(data)) != contentTileID(tileID) {
+		r.report.CorruptTiles = append(r.report.CorruptTiles, tileID)
+		return false, nil
+	}
+
+	values := [][]byte{value}
+	release, err := r.dest.separateBlobs(values)
+	if err != nil {
+		return false, fmt.Errorf("failed to write tile %s: %w", tileID, err)
+	}
+	defer release()
+	if err := r.dest.db.Set(key, values[0], pebble.NoSync); err != nil {
+		return false, fmt.Errorf("failed to write tile %s: %w", tileID, err)
+	}
+
+	r.tiles[tileID] = true
+	r.report.TilesRecovered++
+	return true, nil
+}
+
+// writeManifest stores a salvaged manifest, rebuilding its index entries
+func (r *repairer) writeManifest(bucket []byte, storedImage *StoredImage, live bool) error {
+	data, err := encodeManifest(storedImage)
+	if err != nil {
+		return fmt.Errorf("failed to marshal image %s: %w", storedImage.ID, err)
+	}
+
+	batch := r.dest.db.NewBatch()
+	defer batch.Close()
+
+	if err := batch.Set(makeKey(bucket, storedImage.ID), data, pebble.NoSync); err != nil {
+		return err
+	}
+	if live {
+		if err := indexImage(batch, storedImage); err != nil {
+			return err
+		}
+	}
+
+	return batch.Commit(pebble.NoSync)
+}
+
+// copyImports salvages the import records of external sources
+func (r *repairer) copyImports() error {
+	prefix := makePrefixKey(importBucket)
+	iter, err := r.source.db.NewIter(&pebble.IterOptions{
+		LowerBound: prefix,
+		UpperBound: prefixUpperBound(prefix),
+	})
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+
+	for iter.First(); iter.Valid(); iter.Next() {
+		var record ImportRecord
+		if err := json.Unmarshal(iter.Value(), &record); err != nil {
+			r.report.UnreadableKeys = append(r.report.UnreadableKeys, string(iter.Key()))
+			continue
+		}
+		if err := r.dest.db.Set(iter.Key(), iter.Value(), pebble.NoSync); err != nil {
+			return err
+		}
+		r.report.ImportsRecovered++
+	}
+
+	if err := iter.Error(); err != nil {
+		r.report.ScanErrors = append(r.report.ScanErrors, fmt.Sprintf("%s: %v", importBucket, err))
+	}
+	return nil
+}
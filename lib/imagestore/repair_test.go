@@ -0,0 +1,98 @@
+package imagestore
+
+import (
+	"image/color"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+)
+
+func TestRepair(t *testing.T) {
+	config := DefaultConfig()
+	config.DatabasePath = filepath.Join(t.TempDir(), "damaged.db")
+	config.TileSize = 4
+	config.TrashRetention = time.Hour
+
+	store, err := NewPebbleImageStore(config)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	imageData, _ := encodeImageToPNG(createTestImage(8, 8))
+	images := map[string][]byte{
+		"good":    imageData,
+		"corrupt": encodeSolidImage(t, color.RGBA{255, 0, 0, 255}),
+		"missing": encodeSolidImage(t, color.RGBA{0, 255, 0, 255}),
+		"trashed": encodeSolidImage(t, color.RGBA{0, 0, 255, 255}),
+	}
+	for id, data := range images {
+		if err := store.StoreImage(id, data); err != nil {
+			t.Fatalf("failed to store %s: %v", id, err)
+		}
+	}
+	store.AddTags("good", "keep")
+	store.DeleteImage("trashed")
+	store.RecordImport("s3://bucket", "good.png", ImportRecord{ImageID: "good", ETag: "etag"})
+	expected, _ := store.RetrieveImage("good")
+
+	// Damage the store: garble one tile, lose another and garble a manifest
+	corrupt, _ := store.GetManifest("corrupt")
+	missing, _ := store.GetManifest("missing")
+	store.db.Set(tileKey(corrupt.TileRefs[0].TileID), []byte("garbage"), pebble.Sync)
+	store.db.Delete(tileKey(missing.TileRefs[0].TileID), pebble.Sync)
+	store.db.Set(makeKey(imagesBucket, "unreadable"), []byte("{not json"), pebble.Sync)
+	store.Close()
+
+	outPath := filepath.Join(t.TempDir(), "repaired.db")
+	report, err := Repair(config, outPath)
+	if err != nil {
+		t.Fatalf("repair failed: %v", err)
+	}
+
+	if report.ImagesRecovered != 1 || report.TrashRecovered != 1 || report.ImportsRecovered != 1 {
+		t.Errorf("unexpected recovery counts: %+v", report)
+	}
+	if !reflect.DeepEqual(report.DamagedImages, []string{"corrupt", "missing"}) {
+		t.Errorf("expected corrupt and missing to be damaged, got %v", report.DamagedImages)
+	}
+	if len(report.CorruptTiles) != 1 || report.CorruptTiles[0] != corrupt.TileRefs[0].TileID {
+		t.Errorf("expected the garbled tile to be reported, got %v", report.CorruptTiles)
+	}
+	if len(report.MissingTiles) != 1 || report.MissingTiles[0] != missing.TileRefs[0].TileID {
+		t.Errorf("expected the lost tile to be reported, got %v", report.MissingTiles)
+	}
+	if !reflect.DeepEqual(report.UnreadableKeys, []string{"images:unreadable"}) {
+		t.Errorf("expected the garbled manifest to be reported, got %v", report.UnreadableKeys)
+	}
+
+	// Repairing into a non-empty directory is refused
+	if _, err := Repair(config, outPath); err == nil {
+		t.Error("expected repair into an existing database to fail")
+	}
+
+	repairedConfig := *config
+	repairedConfig.DatabasePath = outPath
+	repaired, err := NewPebbleImageStore(&repairedConfig)
+	if err != nil {
+		t.Fatalf("failed to open repaired store: %v", err)
+	}
+	defer repaired.Close()
+
+	data, err := repaired.RetrieveImage("good")
+	if err != nil || string(data) != string(expected) {
+		t.Fatalf("good image not recovered intact: %v", err)
+	}
+	assertTagged(t, repaired, "keep", []string{"good"})
+	if err := repaired.UndeleteImage("trashed"); err != nil {
+		t.Errorf("failed to restore trashed image: %v", err)
+	}
+	if record, _ := repaired.LookupImport("s3://bucket", "good.png"); record == nil || record.ETag != "etag" {
+		t.Errorf("import record not recovered: %+v", record)
+	}
+	if stats := repaired.GetStorageStats(); stats.UniqueTiles != report.TilesRecovered {
+		t.Errorf("expected %d tiles in repaired store, got %d", report.TilesRecovered, stats.UniqueTiles)
+	}
+}
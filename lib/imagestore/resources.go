@@ -0,0 +1,96 @@
+package imagestore
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// Resources bounds how much of the host concurrent requests can claim.
+// Image writes are bounded separately by Config.MaxWriters. Zero fields are
+// unlimited.
+type Resources struct {
+	MaxReconstructions int      // Images reconstructed at once; other reads wait their turn
+	MaxDecompressors   int      // Tiles decompressed at once across the whole store
+	ImageCacheBytes    int64    // Memory for retrieved images kept as PNGs for repeat reads; 0 disables the cache
+	Preload            []string // Image ID prefixes cached at startup and after RecompressTiles; needs ImageCacheBytes
+}
+
+// ResourceStats reports how busy the bounded resources are
+type ResourceStats struct {
+	MaxReconstructions    int           // From Config.Resources; 0 when unlimited
+	MaxDecompressors      int           // From Config.Resources; 0 when unlimited
+	ActiveReconstructions int           // Reconstructions running now
+	ActiveDecompressors   int           // Tile decompressions running now
+	ReconstructionWait    time.Duration // Total time reads spent waiting for a reconstruction slot
+	SharedRetrievals      int64         // Retrievals that shared a concurrent identical request's reconstruction
+}
+
+// semaphore admits a bounded number of holders. A nil semaphore admits
+// everyone.
+type semaphore struct {
+	slots  chan struct{}
+	active atomic.Int64
+	waited atomic.Int64 // Nanoseconds spent blocked in acquire
+}
+
+// newSemaphore returns a semaphore with n slots, or nil when n is zero
+func newSemaphore(n int) *semaphore {
+	if n <= 0 {
+		return nil
+	}
+	return &semaphore{slots: make(chan struct{}, n)}
+}
+
+// acquire waits for a slot
+func (s *semaphore) acquire() {
+	s.acquireContext(context.Background())
+}
+
+// acquireContext waits for a slot, giving up with ctx's error once ctx is
+// done
+func (s *semaphore) acquireContext(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+	select {
+	case s.slots <- struct{}{}:
+	default:
+		start := time.Now()
+		select {
+		case s.slots <- struct{}{}:
+		case <-ctx.Done():
+			s.waited.Add(int64(time.Since(start)))
+			return ctx.Err()
+		}
+		s.waited.Add(int64(time.Since(start)))
+	}
+	s.active.Add(1)
+	return nil
+}
+
+// release frees a slot taken by acquire
+func (s *semaphore) release() {
+	if s == nil {
+		return
+	}
+	s.active.Add(-1)
+	<-s.slots
+}
+
+// resourceStats reports the store's resource limits and their use
+func (s *PebbleImageStore) resourceStats() ResourceStats {
+	stats := ResourceStats{
+		MaxReconstructions: s.config.Resources.MaxReconstructions,
+		MaxDecompressors:   s.config.Resources.MaxDecompressors,
+		SharedRetrievals:   s.sharedRetrievals.Load(),
+	}
+	if s.reconstructions != nil {
+		stats.ActiveReconstructions = int(s.reconstructions.active.Load())
+		stats.ReconstructionWait = time.Duration(s.reconstructions.waited.Load())
+	}
+	if s.decompressors != nil {
+		stats.ActiveDecompressors = int(s.decompressors.active.Load())
+	}
+	return stats
+}
@@ -0,0 +1,94 @@
+package imagestore
+
+import (
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSemaphoreBoundsHolders(t *testing.T) {
+	sem := newSemaphore(2)
+
+	var active, peak atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem.acquire()
+			defer sem.release()
+
+			n := active.Add(1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			active.Add(-1)
+		}()
+	}
+	wg.Wait()
+
+	if peak.Load() > 2 {
+		t.Errorf("expected at most 2 holders at once, saw %d", peak.Load())
+	}
+	if sem.active.Load() != 0 {
+		t.Errorf("expected no active holders afterwards, got %d", sem.active.Load())
+	}
+
+	// A nil semaphore is unlimited
+	var unlimited *semaphore
+	unlimited.acquire()
+	unlimited.release()
+}
+
+func TestResourceLimitedRetrieval(t *testing.T) {
+	config := DefaultConfig()
+	config.DatabasePath = filepath.Join(t.TempDir(), "test.db")
+	config.TileSize = 4
+	config.Resources = Resources{MaxReconstructions: 1, MaxDecompressors: 1}
+
+	store, err := NewPebbleImageStore(config)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	imageData, err := encodeImageToPNG(createTestImage(16, 16))
+	if err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	if err := store.StoreImage("a", imageData); err != nil {
+		t.Fatalf("failed to store image: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := store.RetrieveImage("a")
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("retrieval failed: %v", err)
+		}
+	}
+
+	stats := store.GetStorageStats().Resources
+	if stats.MaxReconstructions != 1 || stats.MaxDecompressors != 1 {
+		t.Errorf("expected limits of 1, got %+v", stats)
+	}
+	if stats.ActiveReconstructions != 0 || stats.ActiveDecompressors != 0 {
+		t.Errorf("expected nothing active after the reads, got %+v", stats)
+	}
+}
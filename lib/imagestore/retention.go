@@ -0,0 +1,250 @@
+package imagestore
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// RetentionPolicy limits how many live images under an ID prefix are kept.
+// Zero fields are unlimited. The newest images are kept first.
+type RetentionPolicy struct {
+	Prefix           string        // Image ID prefix the policy covers, such as "ci/"; "" covers every image
+	MaxAge           time.Duration // Delete images stored longer ago than this
+	MaxCount         int           // Keep at most this many images
+	MaxOriginalBytes int64         // Keep images while their uploaded sizes total at most this
+	MaxStoredBytes   int64         // Keep images while their exclusive bytes total at most this
+}
+
+// RetentionCandidate is an image a retention run deletes
+type RetentionCandidate struct {
+	ID             string     `json:"id"`
+	StoredAt       *time.Time `json:"stored_at,omitempty"`
+	OriginalBytes  int64      `json:"original_bytes"`
+	ExclusiveBytes int64      `json:"exclusive_bytes"` // Bytes freed if this image alone were deleted
+	Reason         string     `json:"reason"`          // Limit that selected the image, e.g. "max_age"
+	Prefix         string     `json:"prefix"`          // Prefix of the policy that selected it
+}
+
+// RetentionReport describes a retention run. Reclaimable figures account for
+// deduplication: a tile is only counted when no surviving live or trashed
+// image references it.
+type RetentionReport struct {
+	DryRun           bool                 `json:"dry_run"`
+	Images           []RetentionCandidate `json:"images"`
+	OriginalBytes    int64                `json:"original_bytes"`    // Uploaded size of the deleted images
+	ReclaimableTiles int                  `json:"reclaimable_tiles"` // Tiles referenced only by deleted images
+	ReclaimableBytes int64                `json:"reclaimable_bytes"` // Stored bytes of those tiles
+}
+
+// RunRetention applies the configured Config.RetentionPolicies
+func (s *PebbleImageStore) RunRetention(dryRun bool) (*RetentionReport, error) {
+	return s.ApplyRetention(s.config.RetentionPolicies, dryRun)
+}
+
+// retainedImage is what a retention run needs to know about a live image
+type retainedImage struct {
+	id            string
+	storedAt      *time.Time
+	originalBytes int64
+	tiles         []TileID // Distinct tiles
+	exclusive     int64    // Stored bytes of tiles no other image references
+}
+
+// storedNow returns the current time for StoredImage.StoredAt
+func storedNow() *time.Time {
+	now := time.Now().UTC()
+	return &now
+}
+
+// ApplyRetention deletes live images that exceed any of the policies,
+// bypassing the trash, then collects their unreferenced tiles. With dryRun
+// nothing is deleted and the report shows what would be. Images stored
+// before StoredAt was recorded have no known age: MaxAge never selects them,
+// and the count and size limits treat them as the oldest.
+func (s *PebbleImageStore) ApplyRetention(policies []RetentionPolicy, dryRun bool) (*RetentionReport, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+
+	snapshot := s.db.NewSnapshot()
+	defer snapshot.Close()
+
+	images, err := retainedImages(snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &RetentionReport{DryRun: dryRun, Images: []RetentionCandidate{}}
+	selected := make(map[string]bool)
+	now := time.Now()
+
+	for _, policy := range policies {
+		count := 0
+		var originalBytes, storedBytes int64
+		for _, image := range images {
+			if !strings.HasPrefix(image.id, policy.Prefix) {
+				continue
+			}
+			count++
+			originalBytes += image.originalBytes
+			storedBytes += image.exclusive
+
+			reason := ""
+			switch {
+			case policy.MaxAge > 0 && image.storedAt != nil && now.Sub(*image.storedAt) > policy.MaxAge:
+				reason = "max_age"
+			case policy.MaxCount > 0 && count > policy.MaxCount:
+				reason = "max_count"
+			case policy.MaxOriginalBytes > 0 && originalBytes > policy.MaxOriginalBytes:
+				reason = "max_original_bytes"
+			case policy.MaxStoredBytes > 0 && storedBytes > policy.MaxStoredBytes:
+				reason = "max_stored_bytes"
+			}
+			if reason == "" || selected[image.id] {
+				continue
+			}
+
+			selected[image.id] = true
+			report.Images = append(report.Images, RetentionCandidate{
+				ID:             image.id,
+				StoredAt:       image.storedAt,
+				OriginalBytes:  image.originalBytes,
+				ExclusiveBytes: image.exclusive,
+				Reason:         reason,
+				Prefix:         policy.Prefix,
+			})
+			report.OriginalBytes += image.originalBytes
+		}
+	}
+
+	if err := reclaimable(snapshot, images, selected, report); err != nil {
+		return nil, err
+	}
+
+	if dryRun || len(report.Images) == 0 {
+		return report, nil
+	}
+
+	for _, candidate := range report.Images {
+		unlock := s.lockVersion(candidate.ID)
+		err := s.deleteImagePermanently(candidate.ID)
+		unlock()
+		if err != nil && !strings.Contains(err.Error(), "not found") {
+			return report, fmt.Errorf("failed to delete image %s: %w", candidate.ID, err)
+		}
+	}
+	if _, _, err := s.CollectGarbage(); err != nil {
+		return report, err
+	}
+
+	fmt.Printf("Retention deleted %d images, reclaiming %d bytes\n", len(report.Images), report.ReclaimableBytes)
+	return report, nil
+}
+
+// retainedImages loads every live image, newest first, with the bytes each
+// would free if deleted alone
+func retainedImages(snapshot *pebble.Snapshot) ([]retainedImage, error) {
+	prefix := makePrefixKey(imagesBucket)
+	iter, err := snapshot.NewIter(&pebble.IterOptions{
+		LowerBound: prefix,
+		UpperBound: prefixUpperBound(prefix),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	var images []retainedImage
+	for iter.First(); iter.Valid(); iter.Next() {
+		var storedImage StoredImage
+		if err := decodeManifest(iter.Value(), &storedImage); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal image: %w", err)
+		}
+
+		images = append(images, retainedImage{
+			id:            storedImage.ID,
+			storedAt:      storedImage.StoredAt,
+			originalBytes: storedImage.OriginalBytes,
+			tiles:         distinctTiles(storedImage.TileRefs),
+		})
+	}
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+
+	// Trashed images hold their tiles too, so they count towards sharing
+	counts, err := tileImageCounts(snapshot, trashBucket)
+	if err != nil {
+		return nil, err
+	}
+	for _, image := range images {
+		for _, tileID := range image.tiles {
+			counts[tileID]++
+		}
+	}
+	for i := range images {
+		for _, tileID := range images[i].tiles {
+			if counts[tileID] != 1 {
+				continue
+			}
+			if value, closer, err := snapshot.Get(tileKey(tileID)); err == nil {
+				images[i].exclusive += storedTileSize(value)
+				closer.Close()
+			}
+		}
+	}
+
+	// Undated images sort last, as the oldest; ties keep ID order
+	sort.SliceStable(images, func(i, j int) bool {
+		a, b := images[i].storedAt, images[j].storedAt
+		if a == nil || b == nil {
+			return a != nil && b == nil
+		}
+		return a.After(*b)
+	})
+	return images, nil
+}
+
+// reclaimable adds the tiles referenced by selected images and by no
+// surviving live or trashed image to the report
+func reclaimable(snapshot *pebble.Snapshot, images []retainedImage, selected map[string]bool, report *RetentionReport) error {
+	if len(selected) == 0 {
+		return nil
+	}
+
+	// Trashed images keep their tiles until the trash is purged
+	kept, err := referencedTiles(snapshot, trashBucket)
+	if err != nil {
+		return err
+	}
+
+	freed := make(map[TileID]bool)
+	for _, image := range images {
+		for _, tileID := range image.tiles {
+			if selected[image.id] {
+				freed[tileID] = true
+			} else {
+				kept[tileID] = true
+			}
+		}
+	}
+
+	for tileID := range freed {
+		if kept[tileID] {
+			continue
+		}
+		value, closer, err := snapshot.Get(tileKey(tileID))
+		if err != nil {
+			continue // Already missing; nothing to reclaim
+		}
+		report.ReclaimableTiles++
+		report.ReclaimableBytes += storedTileSize(value)
+		closer.Close()
+	}
+	return nil
+}
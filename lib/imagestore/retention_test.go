@@ -0,0 +1,94 @@
+package imagestore
+
+import (
+	"image"
+	"image/color"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// setStoredAt backdates an image's upload time
+func setStoredAt(t *testing.T, store *PebbleImageStore, id string, storedAt *time.Time) {
+	t.Helper()
+
+	storedImage, err := store.GetManifest(id)
+	if err != nil {
+		t.Fatalf("failed to load %s: %v", id, err)
+	}
+	storedImage.StoredAt = storedAt
+	data, _ := encodeManifest(storedImage)
+	store.db.Set(makeKey(imagesBucket, id), data, pebble.Sync)
+}
+
+func TestApplyRetention(t *testing.T) {
+	store := newTagsTestStore(t, "ci/old", "ci/mid", "other")
+
+	distinct := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			distinct.Set(x, y, color.RGBA{uint8(x * 20), 99, uint8(y * 20), 255})
+		}
+	}
+	imageData, _ := encodeImageToPNG(distinct)
+	if err := store.StoreImage("ci/new", imageData); err != nil {
+		t.Fatalf("failed to store image: %v", err)
+	}
+
+	now := time.Now().UTC()
+	old := now.Add(-48 * time.Hour)
+	mid := now.Add(-time.Hour)
+	setStoredAt(t, store, "ci/old", &old)
+	setStoredAt(t, store, "ci/mid", &mid)
+	setStoredAt(t, store, "other", nil)
+
+	// "ci/old" is past the age limit, but "ci/mid" still uses its tiles
+	report, err := store.ApplyRetention([]RetentionPolicy{{Prefix: "ci/", MaxAge: 24 * time.Hour}}, true)
+	if err != nil {
+		t.Fatalf("failed to apply retention: %v", err)
+	}
+	if len(report.Images) != 1 || report.Images[0].ID != "ci/old" || report.Images[0].Reason != "max_age" {
+		t.Errorf("expected only ci/old selected by age, got %+v", report.Images)
+	}
+	if report.ReclaimableTiles != 0 || report.ReclaimableBytes != 0 {
+		t.Errorf("expected no reclaimable tiles while shared, got %+v", report)
+	}
+
+	// Undated images are never too old, but count as the oldest
+	report, _ = store.ApplyRetention([]RetentionPolicy{{MaxAge: time.Minute}}, true)
+	for _, candidate := range report.Images {
+		if candidate.ID == "other" {
+			t.Error("undated image selected by age")
+		}
+	}
+	report, _ = store.ApplyRetention([]RetentionPolicy{{MaxCount: 3}}, true)
+	if len(report.Images) != 1 || report.Images[0].ID != "other" {
+		t.Errorf("expected the undated image to go first, got %+v", report.Images)
+	}
+
+	// Keeping only the newest ci/ image frees the old tiles only if nothing
+	// else shares them; "other" does
+	report, err = store.ApplyRetention([]RetentionPolicy{{Prefix: "ci/", MaxCount: 1}}, false)
+	if err != nil {
+		t.Fatalf("failed to apply retention: %v", err)
+	}
+	if len(report.Images) != 2 || report.DryRun || report.ReclaimableTiles != 0 {
+		t.Errorf("expected ci/mid and ci/old deleted with nothing reclaimed, got %+v", report)
+	}
+	images, _ := store.ListImages()
+	if len(images) != 2 {
+		t.Errorf("expected ci/new and other to survive, got %v", images)
+	}
+
+	store.deleteImagePermanently("other")
+	report, _ = store.ApplyRetention([]RetentionPolicy{{MaxOriginalBytes: 1}}, true)
+	if len(report.Images) != 1 || report.Images[0].Reason != "max_original_bytes" || report.ReclaimableTiles != 4 {
+		t.Errorf("expected ci/new selected by size with its 4 tiles reclaimable, got %+v", report)
+	}
+
+	report, _ = store.ApplyRetention([]RetentionPolicy{{MaxStoredBytes: 1}}, true)
+	if len(report.Images) != 1 || report.Images[0].Reason != "max_stored_bytes" || report.Images[0].ExclusiveBytes != report.ReclaimableBytes {
+		t.Errorf("expected ci/new selected by its exclusive bytes, got %+v", report)
+	}
+}
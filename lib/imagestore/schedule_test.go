@@ -0,0 +1,42 @@
+package imagestore
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronSchedule(t *testing.T) {
+	from := time.Date(2026, 3, 14, 10, 17, 30, 0, time.UTC) // A Saturday
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2026, 3, 14, 10, 18, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2026, 3, 14, 11, 0, 0, 0, time.UTC)},
+		{"30 2 * * *", time.Date(2026, 3, 15, 2, 30, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2026, 3, 14, 10, 30, 0, 0, time.UTC)},
+		{"0 9-17/4 * * *", time.Date(2026, 3, 14, 13, 0, 0, 0, time.UTC)},
+		{"0 0 * * 1,3", time.Date(2026, 3, 16, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// Both day fields restricted match either, as in cron
+		{"0 0 20 * 0", time.Date(2026, 3, 15, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, tt := range tests {
+		schedule, err := parseCronSchedule(tt.spec)
+		if err != nil {
+			t.Errorf("%q: %v", tt.spec, err)
+			continue
+		}
+		if got := schedule.next(from); !got.Equal(tt.want) {
+			t.Errorf("%q: expected %v, got %v", tt.spec, tt.want, got)
+		}
+	}
+
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "@yearly", "a * * * *"} {
+		if err := ValidateSchedule(spec); err == nil {
+			t.Errorf("expected %q to be rejected", spec)
+		}
+	}
+}
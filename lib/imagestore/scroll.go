@@ -0,0 +1,240 @@
+package imagestore
+
+import (
+	"hash/fnv"
+	"image"
+	"image/color"
+	"math"
+	"math/bits"
+	"math/cmplx"
+	"sync"
+)
+
+// scrollThumbnailSize bounds the larger side of the thumbnails scroll
+// offsets are estimated on
+const scrollThumbnailSize = 128
+
+// scrollTracker remembers the last upload of each namespace, so the next
+// one can have its tile rows lined up with the content it shares after a
+// scroll. A nil tracker aligns nothing.
+type scrollTracker struct {
+	mu   sync.Mutex
+	last map[string]*scrollReference // By namespace
+}
+
+// scrollReference is what alignment needs of an earlier upload
+type scrollReference struct {
+	width       int
+	rows        []uint64    // Hash of each row of pixels
+	thumbnail   [][]float64 // Mean luminance of scale x scale blocks
+	scale       int
+	gridOffsetY int
+}
+
+func newScrollTracker(enabled bool) *scrollTracker {
+	if !enabled {
+		return nil
+	}
+	return &scrollTracker{last: make(map[string]*scrollReference)}
+}
+
+// align returns the grid offset that lines img's rows of tiles, or strips,
+// rowHeight pixels tall up with the last upload to namespace, and remembers
+// img for the next upload. Uploads of a different width start over at
+// offset 0.
+func (t *scrollTracker) align(namespace string, img image.Image, rowHeight int, background color.RGBA) int {
+	if t == nil {
+		return 0
+	}
+	current := newScrollReference(img, background)
+
+	t.mu.Lock()
+	previous := t.last[namespace]
+	t.mu.Unlock()
+
+	if previous != nil && previous.width == current.width && previous.scale == current.scale {
+		shift := scrollShift(previous, current)
+		current.gridOffsetY = ((previous.gridOffsetY+shift)%rowHeight + rowHeight) % rowHeight
+	}
+
+	t.mu.Lock()
+	t.last[namespace] = current
+	t.mu.Unlock()
+	return current.gridOffsetY
+}
+
+// newScrollReference hashes each row of img and builds its thumbnail
+func newScrollReference(img image.Image, background color.RGBA) *scrollReference {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	scale := max(1, (max(width, height)+scrollThumbnailSize-1)/scrollThumbnailSize)
+
+	ref := &scrollReference{
+		width:     width,
+		rows:      make([]uint64, height),
+		thumbnail: make([][]float64, (height+scale-1)/scale),
+		scale:     scale,
+	}
+	for i := range ref.thumbnail {
+		ref.thumbnail[i] = make([]float64, (width+scale-1)/scale)
+	}
+
+	row := make([]byte, 3*width)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			r, g, b := compositeRGB(img.At(bounds.Min.X+x, bounds.Min.Y+y), background)
+			row[3*x], row[3*x+1], row[3*x+2] = r, g, b
+			ref.thumbnail[y/scale][x/scale] += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+		}
+		hash := fnv.New64a()
+		hash.Write(row)
+		ref.rows[y] = hash.Sum64()
+	}
+	return ref
+}
+
+// scrollShift returns by how many rows current's content sits above
+// previous's: row y of current shows row y+shift of previous. Phase
+// correlation of the thumbnails gives a coarse estimate, refined to the
+// shift under which the most distinct rows match exactly.
+func scrollShift(previous, current *scrollReference) int {
+	coarse := phaseCorrelate(previous.thumbnail, current.thumbnail) * current.scale
+
+	best, bestScore := 0, matchingRows(previous.rows, current.rows, 0)
+	for shift := coarse - current.scale; shift <= coarse+current.scale; shift++ {
+		score := matchingRows(previous.rows, current.rows, shift)
+		if score > bestScore || (score == bestScore && abs(shift) < abs(best)) {
+			best, bestScore = shift, score
+		}
+	}
+	return best
+}
+
+// matchingRows counts rows of current equal to the row shift below in
+// previous, skipping rows that repeat the one above them so that flat
+// backgrounds don't vote for every shift
+func matchingRows(previous, current []uint64, shift int) int {
+	matches := 0
+	for y, hash := range current {
+		if y+shift < 0 || y+shift >= len(previous) || (y > 0 && current[y-1] == hash) {
+			continue
+		}
+		if previous[y+shift] == hash {
+			matches++
+		}
+	}
+	return matches
+}
+
+// phaseCorrelate returns the vertical translation, in thumbnail rows, that
+// best maps current onto previous
+func phaseCorrelate(previous, current [][]float64) int {
+	height, width := len(current), len(current[0])
+	rows, columns := nextPowerOfTwo(2*height), nextPowerOfTwo(width)
+
+	// Zero-mean images, padded to twice the height so shifts don't wrap
+	// onto each other
+	transform := func(img [][]float64) []complex128 {
+		var mean float64
+		for _, row := range img {
+			for _, v := range row {
+				mean += v
+			}
+		}
+		mean /= float64(height * width)
+
+		data := make([]complex128, rows*columns)
+		for y, row := range img {
+			for x, v := range row {
+				data[y*columns+x] = complex(v-mean, 0)
+			}
+		}
+		fft2(data, rows, columns, false)
+		return data
+	}
+	a, b := transform(previous), transform(current)
+
+	for i := range a {
+		cross := a[i] * cmplx.Conj(b[i])
+		if magnitude := cmplx.Abs(cross); magnitude > 1e-9 {
+			a[i] = cross / complex(magnitude, 0)
+		} else {
+			a[i] = 0
+		}
+	}
+	fft2(a, rows, columns, true)
+
+	peak, peakValue := 0, math.Inf(-1)
+	for y := 0; y < rows; y++ {
+		// Only vertical shifts are of interest, so sum over every column
+		var value float64
+		for x := 0; x < columns; x++ {
+			value += real(a[y*columns+x])
+		}
+		if value > peakValue {
+			peak, peakValue = y, value
+		}
+	}
+	if peak > rows/2 {
+		peak -= rows
+	}
+	return peak
+}
+
+// fft2 transforms a rows x columns row-major grid in place, inverting the
+// transform (without scaling) when invert is set
+func fft2(data []complex128, rows, columns int, invert bool) {
+	for y := 0; y < rows; y++ {
+		fft(data[y*columns:(y+1)*columns], invert)
+	}
+	column := make([]complex128, rows)
+	for x := 0; x < columns; x++ {
+		for y := range column {
+			column[y] = data[y*columns+x]
+		}
+		fft(column, invert)
+		for y := range column {
+			data[y*columns+x] = column[y]
+		}
+	}
+}
+
+// fft is an in-place radix-2 fast Fourier transform; len(a) must be a power
+// of two
+func fft(a []complex128, invert bool) {
+	n := len(a)
+	if n <= 1 {
+		return
+	}
+
+	shift := 64 - bits.TrailingZeros(uint(n))
+	for i := range a {
+		if j := int(bits.Reverse64(uint64(i)) >> shift); i < j {
+			a[i], a[j] = a[j], a[i]
+		}
+	}
+
+	for size := 2; size <= n; size <<= 1 {
+		angle := 2 * math.Pi / float64(size)
+		if !invert {
+			angle = -angle
+		}
+		step := cmplx.Rect(1, angle)
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				even, odd := a[start+k], a[start+k+size/2]*w
+				a[start+k], a[start+k+size/2] = even+odd, even-odd
+				w *= step
+			}
+		}
+	}
+}
+
+// nextPowerOfTwo returns the smallest power of two at least n
+func nextPowerOfTwo(n int) int {
+	if n <= 1 {
+		return 1
+	}
+	return 1 << bits.Len(uint(n-1))
+}
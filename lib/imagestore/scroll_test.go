@@ -0,0 +1,142 @@
+package imagestore
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+// scrollingPage returns a tall noisy page and a function cutting a
+// viewport of the given height out of it, scrolled down by some rows
+func scrollingPage(width, height, viewport int) func(scroll int) *image.RGBA {
+	rng := rand.New(rand.NewSource(1))
+	page := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			page.Set(x, y, color.RGBA{uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256)), 255})
+		}
+	}
+	return func(scroll int) *image.RGBA {
+		view := image.NewRGBA(image.Rect(0, 0, width, viewport))
+		for y := 0; y < viewport; y++ {
+			copy(view.Pix[y*view.Stride:(y+1)*view.Stride], page.Pix[(y+scroll)*page.Stride:])
+		}
+		return view
+	}
+}
+
+func TestScrollShift(t *testing.T) {
+	view := scrollingPage(64, 600, 300)
+	top := newScrollReference(view(0), color.RGBA{})
+
+	for _, scroll := range []int{0, 1, 7, 37, 150} {
+		if got := scrollShift(top, newScrollReference(view(scroll), color.RGBA{})); got != scroll {
+			t.Errorf("expected a shift of %d, got %d", scroll, got)
+		}
+	}
+	// Scrolling back up
+	if got := scrollShift(newScrollReference(view(100), color.RGBA{}), top); got != -100 {
+		t.Errorf("expected a shift of -100, got %d", got)
+	}
+}
+
+func TestScrollAlignment(t *testing.T) {
+	config := DefaultConfig()
+	config.DatabasePath = filepath.Join(t.TempDir(), "test.db")
+	config.TileSize = 16
+	config.AlignScroll = true
+
+	store, err := NewPebbleImageStore(config)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	view := scrollingPage(64, 300, 128)
+	first, _ := encodeImageToPNG(view(0))
+	scrolled, _ := encodeImageToPNG(view(37))
+	if err := store.StoreImage("app/1", first); err != nil {
+		t.Fatalf("failed to store: %v", err)
+	}
+	before := store.GetStorageStats().UniqueTiles
+
+	if err := store.StoreImage("app/2", scrolled); err != nil {
+		t.Fatalf("failed to store: %v", err)
+	}
+	manifest, _ := store.GetManifest("app/2")
+	if manifest.GridOffsetY != 37%16 {
+		t.Fatalf("expected a grid offset of %d, got %d", 37%16, manifest.GridOffsetY)
+	}
+	// The 91 rows the two screenshots share cover five whole tile rows
+	if added := store.GetStorageStats().UniqueTiles - before; added > 4*4 {
+		t.Errorf("expected the shared rows to reuse tiles, got %d new tiles", added)
+	}
+
+	// The offset grid reconstructs the upload exactly
+	data, err := store.RetrieveImage("app/2")
+	if err != nil {
+		t.Fatalf("failed to retrieve: %v", err)
+	}
+	img, _ := decodeImageFromBytes(data)
+	if !bytes.Equal(toRGBA(img).Pix, view(37).Pix) {
+		t.Error("expected the scrolled screenshot back unchanged")
+	}
+
+	// Patches work on the offset grid too
+	patch := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for i := 3; i < len(patch.Pix); i += 4 {
+		patch.Pix[i] = 255
+	}
+	patchData, _ := encodeImageToPNG(patch)
+	if _, err := store.PatchImage("app/2", patchData, 10, 2); err != nil {
+		t.Fatalf("failed to patch: %v", err)
+	}
+	data, _ = store.RetrieveImage("app/2")
+	img, _ = decodeImageFromBytes(data)
+	want := view(37)
+	for y := 2; y < 6; y++ {
+		for x := 10; x < 14; x++ {
+			want.Set(x, y, color.RGBA{A: 255})
+		}
+	}
+	if !bytes.Equal(toRGBA(img).Pix, want.Pix) {
+		t.Error("expected the patch applied at (10, 2)")
+	}
+
+	// Compose reuses the tiles of a region lined up with the offset grid
+	result, err := store.ComposeImage("app/crop", 64, 64, []ComposeRegion{{Source: "app/2", X: 0, Y: 11, Width: 64, Height: 64}}, StoreOptions{})
+	if err != nil {
+		t.Fatalf("failed to compose: %v", err)
+	}
+	if result.TilesReused != 16 {
+		t.Errorf("expected all 16 tiles reused, got %+v", result)
+	}
+	data, _ = store.RetrieveImage("app/crop")
+	img, _ = decodeImageFromBytes(data)
+	if !bytes.Equal(toRGBA(img).Pix, toRGBA(want.SubImage(image.Rect(0, 11, 64, 75))).Pix) {
+		t.Error("expected the composed crop to match the source")
+	}
+
+	// A different width starts over at offset 0
+	narrow, _ := encodeImageToPNG(createTestImage(8, 8))
+	if err := store.StoreImage("app/3", narrow); err != nil {
+		t.Fatalf("failed to store: %v", err)
+	}
+	if manifest, _ := store.GetManifest("app/3"); manifest.GridOffsetY != 0 {
+		t.Errorf("expected no offset for an unrelated image, got %d", manifest.GridOffsetY)
+	}
+}
+
+// toRGBA copies img into an RGBA image at the origin
+func toRGBA(img image.Image) *image.RGBA {
+	out := image.NewRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+	for y := 0; y < out.Rect.Dy(); y++ {
+		for x := 0; x < out.Rect.Dx(); x++ {
+			out.Set(x, y, img.At(img.Bounds().Min.X+x, img.Bounds().Min.Y+y))
+		}
+	}
+	return out
+}
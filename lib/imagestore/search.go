@@ -0,0 +1,171 @@
+package imagestore
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// searchIndexKey builds the inverted index key search:<token>\x00<imageID>
+func searchIndexKey(token, imageID string) []byte {
+	return makeKey(searchBucket, token+"\x00"+imageID)
+}
+
+// tokenize lowercases text and splits it into alphanumeric tokens
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// searchTokens returns the distinct tokens of an image's ID and metadata
+// values
+func searchTokens(storedImage *StoredImage) []string {
+	seen := make(map[string]bool)
+	var tokens []string
+
+	add := func(text string) {
+		for _, token := range tokenize(text) {
+			if !seen[token] {
+				seen[token] = true
+				tokens = append(tokens, token)
+			}
+		}
+	}
+
+	add(storedImage.ID)
+	for _, value := range storedImage.Metadata {
+		add(value)
+	}
+
+	return tokens
+}
+
+// indexSearchTokens adds search index entries for an image to a batch
+func indexSearchTokens(batch *pebble.Batch, storedImage *StoredImage) error {
+	for _, token := range searchTokens(storedImage) {
+		if err := batch.Set(searchIndexKey(token, storedImage.ID), nil, pebble.Sync); err != nil {
+			return fmt.Errorf("failed to index search token %s: %w", token, err)
+		}
+	}
+	return nil
+}
+
+// unindexSearchTokens removes search index entries for an image from a batch
+func unindexSearchTokens(batch *pebble.Batch, storedImage *StoredImage) error {
+	for _, token := range searchTokens(storedImage) {
+		if err := batch.Delete(searchIndexKey(token, storedImage.ID), pebble.Sync); err != nil {
+			return fmt.Errorf("failed to unindex search token %s: %w", token, err)
+		}
+	}
+	return nil
+}
+
+// Search returns the IDs of images whose ID or metadata values contain every
+// token of the query. Query tokens match indexed tokens by prefix, so partial
+// commit hashes and words are found.
+func (s *PebbleImageStore) Search(query string) ([]string, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+
+	queryTokens := tokenize(query)
+	if len(queryTokens) == 0 {
+		return nil, fmt.Errorf("invalid query: no searchable terms")
+	}
+
+	var matches map[string]bool
+	for _, queryToken := range queryTokens {
+		tokenMatches, err := s.searchToken(queryToken)
+		if err != nil {
+			return nil, err
+		}
+
+		if matches == nil {
+			matches = tokenMatches
+			continue
+		}
+		for id := range matches {
+			if !tokenMatches[id] {
+				delete(matches, id)
+			}
+		}
+	}
+
+	imageIDs := make([]string, 0, len(matches))
+	for id := range matches {
+		imageIDs = append(imageIDs, id)
+	}
+	sort.Strings(imageIDs)
+
+	return imageIDs, nil
+}
+
+// searchToken returns the images with an indexed token starting with prefix
+func (s *PebbleImageStore) searchToken(prefix string) (map[string]bool, error) {
+	matches := make(map[string]bool)
+
+	lower := makeKey(searchBucket, prefix)
+	iter, err := s.db.NewIter(&pebble.IterOptions{
+		LowerBound: lower,
+		UpperBound: prefixUpperBound(lower),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	for iter.First(); iter.Valid(); iter.Next() {
+		entry := string(iter.Key()[len(makePrefixKey(searchBucket)):])
+		if _, id, ok := strings.Cut(entry, "\x00"); ok {
+			matches[id] = true
+		}
+	}
+
+	return matches, iter.Error()
+}
+
+// RebuildSearchIndex reindexes every live image, covering images stored
+// before the search index existed and dropping any stale entries
+func (s *PebbleImageStore) RebuildSearchIndex() error {
+	if err := s.enter(); err != nil {
+		return err
+	}
+	defer s.leave()
+
+	prefix := makePrefixKey(imagesBucket)
+	iter, err := s.db.NewIter(&pebble.IterOptions{
+		LowerBound: prefix,
+		UpperBound: prefixUpperBound(prefix),
+	})
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+
+	batch := s.db.NewBatch()
+	defer batch.Close()
+
+	if err := deleteBucket(batch, searchBucket); err != nil {
+		return err
+	}
+
+	for iter.First(); iter.Valid(); iter.Next() {
+		var storedImage StoredImage
+		if err := decodeManifest(iter.Value(), &storedImage); err != nil {
+			return fmt.Errorf("failed to unmarshal image: %w", err)
+		}
+		if err := indexSearchTokens(batch, &storedImage); err != nil {
+			return err
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return err
+	}
+
+	return batch.Commit(s.writeOpts)
+}
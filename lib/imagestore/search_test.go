@@ -0,0 +1,94 @@
+package imagestore
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tokens := tokenize("https://Example.com/login?step=2")
+	expected := []string{"https", "example", "com", "login", "step", "2"}
+
+	if !reflect.DeepEqual(tokens, expected) {
+		t.Errorf("expected tokens %v, got %v", expected, tokens)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	store := newTagsTestStore(t, "login-page", "checkout-page", "home")
+
+	if err := store.SetMetadata("login-page", map[string]string{
+		"url":    "https://example.com/login",
+		"commit": "3f9a2c1d",
+	}); err != nil {
+		t.Fatalf("failed to set metadata: %v", err)
+	}
+	if err := store.SetMetadata("home", map[string]string{
+		"url": "https://example.com/",
+	}); err != nil {
+		t.Fatalf("failed to set metadata: %v", err)
+	}
+
+	tests := []struct {
+		query    string
+		expected []string
+	}{
+		{"example.com", []string{"home", "login-page"}},
+		{"3f9a", []string{"login-page"}},
+		{"page", []string{"checkout-page", "login-page"}},
+		{"example login", []string{"login-page"}},
+		{"missing", []string{}},
+	}
+
+	for _, tt := range tests {
+		results, err := store.Search(tt.query)
+		if err != nil {
+			t.Fatalf("search %q failed: %v", tt.query, err)
+		}
+		if !reflect.DeepEqual(results, tt.expected) {
+			t.Errorf("search %q: expected %v, got %v", tt.query, tt.expected, results)
+		}
+	}
+
+	if _, err := store.Search("  ?? "); err == nil {
+		t.Error("expected error for query without searchable terms")
+	}
+}
+
+func TestSearchFollowsImageLifecycle(t *testing.T) {
+	store := newTagsTestStore(t, "nightly-run")
+
+	if err := store.SetMetadata("nightly-run", map[string]string{"suite": "checkout"}); err != nil {
+		t.Fatalf("failed to set metadata: %v", err)
+	}
+
+	assertSearch := func(query string, expected []string) {
+		t.Helper()
+		results, err := store.Search(query)
+		if err != nil {
+			t.Fatalf("search %q failed: %v", query, err)
+		}
+		if !reflect.DeepEqual(results, expected) {
+			t.Errorf("search %q: expected %v, got %v", query, expected, results)
+		}
+	}
+
+	assertSearch("checkout", []string{"nightly-run"})
+
+	// Replacing a metadata value drops its old tokens
+	if err := store.SetMetadata("nightly-run", map[string]string{"suite": "login"}); err != nil {
+		t.Fatalf("failed to set metadata: %v", err)
+	}
+	assertSearch("checkout", []string{})
+	assertSearch("login", []string{"nightly-run"})
+
+	if err := store.DeleteImage("nightly-run"); err != nil {
+		t.Fatalf("failed to delete image: %v", err)
+	}
+	assertSearch("nightly", []string{})
+
+	if err := store.UndeleteImage("nightly-run"); err != nil {
+		t.Fatalf("failed to undelete image: %v", err)
+	}
+	assertSearch("nightly", []string{"nightly-run"})
+}
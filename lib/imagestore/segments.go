@@ -0,0 +1,249 @@
+package imagestore
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+
+	"github.com/DataDog/zstd"
+)
+
+// manifestSegmentTiles is roughly how many tile references each segment of
+// a large manifest holds; segments are rounded to whole tile rows. Images
+// with more tiles than this get segmented manifests, which are encoded and
+// read a segment at a time instead of as one JSON document.
+const manifestSegmentTiles = 4096
+
+// manifestSegmentedMagic is the first byte of a segmented manifest. Plain
+// JSON manifests start with '{' and compressed ones with zstdFrameMagic.
+const manifestSegmentedMagic = 'S'
+
+// manifestSegment is a run of whole tile rows of a segmented manifest. A
+// segmented manifest is manifestSegmentedMagic followed by length-prefixed
+// zstd frames: first a storedManifest without tiles, then one
+// manifestSegment per SegmentRows tile rows.
+type manifestSegment struct {
+	Tiles        []TileID
+	StorageTypes []StorageType   `json:",omitempty"`
+	Transforms   []TileTransform `json:",omitempty"`
+}
+
+// isSegmentedManifest reports whether a manifest was written by
+// manifestWriter
+func isSegmentedManifest(data []byte) bool {
+	return len(data) > 0 && data[0] == manifestSegmentedMagic
+}
+
+// manifestWriter encodes a segmented manifest from tile references added
+// in row-major order, compressing each segment once its rows are complete
+type manifestWriter struct {
+	buf      []byte
+	columns  int
+	perFrame int // Tile references per segment
+	pending  manifestSegment
+}
+
+// newManifestWriter starts a segmented manifest for an image of columns
+// by rows tiles, writing its header
+func newManifestWriter(storedImage *StoredImage, columns, rows int) (*manifestWriter, error) {
+	segmentRows := max(1, manifestSegmentTiles/columns)
+	header := storedManifest{StoredImage: *storedImage, Columns: columns, Rows: rows, SegmentRows: segmentRows}
+	header.StoredImage.TileRefs = nil
+
+	w := &manifestWriter{buf: []byte{manifestSegmentedMagic}, columns: columns, perFrame: columns * segmentRows}
+	if err := w.appendFrame(&header); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// add appends the next tile reference
+func (w *manifestWriter) add(tileRef TileRef) error {
+	n := len(w.pending.Tiles)
+	w.pending.Tiles = append(w.pending.Tiles, tileRef.TileID)
+	if tileRef.StorageType != 0 && w.pending.StorageTypes == nil {
+		w.pending.StorageTypes = make([]StorageType, n, w.perFrame)
+	}
+	if w.pending.StorageTypes != nil {
+		w.pending.StorageTypes = append(w.pending.StorageTypes, tileRef.StorageType)
+	}
+	if tileRef.Transform != 0 && w.pending.Transforms == nil {
+		w.pending.Transforms = make([]TileTransform, n, w.perFrame)
+	}
+	if w.pending.Transforms != nil {
+		w.pending.Transforms = append(w.pending.Transforms, tileRef.Transform)
+	}
+
+	if len(w.pending.Tiles) == w.perFrame {
+		return w.flush()
+	}
+	return nil
+}
+
+// flush writes the pending segment
+func (w *manifestWriter) flush() error {
+	if len(w.pending.Tiles) == 0 {
+		return nil
+	}
+	if err := w.appendFrame(&w.pending); err != nil {
+		return err
+	}
+	w.pending = manifestSegment{Tiles: w.pending.Tiles[:0]}
+	return nil
+}
+
+// finish writes the last segment and returns the manifest
+func (w *manifestWriter) finish() ([]byte, error) {
+	if err := w.flush(); err != nil {
+		return nil, err
+	}
+	return w.buf, nil
+}
+
+// appendFrame appends v as a length-prefixed zstd frame of JSON
+func (w *manifestWriter) appendFrame(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	compressed, err := zstd.Compress(nil, data)
+	if err != nil {
+		return err
+	}
+	w.buf = binary.AppendUvarint(w.buf, uint64(len(compressed)))
+	w.buf = append(w.buf, compressed...)
+	return nil
+}
+
+// encodeSegmentedManifest encodes an image whose tile references are in
+// row-major order over columns tiles per row
+func encodeSegmentedManifest(storedImage *StoredImage, columns int) ([]byte, error) {
+	w, err := newManifestWriter(storedImage, columns, len(storedImage.TileRefs)/columns)
+	if err != nil {
+		return nil, err
+	}
+	for _, tileRef := range storedImage.TileRefs {
+		if err := w.add(tileRef); err != nil {
+			return nil, err
+		}
+	}
+	return w.finish()
+}
+
+// nextManifestFrame decompresses and parses the first length-prefixed frame
+// of data into v, returning the rest
+func nextManifestFrame(data []byte, v any) ([]byte, error) {
+	length, n := binary.Uvarint(data)
+	if n <= 0 || length > uint64(len(data)-n) {
+		return nil, fmt.Errorf("invalid manifest: truncated segment")
+	}
+	frame := data[n : n+int(length)]
+	decompressed, err := zstd.Decompress(nil, frame)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress manifest: %w", err)
+	}
+	if err := json.Unmarshal(decompressed, v); err != nil {
+		return nil, err
+	}
+	return data[n+int(length):], nil
+}
+
+// decodeManifestHeader parses the header of a segmented manifest, returning
+// the rest of the data
+func decodeManifestHeader(data []byte) (*storedManifest, []byte, error) {
+	var header storedManifest
+	rest, err := nextManifestFrame(data[1:], &header)
+	if err != nil {
+		return nil, nil, err
+	}
+	if header.Columns <= 0 || header.Rows <= 0 || header.SegmentRows <= 0 {
+		return nil, nil, fmt.Errorf("invalid manifest: %dx%d tiles in segments of %d rows", header.Columns, header.Rows, header.SegmentRows)
+	}
+	header.StoredImage.TileRefs = nil
+	return &header, rest, nil
+}
+
+// forEachManifestSegment decodes a manifest and calls fn with the tile
+// references of each segment in order, so a segmented manifest is never
+// held whole. Other manifests are passed in a single call.
+func forEachManifestSegment(data []byte, fn func(storedImage *StoredImage, tileRefs []TileRef) error) error {
+	if !isSegmentedManifest(data) {
+		var storedImage StoredImage
+		if err := decodeManifest(data, &storedImage); err != nil {
+			return err
+		}
+		return fn(&storedImage, storedImage.TileRefs)
+	}
+
+	header, rest, err := decodeManifestHeader(data)
+	if err != nil {
+		return err
+	}
+	total := header.Columns * header.Rows
+	next := 0
+	for len(rest) > 0 {
+		var segment manifestSegment
+		if rest, err = nextManifestFrame(rest, &segment); err != nil {
+			return err
+		}
+		tileRefs, err := segment.tileRefs(next, header.Columns)
+		if err != nil {
+			return err
+		}
+		if next += len(tileRefs); next > total {
+			break
+		}
+		if err := fn(&header.StoredImage, tileRefs); err != nil {
+			return err
+		}
+	}
+	if next != total {
+		return fmt.Errorf("invalid manifest: %d tiles for %dx%d", next, header.Columns, header.Rows)
+	}
+	return nil
+}
+
+// tileRefs expands a segment whose first tile is the first'th of the image
+func (segment *manifestSegment) tileRefs(first, columns int) ([]TileRef, error) {
+	if len(segment.Tiles) == 0 || len(segment.Tiles)%columns != 0 {
+		return nil, fmt.Errorf("invalid manifest: segment of %d tiles in rows of %d", len(segment.Tiles), columns)
+	}
+	if segment.StorageTypes != nil && len(segment.StorageTypes) != len(segment.Tiles) {
+		return nil, fmt.Errorf("invalid manifest: %d storage types for %d tiles", len(segment.StorageTypes), len(segment.Tiles))
+	}
+	if segment.Transforms != nil && len(segment.Transforms) != len(segment.Tiles) {
+		return nil, fmt.Errorf("invalid manifest: %d transforms for %d tiles", len(segment.Transforms), len(segment.Tiles))
+	}
+
+	tileRefs := make([]TileRef, len(segment.Tiles))
+	for i, tileID := range segment.Tiles {
+		index := first + i
+		tileRef := TileRef{X: index % columns, Y: index / columns, TileID: tileID}
+		if segment.StorageTypes != nil {
+			tileRef.StorageType = segment.StorageTypes[i]
+		}
+		if segment.Transforms != nil {
+			tileRef.Transform = segment.Transforms[i]
+		}
+		tileRefs[i] = tileRef
+	}
+	return tileRefs, nil
+}
+
+// decodeSegmentedManifest parses a whole segmented manifest
+func decodeSegmentedManifest(data []byte, storedImage *StoredImage) error {
+	var tileRefs []TileRef
+	err := forEachManifestSegment(data, func(header *StoredImage, segment []TileRef) error {
+		if tileRefs == nil {
+			*storedImage = *header
+			tileRefs = make([]TileRef, 0, len(segment))
+		}
+		tileRefs = append(tileRefs, segment...)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	storedImage.TileRefs = tileRefs
+	return nil
+}
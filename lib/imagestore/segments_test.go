@@ -0,0 +1,62 @@
+package imagestore
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSegmentedManifest(t *testing.T) {
+	storedImage := &StoredImage{ID: "huge", Width: 400, Height: 210, Metadata: map[string]string{"k": "v"}}
+	for y := 0; y < 105; y++ {
+		for x := 0; x < 100; x++ {
+			tileID := GenerateTileID(ComputeTileHash([]byte(fmt.Sprint(x, y))))
+			storedImage.TileRefs = append(storedImage.TileRefs, TileRef{X: x, Y: y, TileID: tileID})
+		}
+	}
+	storedImage.TileRefs[4500].StorageType = StorageDuplicate
+	storedImage.TileRefs[10].Transform = transformInvert
+
+	encoded, err := encodeManifest(storedImage)
+	if err != nil {
+		t.Fatalf("failed to encode manifest: %v", err)
+	}
+	if !isSegmentedManifest(encoded) {
+		t.Fatal("expected a segmented manifest")
+	}
+
+	var decoded StoredImage
+	if err := decodeManifest(encoded, &decoded); err != nil {
+		t.Fatalf("failed to decode manifest: %v", err)
+	}
+	if ManifestDigest(&decoded) != ManifestDigest(storedImage) || decoded.Metadata["k"] != "v" {
+		t.Error("manifest changed after decoding")
+	}
+	for i, tileRef := range decoded.TileRefs {
+		if tileRef != storedImage.TileRefs[i] {
+			t.Fatalf("tile ref %d is %+v, expected %+v", i, tileRef, storedImage.TileRefs[i])
+		}
+	}
+
+	// Segments hold whole rows, 40 at a time for 100 columns
+	var sizes []int
+	err = forEachManifestSegment(encoded, func(header *StoredImage, tileRefs []TileRef) error {
+		if header.ID != "huge" || len(header.TileRefs) != 0 {
+			t.Errorf("expected the header without tiles, got %s with %d", header.ID, len(header.TileRefs))
+		}
+		sizes = append(sizes, len(tileRefs))
+		return nil
+	})
+	if err != nil || fmt.Sprint(sizes) != "[4000 4000 2500]" {
+		t.Errorf("unexpected segments %v (%v)", sizes, err)
+	}
+
+	if err := decodeManifest(encoded[:len(encoded)-10], &decoded); err == nil {
+		t.Error("expected an error for a truncated manifest")
+	}
+
+	// Small images keep a single frame
+	storedImage.TileRefs = storedImage.TileRefs[:manifestSegmentTiles]
+	if encoded, err := encodeManifest(storedImage); err != nil || isSegmentedManifest(encoded) {
+		t.Errorf("expected a single-frame manifest (%v)", err)
+	}
+}
@@ -0,0 +1,155 @@
+package imagestore
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// statsBucket holds one StatsSnapshot per day, keyed by its date
+var statsBucket = []byte("stats")
+
+// statsDateLayout formats the date a snapshot is keyed by; dates sort in
+// time order
+const statsDateLayout = "2006-01-02"
+
+// StatsSnapshot is the part of StorageStats kept for each day, enough to
+// follow the store's growth and deduplication over time
+type StatsSnapshot struct {
+	Date                string    `json:"date"` // UTC day, YYYY-MM-DD
+	Time                time.Time `json:"time"` // When the stats were gathered
+	TotalImages         int       `json:"total_images"`
+	TotalTiles          int       `json:"total_tiles"`
+	UniqueTiles         int       `json:"unique_tiles"`
+	DeduplicatedPercent float64   `json:"deduplicated_percent"`
+	StorageBytes        int64     `json:"storage_bytes"`
+	OriginalBytes       int64     `json:"original_bytes"`
+	CompressionRatio    float64   `json:"compression_ratio"`
+	DiskBytes           int64     `json:"disk_bytes"`
+}
+
+// StatsTrend sums up the change between the first and last snapshots of a
+// history
+type StatsTrend struct {
+	From                      string  `json:"from"`
+	To                        string  `json:"to"`
+	Days                      int     `json:"days"`
+	ImagesAdded               int     `json:"images_added"`
+	StorageBytesAdded         int64   `json:"storage_bytes_added"`
+	StorageBytesPerDay        float64 `json:"storage_bytes_per_day"`
+	DeduplicatedPercentChange float64 `json:"deduplicated_percent_change"`
+	CompressionRatioChange    float64 `json:"compression_ratio_change"`
+}
+
+// RecordStatsSnapshot gathers the current stats and keeps them as today's
+// snapshot, replacing one taken earlier the same day
+func (s *PebbleImageStore) RecordStatsSnapshot() (*StatsSnapshot, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+
+	now := time.Now().UTC()
+	stats := s.GetStorageStats()
+	snapshot := &StatsSnapshot{
+		Date:                now.Format(statsDateLayout),
+		Time:                now,
+		TotalImages:         stats.TotalImages,
+		TotalTiles:          stats.TotalTiles,
+		UniqueTiles:         stats.UniqueTiles,
+		DeduplicatedPercent: stats.DeduplicatedPercent,
+		StorageBytes:        stats.StorageBytes,
+		OriginalBytes:       stats.OriginalBytes,
+		CompressionRatio:    stats.CompressionRatio,
+		DiskBytes:           stats.DiskBytes,
+	}
+
+	value, err := json.Marshal(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.db.Set(makeKey(statsBucket, snapshot.Date), value, s.writeOpts); err != nil {
+		return nil, fmt.Errorf("failed to record stats snapshot: %w", err)
+	}
+	return snapshot, nil
+}
+
+// recordDailyStats takes today's snapshot unless there already is one, and
+// drops snapshots older than Config.StatsHistoryDays
+func (s *PebbleImageStore) recordDailyStats() error {
+	today := time.Now().UTC()
+	if _, closer, err := s.db.Get(makeKey(statsBucket, today.Format(statsDateLayout))); err == nil {
+		closer.Close()
+	} else if _, err := s.RecordStatsSnapshot(); err != nil {
+		return err
+	}
+
+	if s.config.StatsHistoryDays <= 0 {
+		return nil
+	}
+	cutoff := today.AddDate(0, 0, -s.config.StatsHistoryDays).Format(statsDateLayout)
+	return s.db.DeleteRange(makePrefixKey(statsBucket), makeKey(statsBucket, cutoff), s.writeOpts)
+}
+
+// StatsHistory returns the daily snapshots taken between from and to,
+// inclusive, oldest first. Zero times leave that end open.
+func (s *PebbleImageStore) StatsHistory(from, to time.Time) ([]StatsSnapshot, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+
+	prefix := makePrefixKey(statsBucket)
+	options := &pebble.IterOptions{LowerBound: prefix, UpperBound: prefixUpperBound(prefix)}
+	if !from.IsZero() {
+		options.LowerBound = makeKey(statsBucket, from.UTC().Format(statsDateLayout))
+	}
+	if !to.IsZero() {
+		options.UpperBound = prefixUpperBound(makeKey(statsBucket, to.UTC().Format(statsDateLayout)))
+	}
+
+	iter, err := s.db.NewIter(options)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	snapshots := []StatsSnapshot{}
+	for iter.First(); iter.Valid(); iter.Next() {
+		var snapshot StatsSnapshot
+		if err := json.Unmarshal(iter.Value(), &snapshot); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal stats snapshot: %w", err)
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	return snapshots, iter.Error()
+}
+
+// SummarizeStats returns the trend over a history as returned by
+// StatsHistory, or nil when it holds fewer than two snapshots
+func SummarizeStats(snapshots []StatsSnapshot) *StatsTrend {
+	if len(snapshots) < 2 {
+		return nil
+	}
+	first, last := snapshots[0], snapshots[len(snapshots)-1]
+
+	trend := &StatsTrend{
+		From:                      first.Date,
+		To:                        last.Date,
+		ImagesAdded:               last.TotalImages - first.TotalImages,
+		StorageBytesAdded:         last.StorageBytes - first.StorageBytes,
+		DeduplicatedPercentChange: last.DeduplicatedPercent - first.DeduplicatedPercent,
+		CompressionRatioChange:    last.CompressionRatio - first.CompressionRatio,
+	}
+	fromDate, errFrom := time.Parse(statsDateLayout, first.Date)
+	toDate, errTo := time.Parse(statsDateLayout, last.Date)
+	if errFrom == nil && errTo == nil {
+		trend.Days = int(toDate.Sub(fromDate).Hours() / 24)
+	}
+	if trend.Days > 0 {
+		trend.StorageBytesPerDay = float64(trend.StorageBytesAdded) / float64(trend.Days)
+	}
+	return trend
+}
@@ -0,0 +1,59 @@
+package imagestore
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestStatsHistory(t *testing.T) {
+	store := newTagsTestStore(t, "a", "b")
+
+	snapshot, err := store.RecordStatsSnapshot()
+	if err != nil {
+		t.Fatalf("failed to record snapshot: %v", err)
+	}
+	if snapshot.TotalImages != 2 || snapshot.UniqueTiles == 0 {
+		t.Errorf("expected the current stats, got %+v", snapshot)
+	}
+
+	// Seed the days before with smaller stores
+	today := time.Now().UTC()
+	for days := 1; days <= 10; days++ {
+		day := today.AddDate(0, 0, -days)
+		value, _ := json.Marshal(StatsSnapshot{
+			Date:         day.Format(statsDateLayout),
+			TotalImages:  2 - min(days, 2),
+			StorageBytes: snapshot.StorageBytes - int64(100*days),
+		})
+		if err := store.db.Set(makeKey(statsBucket, day.Format(statsDateLayout)), value, nil); err != nil {
+			t.Fatalf("failed to seed snapshot: %v", err)
+		}
+	}
+
+	history, err := store.StatsHistory(today.AddDate(0, 0, -4), time.Time{})
+	if err != nil {
+		t.Fatalf("failed to read history: %v", err)
+	}
+	if len(history) != 5 || history[4].Date != snapshot.Date {
+		t.Fatalf("expected the last 5 days ending today, got %+v", history)
+	}
+
+	trend := SummarizeStats(history)
+	if trend.Days != 4 || trend.ImagesAdded != 2 || trend.StorageBytesAdded != 400 || trend.StorageBytesPerDay != 100 {
+		t.Errorf("unexpected trend %+v", trend)
+	}
+	if SummarizeStats(history[:1]) != nil {
+		t.Error("expected no trend from a single snapshot")
+	}
+
+	// The daily job keeps today's snapshot and prunes old ones
+	store.config.StatsHistoryDays = 3
+	if err := store.recordDailyStats(); err != nil {
+		t.Fatalf("failed to run the daily job: %v", err)
+	}
+	history, _ = store.StatsHistory(time.Time{}, time.Time{})
+	if len(history) != 4 || !history[3].Time.Equal(snapshot.Time) {
+		t.Errorf("expected 3 days of history and today's snapshot kept, got %+v", history)
+	}
+}
@@ -2,21 +2,36 @@ package imagestore
 
 import (
 	"bytes"
-	"encoding/json"
+	"context"
+	"encoding/hex"
 	"fmt"
+	"hash/fnv"
 	"image"
 	"image/color"
-	"io"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 
-	"github.com/DataDog/zstd"
 	"github.com/cockroachdb/pebble"
+	"golang.org/x/sync/singleflight"
 )
 
 var (
-	tilesBucket  = []byte("tiles")
-	imagesBucket = []byte("images")
+	tilesBucket       = []byte("tiles")
+	imagesBucket      = []byte("images")
+	trashBucket       = []byte("trash")
+	tagsBucket        = []byte("tags")
+	searchBucket      = []byte("search")
+	expiryBucket      = []byte("expiry")
+	importBucket      = []byte("imports")
+	metaBucket        = []byte("meta")
+	accessBucket      = []byte("access")
+	annotationsBucket = []byte("annotations")
+	animationsBucket  = []byte("animations")
 )
 
 // makeKey safely constructs a key with bucket prefix and suffix
@@ -36,30 +51,218 @@ func makePrefixKey(bucket []byte) []byte {
 	return key
 }
 
+// prefixUpperBound returns the smallest key greater than every key starting
+// with prefix, for use as an exclusive iterator or range-delete bound
+func prefixUpperBound(prefix []byte) []byte {
+	upper := append([]byte(nil), prefix...)
+	for i := len(upper) - 1; i >= 0; i-- {
+		if upper[i] < 0xFF {
+			upper[i]++
+			return upper[:i+1]
+		}
+	}
+	return nil
+}
+
+// deleteBucket adds a range deletion of every key in a bucket to a batch,
+// which costs a single tombstone regardless of how many keys it covers
+func deleteBucket(batch *pebble.Batch, bucket []byte) error {
+	prefix := makePrefixKey(bucket)
+	return batch.DeleteRange(prefix, prefixUpperBound(prefix), pebble.Sync)
+}
+
+// tileKey constructs the key of a tile. Tile IDs are hex SHA-256 digests and
+// are keyed by their 32 raw bytes, halving the size of every tile key.
+// Anything that isn't a valid tile ID is keyed as-is and so never matches a
+// stored tile.
+func tileKey(tileID TileID) []byte {
+	var hash TileHash
+	if len(tileID) != hex.EncodedLen(len(hash)) {
+		return makeKey(tilesBucket, string(tileID))
+	}
+	if _, err := hex.Decode(hash[:], []byte(tileID)); err != nil || GenerateTileID(hash) != tileID {
+		return makeKey(tilesBucket, string(tileID))
+	}
+	return makeKey(tilesBucket, string(hash[:]))
+}
+
+// tileIDFromKey returns the ID of the tile stored under a key
+func tileIDFromKey(key []byte) TileID {
+	suffix := key[len(tilesBucket)+1:]
+	if len(suffix) == len(TileHash{}) {
+		return GenerateTileID(TileHash(suffix))
+	}
+	return TileID(suffix)
+}
+
 // PebbleImageStore implements ImageStore using Pebble
 type PebbleImageStore struct {
-	db     *pebble.DB
-	config *Config
-	dict   []byte // Optional zstd dictionary
+	db               *pebble.DB
+	lock             *pebble.Lock // Held until the database is closed
+	config           *Config
+	dict             []byte // Optional zstd dictionary
+	level            int    // zstd level for interactive stores
+	compactionLevel  int    // zstd level for offline recompression
+	codecs           []TileCodec
+	codecsByID       map[byte]TileCodec
+	writeOpts        *pebble.WriteOptions // Sync behaviour of every commit, from Config.SyncPolicy
+	writes           *writeQueue          // Admits image writes; nil when unlimited
+	reconstructions  *semaphore           // Bounds concurrent reconstructions; nil when unlimited
+	decompressors    *semaphore           // Bounds concurrent tile decompressions; nil when unlimited
+	imageCache       *imageCache          // Recently retrieved images; nil when disabled
+	preloading       atomic.Bool          // Set while a background preload runs
+	retrievals       singleflight.Group   // Coalesces concurrent retrievals, see shareRetrieval
+	sharedRetrievals atomic.Int64         // Retrievals that shared another's reconstruction
+	latency          *latencyTracker      // Store and retrieval timings
+	scroll           *scrollTracker       // Last upload per namespace when Config.AlignScroll is set
+	coldStats        coldTierCounters
+	diskGuard        diskGuard // Caches the disk space check, see checkDiskSpace
+	backups          backupState
+	blobs            blobFiles        // Large tile encodings, see separateBlobs
+	maintenance      maintenanceState // Foreground writes and maintenance job progress, see pace
+	background       color.RGBA       // Fills edge-tile padding and transparent pixels, from Config.Background
+
+	stopJobs chan struct{}  // Closed to stop background jobs
+	jobs     sync.WaitGroup // Running background jobs
+	closing  closeState     // Calls in flight, see enter
+
+	clusterMu sync.RWMutex
+	clusters  *ClusterResult // Latest result of the clustering job
+
+	gcMu       sync.RWMutex                   // Held exclusively while unreferenced tiles are collected
+	quotaLocks [versionLockStripes]sync.Mutex // Serialize quota checks with the stores they admit, per namespace, see lockQuota
+	quotaUsage usageCache                     // Namespace usage for quota checks
+
+	versionLocks [versionLockStripes]sync.Mutex // Serialize commits per image ID, see lockVersion
+
+	changeMu     sync.Mutex    // Orders change feed commits, see commitChanges
+	changeSeq    uint64        // Sequence number of the newest change
+	changeNotify chan struct{} // Closed and replaced when changes are committed
 }
 
 // NewPebbleImageStore creates a new Pebble-backed image store
 func NewPebbleImageStore(config *Config) (*PebbleImageStore, error) {
-	// Ensure database directory exists
-	dbDir := filepath.Dir(config.DatabasePath)
-	if dbDir != "" && dbDir != "." {
-		if err := os.MkdirAll(dbDir, 0755); err != nil {
-			return nil, fmt.Errorf("failed to create database directory: %w", err)
+	store, err := openPebbleImageStore(config, &pebble.Options{ReadOnly: config.ReadOnly})
+	if err != nil {
+		return nil, err
+	}
+
+	// Migrations need write access, so a read-only store can only serve a
+	// database already in the current layout
+	if config.ReadOnly {
+		version, err := keyLayout(store.db)
+		if err == nil && version != keyLayoutVersion {
+			err = fmt.Errorf("database %s uses key layout %d, expected %d: open it read-write once to migrate it", config.DatabasePath, version, keyLayoutVersion)
+		}
+		if err != nil {
+			store.Close()
+			return nil, err
 		}
 	}
 
+	if config.NoBackgroundJobs {
+		return store, nil
+	}
+
+	if config.TrashRetention > 0 && config.TrashPurgeInterval > 0 && !config.ReadOnly {
+		store.startBackgroundJob("trash purge", config.TrashPurgeInterval, store.purgeExpiredTrash)
+	}
+
+	if config.ClusterInterval > 0 {
+		store.startBackgroundJob("clustering", config.ClusterInterval, store.refreshClusters)
+	}
+
+	if config.ColdStore != nil && config.ColdTierInterval > 0 && !config.ReadOnly {
+		store.startBackgroundJob("cold tier offload", config.ColdTierInterval, func() error {
+			_, err := store.OffloadColdTiles()
+			return err
+		})
+	}
+
+	if len(config.RetentionPolicies) > 0 && config.RetentionInterval > 0 && !config.ReadOnly {
+		store.startBackgroundJob("retention", config.RetentionInterval, func() error {
+			_, err := store.RunRetention(false)
+			return err
+		})
+	}
+
+	if len(config.Resources.Preload) > 0 {
+		store.startPreload()
+	}
+
+	if config.ExpirySweepInterval > 0 && !config.ReadOnly {
+		store.startBackgroundJob("expiry sweep", config.ExpirySweepInterval, func() error {
+			_, err := store.SweepExpired()
+			return err
+		})
+	}
+
+	if config.StatsSnapshotInterval > 0 && !config.ReadOnly {
+		store.startBackgroundJob("stats snapshot", config.StatsSnapshotInterval, store.recordDailyStats)
+	}
+
+	if config.BackupTarget != nil && config.BackupSchedule != "" && !config.ReadOnly {
+		store.startBackgroundJob("backup", backupCheckInterval, store.runScheduledBackup)
+	}
+
+	if config.BlobGCInterval > 0 && !config.ReadOnly {
+		store.startBackgroundJob("blob collection", config.BlobGCInterval, func() error {
+			_, err := store.CollectBlobs()
+			return err
+		})
+	}
+
+	return store, nil
+}
+
+// openPebbleImageStore opens the database and sets up codecs without
+// starting background jobs
+func openPebbleImageStore(config *Config, options *pebble.Options) (*PebbleImageStore, error) {
 	// Ensure tile dump directory exists if specified
 	if config.TileDumpDir != "" {
-		if err := os.MkdirAll(config.TileDumpDir, 0755); err != nil {
+		if err := os.MkdirAll(config.TileDumpDir, 0777); err != nil {
 			return nil, fmt.Errorf("failed to create tile dump directory: %w", err)
 		}
 	}
 
+	level, err := ParseCompressionLevel(config.CompressionLevel)
+	if err != nil {
+		return nil, err
+	}
+
+	compactionLevel, err := ParseCompressionLevel(config.CompactionLevel)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateConflictPolicy(config.OnConflict); err != nil {
+		return nil, err
+	}
+
+	if err := validateTransforms(config); err != nil {
+		return nil, err
+	}
+
+	if err := validateStrips(config); err != nil {
+		return nil, err
+	}
+
+	if err := validateTilePools(config.TilePools); err != nil {
+		return nil, err
+	}
+
+	var backupSchedule *cronSchedule
+	if config.BackupSchedule != "" {
+		if backupSchedule, err = parseCronSchedule(config.BackupSchedule); err != nil {
+			return nil, err
+		}
+	}
+
+	background, err := ParseBackground(config.Background)
+	if err != nil {
+		return nil, err
+	}
+
 	// Load zstd dictionary if specified
 	var dict []byte
 	if config.DictPath != "" {
@@ -70,205 +273,677 @@ func NewPebbleImageStore(config *Config) (*PebbleImageStore, error) {
 		dict = dictData
 	}
 
-	db, err := pebble.Open(config.DatabasePath, &pebble.Options{})
+	codecs, codecsByID, err := newTileCodecs(config.TileCodecs, config.TileSize, dict)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open database: %w", err)
+		return nil, err
 	}
 
-	store := &PebbleImageStore{
-		db:     db,
-		config: config,
-		dict:   dict,
+	writeOpts, err := applySyncPolicy(config, options)
+	if err != nil {
+		return nil, err
 	}
 
+	releaseCache, err := applyPebbleTuning(config.Pebble, options)
+	if err != nil {
+		return nil, err
+	}
+	defer releaseCache()
+
+	lock, err := lockDatabase(config.DatabasePath, options.ReadOnly, config.OpenTimeout)
+	if err != nil {
+		return nil, err
+	}
+	options.Lock = lock
+
+	db, err := pebble.Open(config.DatabasePath, options)
+	if err != nil {
+		lock.Close()
+		return nil, openError(config.DatabasePath, err)
+	}
+
+	if !options.ReadOnly {
+		if err := migrateKeyLayout(db); err != nil {
+			db.Close()
+			lock.Close()
+			return nil, fmt.Errorf("failed to migrate database: %w", err)
+		}
+	}
+
+	if err := checkTilePools(db, config.TilePools, options.ReadOnly); err != nil {
+		db.Close()
+		lock.Close()
+		return nil, err
+	}
+
+	store := &PebbleImageStore{
+		db:              db,
+		lock:            lock,
+		config:          config,
+		dict:            dict,
+		level:           level,
+		compactionLevel: compactionLevel,
+		codecs:          codecs,
+		codecsByID:      codecsByID,
+		writeOpts:       writeOpts,
+		writes:          newWriteQueue(config),
+		reconstructions: newSemaphore(config.Resources.MaxReconstructions),
+		decompressors:   newSemaphore(config.Resources.MaxDecompressors),
+		imageCache:      newImageCache(config.Resources.ImageCacheBytes),
+		latency:         newLatencyTracker(config.SlowOperationThreshold),
+		scroll:          newScrollTracker(config.AlignScroll),
+		background:      background,
+		stopJobs:        make(chan struct{}),
+	}
+	store.backups.schedule = backupSchedule
+	store.blobs.dir = filepath.Join(config.DatabasePath, blobDir)
+	store.blobs.noMmap = config.DisableBlobMmap
+	if err := store.loadChangeSeq(); err != nil {
+		db.Close()
+		lock.Close()
+		return nil, fmt.Errorf("failed to read change log: %w", err)
+	}
 	return store, nil
 }
 
+// versionLockStripes is the number of locks per-ID commits are spread over
+const versionLockStripes = 64
+
+// lockVersion serializes commits to one image ID, so a read-modify-write of
+// its manifest is not interleaved with another write to the same ID
+func (s *PebbleImageStore) lockVersion(id string) func() {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	mu := &s.versionLocks[h.Sum32()%versionLockStripes]
+	mu.Lock()
+	return mu.Unlock
+}
+
+// storePlan holds everything computed for an image before any writes happen
+type storePlan struct {
+	image        *StoredImage
+	previous     *StoredImage // Manifest being overwritten, if any
+	ifMatch      string       // Versions the commit may replace, from StoreOptions.IfMatch
+	onConflict   string       // Conflict policy checked at commit, set by commitUpload
+	newTiles     []plannedTile
+	dedupMatches int
+	source       image.Image // Decoded upload, kept to verify the write when Config.VerifyWrites is set
+	actor        string      // Who is storing the image, from WithActor
+}
+
+// plannedTile is a unique tile awaiting write, already compressed
+type plannedTile struct {
+	tile       Tile
+	compressed []byte
+}
+
 // StoreImage stores an image using tile-based deduplication
 func (s *PebbleImageStore) StoreImage(id string, imageData []byte) error {
-	dedupMatch := 0
-	directStore := 0
-	noBestMatch := 0
+	return s.StoreImageWithOptions(id, imageData, StoreOptions{})
+}
+
+// StoreImageWithOptions stores an image with per-upload options. An upload
+// to an existing ID follows Config.OnConflict.
+func (s *PebbleImageStore) StoreImageWithOptions(id string, imageData []byte, opts StoreOptions) error {
+	return s.StoreImageContext(context.Background(), id, imageData, opts)
+}
+
+// StoreImageContext is StoreImageWithOptions, abandoning the upload with
+// ctx's error if ctx is done before it commits. Nothing is written then.
+func (s *PebbleImageStore) StoreImageContext(ctx context.Context, id string, imageData []byte, opts StoreOptions) error {
+	if err := s.enter(); err != nil {
+		return err
+	}
+	defer s.leave()
+
+	start := time.Now()
+	plan, err := s.storeImage(ctx, id, imageData, opts)
+	if err != nil {
+		return err
+	}
+	s.latency.observe(OperationStore, id, time.Since(start), fmt.Sprintf("%s, %d new, %d deduplicated",
+		imageShape(plan.image), len(plan.newTiles), plan.dedupMatches))
+	return s.collectReplaced(plan)
+}
+
+// storeImage plans and commits an upload, returning the plan
+func (s *PebbleImageStore) storeImage(ctx context.Context, id string, imageData []byte, opts StoreOptions) (*storePlan, error) {
+	if err := s.acquireWriteContext(ctx); err != nil {
+		return nil, err
+	}
+	defer s.releaseWrite()
+
+	// Hold off garbage collection so tiles we dedupe against stay present
+	s.gcMu.RLock()
+	defer s.gcMu.RUnlock()
+
+	plan, err := s.planStore(ctx, id, imageData, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return plan, s.commitUpload(plan)
+}
 
+// commitPlan checks a plan against its namespace quota, If-Match
+// precondition and conflict policy and applies it. The caller must hold
+// gcMu for reading from planning through commit.
+func (s *PebbleImageStore) commitPlan(plan *storePlan) error {
+	id := plan.image.ID
+
+	unlock := s.lockVersion(id)
+	defer unlock()
+	if plan.ifMatch != "" {
+		if err := s.checkIfMatch(id, plan.ifMatch); err != nil {
+			return err
+		}
+	}
+
+	// The plan was made from a snapshot, and a tag or metadata update may
+	// have rewritten the image since. Index entries are removed for the
+	// version actually being replaced.
+	if err := planPrevious(plan, s.db); err != nil {
+		return err
+	}
+	if skip, err := resolveConflict(plan); skip || err != nil {
+		return err
+	}
+
+	quota := s.quotaFor(Namespace(id))
+	var newBytes int64
+	if quota.limited() {
+		unlockQuota := s.lockQuota(Namespace(id))
+		defer unlockQuota()
+
+		var err error
+		if newBytes, err = s.checkQuota(plan, quota); err != nil {
+			return err
+		}
+	}
+
+	fmt.Println("considering ", len(plan.image.TileRefs), "tiles for image", id)
+
+	err := s.applyStorePlan(plan)
+	if err != nil {
+		return err
+	}
+	if quota.limited() {
+		s.quotaUsage.add(plan, newBytes)
+	}
+
+	fmt.Println("Deduplication matches found:", plan.dedupMatches)
+	return nil
+}
+
+// planStore runs the read-only half of StoreImage: decoding, tiling, dedup
+// lookups against a snapshot and compression of new tiles
+func (s *PebbleImageStore) planStore(ctx context.Context, id string, imageData []byte, opts StoreOptions) (*storePlan, error) {
 	// Convert image data to image.Image
 	img, err := decodeImageFromBytes(imageData)
 	if err != nil {
-		return fmt.Errorf("failed to decode image: %w", err)
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	source := parseSourceInfo(imageData)
+	img, source = applyTransforms(img, source, s.transformsFor(Namespace(id)))
+
+	plan, err := s.planImage(ctx, id, img, opts)
+	if err != nil {
+		return nil, err
 	}
+	plan.image.OriginalBytes = int64(len(imageData)) // Store original PNG input size
+	plan.image.Source = source
+	return plan, nil
+}
 
-	// Extract tiles
-	tiles, tileRefs, err := ExtractTiles(img, s.config.TileSize)
+// planImage plans storing an already decoded image
+func (s *PebbleImageStore) planImage(ctx context.Context, id string, img image.Image, opts StoreOptions) (*storePlan, error) {
+	// Extract tiles or strips, lined up with the previous upload's when it
+	// scrolled
+	stripHeight := s.stripHeightFor(Namespace(id))
+	var gridOffsetY, tileSize int
+	var tiles []Tile
+	var tileRefs []TileRef
+	var err error
+	if stripHeight > 0 {
+		gridOffsetY = s.scroll.align(Namespace(id), img, stripHeight, s.background)
+		tiles, tileRefs, err = extractStrips(img, stripHeight, gridOffsetY, s.background)
+	} else {
+		size := s.config.TileSize
+		if s.config.AutoTileSize {
+			tileSize = chooseTileSize(img, s.background)
+			size = tileSize
+		}
+		gridOffsetY = s.scroll.align(Namespace(id), img, size, s.background)
+		tiles, tileRefs, err = extractTilesAt(img, size, gridOffsetY, s.background)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to extract tiles: %w", err)
+		return nil, fmt.Errorf("failed to extract tiles: %w", err)
 	}
 
 	bounds := img.Bounds()
-	storedImage := &StoredImage{
-		ID:            id,
-		Width:         bounds.Dx(),
-		Height:        bounds.Dy(),
-		TileRefs:      make([]TileRef, len(tileRefs)),
-		Metadata:      make(map[string]string),
-		OriginalBytes: int64(len(imageData)), // Store original PNG input size
+	plan := &storePlan{
+		image: &StoredImage{
+			ID:          id,
+			Width:       bounds.Dx(),
+			Height:      bounds.Dy(),
+			TileRefs:    make([]TileRef, len(tileRefs)),
+			Metadata:    make(map[string]string),
+			ExpiresAt:   opts.ExpiresAt,
+			StoredAt:    storedNow(),
+			Background:  formatBackground(s.background),
+			GridOffsetY: gridOffsetY,
+			StripHeight: stripHeight,
+			TileSize:    tileSize,
+		},
+		ifMatch: opts.IfMatch,
+		actor:   actorFrom(ctx),
 	}
 
-	// Use batch for atomic operations
-	batch := s.db.NewBatch()
-	defer batch.Close()
+	// Read against a consistent snapshot so lookups don't block writers
+	snapshot := s.db.NewSnapshot()
+	defer snapshot.Close()
+
+	if err := planPrevious(plan, snapshot); err != nil {
+		return nil, err
+	}
 
-	fmt.Println("considering ", len(tiles), "tiles for image", id)
+	byID := make(map[TileID]Tile, len(tiles))
+	for _, tile := range tiles {
+		byID[tile.ID] = tile
+	}
 
-	// Track tiles we've already processed in this batch for intra-image deduplication
+	if err := s.planTiles(ctx, plan, snapshot, tileRefs, byID); err != nil {
+		return nil, err
+	}
+
+	if s.config.VerifyWrites {
+		plan.source = img
+	}
+	return plan, nil
+}
+
+// planPrevious loads the manifest the plan will overwrite, if any
+func planPrevious(plan *storePlan, reader pebble.Reader) error {
+	plan.previous = nil
+	previousData, closer, err := reader.Get(makeKey(imagesBucket, plan.image.ID))
+	if err != nil {
+		return nil
+	}
+	defer closer.Close()
+
+	var previous StoredImage
+	if err := decodeManifest(previousData, &previous); err != nil {
+		return fmt.Errorf("failed to unmarshal existing image: %w", err)
+	}
+	plan.previous = &previous
+	return nil
+}
+
+// planTiles fills in plan.image.TileRefs, deduplicating each tile against
+// the snapshot and compressing the ones that must be written. tiles holds
+// the data for any tile that may be new; a tile that is neither stored nor
+// in tiles is an error. Planning stops with ctx's error once ctx is done.
+// Tiles named by their content are moved into the image's tile pool, and
+// references to any other pool are rejected.
+func (s *PebbleImageStore) planTiles(ctx context.Context, plan *storePlan, snapshot *pebble.Snapshot, tileRefs []TileRef, tiles map[TileID]Tile) error {
+	pool := s.tilePool(plan.image.ID)
+	tileRefs, tiles = poolTiles(pool, tileRefs, tiles)
+
+	// Track tiles we've already planned for intra-image deduplication
 	processedTiles := make(map[TileID]bool)
 
 	// Process each tile
-	for i, tile := range tiles {
-		tileKey := makeKey(tilesBucket, string(tile.ID))
+	for i, tileRef := range tileRefs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if tileIDPool(tileRef.TileID) != pool {
+			return fmt.Errorf("invalid manifest: tile %s is outside the tile pool of %s", tileRef.TileID, plan.image.ID)
+		}
 
 		// Check if exact tile already exists (by hash)
-		if _, closer, err := s.db.Get(tileKey); err == nil {
+		if _, closer, err := snapshot.Get(tileKey(tileRef.TileID)); err == nil {
 			closer.Close()
-			dedupMatch++
-			// Tile already exists, just reference it
-			storedImage.TileRefs[i] = TileRef{
-				X:           tileRefs[i].X,
-				Y:           tileRefs[i].Y,
-				TileID:      tileRefs[i].TileID,
-				StorageType: StorageDuplicate,
-			}
+			plan.dedupMatches++
+			tileRef.StorageType = StorageDuplicate
+			plan.image.TileRefs[i] = tileRef
 			continue
 		}
 
-		// Check if we've already processed this tile in this batch (intra-image deduplication)
-		if processedTiles[tile.ID] {
-			dedupMatch++
-			// Tile already processed in this batch, just reference it
-			storedImage.TileRefs[i] = TileRef{
-				X:           tileRefs[i].X,
-				Y:           tileRefs[i].Y,
-				TileID:      tileRefs[i].TileID,
-				StorageType: StorageDuplicate,
+		tile, ok := tiles[tileRef.TileID]
+		if !ok {
+			return fmt.Errorf("missing tile: %s", tileRef.TileID)
+		}
+
+		// Otherwise store the canonical variant so channel-swapped and
+		// inverted copies share storage
+		if s.config.CanonicalizeTiles {
+			tile, tileRef.Transform = canonicalizeTile(tile)
+			tile.ID = poolTileID(pool, GenerateTileID(tile.Hash))
+			tileRef.TileID = tile.ID
+
+			if _, closer, err := snapshot.Get(tileKey(tile.ID)); err == nil {
+				closer.Close()
+				plan.dedupMatches++
+				tileRef.StorageType = StorageDuplicate
+				plan.image.TileRefs[i] = tileRef
+				continue
 			}
-			continue
 		}
 
-		// Mark this tile as processed in this batch
+		// Check if we've already planned this tile (intra-image deduplication)
+		if processedTiles[tile.ID] {
+			plan.dedupMatches++
+			tileRef.StorageType = StorageDuplicate
+			plan.image.TileRefs[i] = tileRef
+			continue
+		}
 		processedTiles[tile.ID] = true
 
-		directStore++
 		// Store as new tile (compressed)
 		compressedData, err := s.compressTileData(tile.Data)
 		if err != nil {
 			return fmt.Errorf("failed to compress tile %s: %w", tile.ID, err)
 		}
-		err = batch.Set(tileKey, compressedData, pebble.Sync)
-		if err != nil {
-			return fmt.Errorf("failed to store tile %s: %w", tile.ID, err)
-		}
+		plan.newTiles = append(plan.newTiles, plannedTile{tile: tile, compressed: compressedData})
 
-		// Optionally dump uncompressed tile to disk for dictionary training
-		if s.config.TileDumpDir != "" {
-			err = s.dumpTileToFile(tile.ID, tile.Data)
-			if err != nil {
-				// Log error but don't fail the entire operation
-				fmt.Printf("Warning: failed to dump tile %s to file: %v\n", tile.ID, err)
-			}
-		}
+		tileRef.StorageType = StorageUnique
+		plan.image.TileRefs[i] = tileRef
+	}
+
+	return nil
+}
+
+// applyStorePlan writes a precomputed plan in a single atomic batch
+func (s *PebbleImageStore) applyStorePlan(plan *storePlan) error {
+	id := plan.image.ID
 
-		storedImage.TileRefs[i] = TileRef{
-			X:           tileRefs[i].X,
-			Y:           tileRefs[i].Y,
-			TileID:      tileRefs[i].TileID,
-			StorageType: StorageUnique,
+	// Blob files are appended to before the batch commits, so a full disk
+	// must refuse the write before any tile reaches them
+	if err := s.checkDiskSpace(); err != nil {
+		return err
+	}
+
+	// Use batch for atomic operations. Verification reads the image back
+	// through the batch, which needs it indexed.
+	var batch *pebble.Batch
+	if plan.source != nil {
+		batch = s.db.NewIndexedBatch()
+	} else {
+		batch = s.db.NewBatch()
+	}
+	defer batch.Close()
+
+	values := make([][]byte, len(plan.newTiles))
+	for i, planned := range plan.newTiles {
+		values[i] = planned.compressed
+	}
+	release, err := s.separateBlobs(values)
+	if err != nil {
+		return fmt.Errorf("failed to write tile blobs: %w", err)
+	}
+	defer release()
+
+	for i, planned := range plan.newTiles {
+		err := batch.Set(tileKey(planned.tile.ID), values[i], pebble.Sync)
+		if err != nil {
+			return fmt.Errorf("failed to store tile %s: %w", planned.tile.ID, err)
 		}
 	}
 
 	// Store image metadata
-	imageBytes, err := json.Marshal(storedImage)
+	imageBytes, err := encodeManifest(plan.image)
 	if err != nil {
 		return fmt.Errorf("failed to marshal image metadata: %w", err)
 	}
-	imageKey := makeKey(imagesBucket, id)
-	err = batch.Set(imageKey, imageBytes, pebble.Sync)
+	err = batch.Set(makeKey(imagesBucket, id), imageBytes, pebble.Sync)
 	if err != nil {
 		return fmt.Errorf("failed to store image metadata: %w", err)
 	}
 
+	// Index entries belong to the overwritten image, not the new content
+	if plan.previous != nil {
+		if err := unindexImage(batch, plan.previous); err != nil {
+			return err
+		}
+	}
+	if err := indexImage(batch, plan.image); err != nil {
+		return err
+	}
+
+	// A newly stored image supersedes any trashed image with the same ID
+	err = batch.Delete(makeKey(trashBucket, id), pebble.Sync)
+	if err != nil {
+		return fmt.Errorf("failed to clear trashed image: %w", err)
+	}
+
+	if plan.source != nil {
+		if err := s.verifyPlan(batch, plan); err != nil {
+			return err
+		}
+	}
+
 	// Commit the batch
-	err = batch.Commit(pebble.Sync)
+	action := AuditStored
+	if plan.previous != nil {
+		action = AuditOverwritten
+	}
+	err = s.commitChangesBy(batch, action, plan.actor, id)
 	if err != nil {
 		return fmt.Errorf("failed to commit batch: %w", err)
 	}
 
-	fmt.Println("Deduplication matches found:", dedupMatch)
-	fmt.Println("No best matches found:", noBestMatch)
+	// Optionally dump uncompressed tiles to disk for dictionary training
+	if s.config.TileDumpDir != "" {
+		for _, planned := range plan.newTiles {
+			err = s.dumpTileToFile(planned.tile.ID, planned.tile.Data)
+			if err != nil {
+				// Log error but don't fail the entire operation
+				fmt.Printf("Warning: failed to dump tile %s to file: %v\n", planned.tile.ID, err)
+			}
+		}
+	}
+
 	return nil
 }
 
-// RetrieveImage reconstructs and returns an image
+// RetrieveImage reconstructs and returns an image, fetching it from the
+// upstream first when one is configured and the image isn't held locally
 func (s *PebbleImageStore) RetrieveImage(id string) ([]byte, error) {
-	var storedImage StoredImage
+	return s.RetrieveImageContext(context.Background(), id)
+}
 
-	imageKey := makeKey(imagesBucket, id)
-	imageData, closer, err := s.db.Get(imageKey)
+// RetrieveImageContext is RetrieveImage, giving up with ctx's error if ctx
+// is done before the image is reconstructed
+func (s *PebbleImageStore) RetrieveImageContext(ctx context.Context, id string) ([]byte, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+
+	start := time.Now()
+	storedImage, err := s.lookupImage(id)
 	if err != nil {
-		return nil, fmt.Errorf("image not found: %s", id)
+		return nil, err
+	}
+
+	key := renderKey(storedImage)
+	if data, ok := s.imageCache.get(id, key); ok {
+		s.recordAccess(id)
+		s.latency.observe(OperationRetrieve, id, time.Since(start), imageShape(storedImage)+", cached")
+		return data, nil
+	}
+
+	data, err := s.shareRetrieval(ctx, "png", storedImage, key, func(ctx context.Context) ([]byte, error) {
+		img, err := s.reconstructStoredImage(ctx, storedImage)
+		if err != nil {
+			return nil, err
+		}
+
+		// Encode to PNG
+		data, err := encodeStoredImage(img, storedImage)
+		if err != nil {
+			return nil, err
+		}
+		s.imageCache.put(id, key, data)
+		return data, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.latency.observe(OperationRetrieve, id, time.Since(start), imageShape(storedImage))
+	return data, nil
+}
+
+// imageShape describes an image's size and tiling for the slow-operation log
+func imageShape(storedImage *StoredImage) string {
+	distinct := make(map[TileID]struct{}, len(storedImage.TileRefs))
+	for _, tileRef := range storedImage.TileRefs {
+		distinct[tileRef.TileID] = struct{}{}
+	}
+	return fmt.Sprintf("%dx%d, %d tiles, %d distinct", storedImage.Width, storedImage.Height, len(storedImage.TileRefs), len(distinct))
+}
+
+// reconstructImage rebuilds an image from its tiles, recording the access
+func (s *PebbleImageStore) reconstructImage(ctx context.Context, id string) (image.Image, *StoredImage, error) {
+	storedImage, err := s.lookupImage(id)
+	if err != nil {
+		return nil, nil, err
 	}
-	defer closer.Close()
 
-	err = json.Unmarshal(imageData, &storedImage)
+	img, err := s.reconstructStoredImage(ctx, storedImage)
 	if err != nil {
+		return nil, nil, err
+	}
+	return img, storedImage, nil
+}
+
+// reconstructStoredImage rebuilds an already loaded image, recording the
+// access
+func (s *PebbleImageStore) reconstructStoredImage(ctx context.Context, storedImage *StoredImage) (image.Image, error) {
+	img, err := s.rebuildStoredImage(ctx, storedImage)
+	if err != nil {
+		return nil, err
+	}
+	s.recordAccess(storedImage.ID)
+	return img, nil
+}
+
+// rebuildStoredImage rebuilds an already loaded image without counting it
+// as retrieved, for work nobody asked to see
+func (s *PebbleImageStore) rebuildStoredImage(ctx context.Context, storedImage *StoredImage) (image.Image, error) {
+	if err := s.reconstructions.acquireContext(ctx); err != nil {
 		return nil, err
 	}
+	defer s.reconstructions.release()
+
+	tiles, err := s.getTilesFrom(ctx, s.db, storedImage.TileRefs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reconstruct image: %w", err)
+	}
 
 	// Reconstruct image
-	img, err := ReconstructImage(&storedImage, s.config.TileSize, func(tileID TileID) ([]byte, error) {
-		return s.getTileData(tileID)
-	})
+	img, err := ReconstructImage(storedImage, s.config.TileSize, prefetchedTiles(tiles))
 	if err != nil {
 		return nil, fmt.Errorf("failed to reconstruct image: %w", err)
 	}
+	return applyRedactions(img, storedImage.Redactions), nil
+}
 
-	// Encode to PNG
-	return encodeImageToPNG(img)
+// encodeStoredImage encodes a reconstructed image as a PNG, embedding the
+// upload's ICC profile so colors render as they did in the original
+func encodeStoredImage(img image.Image, storedImage *StoredImage) ([]byte, error) {
+	data, err := encodeImageToPNG(img)
+	if err != nil || storedImage.Source == nil || len(storedImage.Source.ICCProfile) == 0 {
+		return data, err
+	}
+	return embedICCProfile(data, storedImage.Source.ICCProfile)
 }
 
-// DeleteImage removes an image and unreferenced tiles
+// DeleteImage moves an image to the trash, or removes it permanently when
+// trash retention is disabled
 func (s *PebbleImageStore) DeleteImage(id string) error {
-	imageKey := makeKey(imagesBucket, id)
-	imageData, closer, err := s.db.Get(imageKey)
-	if err != nil {
-		return fmt.Errorf("image not found: %s", id)
+	if err := s.enter(); err != nil {
+		return err
 	}
-	defer closer.Close()
+	defer s.leave()
 
-	var storedImage StoredImage
-	err = json.Unmarshal(imageData, &storedImage)
+	// Hold the image's version from the read through the commit, so a
+	// concurrent store or update isn't trashed under a stale manifest
+	unlock := s.lockVersion(id)
+	defer unlock()
+
+	if s.config.TrashRetention <= 0 {
+		return s.deleteImagePermanently(id)
+	}
+
+	storedImage, err := s.getStoredImage(id)
 	if err != nil {
-		return fmt.Errorf("failed to unmarshal image: %w", err)
+		return err
 	}
 
-	// Delete image metadata
-	err = s.db.Delete(imageKey, pebble.Sync)
+	return s.trashImage(storedImage)
+}
+
+// deleteImagePermanently removes an image manifest and its index entries,
+// leaving its tiles for garbage collection. The caller must hold the image's
+// version lock.
+func (s *PebbleImageStore) deleteImagePermanently(id string) error {
+	storedImage, err := s.getStoredImage(id)
 	if err != nil {
 		return err
 	}
 
-	// TODO: Implement reference counting to delete unreferenced tiles
-	// For now, we keep tiles to avoid complexity
+	batch := s.db.NewBatch()
+	defer batch.Close()
 
-	return nil
+	// Delete image metadata and its index entries
+	if err := unindexImage(batch, storedImage); err != nil {
+		return err
+	}
+	if err := batch.Delete(makeKey(imagesBucket, id), pebble.Sync); err != nil {
+		return err
+	}
+	if err := batch.Delete(annotationKey(id), pebble.Sync); err != nil {
+		return err
+	}
+
+	return s.commitChanges(batch, AuditDeleted, id)
+}
+
+// getStoredImage loads a live image manifest
+func (s *PebbleImageStore) getStoredImage(id string) (*StoredImage, error) {
+	imageData, closer, err := s.db.Get(makeKey(imagesBucket, id))
+	if err != nil {
+		return nil, fmt.Errorf("image not found: %s", id)
+	}
+	defer closer.Close()
+
+	var storedImage StoredImage
+	if err := decodeManifest(imageData, &storedImage); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal image: %w", err)
+	}
+
+	return &storedImage, nil
 }
 
 // ListImages returns all stored image IDs
 func (s *PebbleImageStore) ListImages() ([]string, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+
 	var imageIDs []string
 
 	// Create iterator for images bucket
 	prefix := makePrefixKey(imagesBucket)
 	iter, err := s.db.NewIter(&pebble.IterOptions{
 		LowerBound: prefix,
-		UpperBound: append(prefix, 0xFF),
+		UpperBound: prefixUpperBound(prefix),
 	})
 	if err != nil {
 		return nil, err
@@ -285,15 +960,23 @@ func (s *PebbleImageStore) ListImages() ([]string, error) {
 	return imageIDs, iter.Error()
 }
 
-// GetStorageStats returns storage statistics
+// GetStorageStats returns storage statistics, or none once the store is
+// closed
 func (s *PebbleImageStore) GetStorageStats() StorageStats {
 	var stats StorageStats
+	if s.enter() != nil {
+		return stats
+	}
+	defer s.leave()
+
+	now := time.Now()
+	coldTiles := 0
 
 	// Count images and analyze tile usage patterns
 	imagesPrefix := makePrefixKey(imagesBucket)
 	imagesIter, err := s.db.NewIter(&pebble.IterOptions{
 		LowerBound: imagesPrefix,
-		UpperBound: append(imagesPrefix, 0xFF),
+		UpperBound: prefixUpperBound(imagesPrefix),
 	})
 	if err != nil {
 		return stats
@@ -304,7 +987,7 @@ func (s *PebbleImageStore) GetStorageStats() StorageStats {
 		stats.TotalImages++
 
 		var storedImage StoredImage
-		err := json.Unmarshal(imagesIter.Value(), &storedImage)
+		err := decodeManifest(imagesIter.Value(), &storedImage)
 		if err == nil {
 			// Count tiles by storage type
 			for _, tileRef := range storedImage.TileRefs {
@@ -319,6 +1002,13 @@ func (s *PebbleImageStore) GetStorageStats() StorageStats {
 
 			// Use stored original PNG input size
 			stats.OriginalBytes += storedImage.OriginalBytes
+
+			if storedImage.ExpiresAt != nil {
+				stats.ExpiringImages++
+				if storedImage.ExpiresAt.Before(now.Add(24 * time.Hour)) {
+					stats.ExpiringWithin24h++
+				}
+			}
 		}
 	}
 
@@ -326,13 +1016,23 @@ func (s *PebbleImageStore) GetStorageStats() StorageStats {
 	tilesPrefix := makePrefixKey(tilesBucket)
 	tilesIter, err := s.db.NewIter(&pebble.IterOptions{
 		LowerBound: tilesPrefix,
-		UpperBound: append(tilesPrefix, 0xFF),
+		UpperBound: prefixUpperBound(tilesPrefix),
 	})
+	var blobTiles int
+	var blobBytes int64
 	if err == nil {
 		defer tilesIter.Close()
 		for tilesIter.First(); tilesIter.Valid(); tilesIter.Next() {
 			stats.UniqueTiles++
-			stats.StorageBytes += int64(len(tilesIter.Value()))
+			size := storedTileSize(tilesIter.Value())
+			stats.StorageBytes += size
+			if isColdStub(tilesIter.Value()) {
+				coldTiles++
+			}
+			if isBlobPointer(tilesIter.Value()) {
+				blobTiles++
+				blobBytes += size
+			}
 		}
 	}
 
@@ -347,66 +1047,113 @@ func (s *PebbleImageStore) GetStorageStats() StorageStats {
 		stats.CompressionRatio = float64(stats.OriginalBytes) / float64(stats.StorageBytes)
 	}
 
+	if namespaces, err := s.UsageByNamespace(); err == nil {
+		stats.Namespaces = namespaces
+	}
+
+	if buckets, err := s.bucketStats(); err == nil {
+		stats.Buckets = buckets
+	}
+	stats.DiskBytes = s.diskBytes()
+	if err := s.checkDiskSpace(); err != nil {
+		stats.StorageFull = err.Error()
+	}
+	stats.WriteQueue = s.writes.snapshot()
+	stats.Resources = s.resourceStats()
+	stats.Operations = s.latency.snapshot()
+	stats.ImageCache = s.imageCache.snapshot()
+	stats.ColdTier = s.coldTierStats(coldTiles)
+	stats.Blobs = s.blobStats(blobTiles, blobBytes)
+	stats.Backups = s.backupStats()
+
 	return stats
 }
 
-// Close closes the database
+// Close refuses new calls with ErrStoreClosed, stops background jobs and
+// waits for calls in flight, including queued writes, before closing the
+// database and blob files. It is safe to call while other calls run, but
+// not from within one, such as a RetrieveImages callback or an Images loop,
+// which it would wait for forever. Closing a closed store returns
+// ErrStoreClosed.
 func (s *PebbleImageStore) Close() error {
-	return s.db.Close()
+	if !s.beginClose() {
+		return ErrStoreClosed
+	}
+	s.stopBackgroundJobs()
+	s.closing.inflight.Wait()
+
+	err := s.db.Close()
+	if blobErr := s.blobs.close(); err == nil {
+		err = blobErr
+	}
+	if lockErr := s.lock.Close(); err == nil {
+		err = lockErr
+	}
+	return err
 }
 
-// compressTileData compresses tile data using zstd
+// compressTileData encodes tile data at the configured level
 func (s *PebbleImageStore) compressTileData(data []byte) ([]byte, error) {
-	expectedSize := s.config.TileSize * s.config.TileSize * 3
-	if len(data) != expectedSize {
-		return nil, fmt.Errorf("invalid tile data size: expected %d, got %d", expectedSize, len(data))
+	return s.compressTileDataLevel(data, s.level)
+}
+
+// compressTileDataLevel encodes tile data with every candidate codec and
+// keeps the smallest result, prefixed with the winning codec's ID. Strips
+// are encoded with plain zstd, since the other codecs may rely on the tile
+// shape.
+func (s *PebbleImageStore) compressTileDataLevel(data []byte, level int) ([]byte, error) {
+	if err := validateStoredTileData(data); err != nil {
+		return nil, err
+	}
+
+	codecs := s.codecs
+	if isStripData(data, s.config.TileSize) {
+		codecs = []TileCodec{s.codecsByID[(&zstdCodec{}).ID()]}
 	}
 
-	// Compress using zstd with optional dictionary
-	if s.dict != nil {
-		var buf bytes.Buffer
-		writer := zstd.NewWriterLevelDict(&buf, zstd.BestSpeed, s.dict)
-		
-		_, err := writer.Write(data)
+	var best []byte
+	for _, codec := range codecs {
+		payload, err := codec.Encode(data, level)
 		if err != nil {
-			writer.Close()
-			return nil, fmt.Errorf("failed to write data to zstd writer: %w", err)
+			return nil, fmt.Errorf("failed to encode tile with %s: %w", codec.Name(), err)
 		}
-		
-		err = writer.Close()
-		if err != nil {
-			return nil, fmt.Errorf("failed to close zstd writer: %w", err)
+		if best == nil || len(payload)+1 < len(best) {
+			best = append([]byte{codec.ID()}, payload...)
 		}
-		
-		return buf.Bytes(), nil
 	}
-	return zstd.Compress(nil, data)
+
+	return best, nil
 }
 
-// decompressTileData decompresses tile data from zstd
+// decompressTileData decodes stored tile data using the codec named by its
+// first byte
 func (s *PebbleImageStore) decompressTileData(compressedData []byte) ([]byte, error) {
-	// Decompress using zstd with optional dictionary
+	if len(compressedData) == 0 {
+		return nil, fmt.Errorf("empty tile data")
+	}
+
+	s.decompressors.acquire()
+	defer s.decompressors.release()
+
 	var data []byte
 	var err error
-	if s.dict != nil {
-		reader := zstd.NewReaderDict(bytes.NewReader(compressedData), s.dict)
-		defer reader.Close()
-		
-		data, err = io.ReadAll(reader)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read from zstd reader: %w", err)
-		}
+	if compressedData[0] == zstdFrameMagic {
+		// Legacy value stored as a bare zstd frame
+		data, err = s.codecsByID[(&zstdCodec{}).ID()].Decode(compressedData)
 	} else {
-		data, err = zstd.Decompress(nil, compressedData)
-		if err != nil {
-			return nil, fmt.Errorf("failed to decompress zstd tile: %w", err)
+		codec, ok := s.codecsByID[compressedData[0]]
+		if !ok {
+			return nil, fmt.Errorf("unknown tile codec ID: %#x", compressedData[0])
 		}
+		data, err = codec.Decode(compressedData[1:])
+	}
+	if err != nil {
+		return nil, err
 	}
 
 	// Validate tile data size
-	expectedSize := s.config.TileSize * s.config.TileSize * 3
-	if len(data) != expectedSize {
-		return nil, fmt.Errorf("invalid decompressed tile data size: expected %d, got %d", expectedSize, len(data))
+	if err := validateStoredTileData(data); err != nil {
+		return nil, fmt.Errorf("invalid decompressed tile: %w", err)
 	}
 
 	return data, nil
@@ -414,6 +1161,11 @@ func (s *PebbleImageStore) decompressTileData(compressedData []byte) ([]byte, er
 
 // RetrieveDebugImage generates a color-coded debug visualization
 func (s *PebbleImageStore) RetrieveDebugImage(id string) ([]byte, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+
 	var storedImage StoredImage
 
 	imageKey := makeKey(imagesBucket, id)
@@ -423,7 +1175,7 @@ func (s *PebbleImageStore) RetrieveDebugImage(id string) ([]byte, error) {
 	}
 	defer closer.Close()
 
-	err = json.Unmarshal(imageData, &storedImage)
+	err = decodeManifest(imageData, &storedImage)
 	if err != nil {
 		return nil, err
 	}
@@ -445,10 +1197,12 @@ func (s *PebbleImageStore) RetrieveDebugImage(id string) ([]byte, error) {
 		}
 
 		// Calculate tile boundaries
-		startX := tileRef.X * s.config.TileSize
-		startY := tileRef.Y * s.config.TileSize
-		endX := min(startX+s.config.TileSize, storedImage.Width)
-		endY := min(startY+s.config.TileSize, storedImage.Height)
+		origin := storedImage.tileOrigin(tileRef, s.config.TileSize)
+		tileWidth, tileHeight := storedImage.tileDims(s.config.TileSize)
+		startX := origin.X
+		startY := max(origin.Y, 0)
+		endX := min(startX+tileWidth, storedImage.Width)
+		endY := min(origin.Y+tileHeight, storedImage.Height)
 
 		// Fill tile area with color
 		for y := startY; y < endY; y++ {
@@ -487,22 +1241,128 @@ func (s *PebbleImageStore) RetrieveDebugImage(id string) ([]byte, error) {
 
 // getTileData retrieves tile data by ID
 func (s *PebbleImageStore) getTileData(tileID TileID) ([]byte, error) {
-	tileKey := makeKey(tilesBucket, string(tileID))
+	return s.getTileDataFrom(s.db, tileID)
+}
+
+// getTilesFrom prefetches every distinct tile an image references from a
+// database or snapshot. Keys are visited in sorted order with a single
+// iterator, so a tile repeated across the image is read once, and the tiles
+// are decompressed in parallel. Reading stops with ctx's error once ctx is
+// done.
+func (s *PebbleImageStore) getTilesFrom(ctx context.Context, reader pebble.Reader, tileRefs []TileRef) (map[TileID][]byte, error) {
+	seen := make(map[TileID]bool, len(tileRefs))
+	var tileIDs []TileID
+	for _, tileRef := range tileRefs {
+		if !seen[tileRef.TileID] {
+			seen[tileRef.TileID] = true
+			tileIDs = append(tileIDs, tileRef.TileID)
+		}
+	}
+	sort.Slice(tileIDs, func(i, j int) bool { return tileIDs[i] < tileIDs[j] })
+
+	prefix := makePrefixKey(tilesBucket)
+	iter, err := reader.NewIter(&pebble.IterOptions{
+		LowerBound: prefix,
+		UpperBound: prefixUpperBound(prefix),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	compressed := make([][]byte, len(tileIDs))
+	for i, tileID := range tileIDs {
+		key := tileKey(tileID)
+		if !iter.SeekGE(key) || !bytes.Equal(iter.Key(), key) {
+			if err := iter.Error(); err != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("tile not found: %s", tileID)
+		}
+		// The iterator reuses its buffer, so keep a copy
+		compressed[i] = append([]byte(nil), iter.Value()...)
+	}
+	s.blobs.willNeed(compressed)
+
+	decompressed := make([][]byte, len(tileIDs))
+	errs := make([]error, len(tileIDs))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(runtime.GOMAXPROCS(0), len(tileIDs)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				decompressed[i], errs[i] = s.decodeTile(tileIDs[i], compressed[i])
+			}
+		}()
+	}
+	for i := range tileIDs {
+		if ctx.Err() != nil {
+			break
+		}
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	tiles := make(map[TileID][]byte, len(tileIDs))
+	for i, tileID := range tileIDs {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		tiles[tileID] = decompressed[i]
+	}
+	return tiles, nil
+}
+
+// prefetchedTiles adapts prefetched tiles to ReconstructImage
+func prefetchedTiles(tiles map[TileID][]byte) func(TileID) ([]byte, error) {
+	return func(tileID TileID) ([]byte, error) {
+		data, ok := tiles[tileID]
+		if !ok {
+			return nil, fmt.Errorf("tile not found: %s", tileID)
+		}
+		return data, nil
+	}
+}
+
+// getTileDataFrom retrieves tile data by ID from a database or snapshot
+func (s *PebbleImageStore) getTileDataFrom(reader pebble.Reader, tileID TileID) ([]byte, error) {
+	key := tileKey(tileID)
 
 	// Try tiles bucket first
-	if compressedData, closer, err := s.db.Get(tileKey); err == nil {
+	if compressedData, closer, err := reader.Get(key); err == nil {
 		defer closer.Close()
-		// Decompress the tile data
-		decompressedData, err := s.decompressTileData(compressedData)
-		if err != nil {
-			return nil, fmt.Errorf("failed to decompress tile %s: %w", tileID, err)
-		}
-		return decompressedData, nil
+		return s.decodeTile(tileID, compressedData)
 	}
 
 	return nil, fmt.Errorf("tile not found: %s", tileID)
 }
 
+// decodeTile resolves and decompresses a stored tile value
+func (s *PebbleImageStore) decodeTile(tileID TileID, stored []byte) ([]byte, error) {
+	if isBlobPointer(stored) {
+		if s.config.ColdStore != nil {
+			s.coldStats.hotReads.Add(1)
+		}
+		return s.decodeBlobTile(tileID, stored)
+	}
+
+	stored, err := s.resolveTile(tileID, stored)
+	if err != nil {
+		return nil, err
+	}
+	data, err := s.decompressTileData(stored)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress tile %s: %w", tileID, err)
+	}
+	return data, nil
+}
+
 // dumpTileToFile writes uncompressed tile data to a file for zstd dictionary training
 func (s *PebbleImageStore) dumpTileToFile(tileID TileID, data []byte) error {
 	filename := filepath.Join(s.config.TileDumpDir, string(tileID)+".tile")
@@ -1,9 +1,12 @@
 package imagestore
 
 import (
+	"context"
+	"errors"
 	"image"
 	"image/color"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -297,6 +300,59 @@ func TestGetStorageStats(t *testing.T) {
 	}
 }
 
+func TestPlanStoreIsReadOnly(t *testing.T) {
+	tempDir := t.TempDir()
+	dbPath := filepath.Join(tempDir, "test.db")
+
+	config := DefaultConfig()
+	config.DatabasePath = dbPath
+	config.TileSize = 4
+
+	store, err := NewPebbleImageStore(config)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	// A uniform 8x8 image yields four identical tiles
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			img.Set(x, y, color.RGBA{10, 20, 30, 255})
+		}
+	}
+	imageData, err := encodeImageToPNG(img)
+	if err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+
+	plan, err := store.planStore(context.Background(), "planned", imageData, StoreOptions{})
+	if err != nil {
+		t.Fatalf("failed to plan store: %v", err)
+	}
+
+	if len(plan.newTiles) != 1 {
+		t.Errorf("expected 1 new tile in plan, got %d", len(plan.newTiles))
+	}
+	if plan.dedupMatches != 3 {
+		t.Errorf("expected 3 dedup matches in plan, got %d", plan.dedupMatches)
+	}
+
+	stats := store.GetStorageStats()
+	if stats.TotalImages != 0 || stats.UniqueTiles != 0 {
+		t.Errorf("planning should not write: got %d images, %d tiles", stats.TotalImages, stats.UniqueTiles)
+	}
+
+	if err := store.applyStorePlan(plan); err != nil {
+		t.Fatalf("failed to apply plan: %v", err)
+	}
+
+	stats = store.GetStorageStats()
+	if stats.TotalImages != 1 || stats.UniqueTiles != 1 {
+		t.Errorf("expected 1 image and 1 tile after apply, got %d images, %d tiles", stats.TotalImages, stats.UniqueTiles)
+	}
+}
+
 func TestCompressDecompressTileData(t *testing.T) {
 	tempDir := t.TempDir()
 	dbPath := filepath.Join(tempDir, "test.db")
@@ -379,3 +435,79 @@ func createTestImage(width, height int) image.Image {
 	}
 	return img
 }
+
+func TestGetTilesFrom(t *testing.T) {
+	store := newTagsTestStore(t)
+
+	// Four identical tiles plus one distinct one
+	img := image.NewRGBA(image.Rect(0, 0, 12, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 12; x++ {
+			c := color.RGBA{10, 20, 30, 255}
+			if x >= 8 && y < 4 {
+				c = color.RGBA{200, 100, 50, 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	imageData, _ := encodeImageToPNG(img)
+	if err := store.StoreImage("repeated", imageData); err != nil {
+		t.Fatalf("failed to store image: %v", err)
+	}
+
+	storedImage, _ := store.GetManifest("repeated")
+	tiles, err := store.getTilesFrom(context.Background(), store.db, storedImage.TileRefs)
+	if err != nil {
+		t.Fatalf("failed to prefetch tiles: %v", err)
+	}
+	if len(storedImage.TileRefs) != 6 || len(tiles) != 2 {
+		t.Fatalf("expected 6 refs to 2 distinct tiles, got %d refs and %d tiles", len(storedImage.TileRefs), len(tiles))
+	}
+	for tileID, data := range tiles {
+		if GenerateTileID(ComputeTileHash(data)) != tileID {
+			t.Errorf("tile %s has the wrong data", tileID)
+		}
+	}
+
+	refs := append(storedImage.TileRefs, TileRef{TileID: "absent"})
+	if _, err := store.getTilesFrom(context.Background(), store.db, refs); err == nil || !strings.Contains(err.Error(), "tile not found: absent") {
+		t.Errorf("expected a missing tile error, got %v", err)
+	}
+}
+
+func TestCancelledStoreAndRetrieve(t *testing.T) {
+	config := DefaultConfig()
+	config.DatabasePath = filepath.Join(t.TempDir(), "test.db")
+	config.TileSize = 4
+
+	store, err := NewPebbleImageStore(config)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	imageData, err := encodeImageToPNG(createTestImage(8, 8))
+	if err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := store.StoreImageContext(ctx, "cancelled", imageData, StoreOptions{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected a cancelled store to fail with context.Canceled, got %v", err)
+	}
+	if stats := store.GetStorageStats(); stats.TotalImages != 0 || stats.UniqueTiles != 0 {
+		t.Errorf("cancelled store should not write: got %d images, %d tiles", stats.TotalImages, stats.UniqueTiles)
+	}
+
+	if err := store.StoreImageContext(context.Background(), "stored", imageData, StoreOptions{}); err != nil {
+		t.Fatalf("failed to store image: %v", err)
+	}
+	if _, err := store.RetrieveImageContext(ctx, "stored"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected a cancelled retrieve to fail with context.Canceled, got %v", err)
+	}
+	if _, err := store.RetrieveImageContext(context.Background(), "stored"); err != nil {
+		t.Errorf("failed to retrieve image: %v", err)
+	}
+}
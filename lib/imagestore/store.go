@@ -7,6 +7,7 @@ import (
 	"image"
 	"image/jpeg"
 	"image/png"
+	"time"
 )
 
 type TileHash [32]byte
@@ -29,7 +30,23 @@ type StoredImage struct {
 	Height        int
 	TileRefs      []TileRef
 	Metadata      map[string]string
-	OriginalBytes int64 // Size of original PNG input data
+	OriginalBytes int64       // Size of original PNG input data
+	TrashedAt     *time.Time  // Set while the image is in the trash
+	Tags          []string    `json:",omitempty"`
+	ExpiresAt     *time.Time  `json:",omitempty"` // Image is deleted by the expiry sweeper after this time
+	StoredAt      *time.Time  `json:",omitempty"` // When the image was stored; nil for images stored before it was recorded
+	Source        *SourceInfo `json:",omitempty"` // EXIF and ICC metadata of the upload
+	Background    string      `json:",omitempty"` // #rrggbb padding edge tiles and behind transparent pixels; empty is black
+	Redactions    []Redaction `json:",omitempty"` // Rectangles hidden whenever the image is rendered
+	GridOffsetY   int         `json:",omitempty"` // Tile rows start this many pixels above the image, lining scrolled content up with earlier tiles
+	StripHeight   int         `json:",omitempty"` // Tiles are full-width strips this many pixels tall instead of squares
+	TileSize      int         `json:",omitempty"` // Tiles are squares of this size chosen by Config.AutoTileSize; 0 uses the store's tile size
+}
+
+// StoreOptions carries optional per-upload settings
+type StoreOptions struct {
+	ExpiresAt *time.Time // Delete the image after this time
+	IfMatch   string     // Only replace an image whose ETag is listed, or any existing image for "*"
 }
 
 type StorageType uint8
@@ -51,9 +68,10 @@ func (s StorageType) String() string {
 }
 
 type TileRef struct {
-	X, Y        int         // Position in image (tile coordinates)
-	TileID      TileID      // Reference to tile
-	StorageType StorageType // How this tile was stored
+	X, Y        int           // Position in image (tile coordinates)
+	TileID      TileID        // Reference to tile
+	StorageType StorageType   // How this tile was stored
+	Transform   TileTransform `json:",omitempty"` // Applied to the stored tile to recover this one
 }
 
 type StorageStats struct {
@@ -67,6 +85,19 @@ type StorageStats struct {
 	StorageBytes        int64
 	OriginalBytes       int64
 	CompressionRatio    float64
+	ExpiringImages      int // Images with an expiration time
+	ExpiringWithin24h   int // Images that expire in the next 24 hours
+	Namespaces          map[string]NamespaceUsage
+	DiskBytes           int64                  // Database size on disk, including WAL and obsolete files
+	StorageFull         string                 // Why writes are refused for lack of space; empty while there is room
+	Buckets             map[string]BucketStats // Key counts and sizes per bucket, e.g. "tiles"
+	WriteQueue          WriteQueueStats
+	Resources           ResourceStats
+	Operations          map[string]OperationStats // Latency of stores and retrievals, keyed by OperationStore and OperationRetrieve
+	ImageCache          ImageCacheStats
+	ColdTier            ColdTierStats
+	Backups             BackupStats
+	Blobs               BlobStats
 }
 
 type ImageStore interface {
@@ -79,18 +110,84 @@ type ImageStore interface {
 }
 
 type Config struct {
-	TileSize            int     // Default 256
-	SimilarityThreshold float64 // Default 0.1 (10% difference threshold)
-	DatabasePath        string
-	TileDumpDir         string  // Optional: directory to dump uncompressed tiles for zstd dictionary training
-	DictPath            string  // Optional: path to zstd dictionary file for compression
+	TileSize                  int     // Default 256
+	SimilarityThreshold       float64 // Default 0.1 (10% difference threshold)
+	DatabasePath              string
+	TileDumpDir               string                 // Optional: directory to dump uncompressed tiles for zstd dictionary training
+	DictPath                  string                 // Optional: path to zstd dictionary file for compression
+	TrashRetention            time.Duration          // How long deleted images stay restorable; 0 deletes immediately
+	TrashPurgeInterval        time.Duration          // How often to purge trash past TrashRetention and collect its tiles; 0 disables the job
+	CompressionLevel          string                 // zstd level for stores: fastest, default, better or best
+	CompactionLevel           string                 // zstd level used by RecompressTiles for offline compaction
+	TileCodecs                []string               // Candidate tile codecs; the smallest encoding wins. Default: zstd
+	CanonicalizeTiles         bool                   // Share tiles that differ only by channel permutation or inversion
+	ClusterInterval           time.Duration          // How often to recluster images in the background; 0 disables the job
+	ClusterThreshold          float64                // Minimum tile overlap (Jaccard) for two images to share a cluster
+	ExpirySweepInterval       time.Duration          // How often to delete expired images; 0 disables the sweeper
+	Quotas                    map[string]Quota       // Per-namespace quotas, keyed by the image ID prefix before "/"
+	DefaultQuota              Quota                  // Quota for namespaces without an entry in Quotas
+	NoBackgroundJobs          bool                   // Start no background jobs, whatever their intervals, for short-lived processes such as CLI commands
+	Upstream                  Upstream               // Optional: source of images not held locally, cached on first read
+	SyncPolicy                string                 // When commits sync the WAL: image, batch or none. Default: image
+	SyncInterval              time.Duration          // How long the batch policy groups WAL syncs; 0 uses DefaultSyncInterval
+	BytesPerSync              int                    // Sync sstables in the background every this many bytes; 0 keeps Pebble's default
+	WALBytesPerSync           int                    // Sync the WAL in the background every this many bytes; 0 disables
+	OpenTimeout               time.Duration          // How long to retry while another process holds the database lock; 0 fails at once
+	ReadOnly                  bool                   // Open the database read-only; writes fail with ErrReadOnly
+	MaxWriters                int                    // Image writes processed at once, the rest queue in arrival order; 0 is unlimited
+	MaxQueuedWrites           int                    // Writes allowed to queue before ErrWriteQueueFull; 0 is unbounded
+	ColdStore                 ColdStore              // Optional: backend for tiles of images not retrieved within ColdAfter
+	ColdAfter                 time.Duration          // How long an image may go unretrieved before its tiles move to ColdStore
+	ColdTierInterval          time.Duration          // How often to offload cold tiles; 0 disables the job
+	RetentionPolicies         []RetentionPolicy      // Limits enforced by RunRetention
+	RetentionInterval         time.Duration          // How often to enforce RetentionPolicies; 0 disables the job
+	Background                string                 // #rrggbb padding edge tiles and shown through transparent pixels. Default: black
+	VerifyWrites              bool                   // Rebuild each upload from its pending batch and fail with ErrVerificationFailed unless it matches
+	OnConflict                string                 // What uploads to an existing ID do: overwrite, overwrite-gc, reject or skip-identical. Default: overwrite
+	ChangeLogSize             int                    // Most recent changes kept for Watch and Changes; 0 keeps DefaultChangeLogSize
+	Resources                 Resources              // Limits on concurrent reconstructions and decompressions
+	SlowOperationThreshold    time.Duration          // Log stores and retrievals slower than this; 0 disables the log
+	AlignScroll               bool                   // Offset each upload's tile rows to line up with the previous upload in its namespace after a scroll
+	Transforms                map[string][]Transform // Per-namespace pipelines run on uploads before tiling
+	DefaultTransforms         []Transform            // Pipeline for namespaces without an entry in Transforms
+	Strips                    map[string]int         // Per-namespace strip height; uploads to these namespaces are split into full-width strips
+	TilePools                 string                 // Whether namespaces share tiles: shared or namespace. Fixed once images are stored. Default: shared
+	StatsSnapshotInterval     time.Duration          // How often to check that today's stats snapshot was taken; 0 disables the stats history
+	StatsHistoryDays          int                    // Days of stats snapshots kept; 0 keeps them all
+	MaxDatabaseBytes          int64                  // Writes fail with ErrStorageFull once the database is this large on disk; 0 disables
+	MinFreeDiskPercent        float64                // Writes fail with ErrStorageFull while less of the database volume is free; 0 disables
+	BackupTarget              BackupTarget           // Optional: where Backup uploads archives of the database
+	BackupSchedule            string                 // Cron expression, in UTC, for automatic backups to BackupTarget; empty only backs up on request
+	BackupKeep                int                    // Newest backups kept on BackupTarget; 0 keeps them all
+	MaintenanceBytesPerSecond int64                  // IO budget of garbage collection, recompression and cold tier offload; 0 is unlimited
+	MaintenanceYield          time.Duration          // Longest a maintenance job pauses between chunks while image writes run; 0 never pauses
+	Pebble                    PebbleTuning           // Storage engine options; see PebbleTuning
+	BlobThreshold             int                    // Tile encodings of at least this many bytes go to append-only blob files, leaving a pointer in the database; 0 keeps every tile in the database
+	BlobFileBytes             int64                  // Size at which a new blob file is started; 0 uses DefaultBlobFileBytes
+	BlobGarbageRatio          float64                // Share of a blob file no tile points to before CollectBlobs rewrites it; 0 uses DefaultBlobGarbageRatio
+	BlobGCInterval            time.Duration          // How often to collect blob files; 0 disables the job
+	DisableBlobMmap           bool                   // Read blob files with ReadAt instead of memory-mapping sealed files
+	AutoTileSize              bool                   // Pick 128, 256 or 512 pixel tiles for each upload from its content; manifest uploads and compositions still use TileSize
 }
 
 func DefaultConfig() *Config {
 	return &Config{
-		TileSize:            256,
-		SimilarityThreshold: 0.05, // More conservative: 5% difference threshold
-		DatabasePath:        "./imagestore.db",
+		TileSize:              256,
+		SimilarityThreshold:   0.05, // More conservative: 5% difference threshold
+		DatabasePath:          "./imagestore.db",
+		TrashRetention:        7 * 24 * time.Hour,
+		TrashPurgeInterval:    time.Hour,
+		CompressionLevel:      CompressionDefault,
+		CompactionLevel:       CompressionBest,
+		TileCodecs:            []string{CodecZstd},
+		ClusterThreshold:      0.5,
+		ExpirySweepInterval:   time.Minute,
+		SyncPolicy:            SyncImage,
+		ColdAfter:             30 * 24 * time.Hour,
+		ColdTierInterval:      time.Hour,
+		StatsSnapshotInterval: time.Hour,
+		MaintenanceYield:      time.Second,
+		BlobGCInterval:        time.Hour,
 	}
 }
 
@@ -104,8 +201,32 @@ func GenerateTileID(hash TileHash) TileID {
 	return TileID(hash.String())
 }
 
-// decodeImageFromBytes decodes image data from bytes, supporting PNG and JPEG
+// DecodeImage decodes a PNG or JPEG the way the store does before tiling it,
+// turning it upright according to its EXIF orientation. HEIC and AVIF are
+// decoded when the binary is built with the heif tag.
+func DecodeImage(data []byte) (image.Image, error) {
+	return decodeImageFromBytes(data)
+}
+
+// decodeImageFromBytes decodes image data from bytes, supporting PNG and
+// JPEG, and applies any EXIF orientation
 func decodeImageFromBytes(data []byte) (image.Image, error) {
+	img, err := decodePixels(data)
+	if err != nil {
+		return nil, err
+	}
+	if info := parseSourceInfo(data); info != nil {
+		img = applyOrientation(img, info.Orientation)
+	}
+	return img, nil
+}
+
+// decodePixels decodes image data as stored, ignoring orientation
+func decodePixels(data []byte) (image.Image, error) {
+	if format := heifFormat(data); format != "" {
+		return decodeHEIF(data, format)
+	}
+
 	reader := bytes.NewReader(data)
 
 	// Try to decode as PNG first
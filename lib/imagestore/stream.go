@@ -0,0 +1,295 @@
+package imagestore
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"io"
+	"time"
+)
+
+// StreamImage writes an image to w as a PNG. Images with segmented
+// manifests are reconstructed a tile row at a time from a consistent
+// snapshot and encoded as they go, so memory stays bounded however large
+// the image is. Other images, and images with redactions, are retrieved
+// with RetrieveImageContext and written whole. Nothing is written to w when
+// the image can't be found.
+func (s *PebbleImageStore) StreamImage(ctx context.Context, w io.Writer, id string) error {
+	if err := s.enter(); err != nil {
+		return err
+	}
+	defer s.leave()
+
+	start := time.Now()
+	snapshot := s.db.NewSnapshot()
+	defer snapshot.Close()
+
+	value, closer, err := snapshot.Get(makeKey(imagesBucket, id))
+	if err == nil {
+		defer closer.Close()
+	}
+	if err != nil || !isSegmentedManifest(value) {
+		return s.writeRetrieved(ctx, w, id)
+	}
+	header, _, err := decodeManifestHeader(value)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal image: %w", err)
+	}
+	if len(header.Redactions) > 0 {
+		// Blurred redactions average blocks that can span tile rows
+		return s.writeRetrieved(ctx, w, id)
+	}
+
+	if err := s.reconstructions.acquireContext(ctx); err != nil {
+		return err
+	}
+	defer s.reconstructions.release()
+
+	var iccProfile []byte
+	if header.Source != nil {
+		iccProfile = header.Source.ICCProfile
+	}
+	encoder, err := newPNGStreamWriter(w, header.Width, header.Height, iccProfile)
+	if err != nil {
+		return err
+	}
+
+	storedImage := &header.StoredImage
+	tileWidth, tileHeight := storedImage.tileDims(s.config.TileSize)
+	pix := make([]byte, 4*storedImage.Width*tileHeight)
+	err = forEachManifestSegment(value, func(_ *StoredImage, tileRefs []TileRef) error {
+		tiles, err := s.getTilesFrom(ctx, snapshot, tileRefs)
+		if err != nil {
+			return fmt.Errorf("failed to reconstruct image: %w", err)
+		}
+
+		for len(tileRefs) > 0 {
+			row := tileRefs[:header.Columns]
+			tileRefs = tileRefs[header.Columns:]
+
+			top := row[0].Y*tileHeight - storedImage.GridOffsetY
+			bounds := image.Rect(0, top, storedImage.Width, top+tileHeight).Intersect(image.Rect(0, 0, storedImage.Width, storedImage.Height))
+			if bounds.Empty() {
+				continue
+			}
+			band := &image.RGBA{Pix: pix[:4*bounds.Dx()*bounds.Dy()], Stride: 4 * bounds.Dx(), Rect: bounds}
+			for _, tileRef := range row {
+				tileData := tiles[tileRef.TileID]
+				if !tileRef.Transform.IsIdentity() {
+					tileData = tileRef.Transform.Apply(tileData)
+				}
+				origin := image.Pt(tileRef.X*tileWidth, top)
+				if err := storedImage.placeTile(band, tileData, origin, s.config.TileSize); err != nil {
+					return fmt.Errorf("failed to place tile at (%d, %d): %w", tileRef.X, tileRef.Y, err)
+				}
+			}
+			if err := encoder.writeRows(band); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := encoder.close(); err != nil {
+		return err
+	}
+
+	s.recordAccess(id)
+	s.latency.observe(OperationRetrieve, id, time.Since(start), imageShape(storedImage)+", streamed")
+	return nil
+}
+
+// writeRetrieved writes an image retrieved whole with RetrieveImageContext
+func (s *PebbleImageStore) writeRetrieved(ctx context.Context, w io.Writer, id string) error {
+	data, err := s.RetrieveImageContext(ctx, id)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// pngChunkBytes bounds the IDAT chunks pngStreamWriter writes
+const pngChunkBytes = 64 << 10
+
+// pngStreamWriter encodes an opaque 8-bit RGB PNG from rows written in order,
+// holding only the previous row for filtering
+type pngStreamWriter struct {
+	w        io.Writer
+	zw       *zlib.Writer
+	idat     []byte // Compressed data not yet written as a chunk
+	width    int
+	prev     []byte // Previous unfiltered row, for the Up and Paeth filters
+	current  []byte
+	filtered [5][]byte // Filter type byte and row, by filter type
+	err      error
+}
+
+// newPNGStreamWriter writes the PNG signature and header, and an iCCP chunk
+// when iccProfile is set
+func newPNGStreamWriter(w io.Writer, width, height int, iccProfile []byte) (*pngStreamWriter, error) {
+	p := &pngStreamWriter{
+		w:       w,
+		width:   width,
+		prev:    make([]byte, 3*width),
+		current: make([]byte, 3*width),
+	}
+	for i := range p.filtered {
+		p.filtered[i] = make([]byte, 1+3*width)
+		p.filtered[i][0] = byte(i)
+	}
+
+	if _, err := w.Write(pngSignature); err != nil {
+		return nil, err
+	}
+	var ihdr [13]byte
+	binary.BigEndian.PutUint32(ihdr[0:], uint32(width))
+	binary.BigEndian.PutUint32(ihdr[4:], uint32(height))
+	ihdr[8] = 8 // Bit depth
+	ihdr[9] = 2 // Truecolor
+	if err := p.writeChunk("IHDR", ihdr[:]); err != nil {
+		return nil, err
+	}
+
+	if len(iccProfile) > 0 {
+		var iccp bytes.Buffer
+		iccp.WriteString("ICC Profile\x00\x00")
+		zw := zlib.NewWriter(&iccp)
+		zw.Write(iccProfile)
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		if err := p.writeChunk("iCCP", iccp.Bytes()); err != nil {
+			return nil, err
+		}
+	}
+
+	p.zw = zlib.NewWriter(p)
+	return p, nil
+}
+
+// writeChunk writes one PNG chunk
+func (p *pngStreamWriter) writeChunk(name string, data []byte) error {
+	var header [8]byte
+	binary.BigEndian.PutUint32(header[:4], uint32(len(data)))
+	copy(header[4:], name)
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(data)
+
+	if _, err := p.w.Write(header[:]); err != nil {
+		return err
+	}
+	if _, err := p.w.Write(data); err != nil {
+		return err
+	}
+	_, err := p.w.Write(binary.BigEndian.AppendUint32(nil, crc.Sum32()))
+	return err
+}
+
+// Write collects compressed image data into IDAT chunks
+func (p *pngStreamWriter) Write(data []byte) (int, error) {
+	if p.err != nil {
+		return 0, p.err
+	}
+	p.idat = append(p.idat, data...)
+	for len(p.idat) >= pngChunkBytes {
+		if p.err = p.writeChunk("IDAT", p.idat[:pngChunkBytes]); p.err != nil {
+			return 0, p.err
+		}
+		p.idat = append(p.idat[:0], p.idat[pngChunkBytes:]...)
+	}
+	return len(data), nil
+}
+
+// writeRows filters and compresses every row of band, which must span the
+// image's width and follow the rows written before it
+func (p *pngStreamWriter) writeRows(band *image.RGBA) error {
+	for y := band.Rect.Min.Y; y < band.Rect.Max.Y; y++ {
+		src := band.Pix[(y-band.Rect.Min.Y)*band.Stride:]
+		for x := 0; x < p.width; x++ {
+			copy(p.current[3*x:3*x+3], src[4*x:4*x+3])
+		}
+		if _, err := p.zw.Write(p.bestFilter()); err != nil {
+			return err
+		}
+		p.prev, p.current = p.current, p.prev
+	}
+	return nil
+}
+
+// bestFilter filters the current row every way and returns the one with the
+// smallest sum of absolute values, the heuristic image/png uses
+func (p *pngStreamWriter) bestFilter() []byte {
+	cur, prev := p.current, p.prev
+	best, bestSum := 0, -1
+	for filter, out := range p.filtered {
+		row := out[1:]
+		sum := 0
+		for i := range cur {
+			var left, up, upLeft byte
+			if i >= 3 {
+				left, upLeft = cur[i-3], prev[i-3]
+			}
+			up = prev[i]
+			switch filter {
+			case 0:
+				row[i] = cur[i]
+			case 1:
+				row[i] = cur[i] - left
+			case 2:
+				row[i] = cur[i] - up
+			case 3:
+				row[i] = cur[i] - byte((int(left)+int(up))/2)
+			case 4:
+				row[i] = cur[i] - paeth(left, up, upLeft)
+			}
+			sum += absFiltered(row[i])
+		}
+		if bestSum < 0 || sum < bestSum {
+			best, bestSum = filter, sum
+		}
+	}
+	return p.filtered[best]
+}
+
+// absFiltered is the magnitude of a filtered byte read as signed
+func absFiltered(b byte) int {
+	v := int(int8(b))
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// paeth is the PNG Paeth predictor
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	switch {
+	case pa <= pb && pa <= pc:
+		return a
+	case pb <= pc:
+		return b
+	}
+	return c
+}
+
+// close finishes the compressed data and writes the remaining chunks
+func (p *pngStreamWriter) close() error {
+	if err := p.zw.Close(); err != nil {
+		return err
+	}
+	if len(p.idat) > 0 {
+		if err := p.writeChunk("IDAT", p.idat); err != nil {
+			return err
+		}
+	}
+	return p.writeChunk("IEND", nil)
+}
@@ -0,0 +1,66 @@
+package imagestore
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/draw"
+	"testing"
+)
+
+// decodeRGBA decodes an encoded image into RGBA pixels for comparison
+func decodeRGBA(t *testing.T, data []byte) *image.RGBA {
+	t.Helper()
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to decode image: %v", err)
+	}
+	rgba := image.NewRGBA(img.Bounds())
+	draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Src)
+	return rgba
+}
+
+func TestStreamImage(t *testing.T) {
+	store := newTagsTestStore(t, "small")
+
+	// 67x65 tiles of 4 pixels, more than one segment
+	large, err := encodeImageToPNG(createTestImage(266, 258))
+	if err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+	if err := store.StoreImage("large", large); err != nil {
+		t.Fatalf("failed to store image: %v", err)
+	}
+
+	value, closer, err := store.db.Get(makeKey(imagesBucket, "large"))
+	if err != nil {
+		t.Fatalf("failed to read manifest: %v", err)
+	}
+	segmented := isSegmentedManifest(value)
+	closer.Close()
+	if !segmented {
+		t.Fatal("expected the large image to have a segmented manifest")
+	}
+
+	var streamed bytes.Buffer
+	if err := store.StreamImage(context.Background(), &streamed, "large"); err != nil {
+		t.Fatalf("failed to stream image: %v", err)
+	}
+	if got, want := decodeRGBA(t, streamed.Bytes()), decodeRGBA(t, mustRetrieve(t, store, "large")); !bytes.Equal(got.Pix, want.Pix) || got.Rect != want.Rect {
+		t.Error("streamed image differs from the retrieved image")
+	}
+
+	// Small images are written as retrieved
+	streamed.Reset()
+	if err := store.StreamImage(context.Background(), &streamed, "small"); err != nil {
+		t.Fatalf("failed to stream image: %v", err)
+	}
+	if !bytes.Equal(streamed.Bytes(), mustRetrieve(t, store, "small")) {
+		t.Error("expected a small image written as retrieved")
+	}
+
+	streamed.Reset()
+	if err := store.StreamImage(context.Background(), &streamed, "missing"); err == nil || streamed.Len() != 0 {
+		t.Errorf("expected nothing written for a missing image (%v)", err)
+	}
+}
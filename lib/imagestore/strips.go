@@ -0,0 +1,31 @@
+package imagestore
+
+import "fmt"
+
+// maxStripHeight bounds the height of full-width strips, keeping a strip of
+// a wide screenshot to a few megabytes
+const maxStripHeight = 1024
+
+// ValidateStripHeight checks the strip height configured for a namespace
+func ValidateStripHeight(height int) error {
+	if height <= 0 || height > maxStripHeight {
+		return fmt.Errorf("invalid strip height: must be between 1 and %d, got %d", maxStripHeight, height)
+	}
+	return nil
+}
+
+// validateStrips checks every strip height in config
+func validateStrips(config *Config) error {
+	for namespace, height := range config.Strips {
+		if err := ValidateStripHeight(height); err != nil {
+			return fmt.Errorf("namespace %q: %w", namespace, err)
+		}
+	}
+	return nil
+}
+
+// stripHeightFor returns the strip height uploads to a namespace are split
+// into, or 0 when they are split into square tiles
+func (s *PebbleImageStore) stripHeightFor(namespace string) int {
+	return s.config.Strips[namespace]
+}
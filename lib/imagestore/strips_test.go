@@ -0,0 +1,144 @@
+package imagestore
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"path/filepath"
+	"testing"
+)
+
+func TestStrips(t *testing.T) {
+	config := DefaultConfig()
+	config.DatabasePath = filepath.Join(t.TempDir(), "test.db")
+	config.TileSize = 16
+	config.Strips = map[string]int{"pages": 8}
+
+	store, err := NewPebbleImageStore(config)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	view := scrollingPage(64, 300, 100)
+	first, _ := encodeImageToPNG(view(0))
+	scrolled, _ := encodeImageToPNG(view(24))
+	if err := store.StoreImage("pages/1", first); err != nil {
+		t.Fatalf("failed to store: %v", err)
+	}
+	manifest, _ := store.GetManifest("pages/1")
+	if manifest.StripHeight != 8 || len(manifest.TileRefs) != 13 {
+		t.Fatalf("expected 13 strips 8 pixels tall, got %d of %d", len(manifest.TileRefs), manifest.StripHeight)
+	}
+	for i, tileRef := range manifest.TileRefs {
+		if tileRef.X != 0 || tileRef.Y != i {
+			t.Fatalf("expected strip %d at (0, %d), got (%d, %d)", i, i, tileRef.X, tileRef.Y)
+		}
+	}
+
+	// A scroll by whole strips only adds the strips that scrolled in
+	before := store.GetStorageStats().UniqueTiles
+	if err := store.StoreImage("pages/2", scrolled); err != nil {
+		t.Fatalf("failed to store: %v", err)
+	}
+	if added := store.GetStorageStats().UniqueTiles - before; added > 4 {
+		t.Errorf("expected the shared rows to reuse strips, got %d new strips", added)
+	}
+	img, _ := decodeImageFromBytes(mustRetrieve(t, store, "pages/2"))
+	if !bytes.Equal(toRGBA(img).Pix, view(24).Pix) {
+		t.Error("expected the scrolled page back unchanged")
+	}
+
+	// Other namespaces keep square tiles
+	if err := store.StoreImage("other", first); err != nil {
+		t.Fatalf("failed to store: %v", err)
+	}
+	if manifest, _ := store.GetManifest("other"); manifest.StripHeight != 0 || len(manifest.TileRefs) != 4*7 {
+		t.Errorf("expected 28 square tiles, got %d strips of %d", len(manifest.TileRefs), manifest.StripHeight)
+	}
+
+	// Patches rewrite the strips they touch
+	patch := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for i := 3; i < len(patch.Pix); i += 4 {
+		patch.Pix[i] = 255
+	}
+	patchData, _ := encodeImageToPNG(patch)
+	result, err := store.PatchImage("pages/2", patchData, 10, 6)
+	if err != nil {
+		t.Fatalf("failed to patch: %v", err)
+	}
+	if result.TilesAffected != 2 || result.TilesChanged != 2 {
+		t.Errorf("expected the patch to change two strips, got %+v", result)
+	}
+	img, _ = decodeImageFromBytes(mustRetrieve(t, store, "pages/2"))
+	want := view(24)
+	for y := 6; y < 10; y++ {
+		for x := 10; x < 14; x++ {
+			want.Set(x, y, color.RGBA{A: 255})
+		}
+	}
+	if !bytes.Equal(toRGBA(img).Pix, want.Pix) {
+		t.Error("expected the patch applied at (10, 6)")
+	}
+
+	// Compose reuses whole strips into a strip namespace and renders them
+	// into square tiles elsewhere
+	region := []ComposeRegion{{Source: "pages/1", X: 0, Y: 16, Width: 64, Height: 40}}
+	composed, err := store.ComposeImage("pages/crop", 64, 40, region, StoreOptions{})
+	if err != nil {
+		t.Fatalf("failed to compose: %v", err)
+	}
+	if composed.TilesReused != 5 {
+		t.Errorf("expected all 5 strips reused, got %+v", composed)
+	}
+	if _, err := store.ComposeImage("crop", 64, 40, region, StoreOptions{}); err != nil {
+		t.Fatalf("failed to compose: %v", err)
+	}
+	for _, id := range []string{"pages/crop", "crop"} {
+		img, _ := decodeImageFromBytes(mustRetrieve(t, store, id))
+		if !bytes.Equal(toRGBA(img).Pix, toRGBA(view(0).SubImage(image.Rect(0, 16, 64, 56))).Pix) {
+			t.Errorf("expected %s to match the source", id)
+		}
+	}
+
+	// Strips can be viewed on their own
+	tilePNG, err := store.TilePNG(manifest.TileRefs[0].TileID)
+	if err != nil {
+		t.Fatalf("failed to render strip: %v", err)
+	}
+	if tile, _ := decodeImageFromBytes(tilePNG); tile.Bounds().Dx() != 64 || tile.Bounds().Dy() != 8 {
+		t.Errorf("expected a 64x8 strip, got %v", tile.Bounds())
+	}
+
+	// Reconstruction follows the manifest, not the current configuration
+	store.Close()
+	config.Strips = nil
+	store, err = NewPebbleImageStore(config)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	img, _ = decodeImageFromBytes(mustRetrieve(t, store, "pages/1"))
+	if !bytes.Equal(toRGBA(img).Pix, view(0).Pix) {
+		t.Error("expected strips to reconstruct after the namespace switched to tiles")
+	}
+}
+
+func TestStripManifestValidation(t *testing.T) {
+	manifest := &StoredImage{Width: 64, Height: 20, StripHeight: 8, GridOffsetY: 5}
+	for y := 0; y < 4; y++ {
+		manifest.TileRefs = append(manifest.TileRefs, TileRef{Y: y})
+	}
+	if err := validateTileRefs(manifest, 4); err != nil {
+		t.Errorf("expected the strip manifest to be valid, got %v", err)
+	}
+
+	manifest.TileRefs = manifest.TileRefs[:3]
+	if err := validateTileRefs(manifest, 4); err == nil {
+		t.Error("expected a missing strip to be rejected")
+	}
+
+	manifest.StripHeight = maxStripHeight + 1
+	if err := validateTileRefs(manifest, 4); err == nil {
+		t.Error("expected an oversized strip height to be rejected")
+	}
+}
@@ -0,0 +1,235 @@
+package imagestore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/DataDog/zstd"
+	"github.com/cockroachdb/pebble"
+)
+
+// ManifestDigest summarizes the content of an image manifest. Two stores
+// hold the same image when their digests match, regardless of how each one
+// compressed or deduplicated the tiles.
+func ManifestDigest(storedImage *StoredImage) string {
+	type digestTile struct {
+		X, Y      int
+		TileID    TileID
+		Transform TileTransform
+	}
+
+	tiles := make([]digestTile, len(storedImage.TileRefs))
+	for i, tileRef := range storedImage.TileRefs {
+		tiles[i] = digestTile{tileRef.X, tileRef.Y, tileRef.TileID, tileRef.Transform}
+	}
+
+	data, _ := json.Marshal(struct {
+		Width, Height int
+		Tiles         []digestTile
+		Metadata      map[string]string
+		Tags          []string
+		ExpiresAt     *time.Time
+		GridOffsetY   int `json:",omitempty"`
+		StripHeight   int `json:",omitempty"`
+		TileSize      int `json:",omitempty"`
+	}{storedImage.Width, storedImage.Height, tiles, storedImage.Metadata, storedImage.Tags, storedImage.ExpiresAt, storedImage.GridOffsetY, storedImage.StripHeight, storedImage.TileSize})
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// ManifestDigests returns the digest of every live image, keyed by ID
+func (s *PebbleImageStore) ManifestDigests() (map[string]string, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+
+	prefix := makePrefixKey(imagesBucket)
+	iter, err := s.db.NewIter(&pebble.IterOptions{
+		LowerBound: prefix,
+		UpperBound: prefixUpperBound(prefix),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	digests := make(map[string]string)
+	for iter.First(); iter.Valid(); iter.Next() {
+		var storedImage StoredImage
+		if err := decodeManifest(iter.Value(), &storedImage); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal image: %w", err)
+		}
+		digests[storedImage.ID] = ManifestDigest(&storedImage)
+	}
+
+	return digests, iter.Error()
+}
+
+// GetManifest returns the stored manifest of a live image
+func (s *PebbleImageStore) GetManifest(id string) (*StoredImage, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+
+	return s.getStoredImage(id)
+}
+
+// ExportTile returns a tile's raw RGB data compressed with plain zstd, a
+// transfer format any store can read regardless of its codecs or dictionary
+func (s *PebbleImageStore) ExportTile(tileID TileID) ([]byte, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+
+	data, err := s.getTileData(tileID)
+	if err != nil {
+		return nil, err
+	}
+	return zstd.Compress(nil, data)
+}
+
+// ImportTile stores a tile received in ExportTile's transfer format,
+// recompressing it with this store's codecs. The data must hash to tileID.
+func (s *PebbleImageStore) ImportTile(tileID TileID, payload []byte) error {
+	if err := s.enter(); err != nil {
+		return err
+	}
+	defer s.leave()
+
+	data, err := zstd.Decompress(nil, payload)
+	if err != nil {
+		return fmt.Errorf("invalid tile: failed to decompress %s: %w", tileID, err)
+	}
+	if err := validateStoredTileData(data); err != nil {
+		return fmt.Errorf("invalid tile: %s: %w", tileID, err)
+	}
+	if GenerateTileID(ComputeTileHash(data)) != contentTileID(tileID) {
+		return fmt.Errorf("invalid tile: hash mismatch for %s", tileID)
+	}
+	if err := s.checkDiskSpace(); err != nil {
+		return err
+	}
+
+	compressed, err := s.compressTileData(data)
+	if err != nil {
+		return fmt.Errorf("failed to compress tile %s: %w", tileID, err)
+	}
+	values := [][]byte{compressed}
+	release, err := s.separateBlobs(values)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return s.db.Set(tileKey(tileID), values[0], s.writeOpts)
+}
+
+// ImportManifest stores an image manifest received from another store.
+// Every tile it references must already be present, otherwise a "missing
+// tile" error is returned.
+func (s *PebbleImageStore) ImportManifest(storedImage *StoredImage) error {
+	if err := s.enter(); err != nil {
+		return err
+	}
+	defer s.leave()
+
+	if err := validateTileRefs(storedImage, s.config.TileSize); err != nil {
+		return err
+	}
+
+	s.gcMu.RLock()
+	defer s.gcMu.RUnlock()
+
+	metadata := storedImage.Metadata
+	if metadata == nil {
+		metadata = make(map[string]string)
+	}
+
+	plan := &storePlan{
+		image: &StoredImage{
+			ID:            storedImage.ID,
+			Width:         storedImage.Width,
+			Height:        storedImage.Height,
+			TileRefs:      make([]TileRef, len(storedImage.TileRefs)),
+			Metadata:      metadata,
+			OriginalBytes: storedImage.OriginalBytes,
+			Tags:          storedImage.Tags,
+			ExpiresAt:     storedImage.ExpiresAt,
+			StoredAt:      storedImage.StoredAt,
+			Redactions:    storedImage.Redactions,
+			GridOffsetY:   storedImage.GridOffsetY,
+			StripHeight:   storedImage.StripHeight,
+			TileSize:      storedImage.TileSize,
+		},
+	}
+
+	if plan.image.StoredAt == nil {
+		plan.image.StoredAt = storedNow()
+	}
+
+	snapshot := s.db.NewSnapshot()
+	defer snapshot.Close()
+
+	if err := planPrevious(plan, snapshot); err != nil {
+		return err
+	}
+	if err := s.planTiles(context.Background(), plan, snapshot, storedImage.TileRefs, nil); err != nil {
+		return err
+	}
+
+	return s.commitPlan(plan)
+}
+
+// validateTileRefs checks that a received manifest covers its image with
+// exactly one valid tile reference per grid cell
+func validateTileRefs(storedImage *StoredImage, tileSize int) error {
+	if storedImage.Width <= 0 || storedImage.Height <= 0 {
+		return fmt.Errorf("invalid manifest: image dimensions %dx%d", storedImage.Width, storedImage.Height)
+	}
+
+	if storedImage.StripHeight != 0 {
+		if err := ValidateStripHeight(storedImage.StripHeight); err != nil {
+			return fmt.Errorf("invalid manifest: %w", err)
+		}
+	}
+	if storedImage.TileSize != 0 && !isAutoTileSize(storedImage.TileSize) {
+		return fmt.Errorf("invalid manifest: tile size %d", storedImage.TileSize)
+	}
+
+	_, tileHeight := storedImage.tileDims(tileSize)
+	if storedImage.GridOffsetY < 0 || storedImage.GridOffsetY >= tileHeight {
+		return fmt.Errorf("invalid manifest: grid offset %d outside the %d pixel tile", storedImage.GridOffsetY, tileHeight)
+	}
+
+	// Check each side before multiplying so huge dimensions can't overflow
+	tilesX, tilesY := imageGrid(storedImage, tileSize)
+	refs := len(storedImage.TileRefs)
+	if tilesX > refs || tilesY > refs || tilesX*tilesY != refs {
+		return fmt.Errorf("invalid manifest: %d tile references for a %dx%d image", refs, storedImage.Width, storedImage.Height)
+	}
+
+	covered := make([]bool, refs)
+	for _, tileRef := range storedImage.TileRefs {
+		if tileRef.X < 0 || tileRef.X >= tilesX || tileRef.Y < 0 || tileRef.Y >= tilesY {
+			return fmt.Errorf("invalid manifest: tile position (%d, %d) outside the image", tileRef.X, tileRef.Y)
+		}
+		cell := tileRef.Y*tilesX + tileRef.X
+		if covered[cell] {
+			return fmt.Errorf("invalid manifest: duplicate tile position (%d, %d)", tileRef.X, tileRef.Y)
+		}
+		covered[cell] = true
+
+		if !tileRef.Transform.valid() {
+			return fmt.Errorf("invalid manifest: unknown transform %d", tileRef.Transform)
+		}
+	}
+
+	return nil
+}
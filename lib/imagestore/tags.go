@@ -0,0 +1,185 @@
+package imagestore
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// tagIndexKey builds the inverted index key tags:<tag>\x00<imageID>
+func tagIndexKey(tag, imageID string) []byte {
+	return makeKey(tagsBucket, tag+"\x00"+imageID)
+}
+
+// validateTag rejects tags that would corrupt the index key layout
+func validateTag(tag string) error {
+	if tag == "" {
+		return fmt.Errorf("invalid tag: tag cannot be empty")
+	}
+	if strings.ContainsRune(tag, 0) {
+		return fmt.Errorf("invalid tag: %q contains a NUL byte", tag)
+	}
+	return nil
+}
+
+// indexTags adds inverted index entries for an image's tags to a batch
+func indexTags(batch *pebble.Batch, imageID string, tags []string) error {
+	for _, tag := range tags {
+		if err := batch.Set(tagIndexKey(tag, imageID), nil, pebble.Sync); err != nil {
+			return fmt.Errorf("failed to index tag %s: %w", tag, err)
+		}
+	}
+	return nil
+}
+
+// unindexTags removes inverted index entries for an image's tags from a batch
+func unindexTags(batch *pebble.Batch, imageID string, tags []string) error {
+	for _, tag := range tags {
+		if err := batch.Delete(tagIndexKey(tag, imageID), pebble.Sync); err != nil {
+			return fmt.Errorf("failed to unindex tag %s: %w", tag, err)
+		}
+	}
+	return nil
+}
+
+// AddTags attaches tags to an image
+func (s *PebbleImageStore) AddTags(id string, tags ...string) error {
+	if err := s.enter(); err != nil {
+		return err
+	}
+	defer s.leave()
+
+	for _, tag := range tags {
+		if err := validateTag(tag); err != nil {
+			return err
+		}
+	}
+
+	return s.updateTags(id, func(existing map[string]bool) {
+		for _, tag := range tags {
+			existing[tag] = true
+		}
+	})
+}
+
+// RemoveTags detaches tags from an image
+func (s *PebbleImageStore) RemoveTags(id string, tags ...string) error {
+	if err := s.enter(); err != nil {
+		return err
+	}
+	defer s.leave()
+
+	return s.updateTags(id, func(existing map[string]bool) {
+		for _, tag := range tags {
+			delete(existing, tag)
+		}
+	})
+}
+
+// updateTags rewrites an image's tag set and its index entries atomically
+func (s *PebbleImageStore) updateTags(id string, update func(map[string]bool)) error {
+	// Held from the read through the commit, so a concurrent store or tag
+	// update can't be overwritten with the manifest read here
+	unlock := s.lockVersion(id)
+	defer unlock()
+
+	imageKey := makeKey(imagesBucket, id)
+	imageData, closer, err := s.db.Get(imageKey)
+	if err != nil {
+		return fmt.Errorf("image not found: %s", id)
+	}
+
+	var storedImage StoredImage
+	err = decodeManifest(imageData, &storedImage)
+	closer.Close()
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal image: %w", err)
+	}
+
+	tagSet := make(map[string]bool)
+	for _, tag := range storedImage.Tags {
+		tagSet[tag] = true
+	}
+	update(tagSet)
+
+	previous := storedImage.Tags
+	storedImage.Tags = nil
+	for tag := range tagSet {
+		storedImage.Tags = append(storedImage.Tags, tag)
+	}
+	sort.Strings(storedImage.Tags)
+
+	imageBytes, err := encodeManifest(&storedImage)
+	if err != nil {
+		return fmt.Errorf("failed to marshal image metadata: %w", err)
+	}
+
+	batch := s.db.NewBatch()
+	defer batch.Close()
+
+	if err := unindexTags(batch, id, previous); err != nil {
+		return err
+	}
+	if err := indexTags(batch, id, storedImage.Tags); err != nil {
+		return err
+	}
+	if err := batch.Set(imageKey, imageBytes, pebble.Sync); err != nil {
+		return fmt.Errorf("failed to store image metadata: %w", err)
+	}
+
+	return s.commitChanges(batch, AuditTagsChanged, id)
+}
+
+// ListByTag returns the IDs of all live images carrying a tag
+func (s *PebbleImageStore) ListByTag(tag string) ([]string, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+
+	if err := validateTag(tag); err != nil {
+		return nil, err
+	}
+
+	var imageIDs []string
+
+	prefix := tagIndexKey(tag, "")
+	iter, err := s.db.NewIter(&pebble.IterOptions{
+		LowerBound: prefix,
+		UpperBound: prefixUpperBound(prefix),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	for iter.First(); iter.Valid(); iter.Next() {
+		imageIDs = append(imageIDs, string(iter.Key()[len(prefix):]))
+	}
+
+	return imageIDs, iter.Error()
+}
+
+// DeleteByTag deletes every image carrying a tag, returning how many were
+// deleted
+func (s *PebbleImageStore) DeleteByTag(tag string) (int, error) {
+	if err := s.enter(); err != nil {
+		return 0, err
+	}
+	defer s.leave()
+
+	imageIDs, err := s.ListByTag(tag)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, id := range imageIDs {
+		if err := s.DeleteImage(id); err != nil {
+			return i, fmt.Errorf("failed to delete image %s: %w", id, err)
+		}
+	}
+
+	return len(imageIDs), nil
+}
@@ -0,0 +1,200 @@
+package imagestore
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTagsTestStore(t testing.TB, ids ...string) *PebbleImageStore {
+	t.Helper()
+
+	config := DefaultConfig()
+	config.DatabasePath = filepath.Join(t.TempDir(), "test.db")
+	config.TileSize = 4
+	config.TrashRetention = time.Hour
+
+	store, err := NewPebbleImageStore(config)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	imageData, err := encodeImageToPNG(createTestImage(8, 8))
+	if err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+
+	for _, id := range ids {
+		if err := store.StoreImage(id, imageData); err != nil {
+			t.Fatalf("failed to store image %s: %v", id, err)
+		}
+	}
+
+	return store
+}
+
+func assertTagged(t *testing.T, store *PebbleImageStore, tag string, expected []string) {
+	t.Helper()
+
+	imageIDs, err := store.ListByTag(tag)
+	if err != nil {
+		t.Fatalf("failed to list by tag %s: %v", tag, err)
+	}
+	sort.Strings(imageIDs)
+
+	if len(imageIDs) == 0 && len(expected) == 0 {
+		return
+	}
+	if !reflect.DeepEqual(imageIDs, expected) {
+		t.Errorf("tag %s: expected %v, got %v", tag, expected, imageIDs)
+	}
+}
+
+func TestAddAndRemoveTags(t *testing.T) {
+	store := newTagsTestStore(t, "a", "b", "c")
+
+	if err := store.AddTags("a", "nightly", "chrome"); err != nil {
+		t.Fatalf("failed to add tags: %v", err)
+	}
+	if err := store.AddTags("b", "nightly"); err != nil {
+		t.Fatalf("failed to add tags: %v", err)
+	}
+
+	assertTagged(t, store, "nightly", []string{"a", "b"})
+	assertTagged(t, store, "chrome", []string{"a"})
+
+	if err := store.RemoveTags("a", "nightly"); err != nil {
+		t.Fatalf("failed to remove tags: %v", err)
+	}
+
+	assertTagged(t, store, "nightly", []string{"b"})
+	assertTagged(t, store, "chrome", []string{"a"})
+
+	if err := store.AddTags("missing", "nightly"); err == nil {
+		t.Error("expected error tagging a nonexistent image")
+	}
+	if err := store.AddTags("a", ""); err == nil {
+		t.Error("expected error for empty tag")
+	}
+}
+
+func TestTagsFollowImageLifecycle(t *testing.T) {
+	store := newTagsTestStore(t, "a", "b")
+
+	if err := store.AddTags("a", "nightly"); err != nil {
+		t.Fatalf("failed to add tags: %v", err)
+	}
+	if err := store.AddTags("b", "nightly"); err != nil {
+		t.Fatalf("failed to add tags: %v", err)
+	}
+
+	// Trashed images drop out of tag listings and come back on restore
+	if err := store.DeleteImage("a"); err != nil {
+		t.Fatalf("failed to delete image: %v", err)
+	}
+	assertTagged(t, store, "nightly", []string{"b"})
+
+	if err := store.UndeleteImage("a"); err != nil {
+		t.Fatalf("failed to undelete image: %v", err)
+	}
+	assertTagged(t, store, "nightly", []string{"a", "b"})
+
+	// Overwriting an image clears its tags
+	imageData, err := encodeImageToPNG(createTestImage(4, 4))
+	if err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	if err := store.StoreImage("b", imageData); err != nil {
+		t.Fatalf("failed to overwrite image: %v", err)
+	}
+	assertTagged(t, store, "nightly", []string{"a"})
+}
+
+func TestTagsConcurrentWithStore(t *testing.T) {
+	store := newTagsTestStore(t, "a")
+
+	// Overwrites alternate between sizes, so a stale manifest written back
+	// by a tag update shows in the dimensions
+	var uploads [][]byte
+	for _, size := range []int{4, 8} {
+		imageData, err := encodeImageToPNG(createTestImage(size, size))
+		if err != nil {
+			t.Fatalf("failed to encode test image: %v", err)
+		}
+		uploads = append(uploads, imageData)
+	}
+
+	const rounds = 50
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < rounds; i++ {
+			if err := store.StoreImage("a", uploads[i%2]); err != nil {
+				t.Errorf("failed to store image: %v", err)
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < rounds; i++ {
+			if err := store.AddTags("a", fmt.Sprintf("t%d", i)); err != nil {
+				t.Errorf("failed to add tags: %v", err)
+				return
+			}
+		}
+	}()
+	wg.Wait()
+
+	manifest, err := store.GetManifest("a")
+	if err != nil {
+		t.Fatalf("failed to get manifest: %v", err)
+	}
+	if manifest.Width != 8 {
+		t.Errorf("expected the last upload's 8 pixel width, got %d", manifest.Width)
+	}
+
+	// The index lists the image under exactly the tags its manifest holds
+	for i := 0; i < rounds; i++ {
+		tag := fmt.Sprintf("t%d", i)
+		var expected []string
+		if slices.Contains(manifest.Tags, tag) {
+			expected = []string{"a"}
+		}
+		assertTagged(t, store, tag, expected)
+	}
+}
+
+func TestDeleteByTag(t *testing.T) {
+	store := newTagsTestStore(t, "a", "b", "c")
+
+	for _, id := range []string{"a", "c"} {
+		if err := store.AddTags(id, "nightly"); err != nil {
+			t.Fatalf("failed to add tags: %v", err)
+		}
+	}
+
+	deleted, err := store.DeleteByTag("nightly")
+	if err != nil {
+		t.Fatalf("failed to delete by tag: %v", err)
+	}
+	if deleted != 2 {
+		t.Errorf("expected 2 images deleted, got %d", deleted)
+	}
+
+	images, err := store.ListImages()
+	if err != nil {
+		t.Fatalf("failed to list images: %v", err)
+	}
+	if len(images) != 1 || images[0] != "b" {
+		t.Errorf("expected only [b] to remain, got %v", images)
+	}
+	assertTagged(t, store, "nightly", nil)
+}
@@ -5,15 +5,44 @@ import (
 	"image"
 	"image/color"
 	"math"
+	"strconv"
+	"strings"
 )
 
-// ExtractTiles divides an image into fixed-size tiles
+// ExtractTiles divides an image into fixed-size tiles, padding edge tiles
+// with black
 func ExtractTiles(img image.Image, tileSize int) ([]Tile, []TileRef, error) {
+	return ExtractTilesOver(img, tileSize, color.RGBA{})
+}
+
+// ExtractTilesOver divides an image into fixed-size tiles, compositing
+// transparent pixels over background and padding edge tiles with it. Tiles
+// are RGB, so background's alpha is ignored.
+func ExtractTilesOver(img image.Image, tileSize int, background color.RGBA) ([]Tile, []TileRef, error) {
+	return extractTilesAt(img, tileSize, 0, background)
+}
+
+// extractTilesAt divides an image into tiles whose rows start offsetY
+// pixels above the top of the image, padding the first row with background
+// like the edge tiles
+func extractTilesAt(img image.Image, tileSize, offsetY int, background color.RGBA) ([]Tile, []TileRef, error) {
+	return extractTileGrid(img, tileSize, tileSize, offsetY, background)
+}
+
+// extractStrips divides an image into full-width strips of the given
+// height, starting offsetY pixels above the top of the image
+func extractStrips(img image.Image, stripHeight, offsetY int, background color.RGBA) ([]Tile, []TileRef, error) {
+	return extractTileGrid(img, img.Bounds().Dx(), stripHeight, offsetY, background)
+}
+
+// extractTileGrid divides an image into tileWidth x tileHeight tiles whose
+// rows start offsetY pixels above the top of the image
+func extractTileGrid(img image.Image, tileWidth, tileHeight, offsetY int, background color.RGBA) ([]Tile, []TileRef, error) {
 	bounds := img.Bounds()
 	width, height := bounds.Dx(), bounds.Dy()
 
-	tilesX := int(math.Ceil(float64(width) / float64(tileSize)))
-	tilesY := int(math.Ceil(float64(height) / float64(tileSize)))
+	tilesX := int(math.Ceil(float64(width) / float64(tileWidth)))
+	tilesY := int(math.Ceil(float64(height+offsetY) / float64(tileHeight)))
 
 	var tiles []Tile
 	var tileRefs []TileRef
@@ -21,13 +50,13 @@ func ExtractTiles(img image.Image, tileSize int) ([]Tile, []TileRef, error) {
 	for tileY := 0; tileY < tilesY; tileY++ {
 		for tileX := 0; tileX < tilesX; tileX++ {
 			// Calculate tile boundaries
-			x0 := tileX * tileSize
-			y0 := tileY * tileSize
-			x1 := min(x0+tileSize, width)
-			y1 := min(y0+tileSize, height)
+			x0 := tileX * tileWidth
+			y0 := tileY*tileHeight - offsetY
+			x1 := min(x0+tileWidth, width)
+			y1 := min(y0+tileHeight, height)
 
 			// Extract tile data
-			tileData := extractTileData(img, x0, y0, x1, y1, tileSize)
+			tileData := extractTileRect(img, x0, y0, x1, y1, tileWidth, tileHeight, background)
 
 			// Compute hash and ID
 			hash := ComputeTileHash(tileData)
@@ -55,28 +84,31 @@ func ExtractTiles(img image.Image, tileSize int) ([]Tile, []TileRef, error) {
 	return tiles, tileRefs, nil
 }
 
-// extractTileData extracts RGB data from a tile region, padding if necessary
-func extractTileData(img image.Image, x0, y0, x1, y1, tileSize int) []byte {
-	data := make([]byte, tileSize*tileSize*3)
+// extractTileData extracts RGB data from a tile region, compositing it over
+// background and padding with background where the region is smaller than
+// the tile
+func extractTileData(img image.Image, x0, y0, x1, y1, tileSize int, background color.RGBA) []byte {
+	return extractTileRect(img, x0, y0, x1, y1, tileSize, tileSize, background)
+}
+
+// extractTileRect is extractTileData for tiles that aren't square, such as
+// strips
+func extractTileRect(img image.Image, x0, y0, x1, y1, tileWidth, tileHeight int, background color.RGBA) []byte {
+	data := make([]byte, tileWidth*tileHeight*3)
 
-	for y := 0; y < tileSize; y++ {
-		for x := 0; x < tileSize; x++ {
+	for y := 0; y < tileHeight; y++ {
+		for x := 0; x < tileWidth; x++ {
 			srcX := x0 + x
 			srcY := y0 + y
 
-			var r, g, b uint8
+			r, g, b := background.R, background.G, background.B
 
 			// If within image bounds, get actual pixel
-			if srcX < x1 && srcY < y1 {
-				pixel := img.At(srcX, srcY)
-				rVal, gVal, bVal, _ := pixel.RGBA()
-				r = uint8(rVal >> 8)
-				g = uint8(gVal >> 8)
-				b = uint8(bVal >> 8)
+			if srcX >= 0 && srcY >= 0 && srcX < x1 && srcY < y1 {
+				r, g, b = compositeRGB(img.At(srcX, srcY), background)
 			}
-			// Otherwise, pixel remains (0, 0, 0) for padding
 
-			i := (y*tileSize + x) * 3
+			i := (y*tileWidth + x) * 3
 			data[i] = r
 			data[i+1] = g
 			data[i+2] = b
@@ -86,6 +118,44 @@ func extractTileData(img image.Image, x0, y0, x1, y1, tileSize int) []byte {
 	return data
 }
 
+// compositeRGB flattens a pixel onto an opaque background. Colors are
+// premultiplied, so the background shows through in proportion to the
+// transparency.
+func compositeRGB(c color.Color, background color.RGBA) (r, g, b uint8) {
+	rVal, gVal, bVal, aVal := c.RGBA()
+	rest := 0xffff - aVal
+	r = uint8((rVal + uint32(background.R)*rest/0xff) >> 8)
+	g = uint8((gVal + uint32(background.G)*rest/0xff) >> 8)
+	b = uint8((bVal + uint32(background.B)*rest/0xff) >> 8)
+	return r, g, b
+}
+
+// ParseBackground parses a #rrggbb background color; an empty string is
+// black
+func ParseBackground(value string) (color.RGBA, error) {
+	if value == "" {
+		return color.RGBA{A: 0xff}, nil
+	}
+	hex, ok := strings.CutPrefix(value, "#")
+	if !ok || len(hex) != 6 {
+		return color.RGBA{}, fmt.Errorf("invalid background color %q: expected #rrggbb", value)
+	}
+	rgb, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.RGBA{}, fmt.Errorf("invalid background color %q: expected #rrggbb", value)
+	}
+	return color.RGBA{R: uint8(rgb >> 16), G: uint8(rgb >> 8), B: uint8(rgb), A: 0xff}, nil
+}
+
+// formatBackground formats a background color for a manifest, returning ""
+// for black so existing manifests and the common case stay unchanged
+func formatBackground(background color.RGBA) string {
+	if background.R == 0 && background.G == 0 && background.B == 0 {
+		return ""
+	}
+	return fmt.Sprintf("#%02x%02x%02x", background.R, background.G, background.B)
+}
+
 // ReconstructImage rebuilds an image from tiles
 func ReconstructImage(storedImage *StoredImage, tileSize int, getTileData func(TileID) ([]byte, error)) (image.Image, error) {
 	// Create output image
@@ -99,12 +169,16 @@ func ReconstructImage(storedImage *StoredImage, tileSize int, getTileData func(T
 			return nil, fmt.Errorf("failed to get tile data for %s: %w", tileRef.TileID, err)
 		}
 
+		// Undo any canonicalization applied when the tile was stored
+		if !tileRef.Transform.IsIdentity() {
+			tileData = tileRef.Transform.Apply(tileData)
+		}
+
 		// Calculate tile position in pixels
-		tileX := tileRef.X * tileSize
-		tileY := tileRef.Y * tileSize
+		origin := storedImage.tileOrigin(tileRef, tileSize)
 
 		// Place tile data into image
-		err = placeTileData(img, tileData, tileX, tileY, tileSize, storedImage.Width, storedImage.Height)
+		err = storedImage.placeTile(img, tileData, origin, tileSize)
 		if err != nil {
 			return nil, fmt.Errorf("failed to place tile at (%d, %d): %w", tileRef.X, tileRef.Y, err)
 		}
@@ -113,20 +187,51 @@ func ReconstructImage(storedImage *StoredImage, tileSize int, getTileData func(T
 	return img, nil
 }
 
+// tileDims returns the width and height of a stored image's tiles: squares
+// of the store's tile size, or strips as wide as the image
+func (storedImage *StoredImage) tileDims(tileSize int) (int, int) {
+	if storedImage.StripHeight > 0 {
+		return storedImage.Width, storedImage.StripHeight
+	}
+	if storedImage.TileSize > 0 {
+		return storedImage.TileSize, storedImage.TileSize
+	}
+	return tileSize, tileSize
+}
+
+// tileOrigin returns the pixel position of a tile's top-left corner, which
+// lies above the image for the first row of a grid with an offset
+func (storedImage *StoredImage) tileOrigin(tileRef TileRef, tileSize int) image.Point {
+	tileWidth, tileHeight := storedImage.tileDims(tileSize)
+	return image.Pt(tileRef.X*tileWidth, tileRef.Y*tileHeight-storedImage.GridOffsetY)
+}
+
+// placeTile places one of a stored image's tiles into img at origin
+func (storedImage *StoredImage) placeTile(img *image.RGBA, tileData []byte, origin image.Point, tileSize int) error {
+	tileWidth, tileHeight := storedImage.tileDims(tileSize)
+	return placeTileRect(img, tileData, origin.X, origin.Y, tileWidth, tileHeight, storedImage.Width, storedImage.Height)
+}
+
 // placeTileData places tile data into the image at the specified position
 func placeTileData(img *image.RGBA, tileData []byte, offsetX, offsetY, tileSize, imgWidth, imgHeight int) error {
-	if len(tileData) != tileSize*tileSize*3 {
-		return fmt.Errorf("invalid tile data size: expected %d, got %d", tileSize*tileSize*3, len(tileData))
+	return placeTileRect(img, tileData, offsetX, offsetY, tileSize, tileSize, imgWidth, imgHeight)
+}
+
+// placeTileRect is placeTileData for tiles that aren't square, such as
+// strips
+func placeTileRect(img *image.RGBA, tileData []byte, offsetX, offsetY, tileWidth, tileHeight, imgWidth, imgHeight int) error {
+	if len(tileData) != tileWidth*tileHeight*3 {
+		return fmt.Errorf("invalid tile data size: expected %d, got %d", tileWidth*tileHeight*3, len(tileData))
 	}
 
-	for y := 0; y < tileSize; y++ {
-		for x := 0; x < tileSize; x++ {
+	for y := 0; y < tileHeight; y++ {
+		for x := 0; x < tileWidth; x++ {
 			imgX := offsetX + x
 			imgY := offsetY + y
 
 			// Only place pixels within image bounds
-			if imgX < imgWidth && imgY < imgHeight {
-				i := (y*tileSize + x) * 3
+			if imgX >= 0 && imgY >= 0 && imgX < imgWidth && imgY < imgHeight {
+				i := (y*tileWidth + x) * 3
 				r := tileData[i]
 				g := tileData[i+1]
 				b := tileData[i+2]
@@ -153,6 +258,22 @@ func ValidateTileData(data []byte, tileSize int) error {
 	return nil
 }
 
+// validateStoredTileData checks that data can be a tile or a strip.
+// Strips are as wide as their image, so only that they hold whole pixels is
+// checked here; placing one checks its size against the manifest.
+func validateStoredTileData(data []byte) error {
+	if len(data) == 0 || len(data)%3 != 0 {
+		return fmt.Errorf("invalid tile data size: %d bytes is not a whole number of pixels", len(data))
+	}
+	return nil
+}
+
+// isStripData reports whether tile data is a strip rather than a square
+// tile
+func isStripData(data []byte, tileSize int) bool {
+	return len(data) != tileSize*tileSize*3
+}
+
 func min(a, b int) int {
 	if a < b {
 		return a
@@ -1,8 +1,10 @@
 package imagestore
 
 import (
+	"context"
 	"image"
 	"image/color"
+	"path/filepath"
 	"testing"
 )
 
@@ -76,7 +78,7 @@ func TestExtractTileDataWithPadding(t *testing.T) {
 
 	tileSize := 4
 	// Extract from top-left corner (0,0) to (3,3) but with 4x4 tile size
-	tileData := extractTileData(img, 0, 0, 3, 3, tileSize)
+	tileData := extractTileData(img, 0, 0, 3, 3, tileSize, color.RGBA{})
 
 	expectedSize := tileSize * tileSize * 3
 	if len(tileData) != expectedSize {
@@ -281,3 +283,99 @@ func TestMinFunction(t *testing.T) {
 		}
 	}
 }
+
+func TestExtractTileDataBackground(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	img.Set(0, 0, color.NRGBA{0, 0, 0, 0})     // Fully transparent
+	img.Set(1, 0, color.NRGBA{255, 0, 0, 128}) // Half-transparent red
+	background := color.RGBA{R: 255, G: 255, B: 255, A: 255}
+
+	tileData := extractTileData(img, 0, 0, 2, 1, 2, background)
+
+	expected := []byte{
+		255, 255, 255, // Background shows through
+		255, 127, 127, // Red blended with white
+		255, 255, 255, // Padding
+		255, 255, 255,
+	}
+	for i := range expected {
+		if diff := int(tileData[i]) - int(expected[i]); diff < -1 || diff > 1 {
+			t.Fatalf("expected tile data %v, got %v", expected, tileData)
+		}
+	}
+}
+
+func TestParseBackground(t *testing.T) {
+	background, err := ParseBackground("#20a0ff")
+	if err != nil {
+		t.Fatalf("failed to parse background: %v", err)
+	}
+	if background != (color.RGBA{R: 0x20, G: 0xa0, B: 0xff, A: 0xff}) {
+		t.Errorf("unexpected background %v", background)
+	}
+	if formatBackground(background) != "#20a0ff" {
+		t.Errorf("expected background to round-trip, got %q", formatBackground(background))
+	}
+
+	if background, err := ParseBackground(""); err != nil || formatBackground(background) != "" {
+		t.Errorf("expected empty background to be black, got %v, %v", background, err)
+	}
+	for _, value := range []string{"white", "#fff", "#ffffff00", "#gggggg"} {
+		if _, err := ParseBackground(value); err == nil {
+			t.Errorf("expected %q to be rejected", value)
+		}
+	}
+}
+
+func TestStoreRecordsBackground(t *testing.T) {
+	config := DefaultConfig()
+	config.DatabasePath = filepath.Join(t.TempDir(), "test.db")
+	config.TileSize = 4
+	config.Background = "#ffffff"
+
+	store, err := NewPebbleImageStore(config)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	imageData, err := encodeImageToPNG(createTestImage(6, 6))
+	if err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	if err := store.StoreImage("edge", imageData); err != nil {
+		t.Fatalf("failed to store image: %v", err)
+	}
+
+	storedImage, err := store.getStoredImage("edge")
+	if err != nil {
+		t.Fatalf("failed to get image: %v", err)
+	}
+	if storedImage.Background != "#ffffff" {
+		t.Errorf("expected background to be recorded, got %q", storedImage.Background)
+	}
+
+	// The bottom-right tile holds 2x2 pixels and white padding
+	last := storedImage.TileRefs[len(storedImage.TileRefs)-1]
+	tiles, err := store.getTilesFrom(context.Background(), store.db, []TileRef{last})
+	if err != nil {
+		t.Fatalf("failed to read tile: %v", err)
+	}
+	data := tiles[last.TileID]
+	if i := (3*4 + 3) * 3; data[i] != 255 || data[i+1] != 255 || data[i+2] != 255 {
+		t.Errorf("expected white padding, got RGB(%d,%d,%d)", data[i], data[i+1], data[i+2])
+	}
+
+	// Padding never reaches the reconstructed image
+	retrieved, err := store.RetrieveImage("edge")
+	if err != nil {
+		t.Fatalf("failed to retrieve image: %v", err)
+	}
+	img, err := decodeImageFromBytes(retrieved)
+	if err != nil {
+		t.Fatalf("failed to decode image: %v", err)
+	}
+	if img.Bounds() != image.Rect(0, 0, 6, 6) {
+		t.Errorf("unexpected bounds %v", img.Bounds())
+	}
+}
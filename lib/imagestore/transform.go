@@ -0,0 +1,103 @@
+package imagestore
+
+// TileTransform describes how a stored tile maps back to the tile that
+// appeared in the image: a permutation of the RGB channels, optionally
+// followed by inverting every channel
+type TileTransform uint8
+
+const (
+	transformPermMask TileTransform = 0x07
+	transformInvert   TileTransform = 0x08
+)
+
+// channelPermutations lists every ordering of the RGB channels; index 0 is the
+// identity. Output channel c takes input channel perm[c].
+var channelPermutations = [6][3]int{
+	{0, 1, 2},
+	{0, 2, 1},
+	{1, 0, 2},
+	{1, 2, 0},
+	{2, 0, 1},
+	{2, 1, 0},
+}
+
+// allTileTransforms enumerates every permutation with and without inversion
+func allTileTransforms() []TileTransform {
+	transforms := make([]TileTransform, 0, 2*len(channelPermutations))
+	for _, invert := range []TileTransform{0, transformInvert} {
+		for perm := range channelPermutations {
+			transforms = append(transforms, TileTransform(perm)|invert)
+		}
+	}
+	return transforms
+}
+
+// IsIdentity reports whether the transform leaves tile data unchanged
+func (t TileTransform) IsIdentity() bool {
+	return t == 0
+}
+
+// valid reports whether the transform names a known permutation and no
+// unknown flags
+func (t TileTransform) valid() bool {
+	return int(t&transformPermMask) < len(channelPermutations) && t&^(transformPermMask|transformInvert) == 0
+}
+
+// Apply returns a transformed copy of RGB tile data
+func (t TileTransform) Apply(data []byte) []byte {
+	perm := channelPermutations[t&transformPermMask]
+	invert := t&transformInvert != 0
+
+	out := make([]byte, len(data))
+	for i := 0; i+2 < len(data); i += 3 {
+		for c := 0; c < 3; c++ {
+			v := data[i+perm[c]]
+			if invert {
+				v = 255 - v
+			}
+			out[i+c] = v
+		}
+	}
+	return out
+}
+
+// Inverse returns the transform that undoes t
+func (t TileTransform) Inverse() TileTransform {
+	perm := channelPermutations[t&transformPermMask]
+
+	var inverse [3]int
+	for c, src := range perm {
+		inverse[src] = c
+	}
+
+	for i, candidate := range channelPermutations {
+		if candidate == inverse {
+			return TileTransform(i) | t&transformInvert
+		}
+	}
+	return 0
+}
+
+// canonicalizeTile picks the variant of a tile with the smallest hash among
+// all channel permutations and inversions, so tiles that differ only by such
+// a transform share one stored representation. It returns the canonical tile
+// and the transform that recovers the original from it.
+func canonicalizeTile(tile Tile) (Tile, TileTransform) {
+	canonical := tile
+	var toCanonical TileTransform
+
+	for _, transform := range allTileTransforms() {
+		if transform.IsIdentity() {
+			continue
+		}
+
+		data := transform.Apply(tile.Data)
+		hash := ComputeTileHash(data)
+		if hash.String() < canonical.Hash.String() {
+			canonical = Tile{ID: GenerateTileID(hash), Hash: hash, Data: data}
+			toCanonical = transform
+		}
+	}
+
+	return canonical, toCanonical.Inverse()
+}
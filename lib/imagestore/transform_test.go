@@ -0,0 +1,99 @@
+package imagestore
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"path/filepath"
+	"testing"
+)
+
+func TestTileTransformInverse(t *testing.T) {
+	data := createTestTileData(4)
+
+	for _, transform := range allTileTransforms() {
+		restored := transform.Inverse().Apply(transform.Apply(data))
+		if !bytes.Equal(restored, data) {
+			t.Errorf("transform %#x: inverse did not restore data", transform)
+		}
+	}
+}
+
+func TestCanonicalizeTileSharesVariants(t *testing.T) {
+	data := createTestTileData(4)
+	hash := ComputeTileHash(data)
+	original := Tile{ID: GenerateTileID(hash), Hash: hash, Data: data}
+
+	canonical, recover := canonicalizeTile(original)
+	if !bytes.Equal(recover.Apply(canonical.Data), data) {
+		t.Fatal("recovery transform does not reproduce the original tile")
+	}
+
+	for _, transform := range allTileTransforms() {
+		variantData := transform.Apply(data)
+		variantHash := ComputeTileHash(variantData)
+		variant := Tile{ID: GenerateTileID(variantHash), Hash: variantHash, Data: variantData}
+
+		variantCanonical, variantRecover := canonicalizeTile(variant)
+		if variantCanonical.ID != canonical.ID {
+			t.Errorf("transform %#x: expected canonical ID %s, got %s", transform, canonical.ID, variantCanonical.ID)
+		}
+		if !bytes.Equal(variantRecover.Apply(variantCanonical.Data), variantData) {
+			t.Errorf("transform %#x: recovery transform does not reproduce the variant", transform)
+		}
+	}
+}
+
+func TestStoreInvertedImageSharesTiles(t *testing.T) {
+	config := DefaultConfig()
+	config.DatabasePath = filepath.Join(t.TempDir(), "test.db")
+	config.TileSize = 4
+	config.CanonicalizeTiles = true
+
+	store, err := NewPebbleImageStore(config)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	light := createTestImage(8, 8)
+	dark := image.NewRGBA(light.Bounds())
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			r, g, b, _ := light.At(x, y).RGBA()
+			dark.Set(x, y, color.RGBA{255 - uint8(r>>8), 255 - uint8(g>>8), 255 - uint8(b>>8), 255})
+		}
+	}
+
+	for id, img := range map[string]image.Image{"light": light, "dark": dark} {
+		imageData, err := encodeImageToPNG(img)
+		if err != nil {
+			t.Fatalf("failed to encode %s image: %v", id, err)
+		}
+		if err := store.StoreImage(id, imageData); err != nil {
+			t.Fatalf("failed to store %s image: %v", id, err)
+		}
+	}
+
+	stats := store.GetStorageStats()
+	if stats.UniqueTiles != 4 {
+		t.Errorf("expected inverted image to share all 4 tiles, got %d unique tiles", stats.UniqueTiles)
+	}
+
+	retrievedData, err := store.RetrieveImage("dark")
+	if err != nil {
+		t.Fatalf("failed to retrieve image: %v", err)
+	}
+	retrieved, err := decodeImageFromBytes(retrievedData)
+	if err != nil {
+		t.Fatalf("failed to decode retrieved image: %v", err)
+	}
+
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			if dark.At(x, y) != retrieved.At(x, y) {
+				t.Fatalf("pixel (%d,%d) mismatch after canonicalized round-trip", x, y)
+			}
+		}
+	}
+}
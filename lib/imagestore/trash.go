@@ -0,0 +1,201 @@
+package imagestore
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// trashCommitHook, when set by tests, runs between reading the manifest to
+// trash or restore and committing
+var trashCommitHook func(id string)
+
+// trashImage moves an image manifest from the images bucket to the trash
+// bucket, hiding it from listings while keeping its tiles referenced. The
+// caller must hold the image's version lock from reading the manifest.
+func (s *PebbleImageStore) trashImage(storedImage *StoredImage) error {
+	if trashCommitHook != nil {
+		trashCommitHook(storedImage.ID)
+	}
+
+	now := time.Now().UTC()
+	storedImage.TrashedAt = &now
+
+	trashBytes, err := encodeManifest(storedImage)
+	if err != nil {
+		return fmt.Errorf("failed to marshal trashed image: %w", err)
+	}
+
+	batch := s.db.NewBatch()
+	defer batch.Close()
+
+	err = batch.Set(makeKey(trashBucket, storedImage.ID), trashBytes, pebble.Sync)
+	if err != nil {
+		return fmt.Errorf("failed to trash image: %w", err)
+	}
+
+	err = batch.Delete(makeKey(imagesBucket, storedImage.ID), pebble.Sync)
+	if err != nil {
+		return fmt.Errorf("failed to remove image metadata: %w", err)
+	}
+
+	// Trashed images are hidden from tag listings and search until restored
+	if err := unindexImage(batch, storedImage); err != nil {
+		return err
+	}
+
+	return s.commitChanges(batch, AuditDeleted, storedImage.ID)
+}
+
+// UndeleteImage restores a trashed image so it is visible again
+func (s *PebbleImageStore) UndeleteImage(id string) error {
+	if err := s.enter(); err != nil {
+		return err
+	}
+	defer s.leave()
+
+	// An image stored meanwhile must not be overwritten by the restore
+	unlock := s.lockVersion(id)
+	defer unlock()
+
+	trashKey := makeKey(trashBucket, id)
+	trashData, closer, err := s.db.Get(trashKey)
+	if err != nil {
+		return fmt.Errorf("trashed image not found: %s", id)
+	}
+	defer closer.Close()
+
+	var storedImage StoredImage
+	err = decodeManifest(trashData, &storedImage)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal trashed image: %w", err)
+	}
+
+	imageKey := makeKey(imagesBucket, id)
+	if _, imageCloser, err := s.db.Get(imageKey); err == nil {
+		imageCloser.Close()
+		return fmt.Errorf("image already exists: %s", id)
+	}
+	if trashCommitHook != nil {
+		trashCommitHook(id)
+	}
+
+	storedImage.TrashedAt = nil
+	imageBytes, err := encodeManifest(&storedImage)
+	if err != nil {
+		return fmt.Errorf("failed to marshal image metadata: %w", err)
+	}
+
+	batch := s.db.NewBatch()
+	defer batch.Close()
+
+	err = batch.Set(imageKey, imageBytes, pebble.Sync)
+	if err != nil {
+		return fmt.Errorf("failed to restore image metadata: %w", err)
+	}
+
+	err = batch.Delete(trashKey, pebble.Sync)
+	if err != nil {
+		return fmt.Errorf("failed to remove trashed image: %w", err)
+	}
+
+	if err := indexImage(batch, &storedImage); err != nil {
+		return err
+	}
+
+	// The restored image counts against its namespace's quota again
+	s.quotaUsage.invalidate()
+	return s.commitChanges(batch, AuditRestored, id)
+}
+
+// ListTrash returns the IDs of all trashed images
+func (s *PebbleImageStore) ListTrash() ([]string, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+
+	var imageIDs []string
+
+	prefix := makePrefixKey(trashBucket)
+	iter, err := s.db.NewIter(&pebble.IterOptions{
+		LowerBound: prefix,
+		UpperBound: prefixUpperBound(prefix),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	for iter.First(); iter.Valid(); iter.Next() {
+		imageIDs = append(imageIDs, string(iter.Key()[len(prefix):]))
+	}
+
+	return imageIDs, iter.Error()
+}
+
+// PurgeTrash permanently removes trashed images whose retention window has
+// elapsed, returning how many were purged. Tiles referenced only by purged
+// images become eligible for garbage collection.
+func (s *PebbleImageStore) PurgeTrash() (int, error) {
+	if err := s.enter(); err != nil {
+		return 0, err
+	}
+	defer s.leave()
+
+	cutoff := time.Now().UTC().Add(-s.config.TrashRetention)
+
+	prefix := makePrefixKey(trashBucket)
+	iter, err := s.db.NewIter(&pebble.IterOptions{
+		LowerBound: prefix,
+		UpperBound: prefixUpperBound(prefix),
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer iter.Close()
+
+	batch := s.db.NewBatch()
+	defer batch.Close()
+
+	purged := 0
+	for iter.First(); iter.Valid(); iter.Next() {
+		var storedImage StoredImage
+		if err := decodeManifest(iter.Value(), &storedImage); err != nil {
+			return purged, fmt.Errorf("failed to unmarshal trashed image: %w", err)
+		}
+
+		if storedImage.TrashedAt != nil && storedImage.TrashedAt.After(cutoff) {
+			continue
+		}
+
+		if err := batch.Delete(append([]byte(nil), iter.Key()...), pebble.Sync); err != nil {
+			return purged, fmt.Errorf("failed to purge trashed image %s: %w", storedImage.ID, err)
+		}
+		if err := batch.Delete(annotationKey(storedImage.ID), pebble.Sync); err != nil {
+			return purged, fmt.Errorf("failed to purge annotations of %s: %w", storedImage.ID, err)
+		}
+		purged++
+	}
+	if err := iter.Error(); err != nil {
+		return 0, err
+	}
+
+	if err := batch.Commit(s.writeOpts); err != nil {
+		return 0, fmt.Errorf("failed to commit purge: %w", err)
+	}
+
+	return purged, nil
+}
+
+// purgeExpiredTrash runs PurgeTrash and, when it removed anything, collects
+// the tiles only the purged images used
+func (s *PebbleImageStore) purgeExpiredTrash() error {
+	purged, err := s.PurgeTrash()
+	if err != nil || purged == 0 {
+		return err
+	}
+	_, _, err = s.CollectGarbage()
+	return err
+}
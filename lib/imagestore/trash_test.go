@@ -0,0 +1,251 @@
+package imagestore
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTrashTestStore(t *testing.T, retention time.Duration) *PebbleImageStore {
+	t.Helper()
+
+	config := DefaultConfig()
+	config.DatabasePath = filepath.Join(t.TempDir(), "test.db")
+	config.TileSize = 4
+	config.TrashRetention = retention
+
+	store, err := NewPebbleImageStore(config)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	imageData, err := encodeImageToPNG(createTestImage(8, 8))
+	if err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+
+	if err := store.StoreImage("trash-me", imageData); err != nil {
+		t.Fatalf("failed to store image: %v", err)
+	}
+
+	return store
+}
+
+func TestDeleteImageMovesToTrash(t *testing.T) {
+	store := newTrashTestStore(t, time.Hour)
+
+	if err := store.DeleteImage("trash-me"); err != nil {
+		t.Fatalf("failed to delete image: %v", err)
+	}
+
+	images, err := store.ListImages()
+	if err != nil {
+		t.Fatalf("failed to list images: %v", err)
+	}
+	if len(images) != 0 {
+		t.Errorf("expected trashed image to be hidden from listing, got %v", images)
+	}
+
+	trashed, err := store.ListTrash()
+	if err != nil {
+		t.Fatalf("failed to list trash: %v", err)
+	}
+	if len(trashed) != 1 || trashed[0] != "trash-me" {
+		t.Errorf("expected trash to contain [trash-me], got %v", trashed)
+	}
+
+	if _, err := store.RetrieveImage("trash-me"); err == nil {
+		t.Error("expected error retrieving trashed image")
+	}
+}
+
+func TestUndeleteImage(t *testing.T) {
+	store := newTrashTestStore(t, time.Hour)
+
+	if err := store.DeleteImage("trash-me"); err != nil {
+		t.Fatalf("failed to delete image: %v", err)
+	}
+
+	if err := store.UndeleteImage("trash-me"); err != nil {
+		t.Fatalf("failed to undelete image: %v", err)
+	}
+
+	if _, err := store.RetrieveImage("trash-me"); err != nil {
+		t.Errorf("expected restored image to be retrievable: %v", err)
+	}
+
+	trashed, err := store.ListTrash()
+	if err != nil {
+		t.Fatalf("failed to list trash: %v", err)
+	}
+	if len(trashed) != 0 {
+		t.Errorf("expected empty trash after restore, got %v", trashed)
+	}
+
+	if err := store.UndeleteImage("trash-me"); err == nil {
+		t.Error("expected error undeleting an image that is not trashed")
+	}
+}
+
+func TestPurgeTrash(t *testing.T) {
+	store := newTrashTestStore(t, time.Hour)
+
+	if err := store.DeleteImage("trash-me"); err != nil {
+		t.Fatalf("failed to delete image: %v", err)
+	}
+
+	// Still inside the retention window
+	purged, err := store.PurgeTrash()
+	if err != nil {
+		t.Fatalf("failed to purge trash: %v", err)
+	}
+	if purged != 0 {
+		t.Errorf("expected nothing purged inside retention window, got %d", purged)
+	}
+
+	store.config.TrashRetention = time.Nanosecond
+	time.Sleep(time.Millisecond)
+
+	purged, err = store.PurgeTrash()
+	if err != nil {
+		t.Fatalf("failed to purge trash: %v", err)
+	}
+	if purged != 1 {
+		t.Errorf("expected 1 image purged, got %d", purged)
+	}
+
+	if err := store.UndeleteImage("trash-me"); err == nil {
+		t.Error("expected error undeleting a purged image")
+	}
+}
+
+func TestTrashPurgeJob(t *testing.T) {
+	config := DefaultConfig()
+	config.DatabasePath = filepath.Join(t.TempDir(), "test.db")
+	config.TileSize = 4
+	config.TrashRetention = time.Millisecond
+	config.TrashPurgeInterval = 10 * time.Millisecond
+
+	store, err := NewPebbleImageStore(config)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	imageData, err := encodeImageToPNG(createTestImage(8, 8))
+	if err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	if err := store.StoreImage("trash-me", imageData); err != nil {
+		t.Fatalf("failed to store image: %v", err)
+	}
+	if err := store.DeleteImage("trash-me"); err != nil {
+		t.Fatalf("failed to delete image: %v", err)
+	}
+
+	// The job purges the image and collects its tiles without PurgeTrash
+	// being called
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		trashed, err := store.ListTrash()
+		if err != nil {
+			t.Fatalf("failed to list trash: %v", err)
+		}
+		if len(trashed) == 0 && store.GetStorageStats().UniqueTiles == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the trash purged and its tiles collected, still have %v and %d tiles", trashed, store.GetStorageStats().UniqueTiles)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// raceTrashCommit runs race while a delete or restore sits between its read
+// and its commit, giving race time to finish unless it waits for the image's
+// version lock. It returns once race has finished.
+func raceTrashCommit(t *testing.T, race func()) func() {
+	t.Helper()
+
+	done := make(chan struct{})
+	trashCommitHook = func(string) {
+		trashCommitHook = nil
+		go func() {
+			defer close(done)
+			race()
+		}()
+		time.Sleep(20 * time.Millisecond)
+	}
+	return func() {
+		trashCommitHook = nil
+		<-done
+	}
+}
+
+func TestDeleteConcurrentWithTags(t *testing.T) {
+	store := newTrashTestStore(t, time.Hour)
+
+	// A tag added after the delete read the manifest must not be left in
+	// the index, nor lost from the trashed copy
+	tagged := false
+	wait := raceTrashCommit(t, func() {
+		tagged = store.AddTags("trash-me", "nightly") == nil
+	})
+	if err := store.DeleteImage("trash-me"); err != nil {
+		t.Fatalf("failed to delete image: %v", err)
+	}
+	wait()
+
+	assertTagged(t, store, "nightly", nil)
+	if tagged {
+		t.Error("expected the tag update to wait for the delete and find no image")
+	}
+}
+
+func TestUndeleteConcurrentWithStore(t *testing.T) {
+	store := newTrashTestStore(t, time.Hour)
+	replacement, err := encodeImageToPNG(createTestImage(4, 4))
+	if err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	if err := store.DeleteImage("trash-me"); err != nil {
+		t.Fatalf("failed to delete image: %v", err)
+	}
+
+	// An image stored after the restore checked for one must not be
+	// overwritten by it
+	wait := raceTrashCommit(t, func() {
+		if err := store.StoreImage("trash-me", replacement); err != nil {
+			t.Errorf("failed to store image: %v", err)
+		}
+	})
+	if err := store.UndeleteImage("trash-me"); err != nil {
+		t.Fatalf("failed to undelete image: %v", err)
+	}
+	wait()
+
+	manifest, err := store.GetManifest("trash-me")
+	if err != nil {
+		t.Fatalf("failed to get manifest: %v", err)
+	}
+	if manifest.Width != 4 {
+		t.Errorf("expected the stored 4 pixel image, got width %d", manifest.Width)
+	}
+}
+
+func TestDeleteImageWithoutRetention(t *testing.T) {
+	store := newTrashTestStore(t, 0)
+
+	if err := store.DeleteImage("trash-me"); err != nil {
+		t.Fatalf("failed to delete image: %v", err)
+	}
+
+	trashed, err := store.ListTrash()
+	if err != nil {
+		t.Fatalf("failed to list trash: %v", err)
+	}
+	if len(trashed) != 0 {
+		t.Errorf("expected permanent delete to bypass trash, got %v", trashed)
+	}
+}
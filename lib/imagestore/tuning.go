@@ -0,0 +1,120 @@
+package imagestore
+
+import (
+	"fmt"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// SSTable compression choices for PebbleTuning.SSTableCompression
+const (
+	SSTableCompressionNone   = "none"
+	SSTableCompressionSnappy = "snappy"
+	SSTableCompressionZstd   = "zstd"
+)
+
+// pebbleLevels is the number of LSM levels Pebble uses
+const pebbleLevels = 7
+
+// PebbleTuning overrides Pebble options whose defaults suit small keys and
+// values rather than tiles of several kilobytes. Zero fields keep Pebble's
+// defaults, except SSTableCompression.
+type PebbleTuning struct {
+	CacheBytes                  int64  // Block cache shared by all reads; Pebble's default is 8 MB
+	MemTableBytes               uint64 // Size of each memtable; larger ones flush fewer, bigger L0 files
+	MemTableStopWritesThreshold int    // Queued memtables at which writes stall
+	L0CompactionThreshold       int    // L0 read amplification at which L0 is compacted
+	L0StopWritesThreshold       int    // L0 read amplification at which writes stall
+	LBaseMaxBytes               int64  // Target size of the base level; each lower level is 10 times larger
+	TargetFileBytes             int64  // Target sstable size in L0, doubling at each level below
+	BlockBytes                  int    // Target uncompressed size of sstable data blocks
+	MaxConcurrentCompactions    int    // Compactions allowed to run at once
+	// SSTableCompression compresses sstable blocks: none, snappy or zstd.
+	// Tiles are already zstd-compressed, so compressing blocks again
+	// mostly costs CPU. Default: none
+	SSTableCompression string
+}
+
+// ValidatePebbleTuning checks a PebbleTuning as NewPebbleImageStore would
+func ValidatePebbleTuning(tuning PebbleTuning) error {
+	if tuning.CacheBytes < 0 || tuning.MemTableStopWritesThreshold < 0 || tuning.L0CompactionThreshold < 0 ||
+		tuning.L0StopWritesThreshold < 0 || tuning.LBaseMaxBytes < 0 || tuning.TargetFileBytes < 0 ||
+		tuning.BlockBytes < 0 || tuning.MaxConcurrentCompactions < 0 {
+		return fmt.Errorf("invalid pebble tuning: sizes and thresholds can't be negative")
+	}
+	if tuning.MemTableStopWritesThreshold == 1 {
+		return fmt.Errorf("invalid pebble tuning: memtable stop writes threshold must be at least 2")
+	}
+	if tuning.L0CompactionThreshold > 0 && tuning.L0StopWritesThreshold > 0 && tuning.L0StopWritesThreshold < tuning.L0CompactionThreshold {
+		return fmt.Errorf("invalid pebble tuning: L0 stop writes threshold %d is below the compaction threshold %d",
+			tuning.L0StopWritesThreshold, tuning.L0CompactionThreshold)
+	}
+	if _, err := sstableCompression(tuning.SSTableCompression); err != nil {
+		return err
+	}
+	return nil
+}
+
+// sstableCompression returns the Pebble compression for a
+// PebbleTuning.SSTableCompression
+func sstableCompression(name string) (pebble.Compression, error) {
+	switch name {
+	case "", SSTableCompressionNone:
+		return pebble.NoCompression, nil
+	case SSTableCompressionSnappy:
+		return pebble.SnappyCompression, nil
+	case SSTableCompressionZstd:
+		return pebble.ZstdCompression, nil
+	}
+	return 0, fmt.Errorf("unknown sstable compression: %s", name)
+}
+
+// applyPebbleTuning sets the tuned options. The returned cache, if any, is
+// referenced by options and must be released with Unref once the database
+// is open.
+func applyPebbleTuning(tuning PebbleTuning, options *pebble.Options) (release func(), err error) {
+	if err := ValidatePebbleTuning(tuning); err != nil {
+		return nil, err
+	}
+
+	release = func() {}
+	if tuning.CacheBytes > 0 {
+		cache := pebble.NewCache(tuning.CacheBytes)
+		options.Cache = cache
+		release = cache.Unref
+	}
+	if tuning.MemTableBytes > 0 {
+		options.MemTableSize = tuning.MemTableBytes
+	}
+	if tuning.MemTableStopWritesThreshold > 0 {
+		options.MemTableStopWritesThreshold = tuning.MemTableStopWritesThreshold
+	}
+	if tuning.L0CompactionThreshold > 0 {
+		options.L0CompactionThreshold = tuning.L0CompactionThreshold
+	}
+	if tuning.L0StopWritesThreshold > 0 {
+		options.L0StopWritesThreshold = tuning.L0StopWritesThreshold
+	}
+	if tuning.LBaseMaxBytes > 0 {
+		options.LBaseMaxBytes = tuning.LBaseMaxBytes
+	}
+	if n := tuning.MaxConcurrentCompactions; n > 0 {
+		options.MaxConcurrentCompactions = func() int { return n }
+	}
+
+	compression, _ := sstableCompression(tuning.SSTableCompression)
+	options.Levels = make([]pebble.LevelOptions, pebbleLevels)
+	for i := range options.Levels {
+		level := &options.Levels[i]
+		level.Compression = compression
+		level.BlockSize = tuning.BlockBytes
+		if tuning.TargetFileBytes > 0 {
+			level.TargetFileSize = tuning.TargetFileBytes << i
+		}
+		level.EnsureDefaults()
+		if i > 0 && tuning.TargetFileBytes <= 0 {
+			level.TargetFileSize = options.Levels[i-1].TargetFileSize * 2
+		}
+	}
+	return release, nil
+}
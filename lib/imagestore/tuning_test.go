@@ -0,0 +1,90 @@
+package imagestore
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/cockroachdb/pebble"
+)
+
+func TestPebbleTuning(t *testing.T) {
+	options := &pebble.Options{}
+	release, err := applyPebbleTuning(PebbleTuning{
+		CacheBytes:               64 << 20,
+		MemTableBytes:            32 << 20,
+		L0CompactionThreshold:    8,
+		TargetFileBytes:          8 << 20,
+		MaxConcurrentCompactions: 3,
+	}, options)
+	if err != nil {
+		t.Fatalf("failed to apply tuning: %v", err)
+	}
+	defer release()
+
+	if options.Cache == nil || options.MemTableSize != 32<<20 || options.L0CompactionThreshold != 8 || options.MaxConcurrentCompactions() != 3 {
+		t.Errorf("expected the tuned options to be set, got %+v", options)
+	}
+	if len(options.Levels) != pebbleLevels {
+		t.Fatalf("expected %d levels, got %d", pebbleLevels, len(options.Levels))
+	}
+	for i, level := range options.Levels {
+		if level.Compression != pebble.NoCompression {
+			t.Errorf("expected level %d uncompressed by default, got %v", i, level.Compression)
+		}
+		if level.TargetFileSize != int64(8<<20)<<i {
+			t.Errorf("expected level %d files of %d bytes, got %d", i, int64(8<<20)<<i, level.TargetFileSize)
+		}
+	}
+
+	// Untuned file sizes keep Pebble's defaults
+	options = &pebble.Options{}
+	applyPebbleTuning(PebbleTuning{SSTableCompression: SSTableCompressionZstd}, options)
+	if options.Cache != nil || options.Levels[0].TargetFileSize != 2<<20 || options.Levels[1].TargetFileSize != 4<<20 || options.Levels[0].Compression != pebble.ZstdCompression {
+		t.Errorf("expected Pebble's defaults with zstd blocks, got %+v", options.Levels[:2])
+	}
+
+	for _, tuning := range []PebbleTuning{
+		{SSTableCompression: "lz4"},
+		{CacheBytes: -1},
+		{MemTableStopWritesThreshold: 1},
+		{L0CompactionThreshold: 10, L0StopWritesThreshold: 5},
+	} {
+		if err := ValidatePebbleTuning(tuning); err == nil {
+			t.Errorf("expected %+v to be rejected", tuning)
+		}
+	}
+}
+
+func TestTunedStore(t *testing.T) {
+	config := DefaultConfig()
+	config.DatabasePath = filepath.Join(t.TempDir(), "test.db")
+	config.TileSize = 4
+	config.Pebble = PebbleTuning{CacheBytes: 1 << 20, MemTableBytes: 1 << 20, SSTableCompression: SSTableCompressionSnappy}
+
+	store, err := NewPebbleImageStore(config)
+	if err != nil {
+		t.Fatalf("failed to open tuned store: %v", err)
+	}
+	img, _ := encodeImageToPNG(createTestImage(8, 8))
+	if err := store.StoreImage("a", img); err != nil {
+		t.Fatalf("failed to store: %v", err)
+	}
+	if err := store.db.Flush(); err != nil {
+		t.Fatalf("failed to flush: %v", err)
+	}
+	store.Close()
+
+	// Tuning can change between opens
+	config.Pebble = PebbleTuning{}
+	store, err = NewPebbleImageStore(config)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	original, _ := decodeImageFromBytes(img)
+	retrieved, _ := decodeImageFromBytes(mustRetrieve(t, store, "a"))
+	if !bytes.Equal(toRGBA(original).Pix, toRGBA(retrieved).Pix) {
+		t.Error("expected the image back after reopening untuned")
+	}
+}
@@ -0,0 +1,67 @@
+package imagestore
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Upstream is a read-only source of images a store falls back to when an
+// image isn't held locally, such as the primary instance behind an edge
+// cache. Any PebbleImageStore is an Upstream; remotesync.NewUpstream
+// provides one for a remote server.
+type Upstream interface {
+	// GetManifest returns an image manifest, or an "image not found" error
+	GetManifest(id string) (*StoredImage, error)
+	// ExportTile returns a tile in the transfer format ImportTile accepts
+	ExportTile(tileID TileID) ([]byte, error)
+}
+
+// lookupImage loads a live image manifest, fetching it from the upstream
+// when it isn't held locally
+func (s *PebbleImageStore) lookupImage(id string) (*StoredImage, error) {
+	storedImage, err := s.getStoredImage(id)
+	if err == nil || s.config.Upstream == nil || !strings.Contains(err.Error(), "not found") {
+		return storedImage, err
+	}
+
+	return s.fetchFromUpstream(id)
+}
+
+// fetchFromUpstream copies an image manifest and the tiles this store lacks
+// from the upstream, caching them locally
+func (s *PebbleImageStore) fetchFromUpstream(id string) (*StoredImage, error) {
+	storedImage, err := s.config.Upstream.GetManifest(id)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			return nil, fmt.Errorf("image not found: %s", id)
+		}
+		return nil, fmt.Errorf("failed to fetch %s from upstream: %w", id, err)
+	}
+	if storedImage.ID != id {
+		return nil, fmt.Errorf("upstream returned image %s for %s", storedImage.ID, id)
+	}
+
+	tileIDs := make([]TileID, len(storedImage.TileRefs))
+	for i, tileRef := range storedImage.TileRefs {
+		tileIDs[i] = tileRef.TileID
+	}
+	missing, err := s.MissingTiles(tileIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, tileID := range missing {
+		payload, err := s.config.Upstream.ExportTile(tileID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch tile %s from upstream: %w", tileID, err)
+		}
+		if err := s.ImportTile(tileID, payload); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.ImportManifest(storedImage); err != nil {
+		return nil, fmt.Errorf("failed to cache %s: %w", id, err)
+	}
+	return s.getStoredImage(id)
+}
@@ -0,0 +1,70 @@
+package imagestore
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// countingUpstream records how often an upstream is consulted
+type countingUpstream struct {
+	Upstream
+	manifests, tiles int
+}
+
+func (u *countingUpstream) GetManifest(id string) (*StoredImage, error) {
+	u.manifests++
+	return u.Upstream.GetManifest(id)
+}
+
+func (u *countingUpstream) ExportTile(tileID TileID) ([]byte, error) {
+	u.tiles++
+	return u.Upstream.ExportTile(tileID)
+}
+
+func TestRetrieveImageFromUpstream(t *testing.T) {
+	primary := newTagsTestStore(t, "shared")
+	upstream := &countingUpstream{Upstream: primary}
+
+	config := DefaultConfig()
+	config.DatabasePath = filepath.Join(t.TempDir(), "edge.db")
+	config.TileSize = 4
+	config.Upstream = upstream
+
+	edge, err := NewPebbleImageStore(config)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer edge.Close()
+
+	expected, _ := primary.RetrieveImage("shared")
+	data, err := edge.RetrieveImage("shared")
+	if err != nil {
+		t.Fatalf("failed to retrieve through upstream: %v", err)
+	}
+	if string(data) != string(expected) {
+		t.Error("edge image differs from the upstream image")
+	}
+	if upstream.manifests != 1 || upstream.tiles == 0 {
+		t.Fatalf("expected one manifest and some tiles fetched, got %d and %d", upstream.manifests, upstream.tiles)
+	}
+
+	// The second read is served locally
+	fetched := upstream.tiles
+	if _, err := edge.RetrieveImage("shared"); err != nil {
+		t.Fatalf("failed to retrieve cached image: %v", err)
+	}
+	if upstream.manifests != 1 || upstream.tiles != fetched {
+		t.Error("cached image was fetched from upstream again")
+	}
+
+	images, _ := edge.ListImages()
+	if len(images) != 1 || images[0] != "shared" {
+		t.Errorf("expected cached image to be listed, got %v", images)
+	}
+
+	_, err = edge.RetrieveImage("absent")
+	if err == nil || !strings.Contains(err.Error(), "image not found") {
+		t.Errorf("expected not found for an image missing upstream, got %v", err)
+	}
+}
@@ -0,0 +1,111 @@
+package imagestore
+
+import (
+	"fmt"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// ImageUsage describes the tile storage behind one image. Tiles are shared,
+// so deleting an image only frees its exclusive bytes, not StoredBytes.
+type ImageUsage struct {
+	ID             string
+	Tiles          int   // Tile references, counting repeats
+	DistinctTiles  int   // Distinct tiles referenced
+	StoredBytes    int64 // Stored bytes of the distinct tiles
+	ExclusiveTiles int   // Tiles no other live or trashed image references
+	ExclusiveBytes int64 // Bytes freed if this image alone were deleted
+}
+
+// ImageUsage reports the storage a live image uses and how much deleting
+// it would free. Sharing is found with a scan of every manifest.
+func (s *PebbleImageStore) ImageUsage(id string) (*ImageUsage, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+
+	snapshot := s.db.NewSnapshot()
+	defer snapshot.Close()
+
+	value, closer, err := snapshot.Get(makeKey(imagesBucket, id))
+	if err != nil {
+		return nil, fmt.Errorf("image not found: %s", id)
+	}
+	var storedImage StoredImage
+	err = decodeManifest(value, &storedImage)
+	closer.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal image: %w", err)
+	}
+
+	counts, err := tileImageCounts(snapshot, imagesBucket, trashBucket)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := &ImageUsage{ID: id, Tiles: len(storedImage.TileRefs)}
+	for _, tileID := range distinctTiles(storedImage.TileRefs) {
+		value, closer, err := snapshot.Get(tileKey(tileID))
+		if err != nil {
+			continue // Missing tiles hold no space
+		}
+		size := storedTileSize(value)
+		closer.Close()
+
+		usage.DistinctTiles++
+		usage.StoredBytes += size
+		if counts[tileID] == 1 {
+			usage.ExclusiveTiles++
+			usage.ExclusiveBytes += size
+		}
+	}
+	return usage, nil
+}
+
+// tileImageCounts counts, for every tile, the images in the given manifest
+// buckets that reference it. An image repeating a tile counts once.
+func tileImageCounts(reader pebble.Reader, buckets ...[]byte) (map[TileID]int, error) {
+	counts := make(map[TileID]int)
+
+	for _, bucket := range buckets {
+		prefix := makePrefixKey(bucket)
+		iter, err := reader.NewIter(&pebble.IterOptions{
+			LowerBound: prefix,
+			UpperBound: prefixUpperBound(prefix),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for iter.First(); iter.Valid(); iter.Next() {
+			var storedImage StoredImage
+			if err := decodeManifest(iter.Value(), &storedImage); err != nil {
+				iter.Close()
+				return nil, fmt.Errorf("failed to unmarshal image: %w", err)
+			}
+			for _, tileID := range distinctTiles(storedImage.TileRefs) {
+				counts[tileID]++
+			}
+		}
+
+		if err := iter.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	return counts, nil
+}
+
+// distinctTiles lists the tiles of an image once each, in first-use order
+func distinctTiles(tileRefs []TileRef) []TileID {
+	seen := make(map[TileID]bool, len(tileRefs))
+	tileIDs := make([]TileID, 0, len(tileRefs))
+	for _, tileRef := range tileRefs {
+		if !seen[tileRef.TileID] {
+			seen[tileRef.TileID] = true
+			tileIDs = append(tileIDs, tileRef.TileID)
+		}
+	}
+	return tileIDs
+}
@@ -0,0 +1,50 @@
+package imagestore
+
+import (
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+func TestImageUsage(t *testing.T) {
+	store := newTagsTestStore(t, "shared", "twin")
+
+	// Half of "mixed" repeats the shared tiles, half is its own
+	mixed := image.NewRGBA(image.Rect(0, 0, 8, 16))
+	shared := createTestImage(8, 8)
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 8; x++ {
+			if y < 8 {
+				mixed.Set(x, y, shared.At(x, y))
+			} else {
+				mixed.Set(x, y, color.RGBA{uint8(x * 20), 99, uint8(y * 10), 255})
+			}
+		}
+	}
+	imageData, _ := encodeImageToPNG(mixed)
+	if err := store.StoreImage("mixed", imageData); err != nil {
+		t.Fatalf("failed to store image: %v", err)
+	}
+
+	usage, err := store.ImageUsage("mixed")
+	if err != nil {
+		t.Fatalf("failed to compute usage: %v", err)
+	}
+	if usage.Tiles != 8 || usage.ExclusiveTiles != 4 {
+		t.Errorf("expected 8 tiles with 4 exclusive, got %+v", usage)
+	}
+	if usage.ExclusiveBytes <= 0 || usage.ExclusiveBytes >= usage.StoredBytes {
+		t.Errorf("expected exclusive bytes below stored bytes, got %+v", usage)
+	}
+
+	// A trashed twin still holds the shared tiles
+	store.DeleteImage("twin")
+	if usage, _ := store.ImageUsage("shared"); usage.ExclusiveTiles != 0 {
+		t.Errorf("expected no exclusive tiles while shared with the trash, got %+v", usage)
+	}
+
+	if _, err := store.ImageUsage("missing"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("expected not found, got %v", err)
+	}
+}
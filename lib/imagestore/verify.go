@@ -0,0 +1,51 @@
+package imagestore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// ErrVerificationFailed is returned when Config.VerifyWrites is set and an
+// image rebuilt from its pending batch differs from the upload. Nothing is
+// written.
+var ErrVerificationFailed = errors.New("stored image failed verification")
+
+// verifyPlan rebuilds a planned image from reader, which must see the plan's
+// new tiles, and compares it pixel for pixel with the decoded upload
+func (s *PebbleImageStore) verifyPlan(reader pebble.Reader, plan *storePlan) error {
+	tiles, err := s.getTilesFrom(context.Background(), reader, plan.image.TileRefs)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrVerificationFailed, err)
+	}
+	img, err := ReconstructImage(plan.image, s.config.TileSize, prefetchedTiles(tiles))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrVerificationFailed, err)
+	}
+
+	source := plan.source.Bounds()
+	if img.Bounds().Size() != source.Size() {
+		return fmt.Errorf("%w: rebuilt %v, uploaded %v", ErrVerificationFailed, img.Bounds().Size(), source.Size())
+	}
+
+	// Uploads are compared as tiled: flattened onto the background
+	background, err := ParseBackground(plan.image.Background)
+	if err != nil {
+		return err
+	}
+	rebuilt := img.(*image.RGBA)
+	for y := 0; y < source.Dy(); y++ {
+		for x := 0; x < source.Dx(); x++ {
+			r, g, b := compositeRGB(plan.source.At(source.Min.X+x, source.Min.Y+y), background)
+			got := rebuilt.RGBAAt(x, y)
+			if got.R != r || got.G != g || got.B != b {
+				return fmt.Errorf("%w: pixel (%d, %d) is RGB(%d,%d,%d), uploaded RGB(%d,%d,%d)",
+					ErrVerificationFailed, x, y, got.R, got.G, got.B, r, g, b)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package imagestore
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"path/filepath"
+	"testing"
+)
+
+func newVerifyTestStore(t *testing.T) *PebbleImageStore {
+	t.Helper()
+
+	config := DefaultConfig()
+	config.DatabasePath = filepath.Join(t.TempDir(), "test.db")
+	config.TileSize = 4
+	config.VerifyWrites = true
+
+	store, err := NewPebbleImageStore(config)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestVerifyWritesAcceptsLosslessStore(t *testing.T) {
+	store := newVerifyTestStore(t)
+
+	// Edge tiles and translucent pixels are flattened the same way on both
+	// sides of the comparison
+	img := image.NewNRGBA(image.Rect(0, 0, 7, 5))
+	for y := 0; y < 5; y++ {
+		for x := 0; x < 7; x++ {
+			img.Set(x, y, color.NRGBA{uint8(x * 36), uint8(y * 50), 90, uint8(255 - x*20)})
+		}
+	}
+	imageData, err := encodeImageToPNG(img)
+	if err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+
+	if err := store.StoreImage("first", imageData); err != nil {
+		t.Fatalf("expected verified store to succeed: %v", err)
+	}
+	if err := store.StoreImage("second", imageData); err != nil {
+		t.Fatalf("expected deduplicated store to verify: %v", err)
+	}
+}
+
+func TestVerifyWritesRejectsCorruptTile(t *testing.T) {
+	store := newVerifyTestStore(t)
+
+	imageData, err := encodeImageToPNG(createTestImage(8, 8))
+	if err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+	if err := store.StoreImage("original", imageData); err != nil {
+		t.Fatalf("failed to store image: %v", err)
+	}
+
+	// Replace a stored tile's content behind its ID, as a bad disk might
+	storedImage, err := store.getStoredImage("original")
+	if err != nil {
+		t.Fatalf("failed to get image: %v", err)
+	}
+	compressed, err := store.compressTileData(CreateEmptyTile(4))
+	if err != nil {
+		t.Fatalf("failed to compress tile: %v", err)
+	}
+	if err := store.db.Set(tileKey(storedImage.TileRefs[0].TileID), compressed, nil); err != nil {
+		t.Fatalf("failed to corrupt tile: %v", err)
+	}
+
+	// A copy deduplicates against the corrupt tile and must not be committed
+	err = store.StoreImage("copy", imageData)
+	if !errors.Is(err, ErrVerificationFailed) {
+		t.Fatalf("expected ErrVerificationFailed, got %v", err)
+	}
+	if _, err := store.getStoredImage("copy"); err == nil {
+		t.Error("expected the failed upload to leave no manifest")
+	}
+}
@@ -0,0 +1,148 @@
+package imagestore
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrWriteQueueFull is returned when Config.MaxQueuedWrites writes are
+// already waiting; the caller should retry later
+var ErrWriteQueueFull = errors.New("write queue is full")
+
+// WriteQueueStats describes the write queue. Wait times cover only the time
+// a write spent queued, not the write itself.
+type WriteQueueStats struct {
+	MaxWriters  int           // Writes allowed to run at once; 0 when the queue is disabled
+	MaxQueued   int           // Writes allowed to wait; 0 is unbounded
+	Active      int           // Writes running now
+	Queued      int           // Writes waiting now
+	Admitted    int64         // Writes that have started
+	Rejected    int64         // Writes refused with ErrWriteQueueFull
+	TotalWait   time.Duration // Time admitted writes spent queued
+	MaxWait     time.Duration // Longest time a write spent queued
+	LastWait    time.Duration // Time the most recent write spent queued
+	AverageWait time.Duration // TotalWait divided by Admitted
+}
+
+// writeQueue admits image writes in arrival order, letting at most a fixed
+// number run at once. Planning a store compresses every new tile, so
+// unbounded concurrent uploads compete for CPU and memory and all finish
+// late; queueing them keeps latency predictable and lets callers shed load
+// once the queue is full.
+type writeQueue struct {
+	mu      sync.Mutex
+	waiting []chan struct{} // FIFO; a slot is handed over by closing the channel
+	stats   WriteQueueStats
+}
+
+// newWriteQueue returns a queue for the config, or nil when writes are
+// unlimited
+func newWriteQueue(config *Config) *writeQueue {
+	if config.MaxWriters <= 0 {
+		return nil
+	}
+	return &writeQueue{stats: WriteQueueStats{MaxWriters: config.MaxWriters, MaxQueued: config.MaxQueuedWrites}}
+}
+
+// acquire waits for a write slot, failing with ErrWriteQueueFull when the
+// queue is at capacity. A nil queue admits every write at once.
+func (q *writeQueue) acquire() error {
+	return q.acquireContext(context.Background())
+}
+
+// acquireContext is acquire, giving up the place in the queue with ctx's
+// error once ctx is done
+func (q *writeQueue) acquireContext(ctx context.Context) error {
+	if q == nil {
+		return nil
+	}
+
+	q.mu.Lock()
+	if q.stats.Active < q.stats.MaxWriters && len(q.waiting) == 0 {
+		q.stats.Active++
+		q.admitted(0)
+		q.mu.Unlock()
+		return nil
+	}
+	if q.stats.MaxQueued > 0 && len(q.waiting) >= q.stats.MaxQueued {
+		q.stats.Rejected++
+		q.mu.Unlock()
+		return ErrWriteQueueFull
+	}
+
+	ready := make(chan struct{})
+	q.waiting = append(q.waiting, ready)
+	q.stats.Queued = len(q.waiting)
+	q.mu.Unlock()
+
+	start := time.Now()
+	select {
+	case <-ready:
+	case <-ctx.Done():
+		q.mu.Lock()
+		for i, waiting := range q.waiting {
+			if waiting == ready {
+				q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+				q.stats.Queued = len(q.waiting)
+				q.mu.Unlock()
+				return ctx.Err()
+			}
+		}
+		q.mu.Unlock()
+		// The slot was handed over as we gave up; pass it on
+		q.release()
+		return ctx.Err()
+	}
+
+	q.mu.Lock()
+	q.admitted(time.Since(start))
+	q.mu.Unlock()
+	return nil
+}
+
+// release frees a write slot, handing it straight to the longest waiting
+// write so later arrivals can't overtake it
+func (q *writeQueue) release() {
+	if q == nil {
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.waiting) > 0 {
+		close(q.waiting[0])
+		q.waiting = q.waiting[1:]
+		q.stats.Queued = len(q.waiting)
+		return
+	}
+	q.stats.Active--
+}
+
+// admitted records a write leaving the queue; the caller holds mu
+func (q *writeQueue) admitted(wait time.Duration) {
+	q.stats.Admitted++
+	q.stats.TotalWait += wait
+	q.stats.LastWait = wait
+	if wait > q.stats.MaxWait {
+		q.stats.MaxWait = wait
+	}
+}
+
+// snapshot returns the current queue statistics
+func (q *writeQueue) snapshot() WriteQueueStats {
+	if q == nil {
+		return WriteQueueStats{}
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	stats := q.stats
+	if stats.Admitted > 0 {
+		stats.AverageWait = stats.TotalWait / time.Duration(stats.Admitted)
+	}
+	return stats
+}
@@ -0,0 +1,108 @@
+package imagestore
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWriteQueueOrderAndBackPressure(t *testing.T) {
+	queue := newWriteQueue(&Config{MaxWriters: 1, MaxQueuedWrites: 2})
+
+	if err := queue.acquire(); err != nil {
+		t.Fatalf("failed to acquire free slot: %v", err)
+	}
+
+	order := make(chan int, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			if err := queue.acquire(); err != nil {
+				t.Errorf("writer %d: %v", i, err)
+				return
+			}
+			order <- i
+			queue.release()
+		}()
+
+		// Wait until the writer is queued so arrival order is fixed
+		for queue.snapshot().Queued != i+1 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	if err := queue.acquire(); !errors.Is(err, ErrWriteQueueFull) {
+		t.Errorf("expected ErrWriteQueueFull with a full queue, got %v", err)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	queue.release()
+	for expected := 0; expected < 2; expected++ {
+		if got := <-order; got != expected {
+			t.Errorf("expected writer %d to run next, got %d", expected, got)
+		}
+	}
+
+	stats := queue.snapshot()
+	if stats.Admitted != 3 || stats.Rejected != 1 || stats.Active != 0 || stats.Queued != 0 {
+		t.Errorf("unexpected queue stats: %+v", stats)
+	}
+	if stats.MaxWait < 10*time.Millisecond || stats.AverageWait <= 0 {
+		t.Errorf("expected queued writers to record their wait, got %+v", stats)
+	}
+}
+
+func TestStoreWithWriteQueue(t *testing.T) {
+	config := DefaultConfig()
+	config.DatabasePath = filepath.Join(t.TempDir(), "test.db")
+	config.TileSize = 4
+	config.MaxWriters = 1
+
+	store, err := NewPebbleImageStore(config)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	imageData, _ := encodeImageToPNG(createTestImage(8, 8))
+	errs := make(chan error, 4)
+	for i := 0; i < cap(errs); i++ {
+		go func() { errs <- store.StoreImage(string(rune('a'+i)), imageData) }()
+	}
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("failed to store image: %v", err)
+		}
+	}
+
+	stats := store.GetStorageStats()
+	if stats.TotalImages != 4 || stats.WriteQueue.Admitted != 4 || stats.WriteQueue.MaxWriters != 1 {
+		t.Errorf("unexpected stats after queued writes: %d images, queue %+v", stats.TotalImages, stats.WriteQueue)
+	}
+}
+
+func TestWriteQueueCancelledWait(t *testing.T) {
+	queue := newWriteQueue(&Config{MaxWriters: 1})
+	if err := queue.acquire(); err != nil {
+		t.Fatalf("failed to acquire free slot: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := queue.acquireContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the queued write to give up at its deadline, got %v", err)
+	}
+	if stats := queue.snapshot(); stats.Queued != 0 {
+		t.Errorf("expected the abandoned write to leave the queue, got %+v", stats)
+	}
+
+	// The slot goes back to the pool rather than to the abandoned writer
+	queue.release()
+	if err := queue.acquireContext(context.Background()); err != nil {
+		t.Errorf("failed to acquire released slot: %v", err)
+	}
+	if stats := queue.snapshot(); stats.Active != 1 {
+		t.Errorf("expected one active writer, got %+v", stats)
+	}
+}
@@ -0,0 +1,153 @@
+package pdfimport
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Rasterizer renders the pages of a PDF as PNG images
+type Rasterizer interface {
+	// Rasterize calls fn for each page in order, numbered from 1, stopping
+	// after maxPages
+	Rasterize(ctx context.Context, pdf []byte, dpi, maxPages int, fn func(page int, png []byte) error) error
+}
+
+// Store is the subset of the image store the importer needs
+type Store interface {
+	StoreImage(id string, imageData []byte) error
+	SetMetadata(id string, metadata map[string]string) error
+}
+
+// Result lists the images an import stored, one per page
+type Result struct {
+	Pages []string `json:"pages"`
+}
+
+// Importer stores each page of a PDF as an image. Documents tend to repeat
+// headers, footers and letterheads on every page, which the store keeps once.
+type Importer struct {
+	store      Store
+	rasterizer Rasterizer
+	dpi        int
+	maxPages   int
+}
+
+// NewImporter creates an importer rendering pages at dpi and storing at most
+// maxPages of each document
+func NewImporter(store Store, rasterizer Rasterizer, dpi, maxPages int) *Importer {
+	return &Importer{store: store, rasterizer: rasterizer, dpi: dpi, maxPages: maxPages}
+}
+
+// PageID returns the image ID of a page of an imported PDF
+func PageID(id string, page int) string {
+	return fmt.Sprintf("%s/pages/%d", id, page)
+}
+
+// Import rasterizes a PDF and stores page n as PageID(id, n), recording the
+// document and page number in each page's metadata
+func (i *Importer) Import(ctx context.Context, id string, pdf []byte) (*Result, error) {
+	if !bytes.HasPrefix(pdf, []byte("%PDF-")) {
+		return nil, fmt.Errorf("invalid PDF: missing %%PDF header")
+	}
+
+	result := &Result{Pages: []string{}}
+	err := i.rasterizer.Rasterize(ctx, pdf, i.dpi, i.maxPages, func(page int, png []byte) error {
+		pageID := PageID(id, page)
+		if err := i.store.StoreImage(pageID, png); err != nil {
+			return fmt.Errorf("failed to store page %d: %w", page, err)
+		}
+		if err := i.store.SetMetadata(pageID, map[string]string{"pdf": id, "page": strconv.Itoa(page)}); err != nil {
+			return fmt.Errorf("failed to tag page %d: %w", page, err)
+		}
+		result.Pages = append(result.Pages, pageID)
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+	if len(result.Pages) == 0 {
+		return result, fmt.Errorf("invalid PDF: no pages rendered")
+	}
+	return result, nil
+}
+
+// Pdftoppm rasterizes with the pdftoppm command from Poppler
+type Pdftoppm struct {
+	Path string // Command to run; "pdftoppm" looks it up on PATH
+}
+
+// NewPdftoppm returns a rasterizer running the given pdftoppm command
+func NewPdftoppm(path string) *Pdftoppm {
+	if path == "" {
+		path = "pdftoppm"
+	}
+	return &Pdftoppm{Path: path}
+}
+
+// Rasterize renders the pages into a temporary directory, then passes them
+// to fn in page order
+func (p *Pdftoppm) Rasterize(ctx context.Context, pdf []byte, dpi, maxPages int, fn func(page int, png []byte) error) error {
+	dir, err := os.MkdirTemp("", "pdfimport-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	input := filepath.Join(dir, "input.pdf")
+	if err := os.WriteFile(input, pdf, 0600); err != nil {
+		return err
+	}
+
+	args := []string{"-png", "-r", strconv.Itoa(dpi)}
+	if maxPages > 0 {
+		args = append(args, "-l", strconv.Itoa(maxPages))
+	}
+	args = append(args, input, filepath.Join(dir, "page"))
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.Path, args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("invalid PDF: %s failed: %v: %s", p.Path, err, strings.TrimSpace(stderr.String()))
+	}
+
+	// Output files are named page-N.png, with N zero-padded to the width of
+	// the page count
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	pages := make(map[int]string)
+	var numbers []int
+	for _, entry := range entries {
+		name, ok := strings.CutPrefix(entry.Name(), "page-")
+		if !ok {
+			continue
+		}
+		number, err := strconv.Atoi(strings.TrimSuffix(name, ".png"))
+		if err != nil {
+			continue
+		}
+		pages[number] = filepath.Join(dir, entry.Name())
+		numbers = append(numbers, number)
+	}
+	sort.Ints(numbers)
+
+	for _, number := range numbers {
+		data, err := os.ReadFile(pages[number])
+		if err != nil {
+			return err
+		}
+		if err := fn(number, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package pdfimport
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gordyf/imageencoder/lib/imagestore"
+)
+
+// fakeRasterizer renders each page as an image with a shared header band
+type fakeRasterizer struct {
+	pages   int
+	lastDPI int
+}
+
+func (f *fakeRasterizer) Rasterize(ctx context.Context, pdf []byte, dpi, maxPages int, fn func(page int, png []byte) error) error {
+	f.lastDPI = dpi
+	for page := 1; page <= f.pages && page <= maxPages; page++ {
+		data, err := encodePage(page)
+		if err != nil {
+			return err
+		}
+		if err := fn(page, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodePage draws a page whose top half is identical on every page
+func encodePage(page int) ([]byte, error) {
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			c := color.RGBA{uint8(x * 30), uint8(y * 30), 200, 255}
+			if y >= 4 {
+				c = color.RGBA{uint8(page * 40), uint8(x * 30), uint8(y * 30), 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func newTestStore(t *testing.T) *imagestore.PebbleImageStore {
+	t.Helper()
+
+	config := imagestore.DefaultConfig()
+	config.DatabasePath = filepath.Join(t.TempDir(), "test.db")
+	config.TileSize = 4
+
+	store, err := imagestore.NewPebbleImageStore(config)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	return store
+}
+
+func TestImportStoresEachPage(t *testing.T) {
+	store := newTestStore(t)
+	rasterizer := &fakeRasterizer{pages: 5}
+	importer := NewImporter(store, rasterizer, 150, 3)
+
+	result, err := importer.Import(context.Background(), "contract", []byte("%PDF-1.7\n..."))
+	if err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+	if rasterizer.lastDPI != 150 {
+		t.Errorf("expected pages rendered at 150 DPI, got %d", rasterizer.lastDPI)
+	}
+
+	expected := []string{"contract/pages/1", "contract/pages/2", "contract/pages/3"}
+	if strings.Join(result.Pages, ",") != strings.Join(expected, ",") {
+		t.Fatalf("expected pages %v, got %v", expected, result.Pages)
+	}
+
+	for i, id := range result.Pages {
+		metadata, err := store.GetMetadata(id)
+		if err != nil {
+			t.Fatalf("failed to get metadata for %s: %v", id, err)
+		}
+		if metadata["pdf"] != "contract" || metadata["page"] != fmt.Sprint(i+1) {
+			t.Errorf("unexpected metadata for %s: %v", id, metadata)
+		}
+	}
+
+	// The header half of every page is the same two tiles
+	if stats := store.GetStorageStats(); stats.UniqueTiles != 2+3*2 {
+		t.Errorf("expected shared header tiles to be stored once, got %d unique tiles", stats.UniqueTiles)
+	}
+}
+
+func TestImportRejectsNonPDF(t *testing.T) {
+	importer := NewImporter(newTestStore(t), &fakeRasterizer{pages: 1}, 150, 10)
+
+	if _, err := importer.Import(context.Background(), "doc", []byte("\x89PNG")); err == nil || !strings.Contains(err.Error(), "invalid PDF") {
+		t.Errorf("expected an invalid PDF error, got %v", err)
+	}
+	if _, err := NewImporter(newTestStore(t), &fakeRasterizer{}, 150, 10).Import(context.Background(), "doc", []byte("%PDF-1.4")); err == nil {
+		t.Error("expected an error for a PDF with no pages")
+	}
+}
+
+func TestPdftoppmReadsPagesInOrder(t *testing.T) {
+	dir := t.TempDir()
+	pages := make([][]byte, 11)
+	for i := range pages {
+		data, err := encodePage(i + 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages[i] = data
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("%02d.png", i+1)), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A stand-in for pdftoppm that copies the prepared pages to the output
+	// prefix, zero-padded the way pdftoppm pads them
+	script := filepath.Join(dir, "pdftoppm")
+	body := fmt.Sprintf("#!/bin/sh\nfor prefix; do :; done\necho \"$@\" > %s/args\nfor f in %s/*.png; do cp \"$f\" \"$prefix-$(basename \"$f\")\"; done\n", dir, dir)
+	if err := os.WriteFile(script, []byte(body), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []int
+	err := NewPdftoppm(script).Rasterize(context.Background(), []byte("%PDF-1.7"), 200, 20, func(page int, data []byte) error {
+		got = append(got, page)
+		if !bytes.Equal(data, pages[page-1]) {
+			t.Errorf("page %d has the wrong content", page)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("rasterize failed: %v", err)
+	}
+	if len(got) != 11 || got[0] != 1 || got[10] != 11 {
+		t.Errorf("expected pages 1 to 11 in order, got %v", got)
+	}
+
+	args, err := os.ReadFile(filepath.Join(dir, "args"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(args), "-png -r 200 -l 20 ") {
+		t.Errorf("unexpected pdftoppm arguments: %s", args)
+	}
+}
@@ -0,0 +1,334 @@
+package remotesync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/gordyf/imageencoder/lib/imagestore"
+)
+
+// Direction selects which way images are transferred
+type Direction string
+
+const (
+	Push Direction = "push" // Local images overwrite remote ones
+	Pull Direction = "pull" // Remote images overwrite local ones
+	Both Direction = "both" // Copy images missing on either side; report conflicts
+)
+
+// ParseDirection validates a direction name
+func ParseDirection(direction string) (Direction, error) {
+	switch Direction(direction) {
+	case Push, Pull, Both:
+		return Direction(direction), nil
+	}
+	return "", fmt.Errorf("invalid sync direction: %s", direction)
+}
+
+// Store is the subset of the local image store a sync needs
+type Store interface {
+	TileSize() int
+	ManifestDigests() (map[string]string, error)
+	GetManifest(id string) (*imagestore.StoredImage, error)
+	ImportManifest(storedImage *imagestore.StoredImage) error
+	MissingTiles(tileIDs []imagestore.TileID) ([]imagestore.TileID, error)
+	ExportTile(tileID imagestore.TileID) ([]byte, error)
+	ImportTile(tileID imagestore.TileID, payload []byte) error
+}
+
+// Result summarizes a sync run
+type Result struct {
+	ImagesPushed  int
+	ImagesPulled  int
+	TilesPushed   int
+	TilesPulled   int
+	BytesSent     int64
+	BytesReceived int64
+	Conflicts     []string // Images that differ on both sides in Both mode
+}
+
+// Syncer transfers images between a local store and a remote instance.
+// Tiles are written before the manifests that reference them, so an
+// interrupted sync resumes where it left off when run again.
+type Syncer struct {
+	store      Store
+	remote     string
+	HTTPClient *http.Client
+}
+
+// New creates a syncer for a remote instance such as http://host:8080
+func New(store Store, remoteURL string) *Syncer {
+	return &Syncer{
+		store:      store,
+		remote:     strings.TrimSuffix(remoteURL, "/"),
+		HTTPClient: http.DefaultClient,
+	}
+}
+
+// Run compares manifests with the remote and transfers what differs
+func (s *Syncer) Run(ctx context.Context, direction Direction) (*Result, error) {
+	local, err := s.store.ManifestDigests()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list local manifests: %w", err)
+	}
+	remote, err := s.remoteDigests(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{}
+
+	var push, pull []string
+	for id, digest := range local {
+		remoteDigest, exists := remote[id]
+		switch {
+		case !exists && direction != Pull:
+			push = append(push, id)
+		case exists && remoteDigest != digest && direction == Push:
+			push = append(push, id)
+		case exists && remoteDigest != digest && direction == Both:
+			result.Conflicts = append(result.Conflicts, id)
+		}
+	}
+	for id, digest := range remote {
+		localDigest, exists := local[id]
+		switch {
+		case !exists && direction != Push:
+			pull = append(pull, id)
+		case exists && localDigest != digest && direction == Pull:
+			pull = append(pull, id)
+		}
+	}
+	sort.Strings(push)
+	sort.Strings(pull)
+	sort.Strings(result.Conflicts)
+
+	for _, id := range push {
+		if err := s.pushImage(ctx, id, result); err != nil {
+			return result, fmt.Errorf("failed to push %s: %w", id, err)
+		}
+	}
+	for _, id := range pull {
+		if err := s.pullImage(ctx, id, result); err != nil {
+			return result, fmt.Errorf("failed to pull %s: %w", id, err)
+		}
+	}
+
+	return result, nil
+}
+
+// pushImage sends the tiles the remote lacks, then the manifest
+func (s *Syncer) pushImage(ctx context.Context, id string, result *Result) error {
+	storedImage, err := s.store.GetManifest(id)
+	if err != nil {
+		return err
+	}
+
+	missing, err := s.remoteMissingTiles(ctx, tileIDs(storedImage))
+	if err != nil {
+		return err
+	}
+
+	for _, tileID := range missing {
+		payload, err := s.store.ExportTile(tileID)
+		if err != nil {
+			return err
+		}
+		if _, err := s.do(ctx, http.MethodPut, "/sync/tiles/"+string(tileID), payload, http.StatusNoContent); err != nil {
+			return err
+		}
+		result.TilesPushed++
+		result.BytesSent += int64(len(payload))
+	}
+
+	manifest, err := json.Marshal(storedImage)
+	if err != nil {
+		return err
+	}
+	if _, err := s.do(ctx, http.MethodPut, "/sync/manifests/"+escapeID(id), manifest, http.StatusNoContent); err != nil {
+		return err
+	}
+	result.ImagesPushed++
+	result.BytesSent += int64(len(manifest))
+
+	return nil
+}
+
+// pullImage fetches the manifest and any tiles the local store lacks
+func (s *Syncer) pullImage(ctx context.Context, id string, result *Result) error {
+	body, err := s.do(ctx, http.MethodGet, "/sync/manifests/"+escapeID(id), nil, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	result.BytesReceived += int64(len(body))
+
+	var storedImage imagestore.StoredImage
+	if err := json.Unmarshal(body, &storedImage); err != nil {
+		return fmt.Errorf("invalid manifest: %w", err)
+	}
+
+	missing, err := s.store.MissingTiles(tileIDs(&storedImage))
+	if err != nil {
+		return err
+	}
+
+	for _, tileID := range missing {
+		payload, err := s.do(ctx, http.MethodGet, "/sync/tiles/"+string(tileID), nil, http.StatusOK)
+		if err != nil {
+			return err
+		}
+		if err := s.store.ImportTile(tileID, payload); err != nil {
+			return err
+		}
+		result.TilesPulled++
+		result.BytesReceived += int64(len(payload))
+	}
+
+	if err := s.store.ImportManifest(&storedImage); err != nil {
+		return err
+	}
+	result.ImagesPulled++
+
+	return nil
+}
+
+// remoteDigests lists the remote's manifest digests
+func (s *Syncer) remoteDigests(ctx context.Context) (map[string]string, error) {
+	body, err := s.do(ctx, http.MethodGet, "/sync/manifests", nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+
+	var reply struct {
+		Manifests map[string]string `json:"manifests"`
+	}
+	if err := json.Unmarshal(body, &reply); err != nil {
+		return nil, fmt.Errorf("invalid manifest list: %w", err)
+	}
+	return reply.Manifests, nil
+}
+
+// remoteMissingTiles asks the remote which of the tiles it lacks
+func (s *Syncer) remoteMissingTiles(ctx context.Context, ids []imagestore.TileID) ([]imagestore.TileID, error) {
+	request, err := json.Marshal(map[string]interface{}{
+		"tile_size": s.store.TileSize(),
+		"tiles":     ids,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := s.do(ctx, http.MethodPost, "/tiles/missing", request, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+
+	var reply struct {
+		Missing []imagestore.TileID `json:"missing"`
+	}
+	if err := json.Unmarshal(body, &reply); err != nil {
+		return nil, fmt.Errorf("invalid missing tiles response: %w", err)
+	}
+	return reply.Missing, nil
+}
+
+// do sends a request to the remote and returns the response body, failing
+// unless the response has the expected status
+func (s *Syncer) do(ctx context.Context, method, path string, body []byte, expected int) ([]byte, error) {
+	return doRequest(ctx, s.HTTPClient, method, s.remote+path, body, expected)
+}
+
+// StatusError reports a response with an unexpected status
+type StatusError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Status     string
+	Code       string          // From the error envelope; empty for servers predating it
+	Message    string          // The envelope's message, or the whole body
+	RequestID  string          // From the error envelope
+	Details    json.RawMessage // From the error envelope
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s %s: %s: %s", e.Method, e.URL, e.Status, e.Message)
+}
+
+// doRequest sends a request and returns the response body, failing with a
+// *StatusError unless the response has the expected status
+func doRequest(ctx context.Context, client *http.Client, method, url string, body []byte, expected int) ([]byte, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != expected {
+		statusErr := &StatusError{
+			Method:     method,
+			URL:        url,
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			Message:    strings.TrimSpace(string(data)),
+		}
+		var envelope struct {
+			Error struct {
+				Code      string          `json:"code"`
+				Message   string          `json:"message"`
+				RequestID string          `json:"request_id"`
+				Details   json.RawMessage `json:"details"`
+			} `json:"error"`
+		}
+		if json.Unmarshal(data, &envelope) == nil && envelope.Error.Code != "" {
+			statusErr.Code = envelope.Error.Code
+			statusErr.Message = envelope.Error.Message
+			statusErr.RequestID = envelope.Error.RequestID
+			statusErr.Details = envelope.Error.Details
+		}
+		return nil, statusErr
+	}
+	return data, nil
+}
+
+// tileIDs returns the distinct tiles an image references
+func tileIDs(storedImage *imagestore.StoredImage) []imagestore.TileID {
+	seen := make(map[imagestore.TileID]bool)
+	var ids []imagestore.TileID
+	for _, tileRef := range storedImage.TileRefs {
+		if !seen[tileRef.TileID] {
+			seen[tileRef.TileID] = true
+			ids = append(ids, tileRef.TileID)
+		}
+	}
+	return ids
+}
+
+// escapeID escapes each segment of an image ID, keeping namespace slashes
+func escapeID(id string) string {
+	segments := strings.Split(id, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,258 @@
+package remotesync
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gordyf/imageencoder/internal/handlers"
+	"github.com/gordyf/imageencoder/lib/config"
+	"github.com/gordyf/imageencoder/lib/imagestore"
+)
+
+func newTestStore(t *testing.T) *imagestore.PebbleImageStore {
+	t.Helper()
+
+	storeConfig := imagestore.DefaultConfig()
+	storeConfig.DatabasePath = filepath.Join(t.TempDir(), "test.db")
+	storeConfig.TileSize = 8
+
+	store, err := imagestore.NewPebbleImageStore(storeConfig)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	return store
+}
+
+// newRemote serves a store over HTTP like a running instance
+func newRemote(t *testing.T) (*imagestore.PebbleImageStore, string) {
+	t.Helper()
+
+	store := newTestStore(t)
+	mux := http.NewServeMux()
+	handlers.NewImageHandler(store, config.DefaultConfig().Server).RegisterRoutes(mux)
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	return store, server.URL
+}
+
+func storeTestImage(t *testing.T, store *imagestore.PebbleImageStore, id string, seed uint8) {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			c := color.RGBA{uint8(x * 16), uint8(y * 16), 60, 255}
+			if x >= 8 && y >= 8 {
+				c.B = seed
+			}
+			img.Set(x, y, c)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	if err := store.StoreImage(id, buf.Bytes()); err != nil {
+		t.Fatalf("failed to store image %s: %v", id, err)
+	}
+}
+
+func assertSameImage(t *testing.T, a, b *imagestore.PebbleImageStore, id string) {
+	t.Helper()
+
+	dataA, err := a.RetrieveImage(id)
+	if err != nil {
+		t.Fatalf("failed to retrieve %s: %v", id, err)
+	}
+	dataB, err := b.RetrieveImage(id)
+	if err != nil {
+		t.Fatalf("failed to retrieve %s on the other side: %v", id, err)
+	}
+	if !bytes.Equal(dataA, dataB) {
+		t.Errorf("image %s differs between stores", id)
+	}
+}
+
+func TestPushAndPull(t *testing.T) {
+	local := newTestStore(t)
+	remote, remoteURL := newRemote(t)
+
+	storeTestImage(t, local, "team/one", 1)
+	storeTestImage(t, local, "team/two", 2)
+	if err := local.AddTags("team/one", "nightly"); err != nil {
+		t.Fatalf("failed to tag image: %v", err)
+	}
+
+	syncer := New(local, remoteURL)
+
+	result, err := syncer.Run(context.Background(), Push)
+	if err != nil {
+		t.Fatalf("push failed: %v", err)
+	}
+	// Two images share three tiles, so five distinct tiles cross the wire
+	if result.ImagesPushed != 2 || result.TilesPushed != 5 {
+		t.Errorf("unexpected push result: %+v", result)
+	}
+	assertSameImage(t, local, remote, "team/one")
+	assertSameImage(t, local, remote, "team/two")
+
+	if tagged, _ := remote.ListByTag("nightly"); len(tagged) != 1 {
+		t.Errorf("expected tags to be pushed, got %v", tagged)
+	}
+
+	// Nothing differs now, so a second run transfers nothing
+	result, err = syncer.Run(context.Background(), Both)
+	if err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	if result.ImagesPushed+result.ImagesPulled != 0 {
+		t.Errorf("expected no transfers, got %+v", result)
+	}
+
+	storeTestImage(t, remote, "team/three", 3)
+	result, err = syncer.Run(context.Background(), Pull)
+	if err != nil {
+		t.Fatalf("pull failed: %v", err)
+	}
+	if result.ImagesPulled != 1 || result.TilesPulled != 1 {
+		t.Errorf("expected one image and its one new tile pulled, got %+v", result)
+	}
+	assertSameImage(t, local, remote, "team/three")
+}
+
+func TestBothReportsConflicts(t *testing.T) {
+	local := newTestStore(t)
+	remote, remoteURL := newRemote(t)
+
+	storeTestImage(t, local, "shared", 1)
+	storeTestImage(t, remote, "shared", 2)
+	storeTestImage(t, local, "local-only", 3)
+	storeTestImage(t, remote, "remote-only", 4)
+
+	result, err := New(local, remoteURL).Run(context.Background(), Both)
+	if err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+
+	if result.ImagesPushed != 1 || result.ImagesPulled != 1 {
+		t.Errorf("expected one image each way, got %+v", result)
+	}
+	if len(result.Conflicts) != 1 || result.Conflicts[0] != "shared" {
+		t.Errorf("expected shared to conflict, got %v", result.Conflicts)
+	}
+
+	assertSameImage(t, local, remote, "local-only")
+	assertSameImage(t, local, remote, "remote-only")
+}
+
+func TestUpstream(t *testing.T) {
+	remote, remoteURL := newRemote(t)
+	storeTestImage(t, remote, "team/home", 1)
+
+	storeConfig := imagestore.DefaultConfig()
+	storeConfig.DatabasePath = filepath.Join(t.TempDir(), "edge.db")
+	storeConfig.TileSize = 8
+	storeConfig.Upstream = NewUpstream(remoteURL)
+
+	edge, err := imagestore.NewPebbleImageStore(storeConfig)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer edge.Close()
+
+	assertSameImage(t, remote, edge, "team/home")
+
+	if _, err := edge.RetrieveImage("team/absent"); err == nil || !strings.Contains(err.Error(), "image not found") {
+		t.Errorf("expected not found for an image missing upstream, got %v", err)
+	}
+}
+
+func TestSyncThroughRemoteStore(t *testing.T) {
+	// The local database is held by a running server, so the sync goes
+	// through its API
+	local, localURL := newRemote(t)
+	remote, remoteURL := newRemote(t)
+	storeTestImage(t, local, "local", 1)
+	storeTestImage(t, remote, "remote", 2)
+
+	proxy, err := DialRemoteStore(context.Background(), localURL)
+	if err != nil {
+		t.Fatalf("failed to dial local server: %v", err)
+	}
+	if proxy.TileSize() != 8 {
+		t.Errorf("expected tile size 8, got %d", proxy.TileSize())
+	}
+
+	result, err := New(proxy, remoteURL).Run(context.Background(), Both)
+	if err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	if result.ImagesPushed != 1 || result.ImagesPulled != 1 {
+		t.Errorf("expected one image each way, got %+v", result)
+	}
+	assertSameImage(t, local, remote, "local")
+	assertSameImage(t, local, remote, "remote")
+
+	if _, err := proxy.GetManifest("absent"); err == nil || !strings.Contains(err.Error(), "image not found") {
+		t.Errorf("expected not found for a missing image, got %v", err)
+	}
+}
+
+func TestRemoteStoreOverUnixSocket(t *testing.T) {
+	store := newTestStore(t)
+	storeTestImage(t, store, "local", 1)
+
+	socket := filepath.Join(t.TempDir(), "imagestore.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("failed to listen on socket: %v", err)
+	}
+	mux := http.NewServeMux()
+	handlers.NewImageHandler(store, config.DefaultConfig().Server).RegisterRoutes(mux)
+	server := &http.Server{Handler: mux}
+	go server.Serve(listener)
+	defer server.Close()
+
+	proxy, err := DialRemoteStore(context.Background(), "unix://"+socket)
+	if err != nil {
+		t.Fatalf("failed to dial socket: %v", err)
+	}
+	digests, err := proxy.ManifestDigests()
+	if err != nil {
+		t.Fatalf("failed to list manifests: %v", err)
+	}
+	if _, ok := digests["local"]; !ok || len(digests) != 1 {
+		t.Errorf("expected the one local image, got %v", digests)
+	}
+}
+
+func TestStatusErrorReadsEnvelope(t *testing.T) {
+	_, remote := newRemote(t)
+
+	_, err := doRequest(context.Background(), http.DefaultClient, http.MethodGet, remote+"/sync/tiles/absent", nil, http.StatusOK)
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) {
+		t.Fatalf("expected a *StatusError, got %v", err)
+	}
+	if statusErr.StatusCode != http.StatusNotFound || statusErr.Code != "tile_not_found" {
+		t.Errorf("expected 404 tile_not_found, got %d %q", statusErr.StatusCode, statusErr.Code)
+	}
+	if statusErr.Message != "Tile not found" || statusErr.RequestID == "" {
+		t.Errorf("expected the envelope's message and a request ID, got %q, %q", statusErr.Message, statusErr.RequestID)
+	}
+}
@@ -0,0 +1,164 @@
+package remotesync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gordyf/imageencoder/lib/imagestore"
+)
+
+// RemoteStore is a Store backed by a running instance's sync endpoints.
+// CLI commands use it when a server holds the database lock, so the server
+// applies their writes instead of a second process opening the database.
+type RemoteStore struct {
+	remote     string
+	tileSize   int
+	HTTPClient *http.Client
+}
+
+var _ Store = (*RemoteStore)(nil)
+
+// DialRemoteStore connects to an instance such as http://127.0.0.1:8080,
+// or one listening on a unix socket such as unix:///run/imagestore.sock,
+// learning its tile size
+func DialRemoteStore(ctx context.Context, remoteURL string) (*RemoteStore, error) {
+	r := &RemoteStore{
+		remote:     strings.TrimSuffix(remoteURL, "/"),
+		HTTPClient: &http.Client{Timeout: DefaultUpstreamTimeout},
+	}
+	if socket, ok := strings.CutPrefix(remoteURL, "unix://"); ok {
+		// The host is ignored; every request goes to the socket
+		r.remote = "http://imagestore"
+		r.HTTPClient.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", socket)
+			},
+		}
+	}
+
+	// The missing-tiles endpoint answers a request at the wrong tile size
+	// with the size it expects
+	request, err := json.Marshal(map[string]interface{}{
+		"tile_size": 0,
+		"tiles":     []imagestore.TileID{},
+	})
+	if err != nil {
+		return nil, err
+	}
+	body, err := doRequest(ctx, r.HTTPClient, http.MethodPost, r.remote+"/tiles/missing", request, http.StatusOK)
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusConflict {
+		// Servers predating error envelopes send the size at the top level
+		body, err = statusErr.Details, nil
+		if statusErr.Code == "" {
+			body = []byte(statusErr.Message)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var reply struct {
+		TileSize int `json:"tile_size"`
+	}
+	if err := json.Unmarshal(body, &reply); err != nil || reply.TileSize <= 0 {
+		return nil, fmt.Errorf("%s did not report a tile size", remoteURL)
+	}
+	r.tileSize = reply.TileSize
+
+	return r, nil
+}
+
+// TileSize returns the instance's tile size
+func (r *RemoteStore) TileSize() int {
+	return r.tileSize
+}
+
+// ManifestDigests lists the instance's manifest digests
+func (r *RemoteStore) ManifestDigests() (map[string]string, error) {
+	body, err := r.do(http.MethodGet, "/sync/manifests", nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+
+	var reply struct {
+		Manifests map[string]string `json:"manifests"`
+	}
+	if err := json.Unmarshal(body, &reply); err != nil {
+		return nil, fmt.Errorf("invalid manifest list: %w", err)
+	}
+	return reply.Manifests, nil
+}
+
+// GetManifest fetches an image manifest from the instance
+func (r *RemoteStore) GetManifest(id string) (*imagestore.StoredImage, error) {
+	body, err := r.do(http.MethodGet, "/sync/manifests/"+escapeID(id), nil, http.StatusOK)
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("image not found: %s", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var storedImage imagestore.StoredImage
+	if err := json.Unmarshal(body, &storedImage); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	return &storedImage, nil
+}
+
+// ImportManifest writes a manifest whose tiles the instance already holds
+func (r *RemoteStore) ImportManifest(storedImage *imagestore.StoredImage) error {
+	manifest, err := json.Marshal(storedImage)
+	if err != nil {
+		return err
+	}
+	_, err = r.do(http.MethodPut, "/sync/manifests/"+escapeID(storedImage.ID), manifest, http.StatusNoContent)
+	return err
+}
+
+// MissingTiles asks the instance which of the tiles it lacks
+func (r *RemoteStore) MissingTiles(tileIDs []imagestore.TileID) ([]imagestore.TileID, error) {
+	request, err := json.Marshal(map[string]interface{}{
+		"tile_size": r.tileSize,
+		"tiles":     tileIDs,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := r.do(http.MethodPost, "/tiles/missing", request, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+
+	var reply struct {
+		Missing []imagestore.TileID `json:"missing"`
+	}
+	if err := json.Unmarshal(body, &reply); err != nil {
+		return nil, fmt.Errorf("invalid missing tiles response: %w", err)
+	}
+	return reply.Missing, nil
+}
+
+// ExportTile fetches a tile from the instance in the sync transfer format
+func (r *RemoteStore) ExportTile(tileID imagestore.TileID) ([]byte, error) {
+	return r.do(http.MethodGet, "/sync/tiles/"+string(tileID), nil, http.StatusOK)
+}
+
+// ImportTile sends a tile to the instance in the sync transfer format
+func (r *RemoteStore) ImportTile(tileID imagestore.TileID, payload []byte) error {
+	_, err := r.do(http.MethodPut, "/sync/tiles/"+string(tileID), payload, http.StatusNoContent)
+	return err
+}
+
+func (r *RemoteStore) do(method, path string, body []byte, expected int) ([]byte, error) {
+	return doRequest(context.Background(), r.HTTPClient, method, r.remote+path, body, expected)
+}
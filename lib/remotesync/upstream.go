@@ -0,0 +1,58 @@
+package remotesync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gordyf/imageencoder/lib/imagestore"
+)
+
+// DefaultUpstreamTimeout bounds each request an Upstream makes
+const DefaultUpstreamTimeout = 30 * time.Second
+
+// Upstream reads manifests and tiles from a remote instance over its sync
+// endpoints. Set it as imagestore.Config.Upstream to run a store as an edge
+// cache in front of that instance.
+type Upstream struct {
+	remote     string
+	HTTPClient *http.Client
+}
+
+var _ imagestore.Upstream = (*Upstream)(nil)
+
+// NewUpstream creates an upstream for a remote instance such as
+// http://primary:8080
+func NewUpstream(remoteURL string) *Upstream {
+	return &Upstream{
+		remote:     strings.TrimSuffix(remoteURL, "/"),
+		HTTPClient: &http.Client{Timeout: DefaultUpstreamTimeout},
+	}
+}
+
+// GetManifest fetches an image manifest from the remote
+func (u *Upstream) GetManifest(id string) (*imagestore.StoredImage, error) {
+	body, err := doRequest(context.Background(), u.HTTPClient, http.MethodGet, u.remote+"/sync/manifests/"+escapeID(id), nil, http.StatusOK)
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("image not found: %s", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var storedImage imagestore.StoredImage
+	if err := json.Unmarshal(body, &storedImage); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	return &storedImage, nil
+}
+
+// ExportTile fetches a tile from the remote in the sync transfer format
+func (u *Upstream) ExportTile(tileID imagestore.TileID) ([]byte, error) {
+	return doRequest(context.Background(), u.HTTPClient, http.MethodGet, u.remote+"/sync/tiles/"+string(tileID), nil, http.StatusOK)
+}
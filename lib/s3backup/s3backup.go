@@ -0,0 +1,153 @@
+package s3backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/gordyf/imageencoder/lib/imagestore"
+)
+
+// requestTimeout bounds listing and deleting backups, since the backup
+// target interface carries no context
+const requestTimeout = 30 * time.Second
+
+// transferTimeout bounds uploading or reading back a whole archive
+const transferTimeout = 6 * time.Hour
+
+// Target keeps backups as objects in an S3 bucket under a key prefix. It
+// implements imagestore.BackupTarget. Archives are uploaded in a single
+// PutObject request, which S3 limits to 5 GB.
+type Target struct {
+	client *s3.Client
+	bucket string
+	prefix string
+}
+
+var _ imagestore.BackupTarget = (*Target)(nil)
+
+// New creates a backup target for a bucket using an existing client
+func New(client *s3.Client, bucket, prefix string) *Target {
+	return &Target{client: client, bucket: bucket, prefix: prefix}
+}
+
+// NewFromEnv creates a backup target for a bucket using the default AWS
+// credential chain. A non-empty endpoint selects an S3-compatible service.
+func NewFromEnv(ctx context.Context, bucket, prefix, region, endpoint string) (*Target, error) {
+	var opts []func(*awsconfig.LoadOptions) error
+	if region != "" {
+		opts = append(opts, awsconfig.WithRegion(region))
+	}
+
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+			o.UsePathStyle = true
+		}
+	})
+	return New(client, bucket, prefix), nil
+}
+
+// key returns the object key of a backup object
+func (t *Target) key(name string) string {
+	return path.Join(t.prefix, name)
+}
+
+// PutBackup uploads an object
+func (t *Target) PutBackup(name string, data io.ReadSeeker) error {
+	ctx, cancel := context.WithTimeout(context.Background(), transferTimeout)
+	defer cancel()
+
+	_, err := t.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(t.bucket),
+		Key:    aws.String(t.key(name)),
+		Body:   data,
+	})
+	return err
+}
+
+// GetBackup opens an object for download
+func (t *Target) GetBackup(name string) (io.ReadCloser, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), transferTimeout)
+
+	output, err := t.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(t.bucket),
+		Key:    aws.String(t.key(name)),
+	})
+	if err != nil {
+		cancel()
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil, fmt.Errorf("backup not found in s3://%s: %s", t.bucket, name)
+		}
+		return nil, err
+	}
+	return &body{ReadCloser: output.Body, cancel: cancel}, nil
+}
+
+// body releases the download's context when it is closed
+type body struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *body) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
+
+// ListBackups pages through the objects under the prefix
+func (t *Target) ListBackups() ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	prefix := t.prefix
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	paginator := s3.NewListObjectsV2Paginator(t.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(t.bucket),
+		Prefix: aws.String(prefix),
+	})
+
+	var names []string
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range page.Contents {
+			name := strings.TrimPrefix(aws.ToString(item.Key), prefix)
+			if name != "" && !strings.Contains(name, "/") {
+				names = append(names, name)
+			}
+		}
+	}
+	return names, nil
+}
+
+// DeleteBackup removes an object. S3 treats deleting a missing object as
+// success.
+func (t *Target) DeleteBackup(name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	_, err := t.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(t.bucket),
+		Key:    aws.String(t.key(name)),
+	})
+	return err
+}
@@ -0,0 +1,114 @@
+package s3backup
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// fakeS3 serves path-style object PUT, GET and DELETE and single-page
+// ListObjectsV2 from memory
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+type listResult struct {
+	XMLName  xml.Name `xml:"ListBucketResult"`
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2":
+		bucket := strings.TrimSuffix(r.URL.Path, "/") + "/"
+		prefix := r.URL.Query().Get("prefix")
+		var keys []string
+		for path := range f.objects {
+			if key, ok := strings.CutPrefix(path, bucket); ok && strings.HasPrefix(key, prefix) {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		var result listResult
+		for _, key := range keys {
+			result.Contents = append(result.Contents, struct {
+				Key string `xml:"Key"`
+			}{key})
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		xml.NewEncoder(w).Encode(result)
+	case r.Method == http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		f.objects[r.URL.Path] = data
+	case r.Method == http.MethodGet:
+		data, ok := f.objects[r.URL.Path]
+		if !ok {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `<Error><Code>NoSuchKey</Code><Message>missing</Message></Error>`)
+			return
+		}
+		w.Write(data)
+	case r.Method == http.MethodDelete:
+		delete(f.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func TestTarget(t *testing.T) {
+	fake := &fakeS3{objects: make(map[string][]byte)}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	client := s3.New(s3.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(server.URL),
+		UsePathStyle: true,
+		Credentials:  aws.AnonymousCredentials{},
+	})
+	target := New(client, "backups", "imagestore")
+
+	if err := target.PutBackup("a.tar", strings.NewReader("archive")); err != nil {
+		t.Fatalf("failed to put backup: %v", err)
+	}
+	if _, ok := fake.objects["/backups/imagestore/a.tar"]; !ok {
+		t.Errorf("expected the backup under the prefix, got %v", fake.objects)
+	}
+	fake.objects["/backups/imagestore-other/b.tar"] = []byte("other")
+
+	r, err := target.GetBackup("a.tar")
+	if err != nil {
+		t.Fatalf("failed to get backup: %v", err)
+	}
+	data, _ := io.ReadAll(r)
+	r.Close()
+	if string(data) != "archive" {
+		t.Errorf("expected the archive back, got %q", data)
+	}
+
+	names, err := target.ListBackups()
+	if err != nil || len(names) != 1 || names[0] != "a.tar" {
+		t.Errorf("expected only a.tar under the prefix, got %v (%v)", names, err)
+	}
+
+	if err := target.DeleteBackup("a.tar"); err != nil {
+		t.Fatalf("failed to delete backup: %v", err)
+	}
+	if _, err := target.GetBackup("a.tar"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("expected a not found error after delete, got %v", err)
+	}
+}
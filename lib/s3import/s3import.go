@@ -0,0 +1,210 @@
+package s3import
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gordyf/imageencoder/lib/imagestore"
+)
+
+// Object describes an object available for import
+type Object struct {
+	Key  string
+	ETag string
+	Size int64
+}
+
+// ObjectSource lists and fetches objects under a prefix
+type ObjectSource interface {
+	// Name identifies the source in the import manifest, e.g. s3://bucket
+	Name() string
+	ListObjects(ctx context.Context, prefix string, fn func(Object) error) error
+	GetObject(ctx context.Context, key string) ([]byte, error)
+}
+
+// Store is the subset of the image store the importer needs
+type Store interface {
+	StoreImage(id string, imageData []byte) error
+	LookupImport(source, key string) (*imagestore.ImportRecord, error)
+	RecordImport(source, key string, record imagestore.ImportRecord) error
+}
+
+// Result summarizes an import run
+type Result struct {
+	Imported int
+	Skipped  int // Already imported at the same ETag, or not an image
+	Failed   int
+	Errors   []string
+}
+
+// Importer copies images from an object source into the store, using each
+// object's key relative to the prefix, without its extension, as the image ID
+type Importer struct {
+	store  Store
+	source ObjectSource
+	prefix string
+}
+
+// NewImporter creates an importer for the objects under prefix
+func NewImporter(store Store, source ObjectSource, prefix string) *Importer {
+	return &Importer{store: store, source: source, prefix: prefix}
+}
+
+// Run imports every object that hasn't been imported at its current ETag.
+// Objects that fail are reported in the result and retried on the next run.
+func (i *Importer) Run(ctx context.Context) (*Result, error) {
+	result := &Result{}
+
+	err := i.source.ListObjects(ctx, i.prefix, func(object Object) error {
+		if !isImageKey(object.Key) {
+			result.Skipped++
+			return nil
+		}
+
+		record, err := i.store.LookupImport(i.source.Name(), object.Key)
+		if err != nil {
+			return err
+		}
+		if record != nil && record.ETag == object.ETag {
+			result.Skipped++
+			return nil
+		}
+
+		if err := i.importObject(ctx, object); err != nil {
+			log.Printf("Error importing %s: %v", object.Key, err)
+			result.Failed++
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", object.Key, err))
+			return nil
+		}
+		result.Imported++
+		return nil
+	})
+	if err != nil {
+		return result, fmt.Errorf("failed to list objects: %w", err)
+	}
+
+	return result, nil
+}
+
+// importObject stores one object and records it in the manifest. A crash
+// between the two only causes the object to be stored again next run.
+func (i *Importer) importObject(ctx context.Context, object Object) error {
+	imageData, err := i.source.GetObject(ctx, object.Key)
+	if err != nil {
+		return fmt.Errorf("failed to fetch object: %w", err)
+	}
+
+	imageID := ImageID(i.prefix, object.Key)
+	if err := i.store.StoreImage(imageID, imageData); err != nil {
+		return fmt.Errorf("failed to store image %s: %w", imageID, err)
+	}
+
+	return i.store.RecordImport(i.source.Name(), object.Key, imagestore.ImportRecord{
+		ImageID:    imageID,
+		ETag:       object.ETag,
+		ImportedAt: time.Now().UTC(),
+	})
+}
+
+// ImageID derives an image ID from an object key: the key relative to the
+// prefix, without its extension
+func ImageID(prefix, key string) string {
+	id := strings.TrimPrefix(strings.TrimPrefix(key, prefix), "/")
+	return strings.TrimSuffix(id, path.Ext(id))
+}
+
+// isImageKey reports whether an object key has a PNG or JPEG extension
+func isImageKey(key string) bool {
+	switch strings.ToLower(path.Ext(key)) {
+	case ".png", ".jpg", ".jpeg":
+		return true
+	}
+	return false
+}
+
+// S3Source reads objects from an S3 bucket
+type S3Source struct {
+	client *s3.Client
+	bucket string
+}
+
+// NewS3Source creates a source for a bucket using an existing client
+func NewS3Source(client *s3.Client, bucket string) *S3Source {
+	return &S3Source{client: client, bucket: bucket}
+}
+
+// NewS3SourceFromEnv creates a source for a bucket using the default AWS
+// credential chain. A non-empty endpoint selects an S3-compatible service.
+func NewS3SourceFromEnv(ctx context.Context, bucket, region, endpoint string) (*S3Source, error) {
+	var opts []func(*awsconfig.LoadOptions) error
+	if region != "" {
+		opts = append(opts, awsconfig.WithRegion(region))
+	}
+
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+			o.UsePathStyle = true
+		}
+	})
+	return NewS3Source(client, bucket), nil
+}
+
+// Name returns s3://<bucket>
+func (s *S3Source) Name() string {
+	return "s3://" + s.bucket
+}
+
+// ListObjects pages through the objects under prefix
+func (s *S3Source) ListObjects(ctx context.Context, prefix string, fn func(Object) error) error {
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		for _, item := range page.Contents {
+			object := Object{
+				Key:  aws.ToString(item.Key),
+				ETag: strings.Trim(aws.ToString(item.ETag), `"`),
+				Size: aws.ToInt64(item.Size),
+			}
+			if err := fn(object); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// GetObject downloads an object's contents
+func (s *S3Source) GetObject(ctx context.Context, key string) ([]byte, error) {
+	output, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer output.Body.Close()
+
+	return io.ReadAll(output.Body)
+}
@@ -0,0 +1,161 @@
+package s3import
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gordyf/imageencoder/lib/imagestore"
+)
+
+// memorySource is an in-memory ObjectSource
+type memorySource struct {
+	objects map[string]Object
+	data    map[string][]byte
+	fetches int
+}
+
+func newMemorySource() *memorySource {
+	return &memorySource{objects: make(map[string]Object), data: make(map[string][]byte)}
+}
+
+func (m *memorySource) put(key, etag string, data []byte) {
+	m.objects[key] = Object{Key: key, ETag: etag, Size: int64(len(data))}
+	m.data[key] = data
+}
+
+func (m *memorySource) Name() string {
+	return "memory://test"
+}
+
+func (m *memorySource) ListObjects(ctx context.Context, prefix string, fn func(Object) error) error {
+	keys := make([]string, 0, len(m.objects))
+	for key := range m.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if err := fn(m.objects[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *memorySource) GetObject(ctx context.Context, key string) ([]byte, error) {
+	m.fetches++
+	data, ok := m.data[key]
+	if !ok {
+		return nil, fmt.Errorf("no such key: %s", key)
+	}
+	return data, nil
+}
+
+func newTestStore(t *testing.T) *imagestore.PebbleImageStore {
+	t.Helper()
+
+	config := imagestore.DefaultConfig()
+	config.DatabasePath = filepath.Join(t.TempDir(), "test.db")
+	config.TileSize = 4
+
+	store, err := imagestore.NewPebbleImageStore(config)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	return store
+}
+
+func encodeTestPNG(t *testing.T, shade uint8) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			img.Set(x, y, color.RGBA{shade, uint8(x * 30), uint8(y * 30), 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestImageID(t *testing.T) {
+	tests := []struct {
+		prefix, key, expected string
+	}{
+		{"screenshots/", "screenshots/home.png", "home"},
+		{"screenshots", "screenshots/2024/home.jpeg", "2024/home"},
+		{"", "team/home.png", "team/home"},
+	}
+	for _, tt := range tests {
+		if got := ImageID(tt.prefix, tt.key); got != tt.expected {
+			t.Errorf("ImageID(%q, %q) = %q, expected %q", tt.prefix, tt.key, got, tt.expected)
+		}
+	}
+}
+
+func TestImporterRun(t *testing.T) {
+	store := newTestStore(t)
+	source := newMemorySource()
+
+	source.put("shots/home.png", "v1", encodeTestPNG(t, 10))
+	source.put("shots/team/login.png", "v1", encodeTestPNG(t, 20))
+	source.put("shots/readme.txt", "v1", []byte("not an image"))
+	source.put("shots/broken.png", "v1", []byte("not a png"))
+	source.put("other/skip.png", "v1", encodeTestPNG(t, 30))
+
+	importer := NewImporter(store, source, "shots/")
+
+	result, err := importer.Run(context.Background())
+	if err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+	if result.Imported != 2 || result.Skipped != 1 || result.Failed != 1 {
+		t.Errorf("unexpected first run result: %+v", result)
+	}
+
+	for _, id := range []string{"home", "team/login"} {
+		if _, err := store.RetrieveImage(id); err != nil {
+			t.Errorf("expected image %s to be imported: %v", id, err)
+		}
+	}
+
+	// A second run skips objects already imported at the same ETag and
+	// retries failures
+	source.fetches = 0
+	source.put("shots/home.png", "v2", encodeTestPNG(t, 40))
+
+	result, err = importer.Run(context.Background())
+	if err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+	if result.Imported != 1 || result.Skipped != 2 || result.Failed != 1 {
+		t.Errorf("unexpected second run result: %+v", result)
+	}
+	if source.fetches != 2 {
+		t.Errorf("expected only the changed and failed objects to be fetched, got %d fetches", source.fetches)
+	}
+
+	record, err := store.LookupImport(source.Name(), "shots/home.png")
+	if err != nil {
+		t.Fatalf("failed to look up import: %v", err)
+	}
+	if record == nil || record.ETag != "v2" || record.ImageID != "home" {
+		t.Errorf("unexpected import record: %+v", record)
+	}
+}
@@ -0,0 +1,110 @@
+package s3tier
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/gordyf/imageencoder/lib/imagestore"
+)
+
+// requestTimeout bounds each S3 request, since the cold store interface
+// carries no context
+const requestTimeout = 30 * time.Second
+
+// Store keeps cold tiles as objects in an S3 bucket, one object per tile
+// under a key prefix. It implements imagestore.ColdStore.
+type Store struct {
+	client *s3.Client
+	bucket string
+	prefix string
+}
+
+var _ imagestore.ColdStore = (*Store)(nil)
+
+// New creates a cold store for a bucket using an existing client
+func New(client *s3.Client, bucket, prefix string) *Store {
+	return &Store{client: client, bucket: bucket, prefix: prefix}
+}
+
+// NewFromEnv creates a cold store for a bucket using the default AWS
+// credential chain. A non-empty endpoint selects an S3-compatible service.
+func NewFromEnv(ctx context.Context, bucket, prefix, region, endpoint string) (*Store, error) {
+	var opts []func(*awsconfig.LoadOptions) error
+	if region != "" {
+		opts = append(opts, awsconfig.WithRegion(region))
+	}
+
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+			o.UsePathStyle = true
+		}
+	})
+	return New(client, bucket, prefix), nil
+}
+
+// key returns the object key of a tile
+func (s *Store) key(tileID imagestore.TileID) string {
+	return path.Join(s.prefix, string(tileID))
+}
+
+// PutTile uploads a tile's payload
+func (s *Store) PutTile(tileID imagestore.TileID, payload []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(tileID)),
+		Body:   bytes.NewReader(payload),
+	})
+	return err
+}
+
+// GetTile downloads a tile's payload
+func (s *Store) GetTile(tileID imagestore.TileID) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	output, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(tileID)),
+	})
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil, fmt.Errorf("tile not found in s3://%s: %s", s.bucket, tileID)
+		}
+		return nil, err
+	}
+	defer output.Body.Close()
+
+	return io.ReadAll(output.Body)
+}
+
+// DeleteTile removes a tile's object. S3 treats deleting a missing object as
+// success.
+func (s *Store) DeleteTile(tileID imagestore.TileID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(tileID)),
+	})
+	return err
+}
@@ -0,0 +1,79 @@
+package s3tier
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gordyf/imageencoder/lib/imagestore"
+)
+
+// fakeS3 serves path-style object PUT, GET and DELETE from memory
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		f.objects[r.URL.Path] = data
+	case http.MethodGet:
+		data, ok := f.objects[r.URL.Path]
+		if !ok {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `<Error><Code>NoSuchKey</Code><Message>missing</Message></Error>`)
+			return
+		}
+		w.Write(data)
+	case http.MethodDelete:
+		delete(f.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func TestStore(t *testing.T) {
+	fake := &fakeS3{objects: make(map[string][]byte)}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	client := s3.New(s3.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(server.URL),
+		UsePathStyle: true,
+		Credentials:  aws.AnonymousCredentials{},
+	})
+	store := New(client, "cold", "tiles")
+
+	tileID := imagestore.TileID("0123abcd")
+	payload := []byte{0x01, 0x02, 0x03}
+	if err := store.PutTile(tileID, payload); err != nil {
+		t.Fatalf("failed to put tile: %v", err)
+	}
+	if _, ok := fake.objects["/cold/tiles/0123abcd"]; !ok {
+		t.Errorf("expected the tile under the prefix, got %v", fake.objects)
+	}
+
+	got, err := store.GetTile(tileID)
+	if err != nil || !bytes.Equal(got, payload) {
+		t.Errorf("expected %v back, got %v (%v)", payload, got, err)
+	}
+
+	if err := store.DeleteTile(tileID); err != nil {
+		t.Fatalf("failed to delete tile: %v", err)
+	}
+	if _, err := store.GetTile(tileID); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("expected a not found error after delete, got %v", err)
+	}
+}
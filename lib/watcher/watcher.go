@@ -0,0 +1,228 @@
+package watcher
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/gordyf/imageencoder/lib/imagestore"
+)
+
+// What to do with a source file once it has been stored
+const (
+	AfterStoreKeep    = "keep"
+	AfterStoreDelete  = "delete"
+	AfterStoreArchive = "archive"
+)
+
+// Config controls which directory is watched and how ingested files are
+// handled
+type Config struct {
+	Dir         string        // Directory to watch for new images
+	AfterStore  string        // keep, delete or archive. Default: keep
+	ArchiveDir  string        // Where stored files are moved when AfterStore is archive
+	SettleDelay time.Duration // Quiet period after the last write before a file is ingested
+}
+
+// Watcher ingests PNG and JPEG files dropped into a directory, storing each
+// under its filename without the extension
+type Watcher struct {
+	store   imagestore.ImageStore
+	config  Config
+	fsw     *fsnotify.Watcher
+	done    chan struct{}
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	pending map[string]*time.Timer // Files waiting for writes to settle
+}
+
+// New creates a watcher for the configured directory. Call Start to begin
+// ingesting.
+func New(store imagestore.ImageStore, config Config) (*Watcher, error) {
+	switch config.AfterStore {
+	case "":
+		config.AfterStore = AfterStoreKeep
+	case AfterStoreKeep, AfterStoreDelete:
+	case AfterStoreArchive:
+		if config.ArchiveDir == "" {
+			return nil, fmt.Errorf("archive directory is required when archiving stored files")
+		}
+		if err := os.MkdirAll(config.ArchiveDir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create archive directory: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("invalid after-store action: %s", config.AfterStore)
+	}
+
+	if config.SettleDelay <= 0 {
+		config.SettleDelay = time.Second
+	}
+
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create file watcher: %w", err)
+	}
+
+	if err := fsw.Add(config.Dir); err != nil {
+		fsw.Close()
+		return nil, fmt.Errorf("failed to watch %s: %w", config.Dir, err)
+	}
+
+	return &Watcher{
+		store:   store,
+		config:  config,
+		fsw:     fsw,
+		done:    make(chan struct{}),
+		pending: make(map[string]*time.Timer),
+	}, nil
+}
+
+// Start ingests any images already in the directory and then watches for
+// new ones until Close is called
+func (w *Watcher) Start() {
+	entries, err := os.ReadDir(w.config.Dir)
+	if err != nil {
+		log.Printf("Error reading watch directory %s: %v", w.config.Dir, err)
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			w.schedule(filepath.Join(w.config.Dir, entry.Name()))
+		}
+	}
+
+	w.wg.Add(1)
+	go w.run()
+}
+
+// Close stops watching and waits for in-flight ingestion to finish
+func (w *Watcher) Close() error {
+	w.mu.Lock()
+	close(w.done)
+	w.mu.Unlock()
+	err := w.fsw.Close()
+
+	// Files that haven't settled yet are picked up on the next start
+	w.mu.Lock()
+	for path, timer := range w.pending {
+		if timer.Stop() {
+			w.wg.Done()
+		}
+		delete(w.pending, path)
+	}
+	w.mu.Unlock()
+
+	w.wg.Wait()
+	return err
+}
+
+// run dispatches file system events until the watcher is closed
+func (w *Watcher) run() {
+	defer w.wg.Done()
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case event, ok := <-w.fsw.Events:
+			if !ok {
+				return
+			}
+			if event.Has(fsnotify.Create) || event.Has(fsnotify.Write) {
+				w.schedule(event.Name)
+			}
+		case err, ok := <-w.fsw.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("Error watching %s: %v", w.config.Dir, err)
+		}
+	}
+}
+
+// schedule ingests a file once it has gone SettleDelay without being
+// written, so partially copied files aren't stored
+func (w *Watcher) schedule(path string) {
+	if !isImageFile(path) {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	select {
+	case <-w.done:
+		return
+	default:
+	}
+
+	if timer, ok := w.pending[path]; ok && timer.Stop() {
+		timer.Reset(w.config.SettleDelay)
+		return
+	}
+
+	w.wg.Add(1)
+	var timer *time.Timer
+	timer = time.AfterFunc(w.config.SettleDelay, func() {
+		defer w.wg.Done()
+
+		w.mu.Lock()
+		if w.pending[path] == timer {
+			delete(w.pending, path)
+		}
+		w.mu.Unlock()
+
+		if err := w.ingest(path); err != nil {
+			log.Printf("Error ingesting %s: %v", path, err)
+		}
+	})
+	w.pending[path] = timer
+}
+
+// ingest stores a file and then keeps, deletes or archives it
+func (w *Watcher) ingest(path string) error {
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() {
+		// Already moved away or not a regular file
+		return nil
+	}
+
+	imageData, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read file: %w", err)
+	}
+
+	name := filepath.Base(path)
+	imageID := strings.TrimSuffix(name, filepath.Ext(name))
+
+	if err := w.store.StoreImage(imageID, imageData); err != nil {
+		return fmt.Errorf("failed to store image %s: %w", imageID, err)
+	}
+	log.Printf("Ingested %s as %s", path, imageID)
+
+	switch w.config.AfterStore {
+	case AfterStoreDelete:
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("failed to delete stored file: %w", err)
+		}
+	case AfterStoreArchive:
+		if err := os.Rename(path, filepath.Join(w.config.ArchiveDir, name)); err != nil {
+			return fmt.Errorf("failed to archive stored file: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// isImageFile reports whether a path has a PNG or JPEG extension
+func isImageFile(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".png", ".jpg", ".jpeg":
+		return true
+	}
+	return false
+}
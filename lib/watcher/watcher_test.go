@@ -0,0 +1,133 @@
+package watcher
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gordyf/imageencoder/lib/imagestore"
+)
+
+func newTestStore(t *testing.T) *imagestore.PebbleImageStore {
+	t.Helper()
+
+	config := imagestore.DefaultConfig()
+	config.DatabasePath = filepath.Join(t.TempDir(), "test.db")
+	config.TileSize = 4
+
+	store, err := imagestore.NewPebbleImageStore(config)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	return store
+}
+
+func writeTestPNG(t *testing.T, path string) {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 30), uint8(y * 30), 128, 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("failed to write test image: %v", err)
+	}
+}
+
+// waitForImage polls the store until an image appears or the timeout passes
+func waitForImage(t *testing.T, store imagestore.ImageStore, id string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := store.RetrieveImage(id); err == nil {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("image %s was not ingested", id)
+}
+
+func TestWatcherIngestsNewFiles(t *testing.T) {
+	store := newTestStore(t)
+	dir := t.TempDir()
+
+	// Files present before the watcher starts are ingested too
+	writeTestPNG(t, filepath.Join(dir, "existing.png"))
+
+	w, err := New(store, Config{Dir: dir, AfterStore: AfterStoreDelete, SettleDelay: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("failed to create watcher: %v", err)
+	}
+	w.Start()
+	defer w.Close()
+
+	writeTestPNG(t, filepath.Join(dir, "dropped.png"))
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("ignored"), 0644); err != nil {
+		t.Fatalf("failed to write text file: %v", err)
+	}
+
+	waitForImage(t, store, "existing")
+	waitForImage(t, store, "dropped")
+
+	// Deleting happens right after the store; give it a moment
+	time.Sleep(50 * time.Millisecond)
+	if _, err := os.Stat(filepath.Join(dir, "dropped.png")); !os.IsNotExist(err) {
+		t.Errorf("expected stored file to be deleted, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "notes.txt")); err != nil {
+		t.Errorf("expected non-image file to be left alone: %v", err)
+	}
+}
+
+func TestWatcherArchivesFiles(t *testing.T) {
+	store := newTestStore(t)
+	dir := t.TempDir()
+	archiveDir := filepath.Join(t.TempDir(), "archive")
+
+	w, err := New(store, Config{Dir: dir, AfterStore: AfterStoreArchive, ArchiveDir: archiveDir, SettleDelay: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("failed to create watcher: %v", err)
+	}
+	w.Start()
+
+	writeTestPNG(t, filepath.Join(dir, "shot.png"))
+	waitForImage(t, store, "shot")
+
+	// Close waits for in-flight ingestion, including the archive step
+	if err := w.Close(); err != nil {
+		t.Fatalf("failed to close watcher: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(archiveDir, "shot.png")); err != nil {
+		t.Errorf("expected stored file to be archived: %v", err)
+	}
+}
+
+func TestNewRejectsInvalidConfig(t *testing.T) {
+	store := newTestStore(t)
+
+	if _, err := New(store, Config{Dir: t.TempDir(), AfterStore: "shred"}); err == nil {
+		t.Error("expected error for unknown after-store action")
+	}
+	if _, err := New(store, Config{Dir: t.TempDir(), AfterStore: AfterStoreArchive}); err == nil {
+		t.Error("expected error for archiving without an archive directory")
+	}
+	if _, err := New(store, Config{Dir: filepath.Join(t.TempDir(), "missing")}); err == nil {
+		t.Error("expected error for nonexistent directory")
+	}
+}